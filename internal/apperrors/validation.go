@@ -0,0 +1,19 @@
+package apperrors
+
+import "errors"
+
+// ValidationError 数据校验错误, 写入数据库前发现字段不合法
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return "validation error: " + e.Field + ": " + e.Reason
+}
+
+// IsValidationError 判断是否为校验错误
+func IsValidationError(err error) bool {
+	var validErr *ValidationError
+	return errors.As(err, &validErr)
+}
@@ -2,11 +2,18 @@ package core
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"goto-bangumi/internal/conf"
 	"goto-bangumi/internal/database"
 	"goto-bangumi/internal/download"
+	"goto-bangumi/internal/eventbus"
 	"goto-bangumi/internal/logger"
 	"goto-bangumi/internal/model"
 	"goto-bangumi/internal/network"
@@ -15,6 +22,8 @@ import (
 	"goto-bangumi/internal/refresh"
 	"goto-bangumi/internal/rename"
 	"goto-bangumi/internal/scheduler"
+	"goto-bangumi/internal/search"
+	"goto-bangumi/internal/settings"
 	"goto-bangumi/internal/task"
 	"goto-bangumi/internal/taskrunner"
 	"goto-bangumi/internal/taskrunner/handlers"
@@ -27,6 +36,12 @@ type Program struct {
 	cancel     context.CancelFunc
 	db         *database.DB
 	downloader *download.DownloadClient
+	// clients 按路由规则为种子选择下载器, 目前只注册了配置的 downloader
+	clients *download.ClientRegistry
+	refresh    *refresh.Service
+	runner     *taskrunner.TaskRunner
+	// 程序自己启动的后台 goroutine, Stop 时等待它们结束
+	wg sync.WaitGroup
 }
 
 func InitProgram(ctx context.Context) *Program {
@@ -42,54 +57,338 @@ func InitProgram(ctx context.Context) *Program {
 	logger.Init(cfg.Program.DebugEnable)
 
 	// Initialize database
-	db, err := database.NewDB(nil)
+	db, err := database.Connect(cfg.Database, cfg.Program.DataDir)
 	if err != nil {
 		slog.Error("[program] 初始化数据库失败", "error", err)
 		panic(err)
 	}
+	db.SetQueryCache(cfg.Database.QueryCacheSize, time.Duration(cfg.Database.QueryCacheTTL)*time.Second)
+	// 数据库中保存的运行时配置覆盖配置文件中的对应项
+	if err := settings.Init(ctx, db, cfg); err != nil {
+		slog.Error("[program] 加载运行时配置失败", "error", err)
+		panic(err)
+	}
+	proxyConf := settings.Proxy()
+	parserConf := cfg.Parser
+	parserConf.Language = settings.GetTmdbLanguage()
+	renameConf := cfg.Rename
+	renameConf.RenameMethod = settings.GetRenameMethod()
+	downloaderConf := cfg.Downloader
+	downloaderConf.SavePath = settings.GetDownloadPath()
 
 	// Initialize modules with injected config
-	network.Init(&cfg.Proxy)
-	parser.Init(&cfg.Parser)
+	network.Init(&proxyConf,
+		network.WithMikanAuth(network.MikanAuth{
+			Host:   cfg.Parser.MikanCustomURL,
+			Token:  cfg.Parser.MikanToken,
+			Cookie: cfg.Parser.MikanCookie,
+		}),
+		network.WithTimeout(time.Duration(cfg.Program.RequestTimeout)*time.Second),
+		network.WithUserAgent(cfg.Program.UserAgent),
+		network.WithRateLimit(cfg.Program.RateLimit, cfg.Program.RateBurst),
+		network.WithTorznabAuth(network.TorznabAuth{URL: cfg.Parser.TorznabURL, APIKey: cfg.Parser.TorznabAPIKey}),
+	)
+	parser.Init(&parserConf)
+	parser.SetMetadataCache(parser.NewMetadataCache(db, time.Duration(cfg.Parser.MetadataTTLHours)*time.Hour))
 	notification.NotificationClient.Init(&cfg.Notification)
-	rename.Init(&cfg.Rename)
+	rename.Init(&renameConf)
 
 	downloader := download.NewDownloadClient()
-	downloader.Init(&cfg.Downloader)
+	downloader.Init(&downloaderConf)
+	// 运行时修改的配置项立即应用到对应的模块
+	settings.Watch(func(key string) { applySetting(downloader, key) })
+
+	clients := download.NewClientRegistry(downloaderConf.Type)
+	clients.Register(downloaderConf.Type, downloader)
+
+	return &Program{db: db, downloader: downloader, clients: clients}
+}
+
+// applySetting 把运行时修改的配置项应用到正在运行的模块, key 见 settings 包
+func applySetting(downloader *download.DownloadClient, key string) {
+	switch key {
+	case settings.KeyDownloadPath:
+		downloader.SetSavePath(settings.GetDownloadPath())
+	case settings.KeyRenameMethod:
+		rename.SetRenameMethod(settings.GetRenameMethod())
+	case settings.KeyTmdbLanguage:
+		parser.SetTmdbLanguage(settings.GetTmdbLanguage())
+	case settings.KeyProxyURL:
+		proxy := settings.Proxy()
+		network.SetProxyConfig(&proxy)
+	}
+}
+
+// Migrate 只执行数据库迁移后关闭数据库, 不启动其他模块
+// sqlite 数据库在迁移前会自动备份, 见 database.NewDB
+func Migrate(ctx context.Context) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	slog.Info("[program] 数据库迁移完成", "版本", version)
+	return nil
+}
 
-	return &Program{db: db, downloader: downloader}
+// Maintain 立即执行一次数据库维护后关闭数据库, 不启动其他模块, 维护内容见 task.MaintenanceTask
+// 保留时间使用配置文件中的设置, 不受 MaintenanceInterval 是否为 0 的影响
+func Maintain(ctx context.Context) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return task.NewMaintenanceTask(conf.Get().Program, db).Run(ctx)
+}
+
+// CheckDatabase 以只读方式打开数据库, 检查连接和数据库文件是否损坏, 不会写入数据库
+// 可以在程序运行时对同一个数据目录执行; postgres/mysql 只检查连接
+func CheckDatabase(ctx context.Context) error {
+	db, err := openDatabase(database.WithReadOnly())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Ping(ctx); err != nil {
+		return fmt.Errorf("数据库连接失败: %w", err)
+	}
+	problems, err := db.IntegrityCheck(ctx)
+	if errors.Is(err, database.ErrIntegrityUnsupported) {
+		slog.Info("[program] 数据库连接正常, 当前驱动不支持完整性检查")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		slog.Error("[program] 数据库完整性检查发现问题", "问题", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("数据库已损坏, 发现 %d 个问题", len(problems))
+	}
+	slog.Info("[program] 数据库检查通过")
+	return nil
+}
+
+// ExportLibrary 把番剧库导出到 path, 见 database.DB.ExportLibrary
+func ExportLibrary(ctx context.Context, path string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := db.ExportLibrary(ctx, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ImportLibrary 从 path 导入番剧库, 当前的番剧库会被替换, 见 database.DB.ImportLibrary
+// 和迁移一样, sqlite 数据库在导入前先备份到数据目录的 backups 下, 导错文件时可以直接替换回去
+func ImportLibrary(ctx context.Context, path string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	backup := filepath.Join(database.ResolveDataDir(conf.Get().Program.DataDir), "backups", "import-"+time.Now().Format("20060102-150405")+".db")
+	if err := db.Backup(ctx, backup); errors.Is(err, database.ErrBackupUnsupported) {
+		slog.Warn("[program] 当前数据库不支持内置备份, 导入前请自行备份", "error", err)
+	} else if err != nil {
+		return fmt.Errorf("导入前备份数据库失败: %w", err)
+	} else {
+		slog.Info("[program] 导入前已备份数据库", "备份", backup)
+	}
+	return db.ImportLibrary(ctx, f)
+}
+
+// openDatabase 读取配置并打开数据库, 供只操作数据库的命令使用
+func openDatabase(opts ...database.Option) (*database.DB, error) {
+	if err := conf.Init(); err != nil {
+		return nil, err
+	}
+	cfg := conf.Get()
+	logger.Init(cfg.Program.DebugEnable)
+	return database.Connect(cfg.Database, cfg.Program.DataDir, opts...)
 }
 
 func (p *Program) Start(ctx context.Context) {
 	p.ctx, p.cancel = context.WithCancel(ctx)
-	go p.downloader.Login(p.ctx)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.downloader.Login(p.ctx)
+	}()
 
 	// 创建并启动 taskrunner
-	renamer := rename.New(p.db, p.downloader)
-	refresher := refresh.New(p.db)
+	renamer := rename.New(p.db, p.clients)
 	runner := taskrunner.New(4, 5)
-	runner.Register(model.PhaseAdding, handlers.NewAddHandler(p.downloader))                        // 唯一受限阶段（持有流水线槽位）
-	runner.Register(model.PhaseChecking, handlers.NewCheckHandler(p.db, p.downloader))                // 轻量查询
-	runner.Register(model.PhaseDownloading, handlers.NewDownloadingHandler(p.db, p.downloader))       // 轻量轮询
+	p.runner = runner
+	runner.Register(model.PhaseAdding, handlers.NewAddHandler(p.clients))                           // 唯一受限阶段（持有流水线槽位）
+	runner.Register(model.PhaseChecking, handlers.NewCheckHandler(p.db, p.clients))                   // 轻量查询
+	runner.Register(model.PhaseDownloading, handlers.NewDownloadingHandler(p.db, p.clients))          // 轻量轮询
 	runner.Register(model.PhaseRenaming, handlers.NewRenameHandler(p.db, renamer))      // 本地文件操作
+	runner.OnFailed(func(ctx context.Context, task *model.Task) {
+		if err := p.db.RecordDownloadEvent(ctx, task.Torrent.Link, task.Torrent.BangumiID, model.ActionFailed, task.ErrorMsg); err != nil {
+			slog.Warn("[program] 记录下载历史失败", "种子名称", task.Torrent.Name, "error", err)
+		}
+		event := notification.NewTorrentEvent(notification.EventFailure, task.Torrent, task.Bangumi)
+		event.Error = task.ErrorMsg
+		notification.NotificationClient.Notify(ctx, event)
+	})
 	runner.Start(p.ctx)
 
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.removeDeletedDownloads(p.ctx)
+	}()
+
+	// RSS 按每个订阅自己的间隔刷新, 其他定时任务交给调度器
+	programConf := conf.Get().Program
+	p.refresh = refresh.NewService(p.db, runner, refresh.SchedulerOptions{
+		Interval:       time.Duration(programConf.RssTime) * time.Second,
+		Jitter:         float64(programConf.RssJitter) / 100,
+		MaxConcurrency: programConf.RssConcurrency,
+		NotifyFailures: programConf.RssFailureNotify,
+		Backfill:       programConf.Backfill,
+	})
+	p.refresh.SetRemover(p.clients)
+	p.refresh.SetResolveConcurrency(programConf.ResolveConcurrency)
+	p.refresh.SetReleaseWindow(time.Duration(programConf.ReleaseWindow)*time.Minute, time.Duration(programConf.UpgradeGrace)*time.Hour)
+	parserConf := conf.Get().Parser
+	if parserConf.TorznabURL != "" {
+		search.Register(search.TorznabProvider{URL: parserConf.TorznabURL, Categories: parserConf.TorznabCategories})
+	}
+	p.refresh.SetSearchProviders(search.Providers(parserConf.SearchProviders...)...)
+	p.refresh.SetPosterDir(filepath.Join(database.ResolveDataDir(programConf.DataDir), "posters"))
+	p.refresh.Start(p.ctx)
+
 	// 启动调度器
-	InitScheduler(p.ctx, runner, p.db, refresher)
+	InitScheduler(p.ctx, p.db, task.NewGapSearchTask(programConf, p.refresh.Refresher, runner))
+}
+
+// removeDeletedDownloadsInterval 定时重试从下载器删除种子的间隔
+const removeDeletedDownloadsInterval = 10 * time.Minute
+
+// removeDeletedDownloads 彻底删除番剧时要求清理下载的, 把记录的种子和已下载的文件从下载器中删除
+// 启动时、收到 BangumiDeleted 事件时和每隔 removeDeletedDownloadsInterval 处理一次, 事件丢失也不会漏删
+func (p *Program) removeDeletedDownloads(ctx context.Context) {
+	events, unsubscribe := eventbus.Subscribe[database.BangumiDeleted](p.db.Events(), ctx, 16)
+	defer unsubscribe()
+	ticker := time.NewTicker(removeDeletedDownloadsInterval)
+	defer ticker.Stop()
+	p.processDownloadRemovals(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Purged {
+				p.processDownloadRemovals(ctx)
+			}
+		case <-ticker.C:
+			p.processDownloadRemovals(ctx)
+		}
+	}
 }
 
-func (p *Program) Stop() {
-	p.cancel()
-	if p.db != nil {
+// processDownloadRemovals 按番剧从下载器删除待删除的种子, 成功后删除记录, 失败的记录保留等待下次重试
+func (p *Program) processDownloadRemovals(ctx context.Context) {
+	removals, err := p.db.ListDownloadRemovals(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("[program] 获取待删除的种子失败", "error", err)
+		}
+		return
+	}
+	var order []int
+	byBangumi := make(map[int][]*model.DownloadRemoval)
+	for _, r := range removals {
+		if _, ok := byBangumi[r.BangumiID]; !ok {
+			order = append(order, r.BangumiID)
+		}
+		byBangumi[r.BangumiID] = append(byBangumi[r.BangumiID], r)
+	}
+	for _, id := range order {
+		group := byBangumi[id]
+		uids := make([]string, len(group))
+		torrents := make([]*model.Torrent, len(group))
+		for i, r := range group {
+			uids[i] = r.DownloadUID
+			torrents[i] = &model.Torrent{DownloadUID: r.DownloadUID, Downloader: r.Downloader}
+		}
+		title := group[0].Title
+		if err := p.clients.DeleteTorrents(ctx, torrents); err != nil {
+			slog.Error("[program] 从下载器删除番剧的种子失败", "番剧", title, "数量", len(uids), "error", err)
+			if err := p.db.RecordDownloadRemovalFailure(ctx, uids, err); err != nil {
+				slog.Warn("[program] 记录删除种子失败失败", "番剧", title, "error", err)
+			}
+			continue
+		}
+		if err := p.db.DeleteDownloadRemovals(ctx, uids); err != nil {
+			slog.Warn("[program] 删除待删除种子记录失败", "番剧", title, "error", err)
+		}
+		slog.Info("[program] 已从下载器删除番剧的种子", "番剧", title, "数量", len(uids))
+	}
+}
+
+// Stop 停止所有模块: 先停止调度新的刷新并等待正在进行的刷新和后台任务结束, 再取消其余模块, 最后关闭数据库
+// ctx 结束时取消还在进行的刷新; 这时可能仍有刷新在使用数据库, 不关闭数据库, 交给进程退出处理
+func (p *Program) Stop(ctx context.Context) {
+	drained := true
+	if p.refresh != nil {
+		if err := p.refresh.Stop(ctx); err != nil {
+			slog.Error("[program] 停止刷新服务失败", "error", err)
+			drained = ctx.Err() == nil
+		}
+	}
+	if s := scheduler.GetScheduler(); s != nil {
+		s.Stop()
+	}
+	if p.cancel != nil {
+		p.cancel()
+	}
+	if p.runner != nil {
+		p.runner.Stop()
+	}
+	p.wg.Wait()
+	// 等待队列中的 webhook 发送完
+	notification.NotificationClient.Close()
+	if p.db != nil && drained {
 		if err := p.db.Close(); err != nil {
 			slog.Error("[program] 关闭数据库失败", "error", err)
 		}
+	} else if p.db != nil {
+		slog.Warn("[program] 还有刷新任务没有结束, 不关闭数据库")
 	}
 	slog.Info("程序已停止")
 }
 
-// InitScheduler 初始化并启动调度器
-func InitScheduler(ctx context.Context, runner *taskrunner.TaskRunner, db *database.DB, refresher *refresh.Refresher) {
+// InitScheduler 初始化并启动调度器, tasks 为依赖其他模块的任务
+func InitScheduler(ctx context.Context, db *database.DB, tasks ...scheduler.Task) {
 	scheduler.InitScheduler(ctx)
 
 	s := scheduler.GetScheduler()
@@ -98,7 +397,12 @@ func InitScheduler(ctx context.Context, runner *taskrunner.TaskRunner, db *datab
 		return
 	}
 
-	s.AddTask(task.NewRSSRefreshTask(conf.Get().Program, runner, db, refresher))
+	s.AddTask(task.NewTrashPurgeTask(conf.Get().Program, db))
+	s.AddTask(task.NewBackupTask(conf.Get().Program, db))
+	s.AddTask(task.NewMaintenanceTask(conf.Get().Program, db))
+	for _, t := range tasks {
+		s.AddTask(t)
+	}
 
 	s.Start()
 
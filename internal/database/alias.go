@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"goto-bangumi/internal/apperrors"
+	"goto-bangumi/internal/model"
+)
+
+// ============ 番剧别名 ============
+
+// minAliasRunes 别名的最短长度, 别名按子串匹配种子名, 太短会匹配到无关的种子
+const minAliasRunes = 2
+
+// AddBangumiAlias 为番剧添加别名, 番剧不存在时返回 ErrNotFound, 别名已经存在时返回 ErrDuplicate
+// 同一个别名不能属于不同的番剧, 否则匹配时无法区分, 返回校验错误
+func (db *DB) AddBangumiAlias(ctx context.Context, bangumiID int, title string) (*model.BangumiAlias, error) {
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) < minAliasRunes {
+		return nil, &apperrors.ValidationError{Field: "Title", Reason: fmt.Sprintf("别名至少需要 %d 个字符", minAliasRunes)}
+	}
+	alias := &model.BangumiAlias{BangumiID: bangumiID, Title: title}
+	err := db.Transaction(ctx, func(tx *DB) error {
+		if err := tx.WithContext(ctx).Select("id").First(&model.Bangumi{}, bangumiID).Error; err != nil {
+			return err
+		}
+		var other model.BangumiAlias
+		err := tx.WithContext(ctx).Where("title = ? AND bangumi_id <> ?", title, bangumiID).Limit(1).Find(&other).Error
+		if err != nil {
+			return err
+		}
+		if other.ID != 0 {
+			return &apperrors.ValidationError{
+				Field:  "Title",
+				Reason: fmt.Sprintf("别名 %s 已经属于番剧 %d", title, other.BangumiID),
+			}
+		}
+		return tx.WithContext(ctx).Create(alias).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("[database] 添加番剧别名", "番剧", bangumiID, "别名", title)
+	return alias, nil
+}
+
+// ListBangumiAliases 返回番剧的所有别名, 按添加顺序排列
+func (db *DB) ListBangumiAliases(ctx context.Context, bangumiID int) ([]*model.BangumiAlias, error) {
+	var aliases []*model.BangumiAlias
+	err := db.WithContext(ctx).Where("bangumi_id = ?", bangumiID).Order("id").Find(&aliases).Error
+	return aliases, err
+}
+
+// UpdateBangumiAlias 修改别名的标题, 校验规则和 AddBangumiAlias 相同, 别名不存在时返回 ErrNotFound
+func (db *DB) UpdateBangumiAlias(ctx context.Context, id int, title string) error {
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) < minAliasRunes {
+		return &apperrors.ValidationError{Field: "Title", Reason: fmt.Sprintf("别名至少需要 %d 个字符", minAliasRunes)}
+	}
+	return db.Transaction(ctx, func(tx *DB) error {
+		var alias model.BangumiAlias
+		if err := tx.WithContext(ctx).First(&alias, id).Error; err != nil {
+			return err
+		}
+		var count int64
+		err := tx.WithContext(ctx).Model(&model.BangumiAlias{}).
+			Where("title = ? AND bangumi_id <> ?", title, alias.BangumiID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return &apperrors.ValidationError{Field: "Title", Reason: fmt.Sprintf("别名 %s 已经属于其他番剧", title)}
+		}
+		return tx.WithContext(ctx).Model(&alias).Update("title", title).Error
+	})
+}
+
+// DeleteBangumiAlias 删除别名, 别名不存在时返回 ErrNotFound
+func (db *DB) DeleteBangumiAlias(ctx context.Context, id int) error {
+	result := db.WithContext(ctx).Delete(&model.BangumiAlias{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
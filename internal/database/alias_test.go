@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/apperrors"
+)
+
+func TestBangumiAlias(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t, "testdata/fixtures.yaml")
+
+	name := "[Nekomoe kissaten] Frieren - 03 [1080p]"
+	if _, err := db.GetBangumiParseByTitle(ctx, name); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetBangumiParseByTitle() before alias error = %v, want ErrNotFound", err)
+	}
+	alias, err := db.AddBangumiAlias(ctx, 1, " Frieren ")
+	if err != nil {
+		t.Fatalf("AddBangumiAlias() error = %v", err)
+	}
+	if alias.Title != "Frieren" {
+		t.Errorf("alias title = %q, want trimmed", alias.Title)
+	}
+	// 别名不限字幕组, 写入后缓存失效, 可以直接匹配到
+	bangumi, err := db.GetBangumiParseByTitle(ctx, name)
+	if err != nil || bangumi.ID != 1 {
+		t.Fatalf("GetBangumiParseByTitle() = %+v, %v, want bangumi 1", bangumi, err)
+	}
+	// 更长的解析信息标题得分更高
+	bangumi, err = db.GetBangumiParseByTitle(ctx, "[LoliHouse] Sousou no Frieren - 03 [1080p]")
+	if err != nil || bangumi.ID != 1 {
+		t.Errorf("GetBangumiParseByTitle() metadata = %+v, %v", bangumi, err)
+	}
+
+	if _, err := db.AddBangumiAlias(ctx, 1, "Frieren"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("AddBangumiAlias() duplicate error = %v, want ErrDuplicate", err)
+	}
+	if _, err := db.AddBangumiAlias(ctx, 2, "Frieren"); !apperrors.IsValidationError(err) {
+		t.Errorf("AddBangumiAlias() other bangumi error = %v, want validation error", err)
+	}
+	if _, err := db.AddBangumiAlias(ctx, 1, "F"); !apperrors.IsValidationError(err) {
+		t.Errorf("AddBangumiAlias() short title error = %v, want validation error", err)
+	}
+	if _, err := db.AddBangumiAlias(ctx, 99, "Frieren S2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddBangumiAlias() missing bangumi error = %v, want ErrNotFound", err)
+	}
+
+	if err := db.UpdateBangumiAlias(ctx, alias.ID, "Sousou Frieren"); err != nil {
+		t.Fatalf("UpdateBangumiAlias() error = %v", err)
+	}
+	aliases, err := db.ListBangumiAliases(ctx, 1)
+	if err != nil || len(aliases) != 1 || aliases[0].Title != "Sousou Frieren" {
+		t.Errorf("ListBangumiAliases() = %+v, %v", aliases, err)
+	}
+	if err := db.DeleteBangumiAlias(ctx, alias.ID); err != nil {
+		t.Fatalf("DeleteBangumiAlias() error = %v", err)
+	}
+	if err := db.DeleteBangumiAlias(ctx, alias.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteBangumiAlias() again error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ============ 备份和恢复 ============
+
+// ErrBackupUnsupported 当前数据库驱动不支持内置的备份和恢复, postgres/mysql 请使用数据库自带的工具
+var ErrBackupUnsupported = errors.New("只有 sqlite 支持备份和恢复")
+
+// snapshotPrefix 定时快照的文件名前缀, 清理旧快照时只处理这个前缀的文件
+const snapshotPrefix = "data-"
+
+// vacuumInto 用 VACUUM INTO 把数据库完整复制到 path
+// VACUUM INTO 在一个读事务中生成副本, 不受 WAL 和其他连接的影响, 服务运行时也能得到一致的副本
+// 先写到同目录的临时文件再改名, 中途失败不会留下不完整的备份
+func vacuumInto(db *gorm.DB, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	// VACUUM INTO 要求目标文件不存在
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := db.Exec("VACUUM INTO ?", tmp).Error; err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Backup 把数据库备份到 path, 已存在的文件会被覆盖
+func (db *DB) Backup(ctx context.Context, path string) error {
+	if db.Dialector.Name() != DriverSQLite {
+		return ErrBackupUnsupported
+	}
+	if err := vacuumInto(db.WithContext(ctx), path); err != nil {
+		return fmt.Errorf("备份数据库到 %s 失败: %w", path, err)
+	}
+	slog.Info("[database] 备份数据库", "path", path)
+	return nil
+}
+
+// Restore 用 path 的备份替换当前数据库的全部数据, 服务运行时也可以恢复
+// 备份先做完整性检查, 然后在一个事务中逐表清空并从备份复制, 失败时保持原样.
+// 只复制两边都有的表和列, 备份中没有的表(旧版本之后新增的)会被清空, 旧版本的备份恢复后会补上缺少的数据迁移
+func (db *DB) Restore(ctx context.Context, path string) error {
+	if db.Dialector.Name() != DriverSQLite {
+		return ErrBackupUnsupported
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("读取备份 %s 失败: %w", path, err)
+	}
+
+	// ATTACH 只对当前连接有效, 且不能在事务中执行, 整个过程固定使用同一个连接
+	err := db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("ATTACH DATABASE ? AS backup", path).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("DETACH DATABASE backup")
+
+		var check string
+		if err := conn.Raw("PRAGMA backup.integrity_check").Scan(&check).Error; err != nil {
+			return err
+		}
+		if check != "ok" {
+			return fmt.Errorf("备份文件已损坏: %s", check)
+		}
+		tables, err := backupTables(conn)
+		if err != nil {
+			return err
+		}
+		restorable := 0
+		for _, columns := range tables {
+			if len(columns) > 0 {
+				restorable++
+			}
+		}
+		if restorable == 0 {
+			return errors.New("备份中没有可恢复的表")
+		}
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			// 恢复过程中外键约束暂时失效, 各表的顺序无关紧要
+			if err := tx.Exec("PRAGMA defer_foreign_keys = ON").Error; err != nil {
+				return err
+			}
+			for table, columns := range tables {
+				if err := tx.Exec("DELETE FROM main." + tx.Statement.Quote(table)).Error; err != nil {
+					return err
+				}
+				// 备份中没有这张表, 清空即可, 不能留下引用恢复前数据的记录
+				if len(columns) == 0 {
+					continue
+				}
+				quoted := make([]string, len(columns))
+				for i, c := range columns {
+					quoted[i] = tx.Statement.Quote(c)
+				}
+				cols := strings.Join(quoted, ", ")
+				if err := tx.Exec(fmt.Sprintf("INSERT INTO main.%s (%s) SELECT %s FROM backup.%s",
+					tx.Statement.Quote(table), cols, cols, tx.Statement.Quote(table))).Error; err != nil {
+					return fmt.Errorf("恢复表 %s 失败: %w", table, err)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("从 %s 恢复数据库失败: %w", path, err)
+	}
+	// 恢复没有经过 DB.Transaction, 提交后手动清空查询缓存
+	if db.cache != nil {
+		db.cache.invalidate("")
+	}
+	if err := runMigrations(db.WithContext(ctx), migrations); err != nil {
+		return err
+	}
+	slog.Info("[database] 从备份恢复数据库", "path", path)
+	return nil
+}
+
+// backupTables 返回当前库的所有表, 以及每张表在备份中也有的列; 备份中没有的表列为空
+func backupTables(conn *gorm.DB) (map[string][]string, error) {
+	var names []string
+	err := conn.Raw("SELECT name FROM main.sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'").
+		Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[string][]string, len(names))
+	for _, name := range names {
+		var mainCols, backupCols []string
+		if err := conn.Raw("SELECT name FROM pragma_table_info(?, 'main')", name).Scan(&mainCols).Error; err != nil {
+			return nil, err
+		}
+		if err := conn.Raw("SELECT name FROM pragma_table_info(?, 'backup')", name).Scan(&backupCols).Error; err != nil {
+			return nil, err
+		}
+		var columns []string
+		for _, c := range mainCols {
+			if slices.Contains(backupCols, c) {
+				columns = append(columns, c)
+			}
+		}
+		tables[name] = columns
+	}
+	return tables, nil
+}
+
+// Snapshot 在 dir 下生成一个带时间的快照, 只保留最新的 keep 个, keep <= 0 时不清理
+// 返回新快照的路径
+func (db *DB) Snapshot(ctx context.Context, dir string, keep int) (string, error) {
+	path := filepath.Join(dir, snapshotPrefix+time.Now().Format("20060102-150405")+".db")
+	if err := db.Backup(ctx, path); err != nil {
+		return "", err
+	}
+	if keep <= 0 {
+		return path, nil
+	}
+
+	snapshots, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*.db"))
+	if err != nil {
+		return path, err
+	}
+	// 文件名中的时间可以直接按字符串排序
+	slices.Sort(snapshots)
+	for _, old := range snapshots[:max(len(snapshots)-keep, 0)] {
+		if err := os.Remove(old); err != nil {
+			slog.Warn("[database] 删除旧快照失败", "path", old, "error", err)
+			continue
+		}
+		slog.Debug("[database] 删除旧快照", "path", old)
+	}
+	return path, nil
+}
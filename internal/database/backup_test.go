@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"goto-bangumi/internal/model"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.db")
+	db, err := NewDB(&path)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: "https://mikanani.me/Download/1.torrent", Name: "败犬 01", BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := filepath.Join(dir, "backups", "manual.db")
+	if err := db.Backup(ctx, backup); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+
+	// 备份之后的修改在恢复后消失
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: "https://mikanani.me/Download/2.torrent", Name: "败犬 02", BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteBangumi(ctx, bangumi.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Restore(ctx, backup); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	bangumis, err := db.ListBangumi(ctx)
+	if err != nil || len(bangumis) != 1 || bangumis[0].OfficialTitle != "败犬女主太多了！" {
+		t.Fatalf("ListBangumi() after restore = %v, %v", bangumis, err)
+	}
+	torrents, err := db.ListTorrent(ctx)
+	if err != nil || len(torrents) != 1 || torrents[0].Name != "败犬 01" {
+		t.Fatalf("ListTorrent() after restore = %d torrents, %v, want only the backed up one", len(torrents), err)
+	}
+	version, err := db.SchemaVersion(ctx)
+	if err != nil || version != migrations[len(migrations)-1].Version {
+		t.Errorf("SchemaVersion() after restore = %d, %v", version, err)
+	}
+
+	// 损坏的备份不会改动当前数据
+	broken := filepath.Join(dir, "broken.db")
+	if err := os.WriteFile(broken, []byte("not a database"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Restore(ctx, broken); err == nil {
+		t.Fatal("Restore() from broken file error = nil")
+	}
+	if err := db.Restore(ctx, filepath.Join(dir, "missing.db")); err == nil {
+		t.Fatal("Restore() from missing file error = nil")
+	}
+	if torrents, _ := db.ListTorrent(ctx); len(torrents) != 1 {
+		t.Errorf("torrents after failed restore = %d, want 1", len(torrents))
+	}
+}
+
+// TestRestoreOlderBackup 旧版本的备份缺少后来新增的表, 恢复后这些表被清空, 查询缓存也不会留下恢复前的结果
+func TestRestoreOlderBackup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.db")
+	db, err := NewDB(&path)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	db.SetQueryCache(100, 0)
+
+	backup := filepath.Join(dir, "backups", "old.db")
+	if err := db.Backup(ctx, backup); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	old, err := gorm.Open(sqlite.Open(backup), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Exec("DROP TABLE pending_torrents").Error; err != nil {
+		t.Fatal(err)
+	}
+	if sqlDB, err := old.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	link := "https://mikanani.me/Download/1.torrent"
+	if err := db.AddPendingTorrents(ctx, []*model.PendingTorrent{{Link: link, Name: "败犬 01"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: link, Name: "败犬 01"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetTorrentByURL(ctx, link); err != nil {
+		t.Fatalf("GetTorrentByURL() before restore error = %v", err)
+	}
+
+	if err := db.Restore(ctx, backup); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if pending, err := db.ListUnmatchedTorrents(ctx, 0); err != nil || len(pending) != 0 {
+		t.Errorf("ListUnmatchedTorrents() after restore = %d, %v, want 0", len(pending), err)
+	}
+	if _, err := db.GetTorrentByURL(ctx, link); err == nil {
+		t.Error("GetTorrentByURL() after restore returned the torrent added after the backup")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	// 已有的旧快照, 文件名中的时间更早
+	for _, name := range []string{"data-20240101-000000.db", "data-20240102-000000.db", "other.db"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := db.Snapshot(ctx, dir, 2)
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("snapshot missing: %v", err)
+	}
+	snapshots, _ := filepath.Glob(filepath.Join(dir, "data-*.db"))
+	if len(snapshots) != 2 || snapshots[0] != filepath.Join(dir, "data-20240102-000000.db") || snapshots[1] != path {
+		t.Errorf("snapshots = %v, want the newest two", snapshots)
+	}
+	// 不是快照的文件不会被清理
+	if _, err := os.Stat(filepath.Join(dir, "other.db")); err != nil {
+		t.Errorf("other.db removed: %v", err)
+	}
+}
+
+func TestBackupUnsupportedDriver(t *testing.T) {
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("gorm.Open failed: %v", err)
+	}
+	db := &DB{DB: gormDB}
+	if err := db.Backup(context.Background(), filepath.Join(t.TempDir(), "pg.db")); !errors.Is(err, ErrBackupUnsupported) {
+		t.Errorf("Backup() on postgres error = %v, want ErrBackupUnsupported", err)
+	}
+}
@@ -1,109 +1,867 @@
 package database
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
+	"goto-bangumi/internal/apperrors"
 	"goto-bangumi/internal/model"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ============ Bangumi 相关方法 ============
 
-// 用于防止并发创建相同 Bangumi 的互斥锁
+// 用于防止并发创建相同 Bangumi 的互斥锁, 只在进程内有效
+// 多个进程共用数据库时由 CreateBangumi 的事务保证查重和写入的原子性
 var bangumiCreateMutex sync.Mutex
 
 // CreateBangumi 创建番剧
-func (db *DB) CreateBangumi(bangumi *model.Bangumi) error {
+// 按 mikan_id、tmdb_id+季度查找映射表(见 model.MikanMapping), 都没有时再找同一个 tmdb_id 的番剧(见 bangumiByTmdbID),
+// 新的一季作为它的季度加入; 已存在时补充 mikan/tmdb 信息、追加 EpisodeMetadata 并确保有这一季,
+// 不存在时创建新番剧; 之后把这些 ID 映射到番剧上. 为 0 的 ID 不参与查重.
+// 查重和写入在同一个事务中完成; 合并到已有番剧时 bangumi 会被替换为合并后的番剧
+func (db *DB) CreateBangumi(ctx context.Context, bangumi *model.Bangumi) error {
+	_, err := db.CreateOrMergeBangumi(ctx, bangumi)
+	return err
+}
+
+// CreateOrMergeBangumi 同 CreateBangumi, created 表示是否创建了新番剧, 为 false 时合并到了已有番剧
+func (db *DB) CreateOrMergeBangumi(ctx context.Context, bangumi *model.Bangumi) (created bool, err error) {
 	slog.Info("[database] 创建番剧", "标题", bangumi.OfficialTitle, "MikanID", bangumi.MikanID, "TmdbID", bangumi.TmdbID)
 	// 加锁防止并发创建重复的 Bangumi
 	bangumiCreateMutex.Lock()
 	defer bangumiCreateMutex.Unlock()
 
-	// 对于 Bangumi 要进行一个查重, 主要是看其对应的 mikanid 和 tmdbid
-	// 先是看 mikanid 有的话
-	var oldBangumi model.Bangumi
-	var tmdbID int
-	if bangumi.TmdbID != nil {
-		tmdbID = *bangumi.TmdbID
-	} else if bangumi.TmdbItem != nil {
-		tmdbID = bangumi.TmdbItem.ID
-	}
-	var mikanID int
-	if bangumi.MikanID != nil {
-		mikanID = *bangumi.MikanID
-	} else if bangumi.MikanItem != nil {
-		mikanID = bangumi.MikanItem.ID
-	}
-	// 通过 mikanID 和 tmdbID 来查找 Bangumi
-	// err := db.Where("mikan_id = ? AND tmdb_id = ?", mikanID, tmdbID).First(&oldBangumi).Error
-	err := db.Preload("MikanItem").
-		Preload("TmdbItem").
-		Preload("EpisodeMetadata").
-		Where("mikan_id = ?", mikanID).
-		Or("tmdb_id = ?", tmdbID).First(&oldBangumi).Error
-	if err != nil && err != gorm.ErrRecordNotFound {
-		slog.Info("[database] 查找番剧时出错", "错误", err)
-		return err
+	keys := bangumiKeys(bangumi)
+	err = db.Transaction(ctx, func(tx *DB) error {
+		if err := tx.upsertBangumiItems(ctx, bangumi); err != nil {
+			return err
+		}
+		uid, err := tx.resolveBangumiUID(ctx, keys)
+		if errors.Is(err, ErrNotFound) && keys.TmdbID != 0 {
+			uid, err = tx.bangumiByTmdbID(ctx, keys.TmdbID)
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			slog.Info("[database] 查找番剧时出错", "错误", err)
+			return err
+		}
+		if uid != 0 {
+			var oldBangumi model.Bangumi
+			if err := tx.WithContext(ctx).Preload("MikanItem").
+				Preload("TmdbItem").
+				Preload("EpisodeMetadata").
+				First(&oldBangumi, uid).Error; err != nil {
+				return err
+			}
+			// 找到的话就更新一下 mikan, tmdb
+			slog.Debug("[database] 番剧已存在，进行更新", "标题", oldBangumi.OfficialTitle)
+			if oldBangumi.MikanID == nil && bangumi.MikanItem != nil {
+				oldBangumi.MikanItem = bangumi.MikanItem
+			}
+			if oldBangumi.TmdbID == nil && bangumi.TmdbItem != nil {
+				oldBangumi.TmdbItem = bangumi.TmdbItem
+			}
+			oldBangumi.Version++
+			if err := tx.WithContext(ctx).Omit("EpisodeMetadata").Save(&oldBangumi).Error; err != nil {
+				return err
+			}
+			tx.appendEpisodeMetadata(ctx, &oldBangumi, bangumi.EpisodeMetadata)
+			if err := tx.syncSeasons(ctx, oldBangumi.ID); err != nil {
+				return err
+			}
+			// 解析信息都已存在而没有追加时, syncSeasons 不会知道新的一季
+			if _, err := tx.ensureSeason(ctx, oldBangumi.ID, bangumi.Season); err != nil {
+				return err
+			}
+			if err := tx.bindBangumiKeys(ctx, oldBangumi.ID, keys, oldBangumi.RSSLink); err != nil {
+				return err
+			}
+			*bangumi = oldBangumi
+			return nil
+		}
+		slog.Info("[database] 番剧不存在，创建新记录", "标题", bangumi.OfficialTitle)
+		if err := tx.WithContext(ctx).Save(bangumi).Error; err != nil {
+			return err
+		}
+		if err := tx.syncSeasons(ctx, bangumi.ID); err != nil {
+			return err
+		}
+		if err := tx.bindBangumiKeys(ctx, bangumi.ID, keys, bangumi.RSSLink); err != nil {
+			return err
+		}
+		tx.publish(BangumiCreated{Bangumi: *bangumi})
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// metadataKey EpisodeMetadata 去重用的标识, 忽略首尾空白和大小写
+func metadataKey(e model.EpisodeMetadata) string {
+	return strings.ToLower(e.Key())
+}
+
+// appendEpisodeMetadata 为已有番剧逐条追加不存在的 EpisodeMetadata
+// 每条单独写入, 某一条校验失败或触发唯一约束时跳过, 不影响其他条目和番剧本身的更新
+// 在事务中调用时每条写入使用一个 savepoint, postgres 的事务中有语句失败后不回滚到 savepoint 就无法继续
+func (db *DB) appendEpisodeMetadata(ctx context.Context, bangumi *model.Bangumi, metadata []model.EpisodeMetadata) {
+	existingKeys := make(map[string]struct{}, len(bangumi.EpisodeMetadata))
+	for _, e := range bangumi.EpisodeMetadata {
+		existingKeys[metadataKey(e)] = struct{}{}
+	}
+	for _, e := range metadata {
+		if err := e.Validate(); err != nil {
+			slog.Warn("[database] 跳过无效的解析信息", "番剧", bangumi.OfficialTitle, "error", err)
+			continue
+		}
+		key := metadataKey(e)
+		if _, ok := existingKeys[key]; ok {
+			continue
+		}
+		e.ID = 0
+		e.BangumiID = bangumi.ID
+		// 在事务中时 gorm 的嵌套事务即为 savepoint, 失败时只回滚这一条
+		err := db.WithContext(ctx).Transaction(func(sp *gorm.DB) error {
+			return sp.Create(&e).Error
+		})
+		if err != nil {
+			if errors.Is(err, ErrDuplicate) {
+				slog.Debug("[database] 解析信息已存在, 跳过", "番剧", bangumi.OfficialTitle, "标题", e.Title, "字幕组", e.Group)
+			} else {
+				slog.Warn("[database] 追加解析信息失败", "番剧", bangumi.OfficialTitle, "标题", e.Title, "error", err)
+			}
+			continue
+		}
+		existingKeys[key] = struct{}{}
+		bangumi.EpisodeMetadata = append(bangumi.EpisodeMetadata, e)
+	}
+}
+
+// UpdateBangumi 更新番剧的全部字段, 以 Version 做乐观锁
+// 读取之后番剧被其他地方修改过时返回 ErrConflict, 不会覆盖别人的修改, 番剧不存在时返回 ErrNotFound.
+// 成功后 bangumi.Version 加一, 可以继续用它更新
+func (db *DB) UpdateBangumi(ctx context.Context, bangumi *model.Bangumi) error {
+	version := bangumi.Version
+	bangumi.Version++
+	// 关联的 mikan/tmdb 等会先于番剧写入, 冲突时在事务中一起回滚
+	err := db.Transaction(ctx, func(tx *DB) error {
+		result := tx.WithContext(ctx).Model(bangumi).Where("version = ?", version).Select("*").Updates(bangumi)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+		var count int64
+		if err := tx.WithContext(ctx).Model(&model.Bangumi{}).Where("id = ?", bangumi.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+		return ErrConflict
+	})
+	if err != nil {
+		bangumi.Version = version
+	}
+	return err
+}
+
+// bumpBangumiVersion 按字段更新番剧(Update/Updates 传 map)时同时增加版本号,
+// 让之前读取番剧的 UpdateBangumi 能检测到修改; 整行保存的地方自己维护 Version
+func bumpBangumiVersion(tx *gorm.DB) {
+	if tx.Statement.Schema == nil || tx.Statement.Schema.Table != "bangumis" {
+		return
 	}
-	if oldBangumi.ID != 0 {
-		// 找到的话就更新一下 mikan, tmdb
-		slog.Debug("[database] 番剧已存在，进行更新", "标题", oldBangumi.OfficialTitle)
-		if oldBangumi.MikanID == nil && bangumi.MikanItem != nil {
-			oldBangumi.MikanItem = bangumi.MikanItem
+	if _, ok := tx.Statement.Dest.(map[string]any); ok {
+		tx.Statement.SetColumn("version", gorm.Expr("version + 1"))
+	}
+}
+
+// DeleteBangumi 把番剧移入回收站, 只标记为已删除, 种子和解析信息都保留
+// 可以用 RestoreBangumi 恢复, 超过保留期后由 PurgeDeletedBangumi 彻底删除
+func (db *DB) DeleteBangumi(ctx context.Context, id int) error {
+	result := db.WithContext(ctx).Model(&model.Bangumi{}).Where("id = ? AND deleted = ?", id, false).
+		Updates(map[string]any{"deleted": true, "deleted_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteBangumiOptions DeleteBangumiDeep 的选项
+type DeleteBangumiOptions struct {
+	// Purge 为 true 时立即彻底删除番剧以及它的种子、解析信息、剧集、季度、别名和外部 ID 映射, 下载历史保留;
+	// 为 false 时和 DeleteBangumi 一样移入回收站, 关联的数据都保留, 可以恢复
+	Purge bool
+	// RemoveDownloads 为 true 且彻底删除时把种子记录为待从下载器删除, 见 ListDownloadRemovals
+	// 下载器删除种子时会一起删除文件, 所以只移入回收站时忽略这个选项, 文件保留
+	RemoveDownloads bool
+}
+
+// DeleteBangumiDeep 删除番剧和它的关联数据, 在一个事务中完成, 提交后发布 BangumiDeleted 事件
+// 番剧不存在时返回 ErrNotFound; 已经在回收站中的番剧也可以彻底删除
+func (db *DB) DeleteBangumiDeep(ctx context.Context, id int, opts DeleteBangumiOptions) error {
+	return db.Transaction(ctx, func(tx *DB) error {
+		var bangumi model.Bangumi
+		if err := tx.WithContext(ctx).First(&bangumi, id).Error; err != nil {
+			return err
+		}
+		event := BangumiDeleted{Bangumi: bangumi, Purged: opts.Purge}
+
+		if !opts.Purge {
+			if !bangumi.Deleted {
+				err := tx.WithContext(ctx).Model(&model.Bangumi{}).Where("id = ?", id).
+					Updates(map[string]any{"deleted": true, "deleted_at": time.Now()}).Error
+				if err != nil {
+					return err
+				}
+			}
+			tx.publish(event)
+			return nil
+		}
+
+		var torrents []*model.Torrent
+		if err := tx.WithContext(ctx).Where("bangumi_id = ?", id).Find(&torrents).Error; err != nil {
+			return err
 		}
-		if oldBangumi.TmdbID == nil && bangumi.TmdbItem != nil {
-			oldBangumi.TmdbItem = bangumi.TmdbItem
+		var removals []*model.DownloadRemoval
+		if opts.RemoveDownloads {
+			for _, t := range torrents {
+				if t.DownloadUID != "" {
+					removals = append(removals, &model.DownloadRemoval{DownloadUID: t.DownloadUID, BangumiID: id, Title: bangumi.OfficialTitle, Downloader: t.Downloader})
+				}
+			}
 		}
-		// 只追加不存在的 EpisodeMetadata
-		existingKeys := make(map[string]struct{}, len(oldBangumi.EpisodeMetadata))
-		for _, e := range oldBangumi.EpisodeMetadata {
-			existingKeys[e.Key()] = struct{}{}
+		if len(removals) > 0 {
+			if err := tx.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(removals).Error; err != nil {
+				return err
+			}
 		}
-		for _, e := range bangumi.EpisodeMetadata {
-			if _, ok := existingKeys[e.Key()]; !ok {
-				oldBangumi.EpisodeMetadata = append(oldBangumi.EpisodeMetadata, e)
+
+		for _, table := range append([]any{&model.Torrent{}, &model.EpisodeMetadata{}, &model.Episode{}, &model.BangumiAlias{}, &model.Season{}}, mappingTables...) {
+			if err := tx.WithContext(ctx).Where("bangumi_id = ?", id).Delete(table).Error; err != nil {
+				return err
 			}
 		}
-		return db.Save(&oldBangumi).Error
+		if err := tx.WithContext(ctx).Delete(&model.Bangumi{}, id).Error; err != nil {
+			return err
+		}
+		for _, t := range torrents {
+			tx.recordDownloadEvent(ctx, t.Link, id, model.ActionDeleted, "番剧已删除")
+		}
+		slog.Info("[database] 彻底删除番剧", "标题", bangumi.OfficialTitle, "种子", len(torrents), "清理下载", len(removals))
+		tx.publish(event)
+		return nil
+	})
+}
+
+// ListDownloadRemovals 获取待从下载器删除的种子, 先记录的在前
+func (db *DB) ListDownloadRemovals(ctx context.Context) ([]*model.DownloadRemoval, error) {
+	var removals []*model.DownloadRemoval
+	err := db.WithContext(ctx).Order("created_at").Find(&removals).Error
+	return removals, err
+}
+
+// DeleteDownloadRemovals 从下载器删除成功后删除记录
+func (db *DB) DeleteDownloadRemovals(ctx context.Context, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Where("download_uid IN ?", uids).Delete(&model.DownloadRemoval{}).Error
+}
+
+// RecordDownloadRemovalFailure 记录一次从下载器删除失败, 记录保留等待下次重试
+func (db *DB) RecordDownloadRemovalFailure(ctx context.Context, uids []string, cause error) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Model(&model.DownloadRemoval{}).Where("download_uid IN ?", uids).Updates(map[string]any{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": cause.Error(),
+	}).Error
+}
+
+// RestoreBangumi 从回收站恢复番剧
+func (db *DB) RestoreBangumi(ctx context.Context, id int) error {
+	result := db.WithContext(ctx).Model(&model.Bangumi{}).Where("id = ? AND deleted = ?", id, true).
+		Updates(map[string]any{"deleted": false, "deleted_at": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListDeletedBangumi 获取回收站中的番剧, 最近删除的在前
+func (db *DB) ListDeletedBangumi(ctx context.Context) ([]*model.Bangumi, error) {
+	var bangumis []*model.Bangumi
+	err := db.WithContext(ctx).Where("deleted = ?", true).
+		Order("deleted_at DESC").Order("id DESC").
+		Find(&bangumis).Error
+	return bangumis, err
+}
+
+// PurgeDeletedBangumi 彻底删除在 before 之前移入回收站的番剧, 以及它们的种子和解析信息
+// 没有删除时间的旧数据(例如合并后留下的番剧)视为已经过了保留期.
+// 删除后顺带清理其他孤儿数据(见 CleanupOrphans), 返回删除的番剧数量和清理结果
+func (db *DB) PurgeDeletedBangumi(ctx context.Context, before time.Time) (int64, CleanupReport, error) {
+	var purged int64
+	var report CleanupReport
+	err := db.Transaction(ctx, func(tx *DB) error {
+		result := tx.WithContext(ctx).
+			Where("deleted = ? AND (deleted_at IS NULL OR deleted_at < ?)", true, before).
+			Delete(&model.Bangumi{})
+		if result.Error != nil {
+			return result.Error
+		}
+		purged = result.RowsAffected
+		if purged == 0 {
+			return nil
+		}
+		var err error
+		report, err = tx.CleanupOrphans(ctx, true)
+		return err
+	})
+	if err != nil {
+		return 0, CleanupReport{}, err
+	}
+	if purged > 0 {
+		slog.Info("[database] 清空回收站", "番剧", purged, "种子", report.Torrents, "解析信息", report.EpisodeMetadata)
+	}
+	return purged, report, nil
+}
+
+// MergeBangumi 将 mergeID 对应的番剧合并到 keepID
+// 种子、EpisodeMetadata、剧集和下载历史都会转移到保留的番剧上, 重复的 EpisodeMetadata 直接删除,
+// 同一集两边都有记录时保留已经下载的那条;
+// 保留的番剧缺少 mikan/tmdb id、RSS 链接和偏移时从被合并的番剧复制过来, 排除过滤取并集,
+// 包含过滤在两边都有规则时取并集, 任意一边为空(不限制)时合并后也为空.
+// 被合并的番剧标记为已删除并清空 mikan/tmdb id, 避免 CreateBangumi 查重时再次匹配到它.
+// 整个过程在一个事务中完成
+func (db *DB) MergeBangumi(ctx context.Context, keepID, mergeID int) error {
+	if keepID == mergeID {
+		return fmt.Errorf("不能将番剧合并到自身: %d", keepID)
+	}
+	slog.Info("[database] 合并番剧", "保留", keepID, "合并", mergeID)
+	return db.Transaction(ctx, func(tx *DB) error {
+		return tx.mergeBangumi(ctx, keepID, mergeID)
+	})
+}
+
+// mergeBangumi 见 MergeBangumi, 需要在事务中调用
+func (db *DB) mergeBangumi(ctx context.Context, keepID, mergeID int) error {
+	tx := db.WithContext(ctx)
+	var keep, merge model.Bangumi
+	if err := tx.Preload("EpisodeMetadata").First(&keep, keepID).Error; err != nil {
+		return err
+	}
+	if err := tx.Preload("EpisodeMetadata").First(&merge, mergeID).Error; err != nil {
+		return err
+	}
+
+	// 转移种子
+	if err := tx.Model(&model.Torrent{}).Where("bangumi_id = ?", mergeID).
+		Update("bangumi_id", keepID).Error; err != nil {
+		return err
+	}
+
+	// 转移 EpisodeMetadata, 与保留番剧重复的直接删除
+	existingKeys := make(map[string]struct{}, len(keep.EpisodeMetadata))
+	for _, e := range keep.EpisodeMetadata {
+		existingKeys[metadataKey(e)] = struct{}{}
+	}
+	for _, e := range merge.EpisodeMetadata {
+		if _, ok := existingKeys[metadataKey(e)]; ok {
+			if err := tx.Delete(&model.EpisodeMetadata{}, e.ID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		existingKeys[metadataKey(e)] = struct{}{}
+		if err := tx.Model(&model.EpisodeMetadata{}).Where("id = ?", e.ID).
+			Update("bangumi_id", keepID).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := mergeEpisodes(tx, keepID, mergeID); err != nil {
+		return err
+	}
+	if err := tx.Model(&model.DownloadEvent{}).Where("bangumi_id = ?", mergeID).
+		Update("bangumi_id", keepID).Error; err != nil {
+		return err
+	}
+	if err := mergeSeasons(tx, keepID, mergeID); err != nil {
+		return err
+	}
+	// 转移别名, 保留番剧已经有的直接删除
+	if err := tx.Where("bangumi_id = ? AND title IN (?)", mergeID,
+		tx.Model(&model.BangumiAlias{}).Select("title").Where("bangumi_id = ?", keepID),
+	).Delete(&model.BangumiAlias{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&model.BangumiAlias{}).Where("bangumi_id = ?", mergeID).
+		Update("bangumi_id", keepID).Error; err != nil {
+		return err
+	}
+	// 被合并番剧的外部 ID 改为指向保留的番剧
+	for _, table := range mappingTables {
+		if err := tx.Model(table).Where("bangumi_id = ?", mergeID).Update("bangumi_id", keepID).Error; err != nil {
+			return err
+		}
+	}
+
+	// 补全保留番剧缺少的 mikan/tmdb id 和 RSS 链接, 合并过滤规则
+	updates := map[string]any{}
+	if keep.MikanID == nil && merge.MikanID != nil {
+		updates["mikan_id"] = *merge.MikanID
+	}
+	if keep.TmdbID == nil && merge.TmdbID != nil {
+		updates["tmdb_id"] = *merge.TmdbID
+	}
+	if keep.RSSLink == "" && merge.RSSLink != "" {
+		updates["rss_link"] = merge.RSSLink
+	}
+	if keep.Offset == 0 && keep.OffsetSource != model.OffsetSourceManual && merge.Offset != 0 {
+		updates["offset"] = merge.Offset
+		updates["offset_source"] = merge.OffsetSource
+		updates["offset_confident"] = merge.OffsetConfident
+	}
+	if !keep.EpsCollect && merge.EpsCollect {
+		updates["eps_collect"] = true
+	}
+	// 包含过滤为空表示不限制, 任意一边不限制时合并后也不限制
+	if keep.IncludeFilter != "" {
+		include := ""
+		if merge.IncludeFilter != "" {
+			include = unionFilter(keep.IncludeFilter, merge.IncludeFilter)
+		}
+		if include != keep.IncludeFilter {
+			updates["include_filter"] = include
+		}
+	}
+	if exclude := unionFilter(keep.ExcludeFilter, merge.ExcludeFilter); exclude != keep.ExcludeFilter {
+		updates["exclude_filter"] = exclude
+	}
+	// 评分和备注只在保留番剧没有时沿用
+	if keep.UserScore == 0 && merge.UserScore != 0 {
+		updates["user_score"] = merge.UserScore
+	}
+	if keep.Notes == "" && merge.Notes != "" {
+		updates["notes"] = merge.Notes
+	}
+	// 保留番剧没有设置下载偏好时沿用被合并番剧的
+	if keep.Preference().IsZero() && !merge.Preference().IsZero() {
+		maps.Copy(updates, preferenceColumns(merge.Preference()))
 	}
-	slog.Info("[database] 番剧不存在，创建新记录", "标题", bangumi.OfficialTitle)
-	return db.Save(bangumi).Error
+	if len(updates) > 0 {
+		if err := tx.Model(&model.Bangumi{}).Where("id = ?", keepID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&model.Bangumi{}).Where("id = ?", mergeID).Updates(map[string]any{
+		"deleted":    true,
+		"deleted_at": time.Now(),
+		"mikan_id":   nil,
+		"tmdb_id":    nil,
+	}).Error
 }
 
-// UpdateBangumi 更新番剧
-func (db *DB) UpdateBangumi(bangumi *model.Bangumi) error {
-	return db.Save(bangumi).Error
+// mergeEpisodes 把 mergeID 的剧集转移到 keepID, 同一集两边都有时保留已经下载的记录
+func mergeEpisodes(tx *gorm.DB, keepID, mergeID int) error {
+	var keepEps, mergeEps []*model.Episode
+	if err := tx.Where("bangumi_id = ?", keepID).Find(&keepEps).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("bangumi_id = ?", mergeID).Find(&mergeEps).Error; err != nil {
+		return err
+	}
+	existing := make(map[[2]int]*model.Episode, len(keepEps))
+	for _, e := range keepEps {
+		existing[[2]int{e.Season, e.Number}] = e
+	}
+	for _, e := range mergeEps {
+		if old, ok := existing[[2]int{e.Season, e.Number}]; ok {
+			drop := e
+			if old.State == model.EpisodeMissing && e.State != model.EpisodeMissing {
+				drop = old
+			}
+			if err := tx.Delete(&model.Episode{}, drop.ID).Error; err != nil {
+				return err
+			}
+			if drop == e {
+				continue
+			}
+		}
+		if err := tx.Model(&model.Episode{}).Where("id = ?", e.ID).Update("bangumi_id", keepID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// DeleteBangumi 删除番剧
-func (db *DB) DeleteBangumi(id int) error {
-	return db.Delete(&model.Bangumi{}, id).Error
+// unionFilter 合并两个逗号分隔的过滤规则, 保持原有顺序并去掉重复的规则
+func unionFilter(a, b string) string {
+	var rules []string
+	for _, rule := range strings.Split(a+","+b, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule != "" && !slices.Contains(rules, rule) {
+			rules = append(rules, rule)
+		}
+	}
+	return strings.Join(rules, ",")
 }
 
 // GetBangumiByID 根据 ID 获取番剧
-func (db *DB) GetBangumiByID(id int) (*model.Bangumi, error) {
+func (db *DB) GetBangumiByID(ctx context.Context, id int) (*model.Bangumi, error) {
+	var bangumi model.Bangumi
+	err := db.WithContext(ctx).First(&bangumi, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &bangumi, nil
+}
+
+func (db *DB) GetBangumiByOfficialTitle(ctx context.Context, title string) (*model.Bangumi, error) {
 	var bangumi model.Bangumi
-	err := db.First(&bangumi, id).Error
+	err := db.WithContext(ctx).Where("official_title = ?", title).First(&bangumi).Error
 	if err != nil {
 		return nil, err
 	}
 	return &bangumi, nil
 }
 
-func (db *DB) GetBangumiByOfficialTitle(title string) (*model.Bangumi, error) {
+// GetBangumiByTitleSeason 根据官方标题和季度精确查找番剧, 标题不区分大小写
+// 找不到时返回 ErrNotFound
+func (db *DB) GetBangumiByTitleSeason(ctx context.Context, title string, season int) (*model.Bangumi, error) {
 	var bangumi model.Bangumi
-	err := db.Where("official_title = ?", title).First(&bangumi).Error
+	err := db.WithContext(ctx).Where("LOWER(official_title) = LOWER(?) AND season = ?", title, season).First(&bangumi).Error
 	if err != nil {
 		return nil, err
 	}
 	return &bangumi, nil
 }
 
-// ListBangumi 获取所有番剧
-func (db *DB) ListBangumi() ([]*model.Bangumi, error) {
+// SearchBangumi 按标题模糊搜索番剧, 不区分大小写
+// 同时匹配番剧中文名及其拼音(全拼或首字母)、TMDB 标题/原名和 Mikan 标题, 完全匹配 > 前缀匹配 > 包含匹配,
+// 同一档内按中文名排序; 种子的原始标题(通常是罗马音或英文名)也参与包含匹配.
+// limit <= 0 时不限制数量, 已删除的番剧不会返回
+func (db *DB) SearchBangumi(ctx context.Context, query string, limit int) ([]*model.Bangumi, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, nil
+	}
+	escaped := likeEscaper.Replace(query)
+	columns := []string{
+		"LOWER(bangumis.official_title)",
+		"LOWER(" + db.quote("TmdbItem.title") + ")",
+		"LOWER(" + db.quote("TmdbItem.original_title") + ")",
+		"LOWER(" + db.quote("MikanItem.official_title") + ")",
+	}
+	var match, exact, prefix []string
+	for _, col := range columns {
+		match = append(match, col+" LIKE @contains ESCAPE '!'")
+		exact = append(exact, col+" = @exact")
+		prefix = append(prefix, col+" LIKE @prefix ESCAPE '!'")
+	}
+	// 拼音别名是 "全拼 首字母", 分别按前缀匹配
+	alias := "LOWER(bangumis.title_alias)"
+	match = append(match, alias+" LIKE @contains ESCAPE '!'")
+	prefix = append(prefix, alias+" LIKE @prefix ESCAPE '!'", alias+" LIKE @wordPrefix ESCAPE '!'")
+	match = append(match, "EXISTS (SELECT 1 FROM episode_metadata WHERE episode_metadata.bangumi_id = bangumis.id"+
+		" AND LOWER(episode_metadata.title) LIKE @contains ESCAPE '!')")
+	args := map[string]any{
+		"exact":      query,
+		"prefix":     escaped + "%",
+		"wordPrefix": "% " + escaped + "%",
+		"contains":   "%" + escaped + "%",
+	}
+	// 先按匹配程度排序, 同一档内按中文名排序
+	order := clause.NamedExpr{
+		SQL: "CASE WHEN " + strings.Join(exact, " OR ") + " THEN 0" +
+			" WHEN " + strings.Join(prefix, " OR ") + " THEN 1 ELSE 2 END, bangumis.official_title",
+		Vars: []any{args},
+	}
+
 	var bangumis []*model.Bangumi
-	err := db.Find(&bangumis).Error
+	tx := db.WithContext(ctx).Joins("TmdbItem").
+		Joins("MikanItem").
+		Where("bangumis.deleted = ?", false).
+		Where(strings.Join(match, " OR "), args).
+		Clauses(clause.OrderBy{Expression: order})
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	err := tx.Find(&bangumis).Error
 	return bangumis, err
 }
+
+// likeEscaper 转义 LIKE 中的通配符
+// 用 ! 作为转义符, 反斜杠在 mysql 的字符串字面量里本身就是转义符
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// ListBangumi 获取所有番剧, 不包括回收站中的番剧(见 ListDeletedBangumi)
+func (db *DB) ListBangumi(ctx context.Context) ([]*model.Bangumi, error) {
+	var bangumis []*model.Bangumi
+	err := db.WithContext(ctx).Where("deleted = ?", false).Find(&bangumis).Error
+	return bangumis, err
+}
+
+// BangumiOrder 番剧列表的排序字段
+type BangumiOrder string
+
+const (
+	BangumiOrderID     BangumiOrder = "id" // 添加顺序, 默认
+	BangumiOrderTitle  BangumiOrder = "official_title"
+	BangumiOrderSeason BangumiOrder = "season"
+)
+
+// BangumiQuery 番剧列表的查询条件, 零值返回全部未删除的番剧, 按添加顺序排列
+type BangumiQuery struct {
+	OrderBy BangumiOrder
+	// Desc 为 true 时倒序, 默认正序
+	Desc bool
+	// Title 中文名包含的文本, 不区分大小写
+	Title string
+	// Season 不大于 0 时不过滤
+	Season         int
+	RSSLink        string
+	IncludeDeleted bool
+	// Limit 不大于 0 时不限制数量
+	Limit  int
+	Offset int
+}
+
+// where 添加过滤条件, 列表和计数共用
+func (opts BangumiQuery) where(tx *gorm.DB) *gorm.DB {
+	if !opts.IncludeDeleted {
+		tx = tx.Where("deleted = ?", false)
+	}
+	if title := strings.ToLower(strings.TrimSpace(opts.Title)); title != "" {
+		tx = tx.Where("LOWER(official_title) LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(title)+"%")
+	}
+	if opts.Season > 0 {
+		tx = tx.Where("season = ?", opts.Season)
+	}
+	if opts.RSSLink != "" {
+		tx = tx.Where("rss_link = ?", opts.RSSLink)
+	}
+	return tx
+}
+
+// ListBangumiPage 分页查询番剧, 同时返回满足条件的番剧总数(不受 Limit/Offset 影响)
+func (db *DB) ListBangumiPage(ctx context.Context, opts BangumiQuery) ([]*model.Bangumi, int64, error) {
+	var total int64
+	if err := db.WithContext(ctx).Model(&model.Bangumi{}).Scopes(opts.where).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	column := opts.OrderBy
+	switch column {
+	case BangumiOrderTitle, BangumiOrderSeason:
+	default:
+		column = BangumiOrderID
+	}
+	// 排序字段相同时按 id 排序, 保证分页稳定
+	query := db.WithContext(ctx).Scopes(opts.where).Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+		{Column: clause.Column{Name: string(column)}, Desc: opts.Desc},
+		{Column: clause.Column{Name: "id"}, Desc: opts.Desc},
+	}})
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+	if opts.Offset > 0 {
+		query = query.Offset(opts.Offset)
+	}
+	var bangumis []*model.Bangumi
+	if err := query.Find(&bangumis).Error; err != nil {
+		return nil, 0, err
+	}
+	return bangumis, total, nil
+}
+
+// ListBangumiMissingMetadata 获取需要手动处理的番剧, 不包括已删除的番剧
+// mikan_id 和 tmdb_id 都为空的番剧无法刷新元数据;
+// 开启了收集模式(EpsCollect)的番剧依赖 TMDB 的总集数, 只缺 tmdb_id 也需要处理
+func (db *DB) ListBangumiMissingMetadata(ctx context.Context) ([]*model.Bangumi, error) {
+	var bangumis []*model.Bangumi
+	err := db.WithContext(ctx).Where("deleted = ?", false).
+		Where(db.Where("mikan_id IS NULL AND tmdb_id IS NULL").
+			Or("tmdb_id IS NULL AND eps_collect = ?", true)).
+		Order("id").
+		Find(&bangumis).Error
+	return bangumis, err
+}
+
+// ListIncompleteBangumi 获取未完结且关联了 TMDB 的番剧, 用于检查是否已经完结
+func (db *DB) ListIncompleteBangumi(ctx context.Context) ([]*model.Bangumi, error) {
+	var bangumis []*model.Bangumi
+	err := db.WithContext(ctx).Preload("TmdbItem").
+		Where("deleted = ? AND completed = ? AND tmdb_id IS NOT NULL", false, false).
+		Order("id").
+		Find(&bangumis).Error
+	return bangumis, err
+}
+
+// MarkBangumiCompleted 标记番剧已完结
+func (db *DB) MarkBangumiCompleted(ctx context.Context, id int) error {
+	return db.setBangumiCompleted(ctx, id, true)
+}
+
+// ReactivateBangumi 取消番剧的完结标记, 定时刷新会重新处理它的 RSS
+func (db *DB) ReactivateBangumi(ctx context.Context, id int) error {
+	return db.setBangumiCompleted(ctx, id, false)
+}
+
+// SetBangumiPosterPath 记录番剧本地缓存的海报路径
+func (db *DB) SetBangumiPosterPath(ctx context.Context, id int, path string) error {
+	return db.updateBangumiByID(ctx, id, map[string]any{"poster_path": path})
+}
+
+// SetBangumiTmdb 为番剧关联 TMDB 条目, 条目不存在时一起保存
+// 番剧的年份和海报为空时用 TMDB 的信息补上, 已有的不覆盖
+func (db *DB) SetBangumiTmdb(ctx context.Context, id int, item *model.TmdbItem) error {
+	return db.Transaction(ctx, func(tx *DB) error {
+		if err := tx.CreateTmdbItem(ctx, item); err != nil {
+			return err
+		}
+		return tx.updateBangumiByID(ctx, id, map[string]any{
+			"tmdb_id":     item.ID,
+			"year":        gorm.Expr("CASE WHEN year = '' THEN ? ELSE year END", item.Year),
+			"poster_link": gorm.Expr("CASE WHEN poster_link = '' THEN ? ELSE poster_link END", item.PosterLink),
+		})
+	})
+}
+
+// SetBangumiStatus 设置番剧的观看状态, 状态未知时返回校验错误, 番剧不存在时返回 ErrNotFound
+func (db *DB) SetBangumiStatus(ctx context.Context, id int, status model.WatchStatus) error {
+	if !status.Valid() {
+		return &apperrors.ValidationError{Field: "Status", Reason: fmt.Sprintf("未知的观看状态: %s", status)}
+	}
+	return db.updateBangumiByID(ctx, id, map[string]any{"status": status})
+}
+
+// SetBangumiScore 设置番剧的用户评分, 范围为 0 到 model.MaxUserScore, 0 表示取消评分
+func (db *DB) SetBangumiScore(ctx context.Context, id, score int) error {
+	if score < 0 || score > model.MaxUserScore {
+		return &apperrors.ValidationError{Field: "UserScore", Reason: fmt.Sprintf("评分应在 0 到 %d 之间: %d", model.MaxUserScore, score)}
+	}
+	return db.updateBangumiByID(ctx, id, map[string]any{"user_score": score})
+}
+
+// SetBangumiNotes 设置番剧的备注
+func (db *DB) SetBangumiNotes(ctx context.Context, id int, notes string) error {
+	return db.updateBangumiByID(ctx, id, map[string]any{"notes": strings.TrimSpace(notes)})
+}
+
+// ListBangumiByStatus 按观看状态列出番剧, 不包括回收站中的
+func (db *DB) ListBangumiByStatus(ctx context.Context, status model.WatchStatus) ([]*model.Bangumi, error) {
+	var bangumis []*model.Bangumi
+	err := db.WithContext(ctx).Where("status = ? AND deleted = ?", status, false).Order("id").Find(&bangumis).Error
+	return bangumis, err
+}
+
+// SetBangumiPreference 设置番剧的下载偏好, 番剧不存在时返回 ErrNotFound
+func (db *DB) SetBangumiPreference(ctx context.Context, id int, pref model.ReleasePreference) error {
+	return db.updateBangumiByID(ctx, id, preferenceColumns(pref))
+}
+
+// SetBangumiOffset 手动设置番剧的集数偏移, 之后不会再自动推断, 番剧不存在时返回 ErrNotFound
+func (db *DB) SetBangumiOffset(ctx context.Context, id, offset int) error {
+	return db.updateBangumiByID(ctx, id, map[string]any{
+		"offset":           offset,
+		"offset_source":    model.OffsetSourceManual,
+		"offset_confident": true,
+	})
+}
+
+// ResetBangumiOffset 清除番剧的集数偏移(包括手动设置的), 之后出现的种子会重新自动推断, 番剧不存在时返回 ErrNotFound
+func (db *DB) ResetBangumiOffset(ctx context.Context, id int) error {
+	return db.updateBangumiByID(ctx, id, map[string]any{
+		"offset":           0,
+		"offset_source":    model.OffsetSourceNone,
+		"offset_confident": false,
+	})
+}
+
+// SetBangumiAutoOffset 记录自动推断的集数偏移, 手动设置过偏移的番剧不修改, 返回是否修改了
+func (db *DB) SetBangumiAutoOffset(ctx context.Context, id, offset int, confident bool) (bool, error) {
+	result := db.WithContext(ctx).Model(&model.Bangumi{}).
+		Where("id = ? AND offset_source <> ?", id, model.OffsetSourceManual).
+		Updates(map[string]any{
+			"offset":           offset,
+			"offset_source":    model.OffsetSourceAuto,
+			"offset_confident": confident,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// SetBangumiBackfilled 标记番剧已经补全过之前的集数, 番剧不存在时返回 ErrNotFound
+func (db *DB) SetBangumiBackfilled(ctx context.Context, id int) error {
+	return db.updateBangumiByID(ctx, id, map[string]any{"backfilled": true})
+}
+
+// ListBangumiToBackfill 还没有补全过之前集数的番剧 ID, 不包括已完结和已删除的, 按 ID 排序
+func (db *DB) ListBangumiToBackfill(ctx context.Context) ([]int, error) {
+	var ids []int
+	err := db.WithContext(ctx).Model(&model.Bangumi{}).
+		Where("backfilled = ? AND completed = ? AND deleted = ?", false, false, false).
+		Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// SetBangumiFilterRules 设置番剧覆盖全局规则的过滤规则, 见 model.Bangumi.SetFilterRules; 番剧不存在时返回 ErrNotFound
+func (db *DB) SetBangumiFilterRules(ctx context.Context, id int, rules model.FilterRules) error {
+	var b model.Bangumi
+	b.SetFilterRules(rules)
+	return db.updateBangumiByID(ctx, id, map[string]any{
+		"include_filter":  b.IncludeFilter,
+		"exclude_filter":  b.ExcludeFilter,
+		"filter_override": b.FilterOverride,
+	})
+}
+
+// preferenceColumns 下载偏好对应的列, 用于按字段更新
+func preferenceColumns(pref model.ReleasePreference) map[string]any {
+	var b model.Bangumi
+	b.SetPreference(pref)
+	return map[string]any{
+		"preferred_resolutions": b.PreferredResolutions,
+		"preferred_groups":      b.PreferredGroups,
+		"preferred_sub_type":    b.PreferredSubType,
+		"skip_batch":            b.SkipBatch,
+		"prefer_batch":          b.PreferBatch,
+	}
+}
+
+// updateBangumiByID 按字段更新番剧, 番剧不存在时返回 ErrNotFound
+func (db *DB) updateBangumiByID(ctx context.Context, id int, updates map[string]any) error {
+	result := db.WithContext(ctx).Model(&model.Bangumi{}).Where("id = ?", id).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (db *DB) setBangumiCompleted(ctx context.Context, id int, completed bool) error {
+	return db.updateBangumiByID(ctx, id, map[string]any{"completed": completed})
+}
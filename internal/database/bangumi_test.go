@@ -2,9 +2,19 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"goto-bangumi/internal/apperrors"
+	"goto-bangumi/internal/eventbus"
 	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
 )
 
 func TestNewDB(t *testing.T) {
@@ -16,6 +26,7 @@ func TestNewDB(t *testing.T) {
 }
 
 func TestBangumiLifecycle(t *testing.T) {
+	ctx := context.Background()
 	testdb := ":memory:"
 	// testdb := "./test.db"
 
@@ -60,7 +71,7 @@ func TestBangumiLifecycle(t *testing.T) {
 	}
 
 	t.Run("Create", func(t *testing.T) {
-		if err := db.CreateBangumi(&bangumi); err != nil {
+		if err := db.CreateBangumi(ctx, &bangumi); err != nil {
 			t.Fatalf("CreateBangumi failed: %v", err)
 		}
 		if bangumi.ID == 0 {
@@ -87,7 +98,7 @@ func TestBangumiLifecycle(t *testing.T) {
 			EpisodeMetadata: []model.EpisodeMetadata{episodeMetadata},
 			RSSLink:         bangumi.RSSLink,
 		}
-		if err := db.CreateBangumi(&dup); err != nil {
+		if err := db.CreateBangumi(ctx, &dup); err != nil {
 			t.Fatalf("CreateBangumi duplicate should not error, got: %v", err)
 		}
 		// 总数仍然只有一条
@@ -105,7 +116,7 @@ func TestBangumiLifecycle(t *testing.T) {
 	})
 
 	t.Run("GetByID", func(t *testing.T) {
-		got, err := db.GetBangumiByID(bangumi.ID)
+		got, err := db.GetBangumiByID(ctx, bangumi.ID)
 		if err != nil {
 			t.Fatalf("GetBangumiByID failed: %v", err)
 		}
@@ -115,7 +126,7 @@ func TestBangumiLifecycle(t *testing.T) {
 	})
 
 	t.Run("GetByOfficialTitle", func(t *testing.T) {
-		got, err := db.GetBangumiByOfficialTitle("夏日口袋")
+		got, err := db.GetBangumiByOfficialTitle(ctx, "夏日口袋")
 		if err != nil {
 			t.Fatalf("GetBangumiByOfficialTitle failed: %v", err)
 		}
@@ -125,7 +136,6 @@ func TestBangumiLifecycle(t *testing.T) {
 	})
 
 	t.Run("GetWithDetails", func(t *testing.T) {
-		ctx := context.Background()
 		got, err := db.GetBangumiWithDetails(ctx, uint(bangumi.ID))
 		if err != nil {
 			t.Fatalf("GetBangumiWithDetails failed: %v", err)
@@ -158,7 +168,7 @@ func TestBangumiLifecycle(t *testing.T) {
 	})
 
 	t.Run("List", func(t *testing.T) {
-		bangumis, err := db.ListBangumi()
+		bangumis, err := db.ListBangumi(ctx)
 		if err != nil {
 			t.Fatalf("ListBangumi failed: %v", err)
 		}
@@ -168,13 +178,844 @@ func TestBangumiLifecycle(t *testing.T) {
 	})
 
 	t.Run("Delete", func(t *testing.T) {
-		if err := db.DeleteBangumi(bangumi.ID); err != nil {
+		if err := db.DeleteBangumi(ctx, bangumi.ID); err != nil {
 			t.Fatalf("DeleteBangumi failed: %v", err)
 		}
+		bangumis, err := db.ListBangumi(ctx)
+		if err != nil {
+			t.Fatalf("ListBangumi failed: %v", err)
+		}
+		if len(bangumis) != 0 {
+			t.Fatalf("Expected 0 bangumis after delete, got %d", len(bangumis))
+		}
+		// 软删除, 行仍然存在
 		var count int64
 		db.Model(&model.Bangumi{}).Count(&count)
-		if count != 0 {
-			t.Fatalf("Expected 0 bangumis after delete, got %d", count)
+		if count != 1 {
+			t.Fatalf("Expected deleted bangumi to be kept, got %d rows", count)
+		}
+		if err := db.DeleteBangumi(ctx, bangumi.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound when deleting twice, got %v", err)
+		}
+	})
+}
+
+func TestUpdateBangumiConflict(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	user, _ := db.GetBangumiByID(ctx, bangumi.ID)
+	refresh, _ := db.GetBangumiByID(ctx, bangumi.ID)
+
+	user.Offset = 12
+	if err := db.UpdateBangumi(ctx, user); err != nil {
+		t.Fatalf("UpdateBangumi failed: %v", err)
+	}
+	if user.Version != 1 {
+		t.Fatalf("Expected version 1 after update, got %d", user.Version)
+	}
+
+	// 基于旧版本的修改不会覆盖
+	refresh.ExcludeFilter = "720p"
+	if err := db.UpdateBangumi(ctx, refresh); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict for stale update, got %v", err)
+	}
+	if refresh.Version != 0 {
+		t.Fatalf("Expected version to stay 0 after conflict, got %d", refresh.Version)
+	}
+	got, _ := db.GetBangumiByID(ctx, bangumi.ID)
+	if got.Offset != 12 || got.ExcludeFilter != "" {
+		t.Fatalf("Expected user edit to survive, got offset %d, filter %q", got.Offset, got.ExcludeFilter)
+	}
+
+	// 按字段更新同样增加版本号
+	if err := db.MarkBangumiCompleted(ctx, bangumi.ID); err != nil {
+		t.Fatal(err)
+	}
+	user.Offset = 0
+	if err := db.UpdateBangumi(ctx, user); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict after MarkBangumiCompleted, got %v", err)
+	}
+
+	// 重新读取后可以更新
+	latest, _ := db.GetBangumiByID(ctx, bangumi.ID)
+	latest.ExcludeFilter = "720p"
+	if err := db.UpdateBangumi(ctx, latest); err != nil {
+		t.Fatalf("UpdateBangumi after reload failed: %v", err)
+	}
+	got, _ = db.GetBangumiByID(ctx, bangumi.ID)
+	if !got.Completed || got.Offset != 12 || got.ExcludeFilter != "720p" || got.Version != latest.Version {
+		t.Fatalf("Unexpected bangumi after reload update: %+v", got)
+	}
+
+	if err := db.UpdateBangumi(ctx, &model.Bangumi{ID: 9999}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for unknown bangumi, got %v", err)
+	}
+}
+
+func TestSetBangumiTmdb(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	empty := &model.Bangumi{OfficialTitle: "败犬女主太多了！"}
+	filled := &model.Bangumi{OfficialTitle: "夏日口袋", Year: "2024", PosterLink: "https://example.com/own.jpg"}
+	for _, b := range []*model.Bangumi{empty, filled} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	item := &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！", Year: "2024", PosterLink: "https://image.tmdb.org/makeine.jpg"}
+	for _, b := range []*model.Bangumi{empty, filled} {
+		if err := db.SetBangumiTmdb(ctx, b.ID, item); err != nil {
+			t.Fatalf("SetBangumiTmdb failed: %v", err)
+		}
+	}
+
+	got, _ := db.GetBangumiByID(ctx, empty.ID)
+	if got.TmdbID == nil || *got.TmdbID != 241535 || got.Year != "2024" || got.PosterLink != item.PosterLink {
+		t.Errorf("Expected empty fields filled from TMDB, got %+v", got)
+	}
+	got, _ = db.GetBangumiByID(ctx, filled.ID)
+	if got.TmdbID == nil || got.PosterLink != "https://example.com/own.jpg" {
+		t.Errorf("Expected existing poster kept, got %+v", got)
+	}
+
+	if err := db.SetBangumiTmdb(ctx, 9999, item); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetBangumiTmdb on unknown bangumi error = %v, want ErrNotFound", err)
+	}
+	if err := db.SetBangumiPosterPath(ctx, 9999, "/tmp/poster.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetBangumiPosterPath on unknown bangumi error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBangumiTrash(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	keep := &model.Bangumi{OfficialTitle: "夏日口袋", Season: 1}
+	old := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1, TmdbItem: &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！"}}
+	recent := &model.Bangumi{OfficialTitle: "Summer 第二季", Season: 2}
+	for _, b := range []*model.Bangumi{keep, old, recent} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+		meta := &model.EpisodeMetadata{Title: b.OfficialTitle, Group: "LoliHouse", BangumiID: b.ID}
+		if err := db.Create(meta).Error; err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+		torrent := &model.Torrent{Link: fmt.Sprintf("https://mikanani.me/Download/%d.torrent", b.ID), Name: b.OfficialTitle, BangumiID: b.ID}
+		if err := db.CreateTorrent(ctx, torrent); err != nil {
+			t.Fatalf("Failed to save torrent: %v", err)
+		}
+	}
+
+	for _, b := range []*model.Bangumi{old, recent} {
+		if err := db.DeleteBangumi(ctx, b.ID); err != nil {
+			t.Fatalf("DeleteBangumi failed: %v", err)
+		}
+	}
+	// old 在 40 天前删除
+	longAgo := time.Now().Add(-40 * 24 * time.Hour)
+	db.Model(&model.Bangumi{}).Where("id = ?", old.ID).Update("deleted_at", longAgo)
+
+	deleted, err := db.ListDeletedBangumi(ctx)
+	if err != nil {
+		t.Fatalf("ListDeletedBangumi failed: %v", err)
+	}
+	if len(deleted) != 2 || deleted[0].ID != recent.ID || deleted[0].DeletedAt == nil {
+		t.Fatalf("ListDeletedBangumi() = %+v, want recent first", deleted)
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		if err := db.RestoreBangumi(ctx, recent.ID); err != nil {
+			t.Fatalf("RestoreBangumi failed: %v", err)
+		}
+		got, err := db.GetBangumiByID(ctx, recent.ID)
+		if err != nil || got.Deleted || got.DeletedAt != nil {
+			t.Fatalf("GetBangumiByID() = %+v, %v, want restored", got, err)
+		}
+		if err := db.RestoreBangumi(ctx, keep.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound when restoring active bangumi, got %v", err)
+		}
+		if err := db.DeleteBangumi(ctx, recent.ID); err != nil {
+			t.Fatalf("DeleteBangumi failed: %v", err)
 		}
 	})
+
+	t.Run("Purge", func(t *testing.T) {
+		purged, report, err := db.PurgeDeletedBangumi(ctx, time.Now().Add(-30*24*time.Hour))
+		if err != nil {
+			t.Fatalf("PurgeDeletedBangumi failed: %v", err)
+		}
+		if purged != 1 || report.Torrents != 1 || report.EpisodeMetadata != 1 || report.TmdbItems != 1 {
+			t.Fatalf("PurgeDeletedBangumi() = %d, %+v, want only the old bangumi", purged, report)
+		}
+		if _, err := db.GetBangumiByID(ctx, old.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expected purged bangumi to be gone, got %v", err)
+		}
+		if _, err := db.GetTorrentByURL(ctx, fmt.Sprintf("https://mikanani.me/Download/%d.torrent", recent.ID)); err != nil {
+			t.Fatalf("Expected torrent of recently deleted bangumi to be kept, got %v", err)
+		}
+
+		purged, _, err = db.PurgeDeletedBangumi(ctx, time.Now().Add(-30*24*time.Hour))
+		if err != nil || purged != 0 {
+			t.Fatalf("PurgeDeletedBangumi() again = %d, %v, want nothing", purged, err)
+		}
+	})
+}
+
+func TestGetBangumiByTitleSeason(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	for _, b := range []*model.Bangumi{
+		{OfficialTitle: "Summer Pocket", Year: "2025", Season: 1},
+		{OfficialTitle: "Summer Pocket", Year: "2026", Season: 2},
+		{OfficialTitle: "Summer Pocket Reflection Blue", Year: "2026", Season: 1},
+	} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	t.Run("CaseInsensitive", func(t *testing.T) {
+		got, err := db.GetBangumiByTitleSeason(ctx, "summer POCKET", 2)
+		if err != nil {
+			t.Fatalf("GetBangumiByTitleSeason failed: %v", err)
+		}
+		if got.OfficialTitle != "Summer Pocket" || got.Season != 2 {
+			t.Fatalf("Expected Summer Pocket S2, got %q S%d", got.OfficialTitle, got.Season)
+		}
+	})
+
+	t.Run("ExactMatch", func(t *testing.T) {
+		got, err := db.GetBangumiByTitleSeason(ctx, "Summer Pocket", 1)
+		if err != nil {
+			t.Fatalf("GetBangumiByTitleSeason failed: %v", err)
+		}
+		if got.OfficialTitle != "Summer Pocket" {
+			t.Fatalf("Expected exact title match, got %q", got.OfficialTitle)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := db.GetBangumiByTitleSeason(ctx, "Summer Pocket", 3)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("Expected gorm.ErrRecordNotFound, got %v", err)
+		}
+		_, err = db.GetBangumiByTitleSeason(ctx, "Summer", 1)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("Expected gorm.ErrRecordNotFound for partial title, got %v", err)
+		}
+	})
+}
+
+func TestSearchBangumi(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	for _, b := range []*model.Bangumi{
+		{
+			OfficialTitle: "败犬女主太多了！",
+			TmdbItem:      &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！", OriginalTitle: "負けヒロインが多すぎる！"},
+			MikanItem:     &model.MikanItem{ID: 3391, OfficialTitle: "败犬女主太多了！"},
+			EpisodeMetadata: []model.EpisodeMetadata{
+				{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse"},
+			},
+		},
+		{
+			OfficialTitle: "夏日口袋",
+			TmdbItem:      &model.TmdbItem{ID: 131631, Title: "Summer Pockets", OriginalTitle: "Summer Pockets"},
+		},
+		{OfficialTitle: "我的 Summer 假期"},
+		{OfficialTitle: "100%_元气"},
+		{OfficialTitle: "Summer 已删除", Deleted: true},
+	} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	titles := func(bangumis []*model.Bangumi) []string {
+		var result []string
+		for _, b := range bangumis {
+			result = append(result, b.OfficialTitle)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{"ChinesePartial", "女主", 0, []string{"败犬女主太多了！"}},
+		{"JapaneseOriginalTitle", "ヒロイン", 0, []string{"败犬女主太多了！"}},
+		{"PrefixBeforeContains", "summer", 0, []string{"夏日口袋", "我的 Summer 假期"}},
+		{"Limit", "summer", 1, []string{"夏日口袋"}},
+		{"EscapeWildcard", "%_", 0, []string{"100%_元气"}},
+		{"PinyinFull", "BaiQuan", 0, []string{"败犬女主太多了！"}},
+		{"PinyinInitials", "xrkd", 0, []string{"夏日口袋"}},
+		{"PinyinContains", "jiaqi", 0, []string{"我的 Summer 假期"}},
+		{"RawTitle", "heroine ga", 0, []string{"败犬女主太多了！"}},
+		{"NoMatch", "不存在", 0, nil},
+		{"Empty", "  ", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.SearchBangumi(ctx, tt.query, tt.limit)
+			if err != nil {
+				t.Fatalf("SearchBangumi failed: %v", err)
+			}
+			if !slices.Equal(titles(got), tt.want) {
+				t.Fatalf("SearchBangumi(%q) = %v, want %v", tt.query, titles(got), tt.want)
+			}
+		})
+	}
+
+	t.Run("PreloadTmdb", func(t *testing.T) {
+		got, err := db.SearchBangumi(ctx, "夏日", 0)
+		if err != nil {
+			t.Fatalf("SearchBangumi failed: %v", err)
+		}
+		if len(got) != 1 || got[0].TmdbItem == nil || got[0].TmdbItem.Title != "Summer Pockets" {
+			t.Fatalf("Expected TmdbItem to be loaded, got %+v", got)
+		}
+	})
+}
+
+func TestListBangumiPage(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	rss := "https://mikanani.me/RSS/MyBangumi?token=test"
+	for _, b := range []*model.Bangumi{
+		{OfficialTitle: "败犬女主太多了！", Season: 1, RSSLink: rss},
+		{OfficialTitle: "夏日口袋", Season: 1, RSSLink: rss},
+		{OfficialTitle: "Summer 第二季", Season: 2},
+		{OfficialTitle: "100%_元气", Season: 1},
+		{OfficialTitle: "Summer 已删除", Season: 2, Deleted: true},
+	} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     BangumiQuery
+		want      []string
+		wantTotal int64
+	}{
+		{"Default", BangumiQuery{}, []string{"败犬女主太多了！", "夏日口袋", "Summer 第二季", "100%_元气"}, 4},
+		{"Page", BangumiQuery{Limit: 2, Offset: 1}, []string{"夏日口袋", "Summer 第二季"}, 4},
+		{"Desc", BangumiQuery{Desc: true, Limit: 1}, []string{"100%_元气"}, 4},
+		{"SeasonThenID", BangumiQuery{OrderBy: BangumiOrderSeason, Desc: true}, []string{"Summer 第二季", "100%_元气", "夏日口袋", "败犬女主太多了！"}, 4},
+		{"Season", BangumiQuery{Season: 2}, []string{"Summer 第二季"}, 1},
+		{"IncludeDeleted", BangumiQuery{Season: 2, IncludeDeleted: true}, []string{"Summer 第二季", "Summer 已删除"}, 2},
+		{"Title", BangumiQuery{Title: "summer"}, []string{"Summer 第二季"}, 1},
+		{"EscapeWildcard", BangumiQuery{Title: "%_"}, []string{"100%_元气"}, 1},
+		{"RSSLink", BangumiQuery{RSSLink: rss, Limit: 1}, []string{"败犬女主太多了！"}, 2},
+		{"OffsetPastEnd", BangumiQuery{Offset: 10}, nil, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := db.ListBangumiPage(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("ListBangumiPage failed: %v", err)
+			}
+			var titles []string
+			for _, b := range got {
+				titles = append(titles, b.OfficialTitle)
+			}
+			if !slices.Equal(titles, tt.want) || total != tt.wantTotal {
+				t.Fatalf("ListBangumiPage() = %v, total %d, want %v, total %d", titles, total, tt.want, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestMergeBangumi(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	tmdbID := 241535
+	keep := model.Bangumi{
+		OfficialTitle: "败犬女主太多了！",
+		Season:        1,
+		IncludeFilter: "1080",
+		ExcludeFilter: "繁体",
+		EpisodeMetadata: []model.EpisodeMetadata{
+			{Title: "败犬女主太多了！", Season: 1, Group: "喵萌奶茶屋&LoliHouse", Resolution: "1080p"},
+		},
+	}
+	merge := model.Bangumi{
+		OfficialTitle: "败犬女主角也太多了！",
+		Season:        1,
+		RSSLink:       "https://mikanani.me/RSS/Bangumi?bangumiId=3391",
+		Offset:        -12,
+		ExcludeFilter: "繁体,合集",
+		TmdbItem:      &model.TmdbItem{ID: tmdbID, Title: "败犬女主太多了！", Season: 1},
+		EpisodeMetadata: []model.EpisodeMetadata{
+			// 与 keep 重复, 合并时应该被删除
+			{Title: "败犬女主太多了！", Season: 1, Group: "喵萌奶茶屋&LoliHouse", Resolution: "1080p"},
+			{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "ANi", Resolution: "1080P"},
+		},
+	}
+	for _, b := range []*model.Bangumi{&keep, &merge} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+	for i, bangumiID := range []int{keep.ID, merge.ID, merge.ID} {
+		torrent := &model.Torrent{
+			Link:      "https://example.com/" + string(rune('a'+i)) + ".torrent",
+			Name:      "torrent",
+			BangumiID: bangumiID,
+		}
+		if err := db.CreateTorrent(ctx, torrent); err != nil {
+			t.Fatalf("Failed to create torrent: %v", err)
+		}
+	}
+
+	// 第 1 集只有被合并的番剧下载过, 第 2 集只在被合并的番剧上
+	episodes := []*model.Episode{
+		{BangumiID: keep.ID, Season: 1, Number: 1, State: model.EpisodeMissing},
+		{BangumiID: keep.ID, Season: 1, Number: 3, State: model.EpisodeRenamed},
+		{BangumiID: merge.ID, Season: 1, Number: 1, State: model.EpisodeRenamed, FilePath: "S01E01.mkv"},
+		{BangumiID: merge.ID, Season: 1, Number: 2, State: model.EpisodeDownloading},
+	}
+	if err := db.Create(&episodes).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.MergeBangumi(ctx, keep.ID, keep.ID); err == nil {
+		t.Fatal("Expected error when merging bangumi into itself")
+	}
+	if err := db.MergeBangumi(ctx, keep.ID, merge.ID); err != nil {
+		t.Fatalf("MergeBangumi failed: %v", err)
+	}
+
+	torrents, err := db.ListTorrentByBangumiID(ctx, keep.ID)
+	if err != nil {
+		t.Fatalf("ListTorrentByBangumiID failed: %v", err)
+	}
+	if len(torrents) != 3 {
+		t.Fatalf("Expected 3 torrents on kept bangumi, got %d", len(torrents))
+	}
+
+	got, err := db.GetBangumiWithDetails(ctx, uint(keep.ID))
+	if err != nil {
+		t.Fatalf("GetBangumiWithDetails failed: %v", err)
+	}
+	if len(got.EpisodeMetadata) != 2 {
+		t.Fatalf("Expected 2 EpisodeMetadata after dedup, got %d", len(got.EpisodeMetadata))
+	}
+	var total int64
+	db.Model(&model.EpisodeMetadata{}).Count(&total)
+	if total != 2 {
+		t.Fatalf("Expected colliding EpisodeMetadata to be dropped, got %d rows", total)
+	}
+	if got.TmdbID == nil || *got.TmdbID != tmdbID {
+		t.Fatalf("Expected TmdbID %d copied to kept bangumi, got %v", tmdbID, got.TmdbID)
+	}
+	if got.RSSLink != merge.RSSLink || got.Offset != -12 {
+		t.Errorf("Expected rss link and offset copied, got %q, %d", got.RSSLink, got.Offset)
+	}
+	if got.IncludeFilter != "" || got.ExcludeFilter != "繁体,合集" {
+		t.Errorf("Expected filters include %q exclude %q, got %q, %q", "", "繁体,合集", got.IncludeFilter, got.ExcludeFilter)
+	}
+	eps, err := db.ListEpisodes(ctx, keep.ID)
+	if err != nil || len(eps) != 3 {
+		t.Fatalf("Expected 3 episodes on kept bangumi, got %d, %v", len(eps), err)
+	}
+	if eps[0].State != model.EpisodeRenamed || eps[0].FilePath != "S01E01.mkv" {
+		t.Errorf("Expected downloaded episode 1 kept, got %+v", eps[0])
+	}
+
+	merged, err := db.GetBangumiByID(ctx, merge.ID)
+	if err != nil {
+		t.Fatalf("GetBangumiByID failed: %v", err)
+	}
+	if !merged.Deleted {
+		t.Fatal("Expected merged bangumi to be marked deleted")
+	}
+	if merged.TmdbID != nil {
+		t.Fatalf("Expected merged bangumi TmdbID to be cleared, got %v", *merged.TmdbID)
+	}
+
+	// 不存在的番剧, 事务回滚
+	if err := db.MergeBangumi(ctx, keep.ID, 404); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for missing bangumi, got %v", err)
+	}
+}
+
+func TestListBangumiMissingMetadata(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tmdbID, mikanID := 241535, 3391
+	bangumis := []*model.Bangumi{
+		{OfficialTitle: "完整", Season: 1, TmdbID: &tmdbID, MikanID: &mikanID},
+		{OfficialTitle: "只有 mikan", Season: 1, MikanID: &mikanID},
+		{OfficialTitle: "只有 tmdb", Season: 1, TmdbID: &tmdbID},
+		{OfficialTitle: "都没有", Season: 1},
+		{OfficialTitle: "收集模式缺 tmdb", Season: 1, MikanID: &mikanID, EpsCollect: true},
+		{OfficialTitle: "已删除", Season: 1, Deleted: true},
+	}
+	for _, b := range bangumis {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := db.ListBangumiMissingMetadata(ctx)
+	if err != nil {
+		t.Fatalf("ListBangumiMissingMetadata() error = %v", err)
+	}
+	var titles []string
+	for _, b := range got {
+		titles = append(titles, b.OfficialTitle)
+	}
+	want := []string{"都没有", "收集模式缺 tmdb"}
+	if !slices.Equal(titles, want) {
+		t.Errorf("ListBangumiMissingMetadata() = %v, want %v", titles, want)
+	}
+}
+
+// TestCreateBangumi_AppendMetadata 追加到已有番剧的解析信息只有大小写或空白不同时视为重复,
+// 触发唯一约束的条目被跳过, 不影响其他条目
+func TestCreateBangumi_AppendMetadata(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	mikanID := 3391
+	existing := &model.Bangumi{
+		OfficialTitle: "败犬女主太多了！",
+		Season:        1,
+		MikanID:       &mikanID,
+		EpisodeMetadata: []model.EpisodeMetadata{
+			{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "LoliHouse", Resolution: "1080p"},
+		},
+	}
+	if err := db.CreateBangumi(ctx, existing); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟数据库中的唯一约束
+	if err := db.Exec("CREATE UNIQUE INDEX idx_test_metadata_title ON episode_metadata(bangumi_id, title, `group`)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 记录 savepoint, 唯一约束冲突的那一条要回滚到 savepoint, postgres 的事务才能继续
+	var rollbacks int
+	if err := db.Callback().Raw().After("gorm:raw").Register("test:savepoint", func(tx *gorm.DB) {
+		if strings.HasPrefix(tx.Statement.SQL.String(), "ROLLBACK TO SAVEPOINT") {
+			rollbacks++
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Callback().Raw().Remove("test:savepoint")
+
+	incoming := &model.Bangumi{
+		OfficialTitle: "败犬女主太多了！",
+		Season:        1,
+		MikanID:       &mikanID,
+		EpisodeMetadata: []model.EpisodeMetadata{
+			// 只有大小写和空白不同
+			{Title: "make heroine ga oosugiru! ", Season: 1, Group: "lolihouse", Resolution: "1080P"},
+			// 只在唯一约束的字段上重复
+			{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "LoliHouse", Resolution: "720p"},
+			// 新的字幕组
+			{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "喵萌奶茶屋", Resolution: "1080p"},
+		},
+	}
+	if err := db.CreateBangumi(ctx, incoming); err != nil {
+		t.Fatalf("CreateBangumi() error = %v", err)
+	}
+
+	metadata, err := db.ListEpisodeMetadataByBangumiID(ctx, existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []string
+	for _, m := range metadata {
+		groups = append(groups, m.Group)
+	}
+	slices.Sort(groups)
+	if want := []string{"LoliHouse", "喵萌奶茶屋"}; !slices.Equal(groups, want) {
+		t.Errorf("groups = %v, want %v", groups, want)
+	}
+	if rollbacks != 1 {
+		t.Errorf("rollbacks to savepoint = %d, want 1", rollbacks)
+	}
+}
+
+// TestCreateOrMergeBangumi 合并到已有番剧时返回 created = false, 传入的番剧被替换为合并后的番剧
+func TestCreateOrMergeBangumi(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t)
+
+	mikanID := 3391
+	existing := &model.Bangumi{
+		OfficialTitle:   "败犬女主太多了！",
+		Season:          1,
+		MikanID:         &mikanID,
+		EpisodeMetadata: []model.EpisodeMetadata{{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "LoliHouse"}},
+	}
+	created, err := db.CreateOrMergeBangumi(ctx, existing)
+	if err != nil || !created {
+		t.Fatalf("CreateOrMergeBangumi(new) = %v, %v, want true, nil", created, err)
+	}
+
+	incoming := &model.Bangumi{
+		OfficialTitle:   "败犬女主太多了！",
+		Season:          1,
+		MikanID:         &mikanID,
+		EpisodeMetadata: []model.EpisodeMetadata{{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "喵萌奶茶屋"}},
+	}
+	created, err = db.CreateOrMergeBangumi(ctx, incoming)
+	if err != nil || created {
+		t.Fatalf("CreateOrMergeBangumi(existing) = %v, %v, want false, nil", created, err)
+	}
+	if incoming.ID != existing.ID {
+		t.Errorf("merged bangumi ID = %d, want %d", incoming.ID, existing.ID)
+	}
+	if incoming.Version != existing.Version+1 {
+		t.Errorf("merged bangumi Version = %d, want %d", incoming.Version, existing.Version+1)
+	}
+	if len(incoming.EpisodeMetadata) != 2 {
+		t.Errorf("merged bangumi has %d EpisodeMetadata, want 2", len(incoming.EpisodeMetadata))
+	}
+}
+
+func TestDeleteBangumiDeep(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t, "testdata/fixtures.yaml")
+	if err := db.Model(&model.Torrent{}).Where("link = ?", "https://mikanani.me/Download/frieren-01.torrent").
+		Update("download_uid", "hash-01").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BindBangumiKeys(ctx, 1, BangumiKeys{MikanID: 3141}); err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := eventbus.Subscribe[BangumiDeleted](db.Events(), ctx, 4)
+	defer unsubscribe()
+
+	// 移入回收站时关联的数据和下载的文件都保留
+	if err := db.DeleteBangumiDeep(ctx, 1, DeleteBangumiOptions{RemoveDownloads: true}); err != nil {
+		t.Fatalf("DeleteBangumiDeep() error = %v", err)
+	}
+	ev := <-events
+	if ev.Bangumi.ID != 1 || ev.Purged {
+		t.Errorf("soft delete event = %+v", ev)
+	}
+	if removals, _ := db.ListDownloadRemovals(ctx); len(removals) != 0 {
+		t.Errorf("removals after soft delete = %+v, want none", removals)
+	}
+	if torrents, _ := db.ListTorrentByBangumiID(ctx, 1); len(torrents) != 2 {
+		t.Errorf("torrents after soft delete = %d, want 2", len(torrents))
+	}
+	if err := db.RestoreBangumi(ctx, 1); err != nil {
+		t.Fatalf("RestoreBangumi() error = %v", err)
+	}
+
+	// 彻底删除时清理关联的数据, 记录要从下载器删除的种子
+	if err := db.DeleteBangumiDeep(ctx, 1, DeleteBangumiOptions{Purge: true, RemoveDownloads: true}); err != nil {
+		t.Fatalf("DeleteBangumiDeep(purge) error = %v", err)
+	}
+	ev = <-events
+	if !ev.Purged {
+		t.Errorf("purge event = %+v", ev)
+	}
+	removals, err := db.ListDownloadRemovals(ctx)
+	if err != nil || len(removals) != 1 || removals[0].DownloadUID != "hash-01" || removals[0].BangumiID != 1 {
+		t.Fatalf("ListDownloadRemovals() = %+v, %v, want hash-01", removals, err)
+	}
+	if err := db.RecordDownloadRemovalFailure(ctx, []string{"hash-01"}, errors.New("offline")); err != nil {
+		t.Fatal(err)
+	}
+	if removals, _ := db.ListDownloadRemovals(ctx); len(removals) != 1 || removals[0].Attempts != 1 || removals[0].LastError != "offline" {
+		t.Errorf("removals after failure = %+v, want 1 attempt", removals)
+	}
+	if err := db.DeleteDownloadRemovals(ctx, []string{"hash-01"}); err != nil {
+		t.Fatal(err)
+	}
+	if removals, _ := db.ListDownloadRemovals(ctx); len(removals) != 0 {
+		t.Errorf("removals after delete = %+v, want none", removals)
+	}
+	var count int64
+	if db.Model(&model.Torrent{}).Where("bangumi_id = ?", 1).Count(&count); count != 0 {
+		t.Errorf("torrents after purge = %d, want 0", count)
+	}
+	if db.Model(&model.EpisodeMetadata{}).Where("bangumi_id = ?", 1).Count(&count); count != 0 {
+		t.Errorf("episode metadata after purge = %d, want 0", count)
+	}
+	if _, err := db.FindBangumiUID(ctx, BangumiKeys{MikanID: 3141}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindBangumiUID() after purge error = %v, want ErrNotFound", err)
+	}
+	if history, _ := db.ListDownloadEvents(ctx, DownloadEventQuery{BangumiID: 1, Actions: []model.DownloadAction{model.ActionDeleted}}); len(history) != 2 {
+		t.Errorf("deleted history = %d, want 2", len(history))
+	}
+	if err := db.DeleteBangumiDeep(ctx, 1, DeleteBangumiOptions{Purge: true}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteBangumiDeep() missing error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCreateTmdbItemMerge(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t)
+
+	full := &model.TmdbItem{ID: 209867, Title: "葬送的芙莉莲", Year: "2023", PosterLink: "/poster.jpg", VoteAverage: 8.8, EpisodeCount: 28}
+	if err := db.CreateTmdbItem(ctx, full); err != nil {
+		t.Fatalf("CreateTmdbItem() error = %v", err)
+	}
+	// 后一次解析只拿到部分信息, 已有的海报和评分保留, 非零字段覆盖
+	partial := &model.TmdbItem{ID: 209867, Title: "葬送的芙莉莲", EpisodeCount: 10}
+	if err := db.CreateTmdbItem(ctx, partial); err != nil {
+		t.Fatalf("CreateTmdbItem() partial error = %v", err)
+	}
+	if partial.PosterLink != "/poster.jpg" || partial.VoteAverage != 8.8 || partial.EpisodeCount != 10 || partial.Year != "2023" {
+		t.Errorf("merged item = %+v", partial)
+	}
+
+	// 并发写入同一个条目不会报重复
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- db.CreateMikanItem(ctx, &model.MikanItem{ID: 3141, OfficialTitle: fmt.Sprintf("葬送的芙莉莲 %d", i)})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent CreateMikanItem() error = %v", err)
+		}
+	}
+	if err := db.CreateMikanItem(ctx, &model.MikanItem{}); err == nil {
+		t.Error("CreateMikanItem() without id error = nil")
+	}
+}
+
+func TestSetBangumiPreference(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t, "testdata/fixtures.yaml")
+
+	pref := model.ReleasePreference{Resolutions: []string{"1080p", " 720p", "1080P"}, Groups: []string{"LoliHouse", "Nekomoe kissaten"}, SubType: "简繁内封"}
+	if err := db.SetBangumiPreference(ctx, 1, pref); err != nil {
+		t.Fatalf("SetBangumiPreference() error = %v", err)
+	}
+	bangumi, err := db.GetBangumiByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := bangumi.Preference()
+	if !slices.Equal(got.Resolutions, []string{"1080p", "720p"}) || len(got.Groups) != 2 || got.AllowBatch {
+		t.Errorf("Preference() = %+v", got)
+	}
+
+	// 分辨率优先于字幕组, 字幕组优先于字幕类型
+	best := got.Score(model.EpisodeMetadata{Resolution: "1080P", Group: "Nekomoe kissaten"})
+	for _, meta := range []model.EpisodeMetadata{
+		{Resolution: "720p", Group: "LoliHouse", SubType: "简繁内封"},
+		{Resolution: "2160p", Group: "LoliHouse"},
+	} {
+		if score := got.Score(meta); score >= best {
+			t.Errorf("Score(%+v) = %d, want less than %d", meta, score, best)
+		}
+	}
+
+	// 合并时保留番剧没有偏好, 沿用被合并番剧的
+	if err := db.MergeBangumi(ctx, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if merged, _ := db.GetBangumiByID(ctx, 2); merged.PreferredGroups != "LoliHouse,Nekomoe kissaten" {
+		t.Errorf("merged preferred groups = %q", merged.PreferredGroups)
+	}
+	if err := db.SetBangumiPreference(ctx, 99, pref); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetBangumiPreference() missing error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBangumiWatchStatus(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t, "testdata/fixtures.yaml")
+
+	// 新番剧默认在看
+	if bangumis, err := db.ListBangumiByStatus(ctx, model.WatchWatching); err != nil || len(bangumis) != 2 {
+		t.Fatalf("ListBangumiByStatus(watching) = %d, %v, want 2", len(bangumis), err)
+	}
+	if err := db.SetBangumiStatus(ctx, 1, model.WatchFinished); err != nil {
+		t.Fatalf("SetBangumiStatus() error = %v", err)
+	}
+	if err := db.SetBangumiScore(ctx, 1, 9); err != nil {
+		t.Fatalf("SetBangumiScore() error = %v", err)
+	}
+	if err := db.SetBangumiNotes(ctx, 1, " 第二季再看一遍 "); err != nil {
+		t.Fatalf("SetBangumiNotes() error = %v", err)
+	}
+	bangumi, _ := db.GetBangumiByID(ctx, 1)
+	if bangumi.Status != model.WatchFinished || bangumi.UserScore != 9 || bangumi.Notes != "第二季再看一遍" {
+		t.Errorf("bangumi = status %s, score %d, notes %q", bangumi.Status, bangumi.UserScore, bangumi.Notes)
+	}
+	if finished, _ := db.ListBangumiByStatus(ctx, model.WatchFinished); len(finished) != 1 || finished[0].ID != 1 {
+		t.Errorf("ListBangumiByStatus(finished) = %+v", finished)
+	}
+
+	if err := db.SetBangumiStatus(ctx, 1, "rewatching"); !apperrors.IsValidationError(err) {
+		t.Errorf("SetBangumiStatus() unknown error = %v, want validation error", err)
+	}
+	if err := db.SetBangumiScore(ctx, 1, 11); !apperrors.IsValidationError(err) {
+		t.Errorf("SetBangumiScore(11) error = %v, want validation error", err)
+	}
+	if err := db.SetBangumiStatus(ctx, 99, model.WatchDropped); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetBangumiStatus() missing error = %v, want ErrNotFound", err)
+	}
 }
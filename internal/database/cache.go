@@ -0,0 +1,199 @@
+package database
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// ============ 查询缓存 ============
+
+// RSS 刷新时每个种子都要按链接和标题查询一次, 同一批种子每轮都会重复查询
+// 这里在内存中缓存 GetTorrentByURL 和 ListBangumiCandidates 的结果, 相关的表有写入时清空
+// 写入时清空缓存由 gorm 的回调完成, 所以不经过 DB 方法直接写表也能正确失效;
+// 事务中的写入在提交前就会清空, 提交前被其他连接读到的旧数据最多保留 TTL, DB.Transaction 提交后会再清空一次
+
+// CacheStats 查询缓存的命中统计
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Size   int   `json:"size"`
+}
+
+// HitRate 命中率, 没有查询时为 0
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// QueryCacheStats 各个查询缓存的统计
+type QueryCacheStats struct {
+	Torrents   CacheStats `json:"torrents"`   // GetTorrentByURL
+	Candidates CacheStats `json:"candidates"` // ListBangumiCandidates/GetBangumiParseByTitle
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// lruCache 带过期时间的 LRU 缓存, size 为 0 时不缓存
+// generation 在每次清空时加一, 查询开始后缓存被清空过的结果不会写入, 避免把写入前读到的旧数据放回缓存
+type lruCache[V any] struct {
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	items      map[string]*list.Element
+	order      *list.List // 最近使用的在前
+	generation uint64
+	hits       atomic.Int64
+	misses     atomic.Int64
+}
+
+func newLRUCache[V any]() *lruCache[V] {
+	return &lruCache[V]{items: map[string]*list.Element{}, order: list.New()}
+}
+
+// configure 修改容量和有效期, 同时清空缓存和统计
+func (c *lruCache[V]) configure(size int, ttl time.Duration) {
+	c.mu.Lock()
+	c.size, c.ttl = max(size, 0), ttl
+	c.mu.Unlock()
+	c.purge()
+	c.hits.Store(0)
+	c.misses.Store(0)
+}
+
+// get 返回缓存的值和当前的 generation, 没有命中时把 generation 传给 set
+func (c *lruCache[V]) get(key string) (V, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var zero V
+	if c.size == 0 {
+		return zero, c.generation, false
+	}
+	elem, ok := c.items[key]
+	if ok {
+		entry := elem.Value.(*lruEntry[V])
+		if c.ttl <= 0 || time.Now().Before(entry.expires) {
+			c.order.MoveToFront(elem)
+			c.hits.Add(1)
+			return entry.value, c.generation, true
+		}
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+	c.misses.Add(1)
+	return zero, c.generation, false
+}
+
+// set 写入查询结果, generation 与 get 时不同说明查询期间表被修改过, 结果可能已经过期, 不写入
+func (c *lruCache[V]) set(key string, value V, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size == 0 || generation != c.generation {
+		return
+	}
+	entry := &lruEntry[V]{key: key, value: value, expires: time.Now().Add(c.ttl)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+// purge 清空缓存
+func (c *lruCache[V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if len(c.items) == 0 {
+		return
+	}
+	clear(c.items)
+	c.order.Init()
+}
+
+func (c *lruCache[V]) stats() CacheStats {
+	c.mu.Lock()
+	size := len(c.items)
+	c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: size}
+}
+
+// queryCache 数据库的查询缓存, 同一个连接的所有 DB(包括事务中的)共享
+type queryCache struct {
+	torrents   *lruCache[*model.Torrent] // 链接 -> 种子, nil 表示不存在
+	candidates *lruCache[[]BangumiCandidate]
+}
+
+func newQueryCache() *queryCache {
+	return &queryCache{torrents: newLRUCache[*model.Torrent](), candidates: newLRUCache[[]BangumiCandidate]()}
+}
+
+// invalidate 按写入的表清空相关的缓存, 表名未知时(例如 Exec 执行的原始 SQL)全部清空
+func (c *queryCache) invalidate(table string) {
+	switch table {
+	case "torrents":
+		c.torrents.purge()
+	case "episode_metadata", "bangumis", "bangumi_parser_mappings", "bangumi_aliases":
+		c.candidates.purge()
+	case "":
+		c.torrents.purge()
+		c.candidates.purge()
+	}
+}
+
+// registerCacheCallbacks 在每次写入之后清空相关的查询缓存
+func registerCacheCallbacks(db *gorm.DB, cache *queryCache) error {
+	// 没有影响任何行的语句(包括 Ping 的 SELECT 1)不会改变数据
+	invalidate := func(tx *gorm.DB) {
+		if tx.RowsAffected > 0 {
+			cache.invalidate(tx.Statement.Table)
+		}
+	}
+	callbacks := db.Callback()
+	for name, err := range map[string]error{
+		"create": callbacks.Create().After("gorm:create").Register("goto:cache_create", invalidate),
+		"update": callbacks.Update().After("gorm:update").Register("goto:cache_update", invalidate),
+		"delete": callbacks.Delete().After("gorm:delete").Register("goto:cache_delete", invalidate),
+		"raw":    callbacks.Raw().After("gorm:raw").Register("goto:cache_raw", invalidate),
+	} {
+		if err != nil {
+			return fmt.Errorf("注册 %s 缓存回调失败: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// SetQueryCache 设置查询缓存的容量和有效期, size 为 0 时关闭缓存; 修改后缓存和统计都会清空
+// ttl 不大于 0 时只在写入时失效
+func (db *DB) SetQueryCache(size int, ttl time.Duration) {
+	db.cache.torrents.configure(size, ttl)
+	db.cache.candidates.configure(size, ttl)
+}
+
+// QueryCacheStats 返回查询缓存的命中统计
+func (db *DB) QueryCacheStats() QueryCacheStats {
+	return QueryCacheStats{Torrents: db.cache.torrents.stats(), Candidates: db.cache.candidates.stats()}
+}
+
+// cacheable 事务中读到的可能是未提交的数据, 不读也不写缓存
+func (db *DB) cacheable() bool {
+	return db.pending == nil && db.cache != nil
+}
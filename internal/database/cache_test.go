@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestQueryCache(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	db.SetQueryCache(100, time.Minute)
+
+	bangumi := &model.Bangumi{OfficialTitle: "葬送的芙莉莲", Season: 1}
+	if err := db.Create(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "Sousou no Frieren", Group: "LoliHouse", Season: 1, BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+	link := "https://mikanani.me/Download/1.torrent"
+	name := "[LoliHouse] Sousou no Frieren - 01 [1080p]"
+
+	// 不存在的链接也会被缓存, 写入后失效
+	for range 2 {
+		if _, err := db.GetTorrentByURL(ctx, link); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetTorrentByURL() error = %v, want ErrNotFound", err)
+		}
+	}
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: link, Name: name, BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		torrent, err := db.GetTorrentByURL(ctx, link)
+		if err != nil || torrent.Downloaded != model.DownloadNone {
+			t.Fatalf("GetTorrentByURL() = %+v, %v", torrent, err)
+		}
+		torrent.Name = "modified" // 修改返回值不影响缓存
+	}
+	if err := db.AddTorrentDownload(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := db.GetTorrentByURL(ctx, link)
+	if err != nil || torrent.Downloaded != model.DownloadDone || torrent.Name != name {
+		t.Errorf("GetTorrentByURL() after update = %+v, %v", torrent, err)
+	}
+	stats := db.QueryCacheStats().Torrents
+	if stats.Hits != 2 || stats.Misses != 3 {
+		t.Errorf("torrent cache stats = %+v, want 2 hits and 3 misses", stats)
+	}
+
+	for range 3 {
+		if match, err := db.GetBangumiParseByTitle(ctx, name); err != nil || match.ID != bangumi.ID {
+			t.Fatalf("GetBangumiParseByTitle() = %v, %v", match, err)
+		}
+	}
+	if stats := db.QueryCacheStats().Candidates; stats.Hits != 2 || stats.Misses != 1 || stats.HitRate() < 0.6 {
+		t.Errorf("candidate cache stats = %+v", stats)
+	}
+	// 删除解析信息后缓存失效, 不再匹配到番剧
+	if err := db.Where("bangumi_id = ?", bangumi.ID).Delete(&model.EpisodeMetadata{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetBangumiParseByTitle(ctx, name); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBangumiParseByTitle() after delete error = %v, want ErrNotFound", err)
+	}
+
+	// 原始 SQL 的写入同样会清空缓存
+	if _, err := db.GetTorrentByURL(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("UPDATE torrents SET renamed = ?", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if torrent, _ := db.GetTorrentByURL(ctx, link); !torrent.Renamed {
+		t.Errorf("GetTorrentByURL() after raw update = %+v, want renamed", torrent)
+	}
+
+	// 容量满时淘汰最久没有使用的
+	db.SetQueryCache(1, 0)
+	db.GetTorrentByURL(ctx, link)
+	db.GetTorrentByURL(ctx, "https://mikanani.me/Download/2.torrent")
+	if stats := db.QueryCacheStats().Torrents; stats.Size != 1 {
+		t.Errorf("torrent cache size = %d, want 1", stats.Size)
+	}
+
+	db.SetQueryCache(0, 0)
+	db.GetTorrentByURL(ctx, link)
+	if stats := db.QueryCacheStats().Torrents; stats.Size != 0 || stats.Hits != 0 {
+		t.Errorf("disabled cache stats = %+v", stats)
+	}
+}
@@ -3,38 +3,169 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"path/filepath"
-
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"goto-bangumi/internal/apperrors"
+	"goto-bangumi/internal/eventbus"
 	"goto-bangumi/internal/model"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
 // DB 数据库连接包装
 type DB struct {
 	*gorm.DB
+	events eventbus.EventBus
+	// pending 事务中待发布的事件, 见 Transaction
+	pending *[]any
+	// readOnly 以只读方式打开, 见 WithReadOnly
+	readOnly bool
+	// cache 查询缓存, 默认关闭, 见 SetQueryCache
+	cache *queryCache
+}
+
+const (
+	// DataDirEnv 指定数据目录的环境变量, 用于同时运行多个实例
+	DataDirEnv = "GOTO_BANGUMI_DATA_DIR"
+	// DefaultDataDir 默认数据目录
+	DefaultDataDir = "./data"
+	dbFileName     = "data.db"
+)
+
+// ResolveDataDir 解析数据目录, 优先级: 传入的 dataDir > GOTO_BANGUMI_DATA_DIR > ./data
+func ResolveDataDir(dataDir string) string {
+	if dataDir != "" {
+		return dataDir
+	}
+	if env := os.Getenv(DataDirEnv); env != "" {
+		return env
+	}
+	return DefaultDataDir
+}
+
+// Open 在数据目录下打开数据库, 目录不存在时会创建
+// 打开前会检查目录是否可写, 避免 sqlite 在第一次写入时才报出难以理解的错误
+func Open(dataDir string, opts ...Option) (*DB, error) {
+	dir := ResolveDataDir(dataDir)
+	if readOnly(opts) {
+		// 只读时不创建任何文件, 数据库必须已经存在
+		path := filepath.Join(dir, dbFileName)
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("只读打开数据库 %s 失败: %w", path, err)
+		}
+		return NewDB(&path, opts...)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建数据目录 %s 失败: %w", dir, err)
+	}
+	if err := checkWritable(dir); err != nil {
+		return nil, fmt.Errorf("数据目录 %s 不可写: %w", dir, err)
+	}
+	// 事务开始时就获取写锁, 多个进程共用数据库时先读后写的事务不会读到过期的数据
+	path := filepath.Join(dir, dbFileName) + "?_txlock=immediate"
+	return NewDB(&path, opts...)
+}
+
+// readOnly 判断 opts 中是否包含 WithReadOnly
+func readOnly(opts []Option) bool {
+	var o sqliteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.readOnly
+}
+
+// checkWritable 通过创建临时文件检查目录是否可写
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
 }
 
 // NewDB 创建数据库连接
-// dsn 为 nil 时使用默认路径，传入 ":memory:" 可创建内存数据库
-func NewDB(dsn *string) (*DB, error) {
-	// 打开数据库连接，使用简单配置
-	path := filepath.Join("./data/data.db")
-	if dsn != nil {
-		path = *dsn
-	}
-	gormDB, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+// dsn 为 nil 时使用数据目录下的默认路径(见 Open)，传入 ":memory:" 可创建内存数据库
+// 默认开启 WAL, 见 defaultSQLiteOptions, 可以通过 opts 调整 pragma 和连接池
+func NewDB(dsn *string, opts ...Option) (*DB, error) {
+	if dsn == nil {
+		return Open("", opts...)
+	}
+	options := defaultSQLiteOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	path := *dsn
+	memory := path == ":memory:"
+	gormDB, err := gorm.Open(sqlite.Open(options.dsn(path, memory)), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
 	if err != nil {
 		return nil, err
 	}
+	sqlDB, err := gormDB.DB()
+	if err != nil {
+		return nil, err
+	}
 
-	slog.Info("数据库连接成功", slog.String("path", path))
+	// 内存数据库每个连接都是一个独立的空库, 并发时连接池新开的连接看不到已迁移的表
+	// 这里限制为单连接, 所有 goroutine 共享同一个库
+	if memory {
+		sqlDB.SetMaxOpenConns(1)
+	} else if !options.readOnly {
+		if options.maxOpenConns > 0 {
+			sqlDB.SetMaxOpenConns(options.maxOpenConns)
+		}
+		if options.maxIdleConns > 0 {
+			sqlDB.SetMaxIdleConns(options.maxIdleConns)
+		}
+		if _, err := backupBeforeMigrate(gormDB, sqliteFile(path), migrations); err != nil {
+			return nil, fmt.Errorf("迁移前备份数据库失败: %w", err)
+		}
+	}
+	if options.readOnly {
+		return setupReadOnlyDB(gormDB, path)
+	}
+	return setupDB(gormDB, path)
+}
+
+// sqliteFile 去掉 sqlite DSN 的 file: 前缀和查询参数, 得到数据库文件路径
+func sqliteFile(dsn string) string {
+	dsn = strings.TrimPrefix(dsn, "file:")
+	if i := strings.IndexByte(dsn, '?'); i >= 0 {
+		dsn = dsn[:i]
+	}
+	return dsn
+}
+
+// setupDB 注册错误回调并执行建表和数据迁移, name 只用于日志
+func setupDB(gormDB *gorm.DB, name string) (*DB, error) {
+	if err := registerErrorCallbacks(gormDB); err != nil {
+		return nil, err
+	}
+	if err := gormDB.Callback().Update().Before("gorm:update").Register("goto:bangumi_version", bumpBangumiVersion); err != nil {
+		return nil, err
+	}
+	cache := newQueryCache()
+	if err := registerCacheCallbacks(gormDB, cache); err != nil {
+		return nil, err
+	}
+
+	slog.Info("数据库连接成功", slog.String("path", name))
 	// 自动迁移模型
 	// 注意：迁移顺序很重要，基础表（无外键依赖）应该先迁移
 	// 1. 首先迁移独立的基础表
@@ -46,6 +177,18 @@ func NewDB(dsn *string) (*DB, error) {
 		&model.TmdbItem{},
 		&model.EpisodeMetadata{},
 		&model.RSSItem{},
+		&model.ResolveAttempt{},
+		&model.PendingTorrent{},
+		&model.MetadataLookup{},
+		&model.DownloadEvent{},
+		&model.DownloadRemoval{},
+		&model.Episode{},
+		&model.Setting{},
+		&model.MikanMapping{},
+		&model.TmdbMapping{},
+		&model.BgmMapping{},
+		&model.BangumiAlias{},
+		&model.Season{},
 
 		// 有外键依赖的表
 		&model.Bangumi{}, // 依赖 MikanItem, TmdbItem，多对多关联 BangumiParse
@@ -54,8 +197,56 @@ func NewDB(dsn *string) (*DB, error) {
 		fmt.Println("Error migrating database:", err)
 		return nil, err
 	}
+	// 执行数据迁移
+	if err := runMigrations(gormDB, migrations); err != nil {
+		slog.Error("[database] 数据库迁移失败", "error", err)
+		return nil, err
+	}
 
-	return &DB{DB: gormDB}, nil
+	return &DB{DB: gormDB, events: eventbus.NewEventBus(), cache: cache}, nil
+}
+
+// setupReadOnlyDB 只注册错误回调, 不建表也不迁移
+// 数据库版本比程序旧时只提示, 读取新加的列或表可能会失败
+func setupReadOnlyDB(gormDB *gorm.DB, name string) (*DB, error) {
+	if err := registerErrorCallbacks(gormDB); err != nil {
+		return nil, err
+	}
+	cache := newQueryCache()
+	if err := registerCacheCallbacks(gormDB, cache); err != nil {
+		return nil, err
+	}
+	if pending, err := pendingMigrations(gormDB, migrations); err == nil && len(pending) > 0 {
+		slog.Warn("[database] 只读打开的数据库还有未执行的迁移", "path", name, "待执行迁移", len(pending))
+	}
+	slog.Info("数据库以只读方式打开", slog.String("path", name))
+	return &DB{DB: gormDB, events: eventbus.NewEventBus(), readOnly: true, cache: cache}, nil
+}
+
+// ReadOnly 是否以只读方式打开
+func (db *DB) ReadOnly() bool {
+	return db.readOnly
+}
+
+// IntegrityCheck 检查数据库文件是否损坏, 返回发现的问题, 没有问题时返回空
+// 只支持 sqlite(PRAGMA integrity_check), 会读取整个数据库, 大库上比较慢
+func (db *DB) IntegrityCheck(ctx context.Context) ([]string, error) {
+	if db.Dialector.Name() != DriverSQLite {
+		return nil, ErrIntegrityUnsupported
+	}
+	var rows []string
+	if err := db.WithContext(ctx).Raw("PRAGMA integrity_check").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 1 && rows[0] == "ok" {
+		return nil, nil
+	}
+	return rows, nil
+}
+
+// Ping 检查数据库连接是否可用
+func (db *DB) Ping(ctx context.Context) error {
+	return db.WithContext(ctx).Exec("SELECT 1").Error
 }
 
 // Close 关闭数据库连接
@@ -67,6 +258,27 @@ func (db *DB) Close() error {
 	return sqlDB.Close()
 }
 
+// Transaction 在事务中执行 fn, fn 返回 nil 时提交, 返回错误或 panic 时回滚
+// tx 同样是 *DB, 可以直接调用已有的方法, 各方法内的 WithContext 不会脱离事务
+// 事务中产生的事件在提交后才发布
+func (db *DB) Transaction(ctx context.Context, fn func(tx *DB) error) error {
+	var pending []any
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&DB{DB: tx, events: db.events, pending: &pending, readOnly: db.readOnly, cache: db.cache})
+	})
+	if err != nil {
+		return err
+	}
+	// 事务提交前其他连接可能把旧数据读进了缓存
+	if db.cache != nil {
+		db.cache.invalidate("")
+	}
+	for _, ev := range pending {
+		db.publish(ev)
+	}
+	return nil
+}
+
 // ============ Torrent 相关方法 ============
 
 // UpdateTorrent 更新种子
@@ -85,12 +297,32 @@ func (db *DB) GetTorrentByID(ctx context.Context, id uint) (*model.Torrent, erro
 }
 
 // GetTorrentByURL 根据 URL 获取种子
+// 开启查询缓存时结果会被缓存, 包括不存在的链接
 func (db *DB) GetTorrentByURL(ctx context.Context, url string) (*model.Torrent, error) {
+	var generation uint64
+	if db.cacheable() {
+		cached, gen, ok := db.cache.torrents.get(url)
+		if ok {
+			if cached == nil {
+				return nil, fmt.Errorf("%w: %w", ErrNotFound, gorm.ErrRecordNotFound)
+			}
+			torrent := *cached
+			return &torrent, nil
+		}
+		generation = gen
+	}
 	var torrent model.Torrent
-	err := db.WithContext(ctx).Where("Link = ?", url).First(&torrent).Error
+	err := db.WithContext(ctx).Where(torrentLink(url)).First(&torrent).Error
+	if errors.Is(err, ErrNotFound) && db.cacheable() {
+		db.cache.torrents.set(url, nil, generation)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if db.cacheable() {
+		cached := torrent
+		db.cache.torrents.set(url, &cached, generation)
+	}
 	return &torrent, nil
 }
 
@@ -104,37 +336,88 @@ func (db *DB) GetTorrentByDownloadUID(ctx context.Context, duid string) (*model.
 	return &torrent, nil
 }
 
-// ListTorrentByBangumi 根据番剧信息获取种子列表
+// ListTorrentByBangumi 根据番剧信息获取种子列表, 番剧按 idx_bangumi_title 索引查找
+// 种子表没有番剧的标题和季度, 通过 bangumi_id 关联番剧表
 func (db *DB) ListTorrentByBangumi(ctx context.Context, title string, season int, rssLink string) ([]*model.Torrent, error) {
 	var torrents []*model.Torrent
-	err := db.WithContext(ctx).Where("bangumi_official_title = ? AND bangumi_season = ? AND rss_link = ?",
-		title, season, rssLink).Find(&torrents).Error
+	err := db.WithContext(ctx).Where("bangumi_id IN (?)",
+		db.WithContext(ctx).Model(&model.Bangumi{}).Select("id").
+			Where("official_title = ? AND season = ? AND rss_link = ?", title, season, rssLink),
+	).Find(&torrents).Error
 	return torrents, err
 }
 
+// ListTorrentByBangumiID 根据番剧 ID 获取种子列表
+func (db *DB) ListTorrentByBangumiID(ctx context.Context, bangumiID int) ([]*model.Torrent, error) {
+	return db.ListTorrentFiltered(ctx, TorrentQuery{BangumiID: bangumiID, Ascending: true})
+}
+
 // FindUnrenamedTorrent 查询已下载但未重命名的种子
 func (db *DB) FindUnrenamedTorrent(ctx context.Context) ([]*model.Torrent, error) {
-	var torrents []*model.Torrent
-	err := db.WithContext(ctx).Where("downloaded = ? AND renamed = ?", model.DownloadDone, false).
-		Find(&torrents).Error
-	return torrents, err
+	renamed := false
+	return db.ListTorrentFiltered(ctx, TorrentQuery{
+		Statuses: []model.DownloadStatus{model.DownloadDone},
+		Renamed:  &renamed,
+	})
 }
 
 // CheckNewTorrents 检查新种子（不存在的种子）
+// 每 torrentBatchSize 个链接查询一次, 避免 RSS 种子多时逐条查询, 也不会超过 sqlite 的变量数限制
+// 同一个种子在不同订阅中的链接可能不同(例如组订阅和聚合订阅), 所以链接不存在时还按 info hash 去重:
+// 已经有相同 info hash 的种子时沿用已有的那个, 同一批中 info hash 相同的只保留第一个
 func (db *DB) CheckNewTorrents(ctx context.Context, torrents []*model.Torrent) ([]*model.Torrent, error) {
-	var newTorrents []*model.Torrent
+	links := make([]string, len(torrents))
+	for i, torrent := range torrents {
+		links[i] = torrent.Link
+	}
 
+	existing := make(map[string]struct{}, len(links))
+	for chunk := range slices.Chunk(links, torrentBatchSize) {
+		var found []string
+		err := db.WithContext(ctx).Model(&model.Torrent{}).Where(torrentLinks(chunk)).Pluck("Link", &found).Error
+		if err != nil {
+			slog.Error("[CheckNewTorrents]检查种子是否存在失败", "数量", len(chunk), "error", err)
+			return nil, err
+		}
+		for _, link := range found {
+			existing[link] = struct{}{}
+		}
+	}
+
+	var hashes []string
 	for _, torrent := range torrents {
-		existing, err := db.GetTorrentByURL(ctx, torrent.Link)
-		if err != nil && err != gorm.ErrRecordNotFound {
-			slog.Error("[CheckNewTorrents]检查种子是否存在失败", "URL", torrent.Link, "error", err)
+		if _, ok := existing[torrent.Link]; !ok && torrent.InfoHash != "" {
+			hashes = append(hashes, strings.ToLower(torrent.InfoHash))
+		}
+	}
+	existingHash := make(map[string]struct{}, len(hashes))
+	for chunk := range slices.Chunk(hashes, torrentBatchSize) {
+		var found []string
+		err := db.WithContext(ctx).Model(&model.Torrent{}).Where("info_hash IN ?", chunk).Pluck("info_hash", &found).Error
+		if err != nil {
+			slog.Error("[CheckNewTorrents]按 info hash 检查种子是否存在失败", "数量", len(chunk), "error", err)
 			return nil, err
 		}
+		for _, hash := range found {
+			existingHash[hash] = struct{}{}
+		}
+	}
+
+	var newTorrents []*model.Torrent
+	for _, torrent := range torrents {
 		// 不存在的种子
-		if existing == nil {
-			slog.Debug("[CheckNewTorrents]发现新种子", "URL", torrent.Link)
-			newTorrents = append(newTorrents, torrent)
+		if _, ok := existing[torrent.Link]; ok {
+			continue
+		}
+		if hash := strings.ToLower(torrent.InfoHash); hash != "" {
+			if _, ok := existingHash[hash]; ok {
+				slog.Debug("[CheckNewTorrents]相同 info hash 的种子已存在, 跳过", "URL", torrent.Link, "info hash", hash)
+				continue
+			}
+			existingHash[hash] = struct{}{}
 		}
+		slog.Debug("[CheckNewTorrents]发现新种子", "URL", torrent.Link)
+		newTorrents = append(newTorrents, torrent)
 	}
 
 	return newTorrents, nil
@@ -142,25 +425,25 @@ func (db *DB) CheckNewTorrents(ctx context.Context, torrents []*model.Torrent) (
 
 // DeleteTorrentByURL 根据 URL 删除种子
 func (db *DB) DeleteTorrentByURL(ctx context.Context, url string) error {
-	return db.WithContext(ctx).Where("Link = ?", url).Delete(&model.Torrent{}).Error
+	return db.deleteTorrents(ctx, torrentLink(url))
 }
 
 // DeleteTorrentByDownloadUID 根据下载 UID 删除种子
 func (db *DB) DeleteTorrentByDownloadUID(ctx context.Context, duid string) error {
-	return db.WithContext(ctx).Where("download_uid = ?", duid).Delete(&model.Torrent{}).Error
+	return db.deleteTorrents(ctx, "download_uid = ?", duid)
 }
 
 // ============ Mikan 关联方法 ============
 
-// CreateMikanItem 创建或更新 Mikan 项
+// CreateMikanItem 创建或更新 Mikan 项, 已存在时只覆盖非零字段, 见 upsertMerge
 func (db *DB) CreateMikanItem(ctx context.Context, item *model.MikanItem) error {
-	return db.WithContext(ctx).Save(item).Error
+	return db.upsertMerge(ctx, item)
 }
 
 // GetMikanItemByID 根据 MikanID 获取 Mikan 项
 func (db *DB) GetMikanItemByID(ctx context.Context, mikanID int) (*model.MikanItem, error) {
 	var item model.MikanItem
-	err := db.WithContext(ctx).Where("mikan_id = ?", mikanID).First(&item).Error
+	err := db.WithContext(ctx).Where("id = ?", mikanID).First(&item).Error
 	if err != nil {
 		return nil, err
 	}
@@ -174,31 +457,96 @@ func (db *DB) GetBangumisByMikanID(ctx context.Context, mikanID int) ([]*model.B
 	return bangumis, err
 }
 
-// UpdateBangumiMikan 更新 Bangumi 的 Mikan 关联
+// UpdateBangumiMikan 更新 Bangumi 的 Mikan 关联, 同时把 mikanID 映射到这个番剧
 func (db *DB) UpdateBangumiMikan(ctx context.Context, bangumiID uint, mikanID int) error {
-	return db.WithContext(ctx).Model(&model.Bangumi{}).
-		Where("id = ?", bangumiID).
-		Update("mikan_id", mikanID).Error
+	return db.Transaction(ctx, func(tx *DB) error {
+		if err := tx.WithContext(ctx).Model(&model.Bangumi{}).
+			Where("id = ?", bangumiID).
+			Update("mikan_id", mikanID).Error; err != nil {
+			return err
+		}
+		return tx.BindBangumiKeys(ctx, int(bangumiID), BangumiKeys{MikanID: mikanID})
+	})
 }
 
-// RemoveBangumiMikan 移除 Bangumi 的 Mikan 关联
+// RemoveBangumiMikan 移除 Bangumi 的 Mikan 关联和映射
 func (db *DB) RemoveBangumiMikan(ctx context.Context, bangumiID uint) error {
-	return db.WithContext(ctx).Model(&model.Bangumi{}).
-		Where("id = ?", bangumiID).
-		Update("mikan_id", nil).Error
+	return db.Transaction(ctx, func(tx *DB) error {
+		if err := tx.WithContext(ctx).Model(&model.Bangumi{}).
+			Where("id = ?", bangumiID).
+			Update("mikan_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.deleteBangumiMappings(ctx, int(bangumiID), &model.MikanMapping{})
+	})
 }
 
 // ============ TMDB 关联方法 ============
 
-// CreateTmdbItem 创建或更新 TMDB 项
+// CreateTmdbItem 创建或更新 TMDB 项, 已存在时只覆盖非零字段, 见 upsertMerge
 func (db *DB) CreateTmdbItem(ctx context.Context, item *model.TmdbItem) error {
-	return db.WithContext(ctx).Save(item).Error
+	return db.upsertMerge(ctx, item)
+}
+
+// upsertMerge 按主键写入元数据条目, 用于 MikanItem/TmdbItem
+// 后一次解析可能只拿到部分信息, 所以条目已存在时只用非零字段覆盖, 海报、评分等已有的信息不会被清空;
+// 写入用 ON CONFLICT 完成, 并发创建同一个条目时不会报重复. 写入后把合并的结果读回 item
+func (db *DB) upsertMerge(ctx context.Context, item any) error {
+	tx := db.WithContext(ctx)
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(item); err != nil {
+		return err
+	}
+	pk := stmt.Schema.PrioritizedPrimaryField
+	record := reflect.ValueOf(item)
+	if _, zero := pk.ValueOf(ctx, record); zero {
+		return &apperrors.ValidationError{Field: pk.Name, Reason: "ID 不能为空"}
+	}
+	// 零值字段不写入, 新条目使用列的默认值, 已有条目保留原值
+	values := map[string]any{}
+	var columns []string
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || !field.Creatable {
+			continue
+		}
+		value, zero := field.ValueOf(ctx, record)
+		if zero && !field.PrimaryKey {
+			continue
+		}
+		values[field.DBName] = value
+		if !field.PrimaryKey {
+			columns = append(columns, field.DBName)
+		}
+	}
+	onConflict := clause.OnConflict{Columns: []clause.Column{{Name: pk.DBName}}, DoNothing: true}
+	if len(columns) > 0 {
+		onConflict = clause.OnConflict{Columns: onConflict.Columns, DoUpdates: clause.AssignmentColumns(columns)}
+	}
+	if err := tx.Table(stmt.Schema.Table).Clauses(onConflict).Create(values).Error; err != nil {
+		return err
+	}
+	return tx.First(item).Error
+}
+
+// upsertBangumiItems 合并写入番剧关联的 Mikan 和 TMDB 条目, 写入番剧时关联对象只会在不存在时插入
+func (db *DB) upsertBangumiItems(ctx context.Context, bangumi *model.Bangumi) error {
+	if bangumi.MikanItem != nil && bangumi.MikanItem.ID != 0 {
+		if err := db.CreateMikanItem(ctx, bangumi.MikanItem); err != nil {
+			return err
+		}
+	}
+	if bangumi.TmdbItem != nil && bangumi.TmdbItem.ID != 0 {
+		if err := db.CreateTmdbItem(ctx, bangumi.TmdbItem); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetTmdbItemByID 根据 TmdbID 获取 TMDB 项
 func (db *DB) GetTmdbItemByID(ctx context.Context, tmdbID int) (*model.TmdbItem, error) {
 	var item model.TmdbItem
-	err := db.WithContext(ctx).Where("tmdb_id = ?", tmdbID).First(&item).Error
+	err := db.WithContext(ctx).Where("id = ?", tmdbID).First(&item).Error
 	if err != nil {
 		return nil, err
 	}
@@ -212,43 +560,225 @@ func (db *DB) GetBangumisByTmdbID(ctx context.Context, tmdbID int) ([]*model.Ban
 	return bangumis, err
 }
 
-// UpdateBangumiTmdb 更新 Bangumi 的 TMDB 关联
+// UpdateBangumiTmdb 更新 Bangumi 的 TMDB 关联, 同时把 tmdbID 和番剧的季度映射到这个番剧
 func (db *DB) UpdateBangumiTmdb(ctx context.Context, bangumiID uint, tmdbID int) error {
-	return db.WithContext(ctx).Model(&model.Bangumi{}).
-		Where("id = ?", bangumiID).
-		Update("tmdb_id", tmdbID).Error
+	return db.Transaction(ctx, func(tx *DB) error {
+		var bangumi model.Bangumi
+		if err := tx.WithContext(ctx).Select("id", "season").First(&bangumi, bangumiID).Error; err != nil {
+			return err
+		}
+		if err := tx.WithContext(ctx).Model(&model.Bangumi{}).
+			Where("id = ?", bangumiID).
+			Update("tmdb_id", tmdbID).Error; err != nil {
+			return err
+		}
+		return tx.BindBangumiKeys(ctx, bangumi.ID, BangumiKeys{TmdbID: tmdbID, Season: bangumi.Season})
+	})
 }
 
-// RemoveBangumiTmdb 移除 Bangumi 的 TMDB 关联
+// RemoveBangumiTmdb 移除 Bangumi 的 TMDB 关联和映射
 func (db *DB) RemoveBangumiTmdb(ctx context.Context, bangumiID uint) error {
-	return db.WithContext(ctx).Model(&model.Bangumi{}).
-		Where("id = ?", bangumiID).
-		Update("tmdb_id", nil).Error
+	return db.Transaction(ctx, func(tx *DB) error {
+		if err := tx.WithContext(ctx).Model(&model.Bangumi{}).
+			Where("id = ?", bangumiID).
+			Update("tmdb_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.deleteBangumiMappings(ctx, int(bangumiID), &model.TmdbMapping{})
+	})
 }
 
 // ============ BangumiParse 关联方法 ============
 
-// CreateBangumiParse 创建番剧解析器
+// CreateBangumiParse 创建番剧解析器, 番剧还没有这一季时一起创建, 见 model.Season
 func (db *DB) CreateBangumiParse(ctx context.Context, parser *model.EpisodeMetadata) error {
-	return db.WithContext(ctx).Save(parser).Error
+	if err := db.validateEpisodeMetadata(ctx, parser.BangumiID, parser); err != nil {
+		return err
+	}
+	return db.Transaction(ctx, func(tx *DB) error {
+		seasonID, err := tx.ensureSeason(ctx, parser.BangumiID, parser.Season)
+		if err != nil {
+			return err
+		}
+		parser.SeasonID = seasonID
+		return tx.WithContext(ctx).Save(parser).Error
+	})
 }
 
+// validateEpisodeMetadata 校验 EpisodeMetadata
+// 字幕组为空时, GetBangumiParseByTitle 的子串匹配会匹配任意种子,
+// 如果其他番剧已经有相同标题和季度且字幕组为空的记录, 会导致种子被随机分配, 这里直接拒绝
+func (db *DB) validateEpisodeMetadata(ctx context.Context, bangumiID int, metadata *model.EpisodeMetadata) error {
+	if err := metadata.Validate(); err != nil {
+		return err
+	}
+	if metadata.Group != "" {
+		return nil
+	}
+	var count int64
+	err := db.WithContext(ctx).Model(&model.EpisodeMetadata{}).
+		Where("title = ? AND season = ? AND "+db.quote("group")+" = '' AND bangumi_id <> ?", metadata.Title, metadata.Season, bangumiID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return &apperrors.ValidationError{
+			Field:  "Group",
+			Reason: fmt.Sprintf("字幕组为空, 与其他番剧的解析信息冲突 (标题: %s, 季度: %d)", metadata.Title, metadata.Season),
+		}
+	}
+	return nil
+}
+
+// BangumiCandidate 标题匹配到的一个番剧, Metadata 是匹配上的解析信息中得分最高的一条
+type BangumiCandidate struct {
+	Bangumi  *model.Bangumi
+	Metadata *model.EpisodeMetadata
+	Score    int
+}
+
+// 匹配得分的权重: 标题越长越具体, 其次看季度是否一致, 最后看字幕组, 前一项总能压过后面的
+const (
+	scoreTitleRune = 1000
+	scoreSeason    = 100
+	scoreGroupMax  = 99
+)
+
+// ListBangumiCandidates 返回标题和字幕组都是 torrentName 子串的所有番剧, 按得分从高到低排序
+// 番剧的别名(见 model.BangumiAlias)是 torrentName 子串时同样匹配
+// season 为种子解析出的季度, 为 0 时不参与打分; 同一个番剧有多条解析信息匹配时只保留得分最高的
+// 回收站中的番剧不参与匹配, 见 MatchDeletedBangumi
+// 开启查询缓存时结果会被缓存, 返回的是副本, 可以修改
+func (db *DB) ListBangumiCandidates(ctx context.Context, torrentName string, season int) ([]BangumiCandidate, error) {
+	if !db.cacheable() {
+		return db.listBangumiCandidates(ctx, torrentName, season, false)
+	}
+	key := strconv.Itoa(season) + "|" + torrentName
+	cached, generation, ok := db.cache.candidates.get(key)
+	if !ok {
+		candidates, err := db.listBangumiCandidates(ctx, torrentName, season, false)
+		if err != nil {
+			return nil, err
+		}
+		db.cache.candidates.set(key, candidates, generation)
+		cached = candidates
+	}
+	return copyCandidates(cached), nil
+}
+
+// copyCandidates 复制候选番剧, 避免调用方修改缓存中的对象
+func copyCandidates(candidates []BangumiCandidate) []BangumiCandidate {
+	if candidates == nil {
+		return nil
+	}
+	copied := make([]BangumiCandidate, len(candidates))
+	for i, c := range candidates {
+		bangumi, metadata := *c.Bangumi, *c.Metadata
+		copied[i] = BangumiCandidate{Bangumi: &bangumi, Metadata: &metadata, Score: c.Score}
+	}
+	return copied
+}
+
+// listBangumiCandidates 见 ListBangumiCandidates, deleted 为 true 时只匹配回收站中的番剧, 否则只匹配不在回收站中的
+func (db *DB) listBangumiCandidates(ctx context.Context, torrentName string, season int, deleted bool) ([]BangumiCandidate, error) {
+	var rows []*model.EpisodeMetadata
+	cond := db.containsSQL("?", "title") + " AND " + db.containsSQL("?", db.quote("group"))
+	if err := db.WithContext(ctx).Where(cond, torrentName, torrentName).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	var aliases []*model.BangumiAlias
+	if err := db.WithContext(ctx).Where(db.containsSQL("?", "title"), torrentName).Order("id").Find(&aliases).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 && len(aliases) == 0 {
+		return nil, nil
+	}
+
+	best := make(map[int]BangumiCandidate, len(rows))
+	for _, row := range rows {
+		score := utf8.RuneCountInString(row.Title)*scoreTitleRune + min(utf8.RuneCountInString(row.Group), scoreGroupMax)
+		if season > 0 && row.Season == season {
+			score += scoreSeason
+		}
+		if old, ok := best[row.BangumiID]; ok && old.Score >= score {
+			continue
+		}
+		best[row.BangumiID] = BangumiCandidate{Metadata: row, Score: score}
+	}
+
+	ids := make([]int, 0, len(best)+len(aliases))
+	for id := range best {
+		ids = append(ids, id)
+	}
+	for _, a := range aliases {
+		ids = append(ids, a.BangumiID)
+	}
+	var bangumis []*model.Bangumi
+	if err := db.WithContext(ctx).Where("id IN ? AND deleted = ?", ids, deleted).Find(&bangumis).Error; err != nil {
+		return nil, err
+	}
+	// 别名没有字幕组和季度, 按番剧的季度打分, 匹配上的解析信息用别名的标题代替
+	byID := make(map[int]*model.Bangumi, len(bangumis))
+	for _, b := range bangumis {
+		byID[b.ID] = b
+	}
+	for _, a := range aliases {
+		b, ok := byID[a.BangumiID]
+		if !ok {
+			continue
+		}
+		score := utf8.RuneCountInString(a.Title) * scoreTitleRune
+		if season > 0 && b.Season == season {
+			score += scoreSeason
+		}
+		if old, ok := best[b.ID]; ok && old.Score >= score {
+			continue
+		}
+		best[b.ID] = BangumiCandidate{Metadata: &model.EpisodeMetadata{Title: a.Title, Season: b.Season, BangumiID: b.ID}, Score: score}
+	}
+	// 解析信息指向已经不存在或不符合 deleted 的番剧时跳过
+	candidates := make([]BangumiCandidate, 0, len(bangumis))
+	for _, b := range bangumis {
+		c := best[b.ID]
+		c.Bangumi = b
+		candidates = append(candidates, c)
+	}
+	slices.SortFunc(candidates, func(a, b BangumiCandidate) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		return a.Bangumi.ID - b.Bangumi.ID
+	})
+	return candidates, nil
+}
+
+// GetBangumiParseByTitle 根据种子名找到得分最高的番剧, 见 ListBangumiCandidates
+// 要求 Title 和 Group 都在 torrentName 中出现, 或者番剧的某个别名在其中出现, 没有匹配时返回 ErrNotFound
+// 只有回收站中的番剧匹配时返回它(Deleted 为 true), 调用方据此跳过种子而不是重新创建番剧
 func (db *DB) GetBangumiParseByTitle(ctx context.Context, torrentName string) (*model.Bangumi, error) {
-	// 要求 Title 和 Group 都在 torrentName 中出现
-	// title 和 group 是 torrentName 的子串
-	var metaData model.EpisodeMetadata
-	err := db.WithContext(ctx).Where("instr(?, title) > 0 AND instr(?, `group`) > 0", torrentName, torrentName).First(&metaData).Error
+	candidates, err := db.ListBangumiCandidates(ctx, torrentName, 0)
 	if err != nil {
 		return nil, err
 	}
-	// 通过 id 获取 对应的bangumi
-	var bangumi model.Bangumi
-	err = db.WithContext(ctx).First(&bangumi, metaData.BangumiID).Error
+	if len(candidates) == 0 {
+		slog.Debug("[GetBangumiParseByTitle]根据标题没有找到番剧", "torrentName", torrentName)
+		return db.MatchDeletedBangumi(ctx, torrentName)
+	}
+	return candidates[0].Bangumi, nil
+}
+
+// MatchDeletedBangumi 在回收站中找标题匹配得分最高的番剧, 规则同 ListBangumiCandidates, 不缓存; 没有匹配时返回 ErrNotFound
+// 用来识别属于已删除番剧的种子, 这些种子应该跳过, 而不是当作新番剧或匹配不到的种子
+func (db *DB) MatchDeletedBangumi(ctx context.Context, torrentName string) (*model.Bangumi, error) {
+	candidates, err := db.listBangumiCandidates(ctx, torrentName, 0, true)
 	if err != nil {
-		slog.Debug("[GetBangumiParseByTitle]根据标题查询番剧解析器失败", "torrentName", torrentName, "error", err)
 		return nil, err
 	}
-	return &bangumi, nil
+	if len(candidates) == 0 {
+		return nil, ErrNotFound
+	}
+	return candidates[0].Bangumi, nil
 }
 
 // GetBangumiParseByID 根据 ID 获取番剧解析器
@@ -279,6 +809,30 @@ func (db *DB) GetParsesByBangumiID(ctx context.Context, bangumiID uint) ([]*mode
 	return parsers, err
 }
 
+// ListEpisodeMetadataByBangumiID 通过 bangumi_id 外键获取番剧的 EpisodeMetadata
+// 集数不落库, 所以按季度、字幕组排序, 同一组内按写入顺序;
+// 除主键外完全相同的记录(见 EpisodeMetadata.Key)只保留最早的一条
+func (db *DB) ListEpisodeMetadataByBangumiID(ctx context.Context, bangumiID int) ([]*model.EpisodeMetadata, error) {
+	var rows []*model.EpisodeMetadata
+	err := db.WithContext(ctx).Where("bangumi_id = ?", bangumiID).
+		Order("season").Order(db.quote("group")).Order("id").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(rows))
+	result := rows[:0]
+	for _, row := range rows {
+		key := row.Key()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
 // ============ Bangumi 复合查询方法 ============
 
 // GetBangumiWithDetails 获取 Bangumi 及其关联的 TMDB、Mikan、Parse 信息
@@ -312,6 +866,11 @@ func (db *DB) AddParsesToBangumi(ctx context.Context, bangumiID int, parsers []*
 	if err := db.WithContext(ctx).First(&bangumi, bangumiID).Error; err != nil {
 		return err
 	}
+	for _, parser := range parsers {
+		if err := db.validateEpisodeMetadata(ctx, bangumiID, parser); err != nil {
+			return err
+		}
+	}
 	return db.WithContext(ctx).Model(&bangumi).Association("EpisodeMetadata").Append(parsers)
 }
 
@@ -367,7 +926,7 @@ func (db *DB) GetTorrentWithDetails(ctx context.Context, url string) (*model.Tor
 	var torrent model.Torrent
 	err := db.WithContext(ctx).Preload("Bangumi").
 		Preload("BangumiParse").
-		Where("Link = ?", url).
+		Where(torrentLink(url)).
 		First(&torrent).Error
 	if err != nil {
 		return nil, err
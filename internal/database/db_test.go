@@ -0,0 +1,632 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/apperrors"
+	"goto-bangumi/internal/model"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestEpisodeMetadataValidation(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	first := model.Bangumi{OfficialTitle: "夏日口袋", Season: 1}
+	second := model.Bangumi{OfficialTitle: "夏日口袋 另一个", Season: 1}
+	for _, b := range []*model.Bangumi{&first, &second} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	t.Run("EmptyTitle", func(t *testing.T) {
+		err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "  ", Group: "LoliHouse", BangumiID: first.ID})
+		if !apperrors.IsValidationError(err) {
+			t.Fatalf("Expected ValidationError for empty title, got %v", err)
+		}
+	})
+
+	t.Run("TrimFields", func(t *testing.T) {
+		em := &model.EpisodeMetadata{Title: " Summer Pocket ", Group: " LoliHouse ", Season: 1, BangumiID: first.ID}
+		if err := db.CreateBangumiParse(ctx, em); err != nil {
+			t.Fatalf("CreateBangumiParse failed: %v", err)
+		}
+		if em.Title != "Summer Pocket" || em.Group != "LoliHouse" {
+			t.Fatalf("Expected trimmed fields, got title %q group %q", em.Title, em.Group)
+		}
+	})
+
+	t.Run("EmptyGroupCollision", func(t *testing.T) {
+		// 第一个番剧的空字幕组记录可以正常写入
+		em := &model.EpisodeMetadata{Title: "Summer Pocket", Season: 1, BangumiID: first.ID}
+		if err := db.CreateBangumiParse(ctx, em); err != nil {
+			t.Fatalf("CreateBangumiParse failed: %v", err)
+		}
+		// 同一个番剧再写入一次不算冲突
+		if err := db.AddParsesToBangumi(ctx, first.ID, []*model.EpisodeMetadata{
+			{Title: "Summer Pocket", Season: 1, Resolution: "1080p"},
+		}); err != nil {
+			t.Fatalf("AddParsesToBangumi to same bangumi failed: %v", err)
+		}
+		// 另一个番剧写入相同标题且字幕组为空的记录, 应该被拒绝
+		err := db.AddParsesToBangumi(ctx, second.ID, []*model.EpisodeMetadata{
+			{Title: "Summer Pocket", Season: 1},
+		})
+		if !apperrors.IsValidationError(err) {
+			t.Fatalf("Expected ValidationError for empty group collision, got %v", err)
+		}
+		// 带上字幕组后可以写入
+		if err := db.AddParsesToBangumi(ctx, second.ID, []*model.EpisodeMetadata{
+			{Title: "Summer Pocket", Season: 1, Group: "喵萌奶茶屋"},
+		}); err != nil {
+			t.Fatalf("AddParsesToBangumi with group failed: %v", err)
+		}
+		var count int64
+		db.Model(&model.EpisodeMetadata{}).Where("bangumi_id = ?", second.ID).Count(&count)
+		if count != 1 {
+			t.Fatalf("Expected 1 EpisodeMetadata for second bangumi, got %d", count)
+		}
+	})
+}
+
+func TestResolveDataDir(t *testing.T) {
+	t.Setenv(DataDirEnv, "")
+	if got := ResolveDataDir(""); got != DefaultDataDir {
+		t.Fatalf("Expected default data dir %q, got %q", DefaultDataDir, got)
+	}
+
+	envDir := t.TempDir()
+	t.Setenv(DataDirEnv, envDir)
+	if got := ResolveDataDir(""); got != envDir {
+		t.Fatalf("Expected env data dir %q, got %q", envDir, got)
+	}
+	// 显式传入的目录优先于环境变量
+	if got := ResolveDataDir("/explicit"); got != "/explicit" {
+		t.Fatalf("Expected explicit data dir, got %q", got)
+	}
+}
+
+func TestOpenWithEnvDataDir(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "instance-a")
+	t.Setenv(DataDirEnv, dataDir)
+
+	db, err := NewDB(nil)
+	if err != nil {
+		t.Fatalf("NewDB(nil) failed: %v", err)
+	}
+	defer db.Close()
+	if _, err := os.Stat(filepath.Join(dataDir, "data.db")); err != nil {
+		t.Fatalf("Expected database file under env data dir: %v", err)
+	}
+}
+
+func TestOpenUnwritableDataDir(t *testing.T) {
+	// 以普通文件作为父目录, 这样即使以 root 运行也无法创建
+	file := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	_, err := Open(filepath.Join(file, "data"))
+	if err == nil {
+		t.Fatal("Expected error for unwritable data dir")
+	}
+	if !strings.Contains(err.Error(), "数据目录") {
+		t.Fatalf("Expected clear data dir error, got %v", err)
+	}
+}
+
+// TestOpenReadOnly 程序运行时以只读方式打开同一个数据目录
+func TestOpenReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// 数据库不存在时不会创建任何文件
+	if _, err := Open(dir, WithReadOnly()); err == nil {
+		t.Fatal("Open() read-only without database error = nil")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("read-only open created files: %v", entries)
+	}
+
+	live, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer live.Close()
+	if err := live.Create(&model.Bangumi{OfficialTitle: "葬送的芙莉莲", Season: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dir, WithReadOnly())
+	if err != nil {
+		t.Fatalf("Open() read-only failed: %v", err)
+	}
+	defer db.Close()
+	if !db.ReadOnly() {
+		t.Error("ReadOnly() = false")
+	}
+	if err := db.Ping(ctx); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+	if bangumis, err := db.ListBangumi(ctx); err != nil || len(bangumis) != 1 {
+		t.Errorf("ListBangumi() = %d, %v, want 1", len(bangumis), err)
+	}
+	problems, err := db.IntegrityCheck(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("IntegrityCheck() = %v, %v, want no problems", problems, err)
+	}
+	err = db.Create(&model.Bangumi{OfficialTitle: "桃源暗鬼", Season: 1}).Error
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Create() on read-only database error = %v, want ErrReadOnly", err)
+	}
+
+	// 只读打开期间程序可以正常写入
+	if err := live.Create(&model.Bangumi{OfficialTitle: "桃源暗鬼", Season: 1}).Error; err != nil {
+		t.Errorf("Create() on live database error = %v", err)
+	}
+
+	if _, err := Connect(model.DatabaseConfig{Driver: "postgres", DSN: "host=localhost"}, "", WithReadOnly()); err == nil {
+		t.Error("Connect() postgres read-only error = nil")
+	}
+}
+
+// TestMemoryDBConcurrent 多个 goroutine 同时使用同一个内存数据库
+// 内存数据库每个连接都是独立的库, 连接池里多出来的连接会看不到已迁移的表
+func TestMemoryDBConcurrent(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	const workers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			torrent := &model.Torrent{
+				Link: fmt.Sprintf("https://mikanani.me/Download/%d.torrent", i),
+				Name: fmt.Sprintf("torrent %d", i),
+			}
+			if err := db.CreateTorrent(ctx, torrent); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := db.GetTorrentByURL(ctx, torrent.Link); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent access failed: %v", err)
+	}
+
+	var count int64
+	if err := db.Model(&model.Torrent{}).Count(&count).Error; err != nil {
+		t.Fatalf("Failed to count torrents: %v", err)
+	}
+	if count != workers {
+		t.Errorf("Expected %d torrents, got %d", workers, count)
+	}
+}
+
+func TestNewDBPragmas(t *testing.T) {
+	pragmas := func(t *testing.T, db *DB) (string, int, int) {
+		t.Helper()
+		var journal string
+		var synchronous, busy int
+		db.Raw("PRAGMA journal_mode").Scan(&journal)
+		db.Raw("PRAGMA synchronous").Scan(&synchronous)
+		db.Raw("PRAGMA busy_timeout").Scan(&busy)
+		return journal, synchronous, busy
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		db, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer db.Close()
+		// synchronous: 1 = NORMAL
+		if journal, synchronous, busy := pragmas(t, db); journal != "wal" || synchronous != 1 || busy != 5000 {
+			t.Errorf("pragmas = %s, %d, %d, want wal, 1, 5000", journal, synchronous, busy)
+		}
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.db")
+		db, err := NewDB(&path, WithJournalMode("delete"), WithSynchronous("full"),
+			WithBusyTimeout(2*time.Second), WithMaxOpenConns(3), WithJournalMode(""))
+		if err != nil {
+			t.Fatalf("NewDB failed: %v", err)
+		}
+		defer db.Close()
+		// synchronous: 2 = FULL
+		if journal, synchronous, busy := pragmas(t, db); journal != "delete" || synchronous != 2 || busy != 2000 {
+			t.Errorf("pragmas = %s, %d, %d, want delete, 2, 2000", journal, synchronous, busy)
+		}
+		sqlDB, _ := db.DB.DB()
+		if got := sqlDB.Stats().MaxOpenConnections; got != 3 {
+			t.Errorf("MaxOpenConnections = %d, want 3", got)
+		}
+	})
+
+	t.Run("DSNKeepsExisting", func(t *testing.T) {
+		got := defaultSQLiteOptions().dsn("data.db?_pragma=journal_mode(DELETE)", false)
+		want := "data.db?_pragma=journal_mode(DELETE)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)"
+		if got != want {
+			t.Errorf("dsn() = %s, want %s", got, want)
+		}
+		if got := defaultSQLiteOptions().dsn(":memory:", true); got != ":memory:?_pragma=busy_timeout(5000)" {
+			t.Errorf("dsn() for memory = %s", got)
+		}
+	})
+}
+
+func TestListEpisodeMetadataByBangumiID(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	target := model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	other := model.Bangumi{OfficialTitle: "弹珠汽水瓶里的千岁同学", Season: 1}
+	for _, b := range []*model.Bangumi{&target, &other} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	// 直接按外键写入, 不经过任何映射表
+	rows := []model.EpisodeMetadata{
+		{Title: "Make Heroine ga Oosugiru!", Season: 2, Group: "LoliHouse", BangumiID: target.ID},
+		{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "喵萌奶茶屋", Episode: 3, BangumiID: target.ID},
+		{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "LoliHouse", Episode: 5, BangumiID: target.ID},
+		// 与上一条仅集数不同, 应被去重
+		{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "LoliHouse", Episode: 6, BangumiID: target.ID},
+		{Title: "Chitose-kun wa Ramune Bin no Naka", Season: 1, Group: "LoliHouse", BangumiID: other.ID},
+	}
+	for i := range rows {
+		if err := db.Create(&rows[i]).Error; err != nil {
+			t.Fatalf("Failed to create episode metadata: %v", err)
+		}
+	}
+	if db.Migrator().HasTable("bangumi_parser_mappings") {
+		t.Fatal("unexpected mapping table")
+	}
+
+	got, err := db.ListEpisodeMetadataByBangumiID(ctx, target.ID)
+	if err != nil {
+		t.Fatalf("ListEpisodeMetadataByBangumiID() error = %v", err)
+	}
+	want := []struct {
+		season int
+		group  string
+	}{
+		{1, "LoliHouse"},
+		{1, "喵萌奶茶屋"},
+		{2, "LoliHouse"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Season != w.season || got[i].Group != w.group {
+			t.Errorf("row %d = (S%d, %s), want (S%d, %s)", i, got[i].Season, got[i].Group, w.season, w.group)
+		}
+		if got[i].BangumiID != target.ID {
+			t.Errorf("row %d BangumiID = %d, want %d", i, got[i].BangumiID, target.ID)
+		}
+	}
+	// 重复记录保留最早写入的一条
+	if got[0].ID != rows[2].ID {
+		t.Errorf("kept ID %d, want %d", got[0].ID, rows[2].ID)
+	}
+
+	empty, err := db.ListEpisodeMetadataByBangumiID(ctx, target.ID+100)
+	if err != nil {
+		t.Fatalf("ListEpisodeMetadataByBangumiID() error = %v", err)
+	}
+	if len(empty) != 0 {
+		t.Errorf("expected no rows for unknown bangumi, got %d", len(empty))
+	}
+}
+
+func TestListBangumiCandidates(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	spy := model.Bangumi{OfficialTitle: "SPY", Season: 1}
+	family := model.Bangumi{OfficialTitle: "间谍过家家", Season: 1}
+	family2 := model.Bangumi{OfficialTitle: "间谍过家家", Season: 2}
+	for _, b := range []*model.Bangumi{&spy, &family, &family2} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 先写入的短标题在旧的实现中总会先被匹配到
+	rows := []model.EpisodeMetadata{
+		{Title: "SPY", Season: 1, Group: "ANi", BangumiID: spy.ID},
+		{Title: "SPY×FAMILY", Season: 1, Group: "ANi", BangumiID: family.ID},
+		{Title: "SPY×FAMILY", Season: 2, Group: "ANi", BangumiID: family2.ID},
+	}
+	for i := range rows {
+		if err := db.Create(&rows[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name := "[ANi] SPY×FAMILY 第二季 - 05 [1080P][Baha][WEB-DL][AAC AVC][CHT].mp4"
+	candidates, err := db.ListBangumiCandidates(ctx, name, 2)
+	if err != nil {
+		t.Fatalf("ListBangumiCandidates() error = %v", err)
+	}
+	if len(candidates) != 3 {
+		t.Fatalf("ListBangumiCandidates() = %d candidates, want 3", len(candidates))
+	}
+	want := []int{family2.ID, family.ID, spy.ID}
+	for i, c := range candidates {
+		if c.Bangumi.ID != want[i] {
+			t.Errorf("candidates[%d] = %s S%d (score %d), want id %d", i, c.Bangumi.OfficialTitle, c.Bangumi.Season, c.Score, want[i])
+		}
+	}
+
+	// 不知道季度时两季得分相同, 按 ID 排序
+	got, err := db.GetBangumiParseByTitle(ctx, name)
+	if err != nil || got.ID != family.ID {
+		t.Errorf("GetBangumiParseByTitle() = %v, %v, want %d", got, err, family.ID)
+	}
+	if _, err := db.GetBangumiParseByTitle(ctx, "[ANi] Unknown - 01"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBangumiParseByTitle(unknown) error = %v, want ErrNotFound", err)
+	}
+
+	// 回收站中的番剧不参与匹配, 只有它匹配时 GetBangumiParseByTitle 返回它, 由调用方跳过
+	if err := db.DeleteBangumi(ctx, family2.ID); err != nil {
+		t.Fatal(err)
+	}
+	candidates, err = db.ListBangumiCandidates(ctx, name, 2)
+	if err != nil || len(candidates) != 2 || candidates[0].Bangumi.ID != family.ID {
+		t.Errorf("ListBangumiCandidates() after delete = %+v, %v, want family first without the deleted season", candidates, err)
+	}
+	if err := db.DeleteBangumi(ctx, spy.ID); err != nil {
+		t.Fatal(err)
+	}
+	spyName := "[ANi] SPY - 01 [1080P][Baha][WEB-DL][AAC AVC][CHT].mp4"
+	if got, err := db.GetBangumiParseByTitle(ctx, spyName); err != nil || got.ID != spy.ID || !got.Deleted {
+		t.Errorf("GetBangumiParseByTitle(deleted) = %+v, %v, want deleted %d", got, err, spy.ID)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	t.Run("Rollback", func(t *testing.T) {
+		errFail := fmt.Errorf("fail")
+		err := db.Transaction(ctx, func(tx *DB) error {
+			if err := tx.Save(&model.Bangumi{OfficialTitle: "回滚番剧", Season: 1}).Error; err != nil {
+				return err
+			}
+			if err := tx.CreateTorrent(ctx, &model.Torrent{Link: "magnet:?xt=urn:btih:ROLLBACK", Name: "rollback"}); err != nil {
+				return err
+			}
+			return errFail
+		})
+		if err != errFail {
+			t.Fatalf("Transaction() error = %v, want %v", err, errFail)
+		}
+		var count int64
+		db.Model(&model.Bangumi{}).Count(&count)
+		if count != 0 {
+			t.Errorf("bangumi count = %d, want 0", count)
+		}
+		if _, err := db.GetTorrentByURL(ctx, "magnet:?xt=urn:btih:ROLLBACK"); err == nil {
+			t.Error("torrent written in rolled back transaction")
+		}
+	})
+
+	t.Run("Commit", func(t *testing.T) {
+		err := db.Transaction(ctx, func(tx *DB) error {
+			return tx.CreateTorrent(ctx, &model.Torrent{Link: "magnet:?xt=urn:btih:COMMIT", Name: "commit"})
+		})
+		if err != nil {
+			t.Fatalf("Transaction() error = %v", err)
+		}
+		if _, err := db.GetTorrentByURL(ctx, "magnet:?xt=urn:btih:COMMIT"); err != nil {
+			t.Errorf("committed torrent not found: %v", err)
+		}
+	})
+}
+
+// TestOpenSharedCreateBangumi 两个连接共用同一个数据库文件, 模拟多个进程
+// 一个连接的事务未提交时, 另一个连接的 CreateBangumi 要等待, 之后查重能看到已提交的番剧
+func TestOpenSharedCreateBangumi(t *testing.T) {
+	dir := t.TempDir()
+	a, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer a.Close()
+	b, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer b.Close()
+	ctx := context.Background()
+
+	tmdbID := 241535
+	newBangumi := func(group string) *model.Bangumi {
+		return &model.Bangumi{
+			OfficialTitle:   "败犬女主太多了！",
+			Season:          1,
+			TmdbItem:        &model.TmdbItem{ID: tmdbID, Title: "败犬女主太多了！"},
+			EpisodeMetadata: []model.EpisodeMetadata{{Title: "Make Heroine ga Oosugiru!", Group: group}},
+		}
+	}
+
+	done := make(chan error, 1)
+	err = a.Transaction(ctx, func(tx *DB) error {
+		if err := tx.CreateBangumi(ctx, newBangumi("LoliHouse")); err != nil {
+			return err
+		}
+		go func() { done <- b.CreateBangumi(ctx, newBangumi("ANi")) }()
+		// 事务提交前另一个连接不能完成写入
+		select {
+		case err := <-done:
+			return fmt.Errorf("CreateBangumi on the other connection finished before commit: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("CreateBangumi() on second connection error = %v", err)
+	}
+
+	var bangumis []model.Bangumi
+	if err := a.Preload("EpisodeMetadata").Find(&bangumis).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(bangumis) != 1 || len(bangumis[0].EpisodeMetadata) != 2 {
+		t.Fatalf("got %d bangumi, want 1 with both metadata rows: %+v", len(bangumis), bangumis)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := Connect(model.DatabaseConfig{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("Connect() with default config failed: %v", err)
+	}
+	db.Close()
+
+	memory, err := Connect(model.DatabaseConfig{Driver: "SQLite", DSN: ":memory:"}, "")
+	if err != nil {
+		t.Fatalf("Connect() with sqlite dsn failed: %v", err)
+	}
+	defer memory.Close()
+	if _, err := memory.SchemaVersion(ctx); err != nil {
+		t.Errorf("SchemaVersion() error = %v", err)
+	}
+
+	if _, err := Connect(model.DatabaseConfig{Driver: "postgres"}, ""); err == nil {
+		t.Error("Connect() postgres without dsn error = nil")
+	}
+	if _, err := Connect(model.DatabaseConfig{Driver: "oracle", DSN: "x"}, ""); err == nil {
+		t.Error("Connect() with unknown driver error = nil")
+	}
+}
+
+// TestDialectSQL 不同数据库下子串匹配和关键字列名生成的 SQL, 只生成 SQL 不连接数据库
+func TestDialectSQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		dialector gorm.Dialector
+		want      string
+	}{
+		{"sqlite", sqlite.Open(":memory:"), "instr(?, title) > 0 AND instr(?, `group`) > 0"},
+		{"postgres", postgres.New(postgres.Config{DSN: "host=localhost"}), `strpos(?, title) > 0 AND strpos(?, "group") > 0`},
+		{"mysql", mysql.New(mysql.Config{DSN: "user@/db", SkipInitializeWithVersion: true}), "instr(?, title) > 0 AND instr(?, `group`) > 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDB, err := gorm.Open(tt.dialector, &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+			if err != nil {
+				t.Fatalf("gorm.Open failed: %v", err)
+			}
+			db := &DB{DB: gormDB}
+			got := db.containsSQL("?", "title") + " AND " + db.containsSQL("?", db.quote("group"))
+			if got != tt.want {
+				t.Errorf("condition = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMySQLDialectSQL mysql 的冲突更新使用 VALUES(列), key 这样的关键字列名需要引用
+func TestMySQLDialectSQL(t *testing.T) {
+	gormDB, err := gorm.Open(mysql.New(mysql.Config{DSN: "user@/db", SkipInitializeWithVersion: true}), &gorm.Config{
+		DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open failed: %v", err)
+	}
+	var statements []string
+	capture := func(tx *gorm.DB) { statements = append(statements, tx.Statement.SQL.String()) }
+	if err := gormDB.Callback().Create().After("gorm:create").Register("test:capture", capture); err != nil {
+		t.Fatal(err)
+	}
+	if err := gormDB.Callback().Query().After("gorm:query").Register("test:capture", capture); err != nil {
+		t.Fatal(err)
+	}
+	if err := gormDB.Callback().Delete().After("gorm:delete").Register("test:capture", capture); err != nil {
+		t.Fatal(err)
+	}
+	db := &DB{DB: gormDB}
+	ctx := context.Background()
+
+	_ = db.CreateTorrent(ctx, &model.Torrent{Link: "magnet:?xt=urn:btih:MYSQL", Name: "mysql"})
+	_ = db.TrackEpisodes(ctx, 1, 1, []int{1}, "magnet:?xt=urn:btih:MYSQL")
+	_, _ = db.RecordEpisodeSearch(ctx, 1, 1, 2)
+	_, _ = db.GetResolveAttempt(ctx, "title|1")
+	_ = db.DeleteResolveAttempt(ctx, "title|1")
+	_, _ = db.GetMetadataLookup(ctx, "tmdb", "zh|title")
+
+	want := []string{
+		"ON DUPLICATE KEY UPDATE `description`=CASE WHEN VALUES(`description`) <> '' THEN VALUES(`description`) ELSE torrents.description END",
+		"COALESCE(NULLIF(VALUES(`pub_date`),",
+		"`state`=CASE WHEN episodes.state IN ('downloaded', 'renamed') THEN episodes.state ELSE VALUES(`state`) END",
+		"`updated_at`=VALUES(`updated_at`)",
+		"`search_attempts`=episodes.search_attempts + 1,`updated_at`=VALUES(`updated_at`)",
+		"WHERE `key` = ?",
+		"kind = ? AND `key` = ?",
+	}
+	all := strings.Join(statements, "\n")
+	for _, w := range want {
+		if !strings.Contains(all, w) {
+			t.Errorf("SQL does not contain %q:\n%s", w, all)
+		}
+	}
+	if strings.Contains(all, "excluded") {
+		t.Errorf("SQL uses excluded on mysql:\n%s", all)
+	}
+}
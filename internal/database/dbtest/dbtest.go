@@ -0,0 +1,25 @@
+// Package dbtest 为其他包的测试提供数据库, 只应该在 _test.go 中导入
+package dbtest
+
+import (
+	"testing"
+
+	"goto-bangumi/internal/database"
+)
+
+// New 创建测试用的内存数据库并依次加载 fixtures(见 database.LoadFixtures), 测试结束时自动关闭
+func New(t testing.TB, fixtures ...string) *database.DB {
+	t.Helper()
+	dsn := ":memory:"
+	db, err := database.NewDB(&dsn)
+	if err != nil {
+		t.Fatalf("创建测试数据库失败: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, path := range fixtures {
+		if err := database.LoadFixtures(db, path); err != nil {
+			t.Fatalf("加载测试数据失败: %v", err)
+		}
+	}
+	return db
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// 支持的数据库驱动
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// Connect 按配置连接数据库
+// sqlite 的 DSN 为空时使用数据目录下的 data.db(见 Open); postgres 和 mysql 必须提供 DSN,
+// mysql 的 DSN 需要带上 parseTime=True, 否则时间字段无法读取
+// opts 追加在配置之后, 例如 WithReadOnly, 只对 sqlite 生效
+func Connect(cfg model.DatabaseConfig, dataDir string, opts ...Option) (*DB, error) {
+	driver := strings.ToLower(strings.TrimSpace(cfg.Driver))
+	var dialector gorm.Dialector
+	switch driver {
+	case "", DriverSQLite:
+		sqliteOpts := []Option{
+			WithJournalMode(cfg.JournalMode),
+			WithBusyTimeout(time.Duration(cfg.BusyTimeout) * time.Millisecond),
+			WithSynchronous(cfg.Synchronous),
+			WithMaxOpenConns(cfg.MaxOpenConns),
+			WithMaxIdleConns(cfg.MaxIdleConns),
+		}
+		opts = append(sqliteOpts, opts...)
+		if cfg.DSN == "" {
+			return Open(dataDir, opts...)
+		}
+		dsn := cfg.DSN
+		return NewDB(&dsn, opts...)
+	case DriverPostgres:
+		dialector = postgres.Open(cfg.DSN)
+	case DriverMySQL:
+		dialector = mysql.Open(cfg.DSN)
+	default:
+		return nil, fmt.Errorf("不支持的数据库驱动: %s", cfg.Driver)
+	}
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("数据库驱动 %s 需要配置 DSN", driver)
+	}
+	if readOnly(opts) {
+		return nil, fmt.Errorf("数据库驱动 %s 不支持只读打开", driver)
+	}
+
+	// sqlite 的错误由 wrapError 按错误信息识别, 其他驱动交给 gorm 转换成通用错误
+	gormDB, err := gorm.Open(dialector, &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接 %s 数据库失败: %w", driver, err)
+	}
+	// 只有 sqlite 会在迁移前自动备份, 其他数据库提醒用户自行备份
+	if gormDB.Migrator().HasTable(&model.Torrent{}) {
+		if pending, err := pendingMigrations(gormDB, migrations); err == nil && len(pending) > 0 {
+			slog.Warn("[database] 即将执行数据库迁移, 不会自动备份, 请确认已备份数据库", "驱动", driver, "待执行迁移", len(pending))
+		}
+	}
+	// DSN 里有密码, 日志只记录驱动名称
+	return setupDB(gormDB, driver)
+}
+
+// quote 按当前数据库的规则引用标识符, group 这样的关键字作为列名时必须引用
+func (db *DB) quote(name string) string {
+	return db.Statement.Quote(name)
+}
+
+// containsSQL 判断 sub 是否为 str 子串的 SQL 条件, 参数都是 SQL 表达式
+// postgres 没有 instr, 使用等价的 strpos
+func (db *DB) containsSQL(str, sub string) string {
+	if db.Dialector.Name() == DriverPostgres {
+		return fmt.Sprintf("strpos(%s, %s) > 0", str, sub)
+	}
+	return fmt.Sprintf("instr(%s, %s) > 0", str, sub)
+}
+
+// excludedColumn 冲突更新时要插入的行中的列, 用于 clause.OnConflict 的 DoUpdates 表达式
+// sqlite 和 postgres 为 excluded.列, mysql 为 VALUES(列)
+type excludedColumn string
+
+func (c excludedColumn) Build(builder clause.Builder) {
+	if stmt, ok := builder.(*gorm.Statement); ok && stmt.Dialector.Name() == DriverMySQL {
+		builder.WriteString("VALUES(")
+		builder.WriteQuoted(string(c))
+		builder.WriteByte(')')
+		return
+	}
+	builder.WriteQuoted(clause.Column{Table: "excluded", Name: string(c)})
+}
+
+// excludedIfSet 冲突更新时字符串列的新值不为空则使用新值, 否则保留 table 中的旧值
+func excludedIfSet(table, column string) clause.Expr {
+	return gorm.Expr("CASE WHEN ? <> '' THEN ? ELSE "+table+"."+column+" END", excludedColumn(column), excludedColumn(column))
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============ Episode 相关方法 ============
+
+// episodeKey 单集的唯一约束列, 见 model.Episode
+var episodeKey = []clause.Column{{Name: "bangumi_id"}, {Name: "season"}, {Name: "number"}}
+
+// TrackEpisodes 种子入队时把它覆盖的集数标记为下载中
+// 已经下载完成或重命名的集数保持不变, 同一集的其他种子(例如合集)入队不会让它退回下载中
+// 番剧还没有这一季时一起创建, 种子关联到这一季
+func (db *DB) TrackEpisodes(ctx context.Context, bangumiID, season int, numbers []int, link string) error {
+	if len(numbers) == 0 {
+		return nil
+	}
+	episodes := make([]*model.Episode, len(numbers))
+	for i, n := range numbers {
+		episodes[i] = &model.Episode{BangumiID: bangumiID, Season: season, Number: n, State: model.EpisodeDownloading, TorrentLink: link}
+	}
+	keep := "episodes.state IN ('" + string(model.EpisodeDownloaded) + "', '" + string(model.EpisodeRenamed) + "')"
+	err := db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: episodeKey,
+		DoUpdates: clause.Assignments(map[string]any{
+			"state":        gorm.Expr("CASE WHEN "+keep+" THEN episodes.state ELSE ? END", excludedColumn("state")),
+			"torrent_link": gorm.Expr("CASE WHEN "+keep+" THEN episodes.torrent_link ELSE ? END", excludedColumn("torrent_link")),
+			"updated_at":   clause.Column{Table: "excluded", Name: "updated_at"},
+		}),
+	}).Create(&episodes).Error
+	if err != nil {
+		return err
+	}
+	// 种子提供的是哪一季现在可以确定了
+	seasonID, err := db.ensureSeason(ctx, bangumiID, season)
+	if err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Model(&model.Torrent{}).Where(torrentLink(link)).Update("season_id", seasonID).Error
+}
+
+// SetEpisodeFile 记录重命名后的文件路径, 这一集标记为已重命名
+func (db *DB) SetEpisodeFile(ctx context.Context, bangumiID, season, number int, link, path string) error {
+	episode := &model.Episode{BangumiID: bangumiID, Season: season, Number: number,
+		State: model.EpisodeRenamed, TorrentLink: link, FilePath: path}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   episodeKey,
+		DoUpdates: clause.AssignmentColumns([]string{"state", "torrent_link", "file_path", "updated_at"}),
+	}).Create(episode).Error
+}
+
+// ListEpisodes 获取番剧每一集的下载情况, 按季度和集数排序
+func (db *DB) ListEpisodes(ctx context.Context, bangumiID int) ([]*model.Episode, error) {
+	var episodes []*model.Episode
+	err := db.WithContext(ctx).Where("bangumi_id = ?", bangumiID).
+		Order("season").Order("number").Find(&episodes).Error
+	return episodes, err
+}
+
+// syncEpisodes 种子状态变化后更新它提供的集数, from 为空时不限制原状态
+// 写入失败只记录日志, 不影响种子状态本身的修改
+func (db *DB) syncEpisodes(ctx context.Context, link string, state model.EpisodeState, from ...model.EpisodeState) {
+	query := db.WithContext(ctx).Model(&model.Episode{}).Where("torrent_link = ?", link)
+	if len(from) > 0 {
+		query = query.Where("state IN ?", from)
+	}
+	if err := query.Update("state", state).Error; err != nil {
+		slog.Warn("[database] 更新剧集状态失败", "link", link, "state", state, "error", err)
+	}
+}
+
+// RecordEpisodeSearch 缺集搜索没有找到这一集, 搜索次数加一并返回累计次数
+// 剧集表中还没有这一集时按缺失创建
+func (db *DB) RecordEpisodeSearch(ctx context.Context, bangumiID, season, number int) (int, error) {
+	episode := &model.Episode{BangumiID: bangumiID, Season: season, Number: number, State: model.EpisodeMissing, SearchAttempts: 1}
+	err := db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: episodeKey,
+		DoUpdates: clause.Assignments(map[string]any{
+			"search_attempts": gorm.Expr("episodes.search_attempts + 1"),
+			"updated_at":      clause.Column{Table: "excluded", Name: "updated_at"},
+		}),
+	}).Create(episode).Error
+	if err != nil {
+		return 0, err
+	}
+	var attempts int
+	err = db.WithContext(ctx).Model(&model.Episode{}).
+		Where("bangumi_id = ? AND season = ? AND number = ?", bangumiID, season, number).
+		Select("search_attempts").Scan(&attempts).Error
+	return attempts, err
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestEpisodeTracking(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{OfficialTitle: "葬送的芙莉莲", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	ep1 := "https://mikanani.me/Download/1.torrent"
+	ep2 := "https://mikanani.me/Download/2.torrent"
+	batch := "https://mikanani.me/Download/batch.torrent"
+	for _, link := range []string{ep1, ep2, batch} {
+		if err := db.CreateTorrent(ctx, &model.Torrent{Link: link, Name: link, BangumiID: bangumi.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第 1 集下载并重命名完成, 第 2 集下载失败
+	if err := db.TrackEpisodes(ctx, bangumi.ID, 1, []int{1}, ep1); err != nil {
+		t.Fatalf("TrackEpisodes() error = %v", err)
+	}
+	if err := db.TrackEpisodes(ctx, bangumi.ID, 1, []int{2}, ep2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTorrentDownload(ctx, ep1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetEpisodeFile(ctx, bangumi.ID, 1, 1, ep1, "葬送的芙莉莲 S01E01.mkv"); err != nil {
+		t.Fatalf("SetEpisodeFile() error = %v", err)
+	}
+	if err := db.TorrentRenamed(ctx, ep1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTorrentError(ctx, ep2); err != nil {
+		t.Fatal(err)
+	}
+
+	// 合集入队不会让已重命名的第 1 集退回下载中, 缺失的第 2 集和新的第 3 集改由合集提供
+	if err := db.TrackEpisodes(ctx, bangumi.ID, 1, []int{1, 2, 3}, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	episodes, err := db.ListEpisodes(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatalf("ListEpisodes() error = %v", err)
+	}
+	want := []struct {
+		state model.EpisodeState
+		link  string
+		path  string
+	}{
+		{model.EpisodeRenamed, ep1, "葬送的芙莉莲 S01E01.mkv"},
+		{model.EpisodeDownloading, batch, ""},
+		{model.EpisodeDownloading, batch, ""},
+	}
+	if len(episodes) != len(want) {
+		t.Fatalf("ListEpisodes() = %d episodes, want %d", len(episodes), len(want))
+	}
+	for i, e := range episodes {
+		if e.Number != i+1 || e.State != want[i].state || e.TorrentLink != want[i].link || e.FilePath != want[i].path {
+			t.Errorf("episodes[%d] = %+v, want %+v", i, e, want[i])
+		}
+	}
+
+	// 合集的种子被删除后, 它提供的集数变回缺失
+	if err := db.DeleteTorrent(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	episodes, _ = db.ListEpisodes(ctx, bangumi.ID)
+	for _, e := range episodes[1:] {
+		if e.State != model.EpisodeMissing {
+			t.Errorf("episode %d state after delete = %s, want missing", e.Number, e.State)
+		}
+	}
+	if episodes[0].State != model.EpisodeRenamed {
+		t.Errorf("episode 1 state after delete = %s, want renamed", episodes[0].State)
+	}
+
+	// 缺集搜索的次数累计在已有的缺失集数上, 没有记录的集数按缺失创建
+	for i, want := range []int{1, 2} {
+		if got, err := db.RecordEpisodeSearch(ctx, bangumi.ID, 1, 2); err != nil || got != want {
+			t.Fatalf("RecordEpisodeSearch() #%d = %d, %v, want %d", i, got, err, want)
+		}
+	}
+	if got, err := db.RecordEpisodeSearch(ctx, bangumi.ID, 1, 5); err != nil || got != 1 {
+		t.Fatalf("RecordEpisodeSearch(new) = %d, %v, want 1", got, err)
+	}
+	episodes, _ = db.ListEpisodes(ctx, bangumi.ID)
+	if last := episodes[len(episodes)-1]; last.Number != 5 || last.State != model.EpisodeMissing || last.SearchAttempts != 1 {
+		t.Errorf("new episode = %+v, want missing episode 5 with 1 attempt", last)
+	}
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// 数据库层的错误, 调用方通过 errors.Is 判断, 不需要依赖 gorm 的错误类型
+// 包装时保留了原始错误, errors.Is(err, gorm.ErrRecordNotFound) 依然成立
+var (
+	ErrNotFound   = errors.New("record not found")
+	ErrDuplicate  = errors.New("duplicate record")
+	ErrConstraint = errors.New("constraint violation")
+	// ErrConflict 记录在读取之后被其他地方修改过, 需要重新读取后再更新
+	ErrConflict = errors.New("update conflict")
+	// ErrReadOnly 数据库以只读方式打开, 见 WithReadOnly
+	ErrReadOnly = errors.New("database is read-only")
+	// ErrIntegrityUnsupported 当前数据库驱动不支持 IntegrityCheck
+	ErrIntegrityUnsupported = errors.New("只有 sqlite 支持完整性检查")
+)
+
+// wrapError 将 gorm/sqlite 的错误包装成数据库层的错误
+func wrapError(err error) error {
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrDuplicate) || errors.Is(err, ErrConstraint) || errors.Is(err, ErrReadOnly) {
+		return err
+	}
+	msg := err.Error()
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case errors.Is(err, gorm.ErrDuplicatedKey),
+		strings.Contains(msg, "UNIQUE constraint failed"),
+		strings.Contains(msg, "PRIMARY KEY constraint failed"):
+		return fmt.Errorf("%w: %w", ErrDuplicate, err)
+	case errors.Is(err, gorm.ErrForeignKeyViolated),
+		strings.Contains(msg, "constraint failed"):
+		return fmt.Errorf("%w: %w", ErrConstraint, err)
+	case strings.Contains(msg, "attempt to write a readonly database"):
+		return fmt.Errorf("%w: %w", ErrReadOnly, err)
+	}
+	return err
+}
+
+// registerErrorCallbacks 在 gorm 的每一类操作之后包装错误, 这样所有 DB 方法返回的都是包装后的错误
+func registerErrorCallbacks(db *gorm.DB) error {
+	wrap := func(tx *gorm.DB) {
+		if tx.Error != nil {
+			tx.Error = wrapError(tx.Error)
+		}
+	}
+	callbacks := db.Callback()
+	if err := callbacks.Create().After("gorm:create").Register("goto:wrap_error", wrap); err != nil {
+		return err
+	}
+	if err := callbacks.Query().After("gorm:query").Register("goto:wrap_error", wrap); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("goto:wrap_error", wrap); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").Register("goto:wrap_error", wrap); err != nil {
+		return err
+	}
+	if err := callbacks.Row().After("gorm:row").Register("goto:wrap_error", wrap); err != nil {
+		return err
+	}
+	return callbacks.Raw().After("gorm:raw").Register("goto:wrap_error", wrap)
+}
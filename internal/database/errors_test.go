@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+)
+
+func TestWrappedErrors(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := db.GetBangumiByID(ctx, 404)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetBangumiByID: expected ErrNotFound, got %v", err)
+		}
+		// 原始的 gorm 错误依然可以判断
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("GetBangumiByID: expected gorm.ErrRecordNotFound to be kept, got %v", err)
+		}
+		if _, err := db.GetTorrentByURL(ctx, "https://example.com/404.torrent"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetTorrentByURL: expected ErrNotFound, got %v", err)
+		}
+		if _, err := db.GetRSSByID(ctx, 404); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetRSSByID: expected ErrNotFound, got %v", err)
+		}
+		if _, err := db.GetBangumiWithDetails(ctx, 404); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetBangumiWithDetails: expected ErrNotFound, got %v", err)
+		}
+		if err := db.AddTorrentDownload(ctx, "https://example.com/404.torrent"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("AddTorrentDownload: expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		torrent := &model.Torrent{Link: "https://example.com/1.torrent", Name: "torrent"}
+		if err := db.Create(torrent).Error; err != nil {
+			t.Fatalf("Failed to create torrent: %v", err)
+		}
+		err := db.Create(&model.Torrent{Link: torrent.Link, Name: "again"}).Error
+		if !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("Create duplicate torrent: expected ErrDuplicate, got %v", err)
+		}
+		if errors.Is(err, ErrConstraint) {
+			t.Fatalf("Duplicate should not be reported as ErrConstraint: %v", err)
+		}
+	})
+
+	t.Run("Constraint", func(t *testing.T) {
+		if err := db.Exec("CREATE TABLE constraint_test (name TEXT NOT NULL)").Error; err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		err := db.Exec("INSERT INTO constraint_test (name) VALUES (NULL)").Error
+		if !errors.Is(err, ErrConstraint) {
+			t.Fatalf("Insert NULL: expected ErrConstraint, got %v", err)
+		}
+	})
+}
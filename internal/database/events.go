@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+
+	"goto-bangumi/internal/eventbus"
+	"goto-bangumi/internal/model"
+)
+
+// ============ 数据变更事件 ============
+// 外部模块通过 eventbus.Subscribe[T](db.Events(), ctx, buf) 订阅, 不需要轮询数据库
+// 事件总线是非阻塞的, 订阅者的 channel 满了会丢弃事件, 不会阻塞数据库写入
+
+// BangumiCreated 创建了新的番剧, 合并到已有番剧时不发布
+type BangumiCreated struct {
+	Bangumi model.Bangumi
+}
+
+// TorrentStatusChanged 种子的下载状态发生变化
+type TorrentStatusChanged struct {
+	Link   string
+	Status model.DownloadStatus
+}
+
+// BangumiDeleted DeleteBangumiDeep 删除了番剧, Purged 为 true 时已经彻底删除, 否则在回收站中
+// 要从下载器删除的种子记录在数据库中, 见 ListDownloadRemovals; 事件只用来尽快处理, 丢失时由定时重试补上
+type BangumiDeleted struct {
+	Bangumi model.Bangumi
+	Purged  bool
+}
+
+// RSSAdded 添加了新的 RSS 订阅
+type RSSAdded struct {
+	RSS model.RSSItem
+}
+
+// Events 返回数据库的事件总线
+func (db *DB) Events() eventbus.EventBus {
+	return db.events
+}
+
+// publish 发布事件, 事务中的事件在提交后才发布, 回滚时丢弃
+func (db *DB) publish(ev any) {
+	if db.pending != nil {
+		*db.pending = append(*db.pending, ev)
+		return
+	}
+	if db.events != nil {
+		db.events.Publish(context.Background(), ev)
+	}
+}
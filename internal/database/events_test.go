@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/eventbus"
+	"goto-bangumi/internal/model"
+)
+
+// receive 等待一个事件, 超时则失败
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	var zero T
+	return zero
+}
+
+func TestEvents(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	created, unsubscribe := eventbus.Subscribe[BangumiCreated](db.Events(), ctx, 4)
+	defer unsubscribe()
+	statuses, _ := eventbus.Subscribe[TorrentStatusChanged](db.Events(), ctx, 4)
+	rss, _ := eventbus.Subscribe[RSSAdded](db.Events(), ctx, 4)
+
+	t.Run("BangumiCreated", func(t *testing.T) {
+		bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+		if err := db.CreateBangumi(ctx, bangumi); err != nil {
+			t.Fatal(err)
+		}
+		ev := receive(t, created)
+		if ev.Bangumi.ID != bangumi.ID || ev.Bangumi.OfficialTitle != bangumi.OfficialTitle {
+			t.Errorf("BangumiCreated = %+v, want bangumi %d", ev, bangumi.ID)
+		}
+	})
+
+	t.Run("TorrentStatusChanged", func(t *testing.T) {
+		link := "magnet:?xt=urn:btih:EVENTS"
+		if err := db.CreateTorrent(ctx, &model.Torrent{Link: link, Name: "events"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddTorrentDownload(ctx, link); err != nil {
+			t.Fatal(err)
+		}
+		ev := receive(t, statuses)
+		if ev.Link != link || ev.Status != model.DownloadDone {
+			t.Errorf("TorrentStatusChanged = %+v, want %s done", ev, link)
+		}
+	})
+
+	t.Run("RSSAdded", func(t *testing.T) {
+		item := &model.RSSItem{Name: "败犬女主太多了！", Link: "https://mikanani.me/RSS/Bangumi?bangumiId=3391"}
+		if err := db.CreateRSS(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+		ev := receive(t, rss)
+		if ev.RSS.ID != item.ID {
+			t.Errorf("RSSAdded = %+v, want rss %d", ev, item.ID)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		errFail := errors.New("fail")
+		err := db.Transaction(ctx, func(tx *DB) error {
+			if err := tx.CreateBangumi(ctx, &model.Bangumi{OfficialTitle: "回滚番剧", Season: 1}); err != nil {
+				return err
+			}
+			return errFail
+		})
+		if !errors.Is(err, errFail) {
+			t.Fatalf("Transaction() error = %v", err)
+		}
+		select {
+		case ev := <-created:
+			t.Errorf("got %+v from rolled back transaction", ev)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+// TestEvents_SlowSubscriber 订阅者不读取时写入不会被阻塞
+func TestEvents_SlowSubscriber(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	_, unsubscribe := eventbus.Subscribe[RSSAdded](db.Events(), ctx, 1)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 10 {
+			item := &model.RSSItem{Name: "rss", Link: fmt.Sprintf("https://mikanani.me/RSS/Bangumi?bangumiId=%d", i)}
+			if err := db.CreateRSS(ctx, item); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("CreateRSS blocked by a slow subscriber")
+	}
+}
@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"goto-bangumi/internal/model"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// ============ 测试数据 ============
+
+// Fixtures 开发和测试用的数据文件, YAML 或 JSON, 字段名为模型的 JSON 字段名(不区分大小写)或列名
+// 文件中没有写的字段使用列的默认值, 写了的字段按原值写入, 包括 0 和 false
+// 番剧和种子之间通过 ID 关联, 需要关联时在文件中写明番剧的 id
+//
+//	bangumis:
+//	  - id: 1
+//	    official_title: 葬送的芙莉莲
+//	episode_metadata:
+//	  - {title: Sousou no Frieren, group: LoliHouse, bangumi_id: 1}
+//	torrents:
+//	  - {link: https://mikanani.me/Download/1.torrent, name: "[LoliHouse] Sousou no Frieren - 01", bangumi_id: 1}
+type Fixtures struct {
+	MikanItems      []map[string]any `json:"mikan_items"`
+	TmdbItems       []map[string]any `json:"tmdb_items"`
+	RSSItems        []map[string]any `json:"rss_items"`
+	Bangumis        []map[string]any `json:"bangumis"`
+	EpisodeMetadata []map[string]any `json:"episode_metadata"`
+	Torrents        []map[string]any `json:"torrents"`
+	Episodes        []map[string]any `json:"episodes"`
+	BangumiAliases  []map[string]any `json:"bangumi_aliases"`
+	Seasons         []map[string]any `json:"seasons"`
+}
+
+// LoadFixtures 把 path 中的数据写入数据库, 已有的数据保持不变, 整个文件在一个事务中写入
+func LoadFixtures(db *DB, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// JSON 是 YAML 的子集, 统一按 YAML 解析后再转换成 JSON, 这样字段名和类型转换都按 JSON 的规则
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("解析测试数据 %s 失败: %w", path, err)
+	}
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("解析测试数据 %s 失败: %w", path, err)
+	}
+	var fixtures Fixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return fmt.Errorf("解析测试数据 %s 失败: %w", path, err)
+	}
+
+	tables := []struct {
+		model any
+		rows  []map[string]any
+	}{
+		{&model.MikanItem{}, fixtures.MikanItems},
+		{&model.TmdbItem{}, fixtures.TmdbItems},
+		{&model.RSSItem{}, fixtures.RSSItems},
+		{&model.Bangumi{}, fixtures.Bangumis},
+		{&model.EpisodeMetadata{}, fixtures.EpisodeMetadata},
+		{&model.Torrent{}, fixtures.Torrents},
+		{&model.Episode{}, fixtures.Episodes},
+		{&model.BangumiAlias{}, fixtures.BangumiAliases},
+		{&model.Season{}, fixtures.Seasons},
+	}
+	err = db.WithContext(context.Background()).Transaction(func(tx *gorm.DB) error {
+		models := make([]any, 0, len(tables))
+		for _, table := range tables {
+			if err := insertFixtures(tx, table.model, table.rows); err != nil {
+				return err
+			}
+			models = append(models, table.model)
+		}
+		if err := fillBangumiMappings(tx); err != nil {
+			return err
+		}
+		if err := syncSeasons(tx); err != nil {
+			return err
+		}
+		if tx.Dialector.Name() == DriverPostgres {
+			return resetSequences(tx, models)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("写入测试数据 %s 失败: %w", path, err)
+	}
+	return nil
+}
+
+// insertFixtures 逐行写入一张表, 每行先解码成模型检查类型, 再只写入文件中出现的列
+func insertFixtures(tx *gorm.DB, table any, rows []map[string]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(table); err != nil {
+		return err
+	}
+	typ := reflect.TypeOf(table).Elem()
+	now := time.Now()
+	for i, row := range rows {
+		// 字段名可以是 JSON 字段名或列名, 统一转换成 JSON 字段名后再解码
+		normalized := make(map[string]any, len(row))
+		fields := make([]*schema.Field, 0, len(row))
+		for key, value := range row {
+			field := fixtureField(stmt.Schema, key)
+			if field == nil {
+				return fmt.Errorf("%s 第 %d 行: 未知字段 %s", stmt.Schema.Table, i+1, key)
+			}
+			normalized[jsonName(field)] = value
+			fields = append(fields, field)
+		}
+		data, err := json.Marshal(normalized)
+		if err != nil {
+			return err
+		}
+		record := reflect.New(typ)
+		if err := json.Unmarshal(data, record.Interface()); err != nil {
+			return fmt.Errorf("%s 第 %d 行: %w", stmt.Schema.Table, i+1, err)
+		}
+		values := make(map[string]any, len(row))
+		for _, field := range fields {
+			values[field.DBName], _ = field.ValueOf(tx.Statement.Context, record.Elem())
+		}
+		// 没有写创建时间和更新时间时和 gorm 一样使用当前时间
+		for _, field := range stmt.Schema.Fields {
+			if _, ok := values[field.DBName]; !ok && field.DBName != "" && (field.AutoCreateTime > 0 || field.AutoUpdateTime > 0) {
+				values[field.DBName] = now
+			}
+		}
+		if b, ok := record.Interface().(*model.Bangumi); ok {
+			values["title_alias"] = model.TitleAlias(b.OfficialTitle)
+		}
+		if err := tx.Table(stmt.Schema.Table).Create(values).Error; err != nil {
+			return fmt.Errorf("%s 第 %d 行: %w", stmt.Schema.Table, i+1, err)
+		}
+	}
+	return nil
+}
+
+// fixtureField 按 JSON 字段名(不区分大小写)或列名查找可以写入的字段
+func fixtureField(sch *schema.Schema, key string) *schema.Field {
+	for _, field := range sch.Fields {
+		if field.DBName == "" || !field.Creatable {
+			continue
+		}
+		if field.DBName == key || strings.EqualFold(jsonName(field), key) {
+			return field
+		}
+	}
+	return nil
+}
+
+// jsonName 字段在 JSON 中的名字, 没有 json 标签时为字段名
+func jsonName(field *schema.Field) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
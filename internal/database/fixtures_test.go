@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestLoadFixtures(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t, "testdata/fixtures.yaml")
+
+	rss, err := db.ListActiveRSS(ctx)
+	if err != nil || len(rss) != 1 || rss[0].Parse != "tmdb" {
+		t.Errorf("ListActiveRSS() = %+v, %v, want one item with default parser", rss, err)
+	}
+	// 写明的 0 不会被列的默认值覆盖, 没写的使用默认值
+	special, err := db.GetBangumiByID(ctx, 2)
+	if err != nil || special.Season != 0 {
+		t.Errorf("special = %+v, %v, want season 0", special, err)
+	}
+	bangumi, err := db.GetBangumiParseByTitle(ctx, "[LoliHouse] Sousou no Frieren - 03 [1080p]")
+	if err != nil || bangumi.ID != 1 || bangumi.Season != 1 || bangumi.TitleAlias == "" {
+		t.Errorf("GetBangumiParseByTitle() = %+v, %v", bangumi, err)
+	}
+	torrents, err := db.ListTorrentByBangumiID(ctx, 1)
+	if err != nil || len(torrents) != 2 {
+		t.Fatalf("ListTorrentByBangumiID() = %d, %v, want 2", len(torrents), err)
+	}
+	first, _ := db.GetTorrentByURL(ctx, "https://mikanani.me/Download/frieren-01.torrent")
+	if first.Downloaded != model.DownloadDone || !first.Renamed || first.CreatedAt.IsZero() {
+		t.Errorf("torrent = %+v", first)
+	}
+
+	// JSON 同样可以加载, 类型不对时报错且不写入
+	path := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(path, []byte(`{"bangumis": [{"official_title": "桃源暗鬼"}, {"season": "one"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFixtures(db, path); err == nil {
+		t.Error("LoadFixtures() with invalid season error = nil")
+	}
+	if bangumis, _ := db.ListBangumi(ctx); len(bangumis) != 2 {
+		t.Errorf("bangumi after failed load = %d, want 2", len(bangumis))
+	}
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+// ============ 下载历史相关方法 ============
+
+// RecordDownloadEvent 追加一条下载历史, bangumiID 为 0 时从种子记录中补上
+func (db *DB) RecordDownloadEvent(ctx context.Context, link string, bangumiID int, action model.DownloadAction, message string) error {
+	if bangumiID == 0 {
+		var ids []int
+		if err := db.WithContext(ctx).Model(&model.Torrent{}).Where(torrentLink(link)).
+			Limit(1).Pluck("bangumi_id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			bangumiID = ids[0]
+		}
+	}
+	event := &model.DownloadEvent{Link: link, BangumiID: bangumiID, Action: action, Message: message}
+	return db.WithContext(ctx).Create(event).Error
+}
+
+// recordDownloadEvent 状态变化后顺带记录历史, 历史写入失败只记录日志, 不影响状态本身的修改
+func (db *DB) recordDownloadEvent(ctx context.Context, link string, bangumiID int, action model.DownloadAction, message string) {
+	if err := db.RecordDownloadEvent(ctx, link, bangumiID, action, message); err != nil {
+		slog.Warn("[database] 记录下载历史失败", "link", link, "action", action, "error", err)
+	}
+}
+
+// DownloadEventQuery 下载历史的查询条件, 零值的条件不参与过滤
+type DownloadEventQuery struct {
+	Link      string
+	BangumiID int
+	Actions   []model.DownloadAction
+	Since     time.Time // 只返回这个时间之后的记录
+	Limit     int       // 不大于 0 时不限制
+}
+
+// ListDownloadEvents 按条件查询下载历史, 按时间倒序
+func (db *DB) ListDownloadEvents(ctx context.Context, opts DownloadEventQuery) ([]*model.DownloadEvent, error) {
+	query := db.WithContext(ctx).Model(&model.DownloadEvent{})
+	if opts.Link != "" {
+		query = query.Where("link = ?", opts.Link)
+	}
+	if opts.BangumiID != 0 {
+		query = query.Where("bangumi_id = ?", opts.BangumiID)
+	}
+	if len(opts.Actions) > 0 {
+		query = query.Where("action IN ?", opts.Actions)
+	}
+	if !opts.Since.IsZero() {
+		query = query.Where("created_at >= ?", opts.Since)
+	}
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+	var events []*model.DownloadEvent
+	// 同一秒内的记录按写入顺序排列
+	err := query.Order("created_at DESC").Order("id DESC").Find(&events).Error
+	return events, err
+}
+
+// PruneDownloadEvents 删除 before 之前的下载历史, 返回删除的条数
+func (db *DB) PruneDownloadEvents(ctx context.Context, before time.Time) (int64, error) {
+	result := db.WithContext(ctx).Where("created_at < ?", before).Delete(&model.DownloadEvent{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestDownloadEvents(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{OfficialTitle: "葬送的芙莉莲", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	ep6 := "https://mikanani.me/Download/6.torrent"
+	ep7 := "https://mikanani.me/Download/7.torrent"
+	for _, link := range []string{ep6, ep7} {
+		if err := db.CreateTorrent(ctx, &model.Torrent{Link: link, Name: link, BangumiID: bangumi.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第 6 集正常走完流程, 第 7 集下载失败后被删除
+	if err := db.RecordDownloadEvent(ctx, ep6, 0, model.ActionQueued, ""); err != nil {
+		t.Fatalf("RecordDownloadEvent() error = %v", err)
+	}
+	if err := db.AddTorrentDUID(ctx, ep6, "", "hash6"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTorrentDownload(ctx, ep6); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TorrentRenamed(ctx, ep6); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTorrentDUID(ctx, ep7, "", "hash7"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTorrentError(ctx, ep7); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordDownloadEvent(ctx, ep7, 0, model.ActionFailed, "下载超时"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteTorrentByDownloadUID(ctx, "hash7"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := db.ListDownloadEvents(ctx, DownloadEventQuery{Link: ep6})
+	if err != nil {
+		t.Fatalf("ListDownloadEvents() error = %v", err)
+	}
+	want := []model.DownloadAction{model.ActionRenamed, model.ActionDownloaded, model.ActionSent, model.ActionQueued}
+	if len(events) != len(want) {
+		t.Fatalf("ListDownloadEvents(ep6) = %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Action != want[i] || e.BangumiID != bangumi.ID {
+			t.Errorf("events[%d] = %s bangumi %d, want %s bangumi %d", i, e.Action, e.BangumiID, want[i], bangumi.ID)
+		}
+	}
+
+	// 种子记录删除后历史仍然保留
+	events, err = db.ListDownloadEvents(ctx, DownloadEventQuery{Link: ep7})
+	if err != nil || len(events) != 3 || events[0].Action != model.ActionDeleted || events[1].Message != "下载超时" {
+		t.Fatalf("ListDownloadEvents(ep7) = %+v, %v", events, err)
+	}
+	if events[0].BangumiID != bangumi.ID {
+		t.Errorf("deleted event bangumi = %d, want %d", events[0].BangumiID, bangumi.ID)
+	}
+
+	events, err = db.ListDownloadEvents(ctx, DownloadEventQuery{
+		BangumiID: bangumi.ID,
+		Actions:   []model.DownloadAction{model.ActionFailed, model.ActionDeleted},
+		Limit:     1,
+	})
+	if err != nil || len(events) != 1 || events[0].Action != model.ActionDeleted {
+		t.Errorf("ListDownloadEvents(actions, limit) = %+v, %v", events, err)
+	}
+	if events, _ := db.ListDownloadEvents(ctx, DownloadEventQuery{Since: time.Now().Add(time.Hour)}); len(events) != 0 {
+		t.Errorf("ListDownloadEvents(since future) = %d events, want 0", len(events))
+	}
+
+	n, err := db.PruneDownloadEvents(ctx, time.Now().Add(time.Minute))
+	if err != nil || n != 7 {
+		t.Errorf("PruneDownloadEvents() = %d, %v, want 7", n, err)
+	}
+	if events, _ := db.ListDownloadEvents(ctx, DownloadEventQuery{}); len(events) != 0 {
+		t.Errorf("events after prune = %d, want 0", len(events))
+	}
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============ 导出和导入 ============
+
+// LibraryVersion 导出文档的格式版本, 文档结构不兼容地修改时加一
+const LibraryVersion = 1
+
+// Library 导出的番剧库, 与数据库驱动无关, 可以在不同机器和不同数据库之间迁移
+// 只包含番剧相关的数据, 解析重试、元数据缓存和下载历史不会导出; 导入时保留目标库的下载历史, 按种子链接重新关联番剧
+type Library struct {
+	Version         int                      `json:"version"`
+	SchemaVersion   int                      `json:"schema_version"` // 导出时的数据库迁移版本, 仅供参考
+	ExportedAt      time.Time                `json:"exported_at"`
+	MikanItems      []*model.MikanItem       `json:"mikan_items"`
+	TmdbItems       []*model.TmdbItem        `json:"tmdb_items"`
+	Bangumis        []*model.Bangumi         `json:"bangumis"`
+	EpisodeMetadata []*model.EpisodeMetadata `json:"episode_metadata"`
+	RSSItems        []*model.RSSItem         `json:"rss_items"`
+	Torrents        []*model.Torrent         `json:"torrents"`
+	Episodes        []*model.Episode         `json:"episodes"`
+	BangumiAliases  []*model.BangumiAlias    `json:"bangumi_aliases"`
+	Seasons         []*model.Season          `json:"seasons"`
+	// BgmMappings bangumi.tv 条目没有记录在番剧上, 映射需要导出; mikan 和 tmdb 的映射导入时按番剧重新生成
+	BgmMappings []*model.BgmMapping `json:"bgm_mappings"`
+}
+
+// ExportLibrary 把番剧库写成 JSON 文档, 所有表在同一个读事务中读取, 得到的是一致的快照
+func (db *DB) ExportLibrary(ctx context.Context, w io.Writer) error {
+	lib := Library{Version: LibraryVersion, ExportedAt: time.Now()}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&lib.SchemaVersion).Error; err != nil {
+			return err
+		}
+		for _, dest := range []any{&lib.MikanItems, &lib.TmdbItems, &lib.Bangumis, &lib.EpisodeMetadata, &lib.RSSItems, &lib.BangumiAliases, &lib.Seasons} {
+			if err := tx.Order("id").Find(dest).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Order("bangumi_id, season, number").Find(&lib.Episodes).Error; err != nil {
+			return err
+		}
+		if err := tx.Order("bgm_id").Find(&lib.BgmMappings).Error; err != nil {
+			return err
+		}
+		return tx.Order(clause.OrderByColumn{Column: clause.Column{Name: "Link"}}).Find(&lib.Torrents).Error
+	})
+	if err != nil {
+		return fmt.Errorf("导出番剧库失败: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&lib); err != nil {
+		return fmt.Errorf("写入导出文档失败: %w", err)
+	}
+	slog.Info("[database] 导出番剧库", "番剧", len(lib.Bangumis), "种子", len(lib.Torrents))
+	return nil
+}
+
+// ImportLibrary 从 ExportLibrary 生成的文档恢复番剧库, 当前库中的同类数据会被全部替换
+// 导入在一个事务中完成, 文档无效或写入失败时保持原样; 字段按原值写入, 包括番剧 ID
+func (db *DB) ImportLibrary(ctx context.Context, r io.Reader) error {
+	var lib Library
+	if err := json.NewDecoder(r).Decode(&lib); err != nil {
+		return fmt.Errorf("解析导入文档失败: %w", err)
+	}
+	if lib.Version < 1 || lib.Version > LibraryVersion {
+		return fmt.Errorf("不支持的导入文档版本: %d", lib.Version)
+	}
+
+	// 通过 DB.Transaction 导入, 提交后清空查询缓存, 运行中的服务不会读到替换前的种子
+	err := db.Transaction(ctx, func(dbTx *DB) error {
+		tx := dbTx.DB
+		// 先删除引用其他表的数据, 写入时顺序相反
+		tables := []any{&model.BangumiAlias{}, &model.Season{}, &model.Episode{}, &model.Torrent{}, &model.EpisodeMetadata{}, &model.Bangumi{}, &model.RSSItem{}, &model.TmdbItem{}, &model.MikanItem{}}
+		for _, table := range append(slices.Clone(mappingTables), tables...) {
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(table).Error; err != nil {
+				return err
+			}
+		}
+		// 拼音别名不在文档中, 按写入时的规则重新生成
+		for _, b := range lib.Bangumis {
+			b.TitleAlias = model.TitleAlias(b.OfficialTitle)
+		}
+		for _, rows := range []any{lib.MikanItems, lib.TmdbItems, lib.RSSItems, lib.Bangumis, lib.EpisodeMetadata, lib.Torrents, lib.Seasons, lib.Episodes, lib.BangumiAliases, lib.BgmMappings} {
+			if err := insertRows(tx, rows); err != nil {
+				return err
+			}
+		}
+		// mikan 和 tmdb 的映射不在文档中, 按番剧的 mikan_id 和 tmdb_id 重新生成
+		if err := fillBangumiMappings(tx); err != nil {
+			return err
+		}
+		// 旧版本导出的文档没有季度, 按番剧、解析信息和剧集补全
+		if err := syncSeasons(tx); err != nil {
+			return err
+		}
+		if err := relinkDownloadEvents(tx); err != nil {
+			return err
+		}
+		if tx.Dialector.Name() == DriverPostgres {
+			return resetSequences(tx, tables)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("导入番剧库失败: %w", err)
+	}
+	slog.Info("[database] 导入番剧库", "番剧", len(lib.Bangumis), "种子", len(lib.Torrents), "导出时间", lib.ExportedAt)
+	return nil
+}
+
+// relinkDownloadEvents 下载历史不在文档中, 保留下来的历史记录的是替换前的番剧 ID
+// 按种子链接关联到导入的番剧, 链接不在导入的种子中时番剧 ID 清零, 不会指向导入后恰好同 ID 的其他番剧
+func relinkDownloadEvents(tx *gorm.DB) error {
+	bangumiID := tx.Model(&model.Torrent{}).Select("bangumi_id").
+		Where(clause.Eq{Column: clause.Column{Table: "torrents", Name: "Link"}, Value: clause.Column{Table: "download_events", Name: "link"}})
+	return tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Model(&model.DownloadEvent{}).
+		Update("bangumi_id", gorm.Expr("COALESCE((?), 0)", bangumiID)).Error
+}
+
+// insertRows 按原值批量写入一张表, 关联对象不会写入
+// 结构体写入时 gorm 会把零值字段替换成列的默认值(例如 Season 0 变成 1), 这里逐列转换成 map 写入, 保留零值
+func insertRows(tx *gorm.DB, rows any) error {
+	value := reflect.ValueOf(rows)
+	if value.Len() == 0 {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(rows); err != nil {
+		return err
+	}
+	values := make([]map[string]any, value.Len())
+	for i := range values {
+		row := make(map[string]any, len(stmt.Schema.DBNames))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || !field.Creatable {
+				continue
+			}
+			row[field.DBName], _ = field.ValueOf(tx.Statement.Context, value.Index(i))
+		}
+		values[i] = row
+	}
+	return tx.Table(stmt.Schema.Table).CreateInBatches(values, torrentBatchSize).Error
+}
+
+// resetSequences postgres 写入显式 ID 后自增序列不会前进, 把各表的序列设置到当前最大 ID
+func resetSequences(tx *gorm.DB, tables []any) error {
+	for _, table := range tables {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(table); err != nil {
+			return err
+		}
+		field := stmt.Schema.PrioritizedPrimaryField
+		if field == nil || !field.AutoIncrement {
+			continue
+		}
+		err := tx.Exec("SELECT setval(pg_get_serial_sequence(?, ?), COALESCE(MAX(?), 0) + 1, false) FROM ?",
+			stmt.Schema.Table, field.DBName, clause.Column{Name: field.DBName}, clause.Table{Name: stmt.Schema.Table}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestExportImportLibrary(t *testing.T) {
+	ctx := context.Background()
+	src, dst := ":memory:", ":memory:"
+	from, err := NewDB(&src)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer from.Close()
+	to, err := NewDB(&dst)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer to.Close()
+
+	tmdbID, mikanID := 241535, 3391
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1, Offset: -1, Completed: true,
+		TmdbItem: &model.TmdbItem{ID: tmdbID, Title: "败犬女主太多了！", EpisodeCount: 12}, MikanItem: &model.MikanItem{ID: mikanID}}
+	if err := from.Create(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	// Season 为 0 和 Enabled 为 false 是零值, 导入时不能被列的默认值覆盖
+	special := &model.Bangumi{OfficialTitle: "败犬女主太多了！ 特别篇"}
+	if err := from.Create(special).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Model(special).Update("season", 0).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := from.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "Make Heroine ga Oosugiru!", Season: 1, Group: "LoliHouse", BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+	rss := &model.RSSItem{Name: "mikan", Link: "https://mikanani.me/RSS/Bangumi?bangumiId=3391", Enabled: true}
+	if err := from.Create(rss).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := from.Model(rss).Update("enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := from.CreateTorrent(ctx, &model.Torrent{Link: "https://mikanani.me/Download/1.torrent", Name: "败犬 01",
+		BangumiID: bangumi.ID, Downloaded: model.DownloadDone, Renamed: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := from.Create(&model.BgmMapping{BgmID: 464376, BangumiID: bangumi.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 目标库中已有的数据会被替换
+	if err := to.Create(&model.Bangumi{OfficialTitle: "桃源暗鬼", Season: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 目标库的下载历史保留, 番剧 ID 按种子链接重新关联
+	tougenLink, link := "https://mikanani.me/Download/tougen.torrent", "https://mikanani.me/Download/1.torrent"
+	if err := to.RecordDownloadEvent(ctx, tougenLink, 1, model.ActionDownloaded, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.RecordDownloadEvent(ctx, link, 5, model.ActionQueued, ""); err != nil {
+		t.Fatal(err)
+	}
+	// 导入前缓存的查询结果在导入后失效
+	to.SetQueryCache(100, 0)
+	if _, err := to.GetTorrentByURL(ctx, link); err == nil {
+		t.Fatal("GetTorrentByURL() before import found the torrent")
+	}
+
+	var buf bytes.Buffer
+	if err := from.ExportLibrary(ctx, &buf); err != nil {
+		t.Fatalf("ExportLibrary() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `"version": 1`) {
+		t.Errorf("export missing version: %s", buf.String()[:min(buf.Len(), 200)])
+	}
+	if err := to.ImportLibrary(ctx, &buf); err != nil {
+		t.Fatalf("ImportLibrary() error = %v", err)
+	}
+
+	bangumis, err := to.ListBangumi(ctx)
+	if err != nil || len(bangumis) != 2 {
+		t.Fatalf("ListBangumi() after import = %d, %v, want 2", len(bangumis), err)
+	}
+	got, err := to.GetBangumiWithDetails(ctx, uint(bangumi.ID))
+	if err != nil {
+		t.Fatalf("GetBangumiWithDetails() error = %v", err)
+	}
+	if got.Offset != -1 || !got.Completed || got.TmdbItem == nil || got.TmdbItem.EpisodeCount != 12 || got.MikanItem == nil {
+		t.Errorf("imported bangumi = %+v", got)
+	}
+	if got.TitleAlias == "" {
+		t.Error("imported bangumi TitleAlias is empty")
+	}
+	if b, err := to.GetBangumiByID(ctx, special.ID); err != nil || b.Season != 0 {
+		t.Errorf("imported special = %+v, %v, want season 0", b, err)
+	}
+	var rssItems []model.RSSItem
+	if err := to.Find(&rssItems).Error; err != nil || len(rssItems) != 1 || rssItems[0].Enabled {
+		t.Errorf("imported rss = %+v, %v, want one disabled item", rssItems, err)
+	}
+	parse, err := to.GetBangumiParseByTitle(ctx, "[LoliHouse] Make Heroine ga Oosugiru! - 01 [1080p]")
+	if err != nil || parse.ID != bangumi.ID {
+		t.Errorf("GetBangumiParseByTitle() after import = %v, %v", parse, err)
+	}
+	torrent, err := to.GetTorrentByURL(ctx, "https://mikanani.me/Download/1.torrent")
+	if err != nil || torrent.Downloaded != model.DownloadDone || !torrent.Renamed || torrent.BangumiID != bangumi.ID {
+		t.Errorf("imported torrent = %+v, %v", torrent, err)
+	}
+
+	for _, tt := range []struct {
+		link      string
+		bangumiID int
+	}{{tougenLink, 0}, {link, bangumi.ID}} {
+		events, err := to.ListDownloadEvents(ctx, DownloadEventQuery{Link: tt.link})
+		if err != nil || len(events) != 1 || events[0].BangumiID != tt.bangumiID {
+			t.Errorf("download events of %s after import = %+v, %v, want bangumi %d", tt.link, events, err, tt.bangumiID)
+		}
+	}
+
+	var bgm model.BgmMapping
+	if err := to.Where("bgm_id = ?", 464376).First(&bgm).Error; err != nil || bgm.BangumiID != bangumi.ID {
+		t.Errorf("imported bgm mapping = %+v, %v, want bangumi %d", bgm, err, bangumi.ID)
+	}
+
+	// 导入后新建的番剧 ID 不会和导入的冲突
+	if err := to.Create(&model.Bangumi{OfficialTitle: "葬送的芙莉莲", Season: 1}).Error; err != nil {
+		t.Errorf("create after import error = %v", err)
+	}
+
+	if err := to.ImportLibrary(ctx, strings.NewReader(`{"version": 99}`)); err == nil {
+		t.Error("ImportLibrary() with unknown version error = nil")
+	}
+	if bangumis, _ := to.ListBangumi(ctx); len(bangumis) != 3 {
+		t.Errorf("bangumi after failed import = %d, want 3", len(bangumis))
+	}
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm/clause"
+)
+
+// ============ 元数据查询缓存相关方法 ============
+
+// GetMetadataLookup 获取元数据查询记录, 没有记录时返回 ErrNotFound
+func (db *DB) GetMetadataLookup(ctx context.Context, kind, key string) (*model.MetadataLookup, error) {
+	var lookup model.MetadataLookup
+	err := db.WithContext(ctx).Where("kind = ?", kind).Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).First(&lookup).Error
+	if err != nil {
+		return nil, err
+	}
+	return &lookup, nil
+}
+
+// SaveMetadataLookup 创建或更新元数据查询记录, 会刷新 UpdatedAt
+func (db *DB) SaveMetadataLookup(ctx context.Context, lookup *model.MetadataLookup) error {
+	return db.WithContext(ctx).Save(lookup).Error
+}
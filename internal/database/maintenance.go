@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"goto-bangumi/internal/model"
+)
+
+// ============ 维护相关方法 ============
+
+// CleanupReport 清理孤儿数据的结果, 各字段为删除的行数
+type CleanupReport struct {
+	TmdbItems       int64 `json:"tmdb_items"`
+	MikanItems      int64 `json:"mikan_items"`
+	EpisodeMetadata int64 `json:"episode_metadata"`
+	Torrents        int64 `json:"torrents"`
+	Episodes        int64 `json:"episodes"`
+	Lookups         int64 `json:"lookups"`
+	Mappings        int64 `json:"mappings"`
+	Aliases         int64 `json:"aliases"`
+	Seasons         int64 `json:"seasons"`
+}
+
+// CleanupOrphans 删除不再被任何番剧引用的 TmdbItem/MikanItem,
+// 以及 bangumi_id 指向不存在番剧的 EpisodeMetadata、Episode、季度、别名和外部 ID 映射, withTorrents 为 true 时同样清理种子
+// 软删除(deleted = true)的番剧行仍然存在, 它们的关联不算孤儿, 只有番剧被彻底删除后才会清理
+// 指向已删除条目的元数据查询缓存也会一并删除, ItemID 为 0 的记录是未命中的查询结果, 不指向任何条目, 由 TTL 控制过期而不在这里清理
+func (db *DB) CleanupOrphans(ctx context.Context, withTorrents bool) (CleanupReport, error) {
+	var report CleanupReport
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id NOT IN (?)",
+			tx.Model(&model.Bangumi{}).Select("tmdb_id").Where("tmdb_id IS NOT NULL"),
+		).Delete(&model.TmdbItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.TmdbItems = result.RowsAffected
+
+		result = tx.Where("id NOT IN (?)",
+			tx.Model(&model.Bangumi{}).Select("mikan_id").Where("mikan_id IS NOT NULL"),
+		).Delete(&model.MikanItem{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.MikanItems = result.RowsAffected
+
+		result = tx.Where("bangumi_id NOT IN (?)", tx.Model(&model.Bangumi{}).Select("id")).
+			Delete(&model.EpisodeMetadata{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.EpisodeMetadata = result.RowsAffected
+
+		result = tx.Where("bangumi_id NOT IN (?)", tx.Model(&model.Bangumi{}).Select("id")).
+			Delete(&model.Episode{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Episodes = result.RowsAffected
+
+		for _, table := range mappingTables {
+			result = tx.Where("bangumi_id NOT IN (?)", tx.Model(&model.Bangumi{}).Select("id")).Delete(table)
+			if result.Error != nil {
+				return result.Error
+			}
+			report.Mappings += result.RowsAffected
+		}
+
+		result = tx.Where("bangumi_id NOT IN (?)", tx.Model(&model.Bangumi{}).Select("id")).
+			Delete(&model.BangumiAlias{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Aliases = result.RowsAffected
+
+		result = tx.Where("bangumi_id NOT IN (?)", tx.Model(&model.Bangumi{}).Select("id")).
+			Delete(&model.Season{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Seasons = result.RowsAffected
+
+		if withTorrents {
+			// 没有关联番剧的种子(bangumi_id 为空或 0)不算孤儿
+			result = tx.Where("bangumi_id IS NOT NULL AND bangumi_id <> 0 AND bangumi_id NOT IN (?)",
+				tx.Model(&model.Bangumi{}).Select("id"),
+			).Delete(&model.Torrent{})
+			if result.Error != nil {
+				return result.Error
+			}
+			report.Torrents = result.RowsAffected
+		}
+
+		// 未命中的查询(item_id = 0)不是孤儿, 删除后 TTL 内会重复请求网络
+		result = tx.Where("item_id <> 0").
+			Where(tx.Where("kind = ? AND item_id NOT IN (?)", model.LookupTMDB, tx.Model(&model.TmdbItem{}).Select("id")).
+				Or("kind = ? AND item_id NOT IN (?)", model.LookupMikan, tx.Model(&model.MikanItem{}).Select("id"))).
+			Delete(&model.MetadataLookup{})
+		if result.Error != nil {
+			return result.Error
+		}
+		report.Lookups = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return CleanupReport{}, err
+	}
+	slog.Info("[database] 清理孤儿数据完成", "tmdb", report.TmdbItems, "mikan", report.MikanItems,
+		"episode_metadata", report.EpisodeMetadata, "torrents", report.Torrents, "episodes", report.Episodes, "lookups", report.Lookups, "mappings", report.Mappings, "aliases", report.Aliases, "seasons", report.Seasons)
+	return report, nil
+}
+
+// PruneTorrents 清理已完结番剧的旧种子记录, 每个番剧只保留最新的 keep 个, keep <= 0 时全部删除
+// 只处理已完结(Completed)且 before 之后没有新种子的番剧, 定时刷新不再检查它们的 RSS,
+// 删除种子记录不会导致重复下载; 番剧被重新激活后 RSS 中已删除的种子会被当作新种子
+// 仍在下载器中的种子(已发送但未完成)不会被删除, 返回删除的种子数量
+func (db *DB) PruneTorrents(ctx context.Context, before time.Time, keep int) (int64, error) {
+	var pruned int64
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ids []int
+		err := tx.Model(&model.Bangumi{}).
+			Where("completed = ? AND deleted = ?", true, false).
+			Where("id NOT IN (?)", tx.Model(&model.Torrent{}).Select("bangumi_id").
+				Where("bangumi_id IS NOT NULL AND created_at >= ?", before)).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			var kept []string
+			if keep > 0 {
+				err := tx.Model(&model.Torrent{}).Where("bangumi_id = ?", id).
+					Order("created_at DESC").Limit(keep).Pluck("Link", &kept).Error
+				if err != nil {
+					return err
+				}
+			}
+			query := tx.Where("bangumi_id = ? AND downloaded <> ?", id, model.DownloadSending)
+			if len(kept) > 0 {
+				query = query.Where(clause.Not(torrentLinks(kept)))
+			}
+			result := query.Delete(&model.Torrent{})
+			if result.Error != nil {
+				return result.Error
+			}
+			pruned += result.RowsAffected
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if pruned > 0 {
+		slog.Info("[database] 清理已完结番剧的旧种子", "数量", pruned)
+	}
+	return pruned, nil
+}
+
+// Optimize 更新查询优化器的统计信息, 并整理数据库文件回收删除数据后留下的空间
+// sqlite 的 VACUUM 会重写整个数据库文件, 期间其他写入会等待, 适合在低峰期执行
+func (db *DB) Optimize(ctx context.Context) error {
+	conn := db.WithContext(ctx)
+	var statements []string
+	switch db.Dialector.Name() {
+	case DriverSQLite:
+		statements = []string{"ANALYZE", "VACUUM"}
+	case DriverPostgres:
+		statements = []string{"VACUUM ANALYZE"}
+	case DriverMySQL:
+		tables, err := conn.Migrator().GetTables()
+		if err != nil {
+			return err
+		}
+		if len(tables) == 0 {
+			return nil
+		}
+		quoted := make([]string, len(tables))
+		for i, t := range tables {
+			quoted[i] = conn.Statement.Quote(t)
+		}
+		statements = []string{"OPTIMIZE TABLE " + strings.Join(quoted, ", ")}
+	default:
+		return fmt.Errorf("不支持的数据库驱动: %s", db.Dialector.Name())
+	}
+	for _, stmt := range statements {
+		if err := conn.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("执行 %s 失败: %w", stmt, err)
+		}
+	}
+	slog.Info("[database] 数据库整理完成")
+	return nil
+}
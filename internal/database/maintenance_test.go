@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestCleanupOrphans(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	// 被引用的条目: 一个正常番剧, 一个软删除的番剧
+	liveTmdb, liveMikan := 241535, 3391
+	softTmdb, softMikan := 261343, 3774
+	live := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1,
+		TmdbItem: &model.TmdbItem{ID: liveTmdb, Title: "败犬女主太多了！"}, MikanItem: &model.MikanItem{ID: liveMikan}}
+	soft := &model.Bangumi{OfficialTitle: "弹珠汽水瓶里的千岁同学", Season: 1, Deleted: true,
+		TmdbItem: &model.TmdbItem{ID: softTmdb, Title: "弹珠汽水瓶里的千岁同学"}, MikanItem: &model.MikanItem{ID: softMikan}}
+	purged := &model.Bangumi{OfficialTitle: "桃源暗鬼", Season: 1}
+	for _, b := range []*model.Bangumi{live, soft, purged} {
+		if err := db.Create(b).Error; err != nil {
+			t.Fatalf("Failed to create bangumi: %v", err)
+		}
+	}
+
+	// 孤儿: 没有番剧引用的条目, 以及指向被彻底删除的番剧的数据
+	if err := db.CreateTmdbItem(ctx, &model.TmdbItem{ID: 253811, Title: "桃源暗鬼"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateMikanItem(ctx, &model.MikanItem{ID: 3676}); err != nil {
+		t.Fatal(err)
+	}
+	metadata := []model.EpisodeMetadata{
+		{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse", BangumiID: live.ID},
+		{Title: "Chitose-kun", Group: "LoliHouse", BangumiID: soft.ID},
+		{Title: "Tougen Anki", Group: "LoliHouse", BangumiID: purged.ID},
+	}
+	for i := range metadata {
+		if err := db.Create(&metadata[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	torrents := []*model.Torrent{
+		{Link: "magnet:?xt=urn:btih:LIVE", Name: "live", BangumiID: live.ID},
+		{Link: "magnet:?xt=urn:btih:SOFT", Name: "soft", BangumiID: soft.ID},
+		{Link: "magnet:?xt=urn:btih:PURGED", Name: "purged", BangumiID: purged.ID},
+		{Link: "magnet:?xt=urn:btih:UNLINKED", Name: "unlinked"},
+	}
+	for _, torrent := range torrents {
+		if err := db.CreateTorrent(ctx, torrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SaveMetadataLookup(ctx, &model.MetadataLookup{Key: "zh|桃源暗鬼", Kind: model.LookupTMDB, ItemID: 253811}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveMetadataLookup(ctx, &model.MetadataLookup{Key: "zh|败犬女主太多了！", Kind: model.LookupTMDB, ItemID: liveTmdb}); err != nil {
+		t.Fatal(err)
+	}
+	// 未命中的查询结果不指向任何条目, 不算孤儿
+	if err := db.SaveMetadataLookup(ctx, &model.MetadataLookup{Key: "zh|不存在的番剧", Kind: model.LookupTMDB}); err != nil {
+		t.Fatal(err)
+	}
+	// 彻底删除番剧, 不经过软删除
+	if err := db.Exec("DELETE FROM bangumis WHERE id = ?", purged.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.CleanupOrphans(ctx, true)
+	if err != nil {
+		t.Fatalf("CleanupOrphans() error = %v", err)
+	}
+	want := CleanupReport{TmdbItems: 1, MikanItems: 1, EpisodeMetadata: 1, Torrents: 1, Lookups: 1}
+	if report != want {
+		t.Errorf("CleanupOrphans() = %+v, want %+v", report, want)
+	}
+
+	for _, id := range []int{liveTmdb, softTmdb} {
+		if _, err := db.GetTmdbItemByID(ctx, id); err != nil {
+			t.Errorf("referenced TmdbItem %d removed: %v", id, err)
+		}
+	}
+	for _, id := range []int{liveMikan, softMikan} {
+		if _, err := db.GetMikanItemByID(ctx, id); err != nil {
+			t.Errorf("referenced MikanItem %d removed: %v", id, err)
+		}
+	}
+	var metadataCount int64
+	db.Model(&model.EpisodeMetadata{}).Count(&metadataCount)
+	if metadataCount != 2 {
+		t.Errorf("EpisodeMetadata count = %d, want 2", metadataCount)
+	}
+	for _, link := range []string{"magnet:?xt=urn:btih:LIVE", "magnet:?xt=urn:btih:SOFT", "magnet:?xt=urn:btih:UNLINKED"} {
+		if _, err := db.GetTorrentByURL(ctx, link); err != nil {
+			t.Errorf("torrent %s removed: %v", link, err)
+		}
+	}
+	if _, err := db.GetMetadataLookup(ctx, model.LookupTMDB, "zh|败犬女主太多了！"); err != nil {
+		t.Errorf("lookup of referenced item removed: %v", err)
+	}
+	if _, err := db.GetMetadataLookup(ctx, model.LookupTMDB, "zh|不存在的番剧"); err != nil {
+		t.Errorf("negative lookup removed: %v", err)
+	}
+
+	// 再次清理没有可删除的数据
+	again, err := db.CleanupOrphans(ctx, true)
+	if err != nil {
+		t.Fatalf("CleanupOrphans() error = %v", err)
+	}
+	if again != (CleanupReport{}) {
+		t.Errorf("second CleanupOrphans() = %+v, want empty report", again)
+	}
+}
+
+func TestCleanupOrphans_KeepTorrents(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: "magnet:?xt=urn:btih:DANGLING", Name: "dangling", BangumiID: 42}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := db.CleanupOrphans(ctx, false)
+	if err != nil {
+		t.Fatalf("CleanupOrphans() error = %v", err)
+	}
+	if report.Torrents != 0 {
+		t.Errorf("Torrents = %d, want 0", report.Torrents)
+	}
+	if _, err := db.GetTorrentByURL(ctx, "magnet:?xt=urn:btih:DANGLING"); err != nil {
+		t.Errorf("torrent removed without withTorrents: %v", err)
+	}
+}
+
+func TestPruneTorrents(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	old := time.Now().AddDate(0, 0, -100)
+	finished := &model.Bangumi{OfficialTitle: "孤独摇滚！", Season: 1, Completed: true}
+	recent := &model.Bangumi{OfficialTitle: "葬送的芙莉莲", Season: 1, Completed: true}
+	airing := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	for _, b := range []*model.Bangumi{finished, recent, airing} {
+		if err := db.Create(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	torrents := []*model.Torrent{
+		{Link: "finished-01", BangumiID: finished.ID, Downloaded: model.DownloadDone, CreatedAt: old},
+		{Link: "finished-02", BangumiID: finished.ID, Downloaded: model.DownloadDone, CreatedAt: old.Add(time.Hour)},
+		{Link: "finished-03", BangumiID: finished.ID, Downloaded: model.DownloadDone, CreatedAt: old.Add(2 * time.Hour)},
+		// 仍在下载器中的种子不会被删除
+		{Link: "finished-sending", BangumiID: finished.ID, Downloaded: model.DownloadSending, CreatedAt: old},
+		{Link: "recent-01", BangumiID: recent.ID, Downloaded: model.DownloadDone, CreatedAt: old},
+		{Link: "recent-02", BangumiID: recent.ID, Downloaded: model.DownloadDone},
+		{Link: "airing-01", BangumiID: airing.ID, Downloaded: model.DownloadDone, CreatedAt: old},
+	}
+	if err := db.CreateTorrents(ctx, torrents); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.PruneTorrents(ctx, time.Now().AddDate(0, 0, -30), 1)
+	if err != nil {
+		t.Fatalf("PruneTorrents() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("PruneTorrents() = %d, want 2", n)
+	}
+	var links []string
+	if err := db.Model(&model.Torrent{}).Order("Link").Pluck("Link", &links).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"airing-01", "finished-03", "finished-sending", "recent-01", "recent-02"}
+	if len(links) != len(want) {
+		t.Fatalf("remaining torrents = %v, want %v", links, want)
+	}
+	for i := range want {
+		if links[i] != want[i] {
+			t.Errorf("remaining torrents = %v, want %v", links, want)
+			break
+		}
+	}
+
+	// keep 为 0 时删除全部已完成的种子
+	if n, err := db.PruneTorrents(ctx, time.Now().AddDate(0, 0, -30), 0); err != nil || n != 1 {
+		t.Errorf("PruneTorrents(keep 0) = %d, %v, want 1", n, err)
+	}
+	if err := db.Optimize(ctx); err != nil {
+		t.Errorf("Optimize() error = %v", err)
+	}
+}
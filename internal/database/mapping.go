@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============ 外部 ID 映射 ============
+
+// BangumiKeys 用来确定番剧的外部 ID, 为 0 的 ID 不参与查找
+// TmdbID 需要和 Season 一起使用, 见 model.TmdbMapping
+type BangumiKeys struct {
+	MikanID int
+	TmdbID  int
+	Season  int
+	BgmID   int
+}
+
+// bangumiKeys 从待创建的番剧中取出外部 ID, 关联对象和外键字段都可能只设置了一个
+func bangumiKeys(b *model.Bangumi) BangumiKeys {
+	keys := BangumiKeys{Season: b.Season}
+	if b.MikanID != nil {
+		keys.MikanID = *b.MikanID
+	} else if b.MikanItem != nil {
+		keys.MikanID = b.MikanItem.ID
+	}
+	if b.TmdbID != nil {
+		keys.TmdbID = *b.TmdbID
+	} else if b.TmdbItem != nil {
+		keys.TmdbID = b.TmdbItem.ID
+	}
+	return keys
+}
+
+// FindBangumiUID 按外部 ID 找到番剧, 没有任何映射时返回 ErrNotFound
+// 不同的 ID 指向不同番剧时把它们合并到最早创建的那个(见 MergeBangumi), 返回合并后的番剧
+func (db *DB) FindBangumiUID(ctx context.Context, keys BangumiKeys) (int, error) {
+	var uid int
+	err := db.Transaction(ctx, func(tx *DB) error {
+		var err error
+		uid, err = tx.resolveBangumiUID(ctx, keys)
+		return err
+	})
+	return uid, err
+}
+
+// resolveBangumiUID 见 FindBangumiUID, 需要在事务中调用
+func (db *DB) resolveBangumiUID(ctx context.Context, keys BangumiKeys) (int, error) {
+	var uids []int
+	lookup := func(dest any, query string, args ...any) error {
+		var ids []int
+		if err := db.WithContext(ctx).Model(dest).Where(query, args...).Pluck("bangumi_id", &ids).Error; err != nil {
+			return err
+		}
+		uids = append(uids, ids...)
+		return nil
+	}
+	if keys.MikanID != 0 {
+		if err := lookup(&model.MikanMapping{}, "mikan_id = ?", keys.MikanID); err != nil {
+			return 0, err
+		}
+	}
+	if keys.TmdbID != 0 {
+		if err := lookup(&model.TmdbMapping{}, "tmdb_id = ? AND season = ?", keys.TmdbID, keys.Season); err != nil {
+			return 0, err
+		}
+	}
+	if keys.BgmID != 0 {
+		if err := lookup(&model.BgmMapping{}, "bgm_id = ?", keys.BgmID); err != nil {
+			return 0, err
+		}
+	}
+	slices.Sort(uids)
+	uids = slices.Compact(uids)
+
+	// 映射指向已经不存在的番剧时忽略, 之后写入映射会覆盖它
+	var existing []int
+	if len(uids) > 0 {
+		if err := db.WithContext(ctx).Model(&model.Bangumi{}).Where("id IN ?", uids).Order("id").Pluck("id", &existing).Error; err != nil {
+			return 0, err
+		}
+	}
+	switch len(existing) {
+	case 0:
+		return 0, ErrNotFound
+	case 1:
+		return existing[0], nil
+	}
+	keep := existing[0]
+	for _, merge := range existing[1:] {
+		slog.Warn("[database] 外部 ID 指向了不同的番剧, 合并为同一个", "保留", keep, "合并", merge,
+			"MikanID", keys.MikanID, "TmdbID", keys.TmdbID, "季度", keys.Season, "BgmID", keys.BgmID)
+		if err := db.mergeBangumi(ctx, keep, merge); err != nil {
+			return 0, err
+		}
+	}
+	return keep, nil
+}
+
+// bangumiByTmdbID 找到 tmdb_id 映射到的番剧, 不区分季度, 用于把新的一季加入已有的番剧
+// 旧数据中每季是单独的番剧, 有多个时使用没有删除的、最早创建的那个; 没有时返回 ErrNotFound
+func (db *DB) bangumiByTmdbID(ctx context.Context, tmdbID int) (int, error) {
+	var ids []int
+	err := db.WithContext(ctx).Model(&model.Bangumi{}).
+		Where("id IN (?)", db.WithContext(ctx).Model(&model.TmdbMapping{}).Select("bangumi_id").Where("tmdb_id = ?", tmdbID)).
+		Where("deleted = ?", false).Order("id").Limit(1).Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, ErrNotFound
+	}
+	return ids[0], nil
+}
+
+// bindBangumiKeys 把外部 ID 映射到番剧, 映射已经存在时覆盖
+func (db *DB) bindBangumiKeys(ctx context.Context, bangumiID int, keys BangumiKeys, rssLink string) error {
+	upsert := func(value any, columns ...string) error {
+		pk := make([]clause.Column, len(columns))
+		for i, c := range columns {
+			pk[i] = clause.Column{Name: c}
+		}
+		return db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns:   pk,
+			DoUpdates: clause.AssignmentColumns([]string{"bangumi_id", "rss_link"}),
+		}).Create(value).Error
+	}
+	if keys.MikanID != 0 {
+		if err := upsert(&model.MikanMapping{MikanID: keys.MikanID, BangumiID: bangumiID, RSSLink: rssLink}, "mikan_id"); err != nil {
+			return err
+		}
+	}
+	if keys.TmdbID != 0 {
+		if err := upsert(&model.TmdbMapping{TmdbID: keys.TmdbID, Season: keys.Season, BangumiID: bangumiID, RSSLink: rssLink}, "tmdb_id", "season"); err != nil {
+			return err
+		}
+	}
+	if keys.BgmID != 0 {
+		if err := upsert(&model.BgmMapping{BgmID: keys.BgmID, BangumiID: bangumiID, RSSLink: rssLink}, "bgm_id"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BindBangumiKeys 把外部 ID 映射到番剧, 已经映射到其他番剧的 ID 会改为指向这个番剧
+func (db *DB) BindBangumiKeys(ctx context.Context, bangumiID int, keys BangumiKeys) error {
+	var bangumi model.Bangumi
+	if err := db.WithContext(ctx).Select("id", "rss_link").First(&bangumi, bangumiID).Error; err != nil {
+		return err
+	}
+	return db.bindBangumiKeys(ctx, bangumiID, keys, bangumi.RSSLink)
+}
+
+// RebuildBangumiMappings 按番剧表中的 mikan_id 和 tmdb_id 重建映射, bangumi.tv 的映射保持不变
+// 同一个 ID 有多个番剧时映射到没有删除的、最早创建的那个
+func (db *DB) RebuildBangumiMappings(ctx context.Context) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, table := range []any{&model.MikanMapping{}, &model.TmdbMapping{}} {
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(table).Error; err != nil {
+				return err
+			}
+		}
+		return fillBangumiMappings(tx)
+	})
+}
+
+// fillBangumiMappings 为还没有映射的 mikan_id 和 tmdb_id 补充映射, 已有的映射不变
+func fillBangumiMappings(tx *gorm.DB) error {
+	var bangumis []model.Bangumi
+	err := tx.Select("id", "season", "mikan_id", "tmdb_id", "rss_link").
+		Where("mikan_id IS NOT NULL OR tmdb_id IS NOT NULL").
+		Order("deleted").Order("id").Find(&bangumis).Error
+	if err != nil {
+		return err
+	}
+	for _, b := range bangumis {
+		if b.MikanID != nil && *b.MikanID != 0 {
+			m := &model.MikanMapping{MikanID: *b.MikanID, BangumiID: b.ID, RSSLink: b.RSSLink}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(m).Error; err != nil {
+				return err
+			}
+		}
+		if b.TmdbID != nil && *b.TmdbID != 0 {
+			m := &model.TmdbMapping{TmdbID: *b.TmdbID, Season: b.Season, BangumiID: b.ID, RSSLink: b.RSSLink}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(m).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteBangumiMappings 删除指向 bangumiID 的映射, 不存在时不报错
+func (db *DB) deleteBangumiMappings(ctx context.Context, bangumiID int, tables ...any) error {
+	for _, table := range tables {
+		err := db.WithContext(ctx).Where("bangumi_id = ?", bangumiID).Delete(table).Error
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// mappingTables 所有的映射表
+var mappingTables = []any{&model.MikanMapping{}, &model.TmdbMapping{}, &model.BgmMapping{}}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestBangumiMappings(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t)
+	intPtr := func(v int) *int { return &v }
+
+	// 同一个 TMDB ID 的新一季加入已有的番剧, 作为它的一个季度
+	s1 := &model.Bangumi{OfficialTitle: "我推的孩子", Season: 1, TmdbItem: &model.TmdbItem{ID: 203737}}
+	s2 := &model.Bangumi{OfficialTitle: "我推的孩子", Season: 2, TmdbItem: &model.TmdbItem{ID: 203737}}
+	for _, b := range []*model.Bangumi{s1, s2} {
+		if err := db.CreateBangumi(ctx, b); err != nil {
+			t.Fatalf("CreateBangumi() error = %v", err)
+		}
+	}
+	if uid, err := db.FindBangumiUID(ctx, BangumiKeys{TmdbID: 203737, Season: 2}); err != nil || uid != s1.ID {
+		t.Errorf("FindBangumiUID(tmdb season 2) = %d, %v, want %d", uid, err, s1.ID)
+	}
+	var count int64
+	db.Model(&model.Bangumi{}).Count(&count)
+	if count != 1 {
+		t.Errorf("bangumi count = %d, want 1", count)
+	}
+	if _, err := db.GetSeason(ctx, s1.ID, 2); err != nil {
+		t.Errorf("GetSeason(2) error = %v", err)
+	}
+
+	// 没有外部 ID 的番剧不会互相匹配
+	a := &model.Bangumi{OfficialTitle: "番剧 A", Season: 1}
+	b := &model.Bangumi{OfficialTitle: "番剧 B", Season: 1}
+	for _, bangumi := range []*model.Bangumi{a, b} {
+		if err := db.CreateBangumi(ctx, bangumi); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.ID == b.ID {
+		t.Errorf("bangumi without ids merged into %d", a.ID)
+	}
+
+	// mikan 先单独建了一个番剧, 之后同时带 mikan 和 tmdb 的番剧说明两者是同一个, 合并到较早的那个
+	byMikan := &model.Bangumi{OfficialTitle: "我推的孩子 第一季", Season: 1, MikanItem: &model.MikanItem{ID: 2995}}
+	if err := db.CreateBangumi(ctx, byMikan); err != nil {
+		t.Fatal(err)
+	}
+	both := &model.Bangumi{OfficialTitle: "我推的孩子", Season: 1, MikanItem: &model.MikanItem{ID: 2995}, TmdbItem: &model.TmdbItem{ID: 203737}}
+	if err := db.CreateBangumi(ctx, both); err != nil {
+		t.Fatalf("CreateBangumi() with colliding ids error = %v", err)
+	}
+	if uid, err := db.FindBangumiUID(ctx, BangumiKeys{MikanID: 2995}); err != nil || uid != s1.ID {
+		t.Errorf("FindBangumiUID(mikan) after merge = %d, %v, want %d", uid, err, s1.ID)
+	}
+	merged, _ := db.GetBangumiByID(ctx, byMikan.ID)
+	if !merged.Deleted {
+		t.Errorf("merged bangumi = %+v, want deleted", merged)
+	}
+	kept, _ := db.GetBangumiByID(ctx, s1.ID)
+	if kept.MikanID == nil || *kept.MikanID != 2995 {
+		t.Errorf("kept bangumi mikan_id = %v, want 2995", kept.MikanID)
+	}
+
+	// 旧数据只有番剧表中的 ID, 重建后可以通过映射找到
+	legacy := &model.Bangumi{OfficialTitle: "葬送的芙莉莲", Season: 1, MikanID: intPtr(3141)}
+	if err := db.Create(&model.MikanItem{ID: 3141}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.FindBangumiUID(ctx, BangumiKeys{MikanID: 3141}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("FindBangumiUID() before rebuild error = %v, want ErrNotFound", err)
+	}
+	if err := db.RebuildBangumiMappings(ctx); err != nil {
+		t.Fatalf("RebuildBangumiMappings() error = %v", err)
+	}
+	if uid, err := db.FindBangumiUID(ctx, BangumiKeys{MikanID: 3141}); err != nil || uid != legacy.ID {
+		t.Errorf("FindBangumiUID() after rebuild = %d, %v, want %d", uid, err, legacy.ID)
+	}
+
+	// 彻底删除番剧后映射一并清理
+	if err := db.DeleteBangumi(ctx, legacy.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, report, err := db.PurgeDeletedBangumi(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Mappings != 1 {
+		t.Errorf("purge report mappings = %d, want 1", report.Mappings)
+	}
+	if _, err := db.FindBangumiUID(ctx, BangumiKeys{MikanID: 3141}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindBangumiUID() after purge error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// SchemaMigration 记录已经执行过的迁移版本
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"default:''"`
+	AppliedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// Migration 一个迁移步骤
+// AutoMigrate 只负责建表和加列, 数据回填、字段改名这类操作放在这里
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+// migrations 按版本号顺序执行, 已发布的迁移不要修改, 只能追加
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "已重命名的种子标记为下载完成",
+		Up: func(tx *gorm.DB) error {
+			// 重命名一定发生在下载完成之后, 旧版本可能没有更新 downloaded
+			return tx.Model(&model.Torrent{}).
+				Where("renamed = ? AND downloaded <> ?", true, model.DownloadDone).
+				Update("downloaded", model.DownloadDone).Error
+		},
+	},
+	{
+		Version: 2,
+		Name:    "生成番剧标题的拼音别名",
+		Up: func(tx *gorm.DB) error {
+			var bangumis []model.Bangumi
+			if err := tx.Select("id", "official_title").Find(&bangumis).Error; err != nil {
+				return err
+			}
+			for _, b := range bangumis {
+				if err := tx.Model(&model.Bangumi{}).Where("id = ?", b.ID).
+					UpdateColumn("title_alias", model.TitleAlias(b.OfficialTitle)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "生成 mikan/tmdb 到番剧的映射",
+		Up: func(tx *gorm.DB) error {
+			// 之前按 mikan_id 或 tmdb_id 查重, 同一个 ID 可能有多个番剧, 映射到没有删除的、最早创建的那个
+			return fillBangumiMappings(tx)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "为番剧生成季度",
+		Up: func(tx *gorm.DB) error {
+			return syncSeasons(tx)
+		},
+	},
+}
+
+// pendingMigrations 返回还没有执行过的迁移, 按版本号排序
+// 还没有迁移记录表的数据库所有迁移都未执行
+func pendingMigrations(db *gorm.DB, steps []Migration) ([]Migration, error) {
+	done := make(map[int]struct{})
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		var applied []int
+		if err := db.Model(&SchemaMigration{}).Pluck("version", &applied).Error; err != nil {
+			return nil, err
+		}
+		for _, v := range applied {
+			done[v] = struct{}{}
+		}
+	}
+
+	var pending []Migration
+	for _, m := range steps {
+		if _, ok := done[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	return pending, nil
+}
+
+// runMigrations 执行所有未执行过的迁移, 每一步在独立的事务中执行并记录版本
+func runMigrations(db *gorm.DB, steps []Migration) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(db, steps)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		slog.Info("[database] 执行数据库迁移", "版本", m.Version, "名称", m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("迁移 %d (%s) 失败: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// backupBeforeMigrate 有待执行的迁移时先把 sqlite 数据库备份到 path 旁边, 返回备份文件的路径
+// 备份文件名带上当前的迁移版本, 例如 data.db.v1-20250829200000.bak, 迁移出错时可以直接替换回去
+// 新建的数据库(还没有任何表)没有需要保护的数据, 不会备份
+func backupBeforeMigrate(db *gorm.DB, path string, steps []Migration) (string, error) {
+	if !db.Migrator().HasTable(&model.Torrent{}) {
+		return "", nil
+	}
+	pending, err := pendingMigrations(db, steps)
+	if err != nil || len(pending) == 0 {
+		return "", err
+	}
+	version := 0
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		if err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+			return "", err
+		}
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102150405"))
+	if err := vacuumInto(db, backup); err != nil {
+		return "", err
+	}
+	slog.Info("[database] 迁移前已备份数据库", "备份", backup, "当前版本", version, "待执行迁移", len(pending))
+	return backup, nil
+}
+
+// SchemaVersion 返回当前数据库已执行的最大迁移版本, 没有执行过迁移时返回 0
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.WithContext(ctx).Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
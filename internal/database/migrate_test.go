@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goto-bangumi/internal/model"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestMigrateOldSchema 在旧版本的表结构上执行迁移
+func TestMigrateOldSchema(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// 旧版本的 torrents 表, 没有 downloader 列
+	old, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open old database: %v", err)
+	}
+	if err := old.Exec(`CREATE TABLE torrents (
+		Link TEXT PRIMARY KEY,
+		download_uid TEXT,
+		name TEXT DEFAULT '',
+		created_at DATETIME,
+		downloaded INTEGER DEFAULT 0,
+		renamed NUMERIC DEFAULT false,
+		bangumi_id INTEGER,
+		homepage TEXT
+	)`).Error; err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+	if err := old.Exec(`INSERT INTO torrents (Link, name, downloaded, renamed) VALUES
+		('https://example.com/1.torrent', 'renamed but sending', 1, true),
+		('https://example.com/2.torrent', 'not renamed', 1, false)`).Error; err != nil {
+		t.Fatalf("Failed to seed old data: %v", err)
+	}
+	sqlDB, _ := old.DB()
+	sqlDB.Close()
+
+	db, err := NewDB(&path)
+	if err != nil {
+		t.Fatalf("NewDB on old schema failed: %v", err)
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if version != migrations[len(migrations)-1].Version {
+		t.Fatalf("Expected schema version %d, got %d", migrations[len(migrations)-1].Version, version)
+	}
+	if !db.Migrator().HasColumn(&model.Torrent{}, "downloader") {
+		t.Fatal("Expected AutoMigrate to add downloader column")
+	}
+
+	var renamed, pending model.Torrent
+	db.Where("Link = ?", "https://example.com/1.torrent").First(&renamed)
+	db.Where("Link = ?", "https://example.com/2.torrent").First(&pending)
+	if renamed.Downloaded != model.DownloadDone {
+		t.Fatalf("Expected renamed torrent to be backfilled as done, got %d", renamed.Downloaded)
+	}
+	if pending.Downloaded != model.DownloadSending {
+		t.Fatalf("Expected unrenamed torrent to stay sending, got %d", pending.Downloaded)
+	}
+
+	// 迁移前的数据库备份到了旁边, 内容是迁移前的状态
+	backups, _ := filepath.Glob(path + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup before migrating, got %v", backups)
+	}
+	backup, err := gorm.Open(sqlite.Open(backups[0]), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer func() {
+		sqlDB, _ := backup.DB()
+		sqlDB.Close()
+	}()
+	var downloaded int
+	backup.Raw("SELECT downloaded FROM torrents WHERE Link = ?", "https://example.com/1.torrent").Scan(&downloaded)
+	if downloaded != int(model.DownloadSending) || backup.Migrator().HasColumn(&model.Torrent{}, "downloader") {
+		t.Fatalf("Expected backup to keep the old schema and data, downloaded = %d", downloaded)
+	}
+}
+
+func TestBackupBeforeMigrate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.db")
+
+	// 新建的数据库不需要备份
+	db, err := NewDB(&path)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "*.bak")); len(backups) != 0 {
+		t.Fatalf("Expected no backup for a new database, got %v", backups)
+	}
+
+	// 迁移都已执行过, 不需要备份
+	backup, err := backupBeforeMigrate(db.DB, path, migrations)
+	if err != nil || backup != "" {
+		t.Fatalf("backupBeforeMigrate() = %q, %v, want no backup", backup, err)
+	}
+
+	steps := append(append([]Migration(nil), migrations...), Migration{Version: 100, Name: "new", Up: func(tx *gorm.DB) error { return nil }})
+	backup, err = backupBeforeMigrate(db.DB, path, steps)
+	if err != nil {
+		t.Fatalf("backupBeforeMigrate() error = %v", err)
+	}
+	want := fmt.Sprintf("%s.v%d-", path, migrations[len(migrations)-1].Version)
+	if !strings.HasPrefix(backup, want) {
+		t.Fatalf("backup = %q, want prefix %q", backup, want)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Fatalf("backup file missing: %v", err)
+	}
+	db.Close()
+
+	// 带参数的 DSN 备份到数据库文件旁边
+	if got := sqliteFile("file:" + path + "?_txlock=immediate"); got != path {
+		t.Errorf("sqliteFile() = %q, want %q", got, path)
+	}
+}
+
+func TestRunMigrationsOnce(t *testing.T) {
+	ctx := context.Background()
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	calls := 0
+	steps := []Migration{
+		{Version: 100, Name: "count", Up: func(tx *gorm.DB) error { calls++; return nil }},
+	}
+	for range 2 {
+		if err := runMigrations(db.DB, steps); err != nil {
+			t.Fatalf("runMigrations failed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Expected migration to run once, ran %d times", calls)
+	}
+
+	// 失败的迁移不记录版本
+	failing := []Migration{
+		{Version: 101, Name: "fail", Up: func(tx *gorm.DB) error { return errors.New("boom") }},
+	}
+	if err := runMigrations(db.DB, failing); err == nil {
+		t.Fatal("Expected failing migration to return error")
+	}
+	version, _ := db.SchemaVersion(ctx)
+	if version != 100 {
+		t.Fatalf("Expected schema version 100 after failed migration, got %d", version)
+	}
+}
+
+func TestMigrateTitleAlias(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	// 旧版本写入的番剧没有拼音别名
+	if err := db.Exec("INSERT INTO bangumis (official_title, title_alias) VALUES (?, '')", "夏日口袋").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrations[1].Up(db.DB); err != nil {
+		t.Fatalf("migration %d failed: %v", migrations[1].Version, err)
+	}
+	var alias string
+	db.Model(&model.Bangumi{}).Where("official_title = ?", "夏日口袋").Pluck("title_alias", &alias)
+	if alias != "xiarikoudai xrkd" {
+		t.Fatalf("title_alias = %q, want %q", alias, "xiarikoudai xrkd")
+	}
+}
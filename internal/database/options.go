@@ -0,0 +1,108 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqliteOptions NewDB 的 sqlite 连接配置, pragma 通过 DSN 的 _pragma 参数在每个新连接上执行
+type sqliteOptions struct {
+	journalMode  string
+	busyTimeout  time.Duration
+	synchronous  string
+	maxOpenConns int
+	maxIdleConns int
+	// readOnly 只读打开, 不建表也不迁移, 所有写入都会失败, 见 WithReadOnly
+	readOnly bool
+}
+
+// defaultSQLiteOptions 默认使用 WAL, 读写互不阻塞, 多个刷新协程同时访问时不容易出现 database is locked
+// WAL 下 synchronous=NORMAL 已经足够安全, 只有断电时可能丢失最后提交的事务
+func defaultSQLiteOptions() sqliteOptions {
+	return sqliteOptions{
+		journalMode: "WAL",
+		busyTimeout: 5 * time.Second,
+		synchronous: "NORMAL",
+	}
+}
+
+// Option NewDB 的可选配置
+type Option func(*sqliteOptions)
+
+// WithJournalMode 设置 journal_mode, 例如 WAL/DELETE/TRUNCATE, 为空时忽略
+// 数据库文件在不支持共享内存的网络存储上时需要改回 DELETE; 内存数据库不使用此配置
+func WithJournalMode(mode string) Option {
+	return func(o *sqliteOptions) {
+		if mode == "" {
+			return
+		}
+		o.journalMode = strings.ToUpper(mode)
+	}
+}
+
+// WithBusyTimeout 设置数据库被锁定时的等待时间, 不大于 0 时忽略
+func WithBusyTimeout(timeout time.Duration) Option {
+	return func(o *sqliteOptions) {
+		if timeout <= 0 {
+			return
+		}
+		o.busyTimeout = timeout
+	}
+}
+
+// WithSynchronous 设置 synchronous, 例如 NORMAL/FULL/OFF, 为空时忽略
+func WithSynchronous(mode string) Option {
+	return func(o *sqliteOptions) {
+		if mode == "" {
+			return
+		}
+		o.synchronous = strings.ToUpper(mode)
+	}
+}
+
+// WithMaxOpenConns 设置连接池的最大连接数, 不大于 0 时不限制; 内存数据库固定为 1
+func WithMaxOpenConns(n int) Option {
+	return func(o *sqliteOptions) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns 设置连接池的最大空闲连接数, 不大于 0 时使用 database/sql 的默认值
+func WithMaxIdleConns(n int) Option {
+	return func(o *sqliteOptions) {
+		o.maxIdleConns = n
+	}
+}
+
+// WithReadOnly 只读打开数据库, 用于对正在使用的数据目录运行报表或排查损坏, 不会有任何写入
+// 不会创建数据目录、建表或执行迁移, journal_mode 和 synchronous 保持数据库当前的设置
+// 只支持 sqlite, 通过 query_only 拒绝写入, 写入时返回 ErrReadOnly
+func WithReadOnly() Option {
+	return func(o *sqliteOptions) {
+		o.readOnly = true
+	}
+}
+
+// dsn 在 path 上追加 pragma 参数, path 中已经设置的 pragma 不会被覆盖
+func (o sqliteOptions) dsn(path string, memory bool) string {
+	pragmas := []string{fmt.Sprintf("busy_timeout(%d)", o.busyTimeout.Milliseconds())}
+	switch {
+	case o.readOnly:
+		pragmas = append(pragmas, "query_only(1)")
+	case !memory:
+		pragmas = append(pragmas, "journal_mode("+o.journalMode+")", "synchronous("+o.synchronous+")")
+	}
+	for _, p := range pragmas {
+		name := p[:strings.IndexByte(p, '(')]
+		if strings.Contains(path, "_pragma="+name) {
+			continue
+		}
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + "_pragma=" + p
+	}
+	return path
+}
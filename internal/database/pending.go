@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============ 待匹配种子相关方法 ============
+
+// AddPendingTorrents 记录匹配不到番剧的种子
+// 已经记录过的说明又一次刷新订阅后仍然匹配不到, 失败次数加一并更新失败原因
+func (db *DB) AddPendingTorrents(ctx context.Context, pending []*model.PendingTorrent) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "link"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"attempts":   gorm.Expr("pending_torrents.attempts + 1"),
+			"last_error": excludedColumn("last_error"),
+			"updated_at": clause.Column{Table: "excluded", Name: "updated_at"},
+		}),
+	}).Create(pending).Error
+}
+
+// ParkPendingTorrents 记录创建番剧失败的种子, 失败原因为 ResolveError
+// 已经记录过的只更新创建失败的原因, 不增加失败次数(由 AddPendingTorrents 每次刷新订阅时计)
+func (db *DB) ParkPendingTorrents(ctx context.Context, pending []*model.PendingTorrent) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "link"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"resolve_error": excludedColumn("resolve_error"),
+			"updated_at":    clause.Column{Table: "excluded", Name: "updated_at"},
+		}),
+	}).Create(pending).Error
+}
+
+// ListPendingTorrents 获取重试次数还没达到 maxAttempts 的待匹配种子, 不包括已忽略的, 先记录的在前
+func (db *DB) ListPendingTorrents(ctx context.Context, maxAttempts int) ([]*model.PendingTorrent, error) {
+	var pending []*model.PendingTorrent
+	err := db.WithContext(ctx).Where("attempts < ? AND dismissed = ?", maxAttempts, false).Order("created_at").Find(&pending).Error
+	return pending, err
+}
+
+// ListUnmatchedTorrents 获取重试 maxAttempts 次之后仍然匹配不到番剧的种子, 需要用户手动处理, 不包括已忽略的
+// maxAttempts 为 0 时返回所有还没有处理的待匹配种子
+func (db *DB) ListUnmatchedTorrents(ctx context.Context, maxAttempts int) ([]*model.PendingTorrent, error) {
+	var pending []*model.PendingTorrent
+	err := db.WithContext(ctx).Where("attempts >= ? AND dismissed = ?", maxAttempts, false).Order("created_at").Find(&pending).Error
+	return pending, err
+}
+
+// RecordPendingFailure 记录一次重试匹配失败, 返回累计的失败次数
+func (db *DB) RecordPendingFailure(ctx context.Context, link string, cause error) (int, error) {
+	var attempts int
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.PendingTorrent{}).Where("link = ?", link).Updates(map[string]any{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": cause.Error(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Model(&model.PendingTorrent{}).Where("link = ?", link).Pluck("attempts", &attempts).Error
+	})
+	return attempts, err
+}
+
+// UpdatePendingError 记录最后一次匹配失败的原因, 不增加失败次数, 记录不存在时返回 ErrNotFound
+func (db *DB) UpdatePendingError(ctx context.Context, link string, cause error) error {
+	result := db.WithContext(ctx).Model(&model.PendingTorrent{}).Where("link = ?", link).Update("last_error", cause.Error())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeletePendingTorrents 删除待匹配记录, 匹配成功或不再需要时调用
+func (db *DB) DeletePendingTorrents(ctx context.Context, links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Where("link IN ?", links).Delete(&model.PendingTorrent{}).Error
+}
+
+// GetPendingTorrent 按链接获取待匹配种子, 不存在时返回 ErrNotFound
+func (db *DB) GetPendingTorrent(ctx context.Context, link string) (*model.PendingTorrent, error) {
+	var pending model.PendingTorrent
+	if err := db.WithContext(ctx).Where("link = ?", link).First(&pending).Error; err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// DismissPendingTorrent 忽略待匹配种子, 不再重试也不再列出, 不存在时返回 ErrNotFound
+func (db *DB) DismissPendingTorrent(ctx context.Context, link string) error {
+	result := db.WithContext(ctx).Model(&model.PendingTorrent{}).Where("link = ?", link).Update("dismissed", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
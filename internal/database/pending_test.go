@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestPendingTorrents(t *testing.T) {
+	db := NewTestDB(t)
+	ctx := context.Background()
+
+	cause := errors.New("record not found")
+	torrents := []*model.Torrent{
+		{Link: "magnet:?xt=urn:btih:PENDING01", Name: "pending 01", Homepage: "https://mikanani.me/Home/Episode/01"},
+		{Link: "magnet:?xt=urn:btih:PENDING02", Name: "pending 02"},
+	}
+	var pending []*model.PendingTorrent
+	for _, torrent := range torrents {
+		pending = append(pending, model.NewPendingTorrent(torrent, "https://mikanani.me/RSS/MyBangumi", cause))
+	}
+	if err := db.AddPendingTorrents(ctx, pending); err != nil {
+		t.Fatalf("AddPendingTorrents() error = %v", err)
+	}
+
+	attempts, err := db.RecordPendingFailure(ctx, torrents[0].Link, errors.New("ambiguous"))
+	if err != nil || attempts != 1 {
+		t.Fatalf("RecordPendingFailure() = %d, %v, want 1", attempts, err)
+	}
+	// 只更新失败原因不增加失败次数
+	if err := db.UpdatePendingError(ctx, torrents[0].Link, errors.New("ambiguous")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetPendingTorrent(ctx, torrents[0].Link); got.Attempts != 1 || got.LastError != "ambiguous" {
+		t.Errorf("after UpdatePendingError = %+v, want 1 attempt and new error", got)
+	}
+	// 再次添加说明刷新订阅后仍然匹配不到, 失败次数加一而不是重置
+	if err := db.AddPendingTorrents(ctx, pending[:1]); err != nil {
+		t.Fatal(err)
+	}
+	unmatched, err := db.ListUnmatchedTorrents(ctx, 2)
+	if err != nil || len(unmatched) != 1 || unmatched[0].LastError != cause.Error() {
+		t.Fatalf("ListUnmatchedTorrents() = %+v, %v, want %s", unmatched, err, torrents[0].Link)
+	}
+	if got := unmatched[0].Torrent(); got.Homepage != torrents[0].Homepage || got.Name != torrents[0].Name {
+		t.Errorf("Torrent() = %+v, want %+v", got, torrents[0])
+	}
+	retry, err := db.ListPendingTorrents(ctx, 1)
+	if err != nil || len(retry) != 1 || retry[0].Link != torrents[1].Link {
+		t.Errorf("ListPendingTorrents() = %+v, %v, want %s", retry, err, torrents[1].Link)
+	}
+
+	// 忽略的种子不再重试也不再列出
+	if err := db.DismissPendingTorrent(ctx, torrents[1].Link); err != nil {
+		t.Fatal(err)
+	}
+	if retry, _ := db.ListPendingTorrents(ctx, 10); len(retry) != 1 || retry[0].Link != torrents[0].Link {
+		t.Errorf("ListPendingTorrents() after dismiss = %+v, want only %s", retry, torrents[0].Link)
+	}
+	if got, err := db.GetPendingTorrent(ctx, torrents[1].Link); err != nil || !got.Dismissed {
+		t.Errorf("GetPendingTorrent() = %+v, %v, want dismissed", got, err)
+	}
+	if err := db.DismissPendingTorrent(ctx, "magnet:?xt=urn:btih:MISSING"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DismissPendingTorrent() missing error = %v, want ErrNotFound", err)
+	}
+
+	// 创建番剧失败只更新失败原因, 不影响失败次数
+	parked := model.NewPendingTorrent(torrents[0], "https://mikanani.me/RSS/MyBangumi", nil)
+	parked.ResolveError = "mikan 404"
+	if err := db.ParkPendingTorrents(ctx, []*model.PendingTorrent{parked}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetPendingTorrent(ctx, torrents[0].Link); got.Attempts != 2 || got.ResolveError != "mikan 404" || got.LastError != cause.Error() {
+		t.Errorf("after ParkPendingTorrents = %+v, want 2 attempts and resolve error", got)
+	}
+
+	if _, err := db.RecordPendingFailure(ctx, "magnet:?xt=urn:btih:MISSING", cause); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RecordPendingFailure() missing error = %v, want ErrNotFound", err)
+	}
+	if err := db.UpdatePendingError(ctx, "magnet:?xt=urn:btih:MISSING", cause); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdatePendingError() missing error = %v, want ErrNotFound", err)
+	}
+	if err := db.DeletePendingTorrents(ctx, []string{torrents[0].Link, torrents[1].Link}); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := db.ListPendingTorrents(ctx, 10); len(left) != 0 {
+		t.Errorf("pending after delete = %d, want 0", len(left))
+	}
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm/clause"
+)
+
+// ============ 解析退避相关方法 ============
+
+// GetResolveAttempt 获取标题的解析失败记录, 没有记录时返回 ErrNotFound
+func (db *DB) GetResolveAttempt(ctx context.Context, key string) (*model.ResolveAttempt, error) {
+	var attempt model.ResolveAttempt
+	err := db.WithContext(ctx).Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).First(&attempt).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// SaveResolveAttempt 创建或更新解析失败记录
+func (db *DB) SaveResolveAttempt(ctx context.Context, attempt *model.ResolveAttempt) error {
+	return db.WithContext(ctx).Save(attempt).Error
+}
+
+// DeleteResolveAttempt 删除解析失败记录, 解析成功后调用
+func (db *DB) DeleteResolveAttempt(ctx context.Context, key string) error {
+	return db.WithContext(ctx).Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).Delete(&model.ResolveAttempt{}).Error
+}
@@ -2,15 +2,25 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
 )
 
 // ============ RSS 相关方法 ============
 
 // CreateRSS 创建 RSS 项
 func (db *DB) CreateRSS(ctx context.Context, item *model.RSSItem) error {
-	return db.WithContext(ctx).Save(item).Error
+	created := item.ID == 0
+	if err := db.WithContext(ctx).Save(item).Error; err != nil {
+		return err
+	}
+	if created {
+		db.publish(RSSAdded{RSS: *item})
+	}
+	return nil
 }
 
 // UpdateRSS 更新 RSS 项
@@ -36,7 +46,7 @@ func (db *DB) GetRSSByID(ctx context.Context, id uint) (*model.RSSItem, error) {
 // GetRSSByURL 根据 URL 获取 RSS 项
 func (db *DB) GetRSSByURL(ctx context.Context, url string) (*model.RSSItem, error) {
 	var item model.RSSItem
-	err := db.WithContext(ctx).Where("url = ?", url).First(&item).Error
+	err := db.WithContext(ctx).Where("link = ?", url).First(&item).Error
 	if err != nil {
 		return nil, err
 	}
@@ -63,3 +73,103 @@ func (db *DB) SetRSSEnabled(ctx context.Context, id uint, enabled bool) error {
 		Where("id = ?", id).
 		Update("enabled", enabled).Error
 }
+
+// SetRSSEnabledBulk 批量设置 RSS 项的启用状态，返回受影响的行数
+func (db *DB) SetRSSEnabledBulk(ctx context.Context, ids []uint, enabled bool) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := db.WithContext(ctx).Model(&model.RSSItem{}).
+		Where("id IN ?", ids).
+		Update("enabled", enabled)
+	return result.RowsAffected, result.Error
+}
+
+// SetAllRSSEnabled 设置所有 RSS 项的启用状态（一键暂停/恢复），返回受影响的行数
+func (db *DB) SetAllRSSEnabled(ctx context.Context, enabled bool) (int64, error) {
+	result := db.WithContext(ctx).Model(&model.RSSItem{}).
+		Where("enabled <> ?", enabled).
+		Update("enabled", enabled)
+	return result.RowsAffected, result.Error
+}
+
+// IsRSSCompleted RSS 关联的番剧是否都已完结, 没有关联番剧时返回 false
+// 聚合订阅包含多个番剧, 只要有一个未完结就需要继续刷新
+func (db *DB) IsRSSCompleted(ctx context.Context, link string) (bool, error) {
+	var counts struct {
+		Total     int64
+		Completed int64
+	}
+	err := db.WithContext(ctx).Model(&model.Bangumi{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(CASE WHEN completed THEN 1 ELSE 0 END), 0) AS completed").
+		Where("rss_link = ? AND deleted = ?", link, false).
+		Scan(&counts).Error
+	if err != nil {
+		return false, err
+	}
+	return counts.Total > 0 && counts.Completed == counts.Total, nil
+}
+
+// ListDueRSS 获取到 now 为止需要再次拉取的激活 RSS 项, 没有单独设置间隔的使用 defaultInterval
+func (db *DB) ListDueRSS(ctx context.Context, now time.Time, defaultInterval time.Duration) ([]*model.RSSItem, error) {
+	items, err := db.ListActiveRSS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	due := items[:0]
+	for _, item := range items {
+		if item.Due(now, defaultInterval) {
+			due = append(due, item)
+		}
+	}
+	return due, nil
+}
+
+// RecordRSSFetch 记录一次拉取的结果, fetchErr 为 nil 时清零连续失败次数, 否则加一并保存错误信息
+func (db *DB) RecordRSSFetch(ctx context.Context, id uint, fetchErr error) error {
+	updates := map[string]any{
+		"last_fetched_at":      time.Now(),
+		"last_status":          model.RSSStatusOK,
+		"consecutive_failures": 0,
+	}
+	if fetchErr != nil {
+		updates["last_status"] = fetchErr.Error()
+		updates["consecutive_failures"] = gorm.Expr("consecutive_failures + 1")
+	}
+	result := db.WithContext(ctx).Model(&model.RSSItem{}).Where("id = ?", id).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ScheduleRSS 设置 RSS 项的下一次拉取时间, 见 model.RSSItem.NextRunAt
+func (db *DB) ScheduleRSS(ctx context.Context, id uint, next time.Time) error {
+	result := db.WithContext(ctx).Model(&model.RSSItem{}).Where("id = ?", id).Update("next_run_at", next)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetRSSFeedState 保存条件拉取用的 ETag、Last-Modified 和内容哈希
+func (db *DB) SetRSSFeedState(ctx context.Context, id uint, etag, lastModified, contentHash string) error {
+	result := db.WithContext(ctx).Model(&model.RSSItem{}).Where("id = ?", id).Updates(map[string]any{
+		"etag":          etag,
+		"last_modified": lastModified,
+		"content_hash":  contentHash,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func newTestRSS(t *testing.T, db *DB, n int) []*model.RSSItem {
+	t.Helper()
+	ctx := context.Background()
+	items := make([]*model.RSSItem, 0, n)
+	for i := range n {
+		item := &model.RSSItem{
+			Name:    fmt.Sprintf("rss-%d", i),
+			Link:    fmt.Sprintf("https://mikanani.me/RSS/Bangumi?bangumiId=%d", 3000+i),
+			Enabled: true,
+		}
+		if err := db.CreateRSS(ctx, item); err != nil {
+			t.Fatalf("CreateRSS failed: %v", err)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestSetAllRSSEnabled(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+	newTestRSS(t, db, 5)
+
+	affected, err := db.SetAllRSSEnabled(ctx, false)
+	if err != nil {
+		t.Fatalf("SetAllRSSEnabled(false) failed: %v", err)
+	}
+	if affected != 5 {
+		t.Fatalf("Expected 5 rows affected, got %d", affected)
+	}
+	active, err := db.ListActiveRSS(ctx)
+	if err != nil {
+		t.Fatalf("ListActiveRSS failed: %v", err)
+	}
+	if len(active) != 0 {
+		t.Fatalf("Expected 0 active rss after pause-all, got %d", len(active))
+	}
+
+	affected, err = db.SetAllRSSEnabled(ctx, true)
+	if err != nil {
+		t.Fatalf("SetAllRSSEnabled(true) failed: %v", err)
+	}
+	if affected != 5 {
+		t.Fatalf("Expected 5 rows affected on resume, got %d", affected)
+	}
+	active, _ = db.ListActiveRSS(ctx)
+	if len(active) != 5 {
+		t.Fatalf("Expected 5 active rss after resume-all, got %d", len(active))
+	}
+}
+
+func TestSetRSSEnabledBulk(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+	items := newTestRSS(t, db, 5)
+
+	affected, err := db.SetRSSEnabledBulk(ctx, []uint{items[0].ID, items[2].ID}, false)
+	if err != nil {
+		t.Fatalf("SetRSSEnabledBulk failed: %v", err)
+	}
+	if affected != 2 {
+		t.Fatalf("Expected 2 rows affected, got %d", affected)
+	}
+	active, _ := db.ListActiveRSS(ctx)
+	if len(active) != 3 {
+		t.Fatalf("Expected 3 active rss, got %d", len(active))
+	}
+
+	affected, err = db.SetRSSEnabledBulk(ctx, nil, false)
+	if err != nil || affected != 0 {
+		t.Fatalf("Expected no-op for empty ids, got affected=%d err=%v", affected, err)
+	}
+}
+
+func TestRecordRSSFetch(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+	items := newTestRSS(t, db, 2)
+	if err := db.Model(items[1]).Update("interval_seconds", 3600).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 从未拉取过的订阅都需要拉取
+	due, err := db.ListDueRSS(ctx, time.Now(), time.Minute)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("ListDueRSS() before fetch = %d, %v, want 2", len(due), err)
+	}
+
+	fetchErr := errors.New("connection refused")
+	for range 2 {
+		if err := db.RecordRSSFetch(ctx, items[0].ID, fetchErr); err != nil {
+			t.Fatalf("RecordRSSFetch() error = %v", err)
+		}
+	}
+	if err := db.RecordRSSFetch(ctx, items[1].ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	failed, _ := db.GetRSSByID(ctx, items[0].ID)
+	if failed.LastFetchedAt == nil || failed.LastStatus != fetchErr.Error() || failed.ConsecutiveFailures != 2 {
+		t.Errorf("failed rss = %+v, want status %q and 2 failures", failed, fetchErr)
+	}
+
+	// 成功后清零连续失败次数
+	if err := db.RecordRSSFetch(ctx, items[0].ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	ok, _ := db.GetRSSByID(ctx, items[0].ID)
+	if ok.LastStatus != model.RSSStatusOK || ok.ConsecutiveFailures != 0 {
+		t.Errorf("rss after success = %+v, want ok and 0 failures", ok)
+	}
+
+	// 两分钟后只有使用默认间隔的订阅到期, 单独设置了一小时间隔的还没有
+	due, err = db.ListDueRSS(ctx, time.Now().Add(2*time.Minute), time.Minute)
+	if err != nil || len(due) != 1 || due[0].ID != items[0].ID {
+		t.Errorf("ListDueRSS() after fetch = %+v, %v, want only %d", due, err, items[0].ID)
+	}
+
+	if err := db.RecordRSSFetch(ctx, 999, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RecordRSSFetch() unknown id error = %v, want ErrNotFound", err)
+	}
+	if got, err := db.GetRSSByURL(ctx, items[1].Link); err != nil || got.ID != items[1].ID {
+		t.Errorf("GetRSSByURL() = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"slices"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ============ 季度 ============
+
+// GetSeason 获取番剧的第 number 季, 不存在时返回 ErrNotFound
+func (db *DB) GetSeason(ctx context.Context, bangumiID, number int) (*model.Season, error) {
+	var season model.Season
+	err := db.WithContext(ctx).Where("bangumi_id = ? AND number = ?", bangumiID, number).First(&season).Error
+	if err != nil {
+		return nil, err
+	}
+	return &season, nil
+}
+
+// ListSeasons 获取番剧的所有季度, 按季度排序
+func (db *DB) ListSeasons(ctx context.Context, bangumiID int) ([]*model.Season, error) {
+	var seasons []*model.Season
+	err := db.WithContext(ctx).Where("bangumi_id = ?", bangumiID).Order("number").Find(&seasons).Error
+	return seasons, err
+}
+
+// UpdateSeason 更新季度的 TMDB 信息、集数、播出日期和偏移, 所属番剧和季度不能修改
+// 季度不存在时返回 ErrNotFound
+func (db *DB) UpdateSeason(ctx context.Context, season *model.Season) error {
+	result := db.WithContext(ctx).Model(&model.Season{}).Where("id = ?", season.ID).Updates(map[string]any{
+		"tmdb_season_id": season.TmdbSeasonID,
+		"episode_count":  season.EpisodeCount,
+		"air_date":       season.AirDate,
+		"end_date":       season.EndDate,
+		"offset":         season.Offset,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ensureSeason 返回番剧第 number 季的 ID, 不存在时创建
+func (db *DB) ensureSeason(ctx context.Context, bangumiID, number int) (int, error) {
+	tx := db.WithContext(ctx)
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.Season{BangumiID: bangumiID, Number: number}).Error
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = tx.Model(&model.Season{}).Where("bangumi_id = ? AND number = ?", bangumiID, number).Pluck("id", &id).Error
+	return id, err
+}
+
+// mergeSeasons 把被合并番剧的季度转移到保留的番剧, 保留番剧已经有的季度合并为一个, 引用它的解析信息和种子改为引用保留的季度
+func mergeSeasons(tx *gorm.DB, keepID, mergeID int) error {
+	var keep, merge []*model.Season
+	if err := tx.Where("bangumi_id = ?", keepID).Find(&keep).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("bangumi_id = ?", mergeID).Find(&merge).Error; err != nil {
+		return err
+	}
+	existing := make(map[int]int, len(keep))
+	for _, s := range keep {
+		existing[s.Number] = s.ID
+	}
+	for _, s := range merge {
+		keepSeason, ok := existing[s.Number]
+		if !ok {
+			if err := tx.Model(&model.Season{}).Where("id = ?", s.ID).Update("bangumi_id", keepID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		for _, table := range []any{&model.EpisodeMetadata{}, &model.Torrent{}} {
+			if err := tx.Model(table).Where("season_id = ?", s.ID).Update("season_id", keepSeason).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&model.Season{}, s.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncSeasons 为指定的番剧补全季度, 见包级函数 syncSeasons
+func (db *DB) syncSeasons(ctx context.Context, bangumiIDs ...int) error {
+	return syncSeasons(db.WithContext(ctx), bangumiIDs...)
+}
+
+// syncSeasons 为番剧补全季度, 并为还没有关联季度的解析信息和种子设置 SeasonID
+// 季度来自番剧本身、解析信息和剧集; 关联的 TMDB 条目有这一季时补上集数和首播日期
+// 种子按它提供的剧集确定季度, 没有剧集记录的使用番剧本身的季度. bangumiIDs 为空时处理所有番剧
+func syncSeasons(tx *gorm.DB, bangumiIDs ...int) error {
+	query := tx.Model(&model.Bangumi{}).Preload("TmdbItem").Select("id", "season", "tmdb_id")
+	if len(bangumiIDs) > 0 {
+		query = query.Where("id IN ?", bangumiIDs)
+	}
+	var bangumis []*model.Bangumi
+	if err := query.Find(&bangumis).Error; err != nil {
+		return err
+	}
+	for _, b := range bangumis {
+		var fromMetadata, fromEpisodes []int
+		if err := tx.Model(&model.EpisodeMetadata{}).Where("bangumi_id = ?", b.ID).Distinct().Pluck("season", &fromMetadata).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Episode{}).Where("bangumi_id = ?", b.ID).Distinct().Pluck("season", &fromEpisodes).Error; err != nil {
+			return err
+		}
+		numbers := append(append([]int{b.Season}, fromMetadata...), fromEpisodes...)
+		slices.Sort(numbers)
+		for _, n := range slices.Compact(numbers) {
+			season := &model.Season{BangumiID: b.ID, Number: n}
+			if b.TmdbItem != nil {
+				season.EpisodeCount, _, _ = b.TmdbItem.SeasonEpisodes(n)
+				season.AirDate = b.TmdbItem.SeasonAirDate(n)
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(season).Error; err != nil {
+				return err
+			}
+		}
+
+		var seasons []*model.Season
+		if err := tx.Where("bangumi_id = ?", b.ID).Find(&seasons).Error; err != nil {
+			return err
+		}
+		for _, s := range seasons {
+			err := tx.Model(&model.EpisodeMetadata{}).Where("bangumi_id = ? AND season = ? AND season_id = 0", b.ID, s.Number).
+				Update("season_id", s.ID).Error
+			if err != nil {
+				return err
+			}
+			var links []string
+			err = tx.Model(&model.Episode{}).Where("bangumi_id = ? AND season = ? AND torrent_link <> ''", b.ID, s.Number).
+				Distinct().Pluck("torrent_link", &links).Error
+			if err != nil {
+				return err
+			}
+			if len(links) > 0 {
+				if err := tx.Model(&model.Torrent{}).Where(torrentLinks(links)).Where("season_id = 0").Update("season_id", s.ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+		for _, s := range seasons {
+			if s.Number != b.Season {
+				continue
+			}
+			if err := tx.Model(&model.Torrent{}).Where("bangumi_id = ? AND season_id = 0", b.ID).Update("season_id", s.ID).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestSeasons(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t, "testdata/fixtures.yaml")
+
+	// 加载测试数据时按番剧和解析信息生成季度, 种子关联到番剧本身的季度
+	first, err := db.GetSeason(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("GetSeason() error = %v", err)
+	}
+	torrent, _ := db.GetTorrentByURL(ctx, "https://mikanani.me/Download/frieren-01.torrent")
+	if torrent.SeasonID != first.ID {
+		t.Errorf("torrent season = %d, want %d", torrent.SeasonID, first.ID)
+	}
+	if special, err := db.GetSeason(ctx, 2, 0); err != nil || special.Number != 0 {
+		t.Errorf("GetSeason(2, 0) = %+v, %v", special, err)
+	}
+
+	// 第二季的解析信息和种子挂在同一个番剧下
+	metadata := &model.EpisodeMetadata{Title: "Sousou no Frieren S2", Group: "LoliHouse", Season: 2, BangumiID: 1}
+	if err := db.CreateBangumiParse(ctx, metadata); err != nil {
+		t.Fatalf("CreateBangumiParse() error = %v", err)
+	}
+	second, err := db.GetSeason(ctx, 1, 2)
+	if err != nil || metadata.SeasonID != second.ID {
+		t.Fatalf("GetSeason(1, 2) = %+v, %v, metadata season = %d", second, err, metadata.SeasonID)
+	}
+	link := "https://mikanani.me/Download/frieren-s2-01.torrent"
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: link, Name: "[LoliHouse] Sousou no Frieren S2 - 01", BangumiID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrackEpisodes(ctx, 1, 2, []int{1}, link); err != nil {
+		t.Fatal(err)
+	}
+	if torrent, _ := db.GetTorrentByURL(ctx, link); torrent.SeasonID != second.ID {
+		t.Errorf("tracked torrent season = %d, want %d", torrent.SeasonID, second.ID)
+	}
+
+	second.EpisodeCount, second.AirDate = 10, "2026-01-16"
+	if err := db.UpdateSeason(ctx, second); err != nil {
+		t.Fatalf("UpdateSeason() error = %v", err)
+	}
+	seasons, err := db.ListSeasons(ctx, 1)
+	if err != nil || len(seasons) != 2 || seasons[1].EpisodeCount != 10 {
+		t.Errorf("ListSeasons() = %+v, %v", seasons, err)
+	}
+	if err := db.UpdateSeason(ctx, &model.Season{ID: 999}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateSeason() missing error = %v, want ErrNotFound", err)
+	}
+
+	// 合并番剧时相同的季度合并为一个
+	if err := db.MergeBangumi(ctx, 1, 2); err != nil {
+		t.Fatalf("MergeBangumi() error = %v", err)
+	}
+	if seasons, _ := db.ListSeasons(ctx, 1); len(seasons) != 3 {
+		t.Errorf("seasons after merge = %d, want 3", len(seasons))
+	}
+	if _, err := db.GetSeason(ctx, 2, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetSeason() of merged bangumi error = %v, want ErrNotFound", err)
+	}
+}
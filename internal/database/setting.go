@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+
+	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm/clause"
+)
+
+// ============ Setting 相关方法 ============
+
+// GetSetting 获取配置项的值, 没有设置过时返回 ErrNotFound
+func (db *DB) GetSetting(ctx context.Context, key string) (string, error) {
+	var setting model.Setting
+	err := db.WithContext(ctx).Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).First(&setting).Error
+	if err != nil {
+		return "", err
+	}
+	return setting.Value, nil
+}
+
+// SetSetting 保存配置项, 已存在时覆盖原值
+func (db *DB) SetSetting(ctx context.Context, key, value string) error {
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&model.Setting{Key: key, Value: value}).Error
+}
+
+// DeleteSetting 删除配置项, 之后读取时回到配置文件中的值
+func (db *DB) DeleteSetting(ctx context.Context, key string) error {
+	return db.WithContext(ctx).Where(clause.Eq{Column: clause.Column{Name: "key"}, Value: key}).Delete(&model.Setting{}).Error
+}
+
+// ListSettings 获取所有保存过的配置项
+func (db *DB) ListSettings(ctx context.Context) (map[string]string, error) {
+	var settings []model.Setting
+	if err := db.WithContext(ctx).Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.Key] = s.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+// ============ 统计相关方法 ============
+
+// TorrentStats 种子统计信息
+type TorrentStats struct {
+	Total      int64 `json:"total"`
+	None       int64 `json:"none"`       // 未下载
+	Sending    int64 `json:"sending"`    // 已发送到下载器
+	Downloaded int64 `json:"downloaded"` // 下载完成
+	Failed     int64 `json:"failed"`     // 下载出错
+	Replaced   int64 `json:"replaced"`   // 被修正版或更好的版本替代
+	Held       int64 `json:"held"`       // 在挑选窗口中等待
+	Unrenamed  int64 `json:"unrenamed"`  // 下载完成但未重命名
+}
+
+// BangumiStats 番剧统计信息
+type BangumiStats struct {
+	Total   int64 `json:"total"`
+	Active  int64 `json:"active"`
+	Deleted int64 `json:"deleted"`
+}
+
+// GetTorrentStats 获取种子统计信息, 通过一次 GROUP BY 查询得到各状态的数量
+func (db *DB) GetTorrentStats(ctx context.Context) (TorrentStats, error) {
+	var rows []struct {
+		Downloaded model.DownloadStatus
+		Renamed    bool
+		Count      int64
+	}
+	var stats TorrentStats
+	err := db.WithContext(ctx).Model(&model.Torrent{}).
+		Select("downloaded, renamed, COUNT(*) AS count").
+		Group("downloaded, renamed").
+		Scan(&rows).Error
+	if err != nil {
+		return stats, err
+	}
+	for _, row := range rows {
+		stats.Total += row.Count
+		switch row.Downloaded {
+		case model.DownloadNone:
+			stats.None += row.Count
+		case model.DownloadSending:
+			stats.Sending += row.Count
+		case model.DownloadDone:
+			stats.Downloaded += row.Count
+			if !row.Renamed {
+				stats.Unrenamed += row.Count
+			}
+		case model.DownloadError:
+			stats.Failed += row.Count
+		case model.DownloadReplaced:
+			stats.Replaced += row.Count
+		case model.DownloadHeld:
+			stats.Held += row.Count
+		}
+	}
+	return stats, nil
+}
+
+// GetBangumiStats 获取番剧统计信息
+func (db *DB) GetBangumiStats(ctx context.Context) (BangumiStats, error) {
+	var stats BangumiStats
+	err := db.WithContext(ctx).Model(&model.Bangumi{}).
+		Select("COUNT(*) AS total, " +
+			"COALESCE(SUM(CASE WHEN deleted THEN 0 ELSE 1 END), 0) AS active, " +
+			"COALESCE(SUM(CASE WHEN deleted THEN 1 ELSE 0 END), 0) AS deleted").
+		Scan(&stats).Error
+	return stats, err
+}
+
+// BangumiDownloadStats 单个番剧的下载统计
+// 集数需要解析种子名, 已有集数和最新一集见 refresh.BangumiProgress
+type BangumiDownloadStats struct {
+	BangumiID    int       `json:"bangumi_id"`
+	EpisodeCount int       `json:"episode_count"` // TMDB 的总集数, 没有时为 0
+	Torrents     int64     `json:"torrents"`
+	Downloaded   int64     `json:"downloaded"` // 已发送到下载器或下载完成
+	Failed       int64     `json:"failed"`
+	Size         int64     `json:"size"`          // 下载完成的种子大小之和(字节)
+	LastDownload time.Time `json:"last_download"` // 最近一个下载的种子入库的时间, 没有时为零值
+}
+
+// RSSDownloadStats 一个 RSS 订阅下所有番剧的下载统计, 不包括已删除的番剧
+type RSSDownloadStats struct {
+	RSSLink      string    `json:"rss_link"`
+	Bangumis     int64     `json:"bangumis"`
+	Torrents     int64     `json:"torrents"`
+	Downloaded   int64     `json:"downloaded"`
+	LastDownload time.Time `json:"last_download"`
+}
+
+// downloadedStatuses 计入已下载的状态, 与下载进度的统计一致
+var downloadedStatuses = []model.DownloadStatus{model.DownloadSending, model.DownloadDone}
+
+// aggregateTime 聚合查询得到的时间
+// sqlite 的 MAX(created_at) 没有列类型, 驱动返回文本, 其他数据库直接返回时间
+type aggregateTime struct {
+	time.Time
+}
+
+// aggregateTimeLayouts sqlite 驱动写入时间的格式, 以及常见的文本格式
+var aggregateTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+// Scan 实现 sql.Scanner
+func (t *aggregateTime) Scan(v any) error {
+	switch v := v.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+	return fmt.Errorf("无法解析时间: %T", v)
+}
+
+// Value 实现 driver.Valuer, gorm 解析结构体时要求字段同时实现 Scanner 和 Valuer
+func (t aggregateTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+func (t *aggregateTime) parse(s string) error {
+	for _, layout := range aggregateTimeLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("无法解析时间: %q", s)
+}
+
+// BangumiDownloadStats 获取番剧的下载统计, 番剧不存在时返回 ErrNotFound
+func (db *DB) BangumiDownloadStats(ctx context.Context, bangumiID int) (BangumiDownloadStats, error) {
+	bangumi, err := db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return BangumiDownloadStats{}, err
+	}
+	stats := BangumiDownloadStats{BangumiID: bangumi.ID}
+	if bangumi.TmdbItem != nil {
+		stats.EpisodeCount, _, _ = bangumi.TmdbItem.SeasonEpisodes(bangumi.Season)
+	}
+
+	var row struct {
+		Torrents     int64
+		Downloaded   int64
+		Failed       int64
+		Size         int64
+		LastDownload aggregateTime
+	}
+	err = db.WithContext(ctx).Model(&model.Torrent{}).
+		Select("COUNT(*) AS torrents, "+
+			"COALESCE(SUM(CASE WHEN downloaded IN ? THEN 1 ELSE 0 END), 0) AS downloaded, "+
+			"COALESCE(SUM(CASE WHEN downloaded = ? THEN 1 ELSE 0 END), 0) AS failed, "+
+			"COALESCE(SUM(CASE WHEN downloaded = ? THEN size ELSE 0 END), 0) AS size, "+
+			"MAX(CASE WHEN downloaded IN ? THEN created_at END) AS last_download",
+			downloadedStatuses, model.DownloadError, model.DownloadDone, downloadedStatuses).
+		Where("bangumi_id = ?", bangumiID).
+		Scan(&row).Error
+	if err != nil {
+		return stats, err
+	}
+	stats.Torrents = row.Torrents
+	stats.Downloaded = row.Downloaded
+	stats.Failed = row.Failed
+	stats.Size = row.Size
+	stats.LastDownload = row.LastDownload.Time
+	return stats, nil
+}
+
+// ListRSSDownloadStats 按 RSS 订阅汇总下载统计, 没有关联 RSS 的番剧汇总在空链接下
+func (db *DB) ListRSSDownloadStats(ctx context.Context) ([]RSSDownloadStats, error) {
+	var rows []struct {
+		RSSLink      string
+		Bangumis     int64
+		Torrents     int64
+		Downloaded   int64
+		LastDownload aggregateTime
+	}
+	err := db.WithContext(ctx).Model(&model.Bangumi{}).
+		Select("bangumis.rss_link AS rss_link, "+
+			"COUNT(DISTINCT bangumis.id) AS bangumis, "+
+			"COUNT(torrents.bangumi_id) AS torrents, "+
+			"COALESCE(SUM(CASE WHEN torrents.downloaded IN ? THEN 1 ELSE 0 END), 0) AS downloaded, "+
+			"MAX(CASE WHEN torrents.downloaded IN ? THEN torrents.created_at END) AS last_download",
+			downloadedStatuses, downloadedStatuses).
+		Joins("LEFT JOIN torrents ON torrents.bangumi_id = bangumis.id").
+		Where("bangumis.deleted = ?", false).
+		Group("bangumis.rss_link").
+		Order("bangumis.rss_link").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]RSSDownloadStats, len(rows))
+	for i, row := range rows {
+		stats[i] = RSSDownloadStats{
+			RSSLink:      row.RSSLink,
+			Bangumis:     row.Bangumis,
+			Torrents:     row.Torrents,
+			Downloaded:   row.Downloaded,
+			LastDownload: row.LastDownload.Time,
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestGetTorrentStats(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	seed := []struct {
+		status  model.DownloadStatus
+		renamed bool
+		n       int
+	}{
+		{model.DownloadNone, false, 2},
+		{model.DownloadSending, false, 3},
+		{model.DownloadDone, true, 4},
+		{model.DownloadDone, false, 1},
+		{model.DownloadError, false, 2},
+	}
+	i := 0
+	for _, s := range seed {
+		for range s.n {
+			i++
+			torrent := &model.Torrent{
+				Link:       fmt.Sprintf("https://example.com/%d.torrent", i),
+				Name:       fmt.Sprintf("torrent %d", i),
+				Downloaded: s.status,
+				Renamed:    s.renamed,
+			}
+			if err := db.Create(torrent).Error; err != nil {
+				t.Fatalf("Failed to create torrent: %v", err)
+			}
+		}
+	}
+
+	stats, err := db.GetTorrentStats(ctx)
+	if err != nil {
+		t.Fatalf("GetTorrentStats failed: %v", err)
+	}
+	want := TorrentStats{Total: 12, None: 2, Sending: 3, Downloaded: 5, Failed: 2, Unrenamed: 1}
+	if stats != want {
+		t.Fatalf("Expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestGetBangumiStats(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	stats, err := db.GetBangumiStats(ctx)
+	if err != nil {
+		t.Fatalf("GetBangumiStats on empty table failed: %v", err)
+	}
+	if stats != (BangumiStats{}) {
+		t.Fatalf("Expected zero stats on empty table, got %+v", stats)
+	}
+
+	for i, deleted := range []bool{false, false, true} {
+		b := &model.Bangumi{OfficialTitle: fmt.Sprintf("番剧 %d", i), Season: 1, Deleted: deleted}
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+	stats, err = db.GetBangumiStats(ctx)
+	if err != nil {
+		t.Fatalf("GetBangumiStats failed: %v", err)
+	}
+	want := BangumiStats{Total: 3, Active: 2, Deleted: 1}
+	if stats != want {
+		t.Fatalf("Expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestDownloadStats(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	rss := "https://mikanani.me/RSS/MyBangumi?token=test"
+	makeine := &model.Bangumi{OfficialTitle: "败犬女主太多了！", RSSLink: rss, TmdbItem: &model.TmdbItem{ID: 241535, EpisodeCount: 12}}
+	summer := &model.Bangumi{OfficialTitle: "夏日口袋", RSSLink: rss}
+	other := &model.Bangumi{OfficialTitle: "没有种子"}
+	for _, b := range []*model.Bangumi{makeine, summer, other} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	base := time.Date(2025, 8, 29, 20, 0, 0, 0, time.UTC)
+	torrents := []*model.Torrent{
+		{Link: "1", Name: "败犬 01", BangumiID: makeine.ID, CreatedAt: base, Downloaded: model.DownloadDone, Size: 100},
+		{Link: "2", Name: "败犬 02", BangumiID: makeine.ID, CreatedAt: base.Add(time.Hour), Downloaded: model.DownloadSending, Size: 50},
+		{Link: "3", Name: "败犬 03", BangumiID: makeine.ID, CreatedAt: base.Add(2 * time.Hour), Downloaded: model.DownloadError},
+		{Link: "4", Name: "夏日口袋 01", BangumiID: summer.ID, CreatedAt: base.Add(3 * time.Hour), Downloaded: model.DownloadDone},
+	}
+	if err := db.CreateTorrents(ctx, torrents); err != nil {
+		t.Fatalf("CreateTorrents failed: %v", err)
+	}
+
+	stats, err := db.BangumiDownloadStats(ctx, makeine.ID)
+	if err != nil {
+		t.Fatalf("BangumiDownloadStats failed: %v", err)
+	}
+	want := BangumiDownloadStats{BangumiID: makeine.ID, EpisodeCount: 12, Torrents: 3, Downloaded: 2, Failed: 1, Size: 100}
+	last := stats.LastDownload
+	stats.LastDownload = time.Time{}
+	if stats != want || !last.Equal(base.Add(time.Hour)) {
+		t.Fatalf("BangumiDownloadStats() = %+v, last %v, want %+v, last %v", stats, last, want, base.Add(time.Hour))
+	}
+
+	stats, err = db.BangumiDownloadStats(ctx, other.ID)
+	if err != nil || stats.Torrents != 0 || !stats.LastDownload.IsZero() {
+		t.Fatalf("BangumiDownloadStats() without torrents = %+v, %v", stats, err)
+	}
+	if _, err := db.BangumiDownloadStats(ctx, 9999); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for unknown bangumi, got %v", err)
+	}
+
+	rssStats, err := db.ListRSSDownloadStats(ctx)
+	if err != nil {
+		t.Fatalf("ListRSSDownloadStats failed: %v", err)
+	}
+	if len(rssStats) != 2 {
+		t.Fatalf("Expected 2 rss groups, got %+v", rssStats)
+	}
+	if got := rssStats[0]; got.RSSLink != "" || got.Bangumis != 1 || got.Torrents != 0 || !got.LastDownload.IsZero() {
+		t.Errorf("stats without rss = %+v", got)
+	}
+	got := rssStats[1]
+	if got.RSSLink != rss || got.Bangumis != 2 || got.Torrents != 4 || got.Downloaded != 3 || !got.LastDownload.Equal(base.Add(3*time.Hour)) {
+		t.Errorf("stats of %s = %+v", rss, got)
+	}
+}
@@ -0,0 +1,21 @@
+package database
+
+import "testing"
+
+// NewTestDB 创建测试用的内存数据库并依次加载 fixtures, 测试结束时自动关闭
+// 包内测试不能导入 dbtest(会循环导入), 其他包的测试使用 dbtest.New
+func NewTestDB(t testing.TB, fixtures ...string) *DB {
+	t.Helper()
+	dsn := ":memory:"
+	db, err := NewDB(&dsn)
+	if err != nil {
+		t.Fatalf("创建测试数据库失败: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, path := range fixtures {
+		if err := LoadFixtures(db, path); err != nil {
+			t.Fatalf("加载测试数据失败: %v", err)
+		}
+	}
+	return db
+}
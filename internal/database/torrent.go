@@ -3,79 +3,358 @@ package database
 import (
 	"context"
 	"log/slog"
+	"strings"
+	"time"
 
 	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ============ Torrent 相关方法 ============
 
-// CreateTorrent 创建种子
+// torrentLink 按主键查找种子的条件, Link 列名有大写, 由 gorm 按数据库的规则引用
+func torrentLink(link string) clause.Expression {
+	return clause.Eq{Column: clause.Column{Name: "Link"}, Value: link}
+}
+
+// torrentLinks 按多个主键查找种子的条件, 见 torrentLink
+func torrentLinks(links []string) clause.Expression {
+	values := make([]any, len(links))
+	for i, link := range links {
+		values[i] = link
+	}
+	return clause.IN{Column: clause.Column{Name: "Link"}, Values: values}
+}
+
+// CreateTorrent 创建种子, 以 link 为准, 重复添加是幂等的
+// 已存在的种子只补充 RSS 带来的元数据(主页、大小、发布时间、info hash、guid 和描述, 新值为空时保留旧值),
+// 名称和下载进度(Downloaded/Renamed/DownloadUID 等)保持不变
 func (db *DB) CreateTorrent(ctx context.Context, torrent *model.Torrent) error {
-	// 先检查是否存在相同 link 的种子
-	var existing model.Torrent
-	err := db.WithContext(ctx).Where("link = ?", torrent.Link).First(&existing).Error
-	if err == nil {
-		slog.Info("[database] 种子已存在，跳过创建", "link", torrent.Link)
+	return db.WithContext(ctx).Clauses(torrentUpsert()).Create(torrent).Error
+}
+
+// ListTorrentsByInfoHash 返回 info hash 相同的种子, 同一个种子在不同镜像站的链接不同, 按入库时间排序
+// hash 为空时返回空列表
+func (db *DB) ListTorrentsByInfoHash(ctx context.Context, hash string) ([]*model.Torrent, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	var torrents []*model.Torrent
+	err := db.WithContext(ctx).Where("info_hash = ?", strings.ToLower(hash)).Order("created_at").Find(&torrents).Error
+	return torrents, err
+}
+
+// torrentUpsert 种子以 link 去重时的冲突处理, 见 CreateTorrent
+func torrentUpsert() clause.OnConflict {
+	return clause.OnConflict{
+		Columns: []clause.Column{{Name: "Link"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"homepage":    excludedIfSet("torrents", "homepage"),
+			"size":        gorm.Expr("CASE WHEN ? > 0 THEN ? ELSE torrents.size END", excludedColumn("size"), excludedColumn("size")),
+			"pub_date":    gorm.Expr("COALESCE(NULLIF(?, ?), torrents.pub_date)", excludedColumn("pub_date"), time.Time{}),
+			"info_hash":   excludedIfSet("torrents", "info_hash"),
+			"guid":        excludedIfSet("torrents", "guid"),
+			"description": excludedIfSet("torrents", "description"),
+		}),
+	}
+}
+
+// torrentBatchSize CreateTorrents 每条 INSERT 的行数, 避免超过 sqlite 的变量数限制
+const torrentBatchSize = 100
+
+// CreateTorrents 在一个事务中批量创建种子, 冲突处理与 CreateTorrent 相同
+// 同一批中重复的 link 只保留第一个; 关联了 Bangumi 的种子使用它的 ID 作为 BangumiID,
+// 番剧本身不会被写入
+func (db *DB) CreateTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	seen := make(map[string]struct{}, len(torrents))
+	batch := make([]*model.Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		if _, ok := seen[t.Link]; ok {
+			continue
+		}
+		seen[t.Link] = struct{}{}
+		if t.Bangumi != nil && t.Bangumi.ID != 0 {
+			t.BangumiID = t.Bangumi.ID
+		}
+		batch = append(batch, t)
+	}
+	if len(batch) == 0 {
 		return nil
 	}
-	// 创建新种子
-	err = db.WithContext(ctx).Create(torrent).Error
-	return err
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(torrentUpsert()).Omit(clause.Associations).
+			CreateInBatches(batch, torrentBatchSize).Error
+	})
 }
 
 // AddTorrentDownload 种子标记为已下载
 func (db *DB) AddTorrentDownload(ctx context.Context, link string) error {
 	t := model.Torrent{}
-	err := db.WithContext(ctx).Where("link = ?", link).First(&t).Error
+	err := db.WithContext(ctx).Where(torrentLink(link)).First(&t).Error
 	if err != nil {
 		slog.Error("[database] 标记种子已下载失败，未找到种子", "link", link, "error", err)
 		return err
 	}
 	t.Downloaded = model.DownloadDone
-	err = db.WithContext(ctx).Save(&t).Error
-	return err
+	if err := db.WithContext(ctx).Save(&t).Error; err != nil {
+		return err
+	}
+	db.recordDownloadEvent(ctx, link, t.BangumiID, model.ActionDownloaded, "")
+	db.syncEpisodes(ctx, link, model.EpisodeDownloaded, model.EpisodeDownloading)
+	db.publish(TorrentStatusChanged{Link: link, Status: t.Downloaded})
+	return nil
 }
 
+// AddTorrentError 种子标记为下载出错
+// 失败原因在任务失败时由调用方通过 RecordDownloadEvent 记录, 这里不重复记录历史
 func (db *DB) AddTorrentError(ctx context.Context, link string) error {
 	t := model.Torrent{}
-	err := db.WithContext(ctx).Where("link = ?", link).First(&t).Error
+	err := db.WithContext(ctx).Where(torrentLink(link)).First(&t).Error
 	if err != nil {
 		slog.Error("[database] 标记种子下载出错失败，未找到种子", "link", link, "error", err)
 		return err
 	}
 	t.Downloaded = model.DownloadError
-	err = db.WithContext(ctx).Save(&t).Error
-	return err
+	if err := db.WithContext(ctx).Save(&t).Error; err != nil {
+		return err
+	}
+	db.syncEpisodes(ctx, link, model.EpisodeMissing, model.EpisodeDownloading)
+	db.publish(TorrentStatusChanged{Link: link, Status: t.Downloaded})
+	return nil
+}
+
+// MarkTorrentReplaced 标记种子已被修正版或更好的版本替代, 它提供的集数变回缺失, 等新的种子入队
+func (db *DB) MarkTorrentReplaced(ctx context.Context, link string) error {
+	result := db.WithContext(ctx).Model(&model.Torrent{}).Where(torrentLink(link)).
+		Update("downloaded", model.DownloadReplaced)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	db.recordDownloadEvent(ctx, link, 0, model.ActionReplaced, "")
+	db.syncEpisodes(ctx, link, model.EpisodeMissing)
+	db.publish(TorrentStatusChanged{Link: link, Status: model.DownloadReplaced})
+	return nil
+}
+
+// ListHeldTorrents 在挑选窗口中等待的种子, 按入库时间排序
+func (db *DB) ListHeldTorrents(ctx context.Context) ([]*model.Torrent, error) {
+	var torrents []*model.Torrent
+	err := db.WithContext(ctx).Where("downloaded = ?", model.DownloadHeld).Order("created_at").Find(&torrents).Error
+	return torrents, err
+}
+
+// ReleaseHeldTorrent 挑选窗口结束, 选中的种子改为未下载, 之后入队下载; 种子不在等待中时返回 ErrNotFound
+func (db *DB) ReleaseHeldTorrent(ctx context.Context, link string) error {
+	result := db.WithContext(ctx).Model(&model.Torrent{}).
+		Where(torrentLink(link)).Where("downloaded = ?", model.DownloadHeld).
+		Update("downloaded", model.DownloadNone)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 func (db *DB) TorrentRenamed(ctx context.Context, link string) error {
 	t := model.Torrent{}
-	err := db.WithContext(ctx).Where("link = ?", link).First(&t).Error
+	err := db.WithContext(ctx).Where(torrentLink(link)).First(&t).Error
 	if err != nil {
 		slog.Error("[database] 标记种子已重命名失败，未找到种子", "link", link, "error", err)
 		return err
 	}
 	t.Renamed = true
-	err = db.WithContext(ctx).Save(&t).Error
-	return err
+	if err := db.WithContext(ctx).Save(&t).Error; err != nil {
+		return err
+	}
+	db.recordDownloadEvent(ctx, link, t.BangumiID, model.ActionRenamed, "")
+	db.syncEpisodes(ctx, link, model.EpisodeRenamed, model.EpisodeDownloading, model.EpisodeDownloaded)
+	return nil
 }
 
 // DeleteTorrent 删除种子
 func (db *DB) DeleteTorrent(ctx context.Context, link string) error {
-	return db.WithContext(ctx).Where("link = ?", link).Delete(&model.Torrent{}).Error
+	return db.deleteTorrents(ctx, torrentLink(link))
 }
 
-// AddTorrentDUID 为种子添加下载 UID
-func (db *DB) AddTorrentDUID(ctx context.Context, link string, guid string) error {
+// deleteTorrents 删除满足条件的种子, 并为每个被删除的种子记录下载历史, 还在下载中的剧集标记为缺失
+func (db *DB) deleteTorrents(ctx context.Context, query any, args ...any) error {
+	var deleted []*model.Torrent
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(query, args...).Find(&deleted).Error; err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return nil
+		}
+		return tx.Where(query, args...).Delete(&model.Torrent{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range deleted {
+		db.recordDownloadEvent(ctx, t.Link, t.BangumiID, model.ActionDeleted, "")
+		db.syncEpisodes(ctx, t.Link, model.EpisodeMissing, model.EpisodeDownloading)
+	}
+	return nil
+}
+
+// AddTorrentDUID 为种子添加下载 UID, downloader 为添加种子的下载器名称, 为空表示默认下载器
+func (db *DB) AddTorrentDUID(ctx context.Context, link string, downloader string, guid string) error {
 	t := model.Torrent{}
-	err := db.WithContext(ctx).Where("link = ?", link).First(&t).Error
+	err := db.WithContext(ctx).Where(torrentLink(link)).First(&t).Error
 	if err != nil {
 		slog.Error("[database] 添加种子 UID 失败，未找到种子", "link", link, "error", err)
 		return err
 	}
 	t.DownloadUID = guid
+	t.Downloader = downloader
 	// 标记为已发送到下载器
 	t.Downloaded = model.DownloadSending
-	err = db.WithContext(ctx).Save(&t).Error
-	return err
+	if err := db.WithContext(ctx).Save(&t).Error; err != nil {
+		return err
+	}
+	db.recordDownloadEvent(ctx, link, t.BangumiID, model.ActionSent, "")
+	db.publish(TorrentStatusChanged{Link: link, Status: t.Downloaded})
+	return nil
+}
+
+// TorrentDisplay 前端下载列表的一行, 种子信息加上所属番剧和 TMDB 的信息
+// 没有关联番剧或 TMDB 的种子对应字段为零值
+type TorrentDisplay struct {
+	Link          string               `json:"link"`
+	Name          string               `json:"name"`
+	DownloadUID   string               `json:"download_uid"`
+	Downloaded    model.DownloadStatus `json:"downloaded"`
+	Renamed       bool                 `json:"renamed"`
+	Size          int64                `json:"size"`
+	CreatedAt     time.Time            `json:"created_at"`
+	BangumiID     int                  `json:"bangumi_id"`
+	OfficialTitle string               `json:"official_title"`
+	Season        int                  `json:"season"`
+	PosterLink    string               `json:"poster_link"`
+	EpisodeCount  int                  `json:"episode_count"`
+}
+
+// ListTorrentDisplay 分页获取下载列表, 按创建时间倒序, 同时返回种子总数
+// 番剧和 TMDB 信息通过一次 LEFT JOIN 查询得到, 不做预加载
+func (db *DB) ListTorrentDisplay(ctx context.Context, offset, limit int) ([]TorrentDisplay, int64, error) {
+	var total int64
+	if err := db.WithContext(ctx).Model(&model.Torrent{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []TorrentDisplay
+	err := db.WithContext(ctx).Model(&model.Torrent{}).
+		Select(db.quote("torrents.Link") + ` AS link, torrents.name, torrents.download_uid, torrents.downloaded, torrents.renamed,
+			torrents.size, torrents.created_at, torrents.bangumi_id,
+			COALESCE(bangumis.official_title, '') AS official_title,
+			COALESCE(bangumis.season, 0) AS season,
+			COALESCE(bangumis.poster_link, '') AS poster_link,
+			COALESCE(tmdb_items.episode_count, 0) AS episode_count`).
+		Joins("LEFT JOIN bangumis ON bangumis.id = torrents.bangumi_id").
+		Joins("LEFT JOIN tmdb_items ON tmdb_items.id = bangumis.tmdb_id").
+		Order("torrents.created_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return rows, total, nil
+}
+
+// TorrentOrder 种子列表的排序字段
+type TorrentOrder string
+
+const (
+	TorrentOrderCreatedAt TorrentOrder = "created_at" // 入库时间, 默认
+	TorrentOrderPubDate   TorrentOrder = "pub_date"   // RSS 发布时间
+)
+
+// TorrentQuery 种子列表的查询条件, 零值表示不过滤, 按入库时间倒序返回全部种子
+type TorrentQuery struct {
+	OrderBy TorrentOrder
+	// Ascending 为 true 时正序, 默认倒序(最新的在前)
+	Ascending bool
+	// Statuses 下载状态, 满足其一即可
+	Statuses  []model.DownloadStatus
+	Renamed   *bool
+	BangumiID int
+	// RSSLink 只返回属于该 RSS 下番剧的种子
+	RSSLink string
+	// Name 种子名称包含的文本, 不区分大小写
+	Name string
+	// Limit 不大于 0 时不限制数量
+	Limit  int
+	Offset int
+}
+
+// where 添加过滤条件, 不包括排序和分页, 列表和计数共用
+func (opts TorrentQuery) where(tx *gorm.DB) *gorm.DB {
+	if len(opts.Statuses) > 0 {
+		tx = tx.Where("downloaded IN ?", opts.Statuses)
+	}
+	if opts.Renamed != nil {
+		tx = tx.Where("renamed = ?", *opts.Renamed)
+	}
+	if opts.BangumiID != 0 {
+		tx = tx.Where("bangumi_id = ?", opts.BangumiID)
+	}
+	if opts.RSSLink != "" {
+		tx = tx.Where("bangumi_id IN (?)", tx.Session(&gorm.Session{NewDB: true}).
+			Model(&model.Bangumi{}).Select("id").Where("rss_link = ?", opts.RSSLink))
+	}
+	if name := strings.ToLower(strings.TrimSpace(opts.Name)); name != "" {
+		tx = tx.Where("LOWER(name) LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(name)+"%")
+	}
+	return tx
+}
+
+// ListTorrentFiltered 按条件查询种子, 查询条件只在设置时才加入
+func (db *DB) ListTorrentFiltered(ctx context.Context, opts TorrentQuery) ([]*model.Torrent, error) {
+	query := db.WithContext(ctx).Model(&model.Torrent{}).Scopes(opts.where)
+
+	column := opts.OrderBy
+	if column != TorrentOrderPubDate {
+		column = TorrentOrderCreatedAt
+	}
+	// 时间相同时按 link 排序, 保证分页稳定
+	query = query.Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+		{Column: clause.Column{Name: string(column)}, Desc: !opts.Ascending},
+		{Column: clause.Column{Name: "Link"}, Desc: !opts.Ascending},
+	}})
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+	if opts.Offset > 0 {
+		query = query.Offset(opts.Offset)
+	}
+
+	var torrents []*model.Torrent
+	err := query.Find(&torrents).Error
+	return torrents, err
+}
+
+// ListTorrentPage 分页查询种子, 同时返回满足条件的种子总数(不受 Limit/Offset 影响)
+func (db *DB) ListTorrentPage(ctx context.Context, opts TorrentQuery) ([]*model.Torrent, int64, error) {
+	var total int64
+	if err := db.WithContext(ctx).Model(&model.Torrent{}).Scopes(opts.where).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	torrents, err := db.ListTorrentFiltered(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return torrents, total, nil
+}
+
+// ListTorrent 获取所有种子, 最新入库的在前
+func (db *DB) ListTorrent(ctx context.Context) ([]*model.Torrent, error) {
+	return db.ListTorrentFiltered(ctx, TorrentQuery{})
 }
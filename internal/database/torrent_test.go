@@ -2,9 +2,14 @@ package database
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"goto-bangumi/internal/model"
+
+	"gorm.io/gorm"
 )
 
 // TestAddTorrent 测试 Torrent 相关数据库操作，每个子测试验证一个函数的功能
@@ -128,6 +133,45 @@ func TestAddTorrent(t *testing.T) {
 		}
 	})
 
+	// 重新添加已下载的种子, 下载进度不能被新解析的零值覆盖, 只补充元数据
+	t.Run("ReinsertKeepsProgress", func(t *testing.T) {
+		pubDate := time.Date(2025, 8, 29, 20, 0, 0, 0, time.UTC)
+		again := model.Torrent{
+			Link:    torrents[2].Link,
+			Name:    torrents[2].Name,
+			Size:    512 * 1024 * 1024,
+			PubDate: pubDate,
+		}
+		if err := db.CreateTorrent(ctx, &again); err != nil {
+			t.Fatalf("Re-insert CreateTorrent failed: %v", err)
+		}
+		got, err := db.GetTorrentByURL(ctx, torrents[2].Link)
+		if err != nil {
+			t.Fatalf("GetTorrentByURL failed: %v", err)
+		}
+		if got.Downloaded != torrents[2].Downloaded || got.Renamed != torrents[2].Renamed || got.DownloadUID != torrents[2].DownloadUID {
+			t.Fatalf("Download progress was reset: downloaded=%d renamed=%v duid=%q", got.Downloaded, got.Renamed, got.DownloadUID)
+		}
+		if got.Homepage != torrents[2].Homepage {
+			t.Fatalf("Expected homepage to be kept, got %q", got.Homepage)
+		}
+		if got.Size != again.Size || !got.PubDate.Equal(pubDate) {
+			t.Fatalf("Expected metadata to be updated, got size=%d pubDate=%v", got.Size, got.PubDate)
+		}
+
+		// 再次添加不带元数据的种子, 已有的元数据保留
+		if err := db.CreateTorrent(ctx, &model.Torrent{Link: torrents[2].Link}); err != nil {
+			t.Fatalf("Re-insert CreateTorrent failed: %v", err)
+		}
+		got, err = db.GetTorrentByURL(ctx, torrents[2].Link)
+		if err != nil {
+			t.Fatalf("GetTorrentByURL failed: %v", err)
+		}
+		if got.Size != again.Size || !got.PubDate.Equal(pubDate) || got.Name != torrents[2].Name {
+			t.Fatalf("Expected metadata to be kept, got name=%q size=%d pubDate=%v", got.Name, got.Size, got.PubDate)
+		}
+	})
+
 	t.Run("GetByURL", func(t *testing.T) {
 		got, err := db.GetTorrentByURL(ctx, torrents[0].Link)
 		if err != nil {
@@ -140,7 +184,7 @@ func TestAddTorrent(t *testing.T) {
 
 	// AddDUID 同时将 Downloaded 置为 DownloadSending
 	t.Run("AddDUID", func(t *testing.T) {
-		if err := db.AddTorrentDUID(ctx, torrents[1].Link, testDUID); err != nil {
+		if err := db.AddTorrentDUID(ctx, torrents[1].Link, "", testDUID); err != nil {
 			t.Fatalf("Failed to add torrent DUID: %v", err)
 		}
 		got, err := db.GetTorrentByURL(ctx, torrents[1].Link)
@@ -264,3 +308,418 @@ func TestAddTorrent(t *testing.T) {
 		}
 	})
 }
+
+func TestListTorrentDisplay(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	withTmdb := model.Bangumi{
+		OfficialTitle: "败犬女主太多了！",
+		Season:        1,
+		PosterLink:    "https://mikanani.me/images/Bangumi/202407/1b5c0a8b.jpg",
+		TmdbItem:      &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！", EpisodeCount: 12},
+	}
+	withoutTmdb := model.Bangumi{OfficialTitle: "夏日口袋", Season: 2}
+	for _, b := range []*model.Bangumi{&withTmdb, &withoutTmdb} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	base := time.Date(2025, 8, 29, 20, 0, 0, 0, time.UTC)
+	torrents := []*model.Torrent{
+		{Link: "https://mikanani.me/Download/1.torrent", Name: "败犬 01", BangumiID: withTmdb.ID, CreatedAt: base},
+		{Link: "https://mikanani.me/Download/2.torrent", Name: "败犬 02", BangumiID: withTmdb.ID, CreatedAt: base.Add(time.Hour), Downloaded: model.DownloadDone},
+		{Link: "https://mikanani.me/Download/3.torrent", Name: "夏日口袋 01", BangumiID: withoutTmdb.ID, CreatedAt: base.Add(2 * time.Hour)},
+		{Link: "https://mikanani.me/Download/4.torrent", Name: "没有番剧", CreatedAt: base.Add(3 * time.Hour)},
+	}
+	for _, torrent := range torrents {
+		if err := db.CreateTorrent(ctx, torrent); err != nil {
+			t.Fatalf("Failed to create torrent: %v", err)
+		}
+	}
+
+	rows, total, err := db.ListTorrentDisplay(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("ListTorrentDisplay failed: %v", err)
+	}
+	if total != 4 || len(rows) != 4 {
+		t.Fatalf("Expected 4 rows and total 4, got %d rows, total %d", len(rows), total)
+	}
+	// 按创建时间倒序
+	if rows[0].Name != "没有番剧" || rows[3].Name != "败犬 01" {
+		t.Fatalf("Unexpected order: %q ... %q", rows[0].Name, rows[3].Name)
+	}
+	if rows[0].OfficialTitle != "" || rows[0].EpisodeCount != 0 {
+		t.Errorf("Torrent without bangumi should have empty fields, got %+v", rows[0])
+	}
+	if rows[1].OfficialTitle != "夏日口袋" || rows[1].Season != 2 || rows[1].EpisodeCount != 0 {
+		t.Errorf("Unexpected row for bangumi without tmdb: %+v", rows[1])
+	}
+	if rows[2].OfficialTitle != "败犬女主太多了！" || rows[2].EpisodeCount != 12 ||
+		rows[2].PosterLink != withTmdb.PosterLink || rows[2].Downloaded != model.DownloadDone {
+		t.Errorf("Unexpected row for bangumi with tmdb: %+v", rows[2])
+	}
+
+	// 分页
+	rows, total, err = db.ListTorrentDisplay(ctx, 2, 1)
+	if err != nil {
+		t.Fatalf("ListTorrentDisplay failed: %v", err)
+	}
+	if total != 4 || len(rows) != 1 || rows[0].Name != "败犬 02" {
+		t.Fatalf("Unexpected page: total %d, rows %+v", total, rows)
+	}
+}
+
+func TestListTorrentFiltered(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	ctx := context.Background()
+
+	makeine := model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1, RSSLink: "https://mikanani.me/RSS/Bangumi?bangumiId=3391"}
+	summer := model.Bangumi{OfficialTitle: "夏日口袋", Season: 2}
+	for _, b := range []*model.Bangumi{&makeine, &summer} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatalf("Failed to save bangumi: %v", err)
+		}
+	}
+
+	// 入库时间和发布时间的顺序相反, 用来区分两种排序
+	base := time.Date(2025, 8, 29, 20, 0, 0, 0, time.UTC)
+	torrents := []*model.Torrent{
+		{Link: "https://mikanani.me/Download/1.torrent", Name: "败犬 01", BangumiID: makeine.ID, CreatedAt: base, PubDate: base.Add(3 * time.Hour), Downloaded: model.DownloadDone, Renamed: true},
+		{Link: "https://mikanani.me/Download/2.torrent", Name: "败犬 02", BangumiID: makeine.ID, CreatedAt: base.Add(time.Hour), PubDate: base.Add(2 * time.Hour), Downloaded: model.DownloadDone},
+		{Link: "https://mikanani.me/Download/3.torrent", Name: "败犬 03", BangumiID: makeine.ID, CreatedAt: base.Add(2 * time.Hour), PubDate: base.Add(time.Hour), Downloaded: model.DownloadError},
+		{Link: "https://mikanani.me/Download/4.torrent", Name: "夏日口袋 01", BangumiID: summer.ID, CreatedAt: base.Add(3 * time.Hour), PubDate: base, Downloaded: model.DownloadSending},
+	}
+	if err := db.CreateTorrents(ctx, torrents); err != nil {
+		t.Fatalf("CreateTorrents failed: %v", err)
+	}
+
+	renamed, notRenamed := true, false
+	tests := []struct {
+		name  string
+		query TorrentQuery
+		want  []string
+	}{
+		{"默认按入库时间倒序", TorrentQuery{}, []string{"夏日口袋 01", "败犬 03", "败犬 02", "败犬 01"}},
+		{"入库时间正序", TorrentQuery{Ascending: true}, []string{"败犬 01", "败犬 02", "败犬 03", "夏日口袋 01"}},
+		{"发布时间倒序", TorrentQuery{OrderBy: TorrentOrderPubDate}, []string{"败犬 01", "败犬 02", "败犬 03", "夏日口袋 01"}},
+		{"只看失败的", TorrentQuery{Statuses: []model.DownloadStatus{model.DownloadError}}, []string{"败犬 03"}},
+		{"多个状态", TorrentQuery{Statuses: []model.DownloadStatus{model.DownloadError, model.DownloadSending}}, []string{"夏日口袋 01", "败犬 03"}},
+		{"已重命名", TorrentQuery{Renamed: &renamed}, []string{"败犬 01"}},
+		{"已下载未重命名", TorrentQuery{Statuses: []model.DownloadStatus{model.DownloadDone}, Renamed: &notRenamed}, []string{"败犬 02"}},
+		{"指定番剧", TorrentQuery{BangumiID: summer.ID}, []string{"夏日口袋 01"}},
+		{"番剧和状态", TorrentQuery{BangumiID: makeine.ID, Statuses: []model.DownloadStatus{model.DownloadDone}}, []string{"败犬 02", "败犬 01"}},
+		{"分页", TorrentQuery{Limit: 2, Offset: 1}, []string{"败犬 03", "败犬 02"}},
+		{"只有 offset", TorrentQuery{Offset: 3}, []string{"败犬 01"}},
+		{"没有结果", TorrentQuery{BangumiID: summer.ID, Renamed: &renamed}, nil},
+		{"指定 RSS", TorrentQuery{RSSLink: makeine.RSSLink, Offset: 1}, []string{"败犬 02", "败犬 01"}},
+		{"名称包含", TorrentQuery{Name: "口袋"}, []string{"夏日口袋 01"}},
+		{"名称通配符转义", TorrentQuery{Name: "%"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.ListTorrentFiltered(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("ListTorrentFiltered failed: %v", err)
+			}
+			var names []string
+			for _, torrent := range got {
+				names = append(names, torrent.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Errorf("ListTorrentFiltered() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+
+	page, total, err := db.ListTorrentPage(ctx, TorrentQuery{Statuses: []model.DownloadStatus{model.DownloadDone}, Limit: 1})
+	if err != nil || total != 2 || len(page) != 1 || page[0].Name != "败犬 02" {
+		t.Errorf("ListTorrentPage() = %d torrents, total %d, %v, want 1 of 2", len(page), total, err)
+	}
+
+	all, err := db.ListTorrent(ctx)
+	if err != nil || len(all) != 4 || all[0].Name != "夏日口袋 01" {
+		t.Errorf("ListTorrent() = %d torrents, %v, want newest first", len(all), err)
+	}
+}
+
+func batchTorrents(n int, bangumi *model.Bangumi) []*model.Torrent {
+	torrents := make([]*model.Torrent, 0, n)
+	for i := range n {
+		torrents = append(torrents, &model.Torrent{
+			Link:    fmt.Sprintf("magnet:?xt=urn:btih:BATCH%03d", i),
+			Name:    fmt.Sprintf("[LoliHouse] Make Heroine ga Oosugiru! - %02d [WebRip 1080p]", i+1),
+			Bangumi: bangumi,
+		})
+	}
+	return torrents
+}
+
+func TestTorrentEntryMetadata(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t)
+
+	hash := "33fbab8f53fe4bad12f07afa5abdb7c4afa5956c"
+	mikan := "https://mikanani.me/Download/20240929/" + hash + ".torrent"
+	mirror := "https://mikanime.tv/Download/20240929/" + hash + ".torrent"
+	for _, link := range []string{mikan, mirror} {
+		err := db.CreateTorrent(ctx, &model.Torrent{Link: link, Name: "[ANi] 败北女角太多了！ - 12", InfoHash: hash, GUID: "guid-12", Description: "[351.8 MB]"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 再次写入时空的字段不会清空已有的信息
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: mikan, Name: "[ANi] 败北女角太多了！ - 12"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetTorrentByURL(ctx, mikan)
+	if err != nil || stored.InfoHash != hash || stored.GUID != "guid-12" || stored.Description != "[351.8 MB]" {
+		t.Errorf("GetTorrentByURL() = %+v, %v", stored, err)
+	}
+	torrents, err := db.ListTorrentsByInfoHash(ctx, strings.ToUpper(hash))
+	if err != nil || len(torrents) != 2 {
+		t.Errorf("ListTorrentsByInfoHash() = %d, %v, want 2", len(torrents), err)
+	}
+	if torrents, _ := db.ListTorrentsByInfoHash(ctx, ""); len(torrents) != 0 {
+		t.Errorf("ListTorrentsByInfoHash(\"\") = %d, want 0", len(torrents))
+	}
+}
+
+func TestCreateTorrents(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 统计 INSERT 语句的数量, 100 个种子应该只有一条
+	var inserts int
+	if err := db.Callback().Create().After("gorm:create").Register("test:count_inserts", func(tx *gorm.DB) {
+		if tx.Statement.Table == "torrents" {
+			inserts++
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	torrents := batchTorrents(100, bangumi)
+	// 重复的 link 只保留一个
+	torrents = append(torrents, &model.Torrent{Link: torrents[0].Link, Name: "duplicate"})
+	if err := db.CreateTorrents(ctx, torrents); err != nil {
+		t.Fatalf("CreateTorrents() error = %v", err)
+	}
+	if inserts != 1 {
+		t.Errorf("inserts = %d, want 1", inserts)
+	}
+
+	var count int64
+	db.Model(&model.Torrent{}).Count(&count)
+	if count != 100 {
+		t.Errorf("torrent count = %d, want 100", count)
+	}
+	stored, err := db.ListTorrentByBangumiID(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 100 {
+		t.Errorf("torrents of bangumi = %d, want 100", len(stored))
+	}
+	first, err := db.GetTorrentByURL(ctx, torrents[0].Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Name == "duplicate" {
+		t.Error("duplicate link overwrote the first torrent")
+	}
+
+	// 再次写入是幂等的
+	if err := db.CreateTorrents(ctx, batchTorrents(100, bangumi)); err != nil {
+		t.Fatalf("CreateTorrents() again error = %v", err)
+	}
+	db.Model(&model.Torrent{}).Count(&count)
+	if count != 100 {
+		t.Errorf("torrent count after second insert = %d, want 100", count)
+	}
+}
+
+func TestCheckNewTorrentsBatch(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	candidates := batchTorrents(250, bangumi)
+	// 隔一个入库一个
+	var stored []*model.Torrent
+	for i := 0; i < len(candidates); i += 2 {
+		stored = append(stored, candidates[i])
+	}
+	if err := db.CreateTorrents(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// 250 个链接按 torrentBatchSize 分成 3 次查询
+	var queries int
+	if err := db.Callback().Query().After("gorm:query").Register("test:count_queries", func(tx *gorm.DB) {
+		if tx.Statement.Table == "torrents" {
+			queries++
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	newOnes, err := db.CheckNewTorrents(ctx, candidates)
+	if err != nil {
+		t.Fatalf("CheckNewTorrents() error = %v", err)
+	}
+	if queries != 3 {
+		t.Errorf("queries = %d, want 3", queries)
+	}
+	if len(newOnes) != 125 {
+		t.Fatalf("new torrents = %d, want 125", len(newOnes))
+	}
+	for i, torrent := range newOnes {
+		if torrent != candidates[2*i+1] {
+			t.Fatalf("newOnes[%d] = %s, want %s", i, torrent.Link, candidates[2*i+1].Link)
+		}
+	}
+
+	empty, err := db.CheckNewTorrents(ctx, nil)
+	if err != nil || len(empty) != 0 {
+		t.Errorf("CheckNewTorrents(nil) = %v, %v, want empty", empty, err)
+	}
+}
+
+func BenchmarkCreateTorrents(b *testing.B) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		b.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	b.Run("CreateTorrent", func(b *testing.B) {
+		for b.Loop() {
+			for _, t := range batchTorrents(100, nil) {
+				if err := db.CreateTorrent(ctx, t); err != nil {
+					b.Fatal(err)
+				}
+			}
+			db.Exec("DELETE FROM torrents")
+		}
+	})
+	b.Run("CreateTorrents", func(b *testing.B) {
+		for b.Loop() {
+			if err := db.CreateTorrents(ctx, batchTorrents(100, nil)); err != nil {
+				b.Fatal(err)
+			}
+			db.Exec("DELETE FROM torrents")
+		}
+	})
+}
+
+func TestTorrentIndexes(t *testing.T) {
+	testdb := ":memory:"
+	db, err := NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	for _, idx := range []struct {
+		model any
+		name  string
+	}{
+		{&model.Torrent{}, "idx_torrent_status"},
+		{&model.Bangumi{}, "idx_bangumi_title"},
+	} {
+		if !db.Migrator().HasIndex(idx.model, idx.name) {
+			t.Errorf("index %s missing", idx.name)
+		}
+	}
+
+	// 查找未重命名种子的查询使用复合索引, 不是全表扫描
+	var plan []struct{ Detail string }
+	renamed := false
+	stmt := db.Session(&gorm.Session{DryRun: true}).Model(&model.Torrent{}).
+		Where("downloaded IN ?", []model.DownloadStatus{model.DownloadDone}).Where("renamed = ?", renamed).
+		Find(&[]model.Torrent{}).Statement
+	if err := db.Raw("EXPLAIN QUERY PLAN "+stmt.SQL.String(), stmt.Vars...).Scan(&plan).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) == 0 || !strings.Contains(plan[0].Detail, "idx_torrent_status") {
+		t.Errorf("query plan = %+v, want idx_torrent_status", plan)
+	}
+
+	target := &model.Bangumi{OfficialTitle: "间谍过家家", Season: 2, RSSLink: "https://mikanani.me/RSS/Bangumi?bangumiId=3141"}
+	other := &model.Bangumi{OfficialTitle: "间谍过家家", Season: 1, RSSLink: target.RSSLink}
+	for _, b := range []*model.Bangumi{target, other} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, b := range []*model.Bangumi{target, target, other} {
+		if err := db.CreateTorrent(ctx, &model.Torrent{Link: fmt.Sprintf("link-%d", i), BangumiID: b.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	torrents, err := db.ListTorrentByBangumi(ctx, "间谍过家家", 2, target.RSSLink)
+	if err != nil || len(torrents) != 2 {
+		t.Errorf("ListTorrentByBangumi() = %d torrents, %v, want 2", len(torrents), err)
+	}
+}
+
+// TestCheckNewTorrentsInfoHash 不同订阅中链接不同的同一个种子按 info hash 去重, 保留已经入库的那个
+func TestCheckNewTorrentsInfoHash(t *testing.T) {
+	ctx := context.Background()
+	db := NewTestDB(t)
+
+	hash := "33fbab8f53fe4bad12f07afa5abdb7c4afa5956c"
+	other := "1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e"
+	group := "https://mikanani.me/Download/20240929/" + hash + ".torrent"
+	if err := db.CreateTorrent(ctx, &model.Torrent{Link: group, Name: "[ANi] 败北女角太多了！ - 12", InfoHash: hash, Downloaded: model.DownloadSending}); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := []*model.Torrent{
+		// 聚合订阅中的同一个种子, info hash 大小写不同
+		{Link: "magnet:?xt=urn:btih:" + strings.ToUpper(hash), Name: "[ANi] 败北女角太多了！ - 12", InfoHash: strings.ToUpper(hash)},
+		{Link: "https://nyaa.si/download/1.torrent", Name: "[ANi] 败北女角太多了！ - 11", InfoHash: other},
+		// 同一批中重复的种子
+		{Link: "magnet:?xt=urn:btih:" + other, Name: "[ANi] 败北女角太多了！ - 11", InfoHash: other},
+		// 没有 info hash 时只按链接判断
+		{Link: "https://example.com/13.torrent", Name: "[ANi] 败北女角太多了！ - 13"},
+	}
+	newOnes, err := db.CheckNewTorrents(ctx, candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(newOnes) != 2 || newOnes[0] != candidates[1] || newOnes[1] != candidates[3] {
+		t.Errorf("CheckNewTorrents() = %+v, want the nyaa torrent and the one without info hash", newOnes)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/singleflight"
@@ -21,8 +22,9 @@ import (
 type DownloadClient struct {
 	Downloader     downloader.BaseDownloader
 	limiter        *rate.Limiter
-	SavePath       string
 	downloaderType string
+	// savePath 下载器的保存路径, 运行时可以修改, 见 SetSavePath
+	savePath atomic.Value
 
 	// 登录控制
 	logined    bool // 是否已登录
@@ -36,7 +38,7 @@ func NewDownloadClient() *DownloadClient {
 }
 
 func (c *DownloadClient) Init(config *model.DownloaderConfig) {
-	c.SavePath = config.SavePath
+	c.SetSavePath(config.SavePath)
 
 	downloaderType := strings.ToLower(config.Type)
 	if c.downloaderType != downloaderType {
@@ -53,6 +55,17 @@ func (c *DownloadClient) Init(config *model.DownloaderConfig) {
 	c.Downloader.Init(config)
 }
 
+// SavePath 下载器的保存路径
+func (c *DownloadClient) SavePath() string {
+	path, _ := c.savePath.Load().(string)
+	return path
+}
+
+// SetSavePath 修改下载器的保存路径, 之后的重命名以新路径为基准
+func (c *DownloadClient) SetSavePath(path string) {
+	c.savePath.Store(path)
+}
+
 func (c *DownloadClient) Check(ctx context.Context, hash string) (string, error) {
 	if err := c.EnsureLogin(ctx); err != nil {
 		return "", fmt.Errorf("登录失败: %w", err)
@@ -89,6 +102,14 @@ func (c *DownloadClient) Login(ctx context.Context) error {
 	return err
 }
 
+// Ping 检查下载器是否可达, 不受限流和登录状态影响
+func (c *DownloadClient) Ping(ctx context.Context) error {
+	if c.Downloader == nil {
+		return fmt.Errorf("下载器未初始化")
+	}
+	return c.Downloader.Ping(ctx)
+}
+
 func (c *DownloadClient) EnsureLogin(ctx context.Context) error {
 	if c.LoginError {
 		return &apperrors.DownloadLoginError{Err: fmt.Errorf("下载器配置错误")}
@@ -24,8 +24,8 @@ type BaseDownloader interface {
 	// Auth 用户认证
 	Auth(ctx context.Context) (bool, error)
 
-	// CheckHost 检查主机连通性
-	// CheckHost(ctx context.Context) (bool, error)
+	// Ping 检查主机连通性, 不需要登录
+	Ping(ctx context.Context) error
 
 	// Logout 登出
 	Logout(ctx context.Context) (bool, error)
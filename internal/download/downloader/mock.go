@@ -69,6 +69,11 @@ func (d *MockDownloader) Auth(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+// Ping 模拟下载器总是可达
+func (d *MockDownloader) Ping(ctx context.Context) error {
+	return nil
+}
+
 // Logout 登出
 func (d *MockDownloader) Logout(ctx context.Context) (bool, error) {
 	d.loggedIn = false
@@ -131,6 +131,18 @@ func (d *QBittorrentDownloader) Auth(ctx context.Context) (bool, error) {
 	return false, &apperrors.NetworkError{Err: fmt.Errorf("登录失败：状态码 %d", resp.StatusCode()), StatusCode: resp.StatusCode()}
 }
 
+// Ping 请求版本号接口检查连通性, 未登录时返回 403 也说明主机可达
+func (d *QBittorrentDownloader) Ping(ctx context.Context) error {
+	resp, err := d.client.R().SetContext(ctx).Get(QBAPI["version"])
+	if err != nil {
+		return &apperrors.NetworkError{Err: fmt.Errorf("连接到qBittorrent时出错: %w", err), StatusCode: 0}
+	}
+	if resp.StatusCode() >= 500 {
+		return &apperrors.NetworkError{Err: fmt.Errorf("qBittorrent 状态异常：状态码 %d", resp.StatusCode()), StatusCode: resp.StatusCode()}
+	}
+	return nil
+}
+
 // Logout 登出
 func (d *QBittorrentDownloader) Logout(ctx context.Context) (bool, error) {
 	resp, err := d.client.R().SetContext(ctx).Post(QBAPI["logout"])
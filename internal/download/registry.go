@@ -0,0 +1,147 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"goto-bangumi/internal/model"
+)
+
+// RouteFunc 路由规则, 根据种子和番剧选择下载客户端名称
+// 返回空字符串表示该规则不处理, 交给下一条规则
+type RouteFunc func(torrent *model.Torrent, bangumi *model.Bangumi) string
+
+// ClientRegistry 管理多个具名的下载客户端, 按规则为种子选择客户端
+type ClientRegistry struct {
+	mu          sync.RWMutex
+	clients     map[string]*DownloadClient
+	rules       []RouteFunc
+	defaultName string
+}
+
+// NewClientRegistry 创建下载客户端注册表, defaultName 为没有规则命中时使用的客户端
+func NewClientRegistry(defaultName string) *ClientRegistry {
+	return &ClientRegistry{
+		clients:     make(map[string]*DownloadClient),
+		defaultName: defaultName,
+	}
+}
+
+// Register 注册一个具名的下载客户端, 同名会覆盖
+func (r *ClientRegistry) Register(name string, client *DownloadClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[name] = client
+}
+
+// Get 根据名称获取下载客户端
+func (r *ClientRegistry) Get(name string) (*DownloadClient, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.clients[name]
+	return c, ok
+}
+
+// AddRule 添加路由规则, 按添加顺序匹配
+func (r *ClientRegistry) AddRule(rule RouteFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, rule)
+}
+
+// Route 为种子选择下载客户端, 并把客户端名称记录到 torrent.Downloader 上
+// 规则返回了未注册的客户端名称时, 回退到默认客户端
+func (r *ClientRegistry) Route(torrent *model.Torrent, bangumi *model.Bangumi) (*DownloadClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name := r.defaultName
+	for _, rule := range r.rules {
+		n := rule(torrent, bangumi)
+		if n == "" {
+			continue
+		}
+		if _, ok := r.clients[n]; !ok {
+			slog.Warn("[ClientRegistry] 路由规则指定的下载器未注册，使用默认下载器", "下载器", n, "种子名称", torrent.Name)
+			break
+		}
+		name = n
+		break
+	}
+	client, ok := r.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("下载器 %q 未注册", name)
+	}
+	torrent.Downloader = name
+	return client, nil
+}
+
+// ClientFor 返回种子添加时使用的下载客户端, 用于状态查询和删除
+// 旧数据没有记录下载器名称时使用默认客户端
+func (r *ClientRegistry) ClientFor(torrent *model.Torrent) (*DownloadClient, error) {
+	name := torrent.Downloader
+	if name == "" {
+		name = r.defaultName
+	}
+	client, ok := r.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("下载器 %q 未注册", name)
+	}
+	return client, nil
+}
+
+// DeleteTorrents 从种子各自添加时使用的下载器中删除种子, 同一个下载器的种子一次删除
+// 没有 DownloadUID 的种子会被跳过, 某个下载器删除失败不影响其他下载器, 返回所有错误
+func (r *ClientRegistry) DeleteTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	var order []string
+	hashes := make(map[string][]string)
+	clients := make(map[string]*DownloadClient)
+	var errs []error
+	for _, t := range torrents {
+		if t.DownloadUID == "" {
+			continue
+		}
+		client, err := r.ClientFor(t)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		name := t.Downloader
+		if name == "" {
+			name = r.defaultName
+		}
+		if _, ok := hashes[name]; !ok {
+			order = append(order, name)
+			clients[name] = client
+		}
+		hashes[name] = append(hashes[name], t.DownloadUID)
+	}
+	for _, name := range order {
+		if err := clients[name].Delete(ctx, hashes[name]); err != nil {
+			errs = append(errs, fmt.Errorf("下载器 %q 删除种子失败: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RouteByRSS 按番剧的 RSS 链接路由
+func RouteByRSS(routes map[string]string) RouteFunc {
+	return func(_ *model.Torrent, bangumi *model.Bangumi) string {
+		if bangumi == nil {
+			return ""
+		}
+		return routes[bangumi.RSSLink]
+	}
+}
+
+// RouteByBangumi 按番剧 ID 路由
+func RouteByBangumi(routes map[int]string) RouteFunc {
+	return func(torrent *model.Torrent, bangumi *model.Bangumi) string {
+		if bangumi != nil {
+			return routes[bangumi.ID]
+		}
+		return routes[torrent.BangumiID]
+	}
+}
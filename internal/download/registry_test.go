@@ -0,0 +1,93 @@
+package download
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/download/downloader"
+	"goto-bangumi/internal/model"
+)
+
+func TestClientRegistryRoute(t *testing.T) {
+	anime := &DownloadClient{Downloader: downloader.NewMockDownloader()}
+	other := &DownloadClient{Downloader: downloader.NewMockDownloader()}
+
+	registry := NewClientRegistry("anime")
+	registry.Register("anime", anime)
+	registry.Register("other", other)
+	registry.AddRule(RouteByBangumi(map[int]string{42: "other"}))
+	registry.AddRule(RouteByRSS(map[string]string{
+		"https://example.com/rss/other":   "other",
+		"https://example.com/rss/missing": "missing",
+	}))
+
+	tests := []struct {
+		name     string
+		bangumi  *model.Bangumi
+		want     *DownloadClient
+		wantName string
+	}{
+		{"default", &model.Bangumi{ID: 1, RSSLink: "https://example.com/rss/anime"}, anime, "anime"},
+		{"by bangumi", &model.Bangumi{ID: 42, RSSLink: "https://example.com/rss/anime"}, other, "other"},
+		{"by rss", &model.Bangumi{ID: 2, RSSLink: "https://example.com/rss/other"}, other, "other"},
+		{"unregistered fallback", &model.Bangumi{ID: 3, RSSLink: "https://example.com/rss/missing"}, anime, "anime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrent := &model.Torrent{Name: tt.name}
+			got, err := registry.Route(torrent, tt.bangumi)
+			if err != nil {
+				t.Fatalf("Route() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Route() picked wrong client for %s", tt.name)
+			}
+			if torrent.Downloader != tt.wantName {
+				t.Errorf("torrent.Downloader = %q, want %q", torrent.Downloader, tt.wantName)
+			}
+			back, err := registry.ClientFor(torrent)
+			if err != nil || back != tt.want {
+				t.Errorf("ClientFor() did not return the routed client, err = %v", err)
+			}
+		})
+	}
+}
+
+func TestClientRegistryMissingDefault(t *testing.T) {
+	registry := NewClientRegistry("qb")
+	if _, err := registry.Route(&model.Torrent{}, nil); err == nil {
+		t.Error("Route() 在默认下载器未注册时应该返回错误")
+	}
+}
+
+func TestClientRegistryDeleteTorrents(t *testing.T) {
+	ctx := context.Background()
+	const hash = "1317e47882474c771e29ed2271b282fbfb56e7d2"
+	newClient := func() *DownloadClient {
+		c := NewDownloadClient()
+		c.Init(&model.DownloaderConfig{Type: "mock"})
+		return c
+	}
+	anime, other := newClient(), newClient()
+	registry := NewClientRegistry("anime")
+	registry.Register("anime", anime)
+	registry.Register("other", other)
+
+	torrents := []*model.Torrent{
+		{Name: "routed", DownloadUID: hash, Downloader: "other"},
+		{Name: "not sent"},
+	}
+	if err := registry.DeleteTorrents(ctx, torrents); err != nil {
+		t.Fatalf("DeleteTorrents() error = %v", err)
+	}
+	if info, _ := other.GetTorrentInfo(ctx, hash); info != nil {
+		t.Error("种子应该从添加它的下载器中删除")
+	}
+	if info, _ := anime.GetTorrentInfo(ctx, hash); info == nil {
+		t.Error("其他下载器中的种子不应该被删除")
+	}
+
+	if err := registry.DeleteTorrents(ctx, []*model.Torrent{{DownloadUID: hash, Downloader: "missing"}}); err == nil {
+		t.Error("DeleteTorrents() 在下载器未注册时应该返回错误")
+	}
+}
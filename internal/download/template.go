@@ -0,0 +1,328 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"goto-bangumi/internal/model"
+)
+
+// 命名模板, 形如 {title}/Season {season:02d}/{title} - S{season:02d}E{episode:02d} [{group}]{ext}
+// 字段写在花括号中, 冒号后为格式:
+//   - 数字字段(season, episode): d, 02d 这样的补零宽度
+//   - 文本字段: s, upper, lower
+//
+// {{ 和 }} 分别输出字面量的 { 和 }, / 为目录分隔符
+// 字段为空时直接省略, 渲染后会清理留下的空括号和多余的分隔符
+
+var (
+	ErrEmptyTemplate   = errors.New("template is empty")
+	ErrInvalidTemplate = errors.New("invalid template")
+)
+
+// TemplateData 渲染命名模板用到的字段
+type TemplateData struct {
+	Title      string
+	Year       string
+	Season     int
+	Episode    int // 小于 0 表示未知
+	Group      string
+	Resolution string
+	Source     string
+	Sub        string
+	Type       model.EpisodeType
+	Label      string // 特别篇原始标记, 如 SP01 OVA
+	Ext        string // 带点的扩展名, 如 .mkv
+}
+
+// NewTemplateData 由番剧和解析出的元数据生成模板字段
+// 特别篇按 Plex/Jellyfin 的习惯放到第 0 季, 正片会加上番剧的集数偏移
+func NewTemplateData(bangumi *model.Bangumi, meta *model.EpisodeMetadata, ext string) *TemplateData {
+	data := &TemplateData{Episode: -1, Ext: ext, Type: model.EpisodeRegular}
+	if bangumi != nil {
+		data.Title = bangumi.OfficialTitle
+		data.Year = bangumi.Year
+		data.Season = bangumi.Season
+	}
+	if meta == nil {
+		return data
+	}
+	data.Group = meta.Group
+	data.Resolution = meta.Resolution
+	data.Source = meta.Source
+	data.Sub = meta.Sub
+	data.Label = meta.EpisodeLabel
+	data.Episode = meta.Episode
+	if meta.EpisodeType != "" && meta.EpisodeType != model.EpisodeRegular {
+		data.Type = meta.EpisodeType
+		data.Season = 0
+	} else if bangumi != nil && data.Episode >= 0 {
+		data.Episode += bangumi.Offset
+	}
+	return data
+}
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	numberField
+)
+
+var templateFields = map[string]fieldKind{
+	"title":      textField,
+	"year":       textField,
+	"season":     numberField,
+	"episode":    numberField,
+	"group":      textField,
+	"resolution": textField,
+	"source":     textField,
+	"sub":        textField,
+	"type":       textField,
+	"label":      textField,
+	"ext":        textField,
+}
+
+func (d *TemplateData) text(field string) string {
+	switch field {
+	case "title":
+		return d.Title
+	case "year":
+		return d.Year
+	case "group":
+		return d.Group
+	case "resolution":
+		return d.Resolution
+	case "source":
+		return d.Source
+	case "sub":
+		return d.Sub
+	case "type":
+		return string(d.Type)
+	case "label":
+		return d.Label
+	case "ext":
+		return d.Ext
+	}
+	return ""
+}
+
+func (d *TemplateData) number(field string) int {
+	switch field {
+	case "season":
+		return d.Season
+	case "episode":
+		return d.Episode
+	}
+	return -1
+}
+
+// templatePart 模板片段, field 为空时是字面量
+type templatePart struct {
+	literal string
+	field   string
+	spec    string
+}
+
+// PathTemplate 解析后的命名模板
+type PathTemplate struct {
+	parts []templatePart
+}
+
+var numberSpecRe = regexp.MustCompile(`^(?:0(\d+))?d$`)
+
+// 字面量中不允许出现的字符, / 作为目录分隔符除外
+const illegalLiteralChars = `\:*?"<>|`
+
+// ParseTemplate 解析命名模板
+func ParseTemplate(tmpl string) (*PathTemplate, error) {
+	if strings.TrimSpace(tmpl) == "" {
+		return nil, ErrEmptyTemplate
+	}
+	t := &PathTemplate{}
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			t.parts = append(t.parts, templatePart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+		switch c {
+		case '{':
+			if i+1 < len(tmpl) && tmpl[i+1] == '{' {
+				literal.WriteByte('{')
+				i++
+				continue
+			}
+			end := strings.IndexByte(tmpl[i+1:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unclosed '{' at %d", ErrInvalidTemplate, i)
+			}
+			part, err := parseField(tmpl[i+1 : i+1+end])
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			t.parts = append(t.parts, part)
+			i += end + 1
+		case '}':
+			if i+1 < len(tmpl) && tmpl[i+1] == '}' {
+				literal.WriteByte('}')
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("%w: unexpected '}' at %d", ErrInvalidTemplate, i)
+		default:
+			if strings.IndexByte(illegalLiteralChars, c) >= 0 {
+				return nil, fmt.Errorf("%w: illegal character %q at %d", ErrInvalidTemplate, c, i)
+			}
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+
+	if strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("%w: template must be a relative path", ErrInvalidTemplate)
+	}
+	for _, segment := range strings.Split(tmpl, "/") {
+		if segment == ".." {
+			return nil, fmt.Errorf("%w: template must not contain '..'", ErrInvalidTemplate)
+		}
+	}
+	return t, nil
+}
+
+func parseField(body string) (templatePart, error) {
+	name, spec, _ := strings.Cut(body, ":")
+	name = strings.TrimSpace(name)
+	kind, ok := templateFields[name]
+	if !ok {
+		return templatePart{}, fmt.Errorf("%w: unknown field {%s}", ErrInvalidTemplate, body)
+	}
+	switch kind {
+	case numberField:
+		if spec != "" && !numberSpecRe.MatchString(spec) {
+			return templatePart{}, fmt.Errorf("%w: invalid format %q for {%s}", ErrInvalidTemplate, spec, name)
+		}
+	case textField:
+		if spec != "" && spec != "s" && spec != "upper" && spec != "lower" {
+			return templatePart{}, fmt.Errorf("%w: invalid format %q for {%s}", ErrInvalidTemplate, spec, name)
+		}
+	}
+	return templatePart{field: name, spec: spec}, nil
+}
+
+// ValidateTemplate 校验命名模板, 用于保存配置前拒绝无法渲染的模板
+func ValidateTemplate(tmpl string) error {
+	_, err := ParseTemplate(tmpl)
+	return err
+}
+
+// RenderTemplate 解析并渲染命名模板
+func RenderTemplate(tmpl string, data *TemplateData) (string, error) {
+	t, err := ParseTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+	result := t.Render(data)
+	if result == "" {
+		return "", fmt.Errorf("%w: template %q rendered to an empty path", ErrInvalidTemplate, tmpl)
+	}
+	return result, nil
+}
+
+// Render 渲染模板, 返回以 / 分隔的相对路径
+func (t *PathTemplate) Render(data *TemplateData) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		b.WriteString(formatField(data, part))
+	}
+	return cleanPath(b.String(), data.Ext)
+}
+
+func formatField(data *TemplateData, part templatePart) string {
+	if templateFields[part.field] == numberField {
+		n := data.number(part.field)
+		if n < 0 {
+			return ""
+		}
+		m := numberSpecRe.FindStringSubmatch(part.spec)
+		if m == nil || m[1] == "" {
+			return strconv.Itoa(n)
+		}
+		width, _ := strconv.Atoi(m[1])
+		return fmt.Sprintf("%0*d", width, n)
+	}
+	value := data.text(part.field)
+	switch part.spec {
+	case "upper":
+		value = strings.ToUpper(value)
+	case "lower":
+		value = strings.ToLower(value)
+	}
+	if part.field == "ext" {
+		return value
+	}
+	return SanitizeFileName(value)
+}
+
+// 文件名中的非法字符替换为全角字符, 保留可读性
+var fileNameReplacer = strings.NewReplacer(
+	"/", "／",
+	`\`, "＼",
+	":", "：",
+	"*", "＊",
+	"?", "？",
+	`"`, "＂",
+	"<", "＜",
+	">", "＞",
+	"|", "｜",
+)
+
+// SanitizeFileName 替换文件名中的非法字符并去掉控制字符
+func SanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	return fileNameReplacer.Replace(name)
+}
+
+var (
+	emptyBracketRe = regexp.MustCompile(`\[\s*\]|\(\s*\)|【\s*】`)
+	multiSpaceRe   = regexp.MustCompile(`\s{2,}`)
+)
+
+// cleanPath 清理字段缺失后留下的空括号、多余空格和首尾的分隔符, 并去掉空目录
+func cleanPath(rendered, ext string) string {
+	segments := strings.Split(rendered, "/")
+	cleaned := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		suffix := ""
+		if i == len(segments)-1 && ext != "" && strings.HasSuffix(segment, ext) {
+			segment = strings.TrimSuffix(segment, ext)
+			suffix = ext
+		}
+		segment = emptyBracketRe.ReplaceAllString(segment, "")
+		segment = multiSpaceRe.ReplaceAllString(segment, " ")
+		segment = strings.Trim(segment, " -_")
+		// Windows 下目录名不能以点结尾
+		segment = strings.TrimRight(segment, ". ")
+		if segment == "" {
+			continue
+		}
+		cleaned = append(cleaned, segment+suffix)
+	}
+	return strings.Join(cleaned, "/")
+}
@@ -0,0 +1,124 @@
+package download
+
+import (
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	makeine := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1, Year: "2024"}
+	regular := &model.EpisodeMetadata{Episode: 5, Group: "LoliHouse", Resolution: "1080p"}
+
+	tests := []struct {
+		name string
+		tmpl string
+		data *TemplateData
+		want string
+	}{
+		{
+			name: "Plex 目录结构",
+			tmpl: "{title}/Season {season:02d}/{title} - S{season:02d}E{episode:02d} [{group}]{ext}",
+			data: NewTemplateData(makeine, regular, ".mkv"),
+			want: "败犬女主太多了！/Season 01/败犬女主太多了！ - S01E05 [LoliHouse].mkv",
+		},
+		{
+			name: "Jellyfin 带年份",
+			tmpl: "{title} ({year})/Season {season}/{title} S{season:02d}E{episode:02d}{ext}",
+			data: NewTemplateData(makeine, regular, ".mp4"),
+			want: "败犬女主太多了！ (2024)/Season 1/败犬女主太多了！ S01E05.mp4",
+		},
+		{
+			name: "扁平结构和大小写格式",
+			tmpl: "{title} E{episode:03d} {resolution:upper} {group:lower}{ext}",
+			data: NewTemplateData(makeine, regular, ".mkv"),
+			want: "败犬女主太多了！ E005 1080P lolihouse.mkv",
+		},
+		{
+			name: "缺少字幕组时省略括号",
+			tmpl: "{title}/Season {season:02d}/{title} - S{season:02d}E{episode:02d} [{group}]{ext}",
+			data: NewTemplateData(makeine, &model.EpisodeMetadata{Episode: 5}, ".mkv"),
+			want: "败犬女主太多了！/Season 01/败犬女主太多了！ - S01E05.mkv",
+		},
+		{
+			name: "缺少年份和字幕组目录",
+			tmpl: "{title} ({year})/{group}/{title} - {episode:02d}{ext}",
+			data: NewTemplateData(&model.Bangumi{OfficialTitle: "Re:Zero", Season: 1}, &model.EpisodeMetadata{Episode: 3}, ".mkv"),
+			want: "Re：Zero/Re：Zero - 03.mkv",
+		},
+		{
+			name: "特别篇放到第 0 季",
+			tmpl: "{title}/Season {season:02d}/{title} - S{season:02d}E{episode:02d} {label} [{group}]{ext}",
+			data: NewTemplateData(makeine, &model.EpisodeMetadata{
+				Episode:      1,
+				Group:        "LoliHouse",
+				EpisodeType:  model.EpisodeOVA,
+				EpisodeLabel: "OVA",
+			}, ".mkv"),
+			want: "败犬女主太多了！/Season 00/败犬女主太多了！ - S00E01 OVA [LoliHouse].mkv",
+		},
+		{
+			name: "正片加上集数偏移",
+			tmpl: "{title} S{season:02d}E{episode:02d}{ext}",
+			data: NewTemplateData(
+				&model.Bangumi{OfficialTitle: "转生贵族靠鉴定技能一飞冲天", Season: 2, Offset: -12},
+				&model.EpisodeMetadata{Episode: 14},
+				".mp4",
+			),
+			want: "转生贵族靠鉴定技能一飞冲天 S02E02.mp4",
+		},
+		{
+			name: "转义花括号",
+			tmpl: "{{{group}}} {title}{ext}",
+			data: NewTemplateData(makeine, regular, ".mkv"),
+			want: "{LoliHouse} 败犬女主太多了！.mkv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplate(tt.tmpl, tt.data)
+			if err != nil {
+				t.Fatalf("RenderTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		wantErr error
+	}{
+		{"合法模板", "{title}/Season {season:02d}/{title} S{season:02d}E{episode:02d}{ext}", nil},
+		{"空模板", "  ", ErrEmptyTemplate},
+		{"未知字段", "{title} {foo}{ext}", ErrInvalidTemplate},
+		{"未闭合", "{title", ErrInvalidTemplate},
+		{"多余的右括号", "title}", ErrInvalidTemplate},
+		{"数字格式错误", "{episode:x}", ErrInvalidTemplate},
+		{"不补零的宽度", "{episode:2d}", ErrInvalidTemplate},
+		{"文本格式错误", "{title:02d}", ErrInvalidTemplate},
+		{"非法字符", "{title}: {episode}", ErrInvalidTemplate},
+		{"绝对路径", "/{title}/{episode}", ErrInvalidTemplate},
+		{"上级目录", "../{title}/{episode}", ErrInvalidTemplate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTemplate(tt.tmpl)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("ValidateTemplate() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateTemplate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
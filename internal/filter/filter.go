@@ -0,0 +1,161 @@
+// Package filter 种子过滤规则引擎
+// 规则见 model.FilterRules: 全局规则来自 parser 配置(Global), 番剧用自己的规则覆盖(ForBangumi)
+// Evaluate 检查所有规则并返回每条不通过的规则和原因, 既用于刷新时过滤, 也用于试运行规则
+package filter
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+// 规则名称, 出现在 Rejection.Rule
+const (
+	RuleMedia      = "media"      // 内容类型, 见 parser.IsAllowedMedia
+	RuleSize       = "size"       // 大小
+	RuleAge        = "age"        // 发布时间
+	RuleSeeders    = "seeders"    // 做种人数
+	RuleExclude    = "exclude"    // 排除正则
+	RuleInclude    = "include"    // 包含正则
+	RuleResolution = "resolution" // 分辨率关键词
+	RuleSubType    = "sub_type"   // 字幕类型关键词
+	RuleGroup      = "group"      // 字幕组关键词
+	RuleLanguage   = "language"   // 字幕语言关键词
+)
+
+// Rejection 一条没有通过的规则
+type Rejection struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// Result 一个种子的过滤结果
+type Result struct {
+	Name       string      `json:"name"`
+	Accepted   bool        `json:"accepted"`
+	Rejections []Rejection `json:"rejections,omitempty"`
+	// Meta 从标题解析出的信息, 关键词规则按它匹配
+	Meta *model.EpisodeMetadata `json:"meta,omitempty"`
+}
+
+// Reason 第一条没有通过的规则的原因, 通过时为空
+func (r Result) Reason() string {
+	if len(r.Rejections) == 0 {
+		return ""
+	}
+	return r.Rejections[0].Rule + ": " + r.Rejections[0].Reason
+}
+
+// Global 配置中的全局规则, 没有配置时为零值
+func Global() model.FilterRules {
+	cfg := parser.ParserConfig
+	if cfg == nil {
+		return model.FilterRules{}
+	}
+	return model.FilterRules{
+		Include:    cfg.Include,
+		Exclude:    cfg.Filter,
+		MinSizeMB:  cfg.MinSizeMB,
+		MaxSizeMB:  cfg.MaxSizeMB,
+		MaxAgeDays: cfg.MaxAgeDays,
+		MinSeeders: cfg.MinSeeders,
+		Resolution: cfg.Resolution,
+		SubType:    cfg.SubType,
+		Group:      cfg.Group,
+		Language:   cfg.SubLanguage,
+	}
+}
+
+// ForBangumi 番剧实际使用的规则, 见 model.Bangumi.EffectiveFilterRules
+func ForBangumi(bangumi *model.Bangumi) model.FilterRules {
+	return bangumi.EffectiveFilterRules(Global())
+}
+
+// Allow 种子是否通过规则, 不通过时记录原因
+func Allow(torrent *model.Torrent, rules model.FilterRules) bool {
+	result := Evaluate(torrent, rules)
+	if !result.Accepted {
+		slog.Debug("[filter] 过滤种子", "种子名称", torrent.Name, "原因", result.Reason())
+	}
+	return result.Accepted
+}
+
+// Evaluate 按规则检查种子, 返回所有没有通过的规则
+// 无法编译的排除正则被忽略, 无法编译的包含正则不放行任何种子
+func Evaluate(torrent *model.Torrent, rules model.FilterRules) Result {
+	result := Result{Name: torrent.Name}
+	reject := func(rule, format string, args ...any) {
+		result.Rejections = append(result.Rejections, Rejection{Rule: rule, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if !parser.IsAllowedMedia(torrent.Name) {
+		reject(RuleMedia, "内容类型为 %s", parser.DetectMediaType(torrent.Name))
+	}
+
+	const mb = 1024 * 1024
+	if torrent.Size > 0 {
+		if rules.MinSizeMB > 0 && torrent.Size < int64(rules.MinSizeMB)*mb {
+			reject(RuleSize, "大小 %.2f MB 小于 %d MB", float64(torrent.Size)/mb, rules.MinSizeMB)
+		}
+		if rules.MaxSizeMB > 0 && torrent.Size > int64(rules.MaxSizeMB)*mb {
+			reject(RuleSize, "大小 %.2f MB 大于 %d MB", float64(torrent.Size)/mb, rules.MaxSizeMB)
+		}
+	}
+	if rules.MaxAgeDays > 0 && !torrent.PubDate.IsZero() {
+		if age := time.Since(torrent.PubDate); age > time.Duration(rules.MaxAgeDays)*24*time.Hour {
+			reject(RuleAge, "发布于 %d 天前, 超过 %d 天", int(age.Hours()/24), rules.MaxAgeDays)
+		}
+	}
+	if rules.MinSeeders > 0 && torrent.Seeders != nil && *torrent.Seeders < rules.MinSeeders {
+		reject(RuleSeeders, "做种人数 %d 少于 %d", *torrent.Seeders, rules.MinSeeders)
+	}
+
+	if re, err := compile(rules.Exclude); err == nil && re != nil && re.MatchString(torrent.Name) {
+		reject(RuleExclude, "匹配排除正则 %s", re)
+	}
+	if re, err := compile(rules.Include); err != nil {
+		reject(RuleInclude, "包含正则无法编译: %v", err)
+	} else if re != nil && !re.MatchString(torrent.Name) {
+		reject(RuleInclude, "不匹配包含正则 %s", re)
+	}
+
+	if !rules.Resolution.IsZero() || !rules.SubType.IsZero() || !rules.Group.IsZero() || !rules.Language.IsZero() {
+		result.Meta = parser.NewTitleMetaParse().ParseEpisode(torrent.Name)
+		for _, f := range []struct {
+			rule  string
+			field model.FieldRule
+			value string
+		}{
+			{RuleResolution, rules.Resolution, result.Meta.Resolution},
+			{RuleSubType, rules.SubType, result.Meta.SubType},
+			{RuleGroup, rules.Group, result.Meta.Group},
+			{RuleLanguage, rules.Language, result.Meta.Sub},
+		} {
+			if ok, reason := f.field.Match(f.value); !ok {
+				reject(f.rule, "%s %s", f.value, reason)
+			}
+		}
+	}
+
+	result.Accepted = len(result.Rejections) == 0
+	return result
+}
+
+// compile 把多个正则用 | 连接后编译, 没有正则时返回 nil
+func compile(patterns []string) (*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	pattern := strings.Join(patterns, "|")
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		slog.Warn("[filter] 过滤正则表达式编译失败", "正则", pattern, "error", err)
+		return nil, err
+	}
+	return re, nil
+}
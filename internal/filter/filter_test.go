@@ -0,0 +1,114 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestEvaluate(t *testing.T) {
+	const mb = 1024 * 1024
+	name := "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 04 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕]"
+	seeders := func(n int) *int { return &n }
+	tests := []struct {
+		name    string
+		torrent model.Torrent
+		rules   model.FilterRules
+		want    []string // 没有通过的规则
+	}{
+		{"no rules", model.Torrent{Name: name}, model.FilterRules{}, nil},
+		{"subtitle pack", model.Torrent{Name: "[LoliHouse] Make Heroine ga Oosugiru! [01-12][简繁日字幕包].7z"}, model.FilterRules{}, []string{RuleMedia}},
+		{"sample", model.Torrent{Name: name, Size: 2 * mb}, model.FilterRules{MinSizeMB: 10}, []string{RuleSize}},
+		{"too old", model.Torrent{Name: name, PubDate: time.Now().AddDate(0, 0, -400)}, model.FilterRules{MaxAgeDays: 30}, []string{RuleAge}},
+		{"few seeders", model.Torrent{Name: name, Seeders: seeders(2)}, model.FilterRules{MinSeeders: 5}, []string{RuleSeeders}},
+		{"unknown seeders", model.Torrent{Name: name}, model.FilterRules{MinSeeders: 5}, nil},
+		{"exclude", model.Torrent{Name: name}, model.FilterRules{Exclude: []string{"720p", "HEVC"}}, []string{RuleExclude}},
+		{"include", model.Torrent{Name: name}, model.FilterRules{Include: []string{"720p"}}, []string{RuleInclude}},
+		{"bad include", model.Torrent{Name: name}, model.FilterRules{Include: []string{"(1080p"}}, []string{RuleInclude}},
+		{"bad exclude", model.Torrent{Name: name}, model.FilterRules{Exclude: []string{"(1080p"}}, nil},
+		{
+			"keywords pass",
+			model.Torrent{Name: name},
+			model.FilterRules{
+				Resolution: model.FieldRule{Include: []string{"1080P"}},
+				Group:      model.FieldRule{Exclude: []string{"桜都"}},
+				Language:   model.FieldRule{Include: []string{"繁"}},
+			},
+			nil,
+		},
+		{
+			"keywords reject",
+			model.Torrent{Name: name},
+			model.FilterRules{
+				Resolution: model.FieldRule{Include: []string{"2160p"}},
+				Group:      model.FieldRule{Exclude: []string{"LoliHouse"}},
+				Language:   model.FieldRule{Include: []string{"英"}},
+			},
+			[]string{RuleResolution, RuleGroup, RuleLanguage},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Evaluate(&tt.torrent, tt.rules)
+			var got []string
+			for _, r := range result.Rejections {
+				got = append(got, r.Rule)
+				if r.Reason == "" {
+					t.Errorf("rule %s has no reason", r.Rule)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Evaluate() rejected by %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Evaluate() rejected by %v, want %v", got, tt.want)
+				}
+			}
+			if result.Accepted != (len(tt.want) == 0) {
+				t.Errorf("Accepted = %v, want %v", result.Accepted, len(tt.want) == 0)
+			}
+		})
+	}
+}
+
+// TestForBangumi 番剧的规则覆盖全局规则, 没有设置的沿用全局规则
+func TestForBangumi(t *testing.T) {
+	global := model.FilterRules{
+		Exclude:    []string{"global"},
+		MinSizeMB:  10,
+		MinSeeders: 3,
+		Resolution: model.FieldRule{Include: []string{"1080p"}},
+	}
+	var bangumi model.Bangumi
+	bangumi.SetFilterRules(model.FilterRules{
+		Exclude:    []string{"720p", "HEVC"},
+		MinSeeders: 10,
+		Group:      model.FieldRule{Include: []string{"LoliHouse"}},
+	})
+	if bangumi.ExcludeFilter != "720p,HEVC" {
+		t.Errorf("ExcludeFilter = %q, want 720p,HEVC", bangumi.ExcludeFilter)
+	}
+
+	rules := bangumi.EffectiveFilterRules(global)
+	if len(rules.Exclude) != 2 || rules.Exclude[0] != "720p" {
+		t.Errorf("Exclude = %v, want the bangumi's", rules.Exclude)
+	}
+	if rules.MinSizeMB != 10 || rules.MinSeeders != 10 {
+		t.Errorf("MinSizeMB = %d, MinSeeders = %d, want 10 and 10", rules.MinSizeMB, rules.MinSeeders)
+	}
+	if len(rules.Resolution.Include) != 1 || len(rules.Group.Include) != 1 {
+		t.Errorf("Resolution = %+v, Group = %+v, want both set", rules.Resolution, rules.Group)
+	}
+
+	// 清空后只剩全局规则, 正则来自番剧(已清空)
+	bangumi.SetFilterRules(model.FilterRules{})
+	if bangumi.FilterOverride != "" {
+		t.Errorf("FilterOverride = %q, want empty", bangumi.FilterOverride)
+	}
+	rules = bangumi.EffectiveFilterRules(global)
+	if len(rules.Exclude) != 0 || rules.MinSeeders != 3 || !rules.Group.IsZero() {
+		t.Errorf("rules = %+v, want global rules without patterns", rules)
+	}
+}
@@ -0,0 +1,143 @@
+// Package health 汇总数据库、下载器和 RSS 订阅的连通性, 用于监控
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/network"
+)
+
+// Status 组件状态
+type Status string
+
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded" // 部分组件不可用
+	StatusDown     Status = "down"     // 数据库不可用, 程序无法工作
+)
+
+// ComponentStatus 单个组件的检查结果
+type ComponentStatus struct {
+	Name    string        `json:"name"`
+	Status  Status        `json:"status"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// HealthReport 健康检查报告
+type HealthReport struct {
+	Status     Status            `json:"status"`
+	Database   ComponentStatus   `json:"database"`
+	Downloader ComponentStatus   `json:"downloader"`
+	Feeds      []ComponentStatus `json:"feeds"`
+	CheckedAt  time.Time         `json:"checked_at"`
+}
+
+// Pinger 检查下载器是否可达, 由 download.DownloadClient 实现
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Checker 健康检查, downloader 为空时跳过下载器检查
+type Checker struct {
+	db         *database.DB
+	downloader Pinger
+	// fetch 请求订阅, 默认使用 network 的请求客户端
+	fetch func(ctx context.Context, url string) ([]byte, error)
+}
+
+// NewChecker 创建健康检查
+func NewChecker(db *database.DB, downloader Pinger) *Checker {
+	return &Checker{
+		db:         db,
+		downloader: downloader,
+		fetch: func(ctx context.Context, url string) ([]byte, error) {
+			return network.GetRequestClient().Get(ctx, url)
+		},
+	}
+}
+
+// probe 执行一次检查并记录耗时, ctx 到期时不再等待检查结束
+func probe(ctx context.Context, name string, check func(ctx context.Context) error) ComponentStatus {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	status := ComponentStatus{Name: name, Status: StatusOK, Latency: time.Since(start)}
+	if err != nil {
+		status.Status = StatusDown
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// HealthCheck 并发检查数据库、下载器和所有启用的订阅
+// 整体耗时受 ctx 的截止时间限制, 超时的组件记为 down
+func (c *Checker) HealthCheck(ctx context.Context) HealthReport {
+	report := HealthReport{CheckedAt: time.Now()}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		report.Database = probe(ctx, "database", c.db.Ping)
+	}()
+	go func() {
+		defer wg.Done()
+		if c.downloader == nil {
+			report.Downloader = ComponentStatus{Name: "downloader", Status: StatusDown, Error: "downloader not configured"}
+			return
+		}
+		report.Downloader = probe(ctx, "downloader", c.downloader.Ping)
+	}()
+
+	// 订阅列表依赖数据库, 读不到时只报告数据库的问题
+	feeds, err := c.db.ListActiveRSS(ctx)
+	if err == nil {
+		report.Feeds = make([]ComponentStatus, len(feeds))
+		for i, feed := range feeds {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				name := feed.Name
+				if name == "" {
+					name = feed.Link
+				}
+				report.Feeds[i] = probe(ctx, name, func(ctx context.Context) error {
+					_, err := c.fetch(ctx, feed.Link)
+					return err
+				})
+			}()
+		}
+	}
+	wg.Wait()
+	if err != nil && report.Database.Status == StatusOK {
+		report.Database.Status = StatusDown
+		report.Database.Error = fmt.Sprintf("list rss: %v", err)
+	}
+
+	report.Status = StatusOK
+	if report.Database.Status != StatusOK {
+		report.Status = StatusDown
+		return report
+	}
+	if report.Downloader.Status != StatusOK {
+		report.Status = StatusDegraded
+	}
+	for _, feed := range report.Feeds {
+		if feed.Status != StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+type fakePinger struct {
+	err   error
+	block bool
+}
+
+func (p fakePinger) Ping(ctx context.Context) error {
+	if p.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return p.err
+}
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	testdb := ":memory:"
+	db, err := database.NewDB(&testdb)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 4xx 不会触发重试
+		if r.URL.Path == "/broken.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<rss><channel></channel></rss>`))
+	}))
+	defer server.Close()
+
+	db := newTestDB(t)
+	ctx := context.Background()
+	feeds := []*model.RSSItem{
+		{Name: "正常订阅", Link: server.URL + "/ok.xml", Enabled: true},
+		{Name: "失效订阅", Link: server.URL + "/broken.xml", Enabled: true},
+		{Name: "停用订阅", Link: server.URL + "/disabled.xml"},
+	}
+	for _, feed := range feeds {
+		if err := db.CreateRSS(ctx, feed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Enabled 默认值为 true, 零值不会写入
+	if err := db.Model(feeds[2]).Update("enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("订阅和下载器异常", func(t *testing.T) {
+		report := NewChecker(db, fakePinger{err: errors.New("connection refused")}).HealthCheck(ctx)
+		if report.Status != StatusDegraded {
+			t.Errorf("Status = %q, want %q", report.Status, StatusDegraded)
+		}
+		if report.Database.Status != StatusOK {
+			t.Errorf("Database = %+v, want ok", report.Database)
+		}
+		if report.Downloader.Status != StatusDown || report.Downloader.Error == "" {
+			t.Errorf("Downloader = %+v, want down with error", report.Downloader)
+		}
+		if len(report.Feeds) != 2 {
+			t.Fatalf("got %d feeds, want 2", len(report.Feeds))
+		}
+		got := map[string]Status{}
+		for _, feed := range report.Feeds {
+			got[feed.Name] = feed.Status
+		}
+		if got["正常订阅"] != StatusOK || got["失效订阅"] != StatusDown {
+			t.Errorf("Feeds = %+v", report.Feeds)
+		}
+	})
+
+	t.Run("下载器超时", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		report := NewChecker(db, fakePinger{block: true}).HealthCheck(ctx)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("HealthCheck took %v, want bounded by ctx deadline", elapsed)
+		}
+		if report.Status != StatusDegraded {
+			t.Errorf("Status = %q, want %q", report.Status, StatusDegraded)
+		}
+		if report.Downloader.Status != StatusDown {
+			t.Errorf("Downloader = %+v, want down", report.Downloader)
+		}
+	})
+
+	t.Run("数据库不可用", func(t *testing.T) {
+		broken := newTestDB(t)
+		broken.Close()
+		report := NewChecker(broken, fakePinger{}).HealthCheck(ctx)
+		if report.Status != StatusDown {
+			t.Errorf("Status = %q, want %q", report.Status, StatusDown)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mozillazg/go-pinyin"
+	"gorm.io/gorm"
+)
+
+// TitleAlias 生成标题的拼音别名, 用于按拼音搜索番剧
+// 格式为 "全拼 首字母", 例如 败犬女主太多了！ -> "baiquannvzhutaiduole bqnztdl"; 标题中没有汉字时为空
+func TitleAlias(title string) string {
+	syllables := pinyin.LazyConvert(title, nil)
+	if len(syllables) == 0 {
+		return ""
+	}
+	var initials strings.Builder
+	for _, s := range syllables {
+		initials.WriteByte(s[0])
+	}
+	return strings.Join(syllables, "") + " " + initials.String()
+}
+
+// BangumiAlias 番剧的其他标题, 字幕组对同一部番剧的写法不同(罗马音、英文、简称), 都可以匹配到这个番剧
+// 和 EpisodeMetadata 的标题一样按子串匹配种子名, 但不限字幕组和季度
+type BangumiAlias struct {
+	ID        int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	BangumiID int       `gorm:"uniqueIndex:idx_bangumi_alias;not null" json:"bangumi_id"`
+	Title     string    `gorm:"uniqueIndex:idx_bangumi_alias;size:255;not null" json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BeforeSave 保存前根据中文名更新拼音别名
+func (b *Bangumi) BeforeSave(*gorm.DB) error {
+	b.TitleAlias = TitleAlias(b.OfficialTitle)
+	return nil
+}
@@ -1,8 +1,14 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
+
+	"goto-bangumi/internal/apperrors"
 )
 
 type MikanItem struct {
@@ -36,6 +42,79 @@ type TmdbItem struct {
 	Season        int     `json:"season" gorm:"default:1;comment:'季度'"`
 	PosterLink    string  `json:"poster_url" gorm:"default:'';comment:'海报链接'"`
 	VoteAverage   float64 `json:"vote_average" gorm:"default:0;comment:'评分'"`
+	// PriorEpisodes Season 之前各季的总集数, 用于把绝对集数换算成这一季的集数, 见 Bangumi.OffsetSource
+	PriorEpisodes int `json:"prior_episodes" gorm:"default:0;comment:'之前季度的总集数'"`
+	// Seasons TMDB 上的各季, 上面的 Season/EpisodeCount/AirDate 只是最后播出的一季, 番剧不一定是这一季, 见 SeasonEpisodes
+	Seasons TmdbSeasons `json:"seasons" gorm:"type:text;comment:'各季的集数和首播日期'"`
+}
+
+// SeasonEpisodes 第 season 季的集数和之前各季的总集数, TMDB 没有这一季时 ok 为 false
+// 没有各季信息的旧条目只知道 Season 这一季
+func (t *TmdbItem) SeasonEpisodes(season int) (count, prior int, ok bool) {
+	if len(t.Seasons) == 0 {
+		if season == t.Season {
+			return t.EpisodeCount, t.PriorEpisodes, true
+		}
+		return 0, 0, false
+	}
+	for _, s := range t.Seasons {
+		if s.Number == season {
+			return s.EpisodeCount, prior, true
+		}
+		if s.Number > 0 && s.Number < season {
+			prior += s.EpisodeCount
+		}
+	}
+	return 0, 0, false
+}
+
+// SeasonAirDate 第 season 季的首播日期, 不知道时为空
+func (t *TmdbItem) SeasonAirDate(season int) string {
+	for _, s := range t.Seasons {
+		if s.Number == season {
+			return s.AirDate
+		}
+	}
+	if season == t.Season {
+		return t.AirDate
+	}
+	return ""
+}
+
+// TmdbSeason TMDB 上的一季
+type TmdbSeason struct {
+	Number       int    `json:"number"`
+	EpisodeCount int    `json:"episode_count"`
+	AirDate      string `json:"air_date"`
+}
+
+// TmdbSeasons 按季度排序的各季, 以 JSON 保存在一列中
+type TmdbSeasons []TmdbSeason
+
+func (s TmdbSeasons) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+func (s *TmdbSeasons) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("无法读取 TMDB 季度信息: %T", src)
+	}
+	if len(data) == 0 {
+		*s = nil
+		return nil
+	}
+	return json.Unmarshal(data, s)
 }
 
 func (t TmdbItem) String() string {
@@ -57,6 +136,47 @@ func NewTmdbItem() *TmdbItem {
 	}
 }
 
+// EpisodeType 剧集类型, 特别篇不计入正片的集数
+type EpisodeType string
+
+const (
+	EpisodeRegular EpisodeType = "regular" // 正片
+	EpisodeSpecial EpisodeType = "special" // SP, 12.5 这样的半集
+	EpisodeOVA     EpisodeType = "ova"     // OVA/OAD
+	EpisodeMovie   EpisodeType = "movie"   // 剧场版
+)
+
+// WatchStatus 用户对番剧的观看状态, 与下载无关, 同步到 Bangumi.tv/MAL 时以它为准
+type WatchStatus string
+
+const (
+	WatchWatching WatchStatus = "watching" // 在看
+	WatchFinished WatchStatus = "finished" // 看过
+	WatchDropped  WatchStatus = "dropped"  // 抛弃
+	WatchPaused   WatchStatus = "paused"   // 搁置
+)
+
+// OffsetSource 番剧集数偏移的来源
+type OffsetSource string
+
+const (
+	OffsetSourceNone   OffsetSource = ""       // 没有设置, 可以自动推断
+	OffsetSourceAuto   OffsetSource = "auto"   // 根据 TMDB 的季度集数自动推断
+	OffsetSourceManual OffsetSource = "manual" // 用户手动设置, 不会被自动推断覆盖
+)
+
+// MaxUserScore 用户评分的上限, 与 Bangumi.tv 和 MAL 的 10 分制一致, 0 表示未评分
+const MaxUserScore = 10
+
+// Valid 判断是否为已知的观看状态
+func (s WatchStatus) Valid() bool {
+	switch s {
+	case WatchWatching, WatchFinished, WatchDropped, WatchPaused:
+		return true
+	}
+	return false
+}
+
 // EpisodeMetadata 用来存储番剧解析器的原始信息
 // 是否要认为一个 EpisodeMetadata 可以对应多个 Bangumi?
 type EpisodeMetadata struct {
@@ -75,17 +195,42 @@ type EpisodeMetadata struct {
 	VideoInfo    string `gorm:"default:'';comment:'视频信息'"`
 	Version      int `gorm:"-;comment:'版本信息'"`
 	BangumiID    int    `gorm:"index;comment:'关联的Bangumi ID'"`
+	SeasonID     int    `gorm:"default:0;index;comment:'关联的季度 ID, 见 Season'"`
 	Collection   bool   `gorm:"-;comment:'是否为合集'"`
 	EpisodeStart int    `gorm:"-;comment:'集数开始'"`
 	EpisodeEnd   int    `gorm:"-;comment:'集数结束'"`
 	Point5       bool   `gorm:"-;comment:'是否为0.5集'"`
+	EpisodeType  EpisodeType `gorm:"default:'regular';comment:'剧集类型'"`
+	EpisodeLabel string `gorm:"-;comment:'特别篇原始标记, 如 SP01 OVA 12.5'"`
 }
 
 // Key 返回用于去重的唯一标识，包含除主键和外键外的所有持久化字段
 func (e EpisodeMetadata) Key() string {
-	return fmt.Sprintf("%s|%d|%s|%s|%s|%s|%s|%s|%s|%s",
+	// 没有设置类型的和数据库默认值一致, 按正片处理
+	episodeType := e.EpisodeType
+	if episodeType == "" {
+		episodeType = EpisodeRegular
+	}
+	return fmt.Sprintf("%s|%d|%s|%s|%s|%s|%s|%s|%s|%s|%s",
 		e.Title, e.Season, e.SeasonRaw, e.Sub, e.SubType,
-		e.Group, e.Resolution, e.Source, e.AudioInfo, e.VideoInfo)
+		e.Group, e.Resolution, e.Source, e.AudioInfo, e.VideoInfo, episodeType)
+}
+
+// Validate 写入数据库前校验并规整字段
+// Title 为空或 Season 为负数时返回 ValidationError,
+// Group/Resolution/SubType 等字段会去掉首尾空白, 为空时保持空字符串, 表示解析器没有识别出来
+func (e *EpisodeMetadata) Validate() error {
+	e.Title = strings.TrimSpace(e.Title)
+	e.Group = strings.TrimSpace(e.Group)
+	e.Resolution = strings.TrimSpace(e.Resolution)
+	e.SubType = strings.TrimSpace(e.SubType)
+	if e.Title == "" {
+		return &apperrors.ValidationError{Field: "Title", Reason: "番剧名称为空, 解析器没有识别出标题"}
+	}
+	if e.Season < 0 {
+		return &apperrors.ValidationError{Field: "Season", Reason: fmt.Sprintf("季度不能为负数: %d", e.Season)}
+	}
+	return nil
 }
 
 // String 式化输出
@@ -109,9 +254,12 @@ func (e EpisodeMetadata) String() string {
 // Bangumi 用于存储一些可配置的番剧信息
 type Bangumi struct {
 	ID            int    `gorm:"primaryKey"`
-	OfficialTitle string `json:"official_title" gorm:"default:'';comment:'番剧中文名'"`
+	// OfficialTitle, Season, RSSLink 组成复合索引, 按番剧信息查找种子时使用, 见 ListTorrentByBangumi
+	OfficialTitle string `json:"official_title" gorm:"default:'';index:idx_bangumi_title,priority:1;comment:'番剧中文名'"`
 	Year          string `json:"year" gorm:"default:'';comment:'番剧年份'"`
-	Season        int    `json:"season" gorm:"default:1;comment:'番剧季度'"`
+	Season        int    `json:"season" gorm:"default:1;index:idx_bangumi_title,priority:2;comment:'番剧季度'"`
+	// TitleAlias 中文名的拼音, 保存时自动生成, 见 TitleAlias
+	TitleAlias string `json:"-" gorm:"default:'';comment:'番剧中文名拼音'"`
 
 	// 外键关联（一对多关系）
 	MikanID *int `json:"mikan_id" gorm:"index;comment:'关联的Mikan ID'"`
@@ -123,18 +271,44 @@ type Bangumi struct {
 	MikanItem *MikanItem `gorm:"foreignKey:MikanID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
 	TmdbItem  *TmdbItem  `gorm:"foreignKey:TmdbID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
 	// 属于一个 RSSItem
-	RSSLink string `json:"rss_link" gorm:"default:'';comment:'关联的RSS订阅链接'"`
+	RSSLink string `json:"rss_link" gorm:"default:'';index:idx_bangumi_title,priority:3;comment:'关联的RSS订阅链接'"`
 
 	// has many关系，关联 BangumiParse
 	EpisodeMetadata []EpisodeMetadata `gorm:"foreignKey:BangumiID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 
 	EpsCollect    bool   `json:"eps_collect" gorm:"default:false;comment:'是否已收集'"`
 	Offset        int    `json:"offset" gorm:"default:0;comment:'番剧偏移量'"`
+	// OffsetSource 偏移的来源; OffsetConfident 自动推断的偏移是否可靠, 不可靠时之后出现的种子会再推断一次
+	OffsetSource    OffsetSource `json:"offset_source" gorm:"default:'';comment:'偏移量来源'"`
+	OffsetConfident bool         `json:"offset_confident" gorm:"default:false;comment:'自动推断的偏移量是否可靠'"`
 	IncludeFilter string `json:"include_filter" gorm:"default:'';comment:'番剧包含过滤器'"`
 	ExcludeFilter string `json:"exclude_filter" gorm:"default:'';comment:'番剧排除过滤器'"`
+	// FilterOverride 覆盖全局规则的其他过滤规则(JSON), 见 FilterRules
+	FilterOverride string `json:"filter_override" gorm:"default:'';comment:'覆盖全局规则的过滤规则'"`
 	Parse         string `json:"parser" gorm:"default:'tmdb';comment:'番剧解析器'"`
 	PosterLink    string `json:"poster_link" gorm:"default:'';comment:'番剧海报链接'"`
+	PosterPath    string `json:"poster_path" gorm:"default:'';comment:'本地缓存的海报路径'"`
 	Deleted       bool   `json:"deleted" gorm:"default:false;comment:'是否已删除'"`
+	// DeletedAt 移入回收站的时间, 超过保留期后由 PurgeDeletedBangumi 彻底删除
+	DeletedAt *time.Time `json:"deleted_at" gorm:"comment:'删除时间'"`
+	// Completed 已完结且全部集数已下载, 定时刷新会跳过, 见 ReactivateBangumi
+	Completed bool `json:"completed" gorm:"default:false;comment:'是否已完结'"`
+	// Backfilled 已经补全过创建番剧之前播出的集数, 或者不需要补全(导入的番剧), 见 refresh.Refresher.Backfill
+	Backfilled bool `json:"backfilled" gorm:"default:false;comment:'是否已补全之前的集数'"`
+	// Version 每次更新加一, UpdateBangumi 用它检测并发修改
+	Version int `json:"version" gorm:"default:0;comment:'版本号'"`
+
+	// 下载偏好, 见 ReleasePreference; 分辨率和字幕组为逗号分隔的列表, 越靠前越优先
+	PreferredResolutions string `json:"preferred_resolutions" gorm:"default:'';comment:'优先的分辨率'"`
+	PreferredGroups      string `json:"preferred_groups" gorm:"default:'';comment:'优先的字幕组'"`
+	PreferredSubType     string `json:"preferred_sub_type" gorm:"default:'';comment:'优先的字幕类型'"`
+	SkipBatch            bool   `json:"skip_batch" gorm:"default:false;comment:'不下载合集'"`
+	PreferBatch          bool   `json:"prefer_batch" gorm:"default:false;comment:'补全时优先下载合集'"`
+
+	// 用户的观看记录, 见 WatchStatus; UserScore 为 0 时表示未评分
+	Status    WatchStatus `json:"status" gorm:"default:'watching';index;comment:'观看状态'"`
+	UserScore int         `json:"user_score" gorm:"default:0;comment:'用户评分'"`
+	Notes     string      `json:"notes" gorm:"default:'';comment:'备注'"`
 }
 
 // NewBangumi 创建一个默认的 Bangumi 实例
@@ -145,11 +319,7 @@ func NewBangumi() *Bangumi {
 	}
 }
 
-// 重新设计几个表来确定 bangumi 和 mikanid, tmdbid , bangumiid 的关系
-// mikanid -> id, mikan_id,rss_link
-// tmdbid -> id, tmdb_id,rss_link, 这个 id 要加个 #season
-// bangumiid -> id, bangumi_id,rss_link
-// 当解析到相同的, ID, 重建 ID
+// bangumi 和 mikanid, tmdbid, bangumiid 的关系由映射表确定, 见 mapping.go
 // bangumi 里面要保留的项: 前端要: title,year, seasion , group,  group_name,poster_link,parser,
 
 // 流程如下:
@@ -8,6 +8,7 @@ type Config struct {
 	Rename       BangumiRenameConfig `yaml:"rename" env-prefix:"RENAME_"`
 	Notification NotificationConfig  `yaml:"notification" env-prefix:"NOTIFICATION_"`
 	Proxy        ProxyConfig         `yaml:"proxy" env-prefix:"PROXY_"`
+	Database     DatabaseConfig      `yaml:"database" env-prefix:"DATABASE_"`
 }
 
 type ProgramConfig struct {
@@ -15,6 +16,62 @@ type ProgramConfig struct {
 	WebuiPort   int    `yaml:"webui_port" env:"WEBUI_PORT" env-default:"7892"`
 	PassWord    string `yaml:"password" env:"PASSWORD" env-default:"adminadmin"`
 	DebugEnable bool   `yaml:"debug_enable" env:"DEBUG_ENABLE" env-default:"false"`
+	// RssJitter 每个订阅的刷新时间随机提前或推后刷新间隔的百分之几, 避免所有订阅同时请求
+	// RssConcurrency 同时刷新的订阅数量上限
+	RssJitter      int `yaml:"rss_jitter" env:"RSS_JITTER" env-default:"10"`
+	RssConcurrency int `yaml:"rss_concurrency" env:"RSS_CONCURRENCY" env-default:"2"`
+	// ResolveConcurrency 一次刷新中同时解析(请求 Mikan、TMDB)的新番剧数量上限
+	ResolveConcurrency int `yaml:"resolve_concurrency" env:"RESOLVE_CONCURRENCY" env-default:"3"`
+	// Backfill 创建番剧后补全创建之前已经播出的集数
+	Backfill bool `yaml:"backfill" env:"BACKFILL" env-default:"true"`
+	// RssFailureNotify 订阅连续失败多少次后发送通知, 为 0 时不通知; 失败的订阅刷新间隔每次翻倍, 最长一天
+	RssFailureNotify int `yaml:"rss_failure_notify" env:"RSS_FAILURE_NOTIFY" env-default:"3"`
+	// ReleaseWindow 同一集等待多少分钟, 收集各个字幕组的种子后按下载偏好挑选最好的一个, 为 0 时出现就下载
+	// UpgradeGrace 一集入队后多少小时内出现了更好的版本时下载新版本并替换旧的, 为 0 时不替换
+	ReleaseWindow int `yaml:"release_window" env:"RELEASE_WINDOW" env-default:"0"`
+	UpgradeGrace  int `yaml:"upgrade_grace" env:"UPGRADE_GRACE" env-default:"0"`
+	// GapSearchInterval 检测缺集并重新搜索的间隔(小时), 为 0 时不启用
+	// GapSearchAttempts 一集搜索多少次仍然没有找到时发送通知, 为 0 时不通知
+	GapSearchInterval int `yaml:"gap_search_interval" env:"GAP_SEARCH_INTERVAL" env-default:"24"`
+	GapSearchAttempts int `yaml:"gap_search_attempts" env:"GAP_SEARCH_ATTEMPTS" env-default:"5"`
+	// DataDir 数据目录, 为空时使用 GOTO_BANGUMI_DATA_DIR 环境变量, 都没有则为 ./data
+	DataDir string `yaml:"data_dir" env:"DATA_DIR"`
+	// RequestTimeout 网络请求(包括重试)的超时时间(秒), UserAgent 为空时使用浏览器的 User-Agent
+	RequestTimeout int    `yaml:"request_timeout" env:"REQUEST_TIMEOUT" env-default:"30"`
+	UserAgent      string `yaml:"user_agent" env:"USER_AGENT"`
+	// RateLimit 对每个站点(Mikan、TMDB 等)每秒最多的请求数, 为 0 时不限速; RateBurst 允许的突发请求数
+	RateLimit float64 `yaml:"rate_limit" env:"RATE_LIMIT" env-default:"2"`
+	RateBurst int     `yaml:"rate_burst" env:"RATE_BURST" env-default:"5"`
+	// TrashDays 删除的番剧在回收站保留的天数, 过期后连同种子彻底删除, 为 0 时不自动清理
+	TrashDays int `yaml:"trash_days" env:"TRASH_DAYS" env-default:"30"`
+	// BackupInterval 定时快照数据库的间隔(小时), 为 0 时不启用; BackupKeep 保留的快照数量
+	// 快照保存在数据目录的 backups 下, 只支持 sqlite
+	BackupInterval int `yaml:"backup_interval" env:"BACKUP_INTERVAL" env-default:"0"`
+	BackupKeep     int `yaml:"backup_keep" env:"BACKUP_KEEP" env-default:"7"`
+	// MaintenanceInterval 数据库维护(清理旧种子和下载历史, VACUUM/ANALYZE)的间隔(小时), 为 0 时不启用
+	MaintenanceInterval int `yaml:"maintenance_interval" env:"MAINTENANCE_INTERVAL" env-default:"168"`
+	// TorrentRetentionDays 已完结的番剧超过这个天数没有新种子时清理它的种子记录, 只保留最新的 TorrentKeep 个
+	// 为 0 时不清理种子
+	TorrentRetentionDays int `yaml:"torrent_retention_days" env:"TORRENT_RETENTION_DAYS" env-default:"0"`
+	TorrentKeep          int `yaml:"torrent_keep" env:"TORRENT_KEEP" env-default:"0"`
+	// HistoryDays 下载历史保留的天数, 为 0 时不清理
+	HistoryDays int `yaml:"history_days" env:"HISTORY_DAYS" env-default:"180"`
+}
+
+// DatabaseConfig 数据库配置, Driver 为 sqlite/postgres/mysql
+// sqlite 的 DSN 为空时使用数据目录下的 data.db, 数据目录在网络存储上时建议改用 postgres
+type DatabaseConfig struct {
+	Driver string `yaml:"driver" env:"DRIVER" env-default:"sqlite"`
+	DSN    string `yaml:"dsn" env:"DSN"`
+	// 以下只对 sqlite 生效, 为空或 0 时使用默认值(WAL, 5000 毫秒, NORMAL, 连接数不限)
+	JournalMode  string `yaml:"journal_mode" env:"JOURNAL_MODE" env-default:"WAL"`
+	BusyTimeout  int    `yaml:"busy_timeout" env:"BUSY_TIMEOUT" env-default:"5000"`
+	Synchronous  string `yaml:"synchronous" env:"SYNCHRONOUS" env-default:"NORMAL"`
+	MaxOpenConns int    `yaml:"max_open_conns" env:"MAX_OPEN_CONNS"`
+	MaxIdleConns int    `yaml:"max_idle_conns" env:"MAX_IDLE_CONNS"`
+	// QueryCacheSize 按链接查种子、按标题匹配番剧的内存缓存条数, 为 0 时不缓存; QueryCacheTTL 缓存有效期(秒)
+	QueryCacheSize int `yaml:"query_cache_size" env:"QUERY_CACHE_SIZE" env-default:"1000"`
+	QueryCacheTTL  int `yaml:"query_cache_ttl" env:"QUERY_CACHE_TTL" env-default:"300"`
 }
 
 type DownloaderConfig struct {
@@ -33,6 +90,33 @@ type RssParserConfig struct {
 	Language       string   `yaml:"language" env:"LANGUAGE" env-default:"zh"`
 	MikanCustomURL string   `yaml:"mikan_custom_url" env:"MIKAN_CUSTOM_URL" env-default:"mikanani.me"`
 	TmdbAPIKey     string   `yaml:"tmdb_api_key" env:"TMDB_API_KEY"`
+	// MikanToken/MikanCookie 用于访问 Mikan 的个人订阅, 只会发送给 MikanCustomURL 对应的域名
+	MikanToken  string `yaml:"mikan_token" env:"MIKAN_TOKEN"`
+	MikanCookie string `yaml:"mikan_cookie" env:"MIKAN_COOKIE"`
+	// MediaTypes 允许入队的内容类型(video/subtitle/archive/other), 为空时只允许 video
+	MediaTypes []string `yaml:"media_types"`
+	// VideoExtensions 额外视为视频的扩展名, 如 ".rmvb"
+	VideoExtensions []string `yaml:"video_extensions"`
+	// 种子大小(MB)和发布时间(天)过滤, 0 表示不限制
+	MinSizeMB  int `yaml:"min_size_mb" env:"MIN_SIZE_MB" env-default:"0"`
+	MaxSizeMB  int `yaml:"max_size_mb" env:"MAX_SIZE_MB" env-default:"0"`
+	MaxAgeDays int `yaml:"max_age_days" env:"MAX_AGE_DAYS" env-default:"0"`
+	// MinSeeders 做种人数下限, 0 表示不限制, 只对提供做种人数的订阅(Nyaa)生效
+	MinSeeders int `yaml:"min_seeders" env:"MIN_SEEDERS" env-default:"0"`
+	// 按标题解析出的分辨率、字幕类型、字幕组和字幕语言过滤, 见 FieldRule; 番剧可以单独覆盖
+	Resolution  FieldRule `yaml:"resolution"`
+	SubType     FieldRule `yaml:"sub_type"`
+	Group       FieldRule `yaml:"group"`
+	SubLanguage FieldRule `yaml:"sub_language"`
+	// SearchProviders 缺集搜索和手动搜索使用的站点(mikan/nyaa/dmhy/torznab), 为空时缺集只在 RSS 中查找
+	SearchProviders []string `yaml:"search_providers" env:"SEARCH_PROVIDERS" env-default:"mikan,nyaa,dmhy"`
+	// Jackett/Prowlarr 的 Torznab 接口地址和 API Key, 配置后可以在 SearchProviders 中使用 torznab;
+	// TorznabCategories 为空时只搜索动画分类(5070)
+	TorznabURL        string `yaml:"torznab_url" env:"TORZNAB_URL"`
+	TorznabAPIKey     string `yaml:"torznab_api_key" env:"TORZNAB_API_KEY"`
+	TorznabCategories []int  `yaml:"torznab_categories" env:"TORZNAB_CATEGORIES"`
+	// MetadataTTLHours TMDB/Mikan 查询结果在数据库中的缓存时间(小时)
+	MetadataTTLHours int `yaml:"metadata_ttl_hours" env:"METADATA_TTL_HOURS" env-default:"24"`
 }
 
 type BangumiRenameConfig struct {
@@ -41,6 +125,9 @@ type BangumiRenameConfig struct {
 	RenameMethod string `yaml:"rename_method" env:"RENAME_METHOD" env-default:"advanced"`
 	Year         bool   `yaml:"year" env:"YEAR" env-default:"false"`
 	Group        bool   `yaml:"group" env:"GROUP" env-default:"false"`
+	// Template 命名模板, 如 {title}/Season {season:02d}/{title} - S{season:02d}E{episode:02d}{ext}
+	// 为空时使用默认的 标题 (年份) S01E02 - 字幕组 格式
+	Template string `yaml:"template" env:"TEMPLATE"`
 }
 
 type NotificationConfig struct {
@@ -48,4 +135,6 @@ type NotificationConfig struct {
 	Type   string `yaml:"type" env:"TYPE" env-default:"telegram"`
 	Token  string `yaml:"token" env:"TOKEN"`
 	ChatID string `yaml:"chat_id" env:"CHAT_ID"`
+	// WebhookURL 新番发现、入队、下载完成、重命名完成和失败时 POST JSON 到该地址
+	WebhookURL string `yaml:"webhook_url" env:"WEBHOOK_URL"`
 }
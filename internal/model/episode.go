@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// EpisodeState 单集的下载状态
+type EpisodeState string
+
+const (
+	EpisodeMissing     EpisodeState = "missing"     // 没有可用的种子(下载失败或种子被删除)
+	EpisodeDownloading EpisodeState = "downloading" // 种子已入队或正在下载
+	EpisodeDownloaded  EpisodeState = "downloaded"  // 下载完成, 还没有重命名
+	EpisodeRenamed     EpisodeState = "renamed"     // 已重命名, FilePath 为重命名后的路径
+)
+
+// Episode 番剧每一集的下载情况, 由刷新和重命名流程维护
+// 集数已经加上番剧的偏移, 与重命名后的文件名一致; 只记录正片, 合集会展开成每一集
+type Episode struct {
+	ID          int          `gorm:"primaryKey;autoIncrement" json:"id"`
+	BangumiID   int          `gorm:"uniqueIndex:idx_episode;comment:'所属番剧 ID'" json:"bangumi_id"`
+	Season      int          `gorm:"uniqueIndex:idx_episode;comment:'季度'" json:"season"`
+	Number      int          `gorm:"uniqueIndex:idx_episode;comment:'集数'" json:"number"`
+	State       EpisodeState `gorm:"default:'missing';index;comment:'下载状态'" json:"state"`
+	TorrentLink string       `gorm:"default:'';index;comment:'提供这一集的种子'" json:"torrent_link"`
+	FilePath    string       `gorm:"default:'';comment:'重命名后的文件路径, 相对于下载目录'" json:"file_path"`
+	// SearchAttempts 缺集搜索没有找到这一集的次数, 见 refresh.Refresher.SearchGaps
+	SearchAttempts int       `gorm:"default:0;comment:'缺集搜索的次数'" json:"search_attempts"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
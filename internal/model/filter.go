@@ -0,0 +1,128 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// FieldRule 按标题解析出的某个字段(分辨率、字幕类型、字幕组、字幕语言)过滤
+// 关键词不区分大小写, 字段包含关键词即为匹配; Include 不为空时必须匹配其中一个, 匹配 Exclude 中任意一个时排除
+// 标题中解析不出这个字段时不按它过滤
+type FieldRule struct {
+	Include []string `yaml:"include" json:"include,omitempty"`
+	Exclude []string `yaml:"exclude" json:"exclude,omitempty"`
+}
+
+// IsZero 没有设置任何关键词
+func (r FieldRule) IsZero() bool {
+	return len(r.Include) == 0 && len(r.Exclude) == 0
+}
+
+// FilterRules 种子过滤规则, 全局规则来自 parser 配置, 番剧可以覆盖其中的一部分, 见 Override 和 Bangumi.FilterRules
+// 大小、发布时间和做种人数为 0 时不限制, RSS 中没有对应信息的种子也不按它们过滤
+type FilterRules struct {
+	// Include/Exclude 匹配种子标题的正则, Include 不为空时必须匹配其中一个
+	Include    []string  `json:"include,omitempty"`
+	Exclude    []string  `json:"exclude,omitempty"`
+	MinSizeMB  int       `json:"min_size_mb,omitempty"`
+	MaxSizeMB  int       `json:"max_size_mb,omitempty"`
+	MaxAgeDays int       `json:"max_age_days,omitempty"`
+	MinSeeders int       `json:"min_seeders,omitempty"`
+	Resolution FieldRule `json:"resolution,omitzero"`
+	SubType    FieldRule `json:"sub_type,omitzero"`
+	Group      FieldRule `json:"group,omitzero"`
+	Language   FieldRule `json:"language,omitzero"`
+}
+
+// Override 用 o 中设置了的规则替换 r 中对应的规则, o 中为零值的规则沿用 r
+func (r FilterRules) Override(o FilterRules) FilterRules {
+	if len(o.Include) > 0 {
+		r.Include = o.Include
+	}
+	if len(o.Exclude) > 0 {
+		r.Exclude = o.Exclude
+	}
+	if o.MinSizeMB > 0 {
+		r.MinSizeMB = o.MinSizeMB
+	}
+	if o.MaxSizeMB > 0 {
+		r.MaxSizeMB = o.MaxSizeMB
+	}
+	if o.MaxAgeDays > 0 {
+		r.MaxAgeDays = o.MaxAgeDays
+	}
+	if o.MinSeeders > 0 {
+		r.MinSeeders = o.MinSeeders
+	}
+	for _, f := range []struct{ dst, src *FieldRule }{
+		{&r.Resolution, &o.Resolution},
+		{&r.SubType, &o.SubType},
+		{&r.Group, &o.Group},
+		{&r.Language, &o.Language},
+	} {
+		if !f.src.IsZero() {
+			*f.dst = *f.src
+		}
+	}
+	return r
+}
+
+// WithPatterns 用逗号分隔的包含和排除正则替换 Include/Exclude, 与 Bangumi.IncludeFilter 的格式相同
+func (r FilterRules) WithPatterns(include, exclude string) FilterRules {
+	r.Include = splitPreference(include)
+	r.Exclude = splitPreference(exclude)
+	return r
+}
+
+// FilterRules 解析番剧覆盖全局规则的过滤规则, 没有设置或无法解析时为零值
+// 包含和排除正则仍然保存在 IncludeFilter/ExcludeFilter
+func (b *Bangumi) FilterRules() FilterRules {
+	var rules FilterRules
+	if b.FilterOverride != "" {
+		_ = json.Unmarshal([]byte(b.FilterOverride), &rules)
+	}
+	return rules
+}
+
+// SetFilterRules 把覆盖全局规则的过滤规则写回番剧, 正则写入 IncludeFilter/ExcludeFilter
+func (b *Bangumi) SetFilterRules(rules FilterRules) {
+	b.IncludeFilter = joinPreference(rules.Include)
+	b.ExcludeFilter = joinPreference(rules.Exclude)
+	rules.Include, rules.Exclude = nil, nil
+	data, _ := json.Marshal(rules)
+	if s := string(data); s != "{}" {
+		b.FilterOverride = s
+	} else {
+		b.FilterOverride = ""
+	}
+}
+
+// EffectiveFilterRules 番剧实际使用的过滤规则: 全局规则, 替换成番剧的包含和排除正则, 再用番剧的规则覆盖
+func (b *Bangumi) EffectiveFilterRules(global FilterRules) FilterRules {
+	return global.WithPatterns(b.IncludeFilter, b.ExcludeFilter).Override(b.FilterRules())
+}
+
+// hasKeyword value 是否包含 keywords 中的任意一个, 不区分大小写
+func hasKeyword(value string, keywords []string) bool {
+	value = strings.ToLower(value)
+	for _, k := range keywords {
+		if k = strings.TrimSpace(k); k != "" && strings.Contains(value, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match 字段的值是否通过规则, 返回不通过的原因; 值为空时总是通过
+func (r FieldRule) Match(value string) (bool, string) {
+	if value == "" {
+		return true, ""
+	}
+	if hasKeyword(value, r.Exclude) {
+		return false, "匹配排除关键词 " + strings.Join(r.Exclude, ",")
+	}
+	if len(r.Include) > 0 && !hasKeyword(value, r.Include) {
+		return false, "不包含关键词 " + strings.Join(r.Include, ",")
+	}
+	return true, ""
+}
@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+// DownloadAction 下载历史中的动作
+type DownloadAction string
+
+const (
+	ActionQueued     DownloadAction = "queued"     // 加入下载队列
+	ActionSent       DownloadAction = "sent"       // 已发送到下载器
+	ActionDownloaded DownloadAction = "downloaded" // 下载完成
+	ActionRenamed    DownloadAction = "renamed"    // 重命名完成
+	ActionFailed     DownloadAction = "failed"     // 任务失败, Message 为失败原因
+	ActionReplaced   DownloadAction = "replaced"   // 被同一集的修正版替代
+	ActionDeleted    DownloadAction = "deleted"    // 种子记录被删除
+)
+
+// DownloadEvent 种子的下载历史, 每次状态变化记录一条, 只追加不修改
+// 种子删除后历史仍然保留, 用来事后排查某一集为什么没有下载
+type DownloadEvent struct {
+	ID        int            `gorm:"primaryKey;autoIncrement" json:"id"`
+	Link      string         `gorm:"index;comment:'种子链接'" json:"link"`
+	BangumiID int            `gorm:"index;default:0;comment:'所属番剧 ID'" json:"bangumi_id"`
+	Action    DownloadAction `gorm:"comment:'动作'" json:"action"`
+	Message   string         `gorm:"default:'';comment:'附加信息'" json:"message"`
+	CreatedAt time.Time      `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// DownloadRemoval 彻底删除番剧时要从下载器中删除的种子, 连同已下载的文件一起删除
+// 和删除番剧在同一个事务中记录, 从下载器删除成功后才删除记录, 失败或程序重启后会重试
+type DownloadRemoval struct {
+	DownloadUID string    `gorm:"primaryKey;comment:'下载器中的种子 UID'" json:"download_uid"`
+	BangumiID   int       `gorm:"index;default:0;comment:'所属番剧 ID'" json:"bangumi_id"`
+	Title       string    `gorm:"default:'';comment:'番剧标题'" json:"title"`
+	Downloader  string    `gorm:"default:'';comment:'添加种子的下载器名称, 为空表示默认下载器'" json:"downloader"`
+	Attempts    int       `gorm:"default:0;comment:'删除失败次数'" json:"attempts"`
+	LastError   string    `gorm:"default:'';comment:'最后一次删除失败原因'" json:"last_error"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// 元数据查询缓存的类型
+const (
+	LookupTMDB  = "tmdb"
+	LookupMikan = "mikan"
+)
+
+// MetadataLookup 记录一次元数据查询的结果, 用于在 TTL 内跳过网络请求
+// Key 为查询条件(TMDB 为 语言|标题, Mikan 为 homepage), ItemID 指向 TmdbItem/MikanItem
+type MetadataLookup struct {
+	Key       string    `gorm:"primaryKey;comment:'查询条件'"`
+	Kind      string    `gorm:"primaryKey;comment:'元数据类型'"`
+	ItemID    int       `gorm:"index;comment:'TmdbItem 或 MikanItem 的 ID'"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// 外部 ID 到番剧(BangumiUID, 即 Bangumi.ID)的映射, 创建番剧时按这些表查重
+// 每个外部 ID 只对应一个番剧; 同一部番剧的不同外部 ID 指向不同番剧时说明它们是重复的, 会合并成一个
+
+// MikanMapping Mikan 番剧 ID 到番剧, Mikan 的每一季是不同的 ID
+type MikanMapping struct {
+	MikanID   int       `gorm:"primaryKey;autoIncrement:false" json:"mikan_id"`
+	BangumiID int       `gorm:"index;not null" json:"bangumi_id"`
+	RSSLink   string    `gorm:"default:''" json:"rss_link"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TmdbMapping TMDB ID 和季度到番剧, TMDB 的同一部剧不区分季度, 要和季度一起才能确定一个番剧
+type TmdbMapping struct {
+	TmdbID    int       `gorm:"primaryKey;autoIncrement:false" json:"tmdb_id"`
+	Season    int       `gorm:"primaryKey;autoIncrement:false" json:"season"`
+	BangumiID int       `gorm:"index;not null" json:"bangumi_id"`
+	RSSLink   string    `gorm:"default:''" json:"rss_link"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BgmMapping bangumi.tv 条目 ID 到番剧, 条目区分季度
+type BgmMapping struct {
+	BgmID     int       `gorm:"primaryKey;autoIncrement:false" json:"bgm_id"`
+	BangumiID int       `gorm:"index;not null" json:"bangumi_id"`
+	RSSLink   string    `gorm:"default:''" json:"rss_link"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -26,15 +26,39 @@ type RSSXml struct {
 
 // RSSTorrent represents a single torrent item
 type RSSTorrent struct {
-	Name string `xml:"title"`
-	Link string `xml:"link"`
+	Name        string `xml:"title"`
+	Link        string `xml:"link"`
+	PubDate     string `xml:"pubDate"`
+	GUID        string `xml:"guid"`
+	Description string `xml:"description"`
+	// Mikan 的扩展字段 <torrent xmlns="https://mikanani.me/0.1/">
+	Torrent MikanTorrent `xml:"torrent"`
 	// Homepage string `xml:"guid"`
 	Enclosure Enclosure `xml:"enclosure"`
+	// Nyaa 的扩展字段 <nyaa:infoHash> <nyaa:size> <nyaa:seeders>, size 为 1.4 GiB 这样的可读格式
+	InfoHash string `xml:"infoHash"`
+	Size     string `xml:"size"`
+	Seeders  *int   `xml:"seeders"`
+	// Torznab(Jackett/Prowlarr)的扩展字段 <torznab:attr name="seeders" value="12"/>, 此时 size 为字节数
+	Attrs []TorznabAttr `xml:"attr"`
 	// Homepage struct {
 	// 	URL string `xml:"url,attr"`
 	// } `xml:"enclosure"`
 }
 
 type Enclosure struct {
-	URL string `xml:"url,attr"`
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+// TorznabAttr Torznab 结果中的 <torznab:attr>
+type TorznabAttr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// MikanTorrent Mikan RSS 中每个 item 的 torrent 扩展信息
+type MikanTorrent struct {
+	ContentLength int64  `xml:"contentLength"`
+	PubDate       string `xml:"pubDate"`
 }
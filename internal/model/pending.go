@@ -0,0 +1,70 @@
+package model
+
+import "time"
+
+// PendingTorrent 刷新时解析失败或匹配不到番剧的种子, 创建新番剧之后重试匹配
+// 匹配成功后记录会被删除; Attempts 达到上限后不再重试, 作为无法匹配的种子等待用户处理:
+// 手动指定番剧(见 refresh.Refresher.AssignPending)或者忽略(Dismissed)
+type PendingTorrent struct {
+	Link     string    `gorm:"primaryKey;comment:'种子链接'" json:"link"`
+	Name     string    `gorm:"default:'';comment:'种子名称'" json:"name"`
+	Homepage string    `gorm:"default:'';comment:'种子主页'" json:"homepage"`
+	Size     int64     `gorm:"default:0;comment:'种子大小'" json:"size"`
+	PubDate  time.Time `gorm:"comment:'发布时间'" json:"pub_date"`
+	InfoHash string    `gorm:"default:'';comment:'种子 info hash'" json:"info_hash"`
+	// RSSLink 种子来自的订阅
+	RSSLink   string `gorm:"default:'';index;comment:'来源订阅链接'" json:"rss_link"`
+	Attempts  int    `gorm:"default:0;index;comment:'重试匹配失败次数'" json:"attempts"`
+	LastError string `gorm:"default:'';comment:'最后一次匹配失败原因'" json:"last_error"`
+	// ResolveError 最后一次为种子创建番剧失败的原因, 见 refresh.Refresher.FindNewBangumi; 没有尝试创建时为空
+	ResolveError string `gorm:"default:'';comment:'最后一次创建番剧失败原因'" json:"resolve_error"`
+	// 从标题解析出的信息, 用于判断为什么匹配不到; 解析不出时为零值
+	ParsedTitle   string `gorm:"default:'';comment:'解析出的番剧名称'" json:"parsed_title"`
+	ParsedSeason  int    `gorm:"default:0;comment:'解析出的季度'" json:"parsed_season"`
+	ParsedEpisode int    `gorm:"default:0;comment:'解析出的集数'" json:"parsed_episode"`
+	ParsedGroup   string `gorm:"default:'';comment:'解析出的字幕组'" json:"parsed_group"`
+	// Dismissed 用户忽略的种子, 不再重试也不再列出; 记录保留, 订阅里再次出现时不会重新加入
+	Dismissed bool      `gorm:"default:false;index;comment:'是否已忽略'" json:"dismissed"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// NewPendingTorrent 从匹配不到番剧的种子创建待匹配记录
+func NewPendingTorrent(t *Torrent, rssLink string, cause error) *PendingTorrent {
+	p := &PendingTorrent{
+		Link:     t.Link,
+		Name:     t.Name,
+		Homepage: t.Homepage,
+		Size:     t.Size,
+		PubDate:  t.PubDate,
+		InfoHash: t.InfoHash,
+		RSSLink:  rssLink,
+	}
+	if cause != nil {
+		p.LastError = cause.Error()
+	}
+	return p
+}
+
+// SetParsed 记录从标题解析出的信息, meta 为 nil 时不做任何事
+func (p *PendingTorrent) SetParsed(meta *EpisodeMetadata) {
+	if meta == nil {
+		return
+	}
+	p.ParsedTitle = meta.Title
+	p.ParsedSeason = meta.Season
+	p.ParsedEpisode = meta.Episode
+	p.ParsedGroup = meta.Group
+}
+
+// Torrent 还原成刷新时的种子, 用于重新匹配
+func (p *PendingTorrent) Torrent() *Torrent {
+	return &Torrent{
+		Link:     p.Link,
+		Name:     p.Name,
+		Homepage: p.Homepage,
+		Size:     p.Size,
+		PubDate:  p.PubDate,
+		InfoHash: p.InfoHash,
+	}
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"slices"
+	"strings"
+)
+
+// ReleasePreference 番剧的下载偏好, 同一集有多个种子时按它挑选, 见 Bangumi.Preference
+// Resolutions 和 Groups 越靠前越优先, 为空时不区分; 比较时不区分大小写
+type ReleasePreference struct {
+	Resolutions []string `json:"resolutions"`
+	Groups      []string `json:"groups"`
+	SubType     string   `json:"sub_type"`
+	AllowBatch  bool     `json:"allow_batch"`
+	// PreferBatch 补全之前的集数时, 有覆盖缺失集数的合集就下载合集而不是逐集下载; AllowBatch 为 false 时无效
+	PreferBatch bool `json:"prefer_batch"`
+}
+
+// Preference 解析番剧的偏好字段
+func (b *Bangumi) Preference() ReleasePreference {
+	return ReleasePreference{
+		Resolutions: splitPreference(b.PreferredResolutions),
+		Groups:      splitPreference(b.PreferredGroups),
+		SubType:     strings.TrimSpace(b.PreferredSubType),
+		AllowBatch:  !b.SkipBatch,
+		PreferBatch: b.PreferBatch,
+	}
+}
+
+// SetPreference 把偏好写回番剧的字段, 列表中的空项和重复项会被去掉
+func (b *Bangumi) SetPreference(p ReleasePreference) {
+	b.PreferredResolutions = joinPreference(p.Resolutions)
+	b.PreferredGroups = joinPreference(p.Groups)
+	b.PreferredSubType = strings.TrimSpace(p.SubType)
+	b.SkipBatch = !p.AllowBatch
+	b.PreferBatch = p.PreferBatch
+}
+
+// 偏好得分的权重: 分辨率优先于字幕组, 字幕组优先于字幕类型, 前一项总能压过后面的
+const (
+	preferResolution = 10000
+	preferGroup      = 100
+	preferSubType    = 1
+)
+
+// Score 按偏好给种子的解析信息打分, 得分越高越优先; 不在列表中的分辨率和字幕组不加分
+func (p ReleasePreference) Score(meta EpisodeMetadata) int {
+	score := 0
+	if i := indexFold(p.Resolutions, meta.Resolution); i >= 0 {
+		score += (len(p.Resolutions) - i) * preferResolution
+	}
+	if i := indexFold(p.Groups, meta.Group); i >= 0 {
+		score += min(len(p.Groups)-i, preferResolution/preferGroup-1) * preferGroup
+	}
+	if p.SubType != "" && strings.EqualFold(p.SubType, meta.SubType) {
+		score += preferSubType
+	}
+	return score
+}
+
+// IsZero 没有设置任何偏好, 允许合集
+func (p ReleasePreference) IsZero() bool {
+	return len(p.Resolutions) == 0 && len(p.Groups) == 0 && p.SubType == "" && p.AllowBatch && !p.PreferBatch
+}
+
+func indexFold(list []string, value string) int {
+	if value == "" {
+		return -1
+	}
+	return slices.IndexFunc(list, func(s string) bool { return strings.EqualFold(s, value) })
+}
+
+func splitPreference(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func joinPreference(items []string) string {
+	var kept []string
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item != "" && indexFold(kept, item) < 0 {
+			kept = append(kept, item)
+		}
+	}
+	return strings.Join(kept, ",")
+}
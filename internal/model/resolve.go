@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// ResolveAttempt 记录解析失败的番剧标题, 用于退避重试
+// 解析成功后记录会被删除
+type ResolveAttempt struct {
+	Key       string    `gorm:"primaryKey;comment:'解析标题'"`
+	Attempts  int       `gorm:"default:0;comment:'连续失败次数'"`
+	LastError string    `gorm:"default:'';comment:'最后一次失败原因'"`
+	NextRetry time.Time `gorm:"index;comment:'下次允许重试的时间'"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
@@ -1,13 +1,78 @@
 package model
 
+import (
+	"strings"
+	"time"
+)
+
 // RSSItem RSS订阅项模型
 type RSSItem struct {
 	ID        uint   `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
 	Name      string `gorm:"column:name" json:"name"`
 	Link       string  `gorm:"default:'https://mikanani.me';index;column:link" json:"link"`
+	// Aggregate 包含多个番剧的聚合订阅, 见 IsAggregate
 	Aggregate bool    `gorm:"default:false;column:aggregate" json:"aggregate"`
 	Parse    string  `gorm:"default:'tmdb';column:parser" json:"parser"`
 	IncludeFilter string `json:"include_filter" gorm:"default:'';comment:'番剧包含过滤器'"`
 	ExcludeFilter string `json:"exclude_filter" gorm:"default:'';comment:'番剧排除过滤器'"`
 	Enabled   bool    `gorm:"default:true;column:enabled" json:"enabled"`
+	// Source 订阅来源(mikan/nyaa/dmhy/acgrip/bangumimoe/torznab), 为空时根据链接识别
+	Source string `gorm:"default:'';column:source" json:"source"`
+	// Label 用户自定义的分组标签
+	Label string `gorm:"default:'';column:label" json:"label"`
+	// IntervalSeconds 这个订阅的刷新间隔(秒), 为 0 时使用全局的 rss_time
+	IntervalSeconds int `gorm:"default:0;column:interval_seconds" json:"interval_seconds"`
+
+	// 最近一次拉取的结果, 由 RecordRSSFetch 维护
+	LastFetchedAt       *time.Time `gorm:"column:last_fetched_at" json:"last_fetched_at"`
+	LastStatus          string     `gorm:"default:'';column:last_status" json:"last_status"` // 成功时为 RSSStatusOK, 失败时为错误信息
+	ConsecutiveFailures int        `gorm:"default:0;column:consecutive_failures" json:"consecutive_failures"`
+
+	// NextRunAt 调度器计划的下一次拉取时间(带随机抖动), 为空时按 LastFetchedAt 和刷新间隔计算
+	NextRunAt *time.Time `gorm:"column:next_run_at" json:"next_run_at"`
+
+	// 条件拉取用的校验信息, 订阅没有变化时跳过解析, 只在一次刷新完全成功后由 SetRSSFeedState 更新
+	ETag         string `gorm:"default:'';column:etag" json:"etag"`
+	LastModified string `gorm:"default:'';column:last_modified" json:"last_modified"`
+	ContentHash  string `gorm:"default:'';column:content_hash" json:"content_hash"` // 订阅内容的 sha256
+}
+
+// RSSStatusOK 最近一次拉取成功时 LastStatus 的值
+const RSSStatusOK = "ok"
+
+// IsAggregate 是否为聚合订阅, Mikan 的 MyBangumi 订阅即使没有设置 Aggregate 也是聚合订阅
+func (r *RSSItem) IsAggregate() bool {
+	return r.Aggregate || strings.Contains(r.Link, "/RSS/MyBangumi")
+}
+
+// Interval 返回订阅的刷新间隔, 没有单独设置时使用 defaultInterval
+func (r *RSSItem) Interval(defaultInterval time.Duration) time.Duration {
+	if r.IntervalSeconds > 0 {
+		return time.Duration(r.IntervalSeconds) * time.Second
+	}
+	return defaultInterval
+}
+
+// MaxRetryInterval 连续失败的订阅退避后的刷新间隔上限
+const MaxRetryInterval = 24 * time.Hour
+
+// RetryInterval 按连续失败次数退避后的刷新间隔, 每失败一次间隔翻倍, 最长 MaxRetryInterval
+// 没有失败时就是 Interval; 本来的间隔已经超过上限时不再延长
+func (r *RSSItem) RetryInterval(defaultInterval time.Duration) time.Duration {
+	interval := r.Interval(defaultInterval)
+	for i := 0; i < r.ConsecutiveFailures && interval < MaxRetryInterval; i++ {
+		interval = min(interval*2, MaxRetryInterval)
+	}
+	return interval
+}
+
+// Due 到 now 为止是否应该再次拉取, 从未拉取过的订阅总是需要拉取, 调度器排过期的以 NextRunAt 为准
+func (r *RSSItem) Due(now time.Time, defaultInterval time.Duration) bool {
+	if r.NextRunAt != nil {
+		return !now.Before(*r.NextRunAt)
+	}
+	if r.LastFetchedAt == nil {
+		return true
+	}
+	return !now.Before(r.LastFetchedAt.Add(r.Interval(defaultInterval)))
 }
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Season 番剧的一季, 同一部番剧的多季挂在同一个 Bangumi 下, 不需要为每一季创建番剧
+// Number 与 EpisodeMetadata.Season、Episode.Season 一致, 0 为特别篇; EpisodeMetadata 和 Torrent 通过 SeasonID 引用它
+type Season struct {
+	ID           int    `gorm:"primaryKey;autoIncrement" json:"id"`
+	BangumiID    int    `gorm:"uniqueIndex:idx_season;not null;comment:'所属番剧 ID'" json:"bangumi_id"`
+	Number       int    `gorm:"uniqueIndex:idx_season;comment:'季度'" json:"number"`
+	TmdbSeasonID int    `gorm:"default:0;comment:'TMDB 季度 ID'" json:"tmdb_season_id"`
+	EpisodeCount int    `gorm:"default:0;comment:'总集数'" json:"episode_count"`
+	AirDate      string `gorm:"default:'';comment:'首播日期'" json:"air_date"`
+	EndDate      string `gorm:"default:'';comment:'完结日期'" json:"end_date"`
+	// Offset 这一季的集数偏移, 为 0 时使用番剧的 Offset
+	Offset    int       `gorm:"default:0;comment:'集数偏移'" json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// Setting 运行时可以修改的配置项, 以键值对保存在数据库中
+// 数据库中没有的键使用配置文件中的值, 见 settings 包
+type Setting struct {
+	Key       string    `gorm:"primaryKey;size:64;column:key" json:"key"`
+	Value     string    `gorm:"column:value" json:"value"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
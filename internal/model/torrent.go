@@ -9,10 +9,22 @@ import (
 type DownloadStatus int
 
 const (
-	DownloadNone    DownloadStatus = 0 // 未下载
-	DownloadSending DownloadStatus = 1 // 已发送到下载器
-	DownloadDone    DownloadStatus = 2 // 下载完成
-	DownloadError   DownloadStatus = 4 // 异常/手动停止下载
+	DownloadNone     DownloadStatus = 0  // 未下载
+	DownloadSending  DownloadStatus = 1  // 已发送到下载器
+	DownloadDone     DownloadStatus = 2  // 下载完成
+	DownloadError    DownloadStatus = 4  // 异常/手动停止下载
+	DownloadReplaced DownloadStatus = 8  // 已被同一集的修正版或更好的版本替代
+	DownloadHeld     DownloadStatus = 16 // 在挑选窗口中等待同一集的其他版本, 见 refresh.Refresher.ReleaseHeld
+)
+
+// MediaType 种子内容类型
+type MediaType string
+
+const (
+	MediaVideo    MediaType = "video"    // 视频剧集
+	MediaSubtitle MediaType = "subtitle" // 字幕包
+	MediaArchive  MediaType = "archive"  // 压缩包/字体包
+	MediaOther    MediaType = "other"    // 其他, 如评论、图片等
 )
 
 // Torrent 种子信息模型
@@ -22,11 +34,33 @@ type Torrent struct {
 	DownloadUID string    `gorm:"index;column:download_uid" json:"download_uid"`
 	Name        string    `gorm:"default:'';column:name" json:"name"`
 	CreatedAt   time.Time `gorm:"autoCreateTime;index;column:created_at" json:"created_at"`
-	Downloaded  DownloadStatus `gorm:"default:0;column:downloaded" json:"downloaded"`
-	Renamed     bool      `gorm:"default:false;column:renamed" json:"renamed"`
+	// Downloaded, Renamed 组成复合索引, 查找已下载未重命名的种子时使用
+	Downloaded  DownloadStatus `gorm:"default:0;index:idx_torrent_status,priority:1;column:downloaded" json:"downloaded"`
+	Renamed     bool      `gorm:"default:false;index:idx_torrent_status,priority:2;column:renamed" json:"renamed"`
 	// torrent 属于一个 bangumi
 	BangumiID int    `gorm:"index;column:bangumi_id" json:"bangumi_id"`
+	// 种子属于番剧的哪一季, 见 Season; 0 表示还不确定
+	SeasonID int    `gorm:"default:0;index;column:season_id" json:"season_id"`
 	Homepage  string `gorm:"column:homepage" json:"homepage"`
+	// 种子大小(字节)和发布时间, 来自 RSS, 没有时为零值
+	Size    int64     `gorm:"default:0;column:size" json:"size"`
+	PubDate time.Time `gorm:"column:pub_date" json:"pub_date"`
+	// Seeders RSS 中的做种人数, 只有 Nyaa 提供, 没有时为 nil; 只用于过滤, 不入库
+	Seeders *int `gorm:"-" json:"seeders,omitempty"`
+	// 种子的 info hash, 40 位小写十六进制, 无法从 RSS 得到时为空
+	// 同一个种子在不同镜像站的链接不同, info hash 相同
+	InfoHash string `gorm:"default:'';index;column:info_hash" json:"info_hash"`
+	// Batch 合集种子, 覆盖 EpisodeStart 到 EpisodeEnd 集(没有加番剧的偏移); 下载后由重命名按文件记录每一集
+	Batch        bool `gorm:"default:false;column:batch" json:"batch"`
+	EpisodeStart int  `gorm:"default:0;column:episode_start" json:"episode_start,omitempty"`
+	EpisodeEnd   int  `gorm:"default:0;column:episode_end" json:"episode_end,omitempty"`
+	// Version 字幕组重新发布的版本, v2/修正版/REPACK 为 2, 见 EpisodeMetadata.Version; 合集等不区分版本的为 1
+	Version int `gorm:"default:1;column:version" json:"version"`
+	// RSS 条目原始的 guid 和描述
+	GUID        string `gorm:"default:'';column:guid" json:"guid"`
+	Description string `gorm:"default:'';column:description" json:"description"`
+	// 添加种子时使用的下载器名称, 状态查询和删除要回到同一个下载器
+	Downloader string `gorm:"default:'';column:downloader" json:"downloader"`
 
 	// GORM 关联对象（用于预加载）
 	Bangumi *Bangumi `gorm:"foreignKey:BangumiID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
@@ -0,0 +1,272 @@
+package network
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"goto-bangumi/internal/apperrors"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/utils"
+)
+
+// 订阅来源
+const (
+	SourceMikan      = "mikan"
+	SourceNyaa       = "nyaa"
+	SourceDMHY       = "dmhy"
+	SourceACGRip     = "acgrip"
+	SourceBangumiMoe = "bangumimoe"
+	SourceTorznab    = "torznab"
+)
+
+// FeedAdapter 把订阅的原始内容转换成种子列表
+// 不同站点的 item 结构不同, 适配器负责把链接、详情页、大小和发布时间规整到 model.Torrent
+type FeedAdapter interface {
+	Parse(data []byte) ([]*model.Torrent, error)
+}
+
+var (
+	feedAdaptersMu sync.RWMutex
+	feedAdapters   = map[string]FeedAdapter{
+		SourceMikan:      MikanFeedAdapter{},
+		SourceNyaa:       TrackerFeedAdapter{},
+		SourceDMHY:       TrackerFeedAdapter{},
+		SourceACGRip:     TrackerFeedAdapter{},
+		SourceBangumiMoe: TrackerFeedAdapter{},
+		SourceTorznab:    TorznabFeedAdapter{},
+	}
+	// 域名到来源的映射, 匹配域名本身和子域名
+	feedHosts = map[string]string{
+		"mikanani.me": SourceMikan,
+		"mikanime.tv": SourceMikan,
+		"nyaa.si":     SourceNyaa,
+		"dmhy.org":    SourceDMHY,
+		"acg.rip":     SourceACGRip,
+		"bangumi.moe": SourceBangumiMoe,
+	}
+)
+
+// RegisterFeedAdapter 注册订阅来源的适配器, 同名会覆盖
+func RegisterFeedAdapter(source string, adapter FeedAdapter) {
+	feedAdaptersMu.Lock()
+	defer feedAdaptersMu.Unlock()
+	feedAdapters[source] = adapter
+}
+
+// RegisterFeedHost 把域名(及其子域名)识别为 source, 用于镜像站等自定义域名, 同名会覆盖
+func RegisterFeedHost(host, source string) {
+	feedAdaptersMu.Lock()
+	defer feedAdaptersMu.Unlock()
+	feedHosts[strings.ToLower(host)] = source
+}
+
+// DetectSource 根据订阅链接的域名识别来源, Torznab 接口按参数识别, 无法识别时按 Mikan 处理
+func DetectSource(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return SourceMikan
+	}
+	if isTorznabURL(u) {
+		return SourceTorznab
+	}
+	host := strings.ToLower(u.Hostname())
+	feedAdaptersMu.RLock()
+	defer feedAdaptersMu.RUnlock()
+	for {
+		if source, ok := feedHosts[host]; ok {
+			return source
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found || !strings.Contains(parent, ".") {
+			return SourceMikan
+		}
+		host = parent
+	}
+}
+
+// GetFeedAdapter 获取订阅的适配器, source 为空时根据链接识别
+func GetFeedAdapter(source, feedURL string) FeedAdapter {
+	if source == "" {
+		source = DetectSource(feedURL)
+	}
+	feedAdaptersMu.RLock()
+	defer feedAdaptersMu.RUnlock()
+	if adapter, ok := feedAdapters[source]; ok {
+		return adapter
+	}
+	slog.Warn("[Network] 未知的订阅来源, 使用 Mikan 适配器", "source", source, "URL", RedactURL(feedURL))
+	return feedAdapters[SourceMikan]
+}
+
+// parseRSS 解析 RSS XML
+func parseRSS(data []byte) (*model.RSSXml, error) {
+	var rss model.RSSXml
+	if err := xml.Unmarshal(data, &rss); err != nil {
+		return nil, &apperrors.ParseError{Err: fmt.Errorf("failed to parse RSS XML: %w", err)}
+	}
+	return &rss, nil
+}
+
+// setPubDate 解析发布时间, 失败时保留零值
+func setPubDate(torrent *model.Torrent, pubDate string) {
+	if pubDate == "" {
+		return
+	}
+	if t, err := parsePubDate(pubDate); err == nil {
+		torrent.PubDate = t
+	} else {
+		slog.Debug("[Network] 解析发布时间失败", "pubDate", pubDate, "error", err)
+	}
+}
+
+// setEntry 记录 RSS 条目原始的 guid、描述和 info hash, infoHash 为空时从种子链接中解析
+func setEntry(torrent *model.Torrent, item model.RSSTorrent, infoHash string) {
+	torrent.GUID = strings.TrimSpace(item.GUID)
+	torrent.Description = strings.TrimSpace(item.Description)
+	torrent.InfoHash = normalizeInfoHash(infoHash)
+	if torrent.InfoHash == "" {
+		torrent.InfoHash = infoHashFromLink(torrent.Link)
+	}
+}
+
+// infoHashFromLink 从磁力链接的 xt 参数, 或者以 info hash 命名的种子链接(Mikan)中取出 info hash, 没有时为空
+func infoHashFromLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	if u.Scheme == "magnet" {
+		for _, xt := range u.Query()["xt"] {
+			if hash, ok := strings.CutPrefix(strings.ToLower(xt), "urn:btih:"); ok {
+				return normalizeInfoHash(xt[len(xt)-len(hash):])
+			}
+		}
+		return ""
+	}
+	return normalizeInfoHash(strings.TrimSuffix(path.Base(u.Path), ".torrent"))
+}
+
+// normalizeInfoHash 把十六进制或 base32 编码的 v1 info hash 统一成小写十六进制, 格式不对时为空
+func normalizeInfoHash(hash string) string {
+	hash = strings.TrimSpace(hash)
+	switch len(hash) {
+	case 40:
+		if _, err := hex.DecodeString(hash); err == nil {
+			return strings.ToLower(hash)
+		}
+	case 32:
+		if raw, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash)); err == nil {
+			return hex.EncodeToString(raw)
+		}
+	}
+	return ""
+}
+
+// MikanFeedAdapter Mikan 的订阅, enclosure 为种子链接, link 为剧集页面
+type MikanFeedAdapter struct{}
+
+func (MikanFeedAdapter) Parse(data []byte) ([]*model.Torrent, error) {
+	rss, err := parseRSS(data)
+	if err != nil {
+		return nil, err
+	}
+	torrents := make([]*model.Torrent, 0, len(rss.Torrents))
+	for _, item := range rss.Torrents {
+		// 移除名称中的换行符和多余空格
+		torrent := &model.Torrent{Name: utils.ProcessTitle(item.Name)}
+		if item.Enclosure.URL != "" {
+			torrent.Link = item.Enclosure.URL
+			torrent.Homepage = item.Link
+		} else {
+			torrent.Link = item.Link
+		}
+		// 大小和发布时间优先使用 mikan 的扩展字段
+		torrent.Size = item.Torrent.ContentLength
+		if torrent.Size == 0 {
+			torrent.Size = item.Enclosure.Length
+		}
+		pubDate := item.Torrent.PubDate
+		if pubDate == "" {
+			pubDate = item.PubDate
+		}
+		setPubDate(torrent, pubDate)
+		setEntry(torrent, item, "")
+		torrents = append(torrents, torrent)
+	}
+	return torrents, nil
+}
+
+// TrackerFeedAdapter Nyaa/DMHY/ACG.RIP/萌番组 这类 BT 站的订阅
+// Nyaa 的 link 为种子链接, 大小在 <nyaa:size>, 做种人数在 <nyaa:seeders>; DMHY 的 enclosure 为磁力链接, length 没有意义;
+// ACG.RIP 和萌番组的 enclosure 为种子链接, link 为详情页
+// 它们的 link/guid 都不是 Mikan 页面, 所以不设置 Homepage, 避免后续按 Mikan 页面解析
+type TrackerFeedAdapter struct{}
+
+func (TrackerFeedAdapter) Parse(data []byte) ([]*model.Torrent, error) {
+	rss, err := parseRSS(data)
+	if err != nil {
+		return nil, err
+	}
+	torrents := make([]*model.Torrent, 0, len(rss.Torrents))
+	for _, item := range rss.Torrents {
+		torrent := &model.Torrent{Name: utils.ProcessTitle(item.Name)}
+		switch {
+		case item.Enclosure.URL != "":
+			torrent.Link = item.Enclosure.URL
+		case item.Link != "":
+			torrent.Link = item.Link
+		case item.InfoHash != "":
+			torrent.Link = "magnet:?xt=urn:btih:" + item.InfoHash
+		default:
+			slog.Debug("[Network] 订阅条目没有种子链接, 跳过", "name", torrent.Name)
+			continue
+		}
+		torrent.Size = parseHumanSize(item.Size)
+		torrent.Seeders = item.Seeders
+		// DMHY 的 length 固定为 1
+		if torrent.Size == 0 && item.Enclosure.Length > 1 {
+			torrent.Size = item.Enclosure.Length
+		}
+		setPubDate(torrent, item.PubDate)
+		setEntry(torrent, item, item.InfoHash)
+		torrents = append(torrents, torrent)
+	}
+	return torrents, nil
+}
+
+var sizeUnits = map[string]float64{
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// parseHumanSize 解析 1.4 GiB 这样的大小, 无法解析时返回 0
+func parseHumanSize(s string) int64 {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	unit, ok := sizeUnits[strings.ToUpper(fields[1])]
+	if !ok {
+		return 0
+	}
+	return int64(value * unit)
+}
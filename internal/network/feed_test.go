@@ -0,0 +1,307 @@
+package network
+
+import (
+	"context"
+	_ "embed"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+//go:embed testdata/nyaa.xml
+var nyaaXML []byte
+
+//go:embed testdata/dmhy.xml
+var dmhyXML []byte
+
+//go:embed testdata/acgrip.xml
+var acgripXML []byte
+
+//go:embed testdata/bangumimoe.xml
+var bangumimoeXML []byte
+
+func TestDetectSource(t *testing.T) {
+	RegisterFeedHost("nyaa.example.net", SourceNyaa)
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583", SourceMikan},
+		{"https://nyaa.si/?page=rss&q=Make+Heroine", SourceNyaa},
+		{"https://sukebei.nyaa.si/?page=rss", SourceNyaa},
+		{"https://share.dmhy.org/topics/rss/rss.xml?keyword=败犬", SourceDMHY},
+		{"https://acg.rip/.xml?term=LoliHouse", SourceACGRip},
+		{"https://bangumi.moe/rss/tags/548ee0ea4ab7379536f56358", SourceBangumiMoe},
+		{"https://rss.nyaa.example.net/?page=rss", SourceNyaa},
+		{"http://prowlarr:9696/1/api?t=search&apikey=KEY&cat=5070", SourceTorznab},
+		{"https://example.com/rss.xml", SourceMikan},
+		{"://bad url", SourceMikan},
+	}
+	for _, tt := range tests {
+		if got := DetectSource(tt.url); got != tt.want {
+			t.Errorf("DetectSource(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestGetFeedTorrents(t *testing.T) {
+	nyaaURL := "https://nyaa.si/?page=rss&q=Make+Heroine"
+	dmhyURL := "https://share.dmhy.org/topics/rss/rss.xml?keyword=Make+Heroine"
+	// 自定义域名的订阅, 需要显式指定来源
+	mirrorURL := "https://dmhy.example.com/rss.xml"
+	acgripURL := "https://acg.rip/.xml?term=Make+Heroine"
+	bangumimoeURL := "https://bangumi.moe/rss/search/Make%20Heroine"
+	SetTestCache(acgripURL, acgripXML)
+	SetTestCache(bangumimoeURL, bangumimoeXML)
+	SetTestCache(nyaaURL, nyaaXML)
+	SetTestCache(dmhyURL, dmhyXML)
+	SetTestCache(mirrorURL, dmhyXML)
+
+	tests := []struct {
+		name   string
+		url    string
+		source string
+		want   []model.Torrent
+	}{
+		{
+			name: "Nyaa",
+			url:  nyaaURL,
+			want: []model.Torrent{
+				{
+					Name:     "[SubsPlease] Make Heroine ga Oosugiru! - 12 (1080p) [6A1F52B5].mkv",
+					Link:     "https://nyaa.si/download/1874915.torrent",
+					InfoHash: "8e7ef1a3c4d59bde1f5e2d3c2a1b0f9e8d7c6b5a",
+					Size:     1503238553,
+					Seeders:  intPtr(512),
+					PubDate:  time.Date(2024, 9, 28, 16, 32, 5, 0, time.UTC),
+				},
+				{
+					Name:     "[SubsPlease] Make Heroine ga Oosugiru! - 11 (1080p) [0C9D3E21].mkv",
+					Link:     "https://nyaa.si/download/1871520.torrent",
+					InfoHash: "1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e",
+					Size:     int64(712.5 * (1 << 20)),
+					Seeders:  intPtr(301),
+					PubDate:  time.Date(2024, 9, 21, 16, 31, 48, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name: "DMHY",
+			url:  dmhyURL,
+			want: []model.Torrent{
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "magnet:?xt=urn:btih:QNVXIMLTNBWGS5DFOJXW4ZLSONSXI2LOM5SXG4TF&dn=%5BLoliHouse%5D%20Make%20Heroine%20ga%20Oosugiru%21%20-%2012&tr=http%3A%2F%2Ft.nyaatracker.com%2Fannounce",
+					PubDate: time.Date(2024, 9, 28, 17, 32, 17, 0, time.UTC),
+				},
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "magnet:?xt=urn:btih:GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBV&dn=%5BLoliHouse%5D%20Make%20Heroine%20ga%20Oosugiru%21%20-%2011",
+					PubDate: time.Date(2024, 9, 21, 17, 30, 2, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name: "ACG.RIP",
+			url:  acgripURL,
+			want: []model.Torrent{
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "https://acg.rip/t/312345.torrent",
+					PubDate: time.Date(2024, 9, 28, 17, 32, 17, 0, time.UTC),
+				},
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "https://acg.rip/t/311020.torrent",
+					PubDate: time.Date(2024, 9, 21, 17, 30, 2, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name: "萌番组",
+			url:  bangumimoeURL,
+			want: []model.Torrent{
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "https://bangumi.moe/download/torrent/66f84d315f8d1a0007a1b2c3/Make_Heroine_ga_Oosugiru_12.torrent",
+					Size:    1503238553,
+					PubDate: time.Date(2024, 9, 28, 17, 32, 17, 0, time.UTC),
+				},
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "https://bangumi.moe/download/torrent/66ef12a95f8d1a0007a0f1e2/Make_Heroine_ga_Oosugiru_11.torrent",
+					Size:    747110400,
+					PubDate: time.Date(2024, 9, 21, 17, 30, 2, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name:   "显式指定来源",
+			url:    mirrorURL,
+			source: SourceDMHY,
+			want: []model.Torrent{
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "magnet:?xt=urn:btih:QNVXIMLTNBWGS5DFOJXW4ZLSONSXI2LOM5SXG4TF&dn=%5BLoliHouse%5D%20Make%20Heroine%20ga%20Oosugiru%21%20-%2012&tr=http%3A%2F%2Ft.nyaatracker.com%2Fannounce",
+					PubDate: time.Date(2024, 9, 28, 17, 32, 17, 0, time.UTC),
+				},
+				{
+					Name:    "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+					Link:    "magnet:?xt=urn:btih:GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBV&dn=%5BLoliHouse%5D%20Make%20Heroine%20ga%20Oosugiru%21%20-%2011",
+					PubDate: time.Date(2024, 9, 21, 17, 30, 2, 0, time.UTC),
+				},
+			},
+		},
+	}
+
+	client := GetRequestClient()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			torrents, err := client.GetFeedTorrents(context.Background(), tt.url, tt.source)
+			if err != nil {
+				t.Fatalf("GetFeedTorrents() error = %v", err)
+			}
+			if len(torrents) != len(tt.want) {
+				t.Fatalf("got %d torrents, want %d", len(torrents), len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := torrents[i]
+				if got.Name != want.Name {
+					t.Errorf("[%d] Name = %q, want %q", i, got.Name, want.Name)
+				}
+				if got.Link != want.Link {
+					t.Errorf("[%d] Link = %q, want %q", i, got.Link, want.Link)
+				}
+				// 非 Mikan 的详情页不能作为 Homepage, 否则会被当作 Mikan 页面解析
+				if got.Homepage != "" {
+					t.Errorf("[%d] Homepage = %q, want empty", i, got.Homepage)
+				}
+				if got.Size != want.Size {
+					t.Errorf("[%d] Size = %d, want %d", i, got.Size, want.Size)
+				}
+				if (got.Seeders == nil) != (want.Seeders == nil) || got.Seeders != nil && *got.Seeders != *want.Seeders {
+					t.Errorf("[%d] Seeders = %v, want %v", i, got.Seeders, want.Seeders)
+				}
+				if got.InfoHash != want.InfoHash {
+					t.Errorf("[%d] InfoHash = %q, want %q", i, got.InfoHash, want.InfoHash)
+				}
+				if got.GUID == "" || got.Description == "" {
+					t.Errorf("[%d] GUID = %q, Description = %q, want raw entry", i, got.GUID, got.Description)
+				}
+				if !got.PubDate.Equal(want.PubDate) {
+					t.Errorf("[%d] PubDate = %v, want %v", i, got.PubDate, want.PubDate)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHumanSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"1.4 GiB", 1503238553},
+		{"712.5 MiB", int64(712.5 * (1 << 20))},
+		{"350 MB", 350 * 1000 * 1000},
+		{"", 0},
+		{"unknown", 0},
+		{"1.2 XB", 0},
+	}
+	for _, tt := range tests {
+		if got := parseHumanSize(tt.in); got != tt.want {
+			t.Errorf("parseHumanSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInfoHashFromLink(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://mikanani.me/Download/20240929/33fbab8f53fe4bad12f07afa5abdb7c4afa5956c.torrent", "33fbab8f53fe4bad12f07afa5abdb7c4afa5956c"},
+		{"magnet:?xt=urn:btih:8E7EF1A3C4D59BDE1F5E2D3C2A1B0F9E8D7C6B5A&dn=test", "8e7ef1a3c4d59bde1f5e2d3c2a1b0f9e8d7c6b5a"},
+		{"magnet:?xt=urn:btih:RZ7PDI6E2WN54H26FU6CUGYPT2GXY222", "8e7ef1a3c4d59bde1f5e2d3c2a1b0f9e8d7c6b5a"},
+		// 测试数据中 DMHY 的磁力链接不是有效的 info hash
+		{"magnet:?xt=urn:btih:GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBV", ""},
+		{"https://nyaa.si/download/1874915.torrent", ""},
+		{"magnet:?xt=urn:btmh:1220abcd", ""},
+	}
+	for _, tt := range tests {
+		if got := infoHashFromLink(tt.link); got != tt.want {
+			t.Errorf("infoHashFromLink(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+const torznabXML = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+<channel>
+<title>Prowlarr</title>
+<item>
+	<title>[LoliHouse] Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC]</title>
+	<guid>https://nyaa.si/view/1874916</guid>
+	<comments>https://nyaa.si/view/1874916</comments>
+	<pubDate>Sat, 28 Sep 2024 16:40:00 +0000</pubDate>
+	<size>734003200</size>
+	<link>http://prowlarr:9696/1/download?apikey=KEY&amp;link=abc</link>
+	<enclosure url="http://prowlarr:9696/1/download?apikey=KEY&amp;link=abc" length="734003200" type="application/x-bittorrent" />
+	<torznab:attr name="seeders" value="87" />
+	<torznab:attr name="infohash" value="8E7EF1A3C4D59BDE1F5E2D3C2A1B0F9E8D7C6B5B" />
+</item>
+<item>
+	<title>[SubsPlease] Make Heroine ga Oosugiru! - 11 (1080p)</title>
+	<pubDate>Sat, 21 Sep 2024 16:31:48 +0000</pubDate>
+	<size>1503238553</size>
+	<torznab:attr name="magneturl" value="magnet:?xt=urn:btih:1111111111111111111111111111111111111111&amp;dn=test" />
+</item>
+</channel>
+</rss>`
+
+func TestTorznabFeedAdapter(t *testing.T) {
+	torrents, err := TorznabFeedAdapter{}.Parse([]byte(torznabXML))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []model.Torrent{
+		{
+			Name:     "[LoliHouse] Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC]",
+			Link:     "http://prowlarr:9696/1/download?apikey=&link=abc",
+			InfoHash: "8e7ef1a3c4d59bde1f5e2d3c2a1b0f9e8d7c6b5b",
+			Size:     734003200,
+			Seeders:  intPtr(87),
+			PubDate:  time.Date(2024, 9, 28, 16, 40, 0, 0, time.UTC),
+		},
+		{
+			Name:     "[SubsPlease] Make Heroine ga Oosugiru! - 11 (1080p)",
+			Link:     "magnet:?xt=urn:btih:1111111111111111111111111111111111111111&dn=test",
+			InfoHash: "1111111111111111111111111111111111111111",
+			Size:     1503238553,
+			PubDate:  time.Date(2024, 9, 21, 16, 31, 48, 0, time.UTC),
+		},
+	}
+	if len(torrents) != len(want) {
+		t.Fatalf("Parse() returned %d torrents, want %d", len(torrents), len(want))
+	}
+	for i, w := range want {
+		got := torrents[i]
+		if got.Name != w.Name || got.Link != w.Link || got.InfoHash != w.InfoHash || got.Size != w.Size || !got.PubDate.Equal(w.PubDate) {
+			t.Errorf("torrent[%d] = %+v, want %+v", i, got, w)
+		}
+		if (got.Seeders == nil) != (w.Seeders == nil) || got.Seeders != nil && *got.Seeders != *w.Seeders {
+			t.Errorf("torrent[%d] seeders = %v, want %v", i, got.Seeders, w.Seeders)
+		}
+		if got.Homepage != "" {
+			t.Errorf("torrent[%d] homepage = %q, want empty", i, got.Homepage)
+		}
+	}
+
+	// API Key 错误时返回 <error>
+	if _, err := (TorznabFeedAdapter{}).Parse([]byte(`<?xml version="1.0" encoding="UTF-8"?><error code="100" description="Invalid API Key" />`)); err == nil {
+		t.Error("Parse() of torznab error = nil, want error")
+	}
+}
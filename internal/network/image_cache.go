@@ -2,12 +2,20 @@ package network
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+
+	"goto-bangumi/internal/apperrors"
 )
 
 //TODO: 要防止一些恶意的链接
@@ -16,6 +24,16 @@ var (
 	posterDir = filepath.Join(dataDir, "posters")
 )
 
+// MaxImageSize 图片的最大大小, 超过时放弃下载
+const MaxImageSize = 10 << 20
+
+var (
+	// ErrNotImage 响应内容不是图片
+	ErrNotImage = errors.New("response is not an image")
+	// ErrImageTooLarge 图片超过 MaxImageSize
+	ErrImageTooLarge = errors.New("image too large")
+)
+
 // urlToBase64 converts URL to base64 encoded string for filename (reversible)
 func urlToBase64(url string) string {
 	return base64.URLEncoding.EncodeToString([]byte(url))
@@ -42,14 +60,10 @@ func SaveImage(ctx context.Context, url string) ([]byte, error) {
 	imagePath := filepath.Join(posterDir, imgEncoded)
 
 	// Download image
-	imgData, err := defaultClient.Get(ctx, url)
+	imgData, err := GetRequestClient().GetImage(ctx, url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download image: %w", err)
 	}
-	// TODO:检测是不是图片以及是不是有效数据
-	if len(imgData) == 0 {
-		return nil, fmt.Errorf("downloaded image is empty")
-	}
 
 	// Save to file
 	if err := os.WriteFile(imagePath, imgData, 0o644); err != nil {
@@ -96,3 +110,90 @@ func LoadImage(ctx context.Context, imgPath string) ([]byte, error) {
 
 	return imgData, nil
 }
+
+// GetImage 下载图片, 不经过响应缓存
+// 内容不是图片时返回 ErrNotImage, 超过 MaxImageSize 时返回 ErrImageTooLarge
+func (r *RequestClient) GetImage(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	resp, err := r.client.R().
+		SetContext(ctx).
+		SetHeader("Accept", "image/*").
+		SetDoNotParseResponse(true).
+		Get(url)
+	if err != nil {
+		return nil, &apperrors.NetworkError{Err: fmt.Errorf("GET request failed: %w", err), StatusCode: 0}
+	}
+	body := resp.RawBody()
+	defer body.Close()
+
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return nil, &apperrors.NetworkError{
+			Err:        fmt.Errorf("HTTP %d: %s", resp.StatusCode(), resp.Status()),
+			StatusCode: resp.StatusCode(),
+		}
+	}
+	if resp.RawResponse.ContentLength > MaxImageSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrImageTooLarge, resp.RawResponse.ContentLength)
+	}
+	// 服务器可能没有给出 Content-Length, 多读一个字节判断是否超出
+	data, err := io.ReadAll(io.LimitReader(body, MaxImageSize+1))
+	if err != nil {
+		return nil, &apperrors.NetworkError{Err: fmt.Errorf("read image failed: %w", err), StatusCode: resp.StatusCode()}
+	}
+	if len(data) > MaxImageSize {
+		return nil, ErrImageTooLarge
+	}
+	// 以内容为准, 部分 CDN 返回的 Content-Type 不可靠
+	if len(data) == 0 || !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return nil, ErrNotImage
+	}
+	return data, nil
+}
+
+// posterFileName 由图片链接的哈希生成文件名, 保留链接中的扩展名
+func posterFileName(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:])
+	ext := strings.ToLower(path.Ext(strings.SplitN(url, "?", 2)[0]))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".webp", ".gif":
+		name += ext
+	}
+	return name
+}
+
+// CacheImage 下载图片保存到 dir 下, 返回本地路径
+// 文件名由链接的哈希决定, 同一个链接已经缓存过时直接返回, 链接变化后会重新下载
+func CacheImage(ctx context.Context, url, dir string) (string, error) {
+	imagePath := filepath.Join(dir, posterFileName(url))
+	if info, err := os.Stat(imagePath); err == nil && info.Size() > 0 {
+		return imagePath, nil
+	}
+
+	imgData, err := GetRequestClient().GetImage(ctx, url)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create image dir: %w", err)
+	}
+	// 先写临时文件再改名, 避免中断时留下不完整的图片被当作缓存
+	tmp, err := os.CreateTemp(dir, ".poster-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to save image: %w", err)
+	}
+	if _, err := tmp.Write(imgData); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to save image: %w", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), imagePath); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to save image: %w", err)
+	}
+
+	slog.Info("[ImageCache] Cached image", "url", url, "path", imagePath)
+	return imagePath, nil
+}
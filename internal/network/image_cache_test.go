@@ -0,0 +1,79 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// pngHeader 足够让 http.DetectContentType 识别为 image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestGetImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/poster.png":
+			w.Write(pngHeader)
+		case "/page.html":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("<html><body>not found</body></html>"))
+		case "/huge.png":
+			w.Write(pngHeader)
+			w.Write(bytes.Repeat([]byte{0}, MaxImageSize))
+		}
+	}))
+	defer server.Close()
+
+	client := newRequestClient()
+	data, err := client.GetImage(context.Background(), server.URL+"/poster.png")
+	if err != nil || !bytes.Equal(data, pngHeader) {
+		t.Errorf("GetImage(poster.png) = %d bytes, %v", len(data), err)
+	}
+	// Content-Type 不可靠, 以内容为准
+	if _, err := client.GetImage(context.Background(), server.URL+"/page.html"); !errors.Is(err, ErrNotImage) {
+		t.Errorf("GetImage(page.html) error = %v, want ErrNotImage", err)
+	}
+	if _, err := client.GetImage(context.Background(), server.URL+"/huge.png"); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("GetImage(huge.png) error = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestCacheImage(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write(pngHeader)
+	}))
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "posters")
+	first, err := CacheImage(context.Background(), server.URL+"/a.png?v=1", dir)
+	if err != nil {
+		t.Fatalf("CacheImage() error = %v", err)
+	}
+	if filepath.Dir(first) != dir || filepath.Ext(first) != ".png" {
+		t.Errorf("CacheImage() = %s, want a .png under %s", first, dir)
+	}
+	if data, err := os.ReadFile(first); err != nil || !bytes.Equal(data, pngHeader) {
+		t.Errorf("cached file = %d bytes, %v", len(data), err)
+	}
+
+	again, err := CacheImage(context.Background(), server.URL+"/a.png?v=1", dir)
+	if err != nil || again != first {
+		t.Errorf("CacheImage() again = %s, %v, want %s", again, err, first)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("server hits = %d, want 1", got)
+	}
+
+	changed, err := CacheImage(context.Background(), server.URL+"/a.png?v=2", dir)
+	if err != nil || changed == first {
+		t.Errorf("CacheImage() with new URL = %s, %v, want a new file", changed, err)
+	}
+}
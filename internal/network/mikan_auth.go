@@ -0,0 +1,70 @@
+package network
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// DefaultMikanHost Mikan 的默认域名
+const DefaultMikanHost = "mikanani.me"
+
+// MikanAuth Mikan 个人订阅(MyBangumi)的认证信息
+// 只会附加到 Mikan 域名(及其子域名)的请求上, 不会发给 TMDB 等其他站点
+type MikanAuth struct {
+	Host   string // Mikan 域名, 为空时为 mikanani.me, 可以带协议
+	Token  string // RSS 的 token 参数, 请求里已经带了 token 时不覆盖
+	Cookie string // 原样放进 Cookie 请求头
+}
+
+// ClientOption RequestClient 的可选配置
+type ClientOption func(*RequestClient)
+
+// WithMikanAuth 为 Mikan 的请求附加 token 和 cookie
+func WithMikanAuth(auth MikanAuth) ClientOption {
+	return func(r *RequestClient) {
+		if auth.Token == "" && auth.Cookie == "" {
+			return
+		}
+		host := normalizeHost(auth.Host)
+		if host == "" {
+			host = DefaultMikanHost
+		}
+		r.client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+			u, err := url.Parse(req.URL)
+			if err != nil || !matchHost(u.Hostname(), host) {
+				return nil
+			}
+			if auth.Token != "" && u.Query().Get("token") == "" {
+				req.SetQueryParam("token", auth.Token)
+			}
+			if auth.Cookie != "" {
+				req.SetHeader("Cookie", auth.Cookie)
+			}
+			return nil
+		})
+	}
+}
+
+// normalizeHost 去掉配置里的协议、路径和端口, 只保留域名
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return ""
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// matchHost 判断请求域名是否是 host 或它的子域名
+func matchHost(hostname, host string) bool {
+	hostname = strings.ToLower(hostname)
+	return hostname == host || strings.HasSuffix(hostname, "."+host)
+}
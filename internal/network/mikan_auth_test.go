@@ -0,0 +1,84 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWithMikanAuth(t *testing.T) {
+	type seen struct {
+		token  string
+		cookie string
+	}
+	requests := make(chan seen, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- seen{token: r.URL.Query().Get("token"), cookie: r.Header.Get("Cookie")}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// 测试服务器同时用 127.0.0.1 和 localhost 访问, 只把 127.0.0.1 当作 Mikan
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mikanURL := "http://127.0.0.1:" + u.Port()
+	otherURL := "http://localhost:" + u.Port()
+
+	client := newRequestClient(WithMikanAuth(MikanAuth{
+		Host:   "http://127.0.0.1/",
+		Token:  "secret-token",
+		Cookie: ".AspNetCore.Identity.Application=secret",
+	}))
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		url        string
+		wantToken  string
+		wantCookie string
+	}{
+		{"mikan", mikanURL + "/RSS/MyBangumi", "secret-token", ".AspNetCore.Identity.Application=secret"},
+		{"mikan 已有 token", mikanURL + "/RSS/MyBangumi?token=own", "own", ".AspNetCore.Identity.Application=secret"},
+		{"其他域名", otherURL + "/3/search/tv?query=mikan", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.Get(ctx, tt.url); err != nil {
+				t.Fatalf("Get(%s) failed: %v", tt.url, err)
+			}
+			got := <-requests
+			if got.token != tt.wantToken {
+				t.Errorf("token = %q, want %q", got.token, tt.wantToken)
+			}
+			if got.cookie != tt.wantCookie {
+				t.Errorf("Cookie = %q, want %q", got.cookie, tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		hostname string
+		host     string
+		want     bool
+	}{
+		{"mikanani.me", normalizeHost("mikanani.me"), true},
+		{"www.mikanani.me", normalizeHost("https://mikanani.me"), true},
+		{"MIKANANI.ME", normalizeHost("mikanani.me"), true},
+		{"api.themoviedb.org", normalizeHost("mikanani.me"), false},
+		{"notmikanani.me", normalizeHost("mikanani.me"), false},
+	}
+	for _, tt := range tests {
+		if got := matchHost(tt.hostname, tt.host); got != tt.want {
+			t.Errorf("matchHost(%q, %q) = %v, want %v", tt.hostname, tt.host, got, tt.want)
+		}
+	}
+	if got := normalizeHost(" https://Mikanani.me:443/RSS "); got != "mikanani.me" {
+		t.Errorf("normalizeHost() = %q, want mikanani.me", got)
+	}
+}
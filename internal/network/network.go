@@ -15,7 +15,8 @@ const (
 	DefaultRetryDelay   = 5 * time.Second
 	DefaultCacheTTL     = 60 * time.Second
 	DefaultMaxCacheSize = 1000
-	DefaultUserAgent    = "Mozilla/5.0"
+	// DefaultUserAgent 部分站点会限制 Go 默认的 User-Agent, 使用浏览器的值
+	DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36"
 )
 
 // HTTPClient provides basic HTTP operations
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/time/rate"
+)
+
+// hostLimiter 按域名分别限速的令牌桶, 同一个 RequestClient 的所有请求共享
+// 刷新订阅、Mikan 页面和 TMDB 查询都通过全局的 RequestClient, 因此对同一个站点的总请求频率受限
+type hostLimiter struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+}
+
+func newHostLimiter(rps float64, burst int) *hostLimiter {
+	return &hostLimiter{
+		limit:    rate.Limit(rps),
+		burst:    max(burst, 1),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// get 返回域名对应的令牌桶, 不存在时创建
+func (h *hostLimiter) get(host string) *rate.Limiter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l, ok := h.limiters[host]
+	if !ok {
+		l = rate.NewLimiter(h.limit, h.burst)
+		h.limiters[host] = l
+	}
+	return l
+}
+
+// WithRateLimit 限制对每个域名每秒的请求数 rps, burst 为允许的突发请求数(最少 1)
+// 重试同样消耗令牌; 命中缓存的请求不发出, 不受限制; rps 不大于 0 时不限速
+func WithRateLimit(rps float64, burst int) ClientOption {
+	return func(r *RequestClient) {
+		if rps <= 0 {
+			return
+		}
+		limiter := newHostLimiter(rps, burst)
+		r.client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+			u, err := url.Parse(req.URL)
+			if err != nil || u.Hostname() == "" {
+				return nil
+			}
+			return limiter.get(strings.ToLower(u.Hostname())).Wait(req.Context())
+		})
+	}
+}
@@ -3,17 +3,16 @@ package network
 import (
 	"context"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"goto-bangumi/internal/apperrors"
 	"goto-bangumi/internal/model"
-	"goto-bangumi/internal/utils"
 
 	"github.com/go-resty/resty/v2"
 	"golang.org/x/sync/singleflight"
@@ -25,6 +24,10 @@ var (
 	globalCache        CacheManager
 	requestGroup       singleflight.Group
 	defaultClient      *RequestClient
+	// defaultOptions Init 传入的可选配置, 修改代理重建 defaultClient 时继续使用
+	defaultOptions []ClientOption
+	// clientMu 保护 defaultProxyConfig、defaultClient 和 defaultOptions, 运行时可以修改代理
+	clientMu sync.RWMutex
 )
 
 func init() {
@@ -35,28 +38,48 @@ func init() {
 	defaultClient = newRequestClient()
 }
 
-// Init 初始化 network 包的代理配置
-func Init(config *model.ProxyConfig) {
+// Init 初始化 network 包的代理配置, opts 用于附加 Mikan 认证等可选配置
+func Init(config *model.ProxyConfig, opts ...ClientOption) {
 	if config != nil {
+		clientMu.Lock()
+		defer clientMu.Unlock()
 		defaultProxyConfig = config
+		defaultOptions = opts
 		slog.Info("[Network] Network package initialized", "proxy_enabled", config.Enable)
-		defaultClient = newRequestClient()
+		defaultClient = newRequestClient(opts...)
 	}
 }
 
+// SetProxyConfig 运行时修改代理, 用新的代理重建全局的 RequestClient, Init 传入的其他配置不变
+// 已经拿到旧实例的请求继续使用旧的代理
+func SetProxyConfig(config *model.ProxyConfig) {
+	if config == nil {
+		config = &model.ProxyConfig{}
+	}
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	defaultProxyConfig = config
+	defaultClient = newRequestClient(defaultOptions...)
+	slog.Info("[Network] 代理已修改", "proxy_enabled", config.Enable)
+}
+
 // GetRequestClient 返回全局共享的 RequestClient 实例
 // 所有 HTTP 请求应使用此实例以共享连接池和缓存
 func GetRequestClient() *RequestClient {
+	clientMu.RLock()
+	defer clientMu.RUnlock()
 	return defaultClient
 }
 
 // RequestClient provides HTTP request functionality with retry and proxy support using resty
 type RequestClient struct {
 	client *resty.Client
+	// timeout 单次请求(包括重试)的总超时时间
+	timeout time.Duration
 }
 
 // NewRequestClient creates a new RequestURL instance with resty
-func newRequestClient() *RequestClient {
+func newRequestClient(opts ...ClientOption) *RequestClient {
 	// 如果没有init config，则使用包级的 defaultProxyConfig
 	proxyConfig := defaultProxyConfig
 
@@ -69,6 +92,7 @@ func newRequestClient() *RequestClient {
 	}
 
 	client := resty.New()
+	client.SetLogger(restyLogger{})
 
 	// 设置自定义 transport
 	client.SetTransport(transport)
@@ -100,39 +124,75 @@ func newRequestClient() *RequestClient {
 	// Add retry condition: retry on 5xx errors and network errors
 	client.AddRetryCondition(func(r *resty.Response, err error) bool {
 		if err != nil {
-			slog.Warn("[Network] Retrying due to error", "error", err)
+			slog.Warn("[Network] Retrying due to error", "error", redactError(err))
 			return true // Retry on network errors
 		}
 		// Retry on 5xx server errors
 		if r.StatusCode() >= 500 {
 			slog.Warn("[Network] Retrying due to server error",
-				"url", r.Request.URL,
+				"url", RedactURL(r.Request.URL),
 				"status", r.StatusCode())
 			return true
 		}
 		return false
 	})
 
-	return &RequestClient{
-		client: client,
+	r := &RequestClient{
+		client:  client,
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithTimeout 设置请求超时, 同时限制单次连接和包括重试在内的总时间, 不大于 0 时忽略
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(r *RequestClient) {
+		if timeout <= 0 {
+			return
+		}
+		r.timeout = timeout
+		r.client.SetTimeout(timeout)
+	}
+}
+
+// WithUserAgent 设置所有请求的 User-Agent, 为空时使用 DefaultUserAgent
+func WithUserAgent(userAgent string) ClientOption {
+	return func(r *RequestClient) {
+		if userAgent == "" {
+			return
+		}
+		r.client.SetHeader("User-Agent", userAgent)
+	}
+}
+
+// withTimeout 为请求加上总超时, ctx 自身的截止时间更早时以 ctx 为准
+func (r *RequestClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, r.timeout)
 }
 
 // Get performs HTTP GET request with cache support and request deduplication
 func (r *RequestClient) Get(ctx context.Context, url string) ([]byte, error) {
 	// 1. 快速路径：检查缓存
 	if data, found := globalCache.Get(url); found {
-		slog.Debug("[Network] Cache hit", "url", url)
+		slog.Debug("[Network] Cache hit", "url", RedactURL(url))
 		return data, nil
 	}
 
 	// 2. 使用 singleflight 防止并发重复请求
 	v, err, shared := requestGroup.Do(url, func() (any, error) {
 		// 2.2 执行实际 HTTP 请求
-		slog.Debug("[Network] Executing HTTP request", "url", url)
+		slog.Debug("[Network] Executing HTTP request", "url", RedactURL(url))
+		ctx, cancel := r.withTimeout(ctx)
+		defer cancel()
 		resp, err := r.client.R().SetContext(ctx).Get(url)
 		if err != nil {
-			return nil, &apperrors.NetworkError{Err: fmt.Errorf("GET request failed: %w", err), StatusCode: 0}
+			return nil, &apperrors.NetworkError{Err: fmt.Errorf("GET request failed: %w", redactError(err)), StatusCode: 0}
 		}
 
 		if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
@@ -146,7 +206,7 @@ func (r *RequestClient) Get(ctx context.Context, url string) ([]byte, error) {
 
 		// 2.3 写入缓存
 		globalCache.Set(url, body, DefaultCacheTTL)
-		slog.Debug("[Network] Cached response", "url", url, "size", len(body))
+		slog.Debug("[Network] Cached response", "url", RedactURL(url), "size", len(body))
 
 		return body, nil
 	})
@@ -156,14 +216,67 @@ func (r *RequestClient) Get(ctx context.Context, url string) ([]byte, error) {
 	}
 
 	if shared {
-		slog.Debug("[Network] Request shared via singleflight", "url", url)
+		slog.Debug("[Network] Request shared via singleflight", "url", RedactURL(url))
 	}
 
 	return v.([]byte), nil
 }
 
+// ConditionalResponse 条件请求的结果
+type ConditionalResponse struct {
+	Body []byte
+	// 服务器返回的校验信息, 下次请求时原样带上
+	ETag         string
+	LastModified string
+	// NotModified 服务器返回 304, 此时 Body 为空, ETag 和 LastModified 为请求时带上的值
+	NotModified bool
+}
+
+// GetConditional 带上 If-None-Match/If-Modified-Since 的 GET 请求, 为空的校验信息不发送
+// 命中缓存时直接返回缓存的内容, 成功的响应同样会写入缓存, 之后的 Get 可以直接使用
+func (r *RequestClient) GetConditional(ctx context.Context, url, etag, lastModified string) (*ConditionalResponse, error) {
+	if data, found := globalCache.Get(url); found {
+		slog.Debug("[Network] Cache hit", "url", RedactURL(url))
+		return &ConditionalResponse{Body: data, ETag: etag, LastModified: lastModified}, nil
+	}
+
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	req := r.client.R().SetContext(ctx)
+	if etag != "" {
+		req.SetHeader("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.SetHeader("If-Modified-Since", lastModified)
+	}
+	resp, err := req.Get(url)
+	if err != nil {
+		return nil, &apperrors.NetworkError{Err: fmt.Errorf("GET request failed: %w", redactError(err)), StatusCode: 0}
+	}
+	if resp.StatusCode() == http.StatusNotModified {
+		slog.Debug("[Network] Not modified", "url", RedactURL(url))
+		return &ConditionalResponse{ETag: etag, LastModified: lastModified, NotModified: true}, nil
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return nil, &apperrors.NetworkError{
+			Err:        fmt.Errorf("HTTP %d: %s", resp.StatusCode(), resp.Status()),
+			StatusCode: resp.StatusCode(),
+		}
+	}
+
+	body := resp.Body()
+	globalCache.Set(url, body, DefaultCacheTTL)
+	return &ConditionalResponse{
+		Body:         body,
+		ETag:         resp.Header().Get("ETag"),
+		LastModified: resp.Header().Get("Last-Modified"),
+	}, nil
+}
+
 // Post performs HTTP POST request
 func (r *RequestClient) Post(ctx context.Context, url string, contentType string, body io.Reader) ([]byte, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	resp, err := r.client.R().
 		SetContext(ctx).
 		SetHeader("Content-Type", contentType).
@@ -239,44 +352,37 @@ func (r *RequestClient) GetRSS(ctx context.Context, url string) (*model.RSSXml,
 	if err != nil {
 		return nil, err
 	}
-	// Parse XML
-	var rss model.RSSXml
-	if err := xml.Unmarshal(resp, &rss); err != nil {
-		return nil, &apperrors.ParseError{Err: fmt.Errorf("failed to parse RSS XML: %w", err)}
-	}
-	return &rss, nil
+	return parseRSS(resp)
 }
 
 // GetTorrents fetches and parses RSS feed to extract torrents
 // 返回错误主是是区分是网络请求错误还是确实没有种子
 func (r *RequestClient) GetTorrents(ctx context.Context, url string) ([]*model.Torrent, error) {
-	rss, err := r.GetRSS(ctx, url)
+	return r.GetFeedTorrents(ctx, url, "")
+}
+
+// GetFeedTorrents 使用指定来源的适配器解析订阅, source 为空时根据链接识别
+func (r *RequestClient) GetFeedTorrents(ctx context.Context, url string, source string) ([]*model.Torrent, error) {
+	resp, err := r.Get(ctx, url)
 	if err != nil {
 		return nil, err
 	}
+	return GetFeedAdapter(source, url).Parse(resp)
+}
 
-	torrents := make([]*model.Torrent, 0, len(rss.Torrents))
-	for _, item := range rss.Torrents {
-		// 移除名称中的换行符和多余空格
-		item.Name = utils.ProcessTitle(item.Name)
-		// 创建 Torrent 对象
-		torrent := &model.Torrent{
-			Name:     item.Name,
-			Homepage: item.Enclosure.URL,
-			Link:      url,
-		}
+// mikanLocation mikan 的发布时间不带时区, 为北京时间
+var mikanLocation = time.FixedZone("CST", 8*60*60)
 
-		if item.Enclosure.URL != "" {
-			torrent.Link = item.Enclosure.URL
-			torrent.Homepage = item.Link
-		} else {
-			torrent.Link = item.Link
+// parsePubDate 解析 RSS 的发布时间
+// 标准 RSS 使用 RFC1123, mikan 的扩展字段为不带时区的 ISO8601
+func parsePubDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
 		}
-
-		torrents = append(torrents, torrent)
 	}
-
-	return torrents, nil
+	return time.ParseInLocation("2006-01-02T15:04:05.999999999", s, mikanLocation)
 }
 
 // GetRSSTitle fetches RSS feed and returns the channel title
@@ -291,6 +397,8 @@ func (r *RequestClient) GetRSSTitle(ctx context.Context, url string) (string, er
 
 // PostData sends form data and files via POST request
 func (r *RequestClient) PostData(ctx context.Context, url string, data map[string]string, files map[string][]byte) ([]byte, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	req := r.client.R().SetContext(ctx)
 
 	// Set form data
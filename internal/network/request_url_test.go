@@ -3,8 +3,12 @@ package network
 import (
 	"context"
 	_ "embed"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed testdata/rss_3391_583.xml
@@ -114,6 +118,15 @@ func TestGetTorrents(t *testing.T) {
 		if firstTorrent.Homepage != expectedHomepage {
 			t.Errorf("First torrent Homepage = %q, want %q", firstTorrent.Homepage, expectedHomepage)
 		}
+
+		// 验证大小和发布时间
+		if firstTorrent.Size != 368889024 {
+			t.Errorf("First torrent Size = %d, want %d", firstTorrent.Size, 368889024)
+		}
+		expectedPubDate := time.Date(2024, 9, 29, 1, 1, 3, 776281000, time.FixedZone("CST", 8*60*60))
+		if !firstTorrent.PubDate.Equal(expectedPubDate) {
+			t.Errorf("First torrent PubDate = %v, want %v", firstTorrent.PubDate, expectedPubDate)
+		}
 	}
 
 	// 验证最后一个种子
@@ -131,3 +144,154 @@ func TestGetTorrents(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePubDate(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  time.Time
+	}{
+		{"mikan", "2024-11-04T23:58:52.737", time.Date(2024, 11, 4, 23, 58, 52, 737000000, time.FixedZone("CST", 8*60*60))},
+		{"mikan without fraction", "2024-08-09T12:00:00", time.Date(2024, 8, 9, 12, 0, 0, 0, time.FixedZone("CST", 8*60*60))},
+		{"rfc1123z", "Mon, 04 Nov 2024 15:58:52 +0000", time.Date(2024, 11, 4, 15, 58, 52, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePubDate(tt.input)
+			if err != nil {
+				t.Fatalf("parsePubDate(%q) error = %v", tt.input, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parsePubDate(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+	if _, err := parsePubDate("not a date"); err == nil {
+		t.Error("parsePubDate() 应该对非法日期返回错误")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newRequestClient(WithTimeout(200 * time.Millisecond))
+	start := time.Now()
+	_, err := client.Get(context.Background(), server.URL+"/slow")
+	if err == nil {
+		t.Fatal("Get() error = nil, want timeout")
+	}
+	// 包括重试在内的总时间也受超时限制
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Get() took %v, want about 200ms", elapsed)
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	agents := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		client *RequestClient
+		want   string
+	}{
+		{"默认", newRequestClient(), DefaultUserAgent},
+		{"自定义", newRequestClient(WithUserAgent("goto-bangumi/test")), "goto-bangumi/test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 每个请求使用不同的 URL, 避免命中缓存
+			if _, err := tt.client.Get(context.Background(), server.URL+"/ua/"+tt.name); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got := <-agents; got != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetConditional(t *testing.T) {
+	const etag = `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Mon, 04 Nov 2024 15:58:52 GMT")
+		w.Write([]byte("<rss/>"))
+	}))
+	defer server.Close()
+
+	client := newRequestClient()
+	url := server.URL + "/rss"
+	defer ClearTestCache(url)
+	resp, err := client.GetConditional(context.Background(), url, "", "")
+	if err != nil {
+		t.Fatalf("GetConditional() error = %v", err)
+	}
+	if resp.NotModified || string(resp.Body) != "<rss/>" || resp.ETag != etag || resp.LastModified == "" {
+		t.Fatalf("first response = %+v, want body with validators", resp)
+	}
+
+	// 缓存过期后带上 ETag 重新请求, 服务器返回 304
+	ClearTestCache(url)
+	resp, err = client.GetConditional(context.Background(), url, etag, "")
+	if err != nil {
+		t.Fatalf("GetConditional() error = %v", err)
+	}
+	if !resp.NotModified || len(resp.Body) != 0 || resp.ETag != etag {
+		t.Errorf("second response = %+v, want not modified", resp)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer other.Close()
+
+	// 每秒 5 个请求, 突发 2 个: 第 3 个请求需要等待约 200ms
+	client := newRequestClient(WithRateLimit(5, 2))
+	ctx := context.Background()
+	start := time.Now()
+	for i := range 3 {
+		url := server.URL + "/limit/" + string(rune('a'+i))
+		defer ClearTestCache(url)
+		if _, err := client.Get(ctx, url); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3 requests took %v, want at least 150ms", elapsed)
+	}
+
+	// httptest 的服务器都在 127.0.0.1 上, 换成 localhost 作为另一个域名, 不受前面的令牌桶影响
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1) + "/limit"
+	defer ClearTestCache(otherURL)
+	start = time.Now()
+	if _, err := client.Get(ctx, otherURL); err != nil {
+		t.Fatalf("Get() other host error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("other host took %v, want no wait", elapsed)
+	}
+
+}
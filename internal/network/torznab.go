@@ -0,0 +1,189 @@
+package network
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-resty/resty/v2"
+
+	"goto-bangumi/internal/apperrors"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/utils"
+)
+
+// apiKeyParams Jackett/Prowlarr 链接中 API Key 的参数名, Jackett 的种子下载链接用 jackett_apikey
+var apiKeyParams = []string{"apikey", "jackett_apikey"}
+
+// TorznabAuth Jackett/Prowlarr 的认证信息
+// API Key 只在请求时填进索引器域名的链接, 订阅、搜索和保存的种子链接中都不带 API Key
+type TorznabAuth struct {
+	URL    string // 索引器的 Torznab 地址, 只使用其中的域名
+	APIKey string
+}
+
+// torznabHost 配置了 API Key 的索引器域名, 用于识别不带 API Key 的 Torznab 接口
+var torznabHost atomic.Value
+
+// WithTorznabAuth 为索引器的请求填上 API Key
+// Torznab 接口(带 t 参数)没有 apikey 时加上, 种子链接中被 StripAPIKey 清空的参数填回
+func WithTorznabAuth(auth TorznabAuth) ClientOption {
+	return func(r *RequestClient) {
+		host := normalizeHost(auth.URL)
+		if host == "" || auth.APIKey == "" {
+			return
+		}
+		torznabHost.Store(host)
+		r.client.OnBeforeRequest(func(_ *resty.Client, req *resty.Request) error {
+			u, err := url.Parse(req.URL)
+			if err != nil || !matchHost(u.Hostname(), host) {
+				return nil
+			}
+			q := u.Query()
+			filled := false
+			for _, name := range apiKeyParams {
+				if values, ok := q[name]; ok && (len(values) == 0 || values[0] == "") {
+					q.Set(name, auth.APIKey)
+					filled = true
+				}
+			}
+			if !filled && q.Get("t") != "" && q.Get("apikey") == "" {
+				q.Set("apikey", auth.APIKey)
+				filled = true
+			}
+			if filled {
+				u.RawQuery = q.Encode()
+				req.URL = u.String()
+			}
+			return nil
+		})
+	}
+}
+
+// isTorznabURL Jackett/Prowlarr 的 Torznab 接口: 带 t(search/tvsearch 等)参数, 并且带 apikey 或者是配置的索引器域名
+func isTorznabURL(u *url.URL) bool {
+	q := u.Query()
+	if q.Get("t") == "" {
+		return false
+	}
+	if q.Get("apikey") != "" {
+		return true
+	}
+	host, _ := torznabHost.Load().(string)
+	return host != "" && matchHost(u.Hostname(), host)
+}
+
+// StripAPIKey 清空链接中的 API Key, 保留参数名, 请求时由 WithTorznabAuth 填回; 其他链接原样返回
+func StripAPIKey(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return link
+	}
+	q := u.Query()
+	replaced := false
+	for _, name := range apiKeyParams {
+		if q.Get(name) != "" {
+			q.Set(name, "")
+			replaced = true
+		}
+	}
+	if !replaced {
+		return link
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// apiKeyRe 链接或日志文本中的 API Key 参数
+var apiKeyRe = regexp.MustCompile(`(?i)([?&](?:jackett_)?apikey=)[^&#\s"]+`)
+
+// RedactURL 隐藏链接中的 API Key, 用于日志和错误信息
+func RedactURL(link string) string {
+	return apiKeyRe.ReplaceAllString(link, "${1}***")
+}
+
+// redactError 隐藏请求错误(*url.Error)中链接的 API Key
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+	return err
+}
+
+// restyLogger 把 resty 的日志转给 slog, 并隐藏其中的 API Key
+type restyLogger struct{}
+
+func (restyLogger) Errorf(format string, v ...any) { slog.Error(restyMessage(format, v...)) }
+func (restyLogger) Warnf(format string, v ...any)  { slog.Warn(restyMessage(format, v...)) }
+func (restyLogger) Debugf(format string, v ...any) { slog.Debug(restyMessage(format, v...)) }
+
+func restyMessage(format string, v ...any) string {
+	msg := strings.TrimSpace(fmt.Sprintf(format, v...))
+	return "[Network] " + RedactURL(msg)
+}
+
+// torznabError Torznab 出错时返回的 <error code="100" description="Invalid API Key"/>, HTTP 状态码仍可能为 200
+type torznabError struct {
+	XMLName     xml.Name
+	Code        string `xml:"code,attr"`
+	Description string `xml:"description,attr"`
+}
+
+// TorznabFeedAdapter Jackett/Prowlarr 的 Torznab 结果, 格式同 RSS
+// link/enclosure 为种子链接(可能经过 Jackett/Prowlarr 代理, 其中的 API Key 会被清空, 见 StripAPIKey), 没有时用 magneturl;
+// 大小在 <size> 中(字节), 做种人数和 info hash 在 <torznab:attr> 中; comments 为详情页, 不是 Mikan 页面, 不设置 Homepage
+type TorznabFeedAdapter struct{}
+
+func (TorznabFeedAdapter) Parse(data []byte) ([]*model.Torrent, error) {
+	var root torznabError
+	if err := xml.Unmarshal(data, &root); err == nil && root.XMLName.Local == "error" {
+		return nil, &apperrors.ParseError{Err: fmt.Errorf("torznab error %s: %s", root.Code, root.Description)}
+	}
+	rss, err := parseRSS(data)
+	if err != nil {
+		return nil, err
+	}
+	torrents := make([]*model.Torrent, 0, len(rss.Torrents))
+	for _, item := range rss.Torrents {
+		attrs := make(map[string]string, len(item.Attrs))
+		for _, attr := range item.Attrs {
+			attrs[strings.ToLower(attr.Name)] = attr.Value
+		}
+		torrent := &model.Torrent{Name: utils.ProcessTitle(item.Name)}
+		switch {
+		case item.Enclosure.URL != "":
+			torrent.Link = item.Enclosure.URL
+		case item.Link != "":
+			torrent.Link = item.Link
+		case attrs["magneturl"] != "":
+			torrent.Link = attrs["magneturl"]
+		default:
+			slog.Debug("[Network] 订阅条目没有种子链接, 跳过", "name", torrent.Name)
+			continue
+		}
+		torrent.Link = StripAPIKey(torrent.Link)
+		torrent.Size, _ = strconv.ParseInt(strings.TrimSpace(item.Size), 10, 64)
+		if torrent.Size == 0 {
+			torrent.Size = item.Enclosure.Length
+		}
+		if seeders, err := strconv.Atoi(attrs["seeders"]); err == nil {
+			torrent.Seeders = &seeders
+		}
+		setPubDate(torrent, item.PubDate)
+		infoHash := attrs["infohash"]
+		if infoHash == "" {
+			infoHash = infoHashFromLink(attrs["magneturl"])
+		}
+		setEntry(torrent, item, infoHash)
+		torrent.GUID = StripAPIKey(torrent.GUID)
+		torrents = append(torrents, torrent)
+	}
+	return torrents, nil
+}
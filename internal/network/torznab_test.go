@@ -0,0 +1,80 @@
+package network
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestWithTorznabAuth(t *testing.T) {
+	requests := make(chan url.Values, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.Query()
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// 测试服务器同时用 127.0.0.1 和 localhost 访问, 只把 127.0.0.1 当作索引器
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexerURL := "http://127.0.0.1:" + u.Port()
+	otherURL := "http://localhost:" + u.Port()
+
+	client := newRequestClient(WithTorznabAuth(TorznabAuth{URL: indexerURL + "/1/api", APIKey: "secret-key"}))
+	t.Cleanup(func() { torznabHost.Store("") })
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		url   string
+		param string
+		want  string
+	}{
+		{"搜索接口", indexerURL + "/1/api?t=search&q=Make+Heroine", "apikey", "secret-key"},
+		{"Prowlarr 种子链接", StripAPIKey(indexerURL + "/1/download?apikey=secret-key&link=abc"), "apikey", "secret-key"},
+		{"Jackett 种子链接", StripAPIKey(indexerURL + "/dl/nyaa/?jackett_apikey=secret-key&path=abc"), "jackett_apikey", "secret-key"},
+		{"其他域名", otherURL + "/1/api?t=search&q=Make+Heroine", "apikey", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.Get(ctx, tt.url); err != nil {
+				t.Fatalf("Get(%s) failed: %v", tt.url, err)
+			}
+			got := <-requests
+			if got.Get(tt.param) != tt.want {
+				t.Errorf("%s = %q, want %q", tt.param, got.Get(tt.param), tt.want)
+			}
+			if len(got[tt.param]) > 1 {
+				t.Errorf("%s sent %d times", tt.param, len(got[tt.param]))
+			}
+		})
+	}
+
+	if got := DetectSource(indexerURL + "/1/api?t=search&cat=5070"); got != SourceTorznab {
+		t.Errorf("DetectSource() of keyless torznab url = %q, want torznab", got)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"http://prowlarr:9696/1/api?apikey=KEY&t=search", "http://prowlarr:9696/1/api?apikey=***&t=search"},
+		{"http://jackett:9117/dl/nyaa/?jackett_apikey=KEY&path=abc", "http://jackett:9117/dl/nyaa/?jackett_apikey=***&path=abc"},
+		{"https://mikanani.me/RSS/Bangumi?bangumiId=3391", "https://mikanani.me/RSS/Bangumi?bangumiId=3391"},
+		{"magnet:?xt=urn:btih:1111111111111111111111111111111111111111", "magnet:?xt=urn:btih:1111111111111111111111111111111111111111"},
+	}
+	for _, tt := range tests {
+		if got := RedactURL(tt.link); got != tt.want {
+			t.Errorf("RedactURL(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+	if got, want := restyMessage("Get %q: timeout", "http://prowlarr:9696/1/api?t=search&apikey=KEY"), `[Network] Get "http://prowlarr:9696/1/api?t=search&apikey=***": timeout`; got != want {
+		t.Errorf("restyMessage() = %q, want %q", got, want)
+	}
+}
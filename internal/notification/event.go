@@ -0,0 +1,70 @@
+package notification
+
+import (
+	"context"
+	"time"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+// EventType is the kind of lifecycle event sent to event notifiers.
+type EventType string
+
+const (
+	EventBangumiDiscovered EventType = "bangumi_discovered"
+	EventEpisodeEnqueued   EventType = "episode_enqueued"
+	EventDownloadCompleted EventType = "download_completed"
+	EventRenameDone        EventType = "rename_done"
+	EventFailure           EventType = "failure"
+	EventFeedFailure       EventType = "feed_failure"
+	EventEpisodeMissing    EventType = "episode_missing"
+)
+
+// NotifyEvent describes something that happened in the refresh/download flow.
+type NotifyEvent struct {
+	Type         EventType `json:"type"`
+	BangumiTitle string    `json:"bangumi_title"`
+	Season       int       `json:"season,omitempty"`
+	Episode      int       `json:"episode,omitempty"`
+	TorrentName  string    `json:"torrent_name,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	FeedName     string    `json:"feed_name,omitempty"`
+	FeedLink     string    `json:"feed_link,omitempty"`
+	Failures     int       `json:"failures,omitempty"` // consecutive feed failures, or search attempts for a missing episode
+	Time         time.Time `json:"time"`
+}
+
+// EventNotifier receives lifecycle events, e.g. a webhook.
+type EventNotifier interface {
+	Notify(ctx context.Context, event NotifyEvent) error
+}
+
+// NewTorrentEvent builds an event for a torrent, parsing the episode from its name.
+func NewTorrentEvent(eventType EventType, torrent *model.Torrent, bangumi *model.Bangumi) NotifyEvent {
+	event := NotifyEvent{Type: eventType, Time: time.Now()}
+	if torrent != nil {
+		event.TorrentName = torrent.Name
+		event.Episode = parser.NewTitleMetaParse().Parse(torrent.Name).Episode
+	}
+	if bangumi != nil {
+		event.BangumiTitle = bangumi.OfficialTitle
+		event.Season = bangumi.Season
+	}
+	return event
+}
+
+// NewFeedFailureEvent builds an event for an RSS feed that failed several times in a row.
+func NewFeedFailureEvent(rss *model.RSSItem, err error) NotifyEvent {
+	event := NotifyEvent{
+		Type:     EventFeedFailure,
+		FeedName: rss.Name,
+		FeedLink: rss.Link,
+		Failures: rss.ConsecutiveFailures,
+		Time:     time.Now(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
@@ -7,9 +7,11 @@ import (
 	"goto-bangumi/internal/model"
 )
 
-// Client wraps a single Notifier selected by configuration.
+// Client wraps a single Notifier selected by configuration,
+// plus optional event notifiers (e.g. webhook).
 type Client struct {
 	notifier Notifier
+	events   []EventNotifier
 }
 
 // NotificationClient is the global notification client.
@@ -33,6 +35,40 @@ func (c *Client) Init(config *model.NotificationConfig) {
 	default:
 		slog.Warn("[Notification] Unknown notification type", "type", config.Type)
 	}
+
+	if config.WebhookURL != "" {
+		webhook, err := NewWebhookNotifier(config.WebhookURL)
+		if err != nil {
+			slog.Error("[Notification] Failed to init webhook", "error", err)
+			return
+		}
+		c.events = append(c.events, webhook)
+	}
+}
+
+// AddEventNotifier registers an additional event notifier.
+func (c *Client) AddEventNotifier(n EventNotifier) {
+	c.events = append(c.events, n)
+}
+
+// Notify sends a lifecycle event to all event notifiers.
+// It is a no-op when none is configured. Errors are logged but not returned.
+func (c *Client) Notify(ctx context.Context, event NotifyEvent) {
+	for _, n := range c.events {
+		if err := n.Notify(ctx, event); err != nil {
+			slog.Error("[Notification] Notify failed", "event", event.Type, "error", err)
+		}
+	}
+}
+
+// Close stops event notifiers that deliver in the background (e.g. webhook),
+// waiting for their queued events to be sent.
+func (c *Client) Close() {
+	for _, n := range c.events {
+		if closer, ok := n.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
 }
 
 // Send sends a notification message. Errors are logged but not returned.
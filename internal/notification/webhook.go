@@ -0,0 +1,100 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"goto-bangumi/internal/network"
+)
+
+const (
+	// webhookQueueSize is how many events may wait for delivery before new ones are dropped.
+	webhookQueueSize = 64
+	// webhookTimeout bounds a single POST so a slow endpoint cannot stall the queue.
+	webhookTimeout = 10 * time.Second
+)
+
+// WebhookNotifier posts events as JSON to a configured URL.
+// Events are queued and delivered by a background worker, so callers
+// on the download pipeline never wait on the webhook endpoint.
+type WebhookNotifier struct {
+	url     string
+	timeout time.Duration
+	queue   chan NotifyEvent
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewWebhookNotifier creates a webhook notifier for the given URL and starts its worker.
+func NewWebhookNotifier(url string) (*WebhookNotifier, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook requires url")
+	}
+	w := &WebhookNotifier{
+		url:     url,
+		timeout: webhookTimeout,
+		queue:   make(chan NotifyEvent, webhookQueueSize),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Notify queues the event for delivery. When the queue is full the event is dropped
+// and an error is returned; delivery failures are only logged by the worker.
+func (w *WebhookNotifier) Notify(_ context.Context, event NotifyEvent) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fmt.Errorf("webhook notifier is closed")
+	}
+	select {
+	case w.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full, dropping %s event", event.Type)
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be delivered.
+func (w *WebhookNotifier) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// run delivers queued events one by one until the queue is closed.
+func (w *WebhookNotifier) run() {
+	defer close(w.done)
+	for event := range w.queue {
+		if err := w.post(event); err != nil {
+			slog.Error("[Notification] Webhook delivery failed", "event", event.Type, "error", err)
+		}
+	}
+}
+
+// post sends a single event to the webhook URL, bounded by the notifier timeout.
+func (w *WebhookNotifier) post(event NotifyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	client := network.GetRequestClient()
+	if _, err := client.Post(ctx, w.url, "application/json", bytes.NewReader(body)); err != nil {
+		return fmt.Errorf("failed to post webhook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestWebhookNotifier_Enqueued(t *testing.T) {
+	received := make(chan NotifyEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var event NotifyEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		received <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhookNotifier(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{}
+	client.AddEventNotifier(webhook)
+
+	torrent := &model.Torrent{Name: "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"}
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	client.Notify(context.Background(), NewTorrentEvent(EventEpisodeEnqueued, torrent, bangumi))
+
+	event := <-received
+	if event.Type != EventEpisodeEnqueued {
+		t.Errorf("Type = %q, want %q", event.Type, EventEpisodeEnqueued)
+	}
+	if event.BangumiTitle != bangumi.OfficialTitle {
+		t.Errorf("BangumiTitle = %q, want %q", event.BangumiTitle, bangumi.OfficialTitle)
+	}
+	if event.Episode != 5 {
+		t.Errorf("Episode = %d, want 5", event.Episode)
+	}
+	if event.TorrentName != torrent.Name {
+		t.Errorf("TorrentName = %q, want %q", event.TorrentName, torrent.Name)
+	}
+}
+
+func TestWebhookNotifier_SlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan NotifyEvent, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event NotifyEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+		<-release
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhookNotifier(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook.timeout = 50 * time.Millisecond
+
+	// the endpoint hangs, but Notify must return without waiting on it
+	start := time.Now()
+	for _, eventType := range []EventType{EventDownloadCompleted, EventFailure} {
+		if err := webhook.Notify(context.Background(), NotifyEvent{Type: eventType}); err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("Notify() blocked for %v", elapsed)
+	}
+
+	// the first POST times out and the worker moves on to the next event
+	for _, want := range []EventType{EventDownloadCompleted, EventFailure} {
+		select {
+		case event := <-received:
+			if event.Type != want {
+				t.Errorf("Type = %q, want %q", event.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %q was not delivered", want)
+		}
+	}
+	close(release)
+	webhook.Close()
+	if err := webhook.Notify(context.Background(), NotifyEvent{Type: EventFailure}); err == nil {
+		t.Error("Notify() after Close should return an error")
+	}
+}
+
+func TestClientNotify_Unconfigured(t *testing.T) {
+	// Notify is a no-op without any configured event notifier
+	client := &Client{}
+	client.Notify(context.Background(), NotifyEvent{Type: EventFailure})
+
+	if _, err := NewWebhookNotifier(""); err == nil {
+		t.Error("expected error for empty webhook url")
+	}
+}
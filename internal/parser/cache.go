@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+// DefaultMetadataTTL 元数据缓存的默认有效期, 连载中的番剧集数会变化, 所以不宜太长
+const DefaultMetadataTTL = 24 * time.Hour
+
+// MetadataStore 元数据缓存的持久化, 由 database.DB 实现
+type MetadataStore interface {
+	GetMetadataLookup(ctx context.Context, kind, key string) (*model.MetadataLookup, error)
+	SaveMetadataLookup(ctx context.Context, lookup *model.MetadataLookup) error
+	GetTmdbItemByID(ctx context.Context, tmdbID int) (*model.TmdbItem, error)
+	CreateTmdbItem(ctx context.Context, item *model.TmdbItem) error
+	GetMikanItemByID(ctx context.Context, mikanID int) (*model.MikanItem, error)
+	CreateMikanItem(ctx context.Context, item *model.MikanItem) error
+}
+
+// MetadataCache TMDB/Mikan 查询的读穿缓存
+// 查询条件映射到已保存的 TmdbItem/MikanItem, TTL 内直接读数据库, 过期或没有记录时请求网络并写回
+// nil 的 MetadataCache 不做缓存, 每次都请求网络
+type MetadataCache struct {
+	store MetadataStore
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewMetadataCache 创建元数据缓存, ttl <= 0 时使用 DefaultMetadataTTL
+func NewMetadataCache(store MetadataStore, ttl time.Duration) *MetadataCache {
+	if ttl <= 0 {
+		ttl = DefaultMetadataTTL
+	}
+	return &MetadataCache{store: store, ttl: ttl, now: time.Now}
+}
+
+var metadataCache *MetadataCache
+
+// SetMetadataCache 设置全局的元数据缓存, LookupTMDB/LookupMikan 会使用它
+func SetMetadataCache(cache *MetadataCache) {
+	metadataCache = cache
+}
+
+// LookupTMDB 通过全局缓存查询 TMDB 信息
+func LookupTMDB(ctx context.Context, title, language string) (*model.TmdbItem, error) {
+	return metadataCache.TMDB(ctx, title, language)
+}
+
+// LookupMikan 通过全局缓存查询 Mikan 信息
+func LookupMikan(ctx context.Context, homepage string) (*model.MikanItem, error) {
+	return metadataCache.Mikan(ctx, homepage)
+}
+
+func tmdbLookupKey(title, language string) string {
+	return language + "|" + title
+}
+
+// fresh 查询记录在 TTL 内时返回对应的 ID
+func (c *MetadataCache) fresh(ctx context.Context, kind, key string) (int, bool) {
+	lookup, err := c.store.GetMetadataLookup(ctx, kind, key)
+	if err != nil {
+		return 0, false
+	}
+	if c.now().Sub(lookup.UpdatedAt) > c.ttl {
+		return 0, false
+	}
+	return lookup.ItemID, true
+}
+
+func (c *MetadataCache) remember(ctx context.Context, kind, key string, itemID int) {
+	lookup := &model.MetadataLookup{Key: key, Kind: kind, ItemID: itemID}
+	if err := c.store.SaveMetadataLookup(ctx, lookup); err != nil {
+		slog.Warn("[MetadataCache] 保存查询记录失败", "kind", kind, "key", key, "error", err)
+	}
+}
+
+// TMDB 查询 TMDB 信息, TTL 内的重复查询不会请求网络
+func (c *MetadataCache) TMDB(ctx context.Context, title, language string) (*model.TmdbItem, error) {
+	if c == nil {
+		return NewTMDBParse().TMDBParse(ctx, title, language)
+	}
+	if id, ok := c.fresh(ctx, model.LookupTMDB, tmdbLookupKey(title, language)); ok {
+		if item, err := c.store.GetTmdbItemByID(ctx, id); err == nil {
+			slog.Debug("[MetadataCache] TMDB 命中缓存", "title", title, "id", id)
+			return item, nil
+		}
+	}
+	return c.RefreshTMDB(ctx, title, language)
+}
+
+// RefreshTMDB 忽略缓存重新请求 TMDB, 并更新缓存
+// 用于连载中番剧的集数这类会变化的信息
+func (c *MetadataCache) RefreshTMDB(ctx context.Context, title, language string) (*model.TmdbItem, error) {
+	item, err := NewTMDBParse().TMDBParse(ctx, title, language)
+	if err != nil || c == nil {
+		return item, err
+	}
+	if err := c.store.CreateTmdbItem(ctx, item); err != nil {
+		slog.Warn("[MetadataCache] 保存 TMDB 信息失败", "title", title, "error", err)
+		return item, nil
+	}
+	c.remember(ctx, model.LookupTMDB, tmdbLookupKey(title, language), item.ID)
+	return item, nil
+}
+
+// Mikan 查询 Mikan 页面信息, TTL 内的重复查询不会请求网络
+func (c *MetadataCache) Mikan(ctx context.Context, homepage string) (*model.MikanItem, error) {
+	if c == nil {
+		return NewMikanParser().Parse(ctx, homepage)
+	}
+	if id, ok := c.fresh(ctx, model.LookupMikan, homepage); ok {
+		if item, err := c.store.GetMikanItemByID(ctx, id); err == nil {
+			slog.Debug("[MetadataCache] Mikan 命中缓存", "homepage", homepage, "id", id)
+			return item, nil
+		}
+	}
+	return c.RefreshMikan(ctx, homepage)
+}
+
+// RefreshMikan 忽略缓存重新请求 Mikan 页面, 并更新缓存
+func (c *MetadataCache) RefreshMikan(ctx context.Context, homepage string) (*model.MikanItem, error) {
+	item, err := NewMikanParser().Parse(ctx, homepage)
+	if err != nil || c == nil {
+		return item, err
+	}
+	// 没有解析到 ID 的页面不缓存, 避免不同番剧共用 ID 0
+	if item.ID == 0 {
+		return item, nil
+	}
+	if err := c.store.CreateMikanItem(ctx, item); err != nil {
+		slog.Warn("[MetadataCache] 保存 Mikan 信息失败", "homepage", homepage, "error", err)
+		return item, nil
+	}
+	c.remember(ctx, model.LookupMikan, homepage, item.ID)
+	return item, nil
+}
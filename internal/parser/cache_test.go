@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/network"
+)
+
+func newCacheTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMetadataCache_Mikan(t *testing.T) {
+	ctx := context.Background()
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write(mikan3599HTML)
+	}))
+	defer server.Close()
+	homepage := server.URL + "/Home/Episode/8c2e3e9f7b71419a513d2647f5004f3a0f08a7f0"
+
+	cache := NewMetadataCache(newCacheTestDB(t), time.Hour)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	first, err := cache.Mikan(ctx, homepage)
+	if err != nil {
+		t.Fatalf("Mikan() error = %v", err)
+	}
+	if first.ID != 3599 {
+		t.Fatalf("Mikan() ID = %d, want 3599", first.ID)
+	}
+	// 去掉 network 层的短期缓存, 确认第二次是从数据库读取的
+	network.ClearTestCache(homepage)
+
+	second, err := cache.Mikan(ctx, homepage)
+	if err != nil {
+		t.Fatalf("Mikan() error = %v", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("TTL 内第二次查询请求了网络, hits = %d, want 1", got)
+	}
+	if second.OfficialTitle != first.OfficialTitle {
+		t.Errorf("cached OfficialTitle = %q, want %q", second.OfficialTitle, first.OfficialTitle)
+	}
+
+	// 强制刷新
+	if _, err := cache.RefreshMikan(ctx, homepage); err != nil {
+		t.Fatalf("RefreshMikan() error = %v", err)
+	}
+	network.ClearTestCache(homepage)
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("RefreshMikan 应该请求网络, hits = %d, want 2", got)
+	}
+
+	// 超过 TTL 后重新请求
+	now = now.Add(2 * time.Hour)
+	if _, err := cache.Mikan(ctx, homepage); err != nil {
+		t.Fatalf("Mikan() error = %v", err)
+	}
+	network.ClearTestCache(homepage)
+	if got := hits.Load(); got != 3 {
+		t.Fatalf("TTL 过期后应该请求网络, hits = %d, want 3", got)
+	}
+}
+
+func TestMetadataCache_TMDB(t *testing.T) {
+	ctx := context.Background()
+	const title = "冷门番剧缓存测试"
+	searchURL := SearchURL(title)
+	infoURL := InfoURL(229676, "zh")
+	network.SetTestCache(searchURL, tmdbSearchWolf)
+	network.SetTestCache(infoURL, tmdbInfo229676)
+
+	cache := NewMetadataCache(newCacheTestDB(t), time.Hour)
+	first, err := cache.TMDB(ctx, title, "zh")
+	if err != nil {
+		t.Fatalf("TMDB() error = %v", err)
+	}
+
+	// 没有网络缓存时第二次查询只能来自数据库
+	network.ClearTestCache(searchURL)
+	network.ClearTestCache(infoURL)
+	second, err := cache.TMDB(ctx, title, "zh")
+	if err != nil {
+		t.Fatalf("TMDB() within TTL should not hit the network: %v", err)
+	}
+	if !reflect.DeepEqual(second, first) {
+		t.Errorf("cached item = %+v, want %+v", second, first)
+	}
+
+	// 恢复 TestMain 中的缓存
+	network.SetTestCache(infoURL, tmdbInfo229676)
+}
+
+func TestMetadataCache_Nil(t *testing.T) {
+	// 没有设置缓存时直接请求
+	var cache *MetadataCache
+	item, err := cache.TMDB(context.Background(), "狼与香辛料", "zh")
+	if err != nil {
+		t.Fatalf("TMDB() error = %v", err)
+	}
+	if item.ID != 229676 {
+		t.Errorf("TMDB() ID = %d, want 229676", item.ID)
+	}
+}
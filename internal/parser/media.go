@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser/patterns"
+)
+
+// sizeSuffixRe 种子名末尾的大小信息, 如 [101.69 MB]
+var sizeSuffixRe = regexp.MustCompile(`\s*[\[(]\s*\d+(\.\d+)?\s*[KMGT]i?B\s*[\])]\s*$`)
+
+// DetectMediaType 根据种子名判断内容类型
+// 优先按扩展名判断, 只认识已知的扩展名, 避免把 "Dr.Stone"、"Re.Zero" 这类标题中的点号当作扩展名,
+// 没有已知扩展名时按名称中的提示词判断, 都没有命中时视为视频, 因为绝大多数番剧种子名是不带扩展名的
+func DetectMediaType(name string) model.MediaType {
+	name = sizeSuffixRe.ReplaceAllString(strings.TrimSpace(name), "")
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case slices.Contains(patterns.VideoExtensions, ext), slices.Contains(extraVideoExtensions(), ext):
+		return model.MediaVideo
+	case slices.Contains(patterns.SubtitleExtensions, ext):
+		return model.MediaSubtitle
+	case slices.Contains(patterns.ArchiveExtensions, ext):
+		// 字幕包一般以压缩包形式发布, 这里按名称再细分
+		if ok, _ := patterns.SubtitlePackRe.MatchString(name); ok {
+			return model.MediaSubtitle
+		}
+		return model.MediaArchive
+	case slices.Contains(patterns.OtherExtensions, ext):
+		return model.MediaOther
+	}
+	if ok, _ := patterns.SubtitlePackRe.MatchString(name); ok {
+		return model.MediaSubtitle
+	}
+	if ok, _ := patterns.FontPackRe.MatchString(name); ok {
+		return model.MediaArchive
+	}
+	return model.MediaVideo
+}
+
+// IsAllowedMedia 判断种子的内容类型是否允许入队
+// 未配置 MediaTypes 时只允许视频
+func IsAllowedMedia(name string) bool {
+	mediaType := DetectMediaType(name)
+	if ParserConfig == nil || len(ParserConfig.MediaTypes) == 0 {
+		return mediaType == model.MediaVideo
+	}
+	for _, t := range ParserConfig.MediaTypes {
+		if strings.EqualFold(t, string(mediaType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// extraVideoExtensions 返回配置中额外的视频扩展名, 统一为小写并带上 "."
+func extraVideoExtensions() []string {
+	if ParserConfig == nil {
+		return nil
+	}
+	exts := make([]string, 0, len(ParserConfig.VideoExtensions))
+	for _, ext := range ParserConfig.VideoExtensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestDetectMediaType(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    model.MediaType
+	}{
+		{
+			name:    "普通剧集",
+			content: "[百冬练习组&LoliHouse] BanG Dream! 少女乐团派对！☆PICO FEVER！ / Garupa Pico: Fever! - 26 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕][END] [101.69 MB]",
+			want:    model.MediaVideo,
+		},
+		{
+			name:    "带扩展名的剧集",
+			content: "[Dynamis One] [Oshi no Ko] - 26 (ABEMA 1920x1080 AVC AAC MP4) [8DF340A3].mp4",
+			want:    model.MediaVideo,
+		},
+		{
+			name:    "单个字幕文件",
+			content: "[喵萌奶茶屋] 败犬女主太多了！ - 01 [简日双语].ass",
+			want:    model.MediaSubtitle,
+		},
+		{
+			name:    "字幕包压缩包",
+			content: "[LoliHouse] Make Heroine ga Oosugiru! [01-12][字幕包].7z",
+			want:    model.MediaSubtitle,
+		},
+		{
+			name:    "无扩展名字幕包",
+			content: "[VCB-Studio] Make Heroine ga Oosugiru! [简繁日字幕包] [1.2 MB]",
+			want:    model.MediaSubtitle,
+		},
+		{
+			name:    "字体包",
+			content: "[VCB-Studio] Make Heroine ga Oosugiru! [Fonts]",
+			want:    model.MediaArchive,
+		},
+		{
+			name:    "压缩包",
+			content: "[Nekomoe kissaten] Make Heroine ga Oosugiru! [Scans].zip",
+			want:    model.MediaArchive,
+		},
+		{
+			name:    "标题中带点号",
+			content: "[LoliHouse] Dr.Stone Science Future - 01 [WebRip 1080p HEVC-10bit AAC]",
+			want:    model.MediaVideo,
+		},
+		{
+			name:    "标题末尾带点号",
+			content: "[LoliHouse] Dr.Stone",
+			want:    model.MediaVideo,
+		},
+		{
+			name:    "标题中带点号和空格",
+			content: "[LoliHouse] Re.Zero kara Hajimeru Isekai Seikatsu - 51 [WebRip 1080p HEVC-10bit AAC]",
+			want:    model.MediaVideo,
+		},
+		{
+			name:    "其他文件",
+			content: "[Nekomoe kissaten] Make Heroine ga Oosugiru! comments.txt",
+			want:    model.MediaOther,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectMediaType(tt.content); got != tt.want {
+				t.Errorf("DetectMediaType(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAllowedMedia(t *testing.T) {
+	oldConfig := ParserConfig
+	defer func() { ParserConfig = oldConfig }()
+
+	ParserConfig = &model.RssParserConfig{}
+	if IsAllowedMedia("[LoliHouse] Make Heroine ga Oosugiru! [01-12][字幕包].7z") {
+		t.Error("默认配置下字幕包不应该被允许")
+	}
+	if IsAllowedMedia("[Nekomoe kissaten] Make Heroine ga Oosugiru! comments.txt") {
+		t.Error("默认配置下其他文件不应该被允许")
+	}
+
+	ParserConfig = &model.RssParserConfig{
+		MediaTypes:      []string{"video", "subtitle"},
+		VideoExtensions: []string{"m4v"},
+	}
+	if !IsAllowedMedia("[LoliHouse] Make Heroine ga Oosugiru! [01-12][字幕包].7z") {
+		t.Error("配置允许 subtitle 后字幕包应该被允许")
+	}
+	if !IsAllowedMedia("[LoliHouse] Make Heroine ga Oosugiru! - 01.m4v") {
+		t.Error("配置的额外扩展名应该被当作视频")
+	}
+	if IsAllowedMedia("[VCB-Studio] Make Heroine ga Oosugiru! [Fonts]") {
+		t.Error("字体包不应该被允许")
+	}
+}
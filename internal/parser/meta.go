@@ -212,6 +212,33 @@ func (p *TitleMetaParser) getTrustedEpisode() int {
 	return -1
 }
 
+// getSpecialEpisode 获取特别篇信息, 先看 12.5 这样的半集, 再看 SP/OVA/剧场版
+// 返回剧集类型, 原始标记和集数, 没有编号的特别篇集数为 0
+func (p *TitleMetaParser) getSpecialEpisode() (model.EpisodeType, string, int) {
+	point5Info := p.findallSubTitle(patterns.Point5EpisodeRe, "/[]")
+	if len(point5Info) > 0 {
+		p.episodeTrusted = true
+		episode := p.episodeInfoToEpisode(point5Info[0])
+		return model.EpisodeSpecial, fmt.Sprintf("%d.5", episode), episode
+	}
+
+	specialInfo := p.findallSubTitle(patterns.SpecialEpisodeRe, "/[]")
+	if len(specialInfo) == 0 || len(specialInfo[0]) < 3 {
+		return model.EpisodeRegular, "", 0
+	}
+	p.episodeTrusted = true
+	kind, num, movie := specialInfo[0][0], specialInfo[0][1], specialInfo[0][2]
+	if movie != "" {
+		return model.EpisodeMovie, movie, 0
+	}
+	episode, _ := strconv.Atoi(num)
+	episodeType := model.EpisodeSpecial
+	if !strings.EqualFold(kind, "SP") {
+		episodeType = model.EpisodeOVA
+	}
+	return episodeType, strings.ToUpper(kind) + num, episode
+}
+
 // getUntrustedEpisode 获取不可信的剧集信息
 func (p *TitleMetaParser) getUntrustedEpisode() int {
 	episodeInfo := p.findallSubTitle(patterns.EpisodeReUntrusted, "[]")
@@ -641,6 +668,9 @@ func (p *TitleMetaParser) getVersion() int {
 	if len(versionInfo) > 0 {
 		return p.episodeInfoToEpisode(versionInfo[0])
 	}
+	if len(p.findallSubTitle(patterns.RevisionPattern, "[]")) > 0 {
+		return 2
+	}
 	return 1
 }
 
@@ -662,6 +692,8 @@ func (p *TitleMetaParser) ParseEpisode(title string) *model.EpisodeMetadata {
 	sourceInfo := p.getSourceInfo()
 	resolutionInfo := p.getResolutionInfo()
 	ep.AudioInfo = p.getAudioInfo()
+	// OVA 也会被视频格式匹配掉, 所以特别篇要在这之前解析
+	episodeType, episodeLabel, specialEpisode := p.getSpecialEpisode()
 	videoInfo := p.getVideoInfo()
 
 	// 要先拿字幕类型, 双语什么的会影响字幕语言的判断
@@ -676,8 +708,18 @@ func (p *TitleMetaParser) ParseEpisode(title string) *model.EpisodeMetadata {
 	ep.Version = p.getVersion()
 
 	// 处理可信的集数和季度, collection 的季度和集数解析没有意义
+	ep.EpisodeType = model.EpisodeRegular
 	if ep.Collection { // 是合集，episode = -1
 		ep.Episode = -1
+	} else if episodeType != model.EpisodeRegular {
+		// 特别篇, SP/OVA 没有编号时看看有没有 [01] 这样的可信集数, 没有就当作第 1 集
+		ep.EpisodeType = episodeType
+		ep.EpisodeLabel = episodeLabel
+		ep.Point5 = strings.HasSuffix(episodeLabel, ".5")
+		if specialEpisode <= 0 {
+			specialEpisode = max(p.getTrustedEpisode(), 1)
+		}
+		ep.Episode = specialEpisode
 	} else {
 		// 不是合集，尝试获取可信集数
 		ep.Episode = p.getTrustedEpisode()
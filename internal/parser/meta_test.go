@@ -2,6 +2,8 @@ package parser
 
 import (
 	"testing"
+
+	"goto-bangumi/internal/model"
 )
 
 func TestRawParser(t *testing.T) {
@@ -229,6 +231,28 @@ func TestRawParser(t *testing.T) {
 			wantCollection: false,
 			wantVersion:    2,
 		},
+		{
+			name:         "LoliHouse - 修正版",
+			content:      "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [修正版][WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+			wantGroup:    "LoliHouse",
+			wantTitleRaw: "败犬女主太多了！",
+			wantRes:      "1080p",
+			wantEp:       5,
+			wantSeason:   1,
+			wantSub:      "简繁",
+			wantVersion:  2,
+		},
+		{
+			name:         "LoliHouse - REPACK",
+			content:      "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 06 [REPACK][WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+			wantGroup:    "LoliHouse",
+			wantTitleRaw: "败犬女主太多了！",
+			wantRes:      "1080p",
+			wantEp:       6,
+			wantSeason:   1,
+			wantSub:      "简繁",
+			wantVersion:  2,
+		},
 		{
 			name:         "ANi - 实力至上主义的教室 第四季",
 			content:      "[ANi] 欢迎来到实力至上主义的教室 第四季 2年级篇 第一学期 - 02 [1080P][Baha][WEB-DL][AAC AVC][CHT][MP4]",
@@ -362,6 +386,91 @@ func TestRawParser(t *testing.T) {
 	}
 }
 
+func TestSpecialEpisode(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantType  model.EpisodeType
+		wantLabel string
+		wantEp    int
+		wantTitle string
+	}{
+		{
+			name:      "SP01",
+			content:   "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - SP01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+			wantType:  model.EpisodeSpecial,
+			wantLabel: "SP01",
+			wantEp:    1,
+			wantTitle: "败犬女主太多了！",
+		},
+		{
+			name:      "OVA 无编号",
+			content:   "[ANi] 葬送的芙莉莲 - OVA [1080P][Baha][WEB-DL][AAC AVC][CHT][MP4]",
+			wantType:  model.EpisodeOVA,
+			wantLabel: "OVA",
+			wantEp:    1,
+			wantTitle: "葬送的芙莉莲",
+		},
+		{
+			name:      "OVA 带集数",
+			content:   "[Nekomoe kissaten][Kimetsu no Yaiba][OVA][02][1080p][CHS]",
+			wantType:  model.EpisodeOVA,
+			wantLabel: "OVA",
+			wantEp:    2,
+			wantTitle: "Kimetsu no Yaiba",
+		},
+		{
+			name:      "OAD",
+			content:   "[SweetSub] 某科学的超电磁炮 OAD [WebRip 1080p][CHS]",
+			wantType:  model.EpisodeOVA,
+			wantLabel: "OAD",
+			wantEp:    1,
+			wantTitle: "某科学的超电磁炮",
+		},
+		{
+			name:      "12.5",
+			content:   "[LoliHouse] Oshi no Ko - 12.5 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+			wantType:  model.EpisodeSpecial,
+			wantLabel: "12.5",
+			wantEp:    12,
+			wantTitle: "Oshi no Ko",
+		},
+		{
+			name:      "剧场版",
+			content:   "[喵萌奶茶屋&LoliHouse] 孤独摇滚 / Bocchi the Rock! - 剧场版 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕]",
+			wantType:  model.EpisodeMovie,
+			wantLabel: "剧场版",
+			wantEp:    1,
+			wantTitle: "孤独摇滚",
+		},
+		{
+			name:      "正片",
+			content:   "[LoliHouse] 2.5次元的诱惑 / 2.5-jigen no Ririsa - 05 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+			wantType:  model.EpisodeRegular,
+			wantEp:    5,
+			wantTitle: "2.5次元的诱惑",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := NewTitleMetaParse().Parse(tt.content)
+			if info.EpisodeType != tt.wantType {
+				t.Errorf("EpisodeType = %v, want %v", info.EpisodeType, tt.wantType)
+			}
+			if info.EpisodeLabel != tt.wantLabel {
+				t.Errorf("EpisodeLabel = %v, want %v", info.EpisodeLabel, tt.wantLabel)
+			}
+			if info.Episode != tt.wantEp {
+				t.Errorf("Episode = %v, want %v", info.Episode, tt.wantEp)
+			}
+			if info.Title != tt.wantTitle {
+				t.Errorf("Title = %v, want %v", info.Title, tt.wantTitle)
+			}
+		})
+	}
+}
+
 func TestIsPoint5(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -23,6 +23,29 @@ func NewMikanParser() *MikanParser {
 	return &MikanParser{}
 }
 
+// MikanBangumiRSS Mikan 番剧页面的 RSS, 包含这个番剧所有字幕组发布过的种子
+// 使用配置的 MikanCustomURL, 没有配置时为 mikanani.me
+func MikanBangumiRSS(mikanID int) string {
+	return fmt.Sprintf("%s/RSS/Bangumi?bangumiId=%d", mikanBaseURL(), mikanID)
+}
+
+// MikanSearchRSS Mikan 按关键字搜索的 RSS, 域名同 MikanBangumiRSS
+func MikanSearchRSS(keyword string) string {
+	return fmt.Sprintf("%s/RSS/Search?searchstr=%s", mikanBaseURL(), url.QueryEscape(keyword))
+}
+
+// mikanBaseURL 带协议的 Mikan 地址, 末尾没有 /
+func mikanBaseURL() string {
+	host := network.DefaultMikanHost
+	if ParserConfig != nil && ParserConfig.MikanCustomURL != "" {
+		host = strings.TrimSuffix(ParserConfig.MikanCustomURL, "/")
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return host
+}
+
 func (p *MikanParser) Parse(ctx context.Context, homepage string) (*model.MikanItem, error) {
 	// Fetch HTML content from the URL
 	client := network.GetRequestClient()
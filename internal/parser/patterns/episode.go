@@ -94,6 +94,32 @@ var CollectionSinglePatterns = []*regexp2.Regexp{
 	CollectionVolRe,   // vol.1
 }
 
+// ============ 特别篇匹配规则 ============
+
+// SpecialEpisodeRe 特别篇匹配 如 SP01 OVA OAD2 剧场版 Movie
+var SpecialEpisodeRe = regexp2.MustCompile(
+	BoundaryStart+`
+    (?:-\s)? # - SP01, 连同前面的 - 一起去掉
+    (?:
+    (SP|OVA|OAD)\s?(\d{1,3})? # SP01 OVA OAD 2
+    |(剧场版|劇場版|Movie) # 剧场版
+    )
+    `+BoundaryEnd,
+	regexp2.IgnoreCase|regexp2.IgnorePatternWhitespace,
+)
+
+// Point5EpisodeRe 半集匹配, 捕获整数部分 如 第12.5话 EP12.5 - 12.5 [12.5]
+var Point5EpisodeRe = regexp2.MustCompile(
+	`
+    (?:第(\d+)\.5[话話集] # 第12.5话
+    |EP?(\d+)\.5 # EP12.5
+    |-\s(\d+)\.5 # - 12.5
+    |\[(\d+)\.5\] # [12.5]
+    )
+    `+BoundaryEnd,
+	regexp2.IgnoreCase|regexp2.IgnorePatternWhitespace,
+)
+
 // EpisodeReUntrusted 不可信集数匹配
 var EpisodeReUntrusted = regexp2.MustCompile(
 	BoundaryStart+`
@@ -110,6 +136,15 @@ var VersionPattern = regexp2.MustCompile(
 	regexp2.IgnoreCase|regexp2.IgnorePatternWhitespace,
 )
 
+// RevisionPattern 修正版标记(包括 REPACK/PROPER), 没有版本号时按 v2 处理
+// 只匹配单独的标记, [全遮版&修正版] 这样的是字幕组名
+var RevisionPattern = regexp2.MustCompile(
+	`[\[【\s.]
+	(?:修正版|修正|重置|重製|重制版|(?i:REPACK|PROPER))
+    (?=[\]】\s.])`,
+	regexp2.IgnorePatternWhitespace,
+)
+
 var VersionWithNum = regexp2.MustCompile(
 	`(?<=\d)(v(\d+?))`,  // 用 lookbehind，只匹配 v2 部分，保留前面的集数
 	regexp2.IgnoreCase,
@@ -0,0 +1,36 @@
+package patterns
+
+import "github.com/dlclark/regexp2"
+
+// VideoExtensions 视频文件扩展名
+var VideoExtensions = []string{".mkv", ".mp4", ".avi", ".mov", ".wmv", ".flv", ".webm", ".m2ts", ".ts", ".rmvb"}
+
+// SubtitleExtensions 字幕文件扩展名
+var SubtitleExtensions = []string{".ass", ".ssa", ".srt", ".sup", ".vtt", ".idx", ".sub"}
+
+// ArchiveExtensions 压缩包扩展名
+var ArchiveExtensions = []string{".7z", ".zip", ".rar", ".tar", ".gz"}
+
+// OtherExtensions 种子中常见的非视频文件扩展名, 如说明、图片、音轨
+var OtherExtensions = []string{".txt", ".nfo", ".pdf", ".jpg", ".jpeg", ".png", ".webp", ".gif", ".bmp", ".cue", ".log", ".flac", ".mka", ".mp3"}
+
+// SubtitlePackRe 仅字幕的种子匹配
+// 注意不能直接匹配 "字幕"/"外挂字幕", 否则 "简繁内封字幕" 这类正常剧集也会被匹配
+var SubtitlePackRe = regexp2.MustCompile(
+	`( 字幕包
+    | 仅字幕
+    | 字幕文件
+    | sub(title)?s?\s?only
+    | sub(title)?s?\s?pack
+    )`,
+	regexp2.IgnoreCase|regexp2.IgnorePatternWhitespace,
+)
+
+// FontPackRe 字体包匹配
+var FontPackRe = regexp2.MustCompile(
+	`( 字体包
+    | fonts?\s?pack
+    | `+BoundaryStart+`fonts`+BoundaryEnd+`
+    )`,
+	regexp2.IgnoreCase|regexp2.IgnorePatternWhitespace,
+)
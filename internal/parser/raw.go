@@ -17,6 +17,7 @@ func Init(config *model.RssParserConfig) {
 		ParserConfig = config
 	}
 	InitTmdb(config.TmdbAPIKey)
+	SetTmdbLanguage(config.Language)
 }
 
 type RawParse struct{}
@@ -9,6 +9,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"goto-bangumi/internal/apperrors"
@@ -42,6 +43,22 @@ func InitTmdb(key string) {
 	}
 }
 
+// tmdbLanguage 查询 TMDB 使用的语言, 取值为 Language 的键, 为空时使用 zh
+var tmdbLanguage atomic.Value
+
+// TmdbLanguage 查询 TMDB 使用的语言, 没有设置时为 zh
+func TmdbLanguage() string {
+	if lang, _ := tmdbLanguage.Load().(string); lang != "" {
+		return lang
+	}
+	return "zh"
+}
+
+// SetTmdbLanguage 修改查询 TMDB 使用的语言, 之后的查询生效
+func SetTmdbLanguage(language string) {
+	tmdbLanguage.Store(language)
+}
+
 // TMDBParser handles TMDB API interactions and parsing
 type TMDBParser struct{}
 
@@ -209,12 +226,25 @@ func (p *TMDBParser) TMDBParse(ctx context.Context, title string, language strin
 	if err != nil {
 		return nil, fmt.Errorf("failed to get TV show info: %w", err)
 	}
+	return newTmdbItem(tvShow, content.FirstAirDate), nil
+}
+
+// TMDBItem 根据 TMDB ID 获取信息, 用于手动指定番剧的 TMDB 条目
+func (p *TMDBParser) TMDBItem(ctx context.Context, id int, language string) (*model.TmdbItem, error) {
+	tvShow, err := p.TMDBInfo(ctx, id, language)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get TV show info: %w", err)
+	}
+	return newTmdbItem(tvShow, tvShow.FirstAirDate), nil
+}
 
+// newTmdbItem 以最后一个季度作为当前季度构造 TmdbItem
+func newTmdbItem(tvShow *model.TVShow, firstAirDate string) *model.TmdbItem {
 	// 用最后一个季度作为当前季度
 	lastSeason := GetSeason(tvShow.Seasons)
 
 	// 不以季度的年份为准， 因为tmdb 是以最先播出的时间为准
-	seasonTime, err := time.Parse("2006-01-02", content.FirstAirDate)
+	seasonTime, err := time.Parse("2006-01-02", firstAirDate)
 	var year string
 	if err != nil {
 		year = strconv.Itoa(time.Now().Year())
@@ -225,7 +255,16 @@ func (p *TMDBParser) TMDBParse(ctx context.Context, title string, language strin
 	// 构造海报链接
 	posterLink := tmdbImgURL + lastSeason.PosterPath
 
-	tmdbInfo := &model.TmdbItem{
+	// 各季的集数, 不包括第 0 季(特别篇)
+	var seasons model.TmdbSeasons
+	for _, s := range tvShow.Seasons {
+		if s.SeasonNumber > 0 {
+			seasons = append(seasons, model.TmdbSeason{Number: s.SeasonNumber, EpisodeCount: s.EpisodeCount, AirDate: s.AirDate})
+		}
+	}
+	slices.SortFunc(seasons, func(a, b model.TmdbSeason) int { return a.Number - b.Number })
+
+	item := &model.TmdbItem{
 		ID:            tvShow.ID,
 		Year:          year,
 		OriginalTitle: tvShow.OriginalName,
@@ -235,9 +274,10 @@ func (p *TMDBParser) TMDBParse(ctx context.Context, title string, language strin
 		Season:        lastSeason.SeasonNumber,
 		PosterLink:    posterLink,
 		VoteAverage:   tvShow.VoteAverage,
+		Seasons:       seasons,
 	}
-
-	return tmdbInfo, nil
+	_, item.PriorEpisodes, _ = item.SeasonEpisodes(item.Season)
+	return item
 }
 
 // ParseTMDB is a convenience function that creates a parser, parses, and closes
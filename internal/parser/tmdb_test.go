@@ -133,6 +133,10 @@ func TestTmdbParse(t *testing.T){
 			if tt.wantSeason != "" && fmt.Sprintf("%d", info.Season) != tt.wantSeason {
 				t.Errorf("TMDBParse() Season = %v, want %v", info.Season, tt.wantSeason)
 			}
+			// 各季信息中有当前季度
+			if count, _, ok := info.SeasonEpisodes(info.Season); len(info.Seasons) == 0 || !ok || count != info.EpisodeCount {
+				t.Errorf("Seasons = %+v, want season %d with %d episodes", info.Seasons, info.Season, info.EpisodeCount)
+			}
 			t.Logf("TMDBParse() returned: %+v", info)
 		})
 	}
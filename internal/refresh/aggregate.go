@@ -0,0 +1,24 @@
+package refresh
+
+import "goto-bangumi/internal/model"
+
+// groupByBangumi 把聚合订阅的种子按番剧(解析出的标题和季度, 见 resolveKey)分组, 每组返回一个用来解析的种子
+// 优先使用带 Mikan 页面的种子, 通过 Mikan 解析比只用标题准确; 返回的顺序为每组第一次出现的顺序
+// 同一组的其他种子不用再解析, 番剧创建之后 RefreshRSS 会按标题把它们匹配到这个番剧
+func groupByBangumi(torrents []*model.Torrent) []*model.Torrent {
+	index := make(map[string]int, len(torrents))
+	groups := make([]*model.Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		key := resolveKey(t)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(groups)
+			groups = append(groups, t)
+			continue
+		}
+		if groups[i].Homepage == "" && t.Homepage != "" {
+			groups[i] = t
+		}
+	}
+	return groups
+}
@@ -0,0 +1,44 @@
+package refresh
+
+import (
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestGroupByBangumi(t *testing.T) {
+	makeine11 := &model.Torrent{Name: "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"}
+	makeine12 := &model.Torrent{
+		Name:     "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		Homepage: "https://mikanani.me/Home/Episode/makeine12",
+	}
+	tougen := &model.Torrent{Name: "[LoliHouse] 桃源暗鬼 / Tougen Anki - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"}
+	tougen02 := &model.Torrent{Name: "[LoliHouse] 桃源暗鬼 / Tougen Anki - 02 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"}
+
+	got := groupByBangumi([]*model.Torrent{makeine11, tougen, makeine12, tougen02})
+	want := []*model.Torrent{makeine12, tougen}
+	if len(got) != len(want) {
+		t.Fatalf("groupByBangumi() returned %d torrents, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("[%d] = %q, want %q", i, got[i].Name, want[i].Name)
+		}
+	}
+}
+
+func TestRSSItemIsAggregate(t *testing.T) {
+	tests := []struct {
+		item *model.RSSItem
+		want bool
+	}{
+		{&model.RSSItem{Link: "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583"}, false},
+		{&model.RSSItem{Link: "https://mikanani.me/RSS/Bangumi?bangumiId=3391", Aggregate: true}, true},
+		{&model.RSSItem{Link: "https://mikanani.me/RSS/MyBangumi?token=test"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.item.IsAggregate(); got != tt.want {
+			t.Errorf("IsAggregate(%q, %v) = %v, want %v", tt.item.Link, tt.item.Aggregate, got, tt.want)
+		}
+	}
+}
@@ -2,30 +2,27 @@ package refresh
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
-	"regexp"
 	"strings"
 
 	"goto-bangumi/internal/apperrors"
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/filter"
 	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/notification"
 	"goto-bangumi/internal/parser"
 )
 
+// OfficialTitleParse 默认的 tmdb 解析器, 有 homepage 时先用 mikan 获取标题, 再查询 TMDB
 func OfficialTitleParse(ctx context.Context, torrent *model.Torrent) (*model.Bangumi, error) {
 	bangumi := model.NewBangumi()
 	if torrent.Homepage != "" {
 		// 对于有 homepage 的, 默认进行一遍解析, 用以得到更准确的标题
 		// 就算是 mikan 的, 也不一定有 homepage
-		mikanParse := parser.NewMikanParser()
-		mikanInfo, err := mikanParse.Parse(ctx, torrent.Homepage)
 		// 这里要看看是网络问题还是解析问题, 不过感觉有 homepage ，那就一定是网络问题
 		// 不过也可能是 mikan 还没有收录
-		if err == nil {
-			bangumi.OfficialTitle = mikanInfo.OfficialTitle
-			bangumi.PosterLink = mikanInfo.PosterLink
-			bangumi.MikanItem = mikanInfo
-			bangumi.Season = mikanInfo.Season
-		} else {
+		if err := applyMikan(ctx, torrent, bangumi); err != nil {
 			slog.Debug("[OfficialTitleParse] mikan 解析失败", "种子名称", torrent.Name, "error", err)
 			// 网络错误直接返回,不做后面的解析
 			if apperrors.IsNetworkError(err) {
@@ -33,76 +30,66 @@ func OfficialTitleParse(ctx context.Context, torrent *model.Torrent) (*model.Ban
 			}
 		}
 	}
-	if bangumi.Parse == "bangumi" {
-		// Bangumi 解析, 没有做
+	var title string
+	if bangumi.OfficialTitle != "" {
+		// 优先使用 mikan 解析到的标题
+		title = bangumi.OfficialTitle
 	} else {
-		tmdbParse := parser.NewTMDBParse()
-		var title string
-		if bangumi.OfficialTitle != "" {
-			// 优先使用 mikan 解析到的标题
-			title = bangumi.OfficialTitle
-		} else {
-			// 否则使用种子标题
-			title = parser.NewTitleMetaParse().Parse(torrent.Name).Title
-		}
+		// 否则使用种子标题
+		title = parser.NewTitleMetaParse().Parse(torrent.Name).Title
+	}
 
-		tmdbInfo, err := tmdbParse.TMDBParse(ctx, title, "zh")
-		// 当 tmdb 也没有找到信息的时候，如果 mikan 也没有找到， 报错
-		if err != nil {
-			if bangumi.OfficialTitle == "" {
-				return nil, err
-			}
-			return bangumi, err
-		}
-		// 只有在没有解析到标题的情况下才使用 tmdb 的结果
+	tmdbInfo, err := parser.LookupTMDB(ctx, title, parser.TmdbLanguage())
+	// 当 tmdb 也没有找到信息的时候，如果 mikan 也没有找到， 报错
+	if err != nil {
 		if bangumi.OfficialTitle == "" {
-			bangumi.OfficialTitle = tmdbInfo.Title
-			bangumi.PosterLink = tmdbInfo.PosterLink
+			return nil, err
 		}
-		// 总是以 tmdb 的季度为准
+		return bangumi, err
+	}
+	// 只有在没有解析到标题的情况下才使用 tmdb 的结果
+	if bangumi.OfficialTitle == "" {
+		bangumi.OfficialTitle = tmdbInfo.Title
+		bangumi.PosterLink = tmdbInfo.PosterLink
+	}
+	// 季度由 correctSeason 按标题和 TMDB 的季度确定, 这里先用 mikan 的, 没有时为 TMDB 最新的一季
+	if bangumi.MikanItem == nil {
 		bangumi.Season = tmdbInfo.Season
-		bangumi.Year = tmdbInfo.Year
-		bangumi.TmdbItem = tmdbInfo
 	}
+	bangumi.Year = tmdbInfo.Year
+	bangumi.TmdbItem = tmdbInfo
 	return bangumi, nil
 }
 
-// FilterTorrent 通过bangumi信息判断torrent是否符合要求
-func FilterTorrent(torrent *model.Torrent,include string,exclude string) bool {
-	// 排除过滤
-	// var exclude, include string
-	// exclude = bangumi.ExcludeFilter
-	// include = bangumi.IncludeFilter
-	// 排除过滤：将逗号分隔的规则用 | 连接成正则表达式
-	if exclude != "" {
-		excludePattern := strings.ReplaceAll(exclude, ",", "|")
-		re, err := regexp.Compile(excludePattern)
-		if err != nil {
-			slog.Warn("[FilterTorrent] 排除过滤正则表达式编译失败", "正则", excludePattern, "error", err)
-		} else if re.MatchString(torrent.Name) {
-			slog.Debug("[FilterTorrent] 过滤种子", "种子名称", torrent.Name, "匹配正则", excludePattern)
-			return false
-		}
-	}
-	// 包含过滤：将逗号分隔的规则用 | 连接成正则表达式
-	if include != "" {
-		includePattern := strings.ReplaceAll(include, ",", "|")
-		re, err := regexp.Compile(includePattern)
-		if err != nil {
-			slog.Warn("[FilterTorrent] 包含过滤正则表达式编译失败", "正则", includePattern, "error", err)
-		} else if re.MatchString(torrent.Name) {
-			slog.Debug("[FilterTorrent] 通过包含过滤", "种子名称", torrent.Name, "匹配正则", includePattern)
-			return true
-		}
-		return false
+// applyMikan 解析 mikan 页面, 将标题、海报和季度写入 bangumi
+func applyMikan(ctx context.Context, torrent *model.Torrent, bangumi *model.Bangumi) error {
+	mikanInfo, err := parser.LookupMikan(ctx, torrent.Homepage)
+	if err != nil {
+		return err
 	}
-	slog.Debug("[FilterTorrent] 通过", "种子名称", torrent.Name)
-	return true
+	bangumi.OfficialTitle = mikanInfo.OfficialTitle
+	bangumi.PosterLink = mikanInfo.PosterLink
+	bangumi.MikanItem = mikanInfo
+	bangumi.Season = mikanInfo.Season
+	return nil
+}
+
+// FilterTorrent 按全局规则和逗号分隔的包含、排除正则判断种子是否符合要求, 规则见 filter.Evaluate
+// 已经确定番剧时用 FilterBangumiTorrent, 番剧覆盖的规则也会生效
+func FilterTorrent(torrent *model.Torrent, include string, exclude string) bool {
+	return filter.Allow(torrent, filter.Global().WithPatterns(include, exclude))
+}
+
+// FilterBangumiTorrent 按番剧实际使用的规则判断种子是否符合要求, 见 filter.ForBangumi
+func FilterBangumiTorrent(torrent *model.Torrent, bangumi *model.Bangumi) bool {
+	return filter.Allow(torrent, filter.ForBangumi(bangumi))
 }
 
 // TorrentToBangumi 从 torrent 解析出 bangumi 信息,只会反回网络错误
-func TorrentToBangumi(ctx context.Context, torrent *model.Torrent, rssLink string) (*model.Bangumi, error) {
-	bangumi, err := OfficialTitleParse(ctx, torrent)
+// 使用 rssItem.Parse 指定的解析器, 见 GetMetadataParser
+func TorrentToBangumi(ctx context.Context, torrent *model.Torrent, rssItem *model.RSSItem) (*model.Bangumi, error) {
+	parseName, parse := GetMetadataParser(rssItem.Parse)
+	bangumi, err := parse(ctx, torrent)
 	metaInfo := parser.NewTitleMetaParse().Parse(torrent.Name)
 	// 为空在两种可能
 	// 1. torrent 的名字不太对, 当torrent 名字不对而没法解析的时候, 要显示bangumi
@@ -124,31 +111,105 @@ func TorrentToBangumi(ctx context.Context, torrent *model.Torrent, rssLink strin
 		bangumi.Season = metaInfo.Season
 	}
 
+	correctSeason(bangumi, metaInfo)
+
+	bangumi.Parse = parseName
 	bangumi.IncludeFilter = strings.Join(parser.ParserConfig.Include, ",")
 	bangumi.ExcludeFilter = strings.Join(parser.ParserConfig.Filter, ",")
-	bangumi.RSSLink = rssLink
+	bangumi.RSSLink = rssItem.Link
 	bangumi.EpisodeMetadata = append(bangumi.EpisodeMetadata, *metaInfo)
 	return bangumi, nil
 }
 
-func (r *Refresher) createBangumi(ctx context.Context, torrent *model.Torrent, rssItem *model.RSSItem) {
-	bangumi, err := TorrentToBangumi(ctx, torrent, rssItem.Link)
+// correctSeason 用 TMDB 的季度修正解析器的季度, 番剧的季度与修正后的一致
+// 标题里没有季度信息时解析器默认为第 1 季, 这时使用 mikan 的季度, 没有 mikan 时为 TMDB 最新的一季;
+// 标题里明确写了季度的以标题为准, 只有 TMDB 的各季中没有这一季时(字幕组和 TMDB 分季不同)才改用 TMDB 的季度
+func correctSeason(bangumi *model.Bangumi, metaInfo *model.EpisodeMetadata) {
+	item := bangumi.TmdbItem
+	if item == nil || item.Season <= 0 {
+		return
+	}
+	season := metaInfo.Season
+	switch {
+	case metaInfo.SeasonRaw == "" && bangumi.MikanItem != nil && bangumi.Season > 0 && tmdbHasSeason(item, bangumi.Season):
+		season = bangumi.Season
+	case metaInfo.SeasonRaw == "":
+		season = item.Season
+	case !tmdbHasSeason(item, season):
+		slog.Warn("[correctSeason] TMDB 没有标题中的季度, 使用 TMDB 的季度", "标题", metaInfo.Title,
+			"解析季度", metaInfo.Season, "TMDB 季度", item.Season)
+		season = item.Season
+	}
+	if season != metaInfo.Season {
+		slog.Info("[correctSeason] 根据 TMDB 修正季度", "标题", metaInfo.Title, "解析季度", metaInfo.Season, "季度", season)
+	}
+	metaInfo.Season = season
+	bangumi.Season = season
+}
+
+// tmdbHasSeason TMDB 是否有第 season 季, 没有各季信息的旧条目总是认为有
+func tmdbHasSeason(item *model.TmdbItem, season int) bool {
+	if len(item.Seasons) == 0 {
+		return true
+	}
+	_, _, ok := item.SeasonEpisodes(season)
+	return ok
+}
+
+// createBangumi 解析种子并创建番剧, 返回创建(或合并到)的番剧
+// 同一个标题同时只会有一个创建在进行, 解析失败的标题按 resolveBackoff 退避后才会重试
+// backfill 为 false 时番剧直接标记为已补全, 创建后不会补全之前的集数, 见 Backfill
+// 没有创建番剧时总是返回错误, 调用方不会拿到 nil 的番剧
+func (r *Refresher) createBangumi(ctx context.Context, torrent *model.Torrent, rssItem *model.RSSItem, backfill bool) (*model.Bangumi, error) {
+	key := resolveKey(torrent)
+	if _, loaded := r.creating.LoadOrStore(key, struct{}{}); loaded {
+		slog.Debug("[createBangumi] 番剧正在创建中, 跳过", "种子名称", torrent.Name)
+		return nil, ErrResolveInProgress
+	}
+	defer r.creating.Delete(key)
+	if err := r.checkResolveBackoff(ctx, key); err != nil {
+		return nil, err
+	}
+
+	bangumi, err := TorrentToBangumi(ctx, torrent, rssItem)
 	if err != nil && apperrors.IsNetworkError(err) {
 		slog.Warn("[createBangumi] 网络错误，跳过该番剧的添加", "种子名称", torrent.Name, "error", err)
-		return
+		r.recordResolveFailure(ctx, key, err)
+		return nil, err
 	}
 	// 对 mikan 部份错误进行处理
 
-	if bangumi != nil {
-		slog.Debug("createBangumi", "名称", bangumi.OfficialTitle)
-		// if torrent.Homepage != "" && bangumi.MikanItem == nil {
-		// 	// 这里对应 mikan 未添加的情况, 一般出现在季度初
-		// 	// TODO: 没想好怎么处理, 先放着
-		// }
-		// 对 bangumi 进行处理，要看看有没有相同的 bangumi 项
-		// 有相同的就只更新metadata
-		if err := r.db.CreateBangumi(bangumi); err != nil {
-			slog.Error("[createBangumi] 创建番剧失败", "种子名称", torrent.Name, "error", err)
+	if bangumi == nil {
+		if err == nil {
+			err = fmt.Errorf("解析种子 %s 没有得到番剧", torrent.Name)
 		}
+		return nil, err
 	}
+	slog.Debug("createBangumi", "名称", bangumi.OfficialTitle)
+	bangumi.Backfilled = !backfill
+	// if torrent.Homepage != "" && bangumi.MikanItem == nil {
+	// 	// 这里对应 mikan 未添加的情况, 一般出现在季度初
+	// 	// TODO: 没想好怎么处理, 先放着
+	// }
+	// 对 bangumi 进行处理，要看看有没有相同的 bangumi 项
+	// 有相同的就只更新metadata
+	// 番剧和解析失败记录的清理一起提交
+	// 合并到已有番剧时 bangumi 被替换为已有的番剧, 不再通知发现新番
+	var created bool
+	err = r.db.Transaction(ctx, func(tx *database.DB) error {
+		var err error
+		if created, err = tx.CreateOrMergeBangumi(ctx, bangumi); err != nil {
+			return err
+		}
+		return tx.DeleteResolveAttempt(ctx, key)
+	})
+	if err != nil {
+		slog.Error("[createBangumi] 创建番剧失败", "种子名称", torrent.Name, "error", err)
+		r.recordResolveFailure(ctx, key, err)
+		return nil, err
+	}
+	if created {
+		r.notify(ctx, notification.NewTorrentEvent(notification.EventBangumiDiscovered, torrent, bangumi))
+	}
+	return bangumi, nil
 }
@@ -3,9 +3,11 @@ package refresh
 import (
 	"context"
 	"testing"
+	"time"
 
 	"goto-bangumi/internal/database"
 	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
 )
 
 func TestFilter_torrent(t *testing.T) {
@@ -63,6 +65,30 @@ func TestFilter_torrent(t *testing.T) {
 			bangumi:  model.Bangumi{},
 			expected: true,
 		},
+		{
+			name: "subtitle pack",
+			torrent: model.Torrent{
+				Name: "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! [01-12][简繁日字幕包].7z",
+			},
+			bangumi:  model.Bangumi{},
+			expected: false,
+		},
+		{
+			name: "font pack",
+			torrent: model.Torrent{
+				Name: "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! [Fonts]",
+			},
+			bangumi:  model.Bangumi{},
+			expected: false,
+		},
+		{
+			name: "subtitle file",
+			torrent: model.Torrent{
+				Name: "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 01 [简日双语].ass",
+			},
+			bangumi:  model.Bangumi{},
+			expected: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -140,7 +166,7 @@ func TestTorrentToBangumi(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotBangumi,err := TorrentToBangumi(context.Background(), &tt.torrent, tt.rss.Link)
+			gotBangumi,err := TorrentToBangumi(context.Background(), &tt.torrent, &tt.rss)
 			if err != nil {
 				t.Errorf("TorrentToBangumi() error = %v, want nil", err)
 				return
@@ -189,6 +215,7 @@ func TestTorrentToBangumi(t *testing.T) {
 }
 
 func TestCreateBangumi(t *testing.T) {
+	ctx := context.Background()
 	// 创建内存数据库，测试完成后自动释放
 	memoryDB := ":memory:"
 	db, err := database.NewDB(&memoryDB)
@@ -209,11 +236,11 @@ func TestCreateBangumi(t *testing.T) {
 	}
 
 	// 调用被测函数
-	r := &Refresher{db: db}
-	r.createBangumi(context.Background(), torrent, rssItem)
+	r := New(db)
+	r.createBangumi(context.Background(), torrent, rssItem, true)
 
 	// 验证数据库中是否创建了番剧
-	bangumi, err := db.GetBangumiByOfficialTitle("弹珠汽水瓶里的千岁同学")
+	bangumi, err := db.GetBangumiByOfficialTitle(ctx, "弹珠汽水瓶里的千岁同学")
 	if err != nil {
 		t.Fatalf("查询番剧失败: %v", err)
 	}
@@ -245,3 +272,94 @@ func TestCreateBangumi(t *testing.T) {
 		t.Errorf("TmdbID = %v, want 261343", bangumi.TmdbID)
 	}
 }
+
+func TestCorrectSeason(t *testing.T) {
+	tests := []struct {
+		name        string
+		torrentName string
+		tmdb        *model.TmdbItem
+		mikanSeason int
+		wantSeason  int
+	}{
+		{
+			name:        "没有季度信息, 以 TMDB 为准",
+			torrentName: "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 14 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕]",
+			tmdb:        &model.TmdbItem{ID: 241535, Season: 2},
+			wantSeason:  2,
+		},
+		{
+			name:        "没有季度信息, 以 mikan 为准",
+			torrentName: "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 14 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕]",
+			tmdb:        &model.TmdbItem{ID: 241535, Season: 2, Seasons: model.TmdbSeasons{{Number: 1}, {Number: 2}}},
+			mikanSeason: 1,
+			wantSeason:  1,
+		},
+		{
+			name:        "标题中有季度, 不修改",
+			torrentName: "[黒ネズミたち] 异世界四重奏 第三季 / Isekai Quartet 3 - 11 (ABEMA 1920x1080 AVC AAC MP4)",
+			tmdb:        &model.TmdbItem{ID: 87478, Season: 2},
+			wantSeason:  3,
+		},
+		{
+			name:        "标题中的季度不是 TMDB 最新的一季, 不修改",
+			torrentName: "[黒ネズミたち] 异世界四重奏 第二季 / Isekai Quartet 2 - 11 (ABEMA 1920x1080 AVC AAC MP4)",
+			tmdb:        &model.TmdbItem{ID: 87478, Season: 3, Seasons: model.TmdbSeasons{{Number: 1}, {Number: 2}, {Number: 3}}},
+			wantSeason:  2,
+		},
+		{
+			name:        "TMDB 没有标题中的季度, 以 TMDB 为准",
+			torrentName: "[黒ネズミたち] 异世界四重奏 第三季 / Isekai Quartet 3 - 11 (ABEMA 1920x1080 AVC AAC MP4)",
+			tmdb:        &model.TmdbItem{ID: 87478, Season: 2, Seasons: model.TmdbSeasons{{Number: 1}, {Number: 2}}},
+			wantSeason:  2,
+		},
+		{
+			name:        "没有 TMDB 信息, 不修改",
+			torrentName: "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 14 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕]",
+			tmdb:        nil,
+			wantSeason:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metaInfo := parser.NewTitleMetaParse().Parse(tt.torrentName)
+			bangumi := model.NewBangumi()
+			bangumi.TmdbItem = tt.tmdb
+			if tt.mikanSeason > 0 {
+				bangumi.MikanItem = &model.MikanItem{}
+				bangumi.Season = tt.mikanSeason
+			}
+			correctSeason(bangumi, metaInfo)
+			if metaInfo.Season != tt.wantSeason {
+				t.Errorf("EpisodeMetadata.Season = %d, 期望 %d", metaInfo.Season, tt.wantSeason)
+			}
+			if tt.tmdb != nil && bangumi.Season != tt.wantSeason {
+				t.Errorf("Bangumi.Season = %d, 期望 %d", bangumi.Season, tt.wantSeason)
+			}
+		})
+	}
+}
+
+func TestFilterTorrent_SizeAge(t *testing.T) {
+	oldConfig := parser.ParserConfig
+	parser.ParserConfig = &model.RssParserConfig{MinSizeMB: 10, MaxAgeDays: 30}
+	defer func() { parser.ParserConfig = oldConfig }()
+
+	name := "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 04 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕]"
+	tests := []struct {
+		name     string
+		torrent  model.Torrent
+		expected bool
+	}{
+		{"sample", model.Torrent{Name: name, Size: 2 * 1024 * 1024, PubDate: time.Now()}, false},
+		{"too old", model.Torrent{Name: name, Size: 400 * 1024 * 1024, PubDate: time.Now().AddDate(0, 0, -400)}, false},
+		{"recent episode", model.Torrent{Name: name, Size: 400 * 1024 * 1024, PubDate: time.Now().Add(-time.Hour)}, true},
+		{"unknown size and date", model.Torrent{Name: name}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterTorrent(&tt.torrent, "", ""); got != tt.expected {
+				t.Errorf("FilterTorrent() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
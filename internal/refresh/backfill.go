@@ -0,0 +1,194 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// 补全: 季度中途才创建的番剧, 聚合订阅里只有最近几集, 之前播出的集数不会再出现
+// 创建番剧后从 Mikan 番剧页面的 RSS(没有 Mikan 信息时为番剧自己的 RSS)找出还没有的集数,
+// 只保留番剧选择的字幕组和分辨率, 每集挑一个种子入队; 下载偏好 PreferBatch 时有覆盖多集的合集就下载合集
+// 完成后标记 Bangumi.Backfilled, 之后不再补全; 失败的番剧由调度器定期重试, 见 RetryBackfill
+
+// Backfill 补全番剧创建之前播出的集数, 返回入队的种子数量
+// 已经补全过、已完结或已删除的番剧不做任何事; 拉取 RSS 或入库失败时返回错误, 不标记为已补全, 下次重试
+func (r *Refresher) Backfill(ctx context.Context, bangumiID int, runner *taskrunner.TaskRunner) (int, error) {
+	bangumi, err := r.db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return 0, err
+	}
+	if bangumi.Backfilled || bangumi.Completed || bangumi.Deleted {
+		return 0, nil
+	}
+	link, trusted := backfillLink(bangumi)
+	if link == "" {
+		slog.Debug("[Backfill] 番剧没有可以补全的 RSS", "番剧", bangumi.OfficialTitle)
+		return 0, r.db.SetBangumiBackfilled(ctx, bangumi.ID)
+	}
+	torrents, err := r.fetchNewTorrents(ctx, link)
+	if err != nil {
+		return 0, err
+	}
+	progress, err := r.bangumiProgress(ctx, bangumi)
+	if err != nil {
+		return 0, err
+	}
+	// 正在下载的集数同样不需要补全
+	have := make(map[int]bool, len(progress.Have)+len(progress.Downloading))
+	for _, ep := range slices.Concat(progress.Have, progress.Downloading) {
+		have[ep] = true
+	}
+
+	groups, resolutions := backfillChoice(bangumi)
+	pref := bangumi.Preference()
+	preferBatch := pref.AllowBatch && pref.PreferBatch
+	best := make(map[int]*model.Torrent)
+	bestScore := make(map[int]int)
+	var batch *model.Torrent
+	var batchEps []int
+	batchScore := 0
+	for _, t := range torrents {
+		meta := parser.NewTitleMetaParse().Parse(t.Name)
+		if meta.EpisodeType != model.EpisodeRegular || !meta.Collection && meta.Episode <= 0 {
+			continue
+		}
+		if meta.Collection && !preferBatch {
+			continue
+		}
+		// 合集覆盖的还没有的集数
+		var eps []int
+		if meta.Collection {
+			for ep := meta.EpisodeStart; ep > 0 && ep <= meta.EpisodeEnd; ep++ {
+				if n := ep + bangumi.Offset; !have[n] && (progress.Total <= 0 || n <= progress.Total) {
+					eps = append(eps, n)
+				}
+			}
+		} else if ep := meta.Episode + bangumi.Offset; !have[ep] && (progress.Total <= 0 || ep <= progress.Total) {
+			eps = []int{ep}
+		}
+		if len(eps) == 0 {
+			continue
+		}
+		if !matchFold(groups, meta.Group) || !matchFold(resolutions, meta.Resolution) {
+			continue
+		}
+		// 番剧自己的 RSS 可能是聚合订阅, 只要匹配到这个番剧的种子
+		if !trusted {
+			match, err := r.db.GetBangumiParseByTitle(ctx, t.Name)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return 0, err
+			}
+			if match == nil || match.ID != bangumi.ID {
+				continue
+			}
+		}
+		if !FilterBangumiTorrent(t, bangumi) {
+			continue
+		}
+		score := releaseScore(meta, bangumi)
+		t.BangumiID = bangumi.ID
+		t.Bangumi = r.seasonBangumi(ctx, bangumi, t)
+		if meta.Collection {
+			// 覆盖缺失集数多的合集优先, 相同时按偏好
+			if len(eps) > len(batchEps) || len(eps) == len(batchEps) && score > batchScore {
+				batch, batchEps, batchScore = t, eps, score
+			}
+			continue
+		}
+		ep := eps[0]
+		if old, ok := best[ep]; ok && (bestScore[ep] > score || bestScore[ep] == score && !t.PubDate.After(old.PubDate)) {
+			continue
+		}
+		best[ep] = t
+		bestScore[ep] = score
+	}
+
+	var matched []*model.Torrent
+	// 合集只覆盖一集时不如单集
+	if len(batchEps) > 1 {
+		matched = append(matched, batch)
+		for _, ep := range batchEps {
+			delete(best, ep)
+		}
+	} else {
+		batchEps = nil
+	}
+	eps := make([]int, 0, len(best))
+	for ep := range best {
+		eps = append(eps, ep)
+	}
+	slices.Sort(eps)
+	for _, ep := range eps {
+		matched = append(matched, best[ep])
+	}
+	eps = append(eps, batchEps...)
+	slices.Sort(eps)
+	if err := r.submitTorrents(ctx, matched, "补全: "+link, runner); err != nil {
+		return 0, err
+	}
+	if err := r.db.SetBangumiBackfilled(ctx, bangumi.ID); err != nil {
+		return 0, err
+	}
+	slog.Info("[Backfill] 补全之前的集数", "番剧", bangumi.OfficialTitle, "集数", eps)
+	return len(matched), nil
+}
+
+// RetryBackfill 补全所有还没有补全过的番剧, 返回入队的种子总数
+// 创建番剧时的补全失败(例如拉取 RSS 失败)或者没有收到创建番剧的事件时, 由调度器定期调用重试
+// 单个番剧补全失败只记录日志, 不影响其他番剧, 下次调用时再重试
+func (r *Refresher) RetryBackfill(ctx context.Context, runner *taskrunner.TaskRunner) (int, error) {
+	ids, err := r.db.ListBangumiToBackfill(ctx)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		n, err := r.Backfill(ctx, id, runner)
+		if err != nil {
+			slog.Warn("[Backfill] 补全番剧之前的集数失败, 稍后重试", "番剧 ID", id, "error", err)
+			continue
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// backfillLink 补全使用的 RSS, trusted 表示 RSS 里的种子都属于这个番剧, 不需要再匹配
+func backfillLink(bangumi *model.Bangumi) (link string, trusted bool) {
+	if bangumi.MikanItem != nil && bangumi.MikanItem.ID > 0 {
+		return parser.MikanBangumiRSS(bangumi.MikanItem.ID), true
+	}
+	return bangumi.RSSLink, false
+}
+
+// backfillChoice 番剧选择的字幕组和分辨率: 设置了下载偏好时按偏好, 否则为番剧已有的解析信息中出现过的
+// 为空时不限制
+func backfillChoice(bangumi *model.Bangumi) (groups, resolutions []string) {
+	pref := bangumi.Preference()
+	groups, resolutions = pref.Groups, pref.Resolutions
+	for _, meta := range bangumi.EpisodeMetadata {
+		if len(pref.Groups) == 0 && meta.Group != "" {
+			groups = append(groups, meta.Group)
+		}
+		if len(pref.Resolutions) == 0 && meta.Resolution != "" {
+			resolutions = append(resolutions, meta.Resolution)
+		}
+	}
+	return groups, resolutions
+}
+
+// matchFold value 是否在 list 中(不区分大小写), list 为空时总是匹配
+func matchFold(list []string, value string) bool {
+	return len(list) == 0 || slices.ContainsFunc(list, func(s string) bool { return strings.EqualFold(s, value) })
+}
@@ -0,0 +1,196 @@
+package refresh
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// TestBackfill 从 Mikan 番剧页面的 RSS 补全之前的集数, 只要番剧的字幕组和分辨率, 补全后不再重复
+func TestBackfill(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	bangumi := &model.Bangumi{
+		OfficialTitle:   "败犬女主太多了！",
+		Season:          1,
+		MikanItem:       &model.MikanItem{ID: 991391, OfficialTitle: "败犬女主太多了！"},
+		TmdbItem:        &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！", EpisodeCount: 12},
+		EpisodeMetadata: []model.EpisodeMetadata{{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse", Resolution: "1080p"}},
+	}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 已经有第 5 集
+	have := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	if err := db.CreateTorrent(ctx, &model.Torrent{Name: have, Link: "magnet:?xt=urn:btih:HAVE05", BangumiID: bangumi.ID, Downloaded: model.DownloadDone}); err != nil {
+		t.Fatal(err)
+	}
+
+	rssURL := parser.MikanBangumiRSS(991391)
+	network.SetTestCache(rssURL, collectRSS(
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 02 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 03 [WebRip 720p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 04 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		"[桜都字幕组] 败犬女主太多了！ / Make Heroine ga Oosugiru! [03][1080p][简繁内封]",
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! [01-12合集][WebRip 1080p HEVC-10bit AAC][简繁内封字幕][Fin]",
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+	))
+	defer network.ClearTestCache(rssURL)
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	r := New(db)
+	n, err := r.Backfill(ctx, bangumi.ID, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("Backfill() = %d, want 3", n)
+	}
+	progress, err := r.GetBangumiProgress(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 补全的集数刚入队, 还在下载
+	if !slices.Equal(progress.Have, []int{5}) || !slices.Equal(progress.Downloading, []int{1, 2, 4}) {
+		t.Errorf("Have = %v, Downloading = %v, want [5] and [1 2 4]", progress.Have, progress.Downloading)
+	}
+	saved, err := db.GetBangumiByID(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Backfilled {
+		t.Error("bangumi is not marked as backfilled")
+	}
+
+	// 已经补全过, 不再补全
+	if n, err := r.Backfill(ctx, bangumi.ID, runner); err != nil || n != 0 {
+		t.Errorf("second Backfill() = %d, %v, want 0, nil", n, err)
+	}
+}
+
+// TestBackfillPreferBatch 偏好合集时用覆盖多集的合集补全, 合集记录覆盖的集数; 不要合集的番剧跳过合集
+func TestBackfillPreferBatch(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	bangumi := &model.Bangumi{
+		OfficialTitle: "败犬女主太多了！",
+		Season:        1,
+		MikanItem:     &model.MikanItem{ID: 991392, OfficialTitle: "败犬女主太多了！"},
+		TmdbItem:      &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！", EpisodeCount: 12},
+	}
+	bangumi.SetPreference(model.ReleasePreference{AllowBatch: true, PreferBatch: true})
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	batch := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! [01-12合集][WebRip 1080p HEVC-10bit AAC][简繁内封字幕][Fin]"
+	rssURL := parser.MikanBangumiRSS(991392)
+	network.SetTestCache(rssURL, collectRSS(
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		batch,
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 02 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+	))
+	defer network.ClearTestCache(rssURL)
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	r := New(db)
+	if n, err := r.Backfill(ctx, bangumi.ID, runner); err != nil || n != 1 {
+		t.Fatalf("Backfill() = %d, %v, want only the batch", n, err)
+	}
+	stored, err := db.ListTorrentByBangumiID(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Name != batch || !stored[0].Batch || stored[0].EpisodeStart != 1 || stored[0].EpisodeEnd != 12 {
+		t.Fatalf("stored = %+v, want the batch covering 1-12", stored)
+	}
+
+	// 不要合集的番剧跳过合集, 单集照常下载
+	skip := &model.Bangumi{OfficialTitle: "药屋少女的呢喃", Season: 1, SkipBatch: true}
+	if err := db.Save(skip).Error; err != nil {
+		t.Fatal(err)
+	}
+	torrents := []*model.Torrent{
+		{Link: "magnet:?xt=urn:btih:SKIPBATCH", Name: "[LoliHouse] 药屋少女的呢喃 / Kusuriya no Hitorigoto [01-24合集][WebRip 1080p HEVC-10bit AAC][简繁内封字幕][Fin]", Bangumi: skip},
+		{Link: "magnet:?xt=urn:btih:SKIPSINGLE", Name: "[LoliHouse] 药屋少女的呢喃 / Kusuriya no Hitorigoto - 03 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]", Bangumi: skip},
+	}
+	if err := r.submitTorrents(ctx, torrents, "test", runner); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = db.ListTorrentByBangumiID(ctx, skip.ID)
+	if len(stored) != 1 || stored[0].Link != torrents[1].Link {
+		t.Errorf("stored = %+v, want only the single episode", stored)
+	}
+}
+
+// TestRetryBackfill 重试补全所有还没有补全过的番剧, 已完结的番剧跳过, 补全后不再重试
+func TestRetryBackfill(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	pending := &model.Bangumi{
+		OfficialTitle:   "败犬女主太多了！",
+		Season:          1,
+		MikanItem:       &model.MikanItem{ID: 991392, OfficialTitle: "败犬女主太多了！"},
+		EpisodeMetadata: []model.EpisodeMetadata{{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse", Resolution: "1080p"}},
+	}
+	completed := &model.Bangumi{OfficialTitle: "已完结", Season: 1, Completed: true}
+	done := &model.Bangumi{OfficialTitle: "已补全", Season: 1, Backfilled: true}
+	for _, b := range []*model.Bangumi{pending, completed, done} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	rssURL := parser.MikanBangumiRSS(991392)
+	network.SetTestCache(rssURL, collectRSS(
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 02 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+	))
+	defer network.ClearTestCache(rssURL)
+
+	ids, err := db.ListBangumiToBackfill(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []int{pending.ID}) {
+		t.Fatalf("ListBangumiToBackfill() = %v, want [%d]", ids, pending.ID)
+	}
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	r := New(db)
+	if n, err := r.RetryBackfill(ctx, runner); err != nil || n != 2 {
+		t.Errorf("RetryBackfill() = %d, %v, want 2, nil", n, err)
+	}
+	if n, err := r.RetryBackfill(ctx, runner); err != nil || n != 0 {
+		t.Errorf("second RetryBackfill() = %d, %v, want 0, nil", n, err)
+	}
+}
@@ -3,14 +3,19 @@ package refresh
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
-
-	"gorm.io/gorm"
+	"sync"
+	"time"
 
 	"goto-bangumi/internal/database"
 	"goto-bangumi/internal/model"
 	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/notification"
+	"goto-bangumi/internal/search"
 	"goto-bangumi/internal/taskrunner"
+
+	"golang.org/x/sync/errgroup"
 )
 
 // 流程为: 取种子列表 -> 对比数据库中已有的种子 -> 返回新增的种子 -> 检查是否有对应的番剧信息
@@ -19,27 +24,88 @@ import (
 
 // Refresher 封装了刷新操作所需的数据库依赖
 type Refresher struct {
-	db *database.DB
+	db Store
+	// 正在创建的番剧标题, 防止并发刷新重复创建, 见 createBangumi
+	creating sync.Map
+	// 已经重新获取过的 TMDB 条目 ID, 见 tmdbItem
+	tmdbRefreshed sync.Map
+	// 删除被修正版替代的旧种子, 为空时只在数据库中标记
+	remover TorrentRemover
+	// 海报缓存目录, 为空时使用数据目录下的 posters, 见 CachePoster
+	posterDir string
+	// 同一集挑选最佳版本的等待时间和升级的宽限期, 为 0 时不启用, 见 SetReleaseWindow
+	releaseWindow time.Duration
+	upgradeGrace  time.Duration
+	// FindNewBangumi 同时解析番剧的数量上限, 为 0 时使用 defaultResolveWorkers, 见 SetResolveConcurrency
+	resolveWorkers int
+	// 缺集搜索和手动搜索使用的站点, 见 SetSearchProviders
+	searchProviders []search.Provider
+	// notify 发送番剧和种子的通知, 测试时替换
+	notify func(ctx context.Context, event notification.NotifyEvent)
+	// 后台任务(如 ImportLibrary 的导入), shutdown 时等待它们结束, 超时后取消
+	background     errgroup.Group
+	stopCtx        context.Context
+	stopBackground context.CancelFunc
+}
+
+// New 创建 Refresher 实例, db 通常是 *database.DB
+func New(db Store) *Refresher {
+	r := &Refresher{db: db, notify: notification.NotificationClient.Notify}
+	r.stopCtx, r.stopBackground = context.WithCancel(context.Background())
+	return r
 }
 
-// New 创建 Refresher 实例
-func New(db *database.DB) *Refresher {
-	return &Refresher{db: db}
+// goBackground 在后台执行 f, ctx 被取消或 Refresher 关闭时 f 的 ctx 都会被取消
+func (r *Refresher) goBackground(ctx context.Context, f func(ctx context.Context) error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(r.stopCtx, cancel)
+	r.background.Go(func() error {
+		defer stop()
+		defer cancel()
+		return f(ctx)
+	})
 }
 
-func (r *Refresher) getTorrents(ctx context.Context, url string) []*model.Torrent {
+// shutdown 等待所有后台任务结束, 返回第一个失败的任务的错误
+// ctx 结束时取消还在运行的任务并返回 ctx 的错误, 此时不再等待它们
+func (r *Refresher) shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() { done <- r.background.Wait() }()
+	select {
+	case err := <-done:
+		r.stopBackground()
+		return err
+	case <-ctx.Done():
+		r.stopBackground()
+		return ctx.Err()
+	}
+}
+
+// fetchNewTorrents 拉取 RSS 并返回数据库中还没有的种子, 拉取或查询失败时返回错误
+func (r *Refresher) fetchNewTorrents(ctx context.Context, url string) ([]*model.Torrent, error) {
 	client := network.GetRequestClient()
-	torrents, _ := client.GetTorrents(ctx, url)
-	slog.Debug("[getTorrents]从 RSS 获取种子列表", "URL", url, "数量", len(torrents))
-	newTorrents, _ := r.db.CheckNewTorrents(ctx, torrents)
-	return newTorrents
+	torrents, err := client.GetTorrents(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("[fetchNewTorrents]从 RSS 获取种子列表", "URL", network.RedactURL(url), "数量", len(torrents))
+	return r.db.CheckNewTorrents(ctx, torrents)
 }
 
 // FindNewBangumi 从 rss 里面看看没有没新的番剧
-func (r *Refresher) FindNewBangumi(ctx context.Context, rssItem *model.RSSItem) {
+// 聚合订阅先按番剧把新种子分组, 每个番剧只解析一次, 见 groupByBangumi; 创建的番剧的 RSSLink 为这个订阅
+// 同一个标题的种子只需要解析成功一次, 见 resolveGroup; 最多同时解析 resolveWorkers 个标题, 见 SetResolveConcurrency
+// 开始创建每个番剧前检查 ctx, 取消时等待正在创建的完成后返回 ctx 的错误, 不会留下写了一半的番剧
+// 有番剧创建失败(包括还在退避中)时返回第一个失败的错误, 其余的番剧照常创建
+// 创建失败的种子记录为待匹配并带上失败原因, 见 parkUnresolved; 还在退避中或正在被其他刷新创建的不记录
+func (r *Refresher) FindNewBangumi(ctx context.Context, rssItem *model.RSSItem) error {
 	slog.Info("[FindNewBangumi]检查 RSS 是否有新的番剧", "RSS 名称", rssItem.Name)
 	netClient := network.GetRequestClient()
-	torrents, _ := netClient.GetTorrents(ctx, rssItem.Link)
+	torrents, err := netClient.GetFeedTorrents(ctx, rssItem.Link, rssItem.Source)
+	if err != nil {
+		return err
+	}
+	newTorrents := make([]*model.Torrent, 0, len(torrents))
 	for _, t := range torrents {
 		// 突然想起来, possess title 后,名字会和 torrent 里面的差很多,这时就会导致不停的创建
 		// 这就是之前 AB 会导致不停的创建的原因, 新在已经解决了
@@ -47,32 +113,211 @@ func (r *Refresher) FindNewBangumi(ctx context.Context, rssItem *model.RSSItem)
 		_, err := r.db.GetBangumiParseByTitle(ctx, t.Name)
 		// 没有找到, 说明是新的番剧
 		// 先过一下基础 filter
-		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		if err != nil && errors.Is(err, database.ErrNotFound) {
 			slog.Debug("[FindNewBangumi]没有找到番剧信息，可能是新的番剧", "种子名称", t.Name, "error", err)
-			if FilterTorrent(t, rssItem.ExcludeFilter, rssItem.IncludeFilter) {
-				// 要进行一个去重, 一些torrent 是没必要都解析的
-				// 进行 metaparser 解析
-				slog.Info("[FindNewBangumi]发现新的番剧", "种子名称", t.Name)
-				r.createBangumi(ctx, t, rssItem)
+			if FilterTorrent(t, rssItem.IncludeFilter, rssItem.ExcludeFilter) {
+				newTorrents = append(newTorrents, t)
+			}
+		}
+	}
+	if rssItem.IsAggregate() {
+		newTorrents = groupByBangumi(newTorrents)
+		slog.Debug("[FindNewBangumi]聚合订阅按番剧分组", "RSS 名称", rssItem.Name, "番剧数量", len(newTorrents))
+	}
+
+	// 不同标题同时解析, 数量受 resolveWorkers 限制, 避免同时大量请求 Mikan 和 TMDB
+	groups := groupByResolveKey(newTorrents)
+	errs := make([]error, len(groups))
+	var g errgroup.Group
+	g.SetLimit(r.resolveConcurrency())
+	for i, group := range groups {
+		g.Go(func() error {
+			errs[i] = r.resolveGroup(ctx, group, rssItem)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if err := ctx.Err(); err != nil {
+		slog.Info("[FindNewBangumi]检查被取消", "RSS 名称", rssItem.Name)
+		return err
+	}
+
+	var failed int
+	var firstErr error
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
 			}
+			r.parkUnresolved(ctx, groups[i], rssItem, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d 个番剧创建失败: %w", failed, firstErr)
+	}
+	return nil
+}
+
+// resolveGroup 为同一个标题的一组种子创建番剧, 返回创建失败的错误
+// 依次解析, 第一个成功后就停止; 失败时换下一个种子重试, 标题进入退避或正在被其他刷新创建时不再尝试
+func (r *Refresher) resolveGroup(ctx context.Context, group []*model.Torrent, rssItem *model.RSSItem) error {
+	var firstErr error
+	for _, t := range group {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// 进行 metaparser 解析
+		slog.Info("[FindNewBangumi]发现新的番剧", "种子名称", t.Name)
+		_, err := r.createBangumi(ctx, t, rssItem, true)
+		if err == nil {
+			return nil
 		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if errors.Is(err, ErrResolveBackoff) || errors.Is(err, ErrResolveInProgress) {
+			break
+		}
+	}
+	return firstErr
+}
+
+// parkUnresolved 把创建番剧失败的一组种子记录为待匹配, 用户可以看到为什么没有创建番剧并手动处理
+// 失败只是因为还在退避中或正在被其他刷新创建时不记录, 保留上一次真正失败的原因
+func (r *Refresher) parkUnresolved(ctx context.Context, group []*model.Torrent, rssItem *model.RSSItem, cause error) {
+	if errors.Is(cause, ErrResolveBackoff) || errors.Is(cause, ErrResolveInProgress) {
+		return
+	}
+	pending := make([]*model.PendingTorrent, len(group))
+	for i, t := range group {
+		pending[i] = newPendingTorrent(t, rssItem.Link, nil)
+		pending[i].ResolveError = cause.Error()
+	}
+	if err := r.db.ParkPendingTorrents(ctx, pending); err != nil {
+		slog.Warn("[FindNewBangumi]记录创建番剧失败的种子失败", "RSS 名称", rssItem.Name, "数量", len(pending), "error", err)
 	}
 }
 
-func (r *Refresher) RefreshRSS(ctx context.Context, url string, runner *taskrunner.TaskRunner) {
-	slog.Info("[RefreshRSS]刷新 RSS", "URL", url)
-	torrents := r.getTorrents(ctx, url)
+// RefreshRSS 拉取 RSS, 把匹配到番剧的新种子入库并入队
+// 匹配不到番剧的种子记录为待匹配, 已经记录过的失败次数加一; 创建新番剧后由 RetryPending 重试
+// 只有拉取 RSS 或保存种子失败时返回错误, 单个种子匹配不到番剧不算失败
+func (r *Refresher) RefreshRSS(ctx context.Context, url string, runner *taskrunner.TaskRunner) error {
+	_, _, err := r.refreshRSS(ctx, url, runner)
+	return err
+}
+
+// refreshRSS 同 RefreshRSS, 返回 RSS 中的新种子数量和其中匹配不到番剧的数量
+func (r *Refresher) refreshRSS(ctx context.Context, url string, runner *taskrunner.TaskRunner) (found, unmatchedCount int, err error) {
+	slog.Info("[RefreshRSS]刷新 RSS", "URL", network.RedactURL(url))
+	torrents, err := r.fetchNewTorrents(ctx, url)
+	if err != nil {
+		slog.Error("[RefreshRSS]拉取 RSS 失败", "URL", network.RedactURL(url), "error", err)
+		return 0, 0, err
+	}
 	slog.Debug("[RefreshRSS]获取种子列表", "数量", len(torrents))
+	unmatched, err := r.enqueueTorrents(ctx, torrents, "RSS: "+url, runner)
+	if err != nil {
+		slog.Error("[RefreshRSS]保存种子失败", "URL", network.RedactURL(url), "error", err)
+		return len(torrents), 0, err
+	}
+	pending := make([]*model.PendingTorrent, 0, len(unmatched))
+	for _, u := range unmatched {
+		pending = append(pending, newPendingTorrent(u.torrent, url, u.err))
+	}
+	if err := r.db.AddPendingTorrents(ctx, pending); err != nil {
+		slog.Warn("[RefreshRSS]记录待匹配种子失败", "URL", network.RedactURL(url), "数量", len(pending), "error", err)
+	}
+	return len(torrents), len(unmatched), nil
+}
+
+// unmatchedTorrent 匹配不到番剧的种子和原因
+type unmatchedTorrent struct {
+	torrent *model.Torrent
+	err     error
+}
+
+// enqueueTorrents 为种子匹配番剧, 把需要下载的种子入库并入队, source 记录在下载历史中
+// 返回匹配不到番剧的种子; 番剧已完结、已删除或被过滤的种子直接跳过, 不算匹配不到
+func (r *Refresher) enqueueTorrents(ctx context.Context, torrents []*model.Torrent, source string, runner *taskrunner.TaskRunner) ([]unmatchedTorrent, error) {
+	var unmatched []unmatchedTorrent
+	matched := make([]*model.Torrent, 0, len(torrents))
 	for _, t := range torrents {
-		metaData, err := r.db.GetBangumiParseByTitle(ctx, t.Name)
+		metaData, err := r.matchBangumi(ctx, t)
 		slog.Debug("[RefreshRSS]检查番剧信息", "种子名称", t.Name, "error", err)
 		if err != nil {
+			unmatched = append(unmatched, unmatchedTorrent{torrent: t, err: err})
+			continue
+		}
+		// 聚合订阅里已完结的番剧不再下载, 重新激活后恢复
+		if metaData.Completed {
+			slog.Debug("[RefreshRSS]番剧已完结, 跳过", "种子名称", t.Name, "番剧", metaData.OfficialTitle)
+			continue
+		}
+		// 回收站中的番剧不再下载, 恢复后继续
+		if metaData.Deleted {
+			slog.Debug("[RefreshRSS]番剧已删除, 跳过", "种子名称", t.Name, "番剧", metaData.OfficialTitle)
 			continue
 		}
-		if FilterTorrent(t, metaData.IncludeFilter, metaData.ExcludeFilter) {
+		// 集数偏移没有设置时检查种子是否使用绝对集数, 之后按番剧的偏移计算集数
+		// 番剧的偏移属于主季度, 其他季度的种子使用这一季自己的偏移
+		if view := r.seasonBangumi(ctx, metaData, t); view != metaData {
+			metaData = view
+		} else {
+			r.autoOffset(ctx, metaData, episodeRange(t.Name, 0))
+		}
+		if FilterBangumiTorrent(t, metaData) {
 			t.Bangumi = metaData
-			_ = r.db.CreateTorrent(ctx, t)
-			runner.Submit(model.NewAddTask(t, t.Bangumi))
+			matched = append(matched, t)
 		}
 	}
+	if err := r.submitTorrents(ctx, matched, source, runner); err != nil {
+		return nil, err
+	}
+	return unmatched, nil
+}
+
+// submitTorrents 把已经关联番剧(t.Bangumi)并通过过滤的种子入库并入队
+// 已有更高版本的种子标记为已替代后入库, 收集模式下合集已覆盖的单集跳过, 番剧不要合集时跳过合集
+// 启用挑选窗口时种子入库后等待, 不立即入队, 见 selectRelease
+func (r *Refresher) submitTorrents(ctx context.Context, matched []*model.Torrent, source string, runner *taskrunner.TaskRunner) error {
+	sortCollectionsFirst(matched)
+	// 先判断完所有种子, 一次性写入数据库后再入队
+	pending := make([]*model.Torrent, 0, len(matched))
+	for _, t := range matched {
+		markBatch(t)
+		if !batchAllowed(t, t.Bangumi) {
+			slog.Debug("[RefreshRSS]番剧不下载合集, 跳过", "种子名称", t.Name, "番剧", t.Bangumi.OfficialTitle)
+			continue
+		}
+		// 已有更高版本时也入库, 避免下次刷新重复判断
+		if !r.checkRevision(ctx, t, t.Bangumi, pending) {
+			t.Downloaded = model.DownloadReplaced
+			pending = append(pending, t)
+			continue
+		}
+		if !r.checkEpsCollect(ctx, t, t.Bangumi) {
+			continue
+		}
+		r.selectRelease(ctx, t, pending, time.Now())
+		pending = append(pending, t)
+	}
+	if err := r.db.CreateTorrents(ctx, pending); err != nil {
+		return err
+	}
+	for _, t := range pending {
+		if t.Downloaded == model.DownloadReplaced || t.Downloaded == model.DownloadHeld {
+			continue
+		}
+		r.enqueue(ctx, t, source, runner)
+	}
+	return nil
+}
+
+// enqueue 已经入库的种子提交给 runner, 成功时记录下载历史并发送通知
+func (r *Refresher) enqueue(ctx context.Context, t *model.Torrent, source string, runner *taskrunner.TaskRunner) {
+	if runner.Submit(model.NewAddTask(t, t.Bangumi)) {
+		r.markQueued(ctx, t, t.Bangumi, source)
+		r.notify(ctx, notification.NewTorrentEvent(notification.EventEpisodeEnqueued, t, t.Bangumi))
+	}
 }
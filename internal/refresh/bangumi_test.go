@@ -14,6 +14,7 @@ import (
 
 // TestFindNewBangumi_NormalFlow 测试 FindNewBangumi 的正常流程
 func TestFindNewBangumi_NormalFlow(t *testing.T) {
+	ctx := context.Background()
 	t.Parallel()
 	// 创建内存数据库
 	memoryDB := ":memory:"
@@ -35,7 +36,7 @@ func TestFindNewBangumi_NormalFlow(t *testing.T) {
 	time.Sleep(1 * time.Second)
 
 	// 验证创建的番剧
-	finalBangumis, err := db.ListBangumi()
+	finalBangumis, err := db.ListBangumi(ctx)
 	if err != nil {
 		t.Fatalf("查询番剧列表失败: %v", err)
 	}
@@ -77,8 +78,8 @@ func TestFindNewBangumi_NormalFlow(t *testing.T) {
 	}
 }
 
-// TestGetTorrents 测试 getTorrents 函数
-func TestGetTorrents(t *testing.T) {
+// TestFetchNewTorrents 测试 fetchNewTorrents 函数
+func TestFetchNewTorrents(t *testing.T) {
 	t.Parallel()
 	// 初始化内存数据库
 	memoryDB := ":memory:"
@@ -90,7 +91,10 @@ func TestGetTorrents(t *testing.T) {
 
 	rssURL := "https://mikanani.me/RSS/MyBangumi?token=test"
 	r := New(db)
-	torrents := r.getTorrents(context.Background(), rssURL)
+	torrents, err := r.fetchNewTorrents(context.Background(), rssURL)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// 验证返回的种子数量
 	if len(torrents) == 0 {
@@ -111,8 +115,8 @@ func TestGetTorrents(t *testing.T) {
 	}
 }
 
-// TestGetTorrents_WithExisting 测试 getTorrents 过滤已存在种子
-func TestGetTorrents_WithExisting(t *testing.T) {
+// TestFetchNewTorrents_WithExisting 测试 fetchNewTorrents 过滤已存在种子
+func TestFetchNewTorrents_WithExisting(t *testing.T) {
 	t.Parallel()
 	memoryDB := ":memory:"
 	db, err := database.NewDB(&memoryDB)
@@ -127,7 +131,10 @@ func TestGetTorrents_WithExisting(t *testing.T) {
 
 	r := New(db)
 	// 第一次获取
-	firstTorrents := r.getTorrents(ctx, rssURL)
+	firstTorrents, err := r.fetchNewTorrents(ctx, rssURL)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(firstTorrents) == 0 {
 		t.Fatal("第一次获取种子失败")
 	}
@@ -137,7 +144,10 @@ func TestGetTorrents_WithExisting(t *testing.T) {
 	db.CreateTorrent(ctx, firstTorrents[0])
 
 	// 第二次获取，应该少一个
-	secondTorrents := r.getTorrents(ctx, rssURL)
+	secondTorrents, err := r.fetchNewTorrents(ctx, rssURL)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(secondTorrents) != len(firstTorrents)-1 {
 		t.Errorf("期望 %d 个种子，实际 %d 个", len(firstTorrents)-1, len(secondTorrents))
 	}
@@ -184,7 +194,7 @@ func TestRefreshRSS(t *testing.T) {
 	r.FindNewBangumi(ctx, rssItem)
 
 	// 验证 bangumi 已创建
-	bangumis, err := db.ListBangumi()
+	bangumis, err := db.ListBangumi(ctx)
 	if err != nil {
 		t.Fatalf("查询番剧列表失败: %v", err)
 	}
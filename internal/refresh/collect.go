@@ -0,0 +1,94 @@
+package refresh
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+// 收集模式(Bangumi.EpsCollect): 番剧只想要整季的合集
+// 同一次刷新里合集排在单集前面先入队; 已经下载完成的合集覆盖了 TMDB 的全部集数后, 单集不再入队
+// 合集还在下载时单集照常入队, 合集下载失败或一直下载不完也不会漏掉集数
+
+// isCollection 种子是否为合集
+func isCollection(name string) bool {
+	return parser.NewTitleMetaParse().Parse(name).Collection
+}
+
+// markBatch 从种子名解析是否为合集和覆盖的集数, 记录在种子上
+func markBatch(t *model.Torrent) {
+	meta := parser.NewTitleMetaParse().Parse(t.Name)
+	t.Batch = meta.Collection
+	if t.Batch {
+		t.EpisodeStart, t.EpisodeEnd = meta.EpisodeStart, meta.EpisodeEnd
+	}
+}
+
+// batchAllowed 番剧是否下载这个合集: 下载偏好不要合集(SkipBatch)时跳过, 收集模式的番剧总是要合集
+func batchAllowed(t *model.Torrent, bangumi *model.Bangumi) bool {
+	return !t.Batch || bangumi == nil || bangumi.EpsCollect || !bangumi.SkipBatch
+}
+
+// sortCollectionsFirst 把收集模式番剧的合集排到前面, 其余种子保持原有顺序
+func sortCollectionsFirst(torrents []*model.Torrent) {
+	rank := make(map[*model.Torrent]int, len(torrents))
+	for _, t := range torrents {
+		if t.Bangumi != nil && t.Bangumi.EpsCollect && isCollection(t.Name) {
+			rank[t] = -1
+		}
+	}
+	slices.SortStableFunc(torrents, func(a, b *model.Torrent) int {
+		return cmp.Compare(rank[a], rank[b])
+	})
+}
+
+// collectionComplete 番剧已经下载完成的合集是否覆盖了全部集数
+// 只算下载完成的合集, 已入队或正在下载的合集可能失败, 不能挡住单集
+// TMDB 没有总集数时无法判断, 返回 false
+func (r *Refresher) collectionComplete(ctx context.Context, bangumi *model.Bangumi) bool {
+	tmdbItem := bangumi.TmdbItem
+	if tmdbItem == nil && bangumi.TmdbID != nil {
+		var err error
+		if tmdbItem, err = r.db.GetTmdbItemByID(ctx, *bangumi.TmdbID); err != nil {
+			slog.Warn("[collectionComplete] 获取 TMDB 信息失败", "番剧", bangumi.OfficialTitle, "error", err)
+		}
+	}
+	if tmdbItem == nil || tmdbItem.EpisodeCount <= 0 {
+		return false
+	}
+	torrents, err := r.db.ListTorrentByBangumiID(ctx, bangumi.ID)
+	if err != nil {
+		return false
+	}
+	covered := make(map[int]struct{})
+	for _, t := range torrents {
+		if t.Downloaded != model.DownloadDone || !isCollection(t.Name) {
+			continue
+		}
+		for _, ep := range episodeRange(t.Name, bangumi.Offset) {
+			covered[ep] = struct{}{}
+		}
+	}
+	for ep := 1; ep <= tmdbItem.EpisodeCount; ep++ {
+		if _, ok := covered[ep]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// checkEpsCollect 收集模式下判断种子是否还需要下载
+func (r *Refresher) checkEpsCollect(ctx context.Context, torrent *model.Torrent, bangumi *model.Bangumi) bool {
+	if !bangumi.EpsCollect || isCollection(torrent.Name) {
+		return true
+	}
+	if r.collectionComplete(ctx, bangumi) {
+		slog.Debug("[checkEpsCollect] 合集已覆盖全部集数, 跳过单集", "种子名称", torrent.Name, "番剧", bangumi.OfficialTitle)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// collectRSS 生成只有 enclosure 的订阅, 不需要 mikan 页面
+func collectRSS(names ...string) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><rss version="2.0"><channel>`)
+	for i, name := range names {
+		fmt.Fprintf(&b, `<item><title>%s</title><enclosure type="application/x-bittorrent" length="1" url="magnet:?xt=urn:btih:COLLECT%02d" /></item>`, name, i)
+	}
+	b.WriteString(`</channel></rss>`)
+	return []byte(b.String())
+}
+
+// TestRefreshRSS_EpsCollect 收集模式下合集优先入队, 合集下载完成并覆盖全部集数后单集不再入队
+func TestRefreshRSS_EpsCollect(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{
+		OfficialTitle: "败犬女主太多了！",
+		Season:        1,
+		EpsCollect:    true,
+		TmdbItem:      &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！", EpisodeCount: 12},
+	}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse", BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	single11 := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	batch := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! [01-12合集][WebRip 1080p HEVC-10bit AAC][简繁内封字幕][Fin]"
+	single12 := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583&collect=1"
+	network.SetTestCache(rssURL, collectRSS(single11, batch))
+	defer network.ClearTestCache(rssURL)
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	r := New(db)
+	r.RefreshRSS(ctx, rssURL, runner)
+
+	stored := func() []string {
+		var torrents []*model.Torrent
+		if err := db.Find(&torrents).Error; err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(torrents))
+		for _, torrent := range torrents {
+			names = append(names, torrent.Name)
+		}
+		return names
+	}
+	// 合集还在下载, 不算覆盖, 同一次刷新中的单集照常入队
+	if got := stored(); len(got) != 2 || got[0] != batch {
+		t.Fatalf("stored torrents = %q, want the batch first and the single", got)
+	}
+
+	// 合集下载完成后, 之后出现的单集不再入队
+	if err := db.Model(&model.Torrent{}).Where("name = ?", batch).Update("downloaded", model.DownloadDone).Error; err != nil {
+		t.Fatal(err)
+	}
+	network.SetTestCache(rssURL, collectRSS(single11, batch, single12))
+	r.RefreshRSS(ctx, rssURL, runner)
+	if got := stored(); len(got) != 2 {
+		t.Errorf("stored torrents = %q, want the batch and the first single", got)
+	}
+
+	// 合集出错后不再算覆盖, 单集恢复入队
+	if err := db.Model(&model.Torrent{}).Where("name = ?", batch).Update("downloaded", model.DownloadError).Error; err != nil {
+		t.Fatal(err)
+	}
+	r.RefreshRSS(ctx, rssURL, runner)
+	if got := stored(); len(got) != 3 {
+		t.Errorf("stored torrents = %q, want batch and two singles", got)
+	}
+}
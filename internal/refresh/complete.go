@@ -0,0 +1,64 @@
+package refresh
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+// completedGrace 按周更估算的最后一集播出后再等待的时间, 留给字幕组发布修正版
+const completedGrace = 14 * 24 * time.Hour
+
+// seasonEnded 根据 TMDB 的季度首播日期和总集数估算季度是否已经播完
+// TMDB 只有首播日期, 按每周一集估算最后一集的播出时间
+func seasonEnded(item *model.TmdbItem, now time.Time) bool {
+	if item == nil || item.EpisodeCount <= 0 || item.AirDate == "" {
+		return false
+	}
+	airDate, err := time.Parse("2006-01-02", item.AirDate)
+	if err != nil {
+		return false
+	}
+	lastEpisode := airDate.AddDate(0, 0, 7*(item.EpisodeCount-1))
+	return now.After(lastEpisode.Add(completedGrace))
+}
+
+// progressComplete 正片 1 到总集数是否都已经下载完成
+func progressComplete(p *BangumiProgress) bool {
+	if p.Total <= 0 || len(p.Have) == 0 {
+		return false
+	}
+	return len(p.Missing) == 0 && len(p.Downloading) == 0 && p.Have[len(p.Have)-1] >= p.Total
+}
+
+// UpdateCompleted 检查未完结的番剧, 季度已经播完且全部集数已下载的标记为已完结
+// 返回本次标记的番剧, 已完结的番剧之后的刷新会跳过, 需要时通过 ReactivateBangumi 恢复
+func (r *Refresher) UpdateCompleted(ctx context.Context) ([]*model.Bangumi, error) {
+	bangumis, err := r.db.ListIncompleteBangumi(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var completed []*model.Bangumi
+	for _, b := range bangumis {
+		if !seasonEnded(b.TmdbItem, now) {
+			continue
+		}
+		progress, err := r.bangumiProgress(ctx, b)
+		if err != nil {
+			return completed, err
+		}
+		if !progressComplete(progress) {
+			continue
+		}
+		if err := r.db.MarkBangumiCompleted(ctx, b.ID); err != nil {
+			return completed, err
+		}
+		b.Completed = true
+		slog.Info("[UpdateCompleted] 番剧已完结, 停止刷新", "番剧", b.OfficialTitle, "总集数", progress.Total)
+		completed = append(completed, b)
+	}
+	return completed, nil
+}
@@ -0,0 +1,134 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/taskrunner"
+)
+
+func TestSeasonEnded(t *testing.T) {
+	now := time.Date(2024, 10, 20, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		item *model.TmdbItem
+		want bool
+	}{
+		{"没有 TMDB 信息", nil, false},
+		{"没有总集数", &model.TmdbItem{AirDate: "2024-07-13"}, false},
+		{"已播完", &model.TmdbItem{AirDate: "2024-07-13", EpisodeCount: 12}, true},
+		{"最后一集刚播出", &model.TmdbItem{AirDate: "2024-07-27", EpisodeCount: 12}, false},
+		{"还在播出", &model.TmdbItem{AirDate: "2024-08-01", EpisodeCount: 12}, false},
+		{"日期无效", &model.TmdbItem{AirDate: "unknown", EpisodeCount: 12}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seasonEnded(tt.item, now); got != tt.want {
+				t.Errorf("seasonEnded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestUpdateCompleted 播完且下载齐全的番剧被标记完结, 之后的刷新跳过, 重新激活后恢复
+func TestUpdateCompleted(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583&completed=1"
+	finished := &model.Bangumi{
+		OfficialTitle: "败犬女主太多了！",
+		Season:        1,
+		RSSLink:       rssURL,
+		TmdbItem:      &model.TmdbItem{ID: 241535, Title: "败犬女主太多了！", EpisodeCount: 3, AirDate: "2024-07-13"},
+	}
+	airing := &model.Bangumi{
+		OfficialTitle: "夏日口袋",
+		Season:        1,
+		TmdbItem:      &model.TmdbItem{ID: 1, Title: "夏日口袋", EpisodeCount: 3, AirDate: time.Now().Format("2006-01-02")},
+	}
+	for _, b := range []*model.Bangumi{finished, airing} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse", BangumiID: finished.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := func(ep string) string {
+		return "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - " + ep + " [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	}
+	var torrents []*model.Torrent
+	for _, ep := range []string{"01", "02", "03"} {
+		torrents = append(torrents,
+			&model.Torrent{Link: "magnet:?xt=urn:btih:MAKEINE" + ep, Name: name(ep), BangumiID: finished.ID, Downloaded: model.DownloadDone},
+			&model.Torrent{Link: "magnet:?xt=urn:btih:SUMMER" + ep, Name: "[LoliHouse] 夏日口袋 - " + ep + " [1080p]", BangumiID: airing.ID, Downloaded: model.DownloadDone},
+		)
+	}
+	if err := db.CreateTorrents(ctx, torrents); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(db)
+	completed, err := r.UpdateCompleted(ctx)
+	if err != nil {
+		t.Fatalf("UpdateCompleted() error = %v", err)
+	}
+	if len(completed) != 1 || completed[0].ID != finished.ID {
+		t.Fatalf("UpdateCompleted() = %v, want only the finished bangumi", completed)
+	}
+	if ok, err := db.IsRSSCompleted(ctx, rssURL); err != nil || !ok {
+		t.Errorf("IsRSSCompleted() = %v, %v, want true", ok, err)
+	}
+
+	submitted := make(chan *model.Task, 4)
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		submitted <- task
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	network.SetTestCache(rssURL, collectRSS(name("03v2")))
+	defer network.ClearTestCache(rssURL)
+
+	// 已完结的番剧不再入队
+	r.RefreshRSS(ctx, rssURL, runner)
+	select {
+	case task := <-submitted:
+		t.Fatalf("completed bangumi enqueued %s", task.Torrent.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := db.ReactivateBangumi(ctx, finished.ID); err != nil {
+		t.Fatalf("ReactivateBangumi() error = %v", err)
+	}
+	if ok, _ := db.IsRSSCompleted(ctx, rssURL); ok {
+		t.Error("IsRSSCompleted() = true after reactivation")
+	}
+	r.RefreshRSS(ctx, rssURL, runner)
+	select {
+	case task := <-submitted:
+		if task.Torrent.Name != name("03v2") {
+			t.Errorf("enqueued %s, want the v2 release", task.Torrent.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reactivated bangumi was not refreshed")
+	}
+
+	if err := db.ReactivateBangumi(ctx, 9999); err == nil {
+		t.Error("ReactivateBangumi() on unknown bangumi error = nil")
+	}
+}
@@ -0,0 +1,57 @@
+package refresh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+)
+
+// FeedCheck 条件拉取订阅的结果
+type FeedCheck struct {
+	// Changed 订阅内容是否有变化, 服务器返回 304 或内容哈希和上次相同时为 false
+	Changed bool
+
+	rss          *model.RSSItem
+	etag         string
+	lastModified string
+	hash         string
+}
+
+// CheckFeed 带上次保存的 ETag/Last-Modified 条件拉取订阅, 判断内容是否有变化
+// 拉取到的内容会进入网络缓存, 紧接着的 FindNewBangumi 和 RefreshRSS 不会再次请求
+func (r *Refresher) CheckFeed(ctx context.Context, rss *model.RSSItem) (*FeedCheck, error) {
+	resp, err := network.GetRequestClient().GetConditional(ctx, rss.Link, rss.ETag, rss.LastModified)
+	if err != nil {
+		return nil, err
+	}
+	check := &FeedCheck{rss: rss, etag: resp.ETag, lastModified: resp.LastModified, hash: rss.ContentHash}
+	if resp.NotModified {
+		slog.Debug("[CheckFeed] 订阅没有变化(304)", "名称", rss.Name)
+		return check, nil
+	}
+	sum := sha256.Sum256(resp.Body)
+	check.hash = hex.EncodeToString(sum[:])
+	check.Changed = check.hash != rss.ContentHash
+	if !check.Changed {
+		slog.Debug("[CheckFeed] 订阅内容哈希没有变化", "名称", rss.Name)
+	}
+	return check, nil
+}
+
+// SaveFeedCheck 保存这次拉取的校验信息, 下次拉取时据此判断订阅是否变化
+// 只应该在这次的内容被完全处理之后调用, 否则没处理完的种子要等到订阅变化才会重试
+func (r *Refresher) SaveFeedCheck(ctx context.Context, check *FeedCheck) error {
+	rss := check.rss
+	if check.etag == rss.ETag && check.lastModified == rss.LastModified && check.hash == rss.ContentHash {
+		return nil
+	}
+	if err := r.db.SetRSSFeedState(ctx, rss.ID, check.etag, check.lastModified, check.hash); err != nil {
+		return err
+	}
+	rss.ETag, rss.LastModified, rss.ContentHash = check.etag, check.lastModified, check.hash
+	return nil
+}
@@ -0,0 +1,54 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+)
+
+// TestCheckFeed 保存校验信息后内容不变的订阅被跳过, 内容变化后重新处理
+func TestCheckFeed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+	r := New(db)
+
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583&check=1"
+	network.SetTestCache(rssURL, collectRSS("[LoliHouse] 败犬女主太多了！ - 01 [1080p]"))
+	defer network.ClearTestCache(rssURL)
+	rss := &model.RSSItem{Name: "败犬女主", Link: rssURL, Enabled: true}
+	if err := db.CreateRSS(ctx, rss); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := r.CheckFeed(ctx, rss)
+	if err != nil {
+		t.Fatalf("CheckFeed() error = %v", err)
+	}
+	if !check.Changed {
+		t.Fatal("first CheckFeed() Changed = false, want true")
+	}
+	// 没有保存之前, 同样的内容仍然需要处理
+	if check, _ := r.CheckFeed(ctx, rss); !check.Changed {
+		t.Error("CheckFeed() before save Changed = false, want true")
+	}
+	if err := r.SaveFeedCheck(ctx, check); err != nil {
+		t.Fatalf("SaveFeedCheck() error = %v", err)
+	}
+	saved, _ := db.GetRSSByID(ctx, rss.ID)
+	if saved.ContentHash == "" || saved.ContentHash != rss.ContentHash {
+		t.Fatalf("saved hash = %q, want %q", saved.ContentHash, rss.ContentHash)
+	}
+
+	if check, err := r.CheckFeed(ctx, saved); err != nil || check.Changed {
+		t.Errorf("CheckFeed() after save = %+v, %v, want unchanged", check, err)
+	}
+
+	network.SetTestCache(rssURL, collectRSS("[LoliHouse] 败犬女主太多了！ - 02 [1080p]"))
+	if check, err := r.CheckFeed(ctx, saved); err != nil || !check.Changed {
+		t.Errorf("CheckFeed() after update = %+v, %v, want changed", check, err)
+	}
+}
@@ -0,0 +1,36 @@
+package refresh
+
+import (
+	"context"
+
+	"goto-bangumi/internal/filter"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+)
+
+// ExplainFilter 试运行番剧的过滤规则: 拉取番剧的 RSS, 返回每个种子是否通过以及没有通过的原因
+// rules 不为 nil 时改用它作为番剧的规则(还没有保存), 用于修改规则前预览效果; 不会修改数据库
+func (r *Refresher) ExplainFilter(ctx context.Context, bangumiID int, rules *model.FilterRules) ([]filter.Result, error) {
+	bangumi, err := r.db.GetBangumiByID(ctx, bangumiID)
+	if err != nil {
+		return nil, err
+	}
+	if rules != nil {
+		preview := *bangumi
+		preview.SetFilterRules(*rules)
+		bangumi = &preview
+	}
+	if bangumi.RSSLink == "" {
+		return nil, nil
+	}
+	torrents, err := network.GetRequestClient().GetTorrents(ctx, bangumi.RSSLink)
+	if err != nil {
+		return nil, err
+	}
+	effective := filter.ForBangumi(bangumi)
+	results := make([]filter.Result, 0, len(torrents))
+	for _, t := range torrents {
+		results = append(results, filter.Evaluate(t, effective))
+	}
+	return results, nil
+}
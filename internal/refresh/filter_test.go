@@ -0,0 +1,78 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/filter"
+	"goto-bangumi/internal/model"
+)
+
+// TestExplainFilter 试运行番剧的过滤规则, 预览的规则不保存, 保存后按保存的规则过滤
+// RSS 源: https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=370 (1080p, 1 条合集 + 12 集单集)
+func TestExplainFilter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=370"
+	r := New(db)
+	if err := r.FindNewBangumi(ctx, &model.RSSItem{Name: "败犬女主太多了！", Link: rssURL}); err != nil {
+		t.Fatal(err)
+	}
+	bangumis, err := db.ListBangumi(ctx)
+	if err != nil || len(bangumis) != 1 {
+		t.Fatalf("期望创建 1 个番剧, 实际 %d 个, err: %v", len(bangumis), err)
+	}
+	id := bangumis[0].ID
+
+	results, err := r.ExplainFilter(ctx, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 13 {
+		t.Fatalf("期望 13 个结果, 实际 %d 个", len(results))
+	}
+	for _, res := range results {
+		if !res.Accepted {
+			t.Errorf("%s 被过滤: %s", res.Name, res.Reason())
+		}
+	}
+
+	preview := &model.FilterRules{
+		Exclude:    []string{"合集"},
+		Resolution: model.FieldRule{Include: []string{"720p"}},
+	}
+	results, err = r.ExplainFilter(ctx, id, preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Accepted || res.Rejections[len(res.Rejections)-1].Rule != filter.RuleResolution {
+			t.Errorf("%s 的结果 = %+v, 期望被分辨率规则过滤", res.Name, res)
+		}
+	}
+	if saved, _ := db.GetBangumiByID(ctx, id); saved.FilterOverride != "" || saved.ExcludeFilter != bangumis[0].ExcludeFilter {
+		t.Errorf("试运行修改了番剧: %+v", saved)
+	}
+
+	if err := db.SetBangumiFilterRules(ctx, id, model.FilterRules{Exclude: []string{"合集"}}); err != nil {
+		t.Fatal(err)
+	}
+	results, err = r.ExplainFilter(ctx, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rejected int
+	for _, res := range results {
+		if !res.Accepted {
+			rejected++
+			if res.Rejections[0].Rule != filter.RuleExclude {
+				t.Errorf("%s 被 %s 过滤, 期望 %s", res.Name, res.Rejections[0].Rule, filter.RuleExclude)
+			}
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("期望过滤 1 个合集, 实际 %d 个", rejected)
+	}
+}
@@ -0,0 +1,115 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// 缺集检测: 已有集数中间的空缺(例如有 1-5 和 7, 缺 6), 季度播完后还包括最新一集到 TMDB 总集数之间的集数
+// 定时在番剧的 RSS 和 Mikan 番剧页面的 RSS 中重新搜索, 找到的入队; 没找到的在剧集表中记录搜索次数
+
+// GapEpisode 搜索后仍然没有找到的一集
+type GapEpisode struct {
+	BangumiID int
+	Title     string
+	Season    int
+	Episode   int
+	Attempts  int // 累计搜索次数
+}
+
+// gapEpisodes 番剧需要重新搜索的集数
+func gapEpisodes(bangumi *model.Bangumi, progress *BangumiProgress, now time.Time) []int {
+	if len(progress.Have) == 0 {
+		return nil
+	}
+	eps := append([]int(nil), progress.Missing...)
+	if seasonEnded(bangumi.TmdbItem, now) {
+		for ep := progress.Latest + 1; ep <= progress.Total; ep++ {
+			if !slices.Contains(progress.Downloading, ep) {
+				eps = append(eps, ep)
+			}
+		}
+	}
+	return eps
+}
+
+// SearchGaps 为所有未完结番剧重新搜索缺失的集数, 返回入队的种子数量和仍然没有找到的集数
+// 还没有任何集数的番剧不检测, 它们由正常的刷新和补全处理
+// 单个番剧失败时继续处理其他番剧, 最后返回失败的数量
+func (r *Refresher) SearchGaps(ctx context.Context, runner *taskrunner.TaskRunner) (int, []GapEpisode, error) {
+	bangumis, err := r.db.ListBangumiWithDetails(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	now := time.Now()
+	found := 0
+	var missing []GapEpisode
+	var failed int
+	var lastErr error
+	for _, b := range bangumis {
+		if ctx.Err() != nil {
+			return found, missing, ctx.Err()
+		}
+		if b.Deleted || b.Completed {
+			continue
+		}
+		n, gaps, err := r.searchBangumiGaps(ctx, b, runner, now)
+		found += n
+		missing = append(missing, gaps...)
+		if err != nil {
+			slog.Warn("[SearchGaps] 搜索缺集失败", "番剧", b.OfficialTitle, "error", err)
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		return found, missing, fmt.Errorf("%d 个番剧搜索缺集失败: %w", failed, lastErr)
+	}
+	return found, missing, nil
+}
+
+// searchBangumiGaps 搜索一个番剧的缺集, 没有找到的集数搜索次数加一
+func (r *Refresher) searchBangumiGaps(ctx context.Context, bangumi *model.Bangumi, runner *taskrunner.TaskRunner, now time.Time) (int, []GapEpisode, error) {
+	progress, err := r.bangumiProgress(ctx, bangumi)
+	if err != nil {
+		return 0, nil, err
+	}
+	eps := gapEpisodes(bangumi, progress, now)
+	if len(eps) == 0 {
+		return 0, nil, nil
+	}
+	torrents, err := r.searchEpisodes(ctx, bangumi, eps)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := r.submitTorrents(ctx, torrents, "缺集搜索", runner); err != nil {
+		return 0, nil, err
+	}
+	got := make(map[int]bool, len(torrents))
+	for _, t := range torrents {
+		for _, ep := range episodeRange(t.Name, bangumi.Offset) {
+			got[ep] = true
+		}
+	}
+	var missing []GapEpisode
+	for _, ep := range eps {
+		if got[ep] {
+			continue
+		}
+		attempts, err := r.db.RecordEpisodeSearch(ctx, bangumi.ID, bangumi.Season, ep)
+		if err != nil {
+			return len(torrents), missing, err
+		}
+		missing = append(missing, GapEpisode{BangumiID: bangumi.ID, Title: bangumi.OfficialTitle, Season: bangumi.Season, Episode: ep, Attempts: attempts})
+	}
+	if len(torrents) > 0 {
+		slog.Info("[SearchGaps] 找到缺失的集数", "番剧", bangumi.OfficialTitle, "缺失", eps, "找到", len(torrents))
+	}
+	return len(torrents), missing, nil
+}
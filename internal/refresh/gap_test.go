@@ -0,0 +1,140 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/search"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// TestSearchGaps 有 1-5 和 7 时重新搜索第 6 集, 没找到时累计搜索次数, RSS 里出现后入队
+func TestSearchGaps(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	bangumi := &model.Bangumi{
+		OfficialTitle:   "药屋少女的呢喃",
+		Season:          1,
+		MikanItem:       &model.MikanItem{ID: 992061, OfficialTitle: "药屋少女的呢喃"},
+		EpisodeMetadata: []model.EpisodeMetadata{{Title: "Kusuriya no Hitorigoto", Group: "LoliHouse", Resolution: "1080p"}},
+	}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	title := "[LoliHouse] 药屋少女的呢喃 / Kusuriya no Hitorigoto - %02d [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	for _, ep := range []int{1, 2, 3, 4, 5, 7} {
+		name := fmt.Sprintf(title, ep)
+		if err := db.CreateTorrent(ctx, &model.Torrent{Name: name, Link: fmt.Sprintf("magnet:?xt=urn:btih:HAVE%02d", ep), BangumiID: bangumi.ID, Downloaded: model.DownloadDone}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	rssURL := parser.MikanBangumiRSS(992061)
+	defer network.ClearTestCache(rssURL)
+	r := New(db)
+
+	// RSS 里还没有第 6 集
+	network.SetTestCache(rssURL, collectRSS(fmt.Sprintf(title, 8)))
+	for want := 1; want <= 2; want++ {
+		found, missing, err := r.SearchGaps(ctx, runner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != 0 || len(missing) != 1 || missing[0].Episode != 6 || missing[0].Attempts != want {
+			t.Fatalf("SearchGaps() = %d, %+v, want episode 6 missing after %d attempts", found, missing, want)
+		}
+	}
+
+	network.SetTestCache(rssURL, collectRSS(fmt.Sprintf(title, 6)))
+	found, missing, err := r.SearchGaps(ctx, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 1 || len(missing) != 0 {
+		t.Fatalf("SearchGaps() = %d, %+v, want episode 6 found", found, missing)
+	}
+	progress, err := r.GetBangumiProgress(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3, 4, 5, 7}; !slices.Equal(progress.Have, want) || !slices.Equal(progress.Downloading, []int{6}) {
+		t.Errorf("Have = %v, Downloading = %v, want %v and [6]", progress.Have, progress.Downloading, want)
+	}
+}
+
+// stubProvider 返回固定结果的搜索站点, 记录搜索的关键字
+type stubProvider struct {
+	keywords []string
+	results  []search.Result
+}
+
+func (p *stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) Search(ctx context.Context, keyword string) ([]search.Result, error) {
+	p.keywords = append(p.keywords, keyword)
+	return p.results, nil
+}
+
+// TestSearchGapsProvider 番剧的 RSS 里没有的集数在搜索站点中按标题搜索, 只要匹配到这个番剧的结果
+func TestSearchGapsProvider(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	bangumi := &model.Bangumi{
+		OfficialTitle:   "葬送的芙莉莲",
+		Season:          1,
+		EpisodeMetadata: []model.EpisodeMetadata{{Title: "Sousou no Frieren", Group: "LoliHouse", Resolution: "1080p"}},
+	}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	title := "[LoliHouse] 葬送的芙莉莲 / Sousou no Frieren - %02d [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	for _, ep := range []int{1, 3} {
+		if err := db.CreateTorrent(ctx, &model.Torrent{Name: fmt.Sprintf(title, ep), Link: fmt.Sprintf("magnet:?xt=urn:btih:FRIEREN%02d", ep), BangumiID: bangumi.ID, Downloaded: model.DownloadDone}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	provider := &stubProvider{results: []search.Result{
+		{Title: "[LoliHouse] 药屋少女的呢喃 / Kusuriya no Hitorigoto - 02 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]", Link: "magnet:?xt=urn:btih:OTHER02"},
+		{Title: fmt.Sprintf(title, 2), Link: "magnet:?xt=urn:btih:FRIEREN02"},
+	}}
+	r := New(db)
+	r.SetSearchProviders(provider)
+	found, missing, err := r.SearchGaps(ctx, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != 1 || len(missing) != 0 {
+		t.Fatalf("SearchGaps() = %d, %+v, want episode 2 found", found, missing)
+	}
+	if !slices.Equal(provider.keywords, []string{"Sousou no Frieren"}) {
+		t.Errorf("keywords = %v, want the release title", provider.keywords)
+	}
+	if _, err := db.GetTorrentByURL(ctx, "magnet:?xt=urn:btih:FRIEREN02"); err != nil {
+		t.Errorf("episode 2 not stored: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+)
+
+// ImportEventType 导入进度事件类型
+type ImportEventType string
+
+const (
+	ImportDiscovered ImportEventType = "discovered" // 发现新的种子
+	ImportResolving  ImportEventType = "resolving"  // 正在通过 Mikan/TMDB 解析
+	ImportCreated    ImportEventType = "created"    // 番剧已创建, 或者合并到了已有的番剧
+	ImportSkipped    ImportEventType = "skipped"    // 已经导入过或被过滤
+	ImportError      ImportEventType = "error"      // 解析或写入失败, 下次导入会重试
+)
+
+// ImportEvent 导入进度事件
+type ImportEvent struct {
+	Type    ImportEventType
+	Torrent *model.Torrent
+	Bangumi *model.Bangumi // 仅 ImportCreated 时有值
+	Reason  string         // 跳过的原因
+	Err     error          // 仅 ImportError 时有值
+}
+
+// ImportLibrary 从一个(通常很大的)聚合 RSS 初次导入番剧, 通过 channel 推送进度
+//
+// 已经写入数据库的 EpisodeMetadata 就是检查点: 能通过 GetBangumiParseByTitle 找到的种子直接跳过,
+// 所以中断后重新导入会跳过已完成的番剧, 只重试失败和未处理的部分.
+// 每处理完一个番剧检查一次 ctx, 取消后不会留下写了一半的番剧.
+// 所有事件发送完后 channel 会被关闭, Service 停止时导入也会被取消.
+func (r *Refresher) ImportLibrary(ctx context.Context, url string) (<-chan ImportEvent, error) {
+	torrents, err := network.GetRequestClient().GetTorrents(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("获取 RSS 失败: %w", err)
+	}
+	rssItem := &model.RSSItem{Link: url}
+	events := make(chan ImportEvent)
+	r.goBackground(ctx, func(ctx context.Context) error {
+		defer close(events)
+		send := func(e ImportEvent) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, t := range torrents {
+			if ctx.Err() != nil {
+				slog.Info("[ImportLibrary] 导入被取消", "URL", network.RedactURL(url))
+				return nil
+			}
+			_, err := r.db.GetBangumiParseByTitle(ctx, t.Name)
+			if err == nil {
+				if !send(ImportEvent{Type: ImportSkipped, Torrent: t, Reason: "已导入"}) {
+					return nil
+				}
+				continue
+			}
+			if !errors.Is(err, database.ErrNotFound) {
+				if !send(ImportEvent{Type: ImportError, Torrent: t, Err: err}) {
+					return nil
+				}
+				continue
+			}
+			if !FilterTorrent(t, "", "") {
+				if !send(ImportEvent{Type: ImportSkipped, Torrent: t, Reason: "被过滤"}) {
+					return nil
+				}
+				continue
+			}
+			if !send(ImportEvent{Type: ImportDiscovered, Torrent: t}) {
+				return nil
+			}
+			if !send(ImportEvent{Type: ImportResolving, Torrent: t}) {
+				return nil
+			}
+			bangumi, err := r.createBangumi(ctx, t, rssItem, false)
+			if errors.Is(err, ErrResolveInProgress) || errors.Is(err, ErrResolveBackoff) {
+				if !send(ImportEvent{Type: ImportSkipped, Torrent: t, Reason: err.Error()}) {
+					return nil
+				}
+				continue
+			}
+			if err != nil {
+				if !send(ImportEvent{Type: ImportError, Torrent: t, Err: err}) {
+					return nil
+				}
+				continue
+			}
+			if !send(ImportEvent{Type: ImportCreated, Torrent: t, Bangumi: bangumi}) {
+				return nil
+			}
+		}
+		return nil
+	})
+	return events, nil
+}
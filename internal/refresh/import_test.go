@@ -0,0 +1,89 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/database"
+)
+
+// TestImportLibrary_CancelAndResume 测试导入中途取消后数据库保持一致, 并且重新导入会跳过已完成的番剧
+func TestImportLibrary_CancelAndResume(t *testing.T) {
+	t.Parallel()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rssURL := "https://mikanani.me/RSS/MyBangumi?token=test"
+	r := New(db)
+
+	// 第一次导入, 创建一个番剧后取消
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := r.ImportLibrary(ctx, rssURL)
+	if err != nil {
+		t.Fatalf("ImportLibrary 失败: %v", err)
+	}
+	firstCreated := 0
+	for e := range events {
+		if e.Type == ImportCreated {
+			firstCreated++
+			cancel()
+		}
+	}
+	cancel()
+	if firstCreated != 1 {
+		t.Fatalf("期望取消前创建 1 个番剧, 实际 %d 个", firstCreated)
+	}
+
+	// 取消后数据库中的番剧都应该是完整的
+	bangumis, err := db.ListBangumiWithDetails(context.Background())
+	if err != nil {
+		t.Fatalf("查询番剧列表失败: %v", err)
+	}
+	if len(bangumis) != 1 {
+		t.Fatalf("期望数据库中有 1 个番剧, 实际 %d 个", len(bangumis))
+	}
+	if len(bangumis[0].EpisodeMetadata) == 0 {
+		t.Fatalf("番剧 %s 没有 EpisodeMetadata", bangumis[0].OfficialTitle)
+	}
+	// 导入的番剧不补全之前的集数
+	if !bangumis[0].Backfilled {
+		t.Errorf("导入的番剧 %s 没有标记为已补全", bangumis[0].OfficialTitle)
+	}
+
+	// 重新导入, 已完成的番剧应该被跳过
+	events, err = r.ImportLibrary(context.Background(), rssURL)
+	if err != nil {
+		t.Fatalf("ImportLibrary 失败: %v", err)
+	}
+	counts := make(map[ImportEventType]int)
+	for e := range events {
+		counts[e.Type]++
+		if e.Type == ImportCreated && e.Bangumi.OfficialTitle == bangumis[0].OfficialTitle {
+			t.Errorf("已导入的番剧 %s 被重复创建", e.Bangumi.OfficialTitle)
+		}
+	}
+	t.Logf("重新导入事件统计: %v", counts)
+	if counts[ImportSkipped] == 0 {
+		t.Error("重新导入时应该跳过已导入的种子")
+	}
+	if counts[ImportDiscovered] != counts[ImportResolving] {
+		t.Errorf("discovered(%d) 和 resolving(%d) 数量应该一致", counts[ImportDiscovered], counts[ImportResolving])
+	}
+
+	final, err := db.ListBangumi(context.Background())
+	if err != nil {
+		t.Fatalf("查询番剧列表失败: %v", err)
+	}
+	if len(final) < 4 {
+		t.Errorf("期望重新导入后至少有 4 个番剧, 实际 %d 个", len(final))
+	}
+	var titles []string
+	for _, b := range final {
+		titles = append(titles, b.OfficialTitle)
+	}
+	t.Logf("番剧列表: %v", titles)
+}
@@ -108,6 +108,8 @@ func TestMain(m *testing.M) {
 	// RefreshRSS 测试缓存 - 败犬女主太多了！
 	rss3391URL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=370"
 	network.SetTestCache(rss3391URL, rssBangumi3391XML)
+	// 查找缺集时还会拉取 Mikan 番剧页面的 RSS
+	network.SetTestCache(parser.MikanBangumiRSS(3391), rssBangumi3391XML)
 	// 所有 episode homepage 都指向同一个 bangumi 页面
 	mikan3391Episodes := []string{
 		"https://mikanani.me/Home/Episode/0651a36393eabaf6aee48624efc951983ebd3156",
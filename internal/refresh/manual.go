@@ -0,0 +1,103 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/download"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/notification"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// AddManualTorrent 手动添加一个种子到指定番剧, 不需要等待 RSS 刷新
+// 种子名称来自磁力链接的 dn 或种子文件, 解析出的 EpisodeMetadata 关联到番剧, 之后的 RSS 刷新也能匹配到
+// 解析信息和种子在同一个事务中写入, 种子写入失败时解析信息也不会保存
+// 种子入库后和 RSS 的种子一样入队, 走下载 -> 重命名的流程
+// 已经添加过的链接直接返回已有的种子, 不会重复入队
+func (r *Refresher) AddManualTorrent(ctx context.Context, url string, bangumiID int, runner *taskrunner.TaskRunner) (*model.Torrent, error) {
+	existing, err := r.db.GetTorrentByURL(ctx, url)
+	if err == nil {
+		slog.Info("[AddManualTorrent] 种子已存在, 跳过", "种子名称", existing.Name)
+		return existing, nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	bangumi, err := r.db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return nil, err
+	}
+	name, err := manualTorrentName(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析信息和种子在同一个事务中写入
+	torrent := &model.Torrent{Link: url, Name: name, BangumiID: bangumi.ID}
+	err = r.db.Transaction(ctx, func(tx *database.DB) error {
+		// 标题匹配不到这个番剧时补上解析信息, 解析信息无效时不影响种子入库
+		if match, err := tx.GetBangumiParseByTitle(ctx, name); err != nil || match.ID != bangumi.ID {
+			meta := parser.NewTitleMetaParse().Parse(name)
+			meta.BangumiID = bangumi.ID
+			if err := tx.CreateBangumiParse(ctx, meta); err != nil {
+				slog.Warn("[AddManualTorrent] 保存解析信息失败", "种子名称", name, "error", err)
+			}
+		}
+		return tx.CreateTorrent(ctx, torrent)
+	})
+	if err != nil {
+		return nil, err
+	}
+	bangumi = r.seasonBangumi(ctx, bangumi, torrent)
+	torrent.Bangumi = bangumi
+	slog.Info("[AddManualTorrent] 手动添加种子", "种子名称", name, "番剧", bangumi.OfficialTitle)
+	if runner.Submit(model.NewAddTask(torrent, bangumi)) {
+		r.markQueued(ctx, torrent, bangumi, "手动添加")
+		r.notify(ctx, notification.NewTorrentEvent(notification.EventEpisodeEnqueued, torrent, bangumi))
+	}
+	return torrent, nil
+}
+
+// manualTorrentName 获取种子名称, 磁力链接使用 dn 参数, 其他链接下载种子文件解析
+func manualTorrentName(ctx context.Context, url string) (string, error) {
+	var info *model.TorrentInfo
+	var err error
+	if strings.HasPrefix(url, "magnet:") {
+		info, err = download.ParseTorrentURL(url)
+	} else {
+		var data []byte
+		data, err = network.GetRequestClient().Get(ctx, url)
+		if err != nil {
+			return "", err
+		}
+		info, err = download.ParseTorrent(data)
+	}
+	if err != nil {
+		return "", fmt.Errorf("解析种子失败: %w", err)
+	}
+	if info.Name == "" {
+		return "", fmt.Errorf("种子没有名称: %s", url)
+	}
+	return info.Name, nil
+}
+
+// markQueued 记录种子入队的下载历史, 并把种子覆盖的集数标记为下载中, 写入失败不影响下载
+func (r *Refresher) markQueued(ctx context.Context, t *model.Torrent, bangumi *model.Bangumi, message string) {
+	if err := r.db.RecordDownloadEvent(ctx, t.Link, t.BangumiID, model.ActionQueued, message); err != nil {
+		slog.Warn("[refresh] 记录下载历史失败", "种子名称", t.Name, "error", err)
+	}
+	if bangumi == nil {
+		return
+	}
+	if err := r.db.TrackEpisodes(ctx, bangumi.ID, bangumi.Season, episodeRange(t.Name, bangumi.Offset), t.Link); err != nil {
+		slog.Warn("[refresh] 记录剧集状态失败", "种子名称", t.Name, "error", err)
+	}
+}
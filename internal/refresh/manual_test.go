@@ -0,0 +1,84 @@
+package refresh
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/taskrunner"
+)
+
+func TestAddManualTorrent(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	submitted := make(chan *model.Task, 4)
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		submitted <- task
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	name := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	link := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=" + url.QueryEscape(name)
+	r := New(db)
+
+	torrent, err := r.AddManualTorrent(ctx, link, bangumi.ID, runner)
+	if err != nil {
+		t.Fatalf("AddManualTorrent() error = %v", err)
+	}
+	if torrent.Name != name || torrent.BangumiID != bangumi.ID {
+		t.Errorf("torrent = %q (bangumi %d), want %q (bangumi %d)", torrent.Name, torrent.BangumiID, name, bangumi.ID)
+	}
+	select {
+	case task := <-submitted:
+		if task.Torrent.Link != link {
+			t.Errorf("submitted %s, want %s", task.Torrent.Link, link)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("torrent was not enqueued")
+	}
+	// 解析信息已关联, 之后的 RSS 刷新能匹配到这个番剧
+	match, err := db.GetBangumiParseByTitle(ctx, name)
+	if err != nil || match.ID != bangumi.ID {
+		t.Errorf("GetBangumiParseByTitle() = %v, %v, want bangumi %d", match, err, bangumi.ID)
+	}
+
+	// 重复添加返回已有的种子, 不再入队
+	again, err := r.AddManualTorrent(ctx, link, bangumi.ID, runner)
+	if err != nil {
+		t.Fatalf("AddManualTorrent() again error = %v", err)
+	}
+	if again.Link != link || again.Name != name {
+		t.Errorf("again = %+v, want existing torrent", again)
+	}
+	select {
+	case task := <-submitted:
+		t.Errorf("duplicate add enqueued %s", task.Torrent.Link)
+	case <-time.After(100 * time.Millisecond):
+	}
+	metadata, err := db.ListEpisodeMetadataByBangumiID(ctx, bangumi.ID)
+	if err != nil || len(metadata) != 1 {
+		t.Errorf("metadata = %v, %v, want exactly one row", metadata, err)
+	}
+
+	if _, err := r.AddManualTorrent(ctx, "magnet:?xt=urn:btih:ffffffffffffffffffffffffffffffffffffffff&dn=x", 9999, runner); err == nil {
+		t.Error("AddManualTorrent() for unknown bangumi error = nil")
+	}
+}
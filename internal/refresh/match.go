@@ -0,0 +1,131 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+// errAmbiguousMatch 标题匹配到多个得分相同的番剧, 又没有 mikan_id 可以区分
+var errAmbiguousMatch = errors.New("种子标题匹配到多个番剧")
+
+// matchBangumi 找到种子对应的番剧
+// 标题匹配是子串匹配, 不同番剧的标题互相包含时会分错, 所以有 mikan 主页时优先通过 mikan_id 查找,
+// 两者不一致时以 mikan 为准; 没有主页或 mikan_id 查不到唯一的番剧时才使用标题匹配的结果
+// 标题匹配按 ListBangumiCandidates 的得分选择, 最高分有多个番剧时无法确定, 返回 errAmbiguousMatch
+func (r *Refresher) matchBangumi(ctx context.Context, torrent *model.Torrent) (*model.Bangumi, error) {
+	byTitle, ambiguous, err := r.matchByTitle(ctx, torrent)
+	byMikan := r.matchByMikan(ctx, torrent, byTitle)
+	if byMikan == nil {
+		if ambiguous {
+			slog.Warn("[matchBangumi] 标题匹配到多个番剧, 跳过", "种子名称", torrent.Name, "番剧", byTitle.OfficialTitle)
+			return nil, errAmbiguousMatch
+		}
+		return byTitle, err
+	}
+	if byTitle != nil && byTitle.ID != byMikan.ID {
+		slog.Warn("[matchBangumi] 标题匹配和 mikan_id 匹配的番剧不一致, 使用 mikan_id 的结果",
+			"种子名称", torrent.Name, "标题匹配", byTitle.OfficialTitle, "mikan 匹配", byMikan.OfficialTitle)
+	}
+	return byMikan, nil
+}
+
+// matchByTitle 按标题匹配得分最高的番剧, ambiguous 表示还有其他番剧的得分与它相同
+// 只有回收站中的番剧匹配时返回它, 由调用方跳过; 没有匹配时返回 database.ErrNotFound
+func (r *Refresher) matchByTitle(ctx context.Context, torrent *model.Torrent) (*model.Bangumi, bool, error) {
+	season := parser.NewTitleMetaParse().Parse(torrent.Name).Season
+	candidates, err := r.db.ListBangumiCandidates(ctx, torrent.Name, season)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(candidates) == 0 {
+		deleted, err := r.db.MatchDeletedBangumi(ctx, torrent.Name)
+		return deleted, false, err
+	}
+	ambiguous := len(candidates) > 1 && candidates[1].Score == candidates[0].Score
+	return candidates[0].Bangumi, ambiguous, nil
+}
+
+// matchByMikan 通过种子的 mikan 主页解析 mikan_id, 再找关联的番剧
+// 同一个 mikan_id 有多个番剧时, 标题匹配的番剧在其中就用它, 否则无法确定, 返回 nil
+func (r *Refresher) matchByMikan(ctx context.Context, torrent *model.Torrent, byTitle *model.Bangumi) *model.Bangumi {
+	if torrent.Homepage == "" {
+		return nil
+	}
+	mikanInfo, err := parser.LookupMikan(ctx, torrent.Homepage)
+	if err != nil || mikanInfo.ID == 0 {
+		slog.Debug("[matchBangumi] 解析 mikan_id 失败", "种子名称", torrent.Name, "error", err)
+		return nil
+	}
+	bangumis, err := r.db.GetBangumisByMikanID(ctx, mikanInfo.ID)
+	if err != nil || len(bangumis) == 0 {
+		return nil
+	}
+	if len(bangumis) == 1 {
+		return bangumis[0]
+	}
+	if byTitle != nil {
+		for _, b := range bangumis {
+			if b.ID == byTitle.ID {
+				return b
+			}
+		}
+	}
+	slog.Debug("[matchBangumi] mikan_id 关联了多个番剧, 使用标题匹配", "种子名称", torrent.Name, "mikan_id", mikanInfo.ID)
+	return nil
+}
+
+// seasonBangumi 返回按种子所属季度处理的番剧
+// 同一部番剧的多季属于同一个番剧(见 database.CreateBangumi), 种子属于主季度以外的一季时返回番剧的副本,
+// Season 和 Offset 为这一季的, 下载路径、重命名和剧集记录都按它处理; 属于主季度或无法确定时返回 bangumi 本身
+func (r *Refresher) seasonBangumi(ctx context.Context, bangumi *model.Bangumi, torrent *model.Torrent) *model.Bangumi {
+	seasons, err := r.db.ListSeasons(ctx, bangumi.ID)
+	if err != nil || len(seasons) <= 1 {
+		return bangumi
+	}
+	number := r.torrentSeason(ctx, bangumi, seasons, torrent)
+	for _, s := range seasons {
+		if s.Number != number || s.Number == bangumi.Season {
+			continue
+		}
+		view := *bangumi
+		view.Season = s.Number
+		view.Offset = s.Offset
+		return &view
+	}
+	return bangumi
+}
+
+// torrentSeason 种子属于番剧的第几季
+// 已经关联季度的种子直接使用; 否则使用标题和字幕组都出现在种子名中、标题最长的解析信息的季度,
+// 解析信息的季度经过 TMDB 修正(见 correctSeason), 比种子名中的季度可靠; 都没有时使用种子名中写明的季度, 再没有时为番剧的主季度
+func (r *Refresher) torrentSeason(ctx context.Context, bangumi *model.Bangumi, seasons []*model.Season, torrent *model.Torrent) int {
+	for _, s := range seasons {
+		if torrent.SeasonID != 0 && s.ID == torrent.SeasonID {
+			return s.Number
+		}
+	}
+	name := strings.ToLower(torrent.Name)
+	if metadata, err := r.db.ListEpisodeMetadataByBangumiID(ctx, bangumi.ID); err == nil {
+		var best *model.EpisodeMetadata
+		for _, m := range metadata {
+			if !strings.Contains(name, strings.ToLower(m.Title)) || !strings.Contains(name, strings.ToLower(m.Group)) {
+				continue
+			}
+			if best == nil || len(m.Title) > len(best.Title) {
+				best = m
+			}
+		}
+		if best != nil {
+			return best.Season
+		}
+	}
+	if meta := parser.NewTitleMetaParse().Parse(torrent.Name); meta != nil && meta.SeasonRaw != "" {
+		return meta.Season
+	}
+	return bangumi.Season
+}
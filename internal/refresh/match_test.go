@@ -0,0 +1,131 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+)
+
+// TestMatchBangumi 另一部番剧的标题是种子名称的子串, 标题匹配会分错, mikan_id 能分对
+func TestMatchBangumi(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mikanID := 3391
+	right := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1, MikanID: &mikanID}
+	wrong := &model.Bangumi{OfficialTitle: "败犬", Season: 1}
+	for _, b := range []*model.Bangumi{right, wrong} {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "败犬", Group: "LoliHouse", BangumiID: wrong.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "[喵萌奶茶屋&LoliHouse] 败犬女主角也太多了！ / 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕][END]"
+	tests := []struct {
+		name     string
+		homepage string
+		want     int
+	}{
+		{"mikan_id 优先", "https://mikanani.me/Home/Episode/0651a36393eabaf6aee48624efc951983ebd3156", right.ID},
+		{"没有主页时使用标题匹配", "", wrong.ID},
+	}
+
+	r := New(db)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.matchBangumi(ctx, &model.Torrent{Name: name, Homepage: tt.homepage})
+			if err != nil {
+				t.Fatalf("matchBangumi() error = %v", err)
+			}
+			if got.ID != tt.want {
+				t.Errorf("matchBangumi() = %d (%s), want %d", got.ID, got.OfficialTitle, tt.want)
+			}
+		})
+	}
+
+	// 两边都找不到时返回标题匹配的错误
+	if _, err := r.matchBangumi(ctx, &model.Torrent{Name: "[SubsPlease] Unknown - 01 (1080p)"}); err == nil {
+		t.Error("matchBangumi() error = nil, want not found")
+	}
+}
+
+// TestMatchBangumiAmbiguous 两个番剧的解析信息得分相同且没有 mikan 主页时不分配
+func TestMatchBangumiAmbiguous(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, title := range []string{"间谍过家家", "间谍过家家 (重复)"} {
+		b := &model.Bangumi{OfficialTitle: title, Season: 1}
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "SPY×FAMILY", Season: 1, Group: "ANi", BangumiID: b.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := New(db)
+	_, err = r.matchBangumi(ctx, &model.Torrent{Name: "[ANi] SPY×FAMILY - 05 [1080P][Baha][WEB-DL][AAC AVC][CHT].mp4"})
+	if !errors.Is(err, errAmbiguousMatch) {
+		t.Errorf("matchBangumi() error = %v, want errAmbiguousMatch", err)
+	}
+}
+
+// TestSeasonBangumi 同一个番剧的第二季种子按第二季的季度和偏移处理, 主季度的种子使用番剧本身
+func TestSeasonBangumi(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.New(t)
+	bangumi := &model.Bangumi{OfficialTitle: "我推的孩子", Season: 1, TmdbItem: &model.TmdbItem{ID: 203737}, EpisodeMetadata: []model.EpisodeMetadata{
+		{Title: "Oshi no Ko", Group: "SubsPlease", Season: 1},
+	}}
+	if err := db.CreateBangumi(ctx, bangumi); err != nil {
+		t.Fatal(err)
+	}
+	s2 := &model.Bangumi{OfficialTitle: "我推的孩子", Season: 2, TmdbItem: &model.TmdbItem{ID: 203737}, EpisodeMetadata: []model.EpisodeMetadata{
+		{Title: "Oshi no Ko S2", Group: "SubsPlease", Season: 2},
+	}}
+	if err := db.CreateBangumi(ctx, s2); err != nil {
+		t.Fatal(err)
+	}
+	season, err := db.GetSeason(ctx, bangumi.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	season.Offset = -11
+	if err := db.UpdateSeason(ctx, season); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(db)
+	primary := &model.Torrent{Name: "[SubsPlease] Oshi no Ko - 05 (1080p)"}
+	if got := r.seasonBangumi(ctx, bangumi, primary); got != bangumi {
+		t.Errorf("seasonBangumi(primary) = %+v, want the bangumi itself", got)
+	}
+	second := &model.Torrent{Name: "[SubsPlease] Oshi no Ko S2 - 13 (1080p)"}
+	got := r.seasonBangumi(ctx, bangumi, second)
+	if got == bangumi || got.ID != bangumi.ID || got.Season != 2 || got.Offset != -11 {
+		t.Errorf("seasonBangumi(season 2) = id %d season %d offset %d, want id %d season 2 offset -11", got.ID, got.Season, got.Offset, bangumi.ID)
+	}
+	if bangumi.Season != 1 {
+		t.Errorf("bangumi.Season = %d after seasonBangumi, want 1", bangumi.Season)
+	}
+}
@@ -0,0 +1,69 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+// MetadataParser 从种子解析番剧的元数据, 对应 RSSItem.Parse / Bangumi.Parse
+type MetadataParser func(ctx context.Context, torrent *model.Torrent) (*model.Bangumi, error)
+
+// 可选的解析器
+const (
+	// ParserTMDB mikan 获取标题后再查询 TMDB, 默认解析器
+	ParserTMDB = "tmdb"
+	// ParserMikan 只使用 mikan, 用于 TMDB 匹配错误的番剧
+	ParserMikan = "mikan"
+	// ParserNone 不请求任何元数据, 直接使用种子标题
+	ParserNone = "none"
+)
+
+// DefaultParser 未指定或未知解析器时使用的解析器
+const DefaultParser = ParserTMDB
+
+var metadataParsers = map[string]MetadataParser{
+	ParserTMDB:  OfficialTitleParse,
+	ParserMikan: MikanOnlyParse,
+	ParserNone:  RawTitleParse,
+	"raw":       RawTitleParse,
+}
+
+// GetMetadataParser 根据名称获取解析器, 返回实际使用的解析器名称
+// 名称为空时使用默认解析器, 未知的名称会打印警告并回退到默认解析器
+func GetMetadataParser(name string) (string, MetadataParser) {
+	if name == "" {
+		return DefaultParser, metadataParsers[DefaultParser]
+	}
+	if p, ok := metadataParsers[name]; ok {
+		return name, p
+	}
+	slog.Warn("[GetMetadataParser] 未知的解析器, 使用默认解析器", "解析器", name, "默认", DefaultParser)
+	return DefaultParser, metadataParsers[DefaultParser]
+}
+
+// MikanOnlyParse 只通过 mikan 页面解析, 不查询 TMDB
+func MikanOnlyParse(ctx context.Context, torrent *model.Torrent) (*model.Bangumi, error) {
+	if torrent.Homepage == "" {
+		return nil, fmt.Errorf("种子没有 mikan 主页: %s", torrent.Name)
+	}
+	bangumi := model.NewBangumi()
+	if err := applyMikan(ctx, torrent, bangumi); err != nil {
+		slog.Debug("[MikanOnlyParse] mikan 解析失败", "种子名称", torrent.Name, "error", err)
+		return nil, err
+	}
+	return bangumi, nil
+}
+
+// RawTitleParse 不做任何网络请求, 标题和季度都来自种子名称
+func RawTitleParse(_ context.Context, torrent *model.Torrent) (*model.Bangumi, error) {
+	metaInfo := parser.NewTitleMetaParse().Parse(torrent.Name)
+	bangumi := model.NewBangumi()
+	bangumi.OfficialTitle = metaInfo.Title
+	bangumi.Season = metaInfo.Season
+	bangumi.Year = metaInfo.Year
+	return bangumi, nil
+}
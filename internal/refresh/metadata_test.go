@@ -0,0 +1,52 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+func TestTorrentToBangumi_Parser(t *testing.T) {
+	torrent := model.Torrent{
+		Name:     "[ANi] Chitose Is in the Ramune Bottle / 弹珠汽水瓶里的千岁同学 - 02 [1080P][Baha][WEB-DL][AAC AVC][CHT][MP4]",
+		Link:     "magnet:?xt=urn:btih:EXAMPLE1",
+		Homepage: "https://mikanani.me/Home/Episode/7c8c41e409922d9f2c34a726c92e77daf05558ff",
+	}
+	tests := []struct {
+		name      string
+		parse     string
+		wantParse string
+		wantMikan bool
+		wantTmdb  bool
+	}{
+		{name: "默认", parse: "", wantParse: ParserTMDB, wantMikan: true, wantTmdb: true},
+		{name: "tmdb", parse: ParserTMDB, wantParse: ParserTMDB, wantMikan: true, wantTmdb: true},
+		{name: "只用 mikan", parse: ParserMikan, wantParse: ParserMikan, wantMikan: true},
+		{name: "不解析", parse: ParserNone, wantParse: ParserNone},
+		{name: "未知解析器回退默认", parse: "bangumi", wantParse: ParserTMDB, wantMikan: true, wantTmdb: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rssItem := &model.RSSItem{Link: "https://mikanani.me/RSS/Search?searchstr=ANI", Parse: tt.parse}
+			torrent := torrent
+			bangumi, err := TorrentToBangumi(context.Background(), &torrent, rssItem)
+			if err != nil {
+				t.Fatalf("TorrentToBangumi() error = %v", err)
+			}
+			if bangumi.Parse != tt.wantParse {
+				t.Errorf("Parse = %q, want %q", bangumi.Parse, tt.wantParse)
+			}
+			if (bangumi.MikanItem != nil) != tt.wantMikan {
+				t.Errorf("MikanItem = %v, want mikan %v", bangumi.MikanItem, tt.wantMikan)
+			}
+			if (bangumi.TmdbItem != nil) != tt.wantTmdb {
+				t.Errorf("TmdbItem = %v, want tmdb %v", bangumi.TmdbItem, tt.wantTmdb)
+			}
+			if bangumi.OfficialTitle == "" {
+				t.Error("OfficialTitle is empty")
+			}
+			t.Logf("parse: %s, title: %s, season: %d", bangumi.Parse, bangumi.OfficialTitle, bangumi.Season)
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package refresh
+
+import (
+	"context"
+	"log/slog"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+// 绝对集数: 不少字幕组把第 2 季的第 12 集标成第 37 集(接着第 1 季往下数), TMDB 则记为 S02E12
+// 番剧的偏移没有设置时, 用 TMDB 记录的番剧这一季之前各季的总集数判断种子是否使用绝对集数, 自动设置 Bangumi.Offset
+// 手动设置的偏移(OffsetSourceManual)不会被覆盖; 推断不可靠时之后出现的种子会再推断一次
+
+// OffsetDetection 自动推断集数偏移的结果
+type OffsetDetection struct {
+	Offset    int  `json:"offset"`
+	Confident bool `json:"confident"`
+	// Applied 偏移写入了番剧; 与现有的相同或者番剧的偏移是手动设置的时为 false
+	Applied bool `json:"applied"`
+}
+
+// inferOffset 根据 TMDB 的季度集数判断第 season 季种子标题中的集数 eps 是否为绝对集数, 是时返回偏移
+// 只对第 2 季以后并且 TMDB 有之前各季集数的番剧生效: 所有集数都在之前的总集数之后、这一季的范围之内,
+// 并且至少有一集超过这一季的集数(不可能是这一季的集数)时认为是绝对集数;
+// TMDB 还没有这一季的集数时只要求都在之前的总集数之后, 此时结果不可靠
+func inferOffset(item *model.TmdbItem, season int, eps []int) (offset int, confident, ok bool) {
+	if item == nil || season <= 1 || len(eps) == 0 {
+		return 0, false, false
+	}
+	count, prior, found := item.SeasonEpisodes(season)
+	if !found || prior <= 0 {
+		return 0, false, false
+	}
+	beyond := false
+	for _, ep := range eps {
+		if ep <= prior || count > 0 && ep > prior+count {
+			return 0, false, false
+		}
+		if count <= 0 || ep > count {
+			beyond = true
+		}
+	}
+	if !beyond {
+		return 0, false, false
+	}
+	return -prior, count > 0, true
+}
+
+// autoOffset 用种子标题中的集数 eps 推断番剧的偏移, 写入时同时更新 bangumi, 返回是否写入
+// 第 1 季、手动设置过偏移、已经可靠地推断过, 或者在这个功能之前就设置了偏移的番剧不处理
+func (r *Refresher) autoOffset(ctx context.Context, bangumi *model.Bangumi, eps []int) bool {
+	switch {
+	case len(eps) == 0, bangumi.Season <= 1,
+		bangumi.OffsetSource == model.OffsetSourceManual,
+		bangumi.OffsetSource == model.OffsetSourceAuto && bangumi.OffsetConfident,
+		bangumi.OffsetSource == model.OffsetSourceNone && bangumi.Offset != 0:
+		return false
+	}
+	item, err := r.tmdbItem(ctx, bangumi)
+	if err != nil {
+		slog.Warn("[autoOffset] 获取番剧 TMDB 信息失败", "番剧", bangumi.OfficialTitle, "error", err)
+		return false
+	}
+	offset, confident, ok := inferOffset(item, bangumi.Season, eps)
+	if !ok || bangumi.OffsetSource == model.OffsetSourceAuto && bangumi.Offset == offset && bangumi.OffsetConfident == confident {
+		return false
+	}
+	applied, err := r.db.SetBangumiAutoOffset(ctx, bangumi.ID, offset, confident)
+	if err != nil {
+		slog.Warn("[autoOffset] 保存集数偏移失败", "番剧", bangumi.OfficialTitle, "error", err)
+		return false
+	}
+	if applied {
+		bangumi.Offset = offset
+		bangumi.OffsetSource = model.OffsetSourceAuto
+		bangumi.OffsetConfident = confident
+		slog.Info("[autoOffset] 种子使用绝对集数, 自动设置集数偏移", "番剧", bangumi.OfficialTitle,
+			"季度", bangumi.Season, "偏移", offset, "可靠", confident)
+	}
+	return applied
+}
+
+// tmdbItem 番剧的 TMDB 条目, 没有预加载时从数据库读取, 没有关联时为 nil
+// 保存时还没有各季信息的旧条目重新从 TMDB 获取一次, 获取失败时使用旧条目
+func (r *Refresher) tmdbItem(ctx context.Context, bangumi *model.Bangumi) (*model.TmdbItem, error) {
+	item := bangumi.TmdbItem
+	if item == nil && bangumi.TmdbID != nil {
+		details, err := r.db.GetBangumiWithDetails(ctx, uint(bangumi.ID))
+		if err != nil {
+			return nil, err
+		}
+		item = details.TmdbItem
+	}
+	if item == nil || len(item.Seasons) > 0 {
+		return item, nil
+	}
+	// 每个条目只重新获取一次, 获取失败也不再重试, 避免每个种子都请求 TMDB
+	if _, loaded := r.tmdbRefreshed.LoadOrStore(item.ID, struct{}{}); loaded {
+		return item, nil
+	}
+	fresh, err := parser.NewTMDBParse().TMDBItem(ctx, item.ID, parser.TmdbLanguage())
+	if err != nil {
+		slog.Warn("[tmdbItem] 重新获取 TMDB 信息失败, 使用已保存的信息", "tmdb_id", item.ID, "error", err)
+		return item, nil
+	}
+	if err := r.db.CreateTmdbItem(ctx, fresh); err != nil {
+		slog.Warn("[tmdbItem] 保存 TMDB 信息失败", "tmdb_id", item.ID, "error", err)
+	}
+	bangumi.TmdbItem = fresh
+	return fresh, nil
+}
+
+// DetectOffset 用番剧已有的种子推断集数偏移, 推断不出时返回 nil
+// 番剧的偏移没有设置过或者是自动推断的时写入番剧, 结果的 Applied 表示是否写入;
+// 用于这个功能之前创建的番剧, 之后新出现的种子在入库时会自动推断
+func (r *Refresher) DetectOffset(ctx context.Context, bangumiID int) (*OffsetDetection, error) {
+	bangumi, err := r.db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return nil, err
+	}
+	torrents, err := r.db.ListTorrentByBangumiID(ctx, bangumi.ID)
+	if err != nil {
+		return nil, err
+	}
+	var eps []int
+	for _, t := range torrents {
+		eps = append(eps, episodeRange(t.Name, 0)...)
+	}
+	item, err := r.tmdbItem(ctx, bangumi)
+	if err != nil {
+		return nil, err
+	}
+	offset, confident, ok := inferOffset(item, bangumi.Season, eps)
+	if !ok {
+		return nil, nil
+	}
+	return &OffsetDetection{Offset: offset, Confident: confident, Applied: r.autoOffset(ctx, bangumi, eps)}, nil
+}
@@ -0,0 +1,140 @@
+package refresh
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/taskrunner"
+)
+
+func TestInferOffset(t *testing.T) {
+	season2 := &model.TmdbItem{Season: 2, EpisodeCount: 12, PriorEpisodes: 25}
+	// TMDB 已经有第 3 季, 番剧是第 2 季
+	season3 := &model.TmdbItem{Season: 3, EpisodeCount: 13, PriorEpisodes: 37, Seasons: model.TmdbSeasons{
+		{Number: 1, EpisodeCount: 25}, {Number: 2, EpisodeCount: 12}, {Number: 3, EpisodeCount: 13},
+	}}
+	tests := []struct {
+		name          string
+		item          *model.TmdbItem
+		season        int
+		eps           []int
+		wantOffset    int
+		wantConfident bool
+		wantOK        bool
+	}{
+		{"绝对集数", season2, 2, []int{37}, -25, true, true},
+		{"这一季的集数", season2, 2, []int{1, 12}, 0, false, false},
+		{"超出这一季", season2, 2, []int{38}, 0, false, false},
+		{"混合", season2, 2, []int{5, 37}, 0, false, false},
+		{"不是最新的一季", season3, 2, []int{37}, -25, true, true},
+		{"最新的一季", season3, 3, []int{50}, -37, true, true},
+		{"旧条目不知道其他季度", season2, 3, []int{50}, 0, false, false},
+		{"第 1 季", &model.TmdbItem{Season: 1, EpisodeCount: 12}, 1, []int{13}, 0, false, false},
+		{"没有之前的集数", &model.TmdbItem{Season: 2, EpisodeCount: 12}, 2, []int{37}, 0, false, false},
+		{"还没有这一季的集数", &model.TmdbItem{Season: 2, PriorEpisodes: 25}, 2, []int{26}, -25, false, true},
+		{"之前季度的集数少于这一季", &model.TmdbItem{Season: 2, EpisodeCount: 24, PriorEpisodes: 12}, 2, []int{20}, 0, false, false},
+		{"没有 TMDB", nil, 2, []int{37}, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, confident, ok := inferOffset(tt.item, tt.season, tt.eps)
+			if offset != tt.wantOffset || confident != tt.wantConfident || ok != tt.wantOK {
+				t.Errorf("inferOffset() = %d, %v, %v, want %d, %v, %v", offset, confident, ok, tt.wantOffset, tt.wantConfident, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestAutoOffset 第 2 季的种子使用绝对集数时自动设置偏移, 手动设置的偏移不被覆盖
+func TestAutoOffset(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	newBangumi := func(title string, item *model.TmdbItem) *model.Bangumi {
+		b := &model.Bangumi{
+			OfficialTitle:   title,
+			Season:          2,
+			TmdbItem:        item,
+			EpisodeMetadata: []model.EpisodeMetadata{{Title: title, Group: "LoliHouse", Season: 2}},
+		}
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// TMDB 上已经有第 3 季
+	auto := newBangumi("Jujutsu Kaisen", &model.TmdbItem{ID: 95479, Title: "Jujutsu Kaisen", Season: 3, EpisodeCount: 13, PriorEpisodes: 37,
+		Seasons: model.TmdbSeasons{{Number: 1, EpisodeCount: 25}, {Number: 2, EpisodeCount: 12}, {Number: 3, EpisodeCount: 13}}})
+	// 这个功能之前保存的条目, 没有各季信息, 推断前重新获取
+	manual := newBangumi("Spy x Family", &model.TmdbItem{ID: 120089, Title: "Spy x Family", Season: 3, EpisodeCount: 13})
+	infoURL := parser.InfoURL(120089, "zh")
+	network.SetTestCache(infoURL, []byte(`{"id": 120089, "name": "Spy x Family", "first_air_date": "2022-04-09", "seasons": [
+		{"season_number": 0, "episode_count": 3, "air_date": "2022-04-01"},
+		{"season_number": 1, "episode_count": 25, "air_date": "2022-04-09"},
+		{"season_number": 2, "episode_count": 12, "air_date": "2023-10-07"},
+		{"season_number": 3, "episode_count": 13, "air_date": "2025-10-04"}]}`))
+	defer network.ClearTestCache(infoURL)
+	if err := db.SetBangumiOffset(ctx, manual.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	url := "https://mikanani.me/RSS/AutoOffset"
+	network.SetTestCache(url, collectRSS(
+		"[LoliHouse] Jujutsu Kaisen - 37 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] Spy x Family - 37 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+	))
+	defer network.ClearTestCache(url)
+
+	r := New(db)
+	if err := r.RefreshRSS(ctx, url, runner); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetBangumiByID(ctx, auto.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Offset != -25 || got.OffsetSource != model.OffsetSourceAuto || !got.OffsetConfident {
+		t.Errorf("auto offset = %d, %q, %v, want -25, auto, confident", got.Offset, got.OffsetSource, got.OffsetConfident)
+	}
+	progress, err := r.GetBangumiProgress(ctx, auto.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(progress.Downloading, []int{12}) {
+		t.Errorf("Downloading = %v, want [12]", progress.Downloading)
+	}
+
+	got, err = db.GetBangumiByID(ctx, manual.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Offset != 0 || got.OffsetSource != model.OffsetSourceManual {
+		t.Errorf("manual offset = %d, %q, want 0, manual", got.Offset, got.OffsetSource)
+	}
+
+	// 重置后用已有的种子重新推断
+	if err := db.ResetBangumiOffset(ctx, manual.ID); err != nil {
+		t.Fatal(err)
+	}
+	detection, err := r.DetectOffset(ctx, manual.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detection == nil || detection.Offset != -25 || !detection.Confident || !detection.Applied {
+		t.Errorf("DetectOffset() = %+v, want -25 applied", detection)
+	}
+}
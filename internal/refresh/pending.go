@@ -0,0 +1,136 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// ErrBangumiDeleted 番剧在回收站中, 不能再把种子指定给它
+var ErrBangumiDeleted = errors.New("番剧在回收站中")
+
+// PendingMaxAttempts 待匹配种子最多重试匹配的次数, 达到后不再重试, 见 database.DB.ListUnmatchedTorrents
+const PendingMaxAttempts = 10
+
+// RetryPending 重新为待匹配的种子匹配番剧, 在创建新番剧之后调用
+// 匹配成功的种子和 RefreshRSS 一样入库并入队; 已经入库或不再需要下载的种子删除记录
+// 仍然匹配不到的只更新失败原因; 失败次数只在标题匹配到多个番剧(确实尝试过候选番剧)时增加,
+// 其余的由 RefreshRSS 每次刷新订阅时计一次, 不会因为创建了无关的番剧而耗尽重试次数
+// 失败次数达到 PendingMaxAttempts 后不再重试
+// 返回匹配成功的种子数量
+func (r *Refresher) RetryPending(ctx context.Context, runner *taskrunner.TaskRunner) (int, error) {
+	pending, err := r.db.ListPendingTorrents(ctx, PendingMaxAttempts)
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+	torrents := make([]*model.Torrent, len(pending))
+	links := make([]string, len(pending))
+	for i, p := range pending {
+		torrents[i] = p.Torrent()
+		links[i] = p.Link
+	}
+	// 其他刷新可能已经把种子入库了
+	torrents, err = r.db.CheckNewTorrents(ctx, torrents)
+	if err != nil {
+		return 0, err
+	}
+	unmatched, err := r.enqueueTorrents(ctx, torrents, "待匹配种子重试", runner)
+	if err != nil {
+		return 0, err
+	}
+
+	failed := make(map[string]bool, len(unmatched))
+	for _, u := range unmatched {
+		failed[u.torrent.Link] = true
+		if !errors.Is(u.err, errAmbiguousMatch) {
+			if err := r.db.UpdatePendingError(ctx, u.torrent.Link, u.err); err != nil {
+				slog.Warn("[RetryPending] 记录重试失败失败", "种子名称", u.torrent.Name, "error", err)
+			}
+			continue
+		}
+		attempts, err := r.db.RecordPendingFailure(ctx, u.torrent.Link, u.err)
+		if err != nil {
+			slog.Warn("[RetryPending] 记录重试失败失败", "种子名称", u.torrent.Name, "error", err)
+			continue
+		}
+		if attempts >= PendingMaxAttempts {
+			slog.Warn("[RetryPending] 种子多次重试后仍然匹配不到番剧, 不再重试", "种子名称", u.torrent.Name, "次数", attempts, "原因", u.err)
+		}
+	}
+	done := make([]string, 0, len(links))
+	for _, link := range links {
+		if !failed[link] {
+			done = append(done, link)
+		}
+	}
+	if err := r.db.DeletePendingTorrents(ctx, done); err != nil {
+		return 0, err
+	}
+	matched := len(torrents) - len(unmatched)
+	if matched > 0 {
+		slog.Info("[RetryPending] 待匹配种子匹配成功", "数量", matched, "剩余", len(unmatched))
+	}
+	return matched, nil
+}
+
+// newPendingTorrent 创建待匹配记录, 同时记录从标题解析出的信息, 方便用户判断为什么匹配不到
+func newPendingTorrent(t *model.Torrent, rssLink string, cause error) *model.PendingTorrent {
+	p := model.NewPendingTorrent(t, rssLink, cause)
+	p.SetParsed(parser.NewTitleMetaParse().Parse(t.Name))
+	return p
+}
+
+// ListUnmatched 获取需要用户处理的种子: 重试 PendingMaxAttempts 次后仍然匹配不到番剧且没有被忽略的
+func (r *Refresher) ListUnmatched(ctx context.Context) ([]*model.PendingTorrent, error) {
+	return r.db.ListUnmatchedTorrents(ctx, PendingMaxAttempts)
+}
+
+// AssignPending 手动把待匹配种子指定给番剧, 种子和 RefreshRSS 匹配到的一样入库并入队, 然后删除待匹配记录
+// 解析出的番剧名称添加为番剧的别名, 之后的同名种子可以直接匹配到; 别名无效或已经属于其他番剧时只记录警告
+// 种子已经入库时不再入队, 返回已有的种子; 待匹配记录或番剧不存在时返回 ErrNotFound, 番剧在回收站中时返回 ErrBangumiDeleted
+func (r *Refresher) AssignPending(ctx context.Context, link string, bangumiID int, runner *taskrunner.TaskRunner) (*model.Torrent, error) {
+	pending, err := r.db.GetPendingTorrent(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	bangumi, err := r.db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return nil, err
+	}
+	if bangumi.Deleted {
+		return nil, ErrBangumiDeleted
+	}
+	if pending.ParsedTitle != "" {
+		if _, err := r.db.AddBangumiAlias(ctx, bangumiID, pending.ParsedTitle); err != nil && !errors.Is(err, database.ErrDuplicate) {
+			slog.Warn("[AssignPending] 添加番剧别名失败", "番剧", bangumi.OfficialTitle, "别名", pending.ParsedTitle, "error", err)
+		}
+	}
+
+	if existing, err := r.db.GetTorrentByURL(ctx, link); err == nil {
+		slog.Info("[AssignPending] 种子已存在, 跳过", "种子名称", existing.Name)
+		return existing, r.db.DeletePendingTorrents(ctx, []string{link})
+	} else if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+	torrent := pending.Torrent()
+	torrent.BangumiID = bangumi.ID
+	torrent.Bangumi = r.seasonBangumi(ctx, bangumi, torrent)
+	if err := r.submitTorrents(ctx, []*model.Torrent{torrent}, "手动指定番剧", runner); err != nil {
+		return nil, err
+	}
+	if err := r.db.DeletePendingTorrents(ctx, []string{link}); err != nil {
+		return nil, err
+	}
+	slog.Info("[AssignPending] 手动指定番剧", "种子名称", torrent.Name, "番剧", bangumi.OfficialTitle)
+	return torrent, nil
+}
+
+// DismissPending 忽略待匹配种子, 不再重试也不再出现在 ListUnmatched 中, 记录不存在时返回 ErrNotFound
+func (r *Refresher) DismissPending(ctx context.Context, link string) error {
+	return r.db.DismissPendingTorrent(ctx, link)
+}
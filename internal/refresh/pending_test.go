@@ -0,0 +1,152 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// TestRetryPending 匹配不到番剧的种子被记录, 番剧创建后重试匹配成功
+// 重试不会增加没有候选番剧的种子的失败次数, 每次刷新订阅仍然匹配不到才计一次, 达到上限后不再重试
+func TestRetryPending(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	makeine := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	unknown := "[LoliHouse] 没有这部番 / Nanimo Nai - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583&pending=1"
+	network.SetTestCache(rssURL, collectRSS(makeine, unknown))
+	defer network.ClearTestCache(rssURL)
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	r := New(db)
+	if err := r.RefreshRSS(ctx, rssURL, runner); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := db.ListPendingTorrents(ctx, PendingMaxAttempts)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("ListPendingTorrents() = %d, %v, want 2", len(pending), err)
+	}
+	if pending[0].RSSLink != rssURL || pending[0].LastError == "" {
+		t.Errorf("pending = %+v, want rss link and error", pending[0])
+	}
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse", BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+	matched, err := r.RetryPending(ctx, runner)
+	if err != nil || matched != 1 {
+		t.Fatalf("RetryPending() = %d, %v, want 1", matched, err)
+	}
+	var stored model.Torrent
+	if err := db.Where("name = ?", makeine).First(&stored).Error; err != nil || stored.BangumiID != bangumi.ID {
+		t.Errorf("stored torrent = %+v, %v, want bangumi %d", stored, err, bangumi.ID)
+	}
+
+	for range PendingMaxAttempts {
+		if _, err := r.RetryPending(ctx, runner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, _ = db.ListPendingTorrents(ctx, PendingMaxAttempts)
+	if len(pending) != 1 || pending[0].Attempts != 0 {
+		t.Fatalf("pending after retries = %+v, want %q with no attempts", pending, unknown)
+	}
+
+	for range PendingMaxAttempts {
+		if err := r.RefreshRSS(ctx, rssURL, runner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, _ = db.ListPendingTorrents(ctx, PendingMaxAttempts)
+	unmatched, _ := db.ListUnmatchedTorrents(ctx, PendingMaxAttempts)
+	if len(pending) != 0 || len(unmatched) != 1 || unmatched[0].Name != unknown {
+		t.Errorf("pending = %d, unmatched = %+v, want only %q unmatched", len(pending), unmatched, unknown)
+	}
+}
+
+// TestAssignPending 匹配不到的种子记录解析信息, 手动指定番剧后入库并添加别名, 忽略的种子不再列出
+func TestAssignPending(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	assign := "[LoliHouse] 没有这部番 / Nanimo Nai - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	dismiss := "[LoliHouse] 也没有这部番 / Mou Nai - 02 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583&assign=1"
+	network.SetTestCache(rssURL, collectRSS(assign, dismiss))
+	defer network.ClearTestCache(rssURL)
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	r := New(db)
+	if err := r.RefreshRSS(ctx, rssURL, runner); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := db.ListUnmatchedTorrents(ctx, 0)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("ListUnmatchedTorrents() = %d, %v, want 2", len(pending), err)
+	}
+	if p := pending[0]; p.ParsedTitle == "" || p.ParsedEpisode != 1 || p.ParsedGroup != "LoliHouse" {
+		t.Errorf("pending = %+v, want parsed title, episode 1 and group", p)
+	}
+
+	bangumi := &model.Bangumi{OfficialTitle: "没有这部番", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 回收站中的番剧不能指定
+	trashed := &model.Bangumi{OfficialTitle: "已删除的番剧", Season: 1, Deleted: true}
+	if err := db.Save(trashed).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AssignPending(ctx, pending[0].Link, int(trashed.ID), runner); !errors.Is(err, ErrBangumiDeleted) {
+		t.Errorf("AssignPending() deleted bangumi error = %v, want ErrBangumiDeleted", err)
+	}
+	torrent, err := r.AssignPending(ctx, pending[0].Link, int(bangumi.ID), runner)
+	if err != nil || torrent.BangumiID != bangumi.ID {
+		t.Fatalf("AssignPending() = %+v, %v, want bangumi %d", torrent, err, bangumi.ID)
+	}
+	if stored, err := db.GetTorrentByURL(ctx, pending[0].Link); err != nil || stored.BangumiID != bangumi.ID {
+		t.Errorf("stored torrent = %+v, %v, want bangumi %d", stored, err, bangumi.ID)
+	}
+	// 别名让之后的同名种子直接匹配到
+	if match, err := db.GetBangumiParseByTitle(ctx, "[LoliHouse] 没有这部番 / Nanimo Nai - 02 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"); err != nil || match.ID != bangumi.ID {
+		t.Errorf("GetBangumiParseByTitle() = %+v, %v, want bangumi %d", match, err, bangumi.ID)
+	}
+	if _, err := db.GetPendingTorrent(ctx, pending[0].Link); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetPendingTorrent() after assign error = %v, want ErrNotFound", err)
+	}
+
+	if err := r.DismissPending(ctx, pending[1].Link); err != nil {
+		t.Fatal(err)
+	}
+	if left, err := r.ListUnmatched(ctx); err != nil || len(left) != 0 {
+		t.Errorf("ListUnmatched() = %+v, %v, want none", left, err)
+	}
+	if _, err := r.AssignPending(ctx, "magnet:?xt=urn:btih:MISSING", int(bangumi.ID), runner); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("AssignPending() missing error = %v, want ErrNotFound", err)
+	}
+}
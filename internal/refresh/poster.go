@@ -0,0 +1,64 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+)
+
+// SetPosterDir 设置海报的缓存目录
+func (r *Refresher) SetPosterDir(dir string) {
+	r.posterDir = dir
+}
+
+func (r *Refresher) posterCacheDir() string {
+	if r.posterDir != "" {
+		return r.posterDir
+	}
+	return filepath.Join(database.ResolveDataDir(""), "posters")
+}
+
+// posterSource 番剧海报的来源链接, 优先使用番剧自身的, 其次是 TMDB 和 Mikan
+func posterSource(b *model.Bangumi) string {
+	if b.PosterLink != "" {
+		return b.PosterLink
+	}
+	if b.TmdbItem != nil && b.TmdbItem.PosterLink != "" {
+		return b.TmdbItem.PosterLink
+	}
+	if b.MikanItem != nil && b.MikanItem.PosterLink != "" {
+		return b.MikanItem.PosterLink
+	}
+	return ""
+}
+
+// CachePoster 下载番剧海报到本地并记录路径, 前端直接读取本地文件, 不用再访问 TMDB/Mikan
+// 海报按链接的哈希命名, 链接没有变化时直接返回已缓存的路径
+func (r *Refresher) CachePoster(ctx context.Context, bangumiID int) (string, error) {
+	bangumi, err := r.db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return "", err
+	}
+	link := posterSource(bangumi)
+	if link == "" {
+		return "", fmt.Errorf("番剧 %s 没有海报链接", bangumi.OfficialTitle)
+	}
+	localPath, err := network.CacheImage(ctx, link, r.posterCacheDir())
+	if err != nil {
+		slog.Warn("[CachePoster] 缓存海报失败", "番剧", bangumi.OfficialTitle, "链接", link, "error", err)
+		return "", err
+	}
+	if localPath == bangumi.PosterPath {
+		return localPath, nil
+	}
+	if err := r.db.SetBangumiPosterPath(ctx, bangumiID, localPath); err != nil {
+		return "", err
+	}
+	slog.Debug("[CachePoster] 已缓存海报", "番剧", bangumi.OfficialTitle, "路径", localPath)
+	return localPath, nil
+}
@@ -0,0 +1,84 @@
+package refresh
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+func TestCachePoster(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/broken.jpg" {
+			w.Write([]byte("<html>404</html>"))
+			return
+		}
+		w.Write([]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"))
+	}))
+	defer server.Close()
+
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1, PosterLink: server.URL + "/makeine.jpg"}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	r := New(db)
+	r.SetPosterDir(t.TempDir())
+
+	path, err := r.CachePoster(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatalf("CachePoster() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("cached poster %s: %v", path, err)
+	}
+	got, _ := db.GetBangumiByID(ctx, bangumi.ID)
+	if got.PosterPath != path {
+		t.Errorf("PosterPath = %q, want %q", got.PosterPath, path)
+	}
+
+	// 链接没变, 命中缓存
+	again, err := r.CachePoster(ctx, bangumi.ID)
+	if err != nil || again != path || hits.Load() != 1 {
+		t.Errorf("CachePoster() again = %s, %v (hits %d), want cache hit", again, err, hits.Load())
+	}
+
+	// 链接变化后重新下载
+	got.PosterLink = server.URL + "/makeine-v2.jpg"
+	if err := db.UpdateBangumi(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := r.CachePoster(ctx, bangumi.ID)
+	if err != nil || changed == path {
+		t.Errorf("CachePoster() after link change = %s, %v, want a new file", changed, err)
+	}
+
+	// 不是图片时不更新路径
+	got, _ = db.GetBangumiByID(ctx, bangumi.ID)
+	got.PosterLink = server.URL + "/broken.jpg"
+	if err := db.UpdateBangumi(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CachePoster(ctx, bangumi.ID); err == nil {
+		t.Error("CachePoster() on non-image error = nil")
+	}
+	got, _ = db.GetBangumiByID(ctx, bangumi.ID)
+	if got.PosterPath != changed {
+		t.Errorf("PosterPath = %q after failure, want %q", got.PosterPath, changed)
+	}
+}
@@ -0,0 +1,87 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/filter"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+)
+
+// FeedPreview 预览订阅时一个种子的解析、匹配和过滤结果
+type FeedPreview struct {
+	Name string `json:"name"`
+	Link string `json:"link"`
+	// 从标题解析出的名称、季度和集数; 匹配到番剧时集数加上番剧的偏移
+	// 合集的 Episode 为 0, 覆盖的范围在 EpisodeStart 到 EpisodeEnd
+	Title        string            `json:"title"`
+	Season       int               `json:"season"`
+	Episode      int               `json:"episode"`
+	EpisodeStart int               `json:"episode_start,omitempty"`
+	EpisodeEnd   int               `json:"episode_end,omitempty"`
+	EpisodeType  model.EpisodeType `json:"episode_type"`
+	Group        string            `json:"group"`
+	Resolution   string            `json:"resolution"`
+	// Bangumi 按标题匹配到的已有番剧, 没有时为 nil, 订阅后会为它创建新番剧
+	Bangumi *model.Bangumi `json:"bangumi,omitempty"`
+	// Known 种子已经在数据库中, 订阅后不会再处理
+	Known bool `json:"known"`
+	// Filter 匹配到番剧时按番剧实际使用的规则, 否则按全局规则
+	Filter filter.Result `json:"filter"`
+}
+
+// PreviewFeed 拉取订阅, 返回前 n 个种子的解析、匹配和过滤结果, n 不大于 0 时返回全部
+// 用于订阅前确认订阅是否可用; 只按标题匹配已有的番剧, 不查询 Mikan/TMDB, 不会写入数据库
+func (r *Refresher) PreviewFeed(ctx context.Context, url string, n int) ([]FeedPreview, error) {
+	torrents, err := network.GetRequestClient().GetTorrents(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && len(torrents) > n {
+		torrents = torrents[:n]
+	}
+	fresh, err := r.db.CheckNewTorrents(ctx, torrents)
+	if err != nil {
+		return nil, err
+	}
+	isNew := make(map[string]bool, len(fresh))
+	for _, t := range fresh {
+		isNew[t.Link] = true
+	}
+
+	previews := make([]FeedPreview, 0, len(torrents))
+	for _, t := range torrents {
+		meta := parser.NewTitleMetaParse().Parse(t.Name)
+		p := FeedPreview{
+			Name:         t.Name,
+			Link:         t.Link,
+			Title:        meta.Title,
+			Season:       meta.Season,
+			Episode:      meta.Episode,
+			EpisodeStart: meta.EpisodeStart,
+			EpisodeEnd:   meta.EpisodeEnd,
+			EpisodeType:  meta.EpisodeType,
+			Group:        meta.Group,
+			Resolution:   meta.Resolution,
+			Known:        !isNew[t.Link],
+		}
+		bangumi, ambiguous, err := r.matchByTitle(ctx, t)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+		if bangumi != nil && !ambiguous {
+			p.Bangumi = bangumi
+			if p.Episode > 0 {
+				p.Episode += bangumi.Offset
+			}
+			p.Filter = filter.Evaluate(t, filter.ForBangumi(bangumi))
+		} else {
+			p.Filter = filter.Evaluate(t, filter.Global())
+		}
+		previews = append(previews, p)
+	}
+	return previews, nil
+}
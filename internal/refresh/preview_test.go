@@ -0,0 +1,81 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+)
+
+// TestPreviewFeed 预览订阅返回解析结果、匹配到的番剧和过滤结果, 不写入数据库
+func TestPreviewFeed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	bangumi := &model.Bangumi{
+		OfficialTitle:   "葬送的芙莉莲",
+		Season:          1,
+		Offset:          -28,
+		ExcludeFilter:   "720p",
+		EpisodeMetadata: []model.EpisodeMetadata{{Title: "Sousou no Frieren", Group: "LoliHouse", Resolution: "1080p"}},
+	}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 第一个种子已经下载过
+	if err := db.CreateTorrent(ctx, &model.Torrent{Name: "known", Link: "magnet:?xt=urn:btih:COLLECT00", BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "https://mikanani.me/RSS/Preview?case=1"
+	network.SetTestCache(url, collectRSS(
+		"[LoliHouse] 葬送的芙莉莲 / Sousou no Frieren - 29 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] 葬送的芙莉莲 / Sousou no Frieren - 30 [WebRip 720p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] 药屋少女的呢喃 / Kusuriya no Hitorigoto S2 - 03 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+	))
+	defer network.ClearTestCache(url)
+
+	r := New(db)
+	previews, err := r.PreviewFeed(ctx, url, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previews) != 3 {
+		t.Fatalf("PreviewFeed() returned %d items, want 3", len(previews))
+	}
+	want := []struct {
+		title    string
+		season   int
+		episode  int
+		bangumi  int
+		known    bool
+		accepted bool
+	}{
+		{"葬送的芙莉莲", 1, 1, bangumi.ID, true, true},
+		{"葬送的芙莉莲", 1, 2, bangumi.ID, false, false}, // 番剧排除 720p
+		{"药屋少女的呢喃", 2, 3, 0, false, true},
+	}
+	for i, w := range want {
+		p := previews[i]
+		bangumiID := 0
+		if p.Bangumi != nil {
+			bangumiID = p.Bangumi.ID
+		}
+		if p.Title != w.title || p.Season != w.season || p.Episode != w.episode || bangumiID != w.bangumi ||
+			p.Known != w.known || p.Filter.Accepted != w.accepted {
+			t.Errorf("preview[%d] = %+v, want %+v", i, p, w)
+		}
+	}
+
+	// 没有写入任何种子或番剧
+	var torrents, bangumis int64
+	db.Model(&model.Torrent{}).Count(&torrents)
+	db.Model(&model.Bangumi{}).Count(&bangumis)
+	if torrents != 1 || bangumis != 1 {
+		t.Errorf("torrents = %d, bangumis = %d after preview, want 1 and 1", torrents, bangumis)
+	}
+}
@@ -0,0 +1,258 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/search"
+)
+
+// BangumiProgress 番剧的下载进度
+type BangumiProgress struct {
+	BangumiID int
+	Total     int   // 总集数, TMDB 没有信息时为 0
+	Have      []int // 已有的集数(下载完成)
+	// Downloading 已入队或正在下载的集数, 不算已有, 也不算缺失, 不需要再找种子
+	Downloading []int
+	Missing     []int // 已有或正在下载的最大集数之前缺失的集数
+	Latest      int   // 已有的最新一集, 没有时为 0
+}
+
+// episodeRange 从种子名解析出覆盖的集数, 合集返回整个范围
+// SP/OVA/剧场版和半集不算正片, 不参与进度和缺集计算
+func episodeRange(name string, offset int) []int {
+	meta := parser.NewTitleMetaParse().Parse(name)
+	if meta.Collection {
+		if meta.EpisodeStart <= 0 || meta.EpisodeEnd < meta.EpisodeStart {
+			return nil
+		}
+		eps := make([]int, 0, meta.EpisodeEnd-meta.EpisodeStart+1)
+		for ep := meta.EpisodeStart; ep <= meta.EpisodeEnd; ep++ {
+			eps = append(eps, ep+offset)
+		}
+		return eps
+	}
+	if meta.Episode <= 0 || meta.EpisodeType != model.EpisodeRegular {
+		return nil
+	}
+	return []int{meta.Episode + offset}
+}
+
+// GetBangumiProgress 计算番剧的下载进度
+// 缺失的集数只统计已有或正在下载的最大集数之前的空缺, 之后的集数可能还没有播出
+func (r *Refresher) GetBangumiProgress(ctx context.Context, bangumiID int) (*BangumiProgress, error) {
+	bangumi, err := r.db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return nil, err
+	}
+	return r.bangumiProgress(ctx, bangumi)
+}
+
+func (r *Refresher) bangumiProgress(ctx context.Context, bangumi *model.Bangumi) (*BangumiProgress, error) {
+	torrents, err := r.db.ListTorrentByBangumiID(ctx, bangumi.ID)
+	if err != nil {
+		return nil, err
+	}
+	progress := &BangumiProgress{BangumiID: bangumi.ID}
+	if bangumi.TmdbItem != nil {
+		progress.Total, _, _ = bangumi.TmdbItem.SeasonEpisodes(bangumi.Season)
+	}
+	// 只有下载完成的才算已有, 失败和被替代的不算, 其余的还在下载
+	have := make(map[int]struct{})
+	downloading := make(map[int]struct{})
+	for _, t := range torrents {
+		var target map[int]struct{}
+		switch t.Downloaded {
+		case model.DownloadDone:
+			target = have
+		case model.DownloadNone, model.DownloadSending, model.DownloadHeld:
+			target = downloading
+		default:
+			continue
+		}
+		for _, ep := range episodeRange(t.Name, bangumi.Offset) {
+			target[ep] = struct{}{}
+		}
+	}
+	// 剧集表中还有种子已经被清理的集数, 以及从种子名解析不出集数的情况
+	episodes, err := r.db.ListEpisodes(ctx, bangumi.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range episodes {
+		if e.Season != bangumi.Season {
+			continue
+		}
+		switch e.State {
+		case model.EpisodeDownloaded, model.EpisodeRenamed:
+			have[e.Number] = struct{}{}
+		case model.EpisodeDownloading:
+			downloading[e.Number] = struct{}{}
+		}
+	}
+	latestKnown := 0
+	for ep := range have {
+		progress.Have = append(progress.Have, ep)
+		latestKnown = max(latestKnown, ep)
+	}
+	for ep := range downloading {
+		if _, ok := have[ep]; ok {
+			continue
+		}
+		progress.Downloading = append(progress.Downloading, ep)
+		latestKnown = max(latestKnown, ep)
+	}
+	slices.Sort(progress.Have)
+	slices.Sort(progress.Downloading)
+	if len(progress.Have) > 0 {
+		progress.Latest = progress.Have[len(progress.Have)-1]
+	}
+	for ep := 1; ep < latestKnown; ep++ {
+		_, ok := have[ep]
+		_, pending := downloading[ep]
+		if !ok && !pending {
+			progress.Missing = append(progress.Missing, ep)
+		}
+	}
+	return progress, nil
+}
+
+// FindMissingEpisodes 重新拉取番剧的 RSS, 找出能补上缺失集数的种子
+// 同一集有多个候选时, 优先选择字幕组和分辨率与番剧已有解析信息一致的
+// 返回的种子已经关联到番剧, 可以直接入队
+func (r *Refresher) FindMissingEpisodes(ctx context.Context, bangumiID int) ([]*model.Torrent, error) {
+	bangumi, err := r.db.GetBangumiWithDetails(ctx, uint(bangumiID))
+	if err != nil {
+		return nil, err
+	}
+	progress, err := r.bangumiProgress(ctx, bangumi)
+	if err != nil {
+		return nil, err
+	}
+	return r.searchEpisodes(ctx, bangumi, progress.Missing)
+}
+
+// searchEpisodes 在番剧的 RSS、Mikan 番剧页面的 RSS 和设置的搜索站点中为 eps 中的每一集找一个种子, 按 eps 的顺序返回
+// 番剧的 RSS 可能是聚合订阅, 搜索结果也可能有其他番剧, 只要匹配到这个番剧的种子; Mikan 番剧页面的 RSS 只有这个番剧的种子
+// 只有所有 RSS 都拉取失败时才返回错误
+func (r *Refresher) searchEpisodes(ctx context.Context, bangumi *model.Bangumi, eps []int) ([]*model.Torrent, error) {
+	if len(eps) == 0 {
+		return nil, nil
+	}
+	type source struct {
+		link    string
+		trusted bool
+		// 不为空时按关键字在站点中搜索, link 为关键字
+		provider search.Provider
+	}
+	var sources []source
+	if bangumi.RSSLink != "" {
+		sources = append(sources, source{link: bangumi.RSSLink})
+	}
+	if link, trusted := backfillLink(bangumi); trusted && link != bangumi.RSSLink {
+		sources = append(sources, source{link: link, trusted: true})
+	}
+	if keyword := searchKeyword(bangumi); keyword != "" {
+		for _, p := range r.searchProviders {
+			sources = append(sources, source{link: keyword, provider: p})
+		}
+	}
+	if len(sources) == 0 {
+		slog.Debug("[FindMissingEpisodes] 番剧没有 RSS 链接", "番剧", bangumi.OfficialTitle)
+		return nil, nil
+	}
+
+	missing := make(map[int]struct{}, len(eps))
+	for _, ep := range eps {
+		missing[ep] = struct{}{}
+	}
+	best := make(map[int]*model.Torrent)
+	bestScore := make(map[int]int)
+	var fetchErr error
+	fetched := 0
+	for _, src := range sources {
+		var torrents []*model.Torrent
+		var err error
+		if src.provider != nil {
+			torrents, err = r.searchNewTorrents(ctx, src.provider, src.link)
+		} else {
+			torrents, err = r.fetchNewTorrents(ctx, src.link)
+		}
+		if err != nil {
+			slog.Warn("[FindMissingEpisodes] 拉取 RSS 失败", "番剧", bangumi.OfficialTitle, "URL", network.RedactURL(src.link), "error", err)
+			fetchErr = err
+			continue
+		}
+		fetched++
+		for _, t := range torrents {
+			if !src.trusted {
+				match, err := r.db.GetBangumiParseByTitle(ctx, t.Name)
+				if err != nil {
+					if !errors.Is(err, database.ErrNotFound) {
+						return nil, err
+					}
+					continue
+				}
+				if match.ID != bangumi.ID {
+					continue
+				}
+			}
+			meta := parser.NewTitleMetaParse().Parse(t.Name)
+			if meta.Collection || meta.EpisodeType != model.EpisodeRegular {
+				continue
+			}
+			ep := meta.Episode + bangumi.Offset
+			if _, ok := missing[ep]; !ok {
+				continue
+			}
+			if !FilterBangumiTorrent(t, bangumi) {
+				continue
+			}
+			score := preferenceScore(meta, bangumi.EpisodeMetadata)
+			if old, ok := bestScore[ep]; ok && old >= score {
+				continue
+			}
+			best[ep] = t
+			bestScore[ep] = score
+		}
+	}
+
+	if fetched == 0 {
+		return nil, fetchErr
+	}
+
+	var result []*model.Torrent
+	for _, ep := range eps {
+		t, ok := best[ep]
+		if !ok {
+			continue
+		}
+		t.BangumiID = bangumi.ID
+		t.Bangumi = bangumi
+		result = append(result, t)
+	}
+	slog.Info("[FindMissingEpisodes] 查找缺失集数", "番剧", bangumi.OfficialTitle, "缺失", eps, "找到", len(result))
+	return result, nil
+}
+
+// preferenceScore 种子与番剧已有解析信息的匹配程度, 字幕组比分辨率更重要
+func preferenceScore(meta *model.EpisodeMetadata, prefs []model.EpisodeMetadata) int {
+	score := 0
+	for _, p := range prefs {
+		s := 0
+		if p.Group != "" && p.Group == meta.Group {
+			s += 2
+		}
+		if p.Resolution != "" && p.Resolution == meta.Resolution {
+			s++
+		}
+		score = max(score, s)
+	}
+	return score
+}
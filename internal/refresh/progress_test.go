@@ -0,0 +1,166 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+)
+
+// TestFindMissingEpisodes 已有 1,2,3,5 集, 应该从 RSS 中找到第 4 集
+// RSS 源: https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=370
+func TestFindMissingEpisodes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=370"
+	r := New(db)
+	r.FindNewBangumi(ctx, &model.RSSItem{Name: "败犬女主太多了！", Link: rssURL})
+	bangumis, err := db.ListBangumi(ctx)
+	if err != nil || len(bangumis) != 1 {
+		t.Fatalf("期望创建 1 个番剧, 实际 %d 个, err: %v", len(bangumis), err)
+	}
+	bangumi := bangumis[0]
+
+	// 入库第 1,2,3,5 集
+	torrents, err := network.GetRequestClient().GetTorrents(ctx, rssURL)
+	if err != nil {
+		t.Fatalf("获取种子失败: %v", err)
+	}
+	for _, torrent := range torrents {
+		meta := parser.NewTitleMetaParse().Parse(torrent.Name)
+		if meta.Collection || !slices.Contains([]int{1, 2, 3, 5}, meta.Episode) {
+			continue
+		}
+		torrent.BangumiID = bangumi.ID
+		torrent.Downloaded = model.DownloadDone
+		if err := db.CreateTorrent(ctx, torrent); err != nil {
+			t.Fatalf("创建种子失败: %v", err)
+		}
+	}
+
+	progress, err := r.GetBangumiProgress(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatalf("GetBangumiProgress 失败: %v", err)
+	}
+	if !slices.Equal(progress.Have, []int{1, 2, 3, 5}) {
+		t.Errorf("Have = %v, 期望 [1 2 3 5]", progress.Have)
+	}
+	if progress.Latest != 5 {
+		t.Errorf("Latest = %d, 期望 5", progress.Latest)
+	}
+	if !slices.Equal(progress.Missing, []int{4}) {
+		t.Fatalf("Missing = %v, 期望 [4]", progress.Missing)
+	}
+
+	found, err := r.FindMissingEpisodes(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatalf("FindMissingEpisodes 失败: %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("期望找到 1 个种子, 实际 %d 个", len(found))
+	}
+	if ep := parser.NewTitleMetaParse().Parse(found[0].Name).Episode; ep != 4 {
+		t.Errorf("找到的种子集数 = %d, 期望 4: %s", ep, found[0].Name)
+	}
+	if found[0].BangumiID != bangumi.ID {
+		t.Errorf("种子 BangumiID = %d, 期望 %d", found[0].BangumiID, bangumi.ID)
+	}
+}
+
+func TestEpisodeRange(t *testing.T) {
+	tests := []struct {
+		name string
+		want []int
+	}{
+		{"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]", []int{5}},
+		{"[Vivy -Fluorite Eye's Song-][01-03END][720p][简体]", []int{1, 2, 3}},
+		// 特别篇不计入正片集数
+		{"[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - SP01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]", nil},
+		{"[ANi] 葬送的芙莉莲 - OVA [1080P][Baha][WEB-DL][AAC AVC][CHT][MP4]", nil},
+		{"[LoliHouse] Oshi no Ko - 12.5 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]", nil},
+	}
+	for _, tt := range tests {
+		if got := episodeRange(tt.name, 0); !slices.Equal(got, tt.want) {
+			t.Errorf("episodeRange(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreferenceScore(t *testing.T) {
+	prefs := []model.EpisodeMetadata{{Group: "喵萌奶茶屋&LoliHouse", Resolution: "1080p"}}
+	tests := []struct {
+		name string
+		meta model.EpisodeMetadata
+		want int
+	}{
+		{"group and resolution", model.EpisodeMetadata{Group: "喵萌奶茶屋&LoliHouse", Resolution: "1080p"}, 3},
+		{"group only", model.EpisodeMetadata{Group: "喵萌奶茶屋&LoliHouse", Resolution: "720p"}, 2},
+		{"resolution only", model.EpisodeMetadata{Group: "ANi", Resolution: "1080p"}, 1},
+		{"none", model.EpisodeMetadata{Group: "ANi", Resolution: "720p"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preferenceScore(&tt.meta, prefs); got != tt.want {
+				t.Errorf("preferenceScore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBangumiProgressDownloading 只有下载完成的集数算已有, 还在下载的既不算已有也不算缺失
+func TestBangumiProgressDownloading(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	states := map[int]model.DownloadStatus{
+		1: model.DownloadDone,
+		2: model.DownloadNone,
+		3: model.DownloadSending,
+		4: model.DownloadError,
+		5: model.DownloadDone,
+	}
+	for ep, state := range states {
+		torrent := &model.Torrent{
+			Link:       fmt.Sprintf("magnet:?xt=urn:btih:PROGRESS%02d", ep),
+			Name:       fmt.Sprintf("[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - %02d [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]", ep),
+			BangumiID:  bangumi.ID,
+			Downloaded: state,
+		}
+		if err := db.CreateTorrent(ctx, torrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 剧集表中正在下载的集数同样不算已有
+	if err := db.TrackEpisodes(ctx, bangumi.ID, 1, []int{6}, "magnet:?xt=urn:btih:PROGRESS06"); err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := New(db).GetBangumiProgress(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(progress.Have, []int{1, 5}) || !slices.Equal(progress.Downloading, []int{2, 3, 6}) {
+		t.Errorf("Have = %v, Downloading = %v, want [1 5] and [2 3 6]", progress.Have, progress.Downloading)
+	}
+	if !slices.Equal(progress.Missing, []int{4}) || progress.Latest != 5 {
+		t.Errorf("Missing = %v, Latest = %d, want [4] and 5", progress.Missing, progress.Latest)
+	}
+}
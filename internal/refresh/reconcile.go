@@ -0,0 +1,28 @@
+package refresh
+
+import (
+	"context"
+	"log/slog"
+
+	"goto-bangumi/internal/parser"
+)
+
+// SetBangumiTmdbAndReresolve 手动为番剧指定 TMDB 条目, 并重新获取 TMDB 信息
+// 用于 ListBangumiMissingMetadata 列出的解析失败的番剧
+// 番剧的年份和海报为空时用 TMDB 的信息补上, 官方标题保持不变
+func (r *Refresher) SetBangumiTmdbAndReresolve(ctx context.Context, bangumiID, tmdbID int) error {
+	bangumi, err := r.db.GetBangumiByID(ctx, bangumiID)
+	if err != nil {
+		return err
+	}
+	item, err := parser.NewTMDBParse().TMDBItem(ctx, tmdbID, parser.TmdbLanguage())
+	if err != nil {
+		slog.Warn("[SetBangumiTmdbAndReresolve] 获取 TMDB 信息失败", "番剧", bangumi.OfficialTitle, "tmdb_id", tmdbID, "error", err)
+		return err
+	}
+	if err := r.db.SetBangumiTmdb(ctx, bangumiID, item); err != nil {
+		return err
+	}
+	slog.Info("[SetBangumiTmdbAndReresolve] 已关联 TMDB", "番剧", bangumi.OfficialTitle, "tmdb", item.Title, "总集数", item.EpisodeCount)
+	return nil
+}
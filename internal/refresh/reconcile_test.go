@@ -0,0 +1,59 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+func TestSetBangumiTmdbAndReresolve(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1, EpsCollect: true}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	missing, err := db.ListBangumiMissingMetadata(ctx)
+	if err != nil || len(missing) != 1 {
+		t.Fatalf("ListBangumiMissingMetadata() = %v, %v, want the unresolved bangumi", missing, err)
+	}
+
+	r := New(db)
+	if err := r.SetBangumiTmdbAndReresolve(ctx, bangumi.ID, 241535); err != nil {
+		t.Fatalf("SetBangumiTmdbAndReresolve() error = %v", err)
+	}
+
+	got, err := db.GetBangumiWithDetails(ctx, uint(bangumi.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TmdbItem == nil || got.TmdbItem.ID != 241535 {
+		t.Fatalf("TmdbItem = %+v, want 241535", got.TmdbItem)
+	}
+	if got.TmdbItem.EpisodeCount == 0 {
+		t.Error("TmdbItem.EpisodeCount not filled")
+	}
+	if got.Year == "" || got.PosterLink == "" {
+		t.Errorf("Year = %q, PosterLink = %q, want filled from TMDB", got.Year, got.PosterLink)
+	}
+	if got.OfficialTitle != "败犬女主太多了！" {
+		t.Errorf("OfficialTitle = %q, want unchanged", got.OfficialTitle)
+	}
+	missing, err = db.ListBangumiMissingMetadata(ctx)
+	if err != nil || len(missing) != 0 {
+		t.Errorf("ListBangumiMissingMetadata() = %v, %v, want empty", missing, err)
+	}
+
+	if err := r.SetBangumiTmdbAndReresolve(ctx, 9999, 241535); err == nil {
+		t.Error("SetBangumiTmdbAndReresolve() on unknown bangumi error = nil")
+	}
+}
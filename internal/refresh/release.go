@@ -0,0 +1,208 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// 挑选最佳版本: 同一集往往有多个字幕组、多个分辨率的种子, 陆续出现在 RSS 中
+// 启用挑选窗口时, 一集的第一个种子入库后先等待(DownloadHeld), 窗口内出现的同一集的种子也一起等待,
+// 窗口结束后由 ReleaseHeld 按番剧的下载偏好挑出最好的一个入队, 其余的标记为已替代
+// 启用升级宽限期时, 一集已经入队的种子在宽限期内出现了更好的版本, 下载新版本并替换旧的
+// 只对单集正片生效, 合集、SP 等照常下载; 同一字幕组的修正版由 checkRevision 处理
+
+// SetReleaseWindow 设置同一集挑选最佳版本的等待时间 window 和升级的宽限期 grace, 为 0 时不启用
+func (r *Refresher) SetReleaseWindow(window, grace time.Duration) {
+	r.releaseWindow = max(window, 0)
+	r.upgradeGrace = max(grace, 0)
+}
+
+// releaseEpisode 种子是番剧的哪一集正片, 合集、SP 等返回 false
+func releaseEpisode(name string, bangumi *model.Bangumi) (int, *model.EpisodeMetadata, bool) {
+	meta := parser.NewTitleMetaParse().Parse(name)
+	if meta == nil || meta.Collection || meta.EpisodeType != model.EpisodeRegular || meta.Episode <= 0 {
+		return 0, nil, false
+	}
+	return meta.Episode + bangumi.Offset, meta, true
+}
+
+// releaseScore 种子按番剧的下载偏好的得分, 偏好相同时选与番剧已有解析信息一致的
+func releaseScore(meta *model.EpisodeMetadata, bangumi *model.Bangumi) int {
+	return bangumi.Preference().Score(*meta)*10 + preferenceScore(meta, bangumi.EpisodeMetadata)
+}
+
+// selectRelease 按挑选窗口和升级宽限期决定新种子是等待、下载还是跳过, 结果写在 t.Downloaded:
+// 同一集已有种子在等待时一起等待(DownloadHeld); 已有入队的种子时, 宽限期内的更好版本替换它,
+// 否则跳过(DownloadReplaced); 还没有这一集时启用窗口则开始等待, 不启用则直接下载
+// pending 是本次刷新中还没有写入数据库的种子
+func (r *Refresher) selectRelease(ctx context.Context, t *model.Torrent, pending []*model.Torrent, now time.Time) {
+	if r.releaseWindow <= 0 && r.upgradeGrace <= 0 || t.Downloaded != model.DownloadNone {
+		return
+	}
+	bangumi := t.Bangumi
+	if bangumi == nil || bangumi.ID == 0 {
+		return
+	}
+	ep, meta, ok := releaseEpisode(t.Name, bangumi)
+	if !ok {
+		return
+	}
+	existing, err := r.db.ListTorrentByBangumiID(ctx, bangumi.ID)
+	if err != nil {
+		slog.Warn("[selectRelease]获取番剧种子失败", "番剧", bangumi.OfficialTitle, "error", err)
+		return
+	}
+	existing = append(existing, pendingOf(pending, bangumi)...)
+	seasonOf := r.releaseSeasons(ctx, bangumi)
+
+	var active []*model.Torrent
+	var held bool
+	for _, old := range existing {
+		if old.Link == t.Link || old.Downloaded == model.DownloadError || old.Downloaded == model.DownloadReplaced {
+			continue
+		}
+		// 后面的季度和番剧存在一起, 集数只在同一季内比较
+		oldBangumi := seasonOf(old)
+		if oldBangumi.Season != bangumi.Season {
+			continue
+		}
+		if oldEp, _, ok := releaseEpisode(old.Name, oldBangumi); !ok || oldEp != ep {
+			continue
+		}
+		if old.Downloaded == model.DownloadHeld {
+			held = true
+		} else {
+			active = append(active, old)
+		}
+	}
+
+	switch {
+	case held:
+		t.Downloaded = model.DownloadHeld
+		slog.Debug("[selectRelease]同一集在挑选窗口中, 等待", "种子名称", t.Name)
+	case len(active) > 0:
+		score := releaseScore(meta, bangumi)
+		for _, old := range active {
+			_, oldMeta, _ := releaseEpisode(old.Name, bangumi)
+			// 还没有入库的种子 CreatedAt 为零值, 当作刚刚入队
+			queued := old.CreatedAt
+			if queued.IsZero() {
+				queued = now
+			}
+			if r.upgradeGrace <= 0 || now.Sub(queued) > r.upgradeGrace || releaseScore(oldMeta, bangumi) >= score {
+				slog.Debug("[selectRelease]同一集已有不差的版本, 跳过", "种子名称", t.Name, "已有", old.Name)
+				t.Downloaded = model.DownloadReplaced
+				return
+			}
+		}
+		for _, old := range active {
+			slog.Info("[selectRelease]发现更好的版本, 替换", "旧种子", old.Name, "新种子", t.Name)
+			r.replaceTorrent(ctx, old, pending)
+		}
+	case r.releaseWindow > 0:
+		t.Downloaded = model.DownloadHeld
+		slog.Debug("[selectRelease]开始挑选窗口", "种子名称", t.Name, "窗口", r.releaseWindow)
+	}
+}
+
+// releaseSeasons 返回查询已有种子所属季度视图的函数, 见 seasonBangumi
+// bangumi 可能已经是某一季的视图, 已有种子按番剧本身解析季度; 本次刷新中的种子已经带有季度视图, 直接使用
+// 番剧只有一季时所有种子都使用 bangumi
+func (r *Refresher) releaseSeasons(ctx context.Context, bangumi *model.Bangumi) func(*model.Torrent) *model.Bangumi {
+	single := func(*model.Torrent) *model.Bangumi { return bangumi }
+	seasons, err := r.db.ListSeasons(ctx, bangumi.ID)
+	if err != nil || len(seasons) <= 1 {
+		return single
+	}
+	base, err := r.db.GetBangumiByID(ctx, bangumi.ID)
+	if err != nil {
+		slog.Warn("[selectRelease]获取番剧失败", "番剧", bangumi.OfficialTitle, "error", err)
+		return single
+	}
+	return func(t *model.Torrent) *model.Bangumi {
+		if t.Bangumi != nil && t.Bangumi.ID == bangumi.ID {
+			return t.Bangumi
+		}
+		return r.seasonBangumi(ctx, base, t)
+	}
+}
+
+// ReleaseHeld 挑选窗口已经结束的集数, 按下载偏好选出最好的种子入队, 其余的标记为已替代, 返回入队的数量
+// 窗口从一集的第一个种子入库开始计算; 没有启用窗口时(例如修改了配置)所有等待的种子立即处理
+func (r *Refresher) ReleaseHeld(ctx context.Context, runner *taskrunner.TaskRunner) (int, error) {
+	held, err := r.db.ListHeldTorrents(ctx)
+	if err != nil || len(held) == 0 {
+		return 0, err
+	}
+	type key struct{ bangumiID, season, episode int }
+	groups := make(map[key][]*model.Torrent)
+	var order []key
+	bangumis := make(map[int]*model.Bangumi)
+	now := time.Now()
+	for _, t := range held {
+		bangumi, ok := bangumis[t.BangumiID]
+		if !ok {
+			bangumi, err = r.db.GetBangumiWithDetails(ctx, uint(t.BangumiID))
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				return 0, err
+			}
+			bangumis[t.BangumiID] = bangumi
+		}
+		if bangumi == nil {
+			// 番剧已经彻底删除, 不再下载
+			r.replaceTorrent(ctx, t, nil)
+			continue
+		}
+		t.Bangumi = r.seasonBangumi(ctx, bangumi, t)
+		ep, _, _ := releaseEpisode(t.Name, t.Bangumi)
+		k := key{t.BangumiID, t.Bangumi.Season, ep}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], t)
+	}
+
+	released := 0
+	for _, k := range order {
+		candidates := groups[k]
+		// 按入库时间排序, 第一个种子入库时开始计时
+		if now.Sub(candidates[0].CreatedAt) < r.releaseWindow {
+			continue
+		}
+		best, bestScore := candidates[0], -1
+		for _, t := range candidates {
+			_, meta, _ := releaseEpisode(t.Name, t.Bangumi)
+			score := 0
+			if meta != nil {
+				score = releaseScore(meta, t.Bangumi)
+			}
+			if score > bestScore {
+				best, bestScore = t, score
+			}
+		}
+		if err := r.db.ReleaseHeldTorrent(ctx, best.Link); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				continue
+			}
+			return released, err
+		}
+		best.Downloaded = model.DownloadNone
+		for _, t := range candidates {
+			if t != best {
+				r.replaceTorrent(ctx, t, nil)
+			}
+		}
+		slog.Info("[ReleaseHeld]挑选窗口结束, 下载最佳版本", "番剧", best.Bangumi.OfficialTitle, "集数", k.episode,
+			"种子名称", best.Name, "候选数量", len(candidates))
+		r.enqueue(ctx, best, "挑选窗口", runner)
+		released++
+	}
+	return released, nil
+}
@@ -0,0 +1,133 @@
+package refresh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// TestReleaseWindow 窗口内同一集的种子一起等待, 结束后下载最好的一个; 宽限期内出现更好的版本时替换
+func TestReleaseWindow(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	bangumi.SetPreference(model.ReleasePreference{Resolutions: []string{"1080p", "720p"}, Groups: []string{"LoliHouse", "桜都字幕组"}, AllowBatch: true})
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	torrent := func(link, name string) *model.Torrent {
+		return &model.Torrent{Link: link, Name: name, Bangumi: bangumi}
+	}
+	sakura720 := torrent("magnet:?xt=urn:btih:SAKURA720", "[桜都字幕组] 败犬女主太多了！ / Make Heroine ga Oosugiru! [01][720p][简繁内封]")
+	sakura1080 := torrent("magnet:?xt=urn:btih:SAKURA1080", "[桜都字幕组] 败犬女主太多了！ / Make Heroine ga Oosugiru! [01][1080p][简繁内封]")
+	lolihouse := torrent("magnet:?xt=urn:btih:LOLIHOUSE", "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]")
+	late := torrent("magnet:?xt=urn:btih:LATE", "[桜都字幕组] 败犬女主太多了！ / Make Heroine ga Oosugiru! [01][1080p][繁体内嵌]")
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	r := New(db)
+	r.SetReleaseWindow(30*time.Minute, time.Hour)
+	status := func(link string) model.DownloadStatus {
+		t.Helper()
+		got, err := db.GetTorrentByURL(ctx, link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Downloaded
+	}
+
+	// 两次刷新分别出现的种子都在等待
+	for _, tt := range []*model.Torrent{sakura720, sakura1080} {
+		if err := r.submitTorrents(ctx, []*model.Torrent{tt}, "test", runner); err != nil {
+			t.Fatal(err)
+		}
+		if got := status(tt.Link); got != model.DownloadHeld {
+			t.Errorf("%s status = %d, want held", tt.Name, got)
+		}
+	}
+	if n, err := r.ReleaseHeld(ctx, runner); err != nil || n != 0 {
+		t.Errorf("ReleaseHeld() within window = %d, %v, want 0", n, err)
+	}
+
+	// 窗口结束后下载分辨率更好的那个
+	r.SetReleaseWindow(0, time.Hour)
+	if n, err := r.ReleaseHeld(ctx, runner); err != nil || n != 1 {
+		t.Fatalf("ReleaseHeld() = %d, %v, want 1", n, err)
+	}
+	if got := status(sakura1080.Link); got != model.DownloadNone {
+		t.Errorf("best release status = %d, want none (queued)", got)
+	}
+	if got := status(sakura720.Link); got != model.DownloadReplaced {
+		t.Errorf("other release status = %d, want replaced", got)
+	}
+
+	// 宽限期内出现了偏好的字幕组, 替换已经入队的; 之后不比它好的版本跳过
+	if err := r.submitTorrents(ctx, []*model.Torrent{lolihouse}, "test", runner); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(lolihouse.Link); got != model.DownloadNone {
+		t.Errorf("upgrade status = %d, want none (queued)", got)
+	}
+	if got := status(sakura1080.Link); got != model.DownloadReplaced {
+		t.Errorf("upgraded release status = %d, want replaced", got)
+	}
+	if err := r.submitTorrents(ctx, []*model.Torrent{late}, "test", runner); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(late.Link); got != model.DownloadReplaced {
+		t.Errorf("worse release status = %d, want replaced", got)
+	}
+}
+
+// TestReleaseWindowSeasons 第二季和番剧存在一起, 第二季的第 1 集不和第一季的第 1 集比较
+func TestReleaseWindowSeasons(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+	bangumi := &model.Bangumi{OfficialTitle: "我推的孩子", Season: 1, TmdbItem: &model.TmdbItem{ID: 203737}, EpisodeMetadata: []model.EpisodeMetadata{
+		{Title: "Oshi no Ko", Group: "SubsPlease", Season: 1},
+	}}
+	if err := db.CreateBangumi(ctx, bangumi); err != nil {
+		t.Fatal(err)
+	}
+	s2 := &model.Bangumi{OfficialTitle: "我推的孩子", Season: 2, TmdbItem: &model.TmdbItem{ID: 203737}, EpisodeMetadata: []model.EpisodeMetadata{
+		{Title: "Oshi no Ko S2", Group: "SubsPlease", Season: 2},
+	}}
+	if err := db.CreateBangumi(ctx, s2); err != nil {
+		t.Fatal(err)
+	}
+	first := &model.Torrent{Link: "magnet:?xt=urn:btih:S1E01", Name: "[SubsPlease] Oshi no Ko - 01 (1080p)", BangumiID: bangumi.ID, Downloaded: model.DownloadDone}
+	if err := db.Save(first).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(db)
+	r.SetReleaseWindow(30*time.Minute, 0)
+	second := &model.Torrent{Link: "magnet:?xt=urn:btih:S2E01", Name: "[SubsPlease] Oshi no Ko S2 - 01 (1080p)"}
+	second.Bangumi = r.seasonBangumi(ctx, bangumi, second)
+	if second.Bangumi.Season != 2 {
+		t.Fatalf("seasonBangumi() season = %d, want 2", second.Bangumi.Season)
+	}
+	r.selectRelease(ctx, second, nil, time.Now())
+	if second.Downloaded != model.DownloadHeld {
+		t.Errorf("season 2 episode 1 status = %d, want held", second.Downloaded)
+	}
+
+	// 同一季的同一集仍然跳过
+	again := &model.Torrent{Link: "magnet:?xt=urn:btih:S1E01V2", Name: "[SubsPlease] Oshi no Ko - 01 (720p)", Bangumi: bangumi}
+	r.selectRelease(ctx, again, nil, time.Now())
+	if again.Downloaded != model.DownloadReplaced {
+		t.Errorf("season 1 episode 1 status = %d, want replaced", again.Downloaded)
+	}
+}
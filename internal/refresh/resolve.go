@@ -0,0 +1,115 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+var (
+	// ErrResolveInProgress 同一个标题正在被其他刷新创建
+	ErrResolveInProgress = errors.New("番剧正在创建中")
+	// ErrResolveBackoff 标题之前解析失败, 还没到下次重试的时间
+	ErrResolveBackoff = errors.New("番剧解析失败, 等待重试")
+)
+
+// 解析失败后的退避时间, 每失败一次翻倍, 最长一天
+const (
+	resolveBackoffBase = 30 * time.Minute
+	resolveBackoffMax  = 24 * time.Hour
+)
+
+// defaultResolveWorkers FindNewBangumi 默认同时解析的番剧数量
+const defaultResolveWorkers = 3
+
+// SetResolveConcurrency 设置 FindNewBangumi 同时解析的番剧数量上限, 不大于 0 时使用默认值
+func (r *Refresher) SetResolveConcurrency(n int) {
+	r.resolveWorkers = max(n, 0)
+}
+
+// resolveConcurrency 同时解析的番剧数量上限
+func (r *Refresher) resolveConcurrency() int {
+	if r.resolveWorkers > 0 {
+		return r.resolveWorkers
+	}
+	return defaultResolveWorkers
+}
+
+// groupByResolveKey 按 resolveKey 把种子分组, 组的顺序和组内的顺序都为第一次出现的顺序
+// 同一组的种子在同一个 worker 中依次解析, 不会同时为一个标题请求 Mikan 和 TMDB
+func groupByResolveKey(torrents []*model.Torrent) [][]*model.Torrent {
+	index := make(map[string]int, len(torrents))
+	var groups [][]*model.Torrent
+	for _, t := range torrents {
+		key := resolveKey(t)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], t)
+	}
+	return groups
+}
+
+// resolveKey 用于去重和退避的标题, 同一个番剧的不同集数对应同一个 key
+// 解析不出标题时退回到种子名称
+func resolveKey(torrent *model.Torrent) string {
+	meta := parser.NewTitleMetaParse().Parse(torrent.Name)
+	if meta.Title == "" {
+		return torrent.Name
+	}
+	return fmt.Sprintf("%s|%d", meta.Title, meta.Season)
+}
+
+// resolveBackoff 第 attempts 次失败后需要等待的时间
+func resolveBackoff(attempts int) time.Duration {
+	backoff := resolveBackoffBase
+	for i := 1; i < attempts && backoff < resolveBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, resolveBackoffMax)
+}
+
+// checkResolveBackoff 检查标题是否还在退避期内
+func (r *Refresher) checkResolveBackoff(ctx context.Context, key string) error {
+	attempt, err := r.db.GetResolveAttempt(ctx, key)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	if time.Now().Before(attempt.NextRetry) {
+		slog.Debug("[createBangumi] 番剧解析退避中", "标题", key, "失败次数", attempt.Attempts, "下次重试", attempt.NextRetry)
+		return ErrResolveBackoff
+	}
+	return nil
+}
+
+// recordResolveFailure 记录一次解析失败并计算下次重试时间
+func (r *Refresher) recordResolveFailure(ctx context.Context, key string, cause error) {
+	attempt, err := r.db.GetResolveAttempt(ctx, key)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotFound) {
+			slog.Error("[createBangumi] 查询解析失败记录失败", "标题", key, "error", err)
+			return
+		}
+		attempt = &model.ResolveAttempt{Key: key}
+	}
+	attempt.Attempts++
+	attempt.LastError = cause.Error()
+	attempt.NextRetry = time.Now().Add(resolveBackoff(attempt.Attempts))
+	if err := r.db.SaveResolveAttempt(ctx, attempt); err != nil {
+		slog.Error("[createBangumi] 保存解析失败记录失败", "标题", key, "error", err)
+		return
+	}
+	slog.Warn("[createBangumi] 番剧解析失败, 稍后重试", "标题", key, "失败次数", attempt.Attempts, "下次重试", attempt.NextRetry, "error", cause)
+}
@@ -0,0 +1,265 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/notification"
+)
+
+// TestCreateBangumi_ResolveBackoff 同一个无法解析的标题连续出现在三次刷新中, 只应该请求一次
+func TestCreateBangumi_ResolveBackoff(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// mikan 页面一直返回 404, 解析必然失败
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	r := New(db)
+	rssItem := &model.RSSItem{Link: "https://mikanani.me/RSS/Bangumi?bangumiId=0"}
+	newTorrent := func(ep string) *model.Torrent {
+		return &model.Torrent{
+			Name:     "[LoliHouse] 不存在的番剧 / Nonexistent Anime - " + ep + " [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+			Link:     "magnet:?xt=urn:btih:NONEXISTENT" + ep,
+			Homepage: server.URL + "/Home/Episode/nonexistent" + ep,
+		}
+	}
+
+	// 三次刷新, 每次都是同一个番剧的新集数
+	for i, ep := range []string{"01", "01", "02"} {
+		_, err := r.createBangumi(ctx, newTorrent(ep), rssItem, true)
+		if err == nil {
+			t.Fatalf("第 %d 次刷新: 期望解析失败", i+1)
+		}
+		if i > 0 && !errors.Is(err, ErrResolveBackoff) {
+			t.Fatalf("第 %d 次刷新: 期望 ErrResolveBackoff, 实际 %v", i+1, err)
+		}
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("三次刷新请求了 %d 次, 期望 1 次", got)
+	}
+
+	key := resolveKey(newTorrent("01"))
+	attempt, err := db.GetResolveAttempt(ctx, key)
+	if err != nil {
+		t.Fatalf("GetResolveAttempt 失败: %v", err)
+	}
+	if attempt.Attempts != 1 {
+		t.Errorf("Attempts = %d, 期望 1", attempt.Attempts)
+	}
+
+	// 退避时间到了之后会重试, 再次失败时退避时间翻倍
+	attempt.NextRetry = time.Now().Add(-time.Minute)
+	if err := db.SaveResolveAttempt(ctx, attempt); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	if _, err := r.createBangumi(ctx, newTorrent("03"), rssItem, true); err == nil || errors.Is(err, ErrResolveBackoff) {
+		t.Fatalf("期望重试后解析失败, 实际 %v", err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Fatalf("重试后请求了 %d 次, 期望 2 次", got)
+	}
+	attempt, err = db.GetResolveAttempt(ctx, key)
+	if err != nil {
+		t.Fatalf("GetResolveAttempt 失败: %v", err)
+	}
+	if attempt.Attempts != 2 {
+		t.Errorf("Attempts = %d, 期望 2", attempt.Attempts)
+	}
+	if attempt.NextRetry.Before(before.Add(resolveBackoff(2))) {
+		t.Errorf("NextRetry = %v, 期望至少 %v 之后", attempt.NextRetry, resolveBackoff(2))
+	}
+}
+
+// TestCreateBangumi_InProgress 同一个标题正在创建时直接跳过
+func TestCreateBangumi_InProgress(t *testing.T) {
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r := New(db)
+	torrent := &model.Torrent{
+		Name: "[LoliHouse] 不存在的番剧 / Nonexistent Anime - 01 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		Link: "magnet:?xt=urn:btih:INPROGRESS",
+	}
+	r.creating.Store(resolveKey(torrent), struct{}{})
+	if _, err := r.createBangumi(context.Background(), torrent, &model.RSSItem{}, true); !errors.Is(err, ErrResolveInProgress) {
+		t.Fatalf("期望 ErrResolveInProgress, 实际 %v", err)
+	}
+}
+
+// TestFindNewBangumi_ResolveConcurrency 同时解析的标题数量不超过上限, 同一个标题的种子不会同时解析
+func TestFindNewBangumi_ResolveConcurrency(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	// mikan 页面一直返回 404, 记录同时处理的请求数
+	var hits, inflight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=0&resolve=1"
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><rss version="2.0"><channel>`)
+	// 6 个不同的标题, 第一个标题有两集
+	for i, ep := range []string{"01", "01", "01", "01", "01", "01", "02"} {
+		title := i % 6
+		fmt.Fprintf(&b, `<item><title>[LoliHouse] 不存在的番剧%d / Nonexistent Anime %d - %s [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]</title><link>%s/Home/Episode/%d%s</link><enclosure type="application/x-bittorrent" length="1" url="magnet:?xt=urn:btih:RESOLVE%02d" /></item>`,
+			title, title, ep, server.URL, title, ep, i)
+	}
+	b.WriteString(`</channel></rss>`)
+	network.SetTestCache(rssURL, []byte(b.String()))
+	defer network.ClearTestCache(rssURL)
+
+	r := New(db)
+	r.SetResolveConcurrency(2)
+	err := r.FindNewBangumi(ctx, &model.RSSItem{Name: "resolve", Link: rssURL})
+	if err == nil {
+		t.Fatal("期望解析失败")
+	}
+	if got := peak.Load(); got > 2 {
+		t.Errorf("同时请求了 %d 个, 期望最多 2 个", got)
+	}
+	// 第一个标题的第二集在第一集失败后处于退避中, 不再请求
+	if got := hits.Load(); got != 6 {
+		t.Errorf("请求了 %d 次, 期望 6 次", got)
+	}
+	// 创建失败的种子都记录为待匹配, 带上失败原因
+	parked, err := db.ListUnmatchedTorrents(ctx, 0)
+	if err != nil || len(parked) != 7 {
+		t.Fatalf("ListUnmatchedTorrents() = %d, %v, 期望 7 个", len(parked), err)
+	}
+	for _, p := range parked {
+		if p.ResolveError == "" || p.RSSLink != rssURL || p.ParsedTitle == "" {
+			t.Errorf("待匹配种子 = %+v, 期望带上创建失败原因和解析信息", p)
+		}
+	}
+}
+
+// TestFindNewBangumi_ResolveOnce 同一个标题的第一个种子解析成功后, 其余种子不再解析
+func TestFindNewBangumi_ResolveOnce(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	// 每个标题对应一个 mikan 页面, 记录每个标题请求的次数
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	pages := map[string][]byte{"a": mikan3391HTML, "b": mikan3774HTML}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		title, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/Home/Episode/"), "-")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		hits[title]++
+		mu.Unlock()
+		_, _ = w.Write(pages[title])
+	}))
+	defer server.Close()
+
+	rssURL := "https://mikanani.me/RSS/Bangumi?bangumiId=0&resolve=2"
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><rss version="2.0"><channel>`)
+	for i, ep := range []string{"01", "02", "03"} {
+		fmt.Fprintf(&b, `<item><title>[LoliHouse] Make Heroine ga Oosugiru! - %s [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]</title><link>%s/Home/Episode/a-%s</link><enclosure type="application/x-bittorrent" length="1" url="magnet:?xt=urn:btih:ONCEA%02d" /></item>`,
+			ep, server.URL, ep, i)
+		fmt.Fprintf(&b, `<item><title>[LoliHouse] Chitose-kun wa Ramune Bin no Naka - %s [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]</title><link>%s/Home/Episode/b-%s</link><enclosure type="application/x-bittorrent" length="1" url="magnet:?xt=urn:btih:ONCEB%02d" /></item>`,
+			ep, server.URL, ep, i)
+	}
+	b.WriteString(`</channel></rss>`)
+	network.SetTestCache(rssURL, []byte(b.String()))
+	defer network.ClearTestCache(rssURL)
+
+	r := New(db)
+	var discovered []string
+	r.notify = func(_ context.Context, event notification.NotifyEvent) {
+		if event.Type == notification.EventBangumiDiscovered {
+			mu.Lock()
+			discovered = append(discovered, event.TorrentName)
+			mu.Unlock()
+		}
+	}
+	if err := r.FindNewBangumi(ctx, &model.RSSItem{Name: "resolve", Link: rssURL, Parse: ParserMikan}); err != nil {
+		t.Fatalf("FindNewBangumi() error = %v", err)
+	}
+	if hits["a"] != 1 || hits["b"] != 1 {
+		t.Errorf("mikan 请求次数 = %v, 期望每个标题 1 次", hits)
+	}
+	if len(discovered) != 2 {
+		t.Errorf("发现番剧的通知 = %v, 期望每个标题 1 条", discovered)
+	}
+}
+
+func TestGroupByResolveKey(t *testing.T) {
+	name := func(title, ep string) *model.Torrent {
+		return &model.Torrent{Name: "[LoliHouse] " + title + " - " + ep + " [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"}
+	}
+	torrents := []*model.Torrent{
+		name("Make Heroine ga Oosugiru!", "01"),
+		name("Kusuriya no Hitorigoto", "01"),
+		name("Make Heroine ga Oosugiru!", "02"),
+	}
+	groups := groupByResolveKey(torrents)
+	if len(groups) != 2 || len(groups[0]) != 2 || groups[0][1] != torrents[2] || groups[1][0] != torrents[1] {
+		t.Errorf("groupByResolveKey() = %v, want [[0 2] [1]]", groups)
+	}
+}
+
+func TestResolveBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Minute},
+		{2, time.Hour},
+		{3, 2 * time.Hour},
+		{10, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := resolveBackoff(tt.attempts); got != tt.want {
+			t.Errorf("resolveBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package refresh
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/parser"
+)
+
+// TorrentRemover 从添加种子的下载器中删除种子, 由 download.ClientRegistry 实现
+type TorrentRemover interface {
+	DeleteTorrents(ctx context.Context, torrents []*model.Torrent) error
+}
+
+// SetRemover 设置后, 被修正版替代的旧种子会同时从下载器中删除
+func (r *Refresher) SetRemover(remover TorrentRemover) {
+	r.remover = remover
+}
+
+// revisionKey 同一番剧下用来判断是否为同一集的标识
+type revisionKey struct {
+	season      int
+	episode     int
+	group       string
+	episodeType model.EpisodeType
+}
+
+func parseRevision(name string) (revisionKey, int, bool) {
+	meta := parser.NewTitleMetaParse().Parse(name)
+	if meta == nil || meta.Episode < 0 || meta.Collection {
+		return revisionKey{}, 0, false
+	}
+	episodeType := meta.EpisodeType
+	if episodeType == "" {
+		episodeType = model.EpisodeRegular
+	}
+	version := max(meta.Version, 1)
+	return revisionKey{season: meta.Season, episode: meta.Episode, group: meta.Group, episodeType: episodeType}, version, true
+}
+
+// checkRevision 处理字幕组重新发布的修正版 (v2, 修正版, REPACK), 种子的版本记录在 Torrent.Version
+// 同一番剧同一字幕组同一集已有更高版本时返回 false, 新种子不需要下载;
+// 新种子版本更高时, 把旧版本标记为已替代, 配置了 remover 时从下载器删除
+// pending 是本次刷新中还没有写入数据库的种子, 被替代时只修改内存中的状态
+func (r *Refresher) checkRevision(ctx context.Context, torrent *model.Torrent, bangumi *model.Bangumi, pending []*model.Torrent) bool {
+	if bangumi == nil || bangumi.ID == 0 {
+		return true
+	}
+	key, version, ok := parseRevision(torrent.Name)
+	if !ok {
+		return true
+	}
+	torrent.Version = version
+	existing, err := r.db.ListTorrentByBangumiID(ctx, bangumi.ID)
+	if err != nil {
+		slog.Warn("[checkRevision]获取番剧种子失败", "番剧", bangumi.OfficialTitle, "error", err)
+		return true
+	}
+	existing = append(existing, pendingOf(pending, bangumi)...)
+
+	var replaced []*model.Torrent
+	for _, old := range existing {
+		if old.Link == torrent.Link || old.Downloaded == model.DownloadReplaced {
+			continue
+		}
+		oldKey, oldVersion, ok := parseRevision(old.Name)
+		if !ok || oldKey != key {
+			continue
+		}
+		if oldVersion > version {
+			slog.Info("[checkRevision]已有更新的版本, 跳过", "种子名称", torrent.Name, "已有", old.Name)
+			return false
+		}
+		if oldVersion < version {
+			replaced = append(replaced, old)
+		}
+	}
+
+	for _, old := range replaced {
+		slog.Info("[checkRevision]发现修正版, 替换旧版本", "旧种子", old.Name, "新种子", torrent.Name)
+		r.replaceTorrent(ctx, old, pending)
+	}
+	return true
+}
+
+// replaceTorrent 把旧种子标记为已替代, 配置了 remover 时从下载器删除
+// pending 中还没有写入数据库的种子只修改内存中的状态
+func (r *Refresher) replaceTorrent(ctx context.Context, old *model.Torrent, pending []*model.Torrent) {
+	if slices.Contains(pending, old) {
+		old.Downloaded = model.DownloadReplaced
+		return
+	}
+	if err := r.db.MarkTorrentReplaced(ctx, old.Link); err != nil {
+		slog.Error("[refresh]标记旧种子已替代失败", "种子名称", old.Name, "error", err)
+		return
+	}
+	if r.remover != nil && old.DownloadUID != "" {
+		if err := r.remover.DeleteTorrents(ctx, []*model.Torrent{old}); err != nil {
+			slog.Error("[refresh]从下载器删除旧种子失败", "种子名称", old.Name, "error", err)
+		}
+	}
+}
+
+// pendingOf 本次刷新中属于该番剧的待写入种子
+func pendingOf(pending []*model.Torrent, bangumi *model.Bangumi) []*model.Torrent {
+	var result []*model.Torrent
+	for _, t := range pending {
+		if t.Bangumi != nil && t.Bangumi.ID == bangumi.ID {
+			result = append(result, t)
+		}
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+package refresh
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+type fakeRemover struct {
+	deleted []string
+}
+
+func (f *fakeRemover) DeleteTorrents(_ context.Context, torrents []*model.Torrent) error {
+	for _, t := range torrents {
+		f.deleted = append(f.deleted, t.DownloadUID)
+	}
+	return nil
+}
+
+// TestCheckRevision 第 5 集之后出现第 5 集 v2, 旧版本被替代, 之后再出现的 v1 不再下载
+func TestCheckRevision(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	memoryDB := ":memory:"
+	db, err := database.NewDB(&memoryDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	remover := &fakeRemover{}
+	r := New(db)
+	r.SetRemover(remover)
+
+	ep05 := &model.Torrent{
+		Name:        "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		Link:        "magnet:?xt=urn:btih:MAKEINE05",
+		DownloadUID: "makeine05",
+		Downloaded:  model.DownloadDone,
+		BangumiID:   bangumi.ID,
+	}
+	ep06 := &model.Torrent{
+		Name:        "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 06 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		Link:        "magnet:?xt=urn:btih:MAKEINE06",
+		DownloadUID: "makeine06",
+		Downloaded:  model.DownloadDone,
+		BangumiID:   bangumi.ID,
+	}
+	other := &model.Torrent{
+		Name:       "[喵萌奶茶屋&LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [WebRip 1080p HEVC-10bit AAC][简繁日内封字幕]",
+		Link:       "magnet:?xt=urn:btih:MAKEINE05MIAO",
+		Downloaded: model.DownloadDone,
+		BangumiID:  bangumi.ID,
+	}
+	for _, torrent := range []*model.Torrent{ep05, ep06, other} {
+		if err := db.CreateTorrent(ctx, torrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第 5 集已经重命名
+	if err := db.SetEpisodeFile(ctx, bangumi.ID, 1, 5, ep05.Link, "败犬女主太多了！ S01E05.mkv"); err != nil {
+		t.Fatal(err)
+	}
+
+	v2 := &model.Torrent{
+		Name:      "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05v2 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		Link:      "magnet:?xt=urn:btih:MAKEINE05V2",
+		BangumiID: bangumi.ID,
+	}
+	if !r.checkRevision(ctx, v2, bangumi, nil) {
+		t.Fatal("v2 应该入队下载")
+	}
+	if err := db.CreateTorrent(ctx, v2); err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatus := map[string]model.DownloadStatus{
+		ep05.Link:  model.DownloadReplaced,
+		ep06.Link:  model.DownloadDone,
+		other.Link: model.DownloadDone,
+	}
+	for link, want := range wantStatus {
+		got, err := db.GetTorrentByURL(ctx, link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Downloaded != want {
+			t.Errorf("%s: Downloaded = %d, want %d", got.Name, got.Downloaded, want)
+		}
+	}
+	if !slices.Equal(remover.deleted, []string{"makeine05"}) {
+		t.Errorf("deleted = %v, want [makeine05]", remover.deleted)
+	}
+	if got, _ := db.GetTorrentByURL(ctx, v2.Link); got.Version != 2 {
+		t.Errorf("v2 Version = %d, want 2", got.Version)
+	}
+	// 剧集记录改由 v2 提供, 不会多出一集
+	r.markQueued(ctx, v2, bangumi, "test")
+	episodes, err := db.ListEpisodes(ctx, bangumi.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 1 || episodes[0].State != model.EpisodeDownloading || episodes[0].TorrentLink != v2.Link {
+		t.Errorf("episodes = %+v, want episode 5 downloading from v2", episodes)
+	}
+
+	// 旧版本晚于修正版出现时不再下载
+	late := &model.Torrent{
+		Name:      ep05.Name,
+		Link:      "magnet:?xt=urn:btih:MAKEINE05MIRROR",
+		BangumiID: bangumi.ID,
+	}
+	if r.checkRevision(ctx, late, bangumi, nil) {
+		t.Error("已有 v2 时 v1 不应该入队")
+	}
+
+	// 修正版标记没有版本号时按 v2 处理, 与已有的 v2 相同, 不替换也不跳过
+	revised := &model.Torrent{
+		Name:      "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 05 [修正版][WebRip 1080p HEVC-10bit AAC][简繁内封字幕]",
+		Link:      "magnet:?xt=urn:btih:MAKEINE05FIX",
+		BangumiID: bangumi.ID,
+	}
+	if !r.checkRevision(ctx, revised, bangumi, nil) {
+		t.Error("同版本的种子应该入队")
+	}
+	if got, _ := db.GetTorrentByURL(ctx, v2.Link); got.Downloaded == model.DownloadReplaced {
+		t.Error("同版本不应该替换已有的 v2")
+	}
+}
@@ -0,0 +1,471 @@
+package refresh
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/eventbus"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/notification"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// ============ RSS 调度 ============
+
+// SchedulerOptions RSS 调度器的参数
+type SchedulerOptions struct {
+	// Interval 没有单独设置间隔的订阅使用的刷新间隔
+	Interval time.Duration
+	// Jitter 下一次刷新时间随机偏移的比例(0~1), 为 0 时严格按间隔刷新
+	Jitter float64
+	// MaxConcurrency 同时刷新的订阅数量上限, 不大于 0 时为 1
+	MaxConcurrency int
+	// Tick 检查到期订阅的间隔, 为 0 时为一分钟和 Interval 中较小的那个
+	Tick time.Duration
+	// NotifyFailures 订阅连续失败到这个次数时发送一次通知, 不大于 0 时不通知
+	NotifyFailures int
+	// Backfill 创建番剧后补全之前播出的集数, 见 Refresher.Backfill
+	Backfill bool
+}
+
+// Scheduler 按每个订阅自己的间隔刷新 RSS, 刷新时间带随机抖动, 同时刷新的订阅数量有上限
+// 下一次刷新的时间记录在 RSSItem.NextRunAt, 重启后继续按计划刷新
+// 刷新失败的订阅按连续失败次数退避, 见 RSSItem.RetryInterval
+type Scheduler struct {
+	db        *database.DB
+	refresher *Refresher
+	runner    *taskrunner.TaskRunner
+	opts      SchedulerOptions
+	// fetch 刷新一个订阅, 把新种子数量等写入 result, force 为 true 时订阅没有变化也处理, 测试时替换
+	fetch func(ctx context.Context, rss *model.RSSItem, force bool, result *FeedResult) error
+	// notify 发送订阅连续失败的通知, 测试时替换
+	notify func(ctx context.Context, event notification.NotifyEvent)
+
+	mu      sync.Mutex
+	running map[uint]bool // 正在刷新的订阅, 调度和手动刷新共用, 同一个订阅不会同时刷新
+	cancel  context.CancelFunc
+	// wg 调度的后台协程和所有刷新(包括手动刷新), Stop 等待它们结束
+	wg  sync.WaitGroup
+	sem chan struct{}
+	// stop Stop 时关闭, 不再调度新的刷新; stopping 之后手动刷新也不再开始
+	stop     chan struct{}
+	stopping bool
+	// abort Stop 等待超时后取消, 中断还在进行的手动刷新
+	abort       context.Context
+	abortCancel context.CancelFunc
+	reload      chan struct{}
+	// lastCompleted 上一次检查番剧是否完结的时间, 每个默认间隔检查一次
+	lastCompleted time.Time
+}
+
+// NewScheduler 创建 RSS 调度器
+func NewScheduler(db *database.DB, refresher *Refresher, runner *taskrunner.TaskRunner, opts SchedulerOptions) *Scheduler {
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = 1
+	}
+	opts.Jitter = min(max(opts.Jitter, 0), 1)
+	if opts.Tick <= 0 {
+		opts.Tick = time.Minute
+		if opts.Interval > 0 {
+			opts.Tick = min(opts.Tick, opts.Interval)
+		}
+	}
+	s := &Scheduler{
+		db:        db,
+		refresher: refresher,
+		runner:    runner,
+		opts:      opts,
+		running:   map[uint]bool{},
+		sem:       make(chan struct{}, opts.MaxConcurrency),
+		stop:      make(chan struct{}),
+		reload:    make(chan struct{}, 1),
+	}
+	s.abort, s.abortCancel = context.WithCancel(context.Background())
+	s.fetch = s.refreshRSS
+	s.notify = notification.NotificationClient.Notify
+	return s
+}
+
+// Start 在后台开始调度, 已经启动或已经停止时不做任何事
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil || s.stopping {
+		return
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(3)
+	go s.loop(ctx)
+	go s.watchRSS(ctx)
+	go s.watchBangumi(ctx)
+	slog.Info("[rss scheduler] 启动 RSS 调度", "默认间隔", s.opts.Interval, "并发", s.opts.MaxConcurrency)
+}
+
+// Stop 停止调度新的刷新, 拒绝新的手动刷新, 并等待正在进行的刷新(包括手动刷新)结束
+// 刷新不会被中断, 只有 ctx 结束时才取消它们并返回 ctx 的错误, 此时可能仍有刷新在运行, 不能关闭数据库
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopping = true
+	close(s.stop)
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if cancel != nil {
+			cancel()
+		}
+		s.abortCancel()
+		slog.Info("[rss scheduler] RSS 调度已停止")
+		return nil
+	case <-ctx.Done():
+		if cancel != nil {
+			cancel()
+		}
+		s.abortCancel()
+		slog.Warn("[rss scheduler] 等待刷新结束超时, 取消正在进行的刷新", "error", ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// track 把一次手动刷新加入 wg, 已经停止时返回 false
+func (s *Scheduler) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// Reload 立即重新读取订阅列表, 在添加订阅或修改刷新间隔之后调用
+func (s *Scheduler) Reload() {
+	select {
+	case s.reload <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.Tick)
+	defer ticker.Stop()
+	for {
+		s.dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.reload:
+		}
+	}
+}
+
+// watchRSS 添加了新订阅时立即调度, 不用等到下一次检查
+func (s *Scheduler) watchRSS(ctx context.Context) {
+	defer s.wg.Done()
+	added, unsubscribe := eventbus.Subscribe[database.RSSAdded](s.db.Events(), ctx, 4)
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-added:
+			s.Reload()
+		}
+	}
+}
+
+// watchBangumi 创建了新番剧时重试匹配之前匹配不到番剧的种子, 再补全新番剧之前的集数
+// 一次刷新可能连续创建多个番剧, 事件攒在一起只重试一次
+func (s *Scheduler) watchBangumi(ctx context.Context) {
+	defer s.wg.Done()
+	created, unsubscribe := eventbus.Subscribe[database.BangumiCreated](s.db.Events(), ctx, 16)
+	defer unsubscribe()
+	for {
+		var backfill []int
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case e, ok := <-created:
+			if !ok {
+				return
+			}
+			if !e.Bangumi.Backfilled {
+				backfill = append(backfill, e.Bangumi.ID)
+			}
+		}
+	drain:
+		for {
+			select {
+			case e := <-created:
+				if !e.Bangumi.Backfilled {
+					backfill = append(backfill, e.Bangumi.ID)
+				}
+			default:
+				break drain
+			}
+		}
+		if s.refresher == nil {
+			continue
+		}
+		if _, err := s.refresher.RetryPending(ctx, s.runner); err != nil && ctx.Err() == nil {
+			slog.Warn("[rss scheduler] 重试匹配待匹配种子失败", "error", err)
+		}
+		if !s.opts.Backfill {
+			continue
+		}
+		for _, id := range backfill {
+			if _, err := s.refresher.Backfill(ctx, id, s.runner); err != nil && ctx.Err() == nil {
+				slog.Warn("[rss scheduler] 补全番剧之前的集数失败", "番剧 ID", id, "error", err)
+			}
+		}
+	}
+}
+
+// dispatch 为每个到期且没有在刷新的订阅启动一次刷新
+func (s *Scheduler) dispatch(ctx context.Context) {
+	now := time.Now()
+	if s.refresher != nil && now.Sub(s.lastCompleted) >= s.opts.Interval {
+		s.lastCompleted = now
+		// 先标记已完结的番剧, 只关联了已完结番剧的 RSS 不再刷新
+		if _, err := s.refresher.UpdateCompleted(ctx); err != nil {
+			slog.Warn("[rss scheduler] 检查番剧是否完结失败", "error", err)
+		}
+		// 创建时没有补全成功的番剧重试补全
+		if s.opts.Backfill {
+			if _, err := s.refresher.RetryBackfill(ctx, s.runner); err != nil && ctx.Err() == nil {
+				slog.Warn("[rss scheduler] 重试补全番剧之前的集数失败", "error", err)
+			}
+		}
+	}
+	if s.refresher != nil {
+		// 挑选窗口结束的集数入队, 窗口一般比刷新间隔短, 每次检查都处理
+		if _, err := s.refresher.ReleaseHeld(ctx, s.runner); err != nil {
+			slog.Warn("[rss scheduler] 处理挑选窗口中的种子失败", "error", err)
+		}
+	}
+	due, err := s.db.ListDueRSS(ctx, now, s.opts.Interval)
+	if err != nil {
+		slog.Warn("[rss scheduler] 获取到期的 RSS 失败", "error", err)
+		return
+	}
+	for _, rss := range due {
+		if !s.acquire(rss.ID) {
+			continue
+		}
+		s.wg.Add(1)
+		go s.run(ctx, rss)
+	}
+}
+
+// acquire 标记订阅正在刷新, 已经在刷新时返回 false
+func (s *Scheduler) acquire(id uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id] {
+		return false
+	}
+	s.running[id] = true
+	return true
+}
+
+// release 订阅刷新结束
+func (s *Scheduler) release(id uint) {
+	s.mu.Lock()
+	delete(s.running, id)
+	s.mu.Unlock()
+}
+
+// run 刷新一个订阅并安排下一次刷新, 超过并发上限时等待
+// 调用前必须已经 acquire 了这个订阅
+func (s *Scheduler) run(ctx context.Context, rss *model.RSSItem) {
+	defer s.wg.Done()
+	defer s.release(rss.ID)
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		return
+	case <-s.stop:
+		// 停止时还在等待的刷新不再开始
+		return
+	}
+	s.refreshFeed(ctx, rss, false)
+}
+
+// FeedResult 一次刷新订阅的结果
+type FeedResult struct {
+	RSSID     uint   `json:"rss_id"`
+	Name      string `json:"name"`
+	Link      string `json:"link"`
+	Found     int    `json:"found"`     // RSS 中数据库还没有的种子数量
+	Unmatched int    `json:"unmatched"` // 新种子中匹配不到番剧, 记录为待匹配的数量
+	// Skipped 没有刷新或没有解析 RSS 的原因, 例如正在刷新、订阅没有变化
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// refreshFeed 刷新一个订阅, 记录拉取结果, 连续失败时通知, 并安排下一次刷新
+// force 为 true 时不检查订阅是否有变化, 见 refreshRSS
+func (s *Scheduler) refreshFeed(ctx context.Context, rss *model.RSSItem, force bool) FeedResult {
+	result := FeedResult{RSSID: rss.ID, Name: rss.Name, Link: rss.Link}
+	if completed, err := s.db.IsRSSCompleted(ctx, rss.Link); err == nil && completed {
+		slog.Debug("[rss scheduler] RSS 关联的番剧都已完结, 跳过", "名称", rss.Name)
+		result.Skipped = "关联的番剧都已完结"
+	} else {
+		slog.Debug("[rss scheduler] 刷新 RSS 源", "名称", rss.Name, "URL", network.RedactURL(rss.Link))
+		fetchErr := s.fetch(ctx, rss, force, &result)
+		if ctx.Err() != nil {
+			// 停止时中断的刷新不记录结果, 下次启动时重新刷新
+			result.Error = ctx.Err().Error()
+			return result
+		}
+		if err := s.db.RecordRSSFetch(ctx, rss.ID, fetchErr); err != nil {
+			slog.Warn("[rss scheduler] 记录 RSS 拉取结果失败", "名称", rss.Name, "error", err)
+		}
+		if fetchErr == nil {
+			rss.ConsecutiveFailures = 0
+		} else {
+			result.Error = fetchErr.Error()
+			rss.ConsecutiveFailures++
+			slog.Warn("[rss scheduler] 刷新 RSS 失败", "名称", rss.Name, "连续失败", rss.ConsecutiveFailures, "error", fetchErr)
+			// 只在刚好达到阈值时通知一次, 恢复之后再次失败才会重新通知
+			if s.opts.NotifyFailures > 0 && rss.ConsecutiveFailures == s.opts.NotifyFailures {
+				s.notify(ctx, notification.NewFeedFailureEvent(rss, fetchErr))
+			}
+		}
+	}
+
+	next := s.nextRun(rss, time.Now())
+	if err := s.db.ScheduleRSS(ctx, rss.ID, next); err != nil {
+		slog.Warn("[rss scheduler] 设置下一次刷新时间失败", "名称", rss.Name, "error", err)
+	}
+	stats := s.db.QueryCacheStats()
+	slog.Debug("[rss scheduler] RSS 刷新完成", "名称", rss.Name, "下一次", next,
+		"种子缓存命中率", stats.Torrents.HitRate(), "番剧匹配缓存命中率", stats.Candidates.HitRate())
+	return result
+}
+
+// RefreshFeed 立即刷新一个订阅, 不等它的刷新时间, 刷新后重新安排下一次刷新
+// 手动刷新总是重新拉取并处理订阅, 不因为订阅内容没有变化而跳过
+// 订阅正在刷新(调度的或手动的)时不再刷新, 结果的 Skipped 说明原因
+// 只有读取订阅失败时返回错误, 刷新本身的错误在结果中
+func (s *Scheduler) RefreshFeed(ctx context.Context, rssID uint) (*FeedResult, error) {
+	rss, err := s.db.GetRSSByID(ctx, rssID)
+	if err != nil {
+		return nil, err
+	}
+	result := s.refreshNow(ctx, rss)
+	return &result, nil
+}
+
+// RefreshAll 立即刷新所有启用的订阅, 同时刷新的数量受 MaxConcurrency 限制, 按订阅列表的顺序返回结果
+func (s *Scheduler) RefreshAll(ctx context.Context) ([]FeedResult, error) {
+	items, err := s.db.ListActiveRSS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]FeedResult, len(items))
+	var wg sync.WaitGroup
+	for i, rss := range items {
+		wg.Go(func() {
+			results[i] = s.refreshNow(ctx, rss)
+		})
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// refreshNow 手动刷新一个订阅, 不需要调度器已经启动
+// 刷新计入 wg, Stop 会等待它结束; 已经停止时不再刷新
+func (s *Scheduler) refreshNow(ctx context.Context, rss *model.RSSItem) FeedResult {
+	if !s.track() {
+		return FeedResult{RSSID: rss.ID, Name: rss.Name, Link: rss.Link, Skipped: "正在停止"}
+	}
+	defer s.wg.Done()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(s.abort, cancel)()
+	if !s.acquire(rss.ID) {
+		return FeedResult{RSSID: rss.ID, Name: rss.Name, Link: rss.Link, Skipped: "正在刷新"}
+	}
+	defer s.release(rss.ID)
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		return FeedResult{RSSID: rss.ID, Name: rss.Name, Link: rss.Link, Error: ctx.Err().Error()}
+	}
+	return s.refreshFeed(ctx, rss, true)
+}
+
+// refreshRSS 默认的刷新: 订阅没有变化时跳过, 否则先发现新番剧, 再把新种子加入下载
+// 所有新番剧都创建成功、种子都入库之后才保存订阅的校验信息, 失败的部分下次刷新会重试
+// force 为 true 时不做条件请求也不比较内容, 直接拉取并处理, 不保存校验信息
+func (s *Scheduler) refreshRSS(ctx context.Context, rss *model.RSSItem, force bool, result *FeedResult) error {
+	var check *FeedCheck
+	if !force {
+		var err error
+		check, err = s.refresher.CheckFeed(ctx, rss)
+		if err != nil {
+			return err
+		}
+		if !check.Changed {
+			slog.Debug("[rss scheduler] RSS 没有变化, 跳过", "名称", rss.Name)
+			result.Skipped = "RSS 没有变化"
+			return nil
+		}
+	}
+	complete := true
+	if err := s.refresher.FindNewBangumi(ctx, rss); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		complete = false
+		slog.Warn("[rss scheduler] 检查新番剧失败", "名称", rss.Name, "error", err)
+	}
+	found, unmatched, err := s.refresher.refreshRSS(ctx, rss.Link, s.runner)
+	result.Found, result.Unmatched = found, unmatched
+	if err != nil {
+		return err
+	}
+	if !complete || check == nil {
+		return nil
+	}
+	if err := s.refresher.SaveFeedCheck(ctx, check); err != nil {
+		slog.Warn("[rss scheduler] 保存 RSS 校验信息失败", "名称", rss.Name, "error", err)
+	}
+	return nil
+}
+
+// nextRun 下一次刷新的时间, 在订阅退避后的间隔上随机偏移 ±Jitter
+func (s *Scheduler) nextRun(rss *model.RSSItem, now time.Time) time.Time {
+	interval := rss.RetryInterval(s.opts.Interval)
+	if s.opts.Jitter > 0 {
+		offset := (rand.Float64()*2 - 1) * s.opts.Jitter * float64(interval)
+		interval += time.Duration(offset)
+	}
+	return now.Add(interval)
+}
@@ -0,0 +1,301 @@
+package refresh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/notification"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// TestScheduler 到期的订阅被刷新并记录结果和下一次刷新时间, 同时刷新的数量不超过上限
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	var items []*model.RSSItem
+	for _, link := range []string{
+		"https://mikanani.me/RSS/Bangumi?bangumiId=3774",
+		"https://mikanani.me/RSS/Bangumi?bangumiId=3749",
+		"https://mikanani.me/RSS/Bangumi?bangumiId=3676",
+	} {
+		item := &model.RSSItem{Name: link, Link: link, Enabled: true}
+		if err := db.CreateRSS(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	// 还没到期的订阅不刷新
+	later := time.Now().Add(time.Hour)
+	if err := db.ScheduleRSS(ctx, items[2].ID, later); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(db, nil, nil, SchedulerOptions{Interval: time.Hour, Jitter: 0.1, MaxConcurrency: 1, Tick: time.Hour})
+	var (
+		mu      sync.Mutex
+		fetched = map[uint]int{}
+		active  atomic.Int32
+		peak    atomic.Int32
+	)
+	fetchErr := errors.New("connection refused")
+	s.fetch = func(ctx context.Context, rss *model.RSSItem, _ bool, _ *FeedResult) error {
+		n := active.Add(1)
+		defer active.Add(-1)
+		if n > peak.Load() {
+			peak.Store(n)
+		}
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		fetched[rss.ID]++
+		mu.Unlock()
+		if rss.ID == items[1].ID {
+			return fetchErr
+		}
+		return nil
+	}
+
+	s.Start(ctx)
+	// 等两个到期的订阅都安排好下一次刷新之后再停止
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		due, err := db.ListDueRSS(ctx, time.Now(), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("scheduler did not refresh the due rss")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched[items[0].ID] != 1 || fetched[items[1].ID] != 1 || fetched[items[2].ID] != 0 {
+		t.Errorf("fetched = %v, want the two due rss once each", fetched)
+	}
+	if peak.Load() != 1 {
+		t.Errorf("peak concurrency = %d, want 1", peak.Load())
+	}
+
+	ok, _ := db.GetRSSByID(ctx, items[0].ID)
+	if ok.LastStatus != model.RSSStatusOK || ok.NextRunAt == nil {
+		t.Fatalf("refreshed rss = %+v, want ok status and next run", ok)
+	}
+	if d := time.Until(*ok.NextRunAt); d < 50*time.Minute || d > 70*time.Minute {
+		t.Errorf("next run in %v, want one hour ±10%%", d)
+	}
+	failed, _ := db.GetRSSByID(ctx, items[1].ID)
+	if failed.LastStatus != fetchErr.Error() || failed.ConsecutiveFailures != 1 {
+		t.Errorf("failed rss = %+v, want status %q", failed, fetchErr)
+	}
+	pending, _ := db.GetRSSByID(ctx, items[2].ID)
+	if pending.LastFetchedAt != nil || pending.NextRunAt == nil || !pending.NextRunAt.Equal(later) {
+		t.Errorf("pending rss = %+v, want untouched", pending)
+	}
+}
+
+// TestSchedulerBackoff 失败的订阅按连续失败次数退避, 刚达到阈值时通知一次, 成功后恢复原来的间隔
+func TestSchedulerBackoff(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+	link := "https://mikanani.me/RSS/Bangumi?bangumiId=3774"
+	item := &model.RSSItem{Name: "test", Link: link, Enabled: true}
+	if err := db.CreateRSS(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(db, nil, nil, SchedulerOptions{Interval: time.Hour, Tick: time.Hour, NotifyFailures: 2})
+	fetchErr := errors.New("connection refused")
+	var failing atomic.Bool
+	failing.Store(true)
+	s.fetch = func(ctx context.Context, rss *model.RSSItem, _ bool, _ *FeedResult) error {
+		if failing.Load() {
+			return fetchErr
+		}
+		return nil
+	}
+	var events []notification.NotifyEvent
+	s.notify = func(ctx context.Context, event notification.NotifyEvent) {
+		events = append(events, event)
+	}
+
+	// 每次从数据库读出订阅再刷新, 和调度器一样
+	refresh := func() *model.RSSItem {
+		rss, err := db.GetRSSByID(ctx, item.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.wg.Add(1)
+		s.run(ctx, rss)
+		rss, err = db.GetRSSByID(ctx, item.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rss
+	}
+	tests := []struct {
+		failing  bool
+		failures int
+		interval time.Duration
+		notified int
+	}{
+		{true, 1, 2 * time.Hour, 0},
+		{true, 2, 4 * time.Hour, 1},
+		{true, 3, 8 * time.Hour, 1},
+		{false, 0, time.Hour, 1},
+	}
+	for i, tt := range tests {
+		failing.Store(tt.failing)
+		rss := refresh()
+		if rss.ConsecutiveFailures != tt.failures {
+			t.Errorf("run %d: failures = %d, want %d", i, rss.ConsecutiveFailures, tt.failures)
+		}
+		if d := time.Until(*rss.NextRunAt); d < tt.interval-time.Minute || d > tt.interval {
+			t.Errorf("run %d: next run in %v, want %v", i, d, tt.interval)
+		}
+		if len(events) != tt.notified {
+			t.Errorf("run %d: %d notifications, want %d", i, len(events), tt.notified)
+		}
+	}
+	if events[0].Type != notification.EventFeedFailure || events[0].FeedLink != link ||
+		events[0].Failures != 2 || events[0].Error != fetchErr.Error() {
+		t.Errorf("event = %+v, want feed failure for %s", events[0], link)
+	}
+
+	// 退避的间隔不超过上限
+	long := &model.RSSItem{ConsecutiveFailures: 20}
+	if got := long.RetryInterval(time.Hour); got != model.MaxRetryInterval {
+		t.Errorf("RetryInterval() = %v, want %v", got, model.MaxRetryInterval)
+	}
+}
+
+// TestSchedulerRefreshFeed 手动刷新不等刷新时间, 返回刷新结果; 同一个订阅正在刷新时跳过
+func TestSchedulerRefreshFeed(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+	items := []*model.RSSItem{
+		{Name: "a", Link: "https://mikanani.me/RSS/Bangumi?bangumiId=1", Enabled: true},
+		{Name: "b", Link: "https://mikanani.me/RSS/Bangumi?bangumiId=2", Enabled: true},
+	}
+	for _, item := range items {
+		if err := db.CreateRSS(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewScheduler(db, nil, nil, SchedulerOptions{Interval: time.Hour, Tick: time.Hour, MaxConcurrency: 2})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetchErr := errors.New("connection refused")
+	s.fetch = func(ctx context.Context, rss *model.RSSItem, _ bool, result *FeedResult) error {
+		if rss.ID == items[1].ID {
+			return fetchErr
+		}
+		if started != nil {
+			close(started)
+			<-release
+		}
+		result.Found, result.Unmatched = 3, 1
+		return nil
+	}
+
+	done := make(chan *FeedResult)
+	go func() {
+		result, err := s.RefreshFeed(ctx, items[0].ID)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- result
+	}()
+	<-started
+	// 第一次刷新还没有结束
+	busy, err := s.RefreshFeed(ctx, items[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if busy.Skipped == "" || busy.Found != 0 {
+		t.Errorf("RefreshFeed() while running = %+v, want skipped", busy)
+	}
+	started = nil
+	close(release)
+	if got := <-done; got.Found != 3 || got.Unmatched != 1 || got.Skipped != "" || got.Error != "" {
+		t.Errorf("RefreshFeed() = %+v, want 3 found, 1 unmatched", got)
+	}
+	saved, _ := db.GetRSSByID(ctx, items[0].ID)
+	if saved.LastStatus != model.RSSStatusOK || saved.NextRunAt == nil || !saved.NextRunAt.After(time.Now()) {
+		t.Errorf("rss after RefreshFeed = %+v, want fetched and rescheduled", saved)
+	}
+
+	results, err := s.RefreshAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].RSSID != items[0].ID || results[0].Found != 3 ||
+		results[1].RSSID != items[1].ID || results[1].Error != fetchErr.Error() {
+		t.Errorf("RefreshAll() = %+v, want results in order with b failed", results)
+	}
+}
+
+// TestSchedulerRefreshFeedForce 订阅内容没有变化时调度的刷新跳过, 手动刷新仍然处理
+func TestSchedulerRefreshFeedForce(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+
+	name := "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]"
+	link := "https://mikanani.me/RSS/Bangumi?bangumiId=3391&subgroupid=583&force=1"
+	body := collectRSS(name)
+	network.SetTestCache(link, body)
+	defer network.ClearTestCache(link)
+	sum := sha256.Sum256(body)
+	rss := &model.RSSItem{Name: "force", Link: link, Enabled: true}
+	if err := db.CreateRSS(ctx, rss); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetRSSFeedState(ctx, rss.ID, "", "", hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+	bangumi := &model.Bangumi{OfficialTitle: "败犬女主太多了！", Season: 1}
+	if err := db.Save(bangumi).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateBangumiParse(ctx, &model.EpisodeMetadata{Title: "Make Heroine ga Oosugiru!", Group: "LoliHouse", BangumiID: bangumi.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := taskrunner.New(1, 5)
+	runner.Register(model.PhaseAdding, func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		return taskrunner.PhaseResult{}
+	})
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	s := NewScheduler(db, New(db), runner, SchedulerOptions{Interval: time.Hour, Tick: time.Hour})
+	saved, _ := db.GetRSSByID(ctx, rss.ID)
+	if result := s.refreshFeed(ctx, saved, false); result.Skipped == "" || result.Found != 0 {
+		t.Errorf("scheduled refresh = %+v, want skipped as unchanged", result)
+	}
+	result, err := s.RefreshFeed(ctx, rss.ID)
+	if err != nil || result.Skipped != "" || result.Found != 1 {
+		t.Errorf("RefreshFeed() = %+v, %v, want 1 found", result, err)
+	}
+}
@@ -0,0 +1,43 @@
+package refresh
+
+import (
+	"context"
+	"log/slog"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/search"
+)
+
+// SetSearchProviders 设置按关键字搜索种子的站点, 缺集搜索在 RSS 之外也会搜索这些站点; 为空时不搜索
+func (r *Refresher) SetSearchProviders(providers ...search.Provider) {
+	r.searchProviders = providers
+}
+
+// Search 在设置的站点中按关键字搜索种子, 用于手动添加, 选中的结果交给 AddManualTorrent
+func (r *Refresher) Search(ctx context.Context, keyword string) ([]search.Result, error) {
+	return search.Search(ctx, keyword, r.searchProviders)
+}
+
+// searchKeyword 搜索番剧时使用的关键字: 优先用种子标题中的名称, 字幕组发布时多用它, 没有时用官方标题
+func searchKeyword(bangumi *model.Bangumi) string {
+	for _, meta := range bangumi.EpisodeMetadata {
+		if meta.Title != "" {
+			return meta.Title
+		}
+	}
+	return bangumi.OfficialTitle
+}
+
+// searchNewTorrents 在站点中搜索 keyword, 只返回数据库中还没有的种子
+func (r *Refresher) searchNewTorrents(ctx context.Context, provider search.Provider, keyword string) ([]*model.Torrent, error) {
+	results, err := provider.Search(ctx, keyword)
+	if err != nil {
+		return nil, err
+	}
+	torrents := make([]*model.Torrent, 0, len(results))
+	for _, res := range results {
+		torrents = append(torrents, res.Torrent())
+	}
+	slog.Debug("[searchNewTorrents]搜索种子", "站点", provider.Name(), "关键字", keyword, "数量", len(torrents))
+	return r.db.CheckNewTorrents(ctx, torrents)
+}
@@ -0,0 +1,60 @@
+package refresh
+
+import (
+	"context"
+	"log/slog"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// Service 刷新模块对外的服务: 按计划刷新 RSS, 并管理导入等后台任务
+// 关闭数据库之前必须先调用 Stop, 否则正在创建的番剧可能会因为数据库关闭而失败
+type Service struct {
+	*Refresher
+	scheduler *Scheduler
+}
+
+// NewService 创建刷新服务, 刷新出的新种子提交给 runner
+func NewService(db *database.DB, runner *taskrunner.TaskRunner, opts SchedulerOptions) *Service {
+	r := New(db)
+	return &Service{
+		Refresher: r,
+		scheduler: NewScheduler(db, r, runner, opts),
+	}
+}
+
+// Start 开始按计划刷新 RSS
+func (s *Service) Start(ctx context.Context) {
+	s.scheduler.Start(ctx)
+}
+
+// Reload 立即重新读取订阅列表, 见 Scheduler.Reload
+func (s *Service) Reload() {
+	s.scheduler.Reload()
+}
+
+// RefreshFeed 立即刷新一个订阅, 见 Scheduler.RefreshFeed
+func (s *Service) RefreshFeed(ctx context.Context, rssID uint) (*FeedResult, error) {
+	return s.scheduler.RefreshFeed(ctx, rssID)
+}
+
+// RefreshAll 立即刷新所有启用的订阅, 见 Scheduler.RefreshAll
+func (s *Service) RefreshAll(ctx context.Context) ([]FeedResult, error) {
+	return s.scheduler.RefreshAll(ctx)
+}
+
+// Stop 停止调度新的刷新, 等待正在进行的刷新(包括手动刷新)、创建番剧和导入等后台任务结束
+// ctx 结束时取消还在运行的任务并返回 ctx 的错误, 此时可能仍有任务在运行, 调用方不能关闭数据库
+func (s *Service) Stop(ctx context.Context) error {
+	err := s.scheduler.Stop(ctx)
+	if bgErr := s.shutdown(ctx); err == nil {
+		err = bgErr
+	}
+	if err != nil && ctx.Err() != nil {
+		slog.Warn("[refresh] 等待刷新任务结束超时", "error", ctx.Err())
+		return ctx.Err()
+	}
+	slog.Info("[refresh] 刷新服务已停止")
+	return err
+}
@@ -0,0 +1,106 @@
+package refresh
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goto-bangumi/internal/database/dbtest"
+	"goto-bangumi/internal/model"
+)
+
+// TestServiceStop Stop 等待刷新和后台任务结束, 停止后不再开始手动刷新; 超时后取消任务并返回
+func TestServiceStop(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	s := NewService(db, nil, SchedulerOptions{Interval: time.Hour, Tick: time.Hour})
+	s.Start(context.Background())
+
+	var finished, cancelled atomic.Bool
+	started := make(chan struct{})
+	s.goBackground(context.Background(), func(ctx context.Context) error {
+		close(started)
+		// 模拟还要把当前番剧写完, 不应该被取消
+		select {
+		case <-ctx.Done():
+			cancelled.Store(true)
+		case <-time.After(20 * time.Millisecond):
+		}
+		finished.Store(true)
+		return nil
+	})
+	<-started
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if !finished.Load() || cancelled.Load() {
+		t.Errorf("background task finished = %v, cancelled = %v, want finished without cancel", finished.Load(), cancelled.Load())
+	}
+	rss := &model.RSSItem{Name: "after stop", Link: "https://mikanani.me/RSS/Bangumi?bangumiId=3774&stop=1", Enabled: true}
+	if err := db.CreateRSS(context.Background(), rss); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := s.RefreshFeed(context.Background(), rss.ID); err != nil || result.Skipped != "正在停止" {
+		t.Errorf("RefreshFeed() after stop = %+v, %v, want skipped", result, err)
+	}
+
+	// 后台任务不响应取消时, Stop 在 ctx 结束后返回
+	stuck := NewService(db, nil, SchedulerOptions{Interval: time.Hour, Tick: time.Hour})
+	release := make(chan struct{})
+	defer close(release)
+	aborted := make(chan struct{})
+	stuck.goBackground(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		close(aborted)
+		<-release
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := stuck.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Error("Stop() did not cancel the background task after the deadline")
+	}
+}
+
+// TestSchedulerStopWaitsManualRefresh Stop 等待正在进行的手动刷新结束, 不会取消它
+func TestSchedulerStopWaitsManualRefresh(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := dbtest.New(t)
+	rss := &model.RSSItem{Name: "manual", Link: "https://mikanani.me/RSS/Bangumi?bangumiId=3774&manual=1", Enabled: true}
+	if err := db.CreateRSS(ctx, rss); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(db, nil, nil, SchedulerOptions{Interval: time.Hour, Tick: time.Hour})
+	var finished, cancelled atomic.Bool
+	started := make(chan struct{})
+	s.fetch = func(ctx context.Context, _ *model.RSSItem, _ bool, _ *FeedResult) error {
+		close(started)
+		select {
+		case <-ctx.Done():
+			cancelled.Store(true)
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			finished.Store(true)
+			return nil
+		}
+	}
+	go s.RefreshFeed(ctx, rss.ID)
+	<-started
+
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if !finished.Load() || cancelled.Load() {
+		t.Errorf("manual refresh finished = %v, cancelled = %v, want finished without cancel", finished.Load(), cancelled.Load())
+	}
+}
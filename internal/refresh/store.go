@@ -0,0 +1,64 @@
+package refresh
+
+import (
+	"context"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+// Store Refresher 使用的数据库操作, 由 database.DB 实现
+// 测试时可以替换成只实现用到的方法的假实现; 需要在一个事务中完成的写入仍然通过 Transaction 拿到 database.DB
+type Store interface {
+	Transaction(ctx context.Context, fn func(tx *database.DB) error) error
+
+	// 番剧
+	GetBangumiByID(ctx context.Context, id int) (*model.Bangumi, error)
+	GetBangumiWithDetails(ctx context.Context, id uint) (*model.Bangumi, error)
+	GetBangumiParseByTitle(ctx context.Context, torrentName string) (*model.Bangumi, error)
+	GetBangumisByMikanID(ctx context.Context, mikanID int) ([]*model.Bangumi, error)
+	ListBangumiCandidates(ctx context.Context, torrentName string, season int) ([]database.BangumiCandidate, error)
+	ListBangumiWithDetails(ctx context.Context) ([]*model.Bangumi, error)
+	ListIncompleteBangumi(ctx context.Context) ([]*model.Bangumi, error)
+	ListBangumiToBackfill(ctx context.Context) ([]int, error)
+	MatchDeletedBangumi(ctx context.Context, torrentName string) (*model.Bangumi, error)
+	MarkBangumiCompleted(ctx context.Context, id int) error
+	SetBangumiAutoOffset(ctx context.Context, id, offset int, confident bool) (bool, error)
+	SetBangumiBackfilled(ctx context.Context, id int) error
+	SetBangumiPosterPath(ctx context.Context, id int, path string) error
+	SetBangumiTmdb(ctx context.Context, id int, item *model.TmdbItem) error
+	AddBangumiAlias(ctx context.Context, bangumiID int, title string) (*model.BangumiAlias, error)
+	ListEpisodeMetadataByBangumiID(ctx context.Context, bangumiID int) ([]*model.EpisodeMetadata, error)
+	ListSeasons(ctx context.Context, bangumiID int) ([]*model.Season, error)
+	ListEpisodes(ctx context.Context, bangumiID int) ([]*model.Episode, error)
+	TrackEpisodes(ctx context.Context, bangumiID, season int, numbers []int, link string) error
+	RecordEpisodeSearch(ctx context.Context, bangumiID, season, number int) (int, error)
+	GetTmdbItemByID(ctx context.Context, tmdbID int) (*model.TmdbItem, error)
+	CreateTmdbItem(ctx context.Context, item *model.TmdbItem) error
+
+	// 种子
+	CheckNewTorrents(ctx context.Context, torrents []*model.Torrent) ([]*model.Torrent, error)
+	CreateTorrents(ctx context.Context, torrents []*model.Torrent) error
+	GetTorrentByURL(ctx context.Context, url string) (*model.Torrent, error)
+	ListTorrentByBangumiID(ctx context.Context, bangumiID int) ([]*model.Torrent, error)
+	ListHeldTorrents(ctx context.Context) ([]*model.Torrent, error)
+	ReleaseHeldTorrent(ctx context.Context, link string) error
+	MarkTorrentReplaced(ctx context.Context, link string) error
+	RecordDownloadEvent(ctx context.Context, link string, bangumiID int, action model.DownloadAction, message string) error
+
+	// 待匹配种子和解析重试
+	AddPendingTorrents(ctx context.Context, pending []*model.PendingTorrent) error
+	ParkPendingTorrents(ctx context.Context, pending []*model.PendingTorrent) error
+	GetPendingTorrent(ctx context.Context, link string) (*model.PendingTorrent, error)
+	ListPendingTorrents(ctx context.Context, maxAttempts int) ([]*model.PendingTorrent, error)
+	ListUnmatchedTorrents(ctx context.Context, maxAttempts int) ([]*model.PendingTorrent, error)
+	RecordPendingFailure(ctx context.Context, link string, cause error) (int, error)
+	UpdatePendingError(ctx context.Context, link string, cause error) error
+	DismissPendingTorrent(ctx context.Context, link string) error
+	DeletePendingTorrents(ctx context.Context, links []string) error
+	GetResolveAttempt(ctx context.Context, key string) (*model.ResolveAttempt, error)
+	SaveResolveAttempt(ctx context.Context, attempt *model.ResolveAttempt) error
+
+	// 订阅
+	SetRSSFeedState(ctx context.Context, id uint, etag, lastModified, contentHash string) error
+}
@@ -0,0 +1,66 @@
+package refresh
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"goto-bangumi/internal/model"
+)
+
+// fakeStore 只实现用到的方法, 调用其他方法时因为嵌入的 Store 为 nil 而 panic
+type fakeStore struct {
+	Store
+	pending []*model.PendingTorrent
+	known   map[string]bool
+	created []*model.Torrent
+	deleted []string
+}
+
+func (s *fakeStore) ListPendingTorrents(ctx context.Context, maxAttempts int) ([]*model.PendingTorrent, error) {
+	return s.pending, nil
+}
+
+func (s *fakeStore) CheckNewTorrents(ctx context.Context, torrents []*model.Torrent) ([]*model.Torrent, error) {
+	var fresh []*model.Torrent
+	for _, t := range torrents {
+		if !s.known[t.Link] {
+			fresh = append(fresh, t)
+		}
+	}
+	return fresh, nil
+}
+
+func (s *fakeStore) CreateTorrents(ctx context.Context, torrents []*model.Torrent) error {
+	s.created = append(s.created, torrents...)
+	return nil
+}
+
+func (s *fakeStore) DeletePendingTorrents(ctx context.Context, links []string) error {
+	s.deleted = append(s.deleted, links...)
+	return nil
+}
+
+// TestRefresherWithFakeStore Refresher 只依赖 Store, 不需要真实的数据库
+// 已经被其他刷新入库的待匹配种子直接删除记录, 不会再次入队
+func TestRefresherWithFakeStore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	link := "https://mikanani.me/Download/20240802/makeine12.torrent"
+	store := &fakeStore{
+		pending: []*model.PendingTorrent{{Link: link, Name: "[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p]"}},
+		known:   map[string]bool{link: true},
+	}
+
+	r := New(store)
+	matched, err := r.RetryPending(ctx, nil)
+	if err != nil || matched != 0 {
+		t.Fatalf("RetryPending() = %d, %v, want 0, nil", matched, err)
+	}
+	if len(store.created) != 0 {
+		t.Errorf("created %d torrents, want 0", len(store.created))
+	}
+	if !slices.Equal(store.deleted, []string{link}) {
+		t.Errorf("deleted pending = %v, want [%s]", store.deleted, link)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"path/filepath"
+	"sync/atomic"
 
 	"goto-bangumi/internal/database"
 	"goto-bangumi/internal/download"
@@ -26,19 +27,39 @@ import (
 
 var renameConfig = &model.BangumiRenameConfig{}
 
+// renameMethod 重命名方式, 运行时可以修改, 见 SetRenameMethod
+var renameMethod atomic.Value
+
 func Init(cfg *model.BangumiRenameConfig) {
+	if cfg.Template != "" {
+		if err := download.ValidateTemplate(cfg.Template); err != nil {
+			slog.Warn("[rename] 命名模板无效, 将使用默认格式", "template", cfg.Template, "error", err)
+		}
+	}
 	renameConfig = cfg
+	SetRenameMethod(cfg.RenameMethod)
+}
+
+// SetRenameMethod 修改重命名方式, 之后的重命名生效; none 时不重命名
+func SetRenameMethod(method string) {
+	renameMethod.Store(method)
+}
+
+// RenameMethod 当前的重命名方式
+func RenameMethod() string {
+	method, _ := renameMethod.Load().(string)
+	return method
 }
 
 // Renamer 封装重命名相关操作
 type Renamer struct {
-	db         *database.DB
-	downloader *download.DownloadClient
+	db      *database.DB
+	clients *download.ClientRegistry
 }
 
-// New 创建 Renamer 实例
-func New(db *database.DB, dl *download.DownloadClient) *Renamer {
-	return &Renamer{db: db, downloader: dl}
+// New 创建 Renamer 实例, 种子的文件在添加它的下载器中查询和重命名
+func New(db *database.DB, clients *download.ClientRegistry) *Renamer {
+	return &Renamer{db: db, clients: clients}
 }
 
 func (r *Renamer) GetBangumi(ctx context.Context, torrent *model.Torrent) (*model.Bangumi, error) {
@@ -46,6 +67,10 @@ func (r *Renamer) GetBangumi(ctx context.Context, torrent *model.Torrent) (*mode
 }
 
 func (r *Renamer) Rename(ctx context.Context, torrent *model.Torrent, bangumi *model.Bangumi) {
+	if RenameMethod() == "none" {
+		slog.Debug("[rename] 重命名方式为 none, 跳过", "torrent", torrent.Name)
+		return
+	}
 	// 如果 bangumi 为空, 则从 torrent 中获取 bangumi 信息
 	if bangumi == nil {
 		var err error
@@ -54,7 +79,12 @@ func (r *Renamer) Rename(ctx context.Context, torrent *model.Torrent, bangumi *m
 			return
 		}
 	}
-	fileList, err := r.downloader.GetTorrentFiles(ctx, torrent.DownloadUID)
+	dl, err := r.clients.ClientFor(torrent)
+	if err != nil {
+		slog.Error("[rename] 获取下载器失败", "torrent", torrent.Name, "error", err)
+		return
+	}
+	fileList, err := dl.GetTorrentFiles(ctx, torrent.DownloadUID)
 	if err != nil {
 		return
 	}
@@ -70,15 +100,17 @@ func (r *Renamer) Rename(ctx context.Context, torrent *model.Torrent, bangumi *m
 		metaInfo, newPath := GenPath(torrentName, bangumi)
 		if newPath == filePath {
 			slog.Debug("[rename] File path is the same, no need to rename", "path", filePath)
+			r.recordEpisodeFile(ctx, torrent, bangumi, metaInfo, newPath)
 			continue
 		}
 
 		// 也不用想着要加速什么的, 慢慢来就好了, 主要的还是 api 调用的时间
 		// err := rename(ctx, torrent.DownloadUID, filePath, newPath)
-		if err := r.downloader.Rename(ctx, torrent.DownloadUID, filePath, newPath); err != nil {
+		if err := dl.Rename(ctx, torrent.DownloadUID, filePath, newPath); err != nil {
 			slog.Error("[rename] Failed to rename file", "oldpath", filePath, "newpath", newPath, "error", err)
 			return
 		}
+		r.recordEpisodeFile(ctx, torrent, bangumi, metaInfo, newPath)
 
 		// 发送改名成功通知
 		text := fmt.Sprintf("番剧名称：%s\n季度：第%d季\n更新集数：第%d集",
@@ -99,3 +131,13 @@ func (r *Renamer) Rename(ctx context.Context, torrent *model.Torrent, bangumi *m
 	}
 }
 
+// recordEpisodeFile 记录正片视频重命名后的路径, 字幕和特别篇不记录, 没有数据库时跳过
+func (r *Renamer) recordEpisodeFile(ctx context.Context, torrent *model.Torrent, bangumi *model.Bangumi, metaInfo *model.EpisodeMetadata, path string) {
+	if r.db == nil || metaInfo == nil || metaInfo.EpisodeType != model.EpisodeRegular || parser.DetectMediaType(path) != model.MediaVideo {
+		return
+	}
+	number := metaInfo.Episode + bangumi.Offset
+	if err := r.db.SetEpisodeFile(ctx, bangumi.ID, bangumi.Season, number, torrent.Link, path); err != nil {
+		slog.Warn("[rename] Failed to record episode file", "path", path, "error", err)
+	}
+}
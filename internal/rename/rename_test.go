@@ -25,6 +25,13 @@ func setupMockClient() *download.DownloadClient {
 	return dlClient
 }
 
+// clientsOf 把单个下载客户端注册为默认下载器
+func clientsOf(dlClient *download.DownloadClient) *download.ClientRegistry {
+	clients := download.NewClientRegistry("mock")
+	clients.Register("mock", dlClient)
+	return clients
+}
+
 func TestRename_SingleFile(t *testing.T) {
 	// 我推的孩子 Season 2, 单个文件
 	// 种子名: [Dynamis One] [Oshi no Ko] - 26 (ABEMA 1920x1080 AVC AAC MP4) [8DF340A3].mp4
@@ -34,7 +41,7 @@ func TestRename_SingleFile(t *testing.T) {
 	})
 
 	dlClient := setupMockClient()
-	r := New(nil, dlClient)
+	r := New(nil, clientsOf(dlClient))
 
 	torrent := &model.Torrent{
 		DownloadUID: "1317e47882474c771e29ed2271b282fbfb56e7d2",
@@ -72,7 +79,7 @@ func TestRename_SingleFileWithYearAndGroup(t *testing.T) {
 	})
 
 	dlClient := setupMockClient()
-	r := New(nil, dlClient)
+	r := New(nil, clientsOf(dlClient))
 
 	torrent := &model.Torrent{
 		DownloadUID: "1317e47882474c771e29ed2271b282fbfb56e7d2",
@@ -108,7 +115,7 @@ func TestRename_MultipleFiles(t *testing.T) {
 	})
 
 	dlClient := setupMockClient()
-	r := New(nil, dlClient)
+	r := New(nil, clientsOf(dlClient))
 
 	torrent := &model.Torrent{
 		DownloadUID: "e0a951e431269be7b556101447fbdf9d0842d72f",
@@ -172,7 +179,7 @@ func TestRename_WithOffset(t *testing.T) {
 		"[ANi] 转生贵族靠鉴定技能一飞冲天 - 14 [1080P][Baha][WEB-DL][AAC AVC][CHT].mp4",
 	})
 
-	r := New(nil, dlClient)
+	r := New(nil, clientsOf(dlClient))
 	torrent := &model.Torrent{
 		DownloadUID: hash,
 		Name:        "[ANi] 转生贵族靠鉴定技能一飞冲天 - 14 [1080P][Baha][WEB-DL][AAC AVC][CHT].mp4",
@@ -205,7 +212,7 @@ func TestRename_NilBangumi_FallbackToGetBangumi(t *testing.T) {
 	})
 
 	dlClient := setupMockClient()
-	r := New(nil, dlClient)
+	r := New(nil, clientsOf(dlClient))
 
 	torrent := &model.Torrent{
 		DownloadUID: "1317e47882474c771e29ed2271b282fbfb56e7d2",
@@ -253,7 +260,7 @@ func TestRename_SkipSameFilename(t *testing.T) {
 		Completed: 1,
 	}, []string{alreadyRenamed})
 
-	r := New(nil, dlClient)
+	r := New(nil, clientsOf(dlClient))
 	torrent := &model.Torrent{
 		DownloadUID: hash,
 		Name:        alreadyRenamed,
@@ -356,7 +363,7 @@ func TestRename_MixedSubGroups(t *testing.T) {
 				Completed: 1,
 			}, []string{tt.file})
 
-			r := New(nil, dlClient)
+			r := New(nil, clientsOf(dlClient))
 			torrent := &model.Torrent{
 				DownloadUID: tt.hash,
 				Name:        tt.file,
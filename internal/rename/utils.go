@@ -6,13 +6,19 @@ import (
 	"log/slog"
 	"path/filepath"
 
+	"goto-bangumi/internal/download"
 	"goto-bangumi/internal/model"
 	"goto-bangumi/internal/parser"
 )
 
 func (r *Renamer) getBangumi(ctx context.Context, torrent *model.Torrent) (*model.Bangumi, error) {
+	dl, err := r.clients.ClientFor(torrent)
+	if err != nil {
+		slog.Error("[rename] Failed to get downloader", "name", torrent.Name, "error", err)
+		return nil, err
+	}
 	// 从 download 中拿到下载文件的目录信息
-	downloadInfo, err := r.downloader.GetTorrentInfo(ctx, torrent.DownloadUID)
+	downloadInfo, err := dl.GetTorrentInfo(ctx, torrent.DownloadUID)
 	if err != nil {
 		slog.Error("[rename] Failed to get torrent download info", "name", torrent.Name, "error", err)
 		return nil, err
@@ -20,7 +26,7 @@ func (r *Renamer) getBangumi(ctx context.Context, torrent *model.Torrent) (*mode
 	savePath := downloadInfo.SavePath
 	// 从 savePath 提取出 bangumi 的名字和季度 以及 可能存在的年份 组成为 savePath/BangumiName (Year)/Season \d
 	// 首先提取一个相对路径, 拿到最后的 BangumiName (Year)/Season \d, 以 downloader.SavePath 为基准
-	relativePath, err := filepath.Rel(r.downloader.SavePath, savePath)
+	relativePath, err := filepath.Rel(dl.SavePath(), savePath)
 	if err != nil {
 		slog.Error("[rename] Failed to get relative path", "name", torrent.Name, "path", savePath, "error", err)
 		return nil, err
@@ -35,7 +41,7 @@ func (r *Renamer) getBangumi(ctx context.Context, torrent *model.Torrent) (*mode
 	var bangumi *model.Bangumi
 	if r.db != nil {
 		var err error
-		bangumi, err = r.db.GetBangumiByOfficialTitle(pathInfo.BangumiName)
+		bangumi, err = r.db.GetBangumiByOfficialTitle(ctx, pathInfo.BangumiName)
 		if err != nil {
 			slog.Debug("[rename] Failed to get bangumi from database", "name", torrent.Name, "bangumiName", pathInfo.BangumiName, "error", err)
 			// 如果没有找到的话,就新建一个 bangumi
@@ -62,12 +68,21 @@ func GenPath(torrentName string, bangumi *model.Bangumi) (*model.EpisodeMetadata
 		return nil, ""
 	}
 
-	// offset, 默认是0
-	episode += bangumi.Offset
-
 	// 获取文件扩展名
 	ext := filepath.Ext(torrentName)
 
+	// 配置了命名模板时按模板生成
+	if renameConfig.Template != "" {
+		newPath, err := download.RenderTemplate(renameConfig.Template, download.NewTemplateData(bangumi, metaInfo, ext))
+		if err == nil {
+			return metaInfo, newPath
+		}
+		slog.Error("[rename] Failed to render template, fallback to default", "template", renameConfig.Template, "error", err)
+	}
+
+	// offset, 默认是0
+	episode += bangumi.Offset
+
 	// 构建基本路径: OfficialTitle
 	newPath := bangumi.OfficialTitle
 
@@ -148,6 +148,19 @@ func TestGenPath(t *testing.T) {
 			wantPath:    "我的英雄学院 S07E08.mp4",
 			wantEpisode: 8,
 		},
+		{
+			name:        "命名模板",
+			torrentName: "[ANi] 败犬女主太多了！ - 02 [1080p][Baha][WEB-DL][AAC AVC][CHT].mp4",
+			bangumi: &model.Bangumi{
+				OfficialTitle: "败犬女主太多了",
+				Season:        1,
+			},
+			config: &model.BangumiRenameConfig{
+				Template: "{title}/Season {season:02d}/{title} - S{season:02d}E{episode:02d} [{group}]{ext}",
+			},
+			wantPath:    "败犬女主太多了/Season 01/败犬女主太多了 - S01E02 [ANi].mp4",
+			wantEpisode: 2,
+		},
 	}
 
 	for _, tt := range tests {
@@ -264,7 +277,7 @@ func TestGetBangumi(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := New(nil, dlClient)
+			r := New(nil, clientsOf(dlClient))
 			bangumi, err := r.GetBangumi(context.Background(), tt.torrent)
 
 			if (err != nil) != tt.wantErr {
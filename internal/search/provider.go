@@ -0,0 +1,79 @@
+package search
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+)
+
+// 三个站点都提供搜索结果的 RSS, 用 network 中对应来源的订阅适配器解析
+
+// MikanProvider Mikan 的搜索, 域名使用配置的 MikanCustomURL
+type MikanProvider struct{}
+
+func (MikanProvider) Name() string { return network.SourceMikan }
+
+func (p MikanProvider) Search(ctx context.Context, keyword string) ([]Result, error) {
+	return searchFeed(ctx, parser.MikanSearchRSS(keyword), p.Name())
+}
+
+// NyaaProvider Nyaa 的搜索, 只搜索动画分类; Host 为空时为 nyaa.si
+type NyaaProvider struct {
+	Host string
+}
+
+func (NyaaProvider) Name() string { return network.SourceNyaa }
+
+func (p NyaaProvider) Search(ctx context.Context, keyword string) ([]Result, error) {
+	link := baseURL(p.Host, "nyaa.si") + "/?page=rss&c=1_0&f=0&q=" + url.QueryEscape(keyword)
+	return searchFeed(ctx, link, p.Name())
+}
+
+// DMHYProvider 动漫花园的搜索; Host 为空时为 share.dmhy.org
+type DMHYProvider struct {
+	Host string
+}
+
+func (DMHYProvider) Name() string { return network.SourceDMHY }
+
+func (p DMHYProvider) Search(ctx context.Context, keyword string) ([]Result, error) {
+	link := baseURL(p.Host, "share.dmhy.org") + "/topics/rss/rss.xml?keyword=" + url.QueryEscape(keyword)
+	return searchFeed(ctx, link, p.Name())
+}
+
+// baseURL 带协议的站点地址, 末尾没有 /
+func baseURL(host, fallback string) string {
+	if host == "" {
+		host = fallback
+	}
+	host = strings.TrimSuffix(host, "/")
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return host
+}
+
+// searchFeed 拉取搜索结果的 RSS 并转换成 Result
+func searchFeed(ctx context.Context, link, source string) ([]Result, error) {
+	torrents, err := network.GetRequestClient().GetFeedTorrents(ctx, link, source)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(torrents))
+	for _, t := range torrents {
+		results = append(results, Result{
+			Title:    t.Name,
+			Link:     t.Link,
+			Size:     t.Size,
+			Seeders:  t.Seeders,
+			PubDate:  t.PubDate,
+			InfoHash: t.InfoHash,
+			Homepage: t.Homepage,
+			Provider: source,
+		})
+	}
+	return results, nil
+}
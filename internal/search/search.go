@@ -0,0 +1,132 @@
+// Package search 按关键字在 BT 站搜索种子, 用于手动添加种子和缺集搜索
+// 每个站点实现一个 Provider, 结果统一成 Result; Search 同时查询多个站点并合并结果
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"goto-bangumi/internal/model"
+)
+
+// Result 一条搜索结果, 不同站点的字段统一到这里
+type Result struct {
+	Title string `json:"title"`
+	// Link 种子或磁力链接, 可以直接交给 refresh.Refresher.AddManualTorrent
+	Link string `json:"link"`
+	// Size 大小(字节), 站点没有提供时为 0
+	Size int64 `json:"size"`
+	// Seeders 做种人数, 只有 Nyaa 提供, 没有时为 nil
+	Seeders  *int      `json:"seeders,omitempty"`
+	PubDate  time.Time `json:"pub_date"`
+	InfoHash string    `json:"info_hash"`
+	// Homepage 详情页, 只有 Mikan 提供
+	Homepage string `json:"homepage,omitempty"`
+	// Provider 结果来自哪个站点, 见 Provider.Name
+	Provider string `json:"provider"`
+}
+
+// Torrent 转换成种子, 用于后续的匹配和入队
+func (r Result) Torrent() *model.Torrent {
+	return &model.Torrent{
+		Name:     r.Title,
+		Link:     r.Link,
+		Size:     r.Size,
+		Seeders:  r.Seeders,
+		PubDate:  r.PubDate,
+		InfoHash: r.InfoHash,
+		Homepage: r.Homepage,
+	}
+}
+
+// Provider 一个可以按关键字搜索的站点
+type Provider interface {
+	// Name 站点名称, 与 network 的订阅来源一致, 如 mikan/nyaa/dmhy
+	Name() string
+	Search(ctx context.Context, keyword string) ([]Result, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{
+		MikanProvider{}.Name(): MikanProvider{},
+		NyaaProvider{}.Name():  NyaaProvider{},
+		DMHYProvider{}.Name():  DMHYProvider{},
+	}
+)
+
+// Register 注册搜索站点, 同名会覆盖
+func Register(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// Providers 按名称取出搜索站点, 未知的名称会被忽略
+func Providers(names ...string) []Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	result := make([]Provider, 0, len(names))
+	for _, name := range names {
+		p, ok := providers[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			slog.Warn("[Search] 未知的搜索站点, 忽略", "name", name)
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// Search 同时在 providers 中搜索 keyword, 按 providers 的顺序合并结果
+// 同一个种子(info hash 相同, 没有时链接相同)只保留第一个; 部分站点失败时返回其余站点的结果,
+// 全部失败时返回错误
+func Search(ctx context.Context, keyword string, providers []Provider) ([]Result, error) {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" || len(providers) == 0 {
+		return nil, nil
+	}
+	results := make([][]Result, len(providers))
+	errs := make([]error, len(providers))
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		wg.Go(func() {
+			results[i], errs[i] = p.Search(ctx, keyword)
+			if errs[i] != nil {
+				slog.Warn("[Search] 搜索失败", "站点", p.Name(), "关键字", keyword, "error", errs[i])
+			}
+		})
+	}
+	wg.Wait()
+
+	var merged []Result
+	seen := make(map[string]bool)
+	failed := 0
+	for i := range providers {
+		if errs[i] != nil {
+			failed++
+			continue
+		}
+		for _, r := range results[i] {
+			key := r.InfoHash
+			if key == "" {
+				key = r.Link
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, r)
+		}
+	}
+	if failed == len(providers) {
+		return nil, fmt.Errorf("所有站点搜索失败: %w", errors.Join(errs...))
+	}
+	slog.Debug("[Search] 搜索完成", "关键字", keyword, "数量", len(merged), "失败站点", failed)
+	return merged, nil
+}
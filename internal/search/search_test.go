@@ -0,0 +1,138 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"goto-bangumi/internal/network"
+	"goto-bangumi/internal/parser"
+)
+
+const nyaaRSS = `<?xml version="1.0" encoding="utf-8"?>
+<rss xmlns:nyaa="https://nyaa.si/xmlns/nyaa" version="2.0"><channel>
+<item>
+	<title>[SubsPlease] Make Heroine ga Oosugiru! - 12 (1080p) [6A1F52B5].mkv</title>
+	<link>https://nyaa.si/download/1874915.torrent</link>
+	<pubDate>Sat, 28 Sep 2024 16:32:05 -0000</pubDate>
+	<nyaa:seeders>512</nyaa:seeders>
+	<nyaa:infoHash>8e7ef1a3c4d59bde1f5e2d3c2a1b0f9e8d7c6b5a</nyaa:infoHash>
+	<nyaa:size>1.4 GiB</nyaa:size>
+</item>
+</channel></rss>`
+
+const dmhyRSS = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0"><channel>
+<item>
+	<title><![CDATA[[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]]]></title>
+	<link>http://share.dmhy.org/topics/view/680123.html</link>
+	<pubDate>Sun, 29 Sep 2024 01:32:17 +0800</pubDate>
+	<enclosure url="magnet:?xt=urn:btih:1111111111111111111111111111111111111111" length="1" type="application/x-bittorrent"></enclosure>
+</item>
+<item>
+	<title><![CDATA[[SubsPlease] Make Heroine ga Oosugiru! - 12 (1080p) [6A1F52B5].mkv]]></title>
+	<link>http://share.dmhy.org/topics/view/680124.html</link>
+	<pubDate>Sun, 29 Sep 2024 01:35:00 +0800</pubDate>
+	<enclosure url="magnet:?xt=urn:btih:8E7EF1A3C4D59BDE1F5E2D3C2A1B0F9E8D7C6B5A" length="1" type="application/x-bittorrent"></enclosure>
+</item>
+</channel></rss>`
+
+const mikanRSS = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0"><channel>
+<item>
+	<title>[LoliHouse] 败犬女主太多了！ / Make Heroine ga Oosugiru! - 11 [WebRip 1080p HEVC-10bit AAC][简繁内封字幕]</title>
+	<link>https://mikanani.me/Home/Episode/2222222222222222222222222222222222222222</link>
+	<torrent xmlns="https://mikanani.me/0.1/"><contentLength>734003200</contentLength><pubDate>2024-09-22T01:30:02</pubDate></torrent>
+	<enclosure type="application/x-bittorrent" length="734003200" url="https://mikanani.me/Download/20240922/2222222222222222222222222222222222222222.torrent" />
+</item>
+</channel></rss>`
+
+// failingProvider 总是失败的站点
+type failingProvider struct{}
+
+func (failingProvider) Name() string { return "failing" }
+
+func (failingProvider) Search(ctx context.Context, keyword string) ([]Result, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestSearch(t *testing.T) {
+	const keyword = "Make Heroine"
+	urls := map[string]string{
+		"https://nyaa.si/?page=rss&c=1_0&f=0&q=Make+Heroine":             nyaaRSS,
+		"https://share.dmhy.org/topics/rss/rss.xml?keyword=Make+Heroine": dmhyRSS,
+		parser.MikanSearchRSS(keyword):                                   mikanRSS,
+	}
+	for url, data := range urls {
+		network.SetTestCache(url, []byte(data))
+		defer network.ClearTestCache(url)
+	}
+
+	providers := append(Providers("mikan", "nyaa", "dmhy", "unknown"), failingProvider{})
+	if len(providers) != 4 {
+		t.Fatalf("Providers() returned %d providers, want 3 known ones", len(providers)-1)
+	}
+	results, err := Search(context.Background(), keyword, providers)
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	// Nyaa 和 DMHY 都有 SubsPlease 的种子, 只保留 Nyaa 的
+	want := []struct {
+		provider string
+		link     string
+		size     int64
+		seeders  int
+	}{
+		{"mikan", "https://mikanani.me/Download/20240922/2222222222222222222222222222222222222222.torrent", 734003200, -1},
+		{"nyaa", "https://nyaa.si/download/1874915.torrent", 1503238553, 512},
+		{"dmhy", "magnet:?xt=urn:btih:1111111111111111111111111111111111111111", 0, -1},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Search() = %+v, want %d results", results, len(want))
+	}
+	for i, w := range want {
+		got := results[i]
+		seeders := -1
+		if got.Seeders != nil {
+			seeders = *got.Seeders
+		}
+		if got.Provider != w.provider || got.Link != w.link || got.Size != w.size || seeders != w.seeders || got.Title == "" {
+			t.Errorf("result[%d] = %+v, want provider %s link %s size %d seeders %d", i, got, w.provider, w.link, w.size, w.seeders)
+		}
+	}
+	if tt := results[0].Torrent(); tt.Link != want[0].link || tt.Homepage == "" || tt.InfoHash == "" {
+		t.Errorf("Torrent() = %+v, want link, homepage and info hash", tt)
+	}
+
+	// 所有站点都失败时返回错误
+	if _, err := Search(context.Background(), keyword, []Provider{failingProvider{}}); err == nil {
+		t.Error("Search() with only failing providers error = nil, want error")
+	}
+}
+
+func TestTorznabProvider(t *testing.T) {
+	p := TorznabProvider{URL: "http://prowlarr:9696/1/api/"}
+
+	// 搜索链接不带 API Key, 由 network 在请求时填上
+	searchURL := "http://prowlarr:9696/1/api?cat=5070&q=Make+Heroine&t=search"
+	network.SetTestCache(searchURL, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:torznab="http://torznab.com/schemas/2015/feed"><channel>
+<item>
+	<title>[LoliHouse] Make Heroine ga Oosugiru! - 12 [WebRip 1080p HEVC-10bit AAC]</title>
+	<size>734003200</size>
+	<enclosure url="http://prowlarr:9696/1/download?apikey=KEY&amp;link=abc" length="734003200" type="application/x-bittorrent" />
+	<torznab:attr name="seeders" value="87" />
+</item>
+</channel></rss>`))
+	defer network.ClearTestCache(searchURL)
+	results, err := p.Search(context.Background(), "Make Heroine")
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Size != 734003200 || results[0].Seeders == nil || *results[0].Seeders != 87 || results[0].Provider != network.SourceTorznab {
+		t.Errorf("Search() = %+v, want one torznab result with size and seeders", results)
+	}
+	if got, want := results[0].Link, "http://prowlarr:9696/1/download?apikey=&link=abc"; got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package search
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"goto-bangumi/internal/network"
+)
+
+// TorznabAnimeCategory Torznab 标准分类中的 TV/Anime
+const TorznabAnimeCategory = 5070
+
+// TorznabProvider Jackett/Prowlarr 的 Torznab 接口, 让没有原生支持的站点也可以搜索和订阅
+// URL 为索引器的 Torznab 地址, 如 http://prowlarr:9696/1/api 或 Jackett 的 .../indexers/all/results/torznab/api
+// 搜索链接中不带 API Key, 请求时由 network.WithTorznabAuth 填上
+type TorznabProvider struct {
+	URL string
+	// Categories 搜索的分类, 为空时为 TorznabAnimeCategory
+	Categories []int
+}
+
+func (TorznabProvider) Name() string { return network.SourceTorznab }
+
+func (p TorznabProvider) Search(ctx context.Context, keyword string) ([]Result, error) {
+	return searchFeed(ctx, p.query(keyword), p.Name())
+}
+
+// query Torznab 的搜索链接
+func (p TorznabProvider) query(keyword string) string {
+	params := url.Values{}
+	params.Set("t", "search")
+	categories := p.Categories
+	if len(categories) == 0 {
+		categories = []int{TorznabAnimeCategory}
+	}
+	cats := make([]string, 0, len(categories))
+	for _, c := range categories {
+		cats = append(cats, strconv.Itoa(c))
+	}
+	params.Set("cat", strings.Join(cats, ","))
+	params.Set("q", keyword)
+	link := strings.TrimSuffix(p.URL, "/")
+	if strings.Contains(link, "?") {
+		return link + "&" + params.Encode()
+	}
+	return link + "?" + params.Encode()
+}
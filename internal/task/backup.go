@@ -0,0 +1,55 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+// BackupTask 定时快照数据库
+type BackupTask struct {
+	interval time.Duration
+	keep     int
+	dir      string
+	db       *database.DB
+}
+
+// NewBackupTask 创建数据库快照任务, 快照保存在数据目录的 backups 下
+func NewBackupTask(programConfig model.ProgramConfig, db *database.DB) *BackupTask {
+	task := &BackupTask{
+		interval: time.Duration(programConfig.BackupInterval) * time.Hour,
+		keep:     programConfig.BackupKeep,
+		dir:      filepath.Join(database.ResolveDataDir(programConfig.DataDir), "backups"),
+		db:       db,
+	}
+	slog.Debug("[task backup]创建数据库快照任务", "间隔", task.interval, "保留数量", task.keep)
+	return task
+}
+
+// Name 返回任务名称
+func (t *BackupTask) Name() string {
+	return "数据库快照任务"
+}
+
+// Interval 返回执行间隔
+func (t *BackupTask) Interval() time.Duration {
+	return t.interval
+}
+
+// Enable 返回是否启用
+func (t *BackupTask) Enable() bool {
+	return t.interval > 0
+}
+
+// Run 生成一个快照并清理多余的旧快照
+func (t *BackupTask) Run(ctx context.Context) error {
+	if _, err := t.db.Snapshot(ctx, t.dir, t.keep); err != nil {
+		return fmt.Errorf("[backup task] 数据库快照失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/notification"
+	"goto-bangumi/internal/refresh"
+	"goto-bangumi/internal/taskrunner"
+)
+
+// GapSearchTask 定时检测番剧的缺集并重新搜索, 见 refresh.Refresher.SearchGaps
+type GapSearchTask struct {
+	interval    time.Duration
+	notifyAfter int
+	refresher   *refresh.Refresher
+	runner      *taskrunner.TaskRunner
+}
+
+// NewGapSearchTask 创建缺集搜索任务, 间隔为 0 时任务不启用
+// 一集搜索 GapSearchAttempts 次仍然没有找到时发送一次通知, 为 0 时不通知
+func NewGapSearchTask(programConfig model.ProgramConfig, refresher *refresh.Refresher, runner *taskrunner.TaskRunner) *GapSearchTask {
+	task := &GapSearchTask{
+		interval:    time.Duration(programConfig.GapSearchInterval) * time.Hour,
+		notifyAfter: programConfig.GapSearchAttempts,
+		refresher:   refresher,
+		runner:      runner,
+	}
+	slog.Debug("[task gap]创建缺集搜索任务", "间隔", task.interval, "通知次数", task.notifyAfter)
+	return task
+}
+
+// Name 返回任务名称
+func (t *GapSearchTask) Name() string {
+	return "缺集搜索任务"
+}
+
+// Interval 返回执行间隔
+func (t *GapSearchTask) Interval() time.Duration {
+	return t.interval
+}
+
+// Enable 返回是否启用
+func (t *GapSearchTask) Enable() bool {
+	return t.interval > 0
+}
+
+// Run 重新搜索缺失的集数, 达到搜索次数仍然没有找到的通知用户
+func (t *GapSearchTask) Run(ctx context.Context) error {
+	found, missing, err := t.refresher.SearchGaps(ctx, t.runner)
+	for _, gap := range missing {
+		if t.notifyAfter <= 0 || gap.Attempts != t.notifyAfter {
+			continue
+		}
+		notification.NotificationClient.Notify(ctx, notification.NotifyEvent{
+			Type:         notification.EventEpisodeMissing,
+			BangumiTitle: gap.Title,
+			Season:       gap.Season,
+			Episode:      gap.Episode,
+			Failures:     gap.Attempts,
+			Time:         time.Now(),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("[gap task] 搜索缺集失败: %w", err)
+	}
+	slog.Info("[task gap]缺集搜索完成", "找到", found, "未找到", len(missing))
+	return nil
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+// MaintenanceTask 定时维护数据库: 清理已完结番剧的旧种子和过期的下载历史, 然后整理数据库文件
+type MaintenanceTask struct {
+	interval         time.Duration
+	torrentRetention time.Duration
+	torrentKeep      int
+	historyRetention time.Duration
+	db               *database.DB
+}
+
+// NewMaintenanceTask 创建数据库维护任务, 间隔为 0 时任务不启用
+func NewMaintenanceTask(programConfig model.ProgramConfig, db *database.DB) *MaintenanceTask {
+	task := &MaintenanceTask{
+		interval:         time.Duration(programConfig.MaintenanceInterval) * time.Hour,
+		torrentRetention: time.Duration(programConfig.TorrentRetentionDays) * 24 * time.Hour,
+		torrentKeep:      programConfig.TorrentKeep,
+		historyRetention: time.Duration(programConfig.HistoryDays) * 24 * time.Hour,
+		db:               db,
+	}
+	slog.Debug("[task maintenance]创建数据库维护任务", "间隔", task.interval,
+		"种子保留时间", task.torrentRetention, "历史保留时间", task.historyRetention)
+	return task
+}
+
+// Name 返回任务名称
+func (t *MaintenanceTask) Name() string {
+	return "数据库维护任务"
+}
+
+// Interval 返回执行间隔
+func (t *MaintenanceTask) Interval() time.Duration {
+	return t.interval
+}
+
+// Enable 返回是否启用
+func (t *MaintenanceTask) Enable() bool {
+	return t.interval > 0
+}
+
+// Run 清理过期数据后整理数据库, 清理失败时不再整理
+func (t *MaintenanceTask) Run(ctx context.Context) error {
+	if t.torrentRetention > 0 {
+		if _, err := t.db.PruneTorrents(ctx, time.Now().Add(-t.torrentRetention), t.torrentKeep); err != nil {
+			return fmt.Errorf("[maintenance task] 清理旧种子失败: %w", err)
+		}
+	}
+	if t.historyRetention > 0 {
+		n, err := t.db.PruneDownloadEvents(ctx, time.Now().Add(-t.historyRetention))
+		if err != nil {
+			return fmt.Errorf("[maintenance task] 清理下载历史失败: %w", err)
+		}
+		slog.Debug("[maintenance task] 清理下载历史", "数量", n)
+	}
+	if err := t.db.Optimize(ctx); err != nil {
+		return fmt.Errorf("[maintenance task] 整理数据库失败: %w", err)
+	}
+	return nil
+}
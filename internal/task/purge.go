@@ -0,0 +1,50 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"goto-bangumi/internal/database"
+	"goto-bangumi/internal/model"
+)
+
+// TrashPurgeTask 清空回收站中过期的番剧
+type TrashPurgeTask struct {
+	retention time.Duration
+	db        *database.DB
+}
+
+// NewTrashPurgeTask 创建回收站清理任务, 保留天数为 0 时任务不启用
+func NewTrashPurgeTask(programConfig model.ProgramConfig, db *database.DB) *TrashPurgeTask {
+	task := &TrashPurgeTask{
+		retention: time.Duration(programConfig.TrashDays) * 24 * time.Hour,
+		db:        db,
+	}
+	slog.Debug("[task trash]创建回收站清理任务", "保留时间", task.retention)
+	return task
+}
+
+// Name 返回任务名称
+func (t *TrashPurgeTask) Name() string {
+	return "回收站清理任务"
+}
+
+// Interval 返回执行间隔
+func (t *TrashPurgeTask) Interval() time.Duration {
+	return 24 * time.Hour
+}
+
+// Enable 返回是否启用
+func (t *TrashPurgeTask) Enable() bool {
+	return t.retention > 0
+}
+
+// Run 彻底删除超过保留期的番剧
+func (t *TrashPurgeTask) Run(ctx context.Context) error {
+	if _, _, err := t.db.PurgeDeletedBangumi(ctx, time.Now().Add(-t.retention)); err != nil {
+		return fmt.Errorf("[trash task] 清空回收站失败: %w", err)
+	}
+	return nil
+}
@@ -13,9 +13,15 @@ import (
 	"goto-bangumi/internal/taskrunner"
 )
 
-// NewAddHandler 创建添加下载处理器，将种子添加到下载器
-func NewAddHandler(dl *download.DownloadClient) taskrunner.PhaseFunc {
+// NewAddHandler 创建添加下载处理器，按路由规则选择下载器并将种子添加进去
+// 选中的下载器名称记录在 task.Torrent.Downloader 上, 后续阶段回到同一个下载器查询
+func NewAddHandler(clients *download.ClientRegistry) taskrunner.PhaseFunc {
 	return func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		dl, err := clients.Route(task.Torrent, task.Bangumi)
+		if err != nil {
+			slog.Error("[add handler] 选择下载器失败", "torrent", task.Torrent.Name, "error", err)
+			return taskrunner.PhaseResult{Err: err}
+		}
 		savePath := genSavePath(task.Bangumi)
 		guids, err := dl.Add(ctx, task.Torrent.Link, savePath)
 		if err != nil {
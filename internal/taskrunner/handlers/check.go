@@ -14,8 +14,13 @@ import (
 )
 
 // NewCheckHandler 创建检查处理器，验证下载是否成功添加到下载器
-func NewCheckHandler(db *database.DB, dl *download.DownloadClient) taskrunner.PhaseFunc {
+func NewCheckHandler(db *database.DB, clients *download.ClientRegistry) taskrunner.PhaseFunc {
 	return func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
+		dl, err := clients.ClientFor(task.Torrent)
+		if err != nil {
+			slog.Error("[check handler] 获取下载器失败", "torrent", task.Torrent.Name, "error", err)
+			return taskrunner.PhaseResult{Err: err}
+		}
 		for _, guid := range task.Guids {
 			trueID, err := dl.Check(ctx, guid)
 			// GUID 没找到，试下一个
@@ -33,7 +38,7 @@ func NewCheckHandler(db *database.DB, dl *download.DownloadClient) taskrunner.Ph
 			if trueID != "" {
 				task.Torrent.DownloadUID = trueID
 
-				if err := db.AddTorrentDUID(ctx, task.Torrent.Link, trueID); err != nil {
+				if err := db.AddTorrentDUID(ctx, task.Torrent.Link, task.Torrent.Downloader, trueID); err != nil {
 					slog.Error("[check handler] 更新 Torrent DUID 失败", "error", err)
 					return taskrunner.PhaseResult{Err: err}
 				}
@@ -9,11 +9,12 @@ import (
 	"goto-bangumi/internal/database"
 	"goto-bangumi/internal/download"
 	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/notification"
 	"goto-bangumi/internal/taskrunner"
 )
 
 // NewDownloadingHandler 创建下载监控处理器，合并进度检查和 ETA 计算
-func NewDownloadingHandler(db *database.DB, dl *download.DownloadClient) taskrunner.PhaseFunc {
+func NewDownloadingHandler(db *database.DB, clients *download.ClientRegistry) taskrunner.PhaseFunc {
 	return func(ctx context.Context, task *model.Task) taskrunner.PhaseResult {
 		// 检查是否超时（4小时）
 		if time.Since(task.StartTime) > 4*time.Hour {
@@ -26,6 +27,11 @@ func NewDownloadingHandler(db *database.DB, dl *download.DownloadClient) taskrun
 			return taskrunner.PhaseResult{Err: fmt.Errorf("download timeout after 4 hours")}
 		}
 
+		dl, err := clients.ClientFor(task.Torrent)
+		if err != nil {
+			slog.Error("[downloading handler] 获取下载器失败", "torrent", task.Torrent.Name, "error", err)
+			return taskrunner.PhaseResult{Err: err}
+		}
 		// 获取种子信息
 		info, err := dl.GetTorrentInfo(ctx, task.Torrent.DownloadUID)
 		if err != nil {
@@ -48,6 +54,7 @@ func NewDownloadingHandler(db *database.DB, dl *download.DownloadClient) taskrun
 			}
 
 			slog.Info("[downloading handler] 下载完成", "torrent", task.Torrent.Name)
+			notification.NotificationClient.Notify(ctx, notification.NewTorrentEvent(notification.EventDownloadCompleted, task.Torrent, task.Bangumi))
 			return taskrunner.PhaseResult{} // 成功，进入下一阶段
 		}
 
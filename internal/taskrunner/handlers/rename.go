@@ -6,6 +6,7 @@ import (
 
 	"goto-bangumi/internal/database"
 	"goto-bangumi/internal/model"
+	"goto-bangumi/internal/notification"
 	"goto-bangumi/internal/rename"
 	"goto-bangumi/internal/taskrunner"
 )
@@ -26,6 +27,7 @@ func NewRenameHandler(db *database.DB, renamer *rename.Renamer) taskrunner.Phase
 		}
 
 		slog.Info("[rename handler] 重命名完成", "torrent", task.Torrent.Name)
+		notification.NotificationClient.Notify(ctx, notification.NewTorrentEvent(notification.EventRenameDone, task.Torrent, task.Bangumi))
 		return taskrunner.PhaseResult{} // 成功
 	}
 }
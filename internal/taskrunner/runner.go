@@ -32,6 +32,9 @@ type TaskRunner struct {
 	maxDownload    int           // 下载槽位上限
 	slotTimeout    time.Duration // 下载槽位最大持有时间
 
+	// 任务失败时的回调，可为空
+	onFailed func(ctx context.Context, task *model.Task)
+
 	// 控制
 	signal chan struct{} // buffer 1，唤醒 scheduler
 	wg     sync.WaitGroup
@@ -55,6 +58,11 @@ func New(maxConcurrency, maxDownload int) *TaskRunner {
 	}
 }
 
+// OnFailed 设置任务失败时的回调，需要在 Start 之前调用
+func (r *TaskRunner) OnFailed(fn func(ctx context.Context, task *model.Task)) {
+	r.onFailed = fn
+}
+
 // Register 注册阶段处理器
 func (r *TaskRunner) Register(phase model.TaskPhase, handler PhaseFunc) {
 	r.phases = append(r.phases, phaseEntry{
@@ -305,6 +313,9 @@ func (r *TaskRunner) process(ctx context.Context, task *model.Task) {
 			"torrent", task.Torrent.Name,
 			"phase", task.Phase,
 			"error", result.Err)
+		if r.onFailed != nil {
+			r.onFailed(ctx, task)
+		}
 		return
 	}
 
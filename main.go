@@ -2,15 +2,60 @@ package main
 
 import (
 	"context"
+	"flag"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"goto-bangumi/internal/core"
 	"path/filepath"
 )
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "只执行数据库迁移, 完成后退出")
+	maintainOnly := flag.Bool("maintain", false, "只执行一次数据库维护(清理旧数据, VACUUM/ANALYZE), 完成后退出")
+	exportPath := flag.String("export", "", "把番剧库导出为 JSON 文件, 完成后退出")
+	importPath := flag.String("import", "", "从 JSON 文件导入番剧库(替换当前数据), 完成后退出")
+	checkOnly := flag.Bool("check", false, "以只读方式检查数据库连接和完整性, 完成后退出")
+	flag.Parse()
+	if *migrateOnly {
+		if err := core.Migrate(context.Background()); err != nil {
+			slog.Error("数据库迁移失败", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *maintainOnly {
+		if err := core.Maintain(context.Background()); err != nil {
+			slog.Error("数据库维护失败", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *checkOnly {
+		if err := core.CheckDatabase(context.Background()); err != nil {
+			slog.Error("数据库检查失败", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *exportPath != "" {
+		if err := core.ExportLibrary(context.Background(), *exportPath); err != nil {
+			slog.Error("导出番剧库失败", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *importPath != "" {
+		if err := core.ImportLibrary(context.Background(), *importPath); err != nil {
+			slog.Error("导入番剧库失败", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// logDir 和 dbDir 为同一目录
 	logDir := "./data"
 	posterDir := filepath.Join(logDir, "posters")
@@ -32,8 +77,13 @@ func main() {
 		cancel()
 	}()
 	program := core.InitProgram(ctx)
-	program.Start(ctx)
+	// 模块不直接随信号取消, 由 Stop 等正在进行的任务结束后再按顺序停止
+	program.Start(context.Background())
 	<-ctx.Done()
+	// 等正在进行的刷新和下载任务结束后再退出, 最多等 30 秒
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer stopCancel()
+	program.Stop(stopCtx)
 	// 启动 API 服务器（阻塞）
 	// server := api.NewServer()
 	// // 或者指定端口: server := api.NewServerWithPort(8080)